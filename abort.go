@@ -0,0 +1,88 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// An AbortedError reports the receipt of a record of the abort of a stream by
+// Encoder.Abort, which therefore ends incomplete. Reason is the error given
+// for the abort.
+type AbortedError struct {
+	Reason string
+}
+
+func (e AbortedError) Error() string {
+	if e.Reason == "" {
+		return "stream aborted"
+	}
+
+	return fmt.Sprintf("stream aborted: %s", e.Reason)
+}
+
+// Abort stops the Encoder as would Close, but then transmits, as best it can,
+// a record of the abort of the stream, giving the error that caused it,
+// instead of any trailers, so that a truncated stream can be told apart from a
+// complete one. A Decoder that receives the record returns an AbortedError.
+// Abort is meant to be deferred by a producer, to be called should it fail:
+//
+//	defer func() {
+//		if e != nil {
+//			encoder.Abort(e)
+//		}
+//	}()
+//
+// Abort does nothing if the Encoder has been closed or aborted already.
+func (n *Encoder) Abort(cause error) (e error) {
+	defer errorf("could not abort stream", &e)
+
+	var (
+		reason string
+	)
+
+	n.closing.Do(
+		func() { close(n.done) },
+	)
+
+	n.closeAsync()
+
+	n.workers.Wait()
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if n.closed {
+		return
+	}
+
+	defer func() { n.closed = true }()
+
+	if cause != nil {
+		reason = cause.Error()
+	}
+
+	if len(reason) > abortReasonMaxLen {
+		reason = reason[:abortReasonMaxLen]
+	}
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	e = n.writeControl(controlAbort,
+		[]byte(reason),
+	)
+	if e != nil {
+		return
+	}
+
+	if n.options.syncEvery > 0 || n.options.syncInterval > 0 {
+		e = n.sync()
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
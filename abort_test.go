@@ -0,0 +1,67 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbort(t *testing.T) {
+	var (
+		aborted AbortedError
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		length  int
+		stream  ManifestStream
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a(), WithBatchChecksum(4))
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t,
+		encoder.Abort(fmt.Errorf("disk full")),
+	)
+
+	length = buffer.Len()
+
+	assert.NoError(t, encoder.Abort(nil))
+	assert.NoError(t, encoder.Close())
+	assert.Equal(t, length, buffer.Len())
+
+	assert.Error(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	stream, e = DescribeStream("stream", "", bytes.NewReader(buffer.Bytes()),
+		fnv.New32a(),
+	)
+
+	assert.NoError(t, e)
+	assert.True(t, stream.Aborted)
+	assert.Equal(t, int64(1), stream.Records)
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+
+	if assert.True(t, errors.As(e, &aborted)) {
+		assert.Equal(t, "disk full", aborted.Reason)
+	}
+
+	assert.Equal(t, "stream aborted", AbortedError{}.Error())
+
+	return
+}
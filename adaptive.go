@@ -0,0 +1,290 @@
+package bottledlightning
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A CompressionSetting is a codec and, for CompressionDeflate, a level as for
+// [compress/flate], by which an Encoder configured with
+// WithAdaptiveCompression compresses values.
+type CompressionSetting struct {
+	Codec Compression
+	Level int
+}
+
+// adaptiveSettings are the settings among which WithAdaptiveCompression
+// chooses, in order of increasing effort.
+var adaptiveSettings = []CompressionSetting{
+	{Codec: CompressionNone},
+	{Codec: CompressionLZW},
+	{Codec: CompressionDeflate, Level: flate.BestSpeed},
+	{Codec: CompressionDeflate, Level: flate.DefaultCompression},
+	{Codec: CompressionDeflate, Level: flate.BestCompression},
+}
+
+// CompressionSetting returns the compression setting most recently announced
+// by an Encoder configured with WithAdaptiveCompression, or the zero
+// CompressionSetting, that of CompressionNone, if none has been received.
+func (d *Decoder) CompressionSetting() CompressionSetting {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.compressionSetting
+}
+
+// adaptiveCompression chooses the CompressionSetting of an Encoder by
+// comparing the throughput of its link, as measured by the time taken by
+// writes, with the cost and effect of each setting, as measured on the values
+// compressed, so as to transmit values in the least time.
+type adaptiveCompression struct {
+	interval  time.Duration
+	settings  []CompressionSetting
+	mutex     sync.Mutex
+	current   int // index into settings
+	decided   time.Time
+	probes    []int // settings yet to be measured since the decision
+	estimates []compressionEstimate
+	written   int64
+	writing   time.Duration
+}
+
+// A compressionEstimate accumulates the effect and cost of compressing values
+// with a setting.
+type compressionEstimate struct {
+	raw        int64
+	compressed int64
+	elapsed    time.Duration
+}
+
+func newAdaptiveCompression(interval time.Duration, o *options) (
+	a *adaptiveCompression,
+) {
+	// Returns the adaptive compression of an Encoder, choosing among the
+	// codecs that its peer supports if it has negotiated capabilities.
+
+	var (
+		setting CompressionSetting
+	)
+
+	a = &adaptiveCompression{
+		interval: interval,
+		decided:  time.Now(),
+	}
+
+	for _, setting = range adaptiveSettings {
+		if o.negotiated && setting.Codec != CompressionNone &&
+			o.peerCodecs&(1<<setting.Codec) == 0 {
+			continue
+		}
+
+		a.settings = append(a.settings, setting)
+	}
+
+	a.estimates = make([]compressionEstimate, len(a.settings))
+
+	return
+}
+
+func (a *adaptiveCompression) compress(val []byte) (
+	compressed []byte, applied Compression, e error,
+) {
+	// Compresses val with the current setting, first measuring another
+	// setting on it if one is due to be probed.
+
+	var (
+		current int
+		probe   = -1
+	)
+
+	a.mutex.Lock()
+
+	if time.Since(a.decided) >= a.interval {
+		a.decide()
+	}
+
+	current = a.current
+
+	if len(a.probes) > 0 {
+		probe, a.probes = a.probes[0], a.probes[1:]
+	}
+
+	a.mutex.Unlock()
+
+	if probe >= 0 {
+		_, _, e = a.measure(probe, val)
+		if e != nil {
+			return
+		}
+	}
+
+	return a.measure(current, val)
+}
+
+func (a *adaptiveCompression) measure(i int, val []byte) (
+	compressed []byte, applied Compression, e error,
+) {
+	// Compresses val with setting i, adding the outcome to its estimate.
+
+	var (
+		start = time.Now()
+	)
+
+	compressed, applied, e = compressWith(a.settings[i], val)
+	if e != nil {
+		return
+	}
+
+	a.mutex.Lock()
+
+	defer a.mutex.Unlock()
+
+	a.estimates[i].raw += int64(len(val))
+	a.estimates[i].compressed += int64(len(compressed))
+	a.estimates[i].elapsed += time.Since(start)
+
+	return
+}
+
+func (a *adaptiveCompression) setting() CompressionSetting {
+	a.mutex.Lock()
+
+	defer a.mutex.Unlock()
+
+	return a.settings[a.current]
+}
+
+func (a *adaptiveCompression) decide() {
+	// Switches to the setting that would have transmitted the values measured
+	// since the last decision in the least time, each byte compressed costing
+	// its share of the time taken by compression, and each byte transmitted
+	// its share of the time taken by writes, and schedules the measurement
+	// of the others anew. The caller must hold a.mutex.
+
+	var (
+		best     = a.current
+		cost     float64
+		estimate compressionEstimate
+		i        int
+		least    float64
+		perByte  float64 // seconds to transmit a byte
+	)
+
+	defer func() {
+		a.decided = time.Now()
+		a.estimates = make([]compressionEstimate, len(a.settings))
+		a.written, a.writing = 0, 0
+
+		a.probes = a.probes[:0]
+
+		for i = range a.settings {
+			if i != a.current {
+				a.probes = append(a.probes, i)
+			}
+		}
+	}()
+
+	if a.written == 0 || a.writing == 0 {
+		return
+	}
+
+	perByte = a.writing.Seconds() / float64(a.written)
+
+	for i, estimate = range a.estimates {
+		switch {
+		case a.settings[i].Codec == CompressionNone:
+			cost = perByte
+
+		case estimate.raw == 0:
+			continue
+
+		default:
+			cost = (estimate.elapsed.Seconds() +
+				float64(estimate.compressed)*perByte) /
+				float64(estimate.raw)
+		}
+
+		if i == 0 || cost < least {
+			best, least = i, cost
+		}
+	}
+
+	a.current = best
+
+	return
+}
+
+// A meteredWriter measures the throughput of the link of an Encoder
+// configured with WithAdaptiveCompression.
+type meteredWriter struct {
+	writer   io.Writer
+	adaptive *adaptiveCompression
+}
+
+func (w *meteredWriter) Write(b []byte) (n int, e error) {
+	var (
+		start = time.Now()
+	)
+
+	n, e = w.writer.Write(b)
+
+	w.adaptive.mutex.Lock()
+
+	defer w.adaptive.mutex.Unlock()
+
+	w.adaptive.written += int64(n)
+	w.adaptive.writing += time.Since(start)
+
+	return
+}
+
+func (n *Encoder) announceCompression() (e error) {
+	// Transmits the current compression setting of an Encoder configured
+	// with WithAdaptiveCompression, unless already announced, so that the
+	// Decoder can report it. The caller must hold n.mutex.
+
+	var (
+		setting CompressionSetting
+	)
+
+	if n.options.adaptive == nil {
+		return
+	}
+
+	setting = n.options.adaptive.setting()
+
+	if n.settingAnnounced && setting == n.announcedSetting {
+		return
+	}
+
+	e = n.writeControl(controlCompression,
+		[]byte{byte(setting.Codec), byte(int8(setting.Level))},
+	)
+	if e != nil {
+		return
+	}
+
+	n.announcedSetting = setting
+	n.settingAnnounced = true
+
+	return
+}
+
+func (d *Decoder) receiveCompressionSetting(payload []byte) (e error) {
+	// Notes the compression setting announced by the Encoder.
+
+	if len(payload) != 2 {
+		return fmt.Errorf("malformed compression control record")
+	}
+
+	d.compressionSetting = CompressionSetting{
+		Codec: Compression(payload[0]),
+		Level: int(int8(payload[1])),
+	}
+
+	return
+}
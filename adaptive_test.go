@@ -0,0 +1,125 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveCompression(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(
+			&slowWriter{Writer: &buffer, delay: time.Millisecond},
+			nil, WithAdaptiveCompression(time.Millisecond),
+		)
+		i       int
+		setting CompressionSetting
+		val     = bytes.Repeat([]byte("compressible "), 1000)
+		values  int
+	)
+
+	for i = 0; i < 50; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte("key"), val),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.Positive(t,
+		encoder.CompressionStats().Compressed,
+	)
+
+	decoder = NewDecoder(&buffer, nil)
+
+	for {
+		_, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if !assert.NoError(t, e) {
+			return
+		}
+
+		if decoder.CompressionSetting().Codec != CompressionNone {
+			setting = decoder.CompressionSetting()
+		}
+
+		values++
+	}
+
+	assert.Equal(t, 50, values)
+	assert.NotEqual(t, CompressionNone, setting.Codec)
+
+	return
+}
+
+func TestAdaptiveCompressionFastLink(t *testing.T) {
+	var (
+		encoder = NewEncoder(io.Discard, nil,
+			WithAdaptiveCompression(time.Millisecond),
+		)
+		i   int
+		val = bytes.Repeat([]byte("v"), 64)
+	)
+
+	// Compression costs more than it saves on a link that is never slow.
+
+	for i = 0; i < 10; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte("key"), val),
+		)
+	}
+
+	assert.Equal(t, CompressionNone,
+		encoder.options.adaptive.setting().Codec,
+	)
+
+	return
+}
+
+func TestAdaptiveCompressionCapabilities(t *testing.T) {
+	var (
+		encoder = NewEncoder(io.Discard, nil,
+			WithAdaptiveCompression(time.Millisecond),
+			WithCapabilities(
+				Capabilities{
+					Features:     []string{"compression"},
+					Compressions: []Compression{CompressionLZW},
+				},
+			),
+		)
+	)
+
+	assert.Equal(t,
+		[]CompressionSetting{
+			{Codec: CompressionNone},
+			{Codec: CompressionLZW},
+		},
+		encoder.options.adaptive.settings,
+	)
+
+	return
+}
+
+type slowWriter struct {
+	io.Writer
+
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(w.delay)
+
+	return w.Writer.Write(b)
+}
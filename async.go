@@ -0,0 +1,221 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// A QueueFullPolicy determines what EncodeAsync does with a record submitted
+// while the submission queue is full.
+type QueueFullPolicy int
+
+const (
+	// QueueFullBlock waits for room in the queue, holding up the producer.
+	QueueFullBlock QueueFullPolicy = iota
+
+	// QueueFullReject refuses the record at once, calling its completion
+	// callback with a QueueFullError before EncodeAsync returns, so that the
+	// producer can shed load.
+	QueueFullReject
+)
+
+// A QueueFullError reports a record refused by EncodeAsync under
+// QueueFullReject. See WithAsyncQueue.
+type QueueFullError struct{}
+
+func (QueueFullError) Error() string {
+	return "submission queue full"
+}
+
+// AsyncQueueStats describes the occupancy of the submission queue of
+// EncodeAsync. Depth is the number of records queued at present and HighWater
+// the largest number queued at any one time.
+type AsyncQueueStats struct {
+	Capacity  int
+	Depth     int
+	HighWater int
+	Submitted int
+	Rejected  int
+}
+
+// AsyncQueueStats returns the occupancy of the submission queue, and counts
+// of the records submitted to it so far, by whether they were accepted.
+func (n *Encoder) AsyncQueueStats() (stats AsyncQueueStats) {
+	n.asyncMutex.Lock()
+
+	stats.Depth = len(n.asyncQueue)
+
+	n.asyncMutex.Unlock()
+
+	stats.Capacity = n.options.asyncQueueLen
+	stats.HighWater = int(n.asyncHighWater.Load())
+	stats.Submitted = int(n.asyncSubmitted.Load())
+	stats.Rejected = int(n.asyncRejected.Load())
+
+	return
+}
+
+type asyncRecord struct {
+	key  []byte
+	val  []byte
+	xmv  XMetaValue
+	done func(error)
+}
+
+// EncodeAsync submits a key-value record to be transmitted by a background
+// goroutine, and returns without waiting for it to be written, so that a
+// producer is not held up by a slow destination. Records submitted by
+// EncodeAsync are transmitted in the order of submission, and done, unless
+// nil, is called from the background goroutine with the outcome of each once
+// it has been transmitted, also in order. While the submission queue is full,
+// EncodeAsync blocks or refuses the record as WithAsyncQueue configures; done
+// may itself call EncodeAsync, but under QueueFullBlock it then waits for room
+// that only it can make, unless the queue has some to spare. The
+// caller must not modify key or val until done is called. Records submitted
+// by Encode and its other variants are not ordered with respect to those still
+// queued, and Close transmits every queued record before any trailer.
+func (n *Encoder) EncodeAsync(key, val []byte, done func(e error)) {
+	n.encodeAsync(key, val, XMetaValue0, done)
+}
+
+// EncodeAsyncX is a variant of EncodeAsync that transmits extended metadata.
+func (n *Encoder) EncodeAsyncX(key, val []byte, xmv XMetaValue,
+	done func(e error),
+) {
+	n.encodeAsync(key, val, xmv, done)
+}
+
+func (n *Encoder) encodeAsync(key, val []byte, xmv XMetaValue,
+	done func(error),
+) {
+	// Queues a record for the background goroutine, starting it if need be.
+	// n.asyncMutex is not held while waiting for room in the queue, so that
+	// AsyncQueueStats and completion callbacks are not held up by a blocked
+	// producer; instead each sender is counted in n.asyncSenders, and Close
+	// waits for them before closing the queue.
+
+	var (
+		record asyncRecord
+	)
+
+	n.asyncMutex.Lock()
+
+	if n.asyncClosed {
+		n.asyncMutex.Unlock()
+
+		if done != nil {
+			done(
+				fmt.Errorf("could not encode: encoder closed"),
+			)
+		}
+
+		return
+	}
+
+	if n.asyncQueue == nil {
+		n.asyncQueue = make(chan asyncRecord, n.options.asyncQueueLen)
+
+		n.workers.Add(1)
+
+		go n.drainAsync()
+	}
+
+	n.asyncSenders.Add(1)
+
+	defer n.asyncSenders.Done()
+
+	n.asyncMutex.Unlock()
+
+	record = asyncRecord{key: key, val: val, xmv: xmv, done: done}
+
+	switch n.options.queueFullPolicy {
+	case QueueFullReject:
+		select {
+		case n.asyncQueue <- record:
+
+		default:
+			n.asyncRejected.Add(1)
+
+			if done != nil {
+				done(
+					fmt.Errorf("could not encode: %w", QueueFullError{}),
+				)
+			}
+
+			return
+		}
+
+	default:
+		n.asyncQueue <- record
+	}
+
+	n.asyncSubmitted.Add(1)
+
+	n.raiseAsyncHighWater(
+		int64(len(n.asyncQueue)),
+	)
+
+	return
+}
+
+func (n *Encoder) raiseAsyncHighWater(depth int64) {
+	var (
+		high int64
+	)
+
+	for {
+		high = n.asyncHighWater.Load()
+
+		if depth <= high || n.asyncHighWater.CompareAndSwap(high, depth) {
+			return
+		}
+	}
+}
+
+func (n *Encoder) drainAsync() {
+	// Transmits queued records until the queue is closed and empty.
+
+	var (
+		e      error
+		record asyncRecord
+	)
+
+	defer n.workers.Done()
+
+	n.options.labelGoroutine("async")
+
+	for record = range n.asyncQueue {
+		e = n.encode(record.key, record.val, record.xmv)
+
+		if record.done != nil {
+			record.done(e)
+		}
+	}
+
+	return
+}
+
+func (n *Encoder) closeAsync() {
+	// Refuses further submissions and, once every sender already admitted
+	// has queued its record, closes the queue, if any, so that the background
+	// goroutine exits once it has transmitted every queued record.
+
+	n.asyncMutex.Lock()
+
+	if n.asyncClosed {
+		n.asyncMutex.Unlock()
+
+		return
+	}
+
+	n.asyncClosed = true
+
+	n.asyncMutex.Unlock()
+
+	n.asyncSenders.Wait()
+
+	if n.asyncQueue != nil {
+		close(n.asyncQueue)
+	}
+
+	return
+}
@@ -0,0 +1,190 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeAsync(t *testing.T) {
+	const (
+		records = 4 * asyncQueueLen
+	)
+
+	var (
+		buffer    bytes.Buffer
+		completed []int
+		decoder   *Decoder
+		e         error
+		encoder   *Encoder
+		i         int
+		key       []byte
+		xmv       byte
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a())
+
+	for i = 0; i < records; i++ {
+		encoder.EncodeAsyncX(
+			binary.BigEndian.AppendUint32(nil, uint32(i)),
+			[]byte("val"),
+			XMetaValue1,
+			func(i int) func(error) {
+				return func(e error) {
+					assert.NoError(t, e)
+
+					completed = append(completed, i)
+				}
+			}(i),
+		)
+	}
+
+	assert.NoError(t, encoder.Close())
+
+	assert.Len(t, completed, records)
+	assert.IsIncreasing(t, completed)
+
+	encoder.EncodeAsync([]byte("late"), []byte("val"),
+		func(e error) { assert.Error(t, e) },
+	)
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	for i = 0; ; i++ {
+		key, _, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if !assert.NoError(t, e) {
+			break
+		}
+
+		assert.Equal(t, uint32(i), binary.BigEndian.Uint32(key))
+		assert.Equal(t, byte(XMetaValue1), xmv)
+	}
+
+	assert.Equal(t, records, i)
+
+	encoder = NewEncoder(failingWriter{}, nil)
+
+	encoder.EncodeAsync([]byte("key"), []byte("val"),
+		func(e error) { assert.Error(t, e) },
+	)
+
+	encoder.EncodeAsync([]byte("key"), []byte("val"), nil)
+
+	assert.NoError(t, encoder.Close())
+
+	return
+}
+
+func TestAsyncQueue(t *testing.T) {
+	var (
+		e        error
+		encoder  *Encoder
+		gate     = make(chan struct{})
+		rejected int
+		stats    AsyncQueueStats
+	)
+
+	encoder = NewEncoder(&gatedWriter{gate: gate, writer: io.Discard}, nil,
+		WithAsyncQueue(2, QueueFullReject),
+	)
+
+	encoder.EncodeAsync([]byte("key"), []byte("val"), nil)
+
+	assert.Eventually(t,
+		func() bool { return encoder.AsyncQueueStats().Depth == 0 },
+		time.Second, time.Millisecond,
+	)
+
+	for range 4 {
+		encoder.EncodeAsync([]byte("key"), []byte("val"),
+			func(e error) {
+				if errors.Is(e, QueueFullError{}) {
+					rejected++
+				}
+			},
+		)
+	}
+
+	stats = encoder.AsyncQueueStats()
+
+	assert.Equal(t,
+		AsyncQueueStats{
+			Capacity:  2,
+			Depth:     2,
+			HighWater: 2,
+			Submitted: 3,
+			Rejected:  2,
+		},
+		stats,
+	)
+
+	assert.Equal(t, 2, rejected)
+
+	close(gate)
+
+	e = encoder.Close()
+
+	assert.NoError(t, e)
+	assert.Equal(t, 0, encoder.AsyncQueueStats().Depth)
+
+	return
+}
+
+func TestAsyncQueueBlocked(t *testing.T) {
+	// A producer blocked on a full queue must not hold up a completion
+	// callback that reads the queue statistics or submits another record.
+
+	const (
+		records = 8
+	)
+
+	var (
+		completed = make(chan AsyncQueueStats, 2*records)
+		done      = make(chan struct{})
+		encoder   *Encoder
+	)
+
+	encoder = NewEncoder(
+		&slowWriter{Writer: io.Discard, delay: time.Millisecond}, nil,
+		WithAsyncQueue(1, QueueFullBlock),
+	)
+
+	go func() {
+		defer close(done)
+
+		for range records {
+			encoder.EncodeAsync([]byte("key"), []byte("val"),
+				func(e error) {
+					assert.NoError(t, e)
+
+					completed <- encoder.AsyncQueueStats()
+				},
+			)
+		}
+
+		assert.NoError(t, encoder.Close())
+	}()
+
+	select {
+	case <-done:
+
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlocked on a full submission queue")
+	}
+
+	assert.Len(t, completed, records)
+	assert.Equal(t, records, encoder.AsyncQueueStats().Submitted)
+	assert.Equal(t, 1, encoder.AsyncQueueStats().HighWater)
+
+	return
+}
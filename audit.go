@@ -0,0 +1,78 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// An Op is an operation on a record reported to an audit hook. See
+// WithAuditHook.
+type Op int
+
+const (
+	// OpEncode is the transmission of a record by an Encoder.
+	OpEncode Op = iota + 1
+
+	// OpDecode is the receipt of a record by a Decoder.
+	OpDecode
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpEncode:
+		return "encode"
+
+	case OpDecode:
+		return "decode"
+	}
+
+	return fmt.Sprintf("op(%d)", int(op))
+}
+
+// AuditStats counts the records reported to the audit hook of an Encoder or
+// Decoder configured with WithAuditHook, by the decision of the hook.
+type AuditStats struct {
+	Allowed int
+	Vetoed  int
+}
+
+// AuditStats returns counts of the records audited so far, by decision.
+func (n *Encoder) AuditStats() AuditStats {
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	return n.auditStats
+}
+
+// AuditStats returns counts of the records audited so far, by decision.
+func (d *Decoder) AuditStats() AuditStats {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.auditStats
+}
+
+func (o *options) audit(stats *AuditStats, op Op, key []byte,
+	xmv XMetaValue,
+) (
+	e error,
+) {
+	// Reports a record to the audit hook, if so configured, and counts the
+	// decision in stats. The caller must hold the mutex guarding stats.
+
+	if o.auditHook == nil {
+		return
+	}
+
+	e = o.auditHook(op, key, xmv)
+	if e != nil {
+		stats.Vetoed++
+
+		return fmt.Errorf("record vetoed by audit hook: %w", e)
+	}
+
+	stats.Allowed++
+
+	return
+}
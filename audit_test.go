@@ -0,0 +1,77 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditHook(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		key     []byte
+		trail   []string
+
+		hook = func(op Op, key []byte, xmv XMetaValue) error {
+			trail = append(trail, fmt.Sprintf("%v %s %d", op, key, xmv))
+
+			if bytes.HasPrefix(key, []byte("secret")) {
+				return fmt.Errorf("secrets stay here")
+			}
+
+			return nil
+		}
+	)
+
+	encoder = NewEncoder(&buffer, nil, WithAuditHook(hook))
+
+	assert.NoError(t, encoder.EncodeX([]byte("a"), []byte("one"), XMetaValue2))
+	assert.ErrorContains(t,
+		encoder.Encode([]byte("secret"), []byte("two")),
+		"secrets stay here",
+	)
+	assert.NoError(t, encoder.Encode([]byte("b"), []byte("three")))
+
+	assert.Equal(t, AuditStats{Allowed: 2, Vetoed: 1}, encoder.AuditStats())
+
+	assert.NoError(t,
+		NewEncoder(&buffer, nil).Encode([]byte("secret2"), []byte("four")),
+	)
+
+	decoder = NewDecoder(&buffer, nil, WithAuditHook(hook))
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("a"), key)
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("b"), key)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "vetoed")
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.Equal(t, AuditStats{Allowed: 2, Vetoed: 1}, decoder.AuditStats())
+
+	assert.Equal(t,
+		[]string{
+			"encode a 2", "encode secret 0", "encode b 0",
+			"decode a 2", "decode b 0", "decode secret2 0",
+		},
+		trail,
+	)
+
+	return
+}
@@ -0,0 +1,121 @@
+package bottledlightning
+
+import (
+	"io"
+)
+
+func (n *Encoder) batchRecord(key, val []byte) (e error) {
+	// Adds the key and value of a data record, whose header has already been
+	// added, to the checksum of the current batch, and counts the record
+	// towards the batch. The caller must hold n.mutex.
+
+	_, e = n.hasher.Write(key)
+	if e != nil {
+		return
+	}
+
+	_, e = n.hasher.Write(val)
+	if e != nil {
+		return
+	}
+
+	e = n.countBatch()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) countBatch() (e error) {
+	// Counts a data record towards the current batch, ending the batch if it
+	// is full. The caller must hold n.mutex.
+
+	n.batched++
+
+	if n.batched < n.options.batchLen {
+		return
+	}
+
+	e = n.endBatch()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) endBatch() (e error) {
+	// Transmits the checksum of the current batch, if it holds any records,
+	// and starts a new batch. The caller must hold n.mutex.
+
+	if n.batched == 0 {
+		return
+	}
+
+	e = n.writeControl(controlBatchChecksum,
+		n.hasher.Sum([]byte{}),
+	)
+	if e != nil {
+		return
+	}
+
+	n.hasher.Reset()
+
+	n.batched = 0
+
+	e = n.alignBatch()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) alignBatch() (e error) {
+	// Pads the stream with a padding control record, if configured by
+	// WithBatchAlignment, so that the next batch begins at a multiple of the
+	// alignment. The caller must hold n.mutex.
+
+	var (
+		align = int64(n.options.batchAlignment)
+		gap   int64
+	)
+
+	if n.aligner == nil {
+		return
+	}
+
+	gap = (align - n.aligner.written%align) % align
+	if gap == 0 {
+		return
+	}
+
+	for gap < controlOverhead {
+		gap += align
+	}
+
+	e = n.writeControl(controlPadding,
+		make([]byte, gap-controlOverhead),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// A countingWriter counts the bytes written to the stream of an Encoder
+// configured with WithBatchAlignment.
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(b []byte) (n int, e error) {
+	n, e = w.writer.Write(b)
+
+	w.written += int64(n)
+
+	return
+}
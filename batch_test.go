@@ -0,0 +1,158 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchChecksum(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoded []byte
+		i       int
+		key     []byte
+		val     []byte
+
+		encoder = NewEncoder(&buffer, fnv.New32a(),
+			WithBatchChecksum(3),
+		)
+	)
+
+	for i = 0; i < 5; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte{'k', byte(i)}, []byte{'v', byte(i)}),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte{'k', 5},
+			bytes.NewReader([]byte{'v', 5}), 2,
+		),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte{'k', 6}, []byte{'v', 6}),
+	)
+
+	// Features record (2+4+5+4 B), seven data records (2+1+2+2 B) and three
+	// batch checksum records (2+4+5 B).
+
+	assert.Equal(t, 15+7*7+2*11,
+		buffer.Len(),
+	)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.Equal(t, 15+7*7+3*11,
+		buffer.Len(),
+	)
+
+	encoded = bytes.Clone(buffer.Bytes())
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	for i = 0; i < 7; i++ {
+		key, val, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t, []byte{'k', byte(i)}, key)
+		assert.Equal(t, []byte{'v', byte(i)}, val)
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	encoded[15+5] ^= 1 // value of the first record of the first batch
+
+	decoder = NewDecoder(bytes.NewReader(encoded), fnv.New32a())
+
+	for i = 0; i < 3; i++ {
+		_, _, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "batch checksum")
+
+	decoder = NewDecoder(
+		bytes.NewReader(encoded[:len(encoded)-11]),
+		nil,
+	)
+
+	for i = 0; i < 7; i++ {
+		_, _, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	return
+}
+
+func TestBatchAlignment(t *testing.T) {
+	var (
+		align   int
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		i       int
+		key     []byte
+	)
+
+	// Small alignments exercise padding records spanning several blocks.
+
+	for _, align = range []int{1, 3, 8, 512} {
+		buffer.Reset()
+
+		encoder = NewEncoder(&buffer, fnv.New32a(),
+			WithBatchChecksum(2), WithBatchAlignment(align),
+		)
+
+		for i = 0; i < 5; i++ {
+			assert.NoError(t,
+				encoder.Encode([]byte{'k', byte(i)}, []byte{'v', byte(i)}),
+			)
+
+			if i%2 == 1 {
+				assert.Zero(t, buffer.Len()%align, align)
+			}
+		}
+
+		assert.NoError(t,
+			encoder.Close(),
+		)
+		assert.Zero(t, buffer.Len()%align, align)
+
+		decoder = NewDecoder(&buffer, fnv.New32a())
+
+		for i = 0; i < 5; i++ {
+			key, _, e = decoder.Decode()
+			assert.NoError(t, e)
+			assert.Equal(t, []byte{'k', byte(i)}, key)
+		}
+
+		_, _, e = decoder.Decode()
+		assert.ErrorIs(t, e, io.EOF)
+	}
+
+	return
+}
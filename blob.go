@@ -0,0 +1,370 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// A BlobStore is a content-addressable store to which an Encoder configured
+// with WithBlobStore spills large values, such as a directory or an object
+// store shared by successive snapshots.
+type BlobStore interface {
+	// Put stores the blob read from the [io.Reader] until [io.EOF], and
+	// returns its SHA-256 digest, under which it can be retrieved. Storing a
+	// blob already stored has no further effect.
+	Put(blob io.Reader) (digest []byte, e error)
+
+	// Get retrieves the blob with the given SHA-256 digest.
+	Get(digest []byte) (blob io.ReadCloser, e error)
+}
+
+// A CollectableBlobStore is a BlobStore whose blobs can be enumerated and
+// deleted, so that Catalog.CollectGarbage can reclaim those that no retained
+// snapshot references.
+type CollectableBlobStore interface {
+	BlobStore
+
+	// Blobs calls fn with the digest of every blob stored, stopping at the
+	// first error, which it returns.
+	Blobs(fn func(digest []byte) error) error
+
+	// Delete deletes the blob with the given SHA-256 digest. Deleting a blob
+	// not stored has no effect.
+	Delete(digest []byte) error
+}
+
+// A DirBlobStore is a BlobStore that keeps blobs as files, named after their
+// digests, in a directory on the local file system. DirBlobStores are safe
+// for concurrent use by multiple goroutines and processes.
+type DirBlobStore struct {
+	dir string
+}
+
+// NewDirBlobStore returns a new DirBlobStore that keeps blobs in the named
+// directory, which it creates if necessary.
+func NewDirBlobStore(dir string) (s *DirBlobStore, e error) {
+	defer errorf("could not create blob store", &e)
+
+	e = os.MkdirAll(dir, 0o755)
+	if e != nil {
+		return
+	}
+
+	s = &DirBlobStore{
+		dir: dir,
+	}
+
+	return
+}
+
+// Put implements BlobStore. A blob is written to a temporary file, synced,
+// and then renamed after its digest, so that a blob under a given name is
+// never incomplete.
+func (s *DirBlobStore) Put(blob io.Reader) (digest []byte, e error) {
+	defer errorf("could not put blob", &e)
+
+	var (
+		file   *os.File
+		hasher = sha256.New()
+		name   string
+	)
+
+	file, e = os.CreateTemp(s.dir, ".blob-*")
+	if e != nil {
+		return
+	}
+
+	defer os.Remove(file.Name())
+
+	defer file.Close()
+
+	_, e = io.Copy(
+		io.MultiWriter(file, hasher),
+		blob,
+	)
+	if e != nil {
+		return
+	}
+
+	e = file.Sync()
+	if e != nil {
+		return
+	}
+
+	e = file.Close()
+	if e != nil {
+		return
+	}
+
+	digest = hasher.Sum(nil)
+
+	name = s.path(digest)
+
+	e = os.MkdirAll(filepath.Dir(name), 0o755)
+	if e != nil {
+		return
+	}
+
+	e = os.Rename(file.Name(), name)
+	if e != nil {
+		return
+	}
+
+	e = syncDir(
+		filepath.Dir(name),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Get implements BlobStore.
+func (s *DirBlobStore) Get(digest []byte) (blob io.ReadCloser, e error) {
+	defer errorf("could not get blob", &e)
+
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("malformed digest")
+	}
+
+	blob, e = os.Open(
+		s.path(digest),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Blobs implements CollectableBlobStore. Blobs being put are not enumerated.
+func (s *DirBlobStore) Blobs(fn func(digest []byte) error) (e error) {
+	defer errorf("could not enumerate blobs", &e)
+
+	e = filepath.WalkDir(s.dir,
+		func(name string, entry fs.DirEntry, e error) error {
+			var (
+				digest []byte
+			)
+
+			if e != nil || entry.IsDir() {
+				return e
+			}
+
+			digest, e = hex.DecodeString(entry.Name())
+			if e != nil || len(digest) != sha256.Size ||
+				name != s.path(digest) {
+				return nil // not a blob, such as a temporary file
+			}
+
+			return fn(digest)
+		},
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Delete implements CollectableBlobStore.
+func (s *DirBlobStore) Delete(digest []byte) (e error) {
+	defer errorf("could not delete blob", &e)
+
+	if len(digest) != sha256.Size {
+		return fmt.Errorf("malformed digest")
+	}
+
+	e = os.Remove(
+		s.path(digest),
+	)
+	if errors.Is(e, fs.ErrNotExist) {
+		return nil
+	}
+
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (s *DirBlobStore) path(digest []byte) string {
+	// Returns the name of the file holding the blob with the given digest,
+	// fanned out over subdirectories so that none grows too large.
+
+	var (
+		name = hex.EncodeToString(digest)
+	)
+
+	return filepath.Join(s.dir, name[:2], name)
+}
+
+// Under the blob spill feature, the value of every data record is preceded by
+// a tag, and a value spilled to the BlobStore is replaced by its digest.
+const (
+	blobInline byte = iota // value follows
+	blobRef                // SHA-256 digest of spilled value follows
+)
+
+func (o *options) spill(val []byte) (spilled []byte, e error) {
+	// Returns val tagged, or replaced by the digest of a blob if it reaches
+	// the threshold.
+
+	var (
+		digest []byte
+	)
+
+	if o.features&featureBlobSpill == 0 {
+		return val, nil
+	}
+
+	if int64(len(val)) < o.blobThreshold {
+		return append([]byte{blobInline}, val...), nil
+	}
+
+	digest, e = o.putBlob(
+		bytes.NewReader(val),
+	)
+	if e != nil {
+		return
+	}
+
+	return append([]byte{blobRef}, digest...), nil
+}
+
+func (o *options) spillFrom(val io.Reader, size int64) (
+	spilled io.Reader, spilledSize int64, e error,
+) {
+	// Returns a value of the given size, to be read from val, tagged or
+	// replaced as by spill.
+
+	var (
+		digest  []byte
+		limited *io.LimitedReader
+	)
+
+	if o.features&featureBlobSpill == 0 {
+		return val, size, nil
+	}
+
+	if size < o.blobThreshold {
+		return io.MultiReader(bytes.NewReader([]byte{blobInline}), val),
+			size + 1, nil
+	}
+
+	limited = &io.LimitedReader{R: val, N: size}
+
+	digest, e = o.putBlob(limited)
+	if e != nil {
+		return
+	}
+
+	if limited.N > 0 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	return bytes.NewReader(append([]byte{blobRef}, digest...)),
+		1 + sha256.Size, nil
+}
+
+func (o *options) putBlob(blob io.Reader) (digest []byte, e error) {
+	digest, e = o.blobStore.Put(blob)
+	if e != nil {
+		return
+	}
+
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("blob store returned malformed digest")
+	}
+
+	return
+}
+
+func (d *Decoder) referenceBlob(val []byte) (e error) {
+	// Reports the digest of the blob for which a tagged value stands, if
+	// any, without retrieving it.
+
+	if len(val) == 0 {
+		return fmt.Errorf("blob spill tag missing")
+	}
+
+	switch val[0] {
+	case blobInline:
+		return
+
+	case blobRef:
+		if len(val) != 1+sha256.Size {
+			return fmt.Errorf("malformed blob digest")
+		}
+
+	default:
+		return fmt.Errorf("unknown blob spill tag %d", val[0])
+	}
+
+	d.options.blobReferences(val[1:])
+
+	return
+}
+
+func (d *Decoder) unspill(val []byte) (unspilled []byte, e error) {
+	// Returns the value that a tagged value or digest stands for, retrieving
+	// it from the BlobStore and verifying its digest if necessary.
+
+	var (
+		blob   io.ReadCloser
+		hasher hash.Hash
+	)
+
+	if len(val) == 0 {
+		return nil, fmt.Errorf("blob spill tag missing")
+	}
+
+	switch val[0] {
+	case blobInline:
+		return val[1:], nil
+
+	case blobRef:
+		if len(val) != 1+sha256.Size {
+			return nil, fmt.Errorf("malformed blob digest")
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown blob spill tag %d", val[0])
+	}
+
+	if d.options.blobStore == nil {
+		return nil, fmt.Errorf("value spilled but no blob store configured")
+	}
+
+	blob, e = d.options.blobStore.Get(val[1:])
+	if e != nil {
+		return
+	}
+
+	defer blob.Close()
+
+	hasher = sha256.New()
+
+	unspilled, e = io.ReadAll(
+		io.TeeReader(blob, hasher),
+	)
+	if e != nil {
+		return
+	}
+
+	if !bytes.Equal(hasher.Sum(nil), val[1:]) {
+		return nil, fmt.Errorf("blob digest mismatch")
+	}
+
+	return
+}
@@ -0,0 +1,90 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobStore(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		decoder  *Decoder
+		e        error
+		encoder  *Encoder
+		expected []byte
+		large    = bytes.Repeat([]byte("large"), 1000)
+		names    []string
+		store    *DirBlobStore
+		val      []byte
+	)
+
+	store, e = NewDirBlobStore(
+		filepath.Join(t.TempDir(), "blobs"),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	encoder = NewEncoder(&buffer, fnv.New32a(), WithBlobStore(store, 1024))
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), large),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("b"), bytes.NewReader(large), 5000),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("c"), []byte("small")),
+	)
+
+	assert.ErrorIs(t,
+		encoder.EncodeFrom([]byte("d"), bytes.NewReader(large), 6000),
+		io.ErrUnexpectedEOF,
+	)
+
+	assert.Less(t, buffer.Len(), 200)
+
+	names, e = filepath.Glob(
+		filepath.Join(store.dir, "*", "*"),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Len(t, names, 1) // shared by both records
+
+	_, _, e = NewDecoder(bytes.NewReader(buffer.Bytes()), nil).Decode()
+
+	assert.ErrorContains(t, e, "no blob store")
+
+	decoder = NewDecoder(&buffer, fnv.New32a(), WithBlobStore(store, 0))
+
+	for _, expected = range [][]byte{large, large, []byte("small")} {
+		_, val, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t, expected, val)
+	}
+
+	encoder.Encode([]byte("e"), large)
+
+	assert.NoError(t,
+		os.WriteFile(names[0], []byte("tampered"), 0o644),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "digest mismatch")
+
+	return
+}
@@ -0,0 +1,219 @@
+package bltest
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// Chaos describes the failures injected into a stream by a reader or writer
+// returned by NewChaosReader or NewChaosWriter, at offsets counted from the
+// start of the stream, so that tests of recovery are deterministic. See
+// RecordEnds for the offsets of records.
+type Chaos struct {
+	// MaxRead, if not zero, limits the number of bytes returned by each
+	// Read, as might a network connection or pipe.
+	MaxRead int
+
+	// Flips are the offsets of the bits to invert, each counted from the
+	// most significant bit of the first byte of the stream.
+	Flips []int64
+
+	// Truncate ends the stream after TruncateAt bytes. A reader then reports
+	// [io.EOF], as would a file cut short, and a writer discards the rest of
+	// each write and returns ErrFault, as would a full disk.
+	Truncate   bool
+	TruncateAt int64
+
+	// WriteDelay delays each Write, as would a slow disk or peer.
+	WriteDelay time.Duration
+}
+
+// NewChaosReader returns an [io.Reader] that reads from the given one and
+// injects the failures of the Chaos.
+func NewChaosReader(reader io.Reader, chaos Chaos) io.Reader {
+	return &chaosReader{
+		reader: reader,
+		chaos:  newChaosState(chaos),
+	}
+}
+
+type chaosReader struct {
+	reader io.Reader
+	chaos  *chaosState
+}
+
+func (r *chaosReader) Read(b []byte) (n int, e error) {
+	r.chaos.mutex.Lock()
+
+	defer r.chaos.mutex.Unlock()
+
+	b, e = r.chaos.limit(b)
+	if e != nil {
+		return
+	}
+
+	if r.chaos.MaxRead > 0 {
+		b = b[:min(len(b), r.chaos.MaxRead)]
+	}
+
+	n, e = r.reader.Read(b)
+
+	r.chaos.flip(b[:n])
+
+	return
+}
+
+// NewChaosWriter returns an [io.Writer] that writes to the given one and
+// injects the failures of the Chaos. MaxRead is ignored.
+func NewChaosWriter(writer io.Writer, chaos Chaos) io.Writer {
+	return &chaosWriter{
+		writer: writer,
+		chaos:  newChaosState(chaos),
+	}
+}
+
+type chaosWriter struct {
+	writer io.Writer
+	chaos  *chaosState
+	buffer []byte
+}
+
+func (w *chaosWriter) Write(b []byte) (n int, e error) {
+	// Bits are flipped in a copy of b, which the caller may reuse.
+
+	var (
+		truncated []byte
+	)
+
+	w.chaos.mutex.Lock()
+
+	defer w.chaos.mutex.Unlock()
+
+	if w.chaos.WriteDelay > 0 {
+		time.Sleep(w.chaos.WriteDelay)
+	}
+
+	truncated, e = w.chaos.limit(b)
+	if e != nil {
+		return 0, ErrFault
+	}
+
+	w.buffer = append(w.buffer[:0], truncated...)
+
+	w.chaos.flip(w.buffer)
+
+	n, e = w.writer.Write(w.buffer)
+	if e != nil {
+		return
+	}
+
+	if n < len(b) {
+		return n, ErrFault
+	}
+
+	return
+}
+
+type chaosState struct {
+	Chaos
+
+	mutex  sync.Mutex
+	offset int64 // of the next byte
+}
+
+func newChaosState(chaos Chaos) (s *chaosState) {
+	s = &chaosState{
+		Chaos: chaos,
+	}
+
+	s.Flips = slices.Clone(chaos.Flips)
+
+	slices.Sort(s.Flips)
+
+	return
+}
+
+func (s *chaosState) limit(b []byte) ([]byte, error) {
+	// Returns the part of b before the truncation of the stream, or io.EOF if
+	// the stream is already truncated. The caller must hold s.mutex.
+
+	if !s.Truncate {
+		return b, nil
+	}
+
+	if s.offset >= s.TruncateAt && len(b) > 0 {
+		return nil, io.EOF
+	}
+
+	return b[:min(int64(len(b)), s.TruncateAt-s.offset)], nil
+}
+
+func (s *chaosState) flip(b []byte) {
+	// Inverts the bits of b due to be flipped, b being the next bytes of the
+	// stream, and advances the offset past them. The caller must hold
+	// s.mutex.
+
+	var (
+		bit int64
+	)
+
+	for len(s.Flips) > 0 && s.Flips[0] < 8*(s.offset+int64(len(b))) {
+		bit, s.Flips = s.Flips[0]-8*s.offset, s.Flips[1:]
+
+		if bit >= 0 {
+			b[bit/8] ^= 0x80 >> (bit % 8)
+		}
+	}
+
+	s.offset += int64(len(b))
+
+	return
+}
+
+// RecordEnds decodes the stream with a Decoder configured by the hasher and
+// options, which must match those of the Encoder, and returns the offset in
+// the stream at which each record ends, for use in Chaos. Truncation at the
+// end of record N, counted from 0, leaves records 0 to N intact, and the
+// inversion of a bit before it corrupts record N or a control record that
+// precedes it.
+func RecordEnds(stream []byte, hasher hash.Hash32, opts ...bl.Option) (
+	ends []int64, e error,
+) {
+	var (
+		counter = &countingReader{reader: bytes.NewReader(stream)}
+		decoder = bl.NewDecoder(counter, hasher, opts...)
+	)
+
+	for {
+		_, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			return ends, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		ends = append(ends, counter.n)
+	}
+}
+
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(b []byte) (n int, e error) {
+	n, e = r.reader.Read(b)
+
+	r.n += int64(n)
+
+	return
+}
@@ -0,0 +1,152 @@
+package bltest
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosReader(t *testing.T) {
+	var (
+		ends   []int64
+		e      error
+		keys   []string
+		stream = encodeTestStream(t)
+	)
+
+	ends, e = RecordEnds(stream, crc32.NewIEEE())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Len(t, ends, 3)
+	assert.Equal(t, int64(len(stream)), ends[2])
+
+	keys, e = decodeTestStream(
+		NewChaosReader(bytes.NewReader(stream), Chaos{MaxRead: 1}),
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	keys, e = decodeTestStream(
+		NewChaosReader(bytes.NewReader(stream),
+			Chaos{Flips: []int64{8*ends[1] - 1}},
+		),
+	)
+	assert.Error(t, e) // checksum of record 1
+	assert.Equal(t, []string{"a"}, keys)
+
+	keys, e = decodeTestStream(
+		NewChaosReader(bytes.NewReader(stream),
+			Chaos{Truncate: true, TruncateAt: ends[1]},
+		),
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	keys, e = decodeTestStream(
+		NewChaosReader(bytes.NewReader(stream),
+			Chaos{Truncate: true, TruncateAt: ends[1] - 1},
+		),
+	)
+	assert.Error(t, e) // record 1 cut short
+	assert.Equal(t, []string{"a"}, keys)
+
+	return
+}
+
+func TestChaosWriter(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		e       error
+		encoder *bl.Encoder
+		ends    []int64
+		start   time.Time
+		stream  = encodeTestStream(t)
+	)
+
+	ends, e = RecordEnds(stream, crc32.NewIEEE())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	encoder = bl.NewEncoder(
+		NewChaosWriter(&buffer,
+			Chaos{
+				Flips:      []int64{0},
+				Truncate:   true,
+				TruncateAt: ends[1],
+				WriteDelay: time.Millisecond,
+			},
+		),
+		crc32.NewIEEE(),
+	)
+
+	start = time.Now()
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("a")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), []byte("b")),
+	)
+	assert.GreaterOrEqual(t, time.Since(start), 2*time.Millisecond)
+
+	e = encoder.Encode([]byte("c"), []byte("c"))
+	assert.ErrorIs(t, e, ErrFault)
+
+	assert.Equal(t, stream[1:ends[1]], buffer.Bytes()[1:])
+	assert.Equal(t, stream[0]^0x80, buffer.Bytes()[0])
+
+	return
+}
+
+func encodeTestStream(t *testing.T) []byte {
+	// Returns a stream of three records, with keys equal to their values.
+
+	var (
+		buffer  bytes.Buffer
+		encoder = bl.NewEncoder(&buffer, crc32.NewIEEE())
+		key     string
+	)
+
+	for _, key = range []string{"a", "b", "c"} {
+		assert.NoError(t,
+			encoder.Encode([]byte(key), []byte(key)),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	return buffer.Bytes()
+}
+
+func decodeTestStream(reader io.Reader) (keys []string, e error) {
+	var (
+		decoder = bl.NewDecoder(reader, crc32.NewIEEE())
+		key     []byte
+	)
+
+	for {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			return keys, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		keys = append(keys,
+			string(key),
+		)
+	}
+}
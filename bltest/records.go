@@ -0,0 +1,156 @@
+package bltest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A Record is a key-value record and its extended metadata value.
+type Record struct {
+	Key   []byte
+	Val   []byte
+	XMeta bl.XMetaValue
+}
+
+// A RecordSource transmits its Records, as would a replicated database.
+type RecordSource struct {
+	Records []Record
+
+	// Err, if not nil, is the cause with which the RecordSource aborts the
+	// stream once it has transmitted FailAfter records.
+	Err       error
+	FailAfter int
+}
+
+// Transmit encodes the Records, or those whose keys sort after after if it is
+// not nil, on the Encoder, and closes it, or aborts the stream as described at
+// Err. It returns the error of the Encoder, if any, or else Err.
+func (s *RecordSource) Transmit(encoder *bl.Encoder, after []byte) (e error) {
+	var (
+		record      Record
+		transmitted int
+	)
+
+	for _, record = range s.Records {
+		if after != nil && bytes.Compare(record.Key, after) <= 0 {
+			continue
+		}
+
+		if s.Err != nil && transmitted == s.FailAfter {
+			break
+		}
+
+		e = encoder.EncodeX(record.Key, record.Val, record.XMeta)
+		if e != nil {
+			return
+		}
+
+		transmitted++
+	}
+
+	if s.Err != nil && transmitted == s.FailAfter {
+		e = encoder.Abort(s.Err)
+		if e != nil {
+			return
+		}
+
+		return s.Err
+	}
+
+	return encoder.Close()
+}
+
+// A RecordSink keeps the records that it receives, as would a replica. A
+// RecordSink is safe for concurrent use by multiple goroutines.
+type RecordSink struct {
+	// Err, if not nil, is the error with which Receive fails, once, after the
+	// RecordSink has kept FailAfter records, as though the replica had
+	// crashed.
+	Err       error
+	FailAfter int
+
+	mutex   sync.Mutex
+	records []Record
+	failed  bool
+}
+
+// Receive decodes records from the Decoder, keeping copies of them, until the
+// end of the stream, and then closes the Decoder. It returns nil at the end
+// of the stream, or the error of the Decoder, or Err as described there.
+func (s *RecordSink) Receive(decoder *bl.Decoder) (e error) {
+	var (
+		key []byte
+		val []byte
+		xmv byte
+	)
+
+	for {
+		if s.fail() {
+			return s.Err
+		}
+
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			return decoder.Close()
+		}
+
+		if e != nil {
+			return
+		}
+
+		s.mutex.Lock()
+
+		s.records = append(s.records,
+			Record{
+				Key:   bytes.Clone(key),
+				Val:   bytes.Clone(val),
+				XMeta: bl.XMetaValue(xmv),
+			},
+		)
+
+		s.mutex.Unlock()
+	}
+}
+
+// Records returns the records kept by the RecordSink, in the order received.
+func (s *RecordSink) Records() []Record {
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	return s.records[:len(s.records):len(s.records)]
+}
+
+// Last returns the key of the last record kept by the RecordSink, or nil if
+// none, after which a stream can be resumed.
+func (s *RecordSink) Last() []byte {
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	if len(s.records) == 0 {
+		return nil
+	}
+
+	return s.records[len(s.records)-1].Key
+}
+
+func (s *RecordSink) fail() bool {
+	// Returns true, once, if Receive should fail with Err.
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	if s.Err == nil || s.failed || len(s.records) != s.FailAfter {
+		return false
+	}
+
+	s.failed = true
+
+	return true
+}
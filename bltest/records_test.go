@@ -0,0 +1,73 @@
+package bltest
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordSourceSink(t *testing.T) {
+	var (
+		aborted bl.AbortedError
+		crash   = errors.New("crash")
+		e       error
+		records = []Record{
+			{Key: []byte("a"), Val: []byte("1")},
+			{Key: []byte("b"), Val: []byte("2"), XMeta: bl.XMetaValue(1)},
+			{Key: []byte("c"), Val: []byte("3")},
+		}
+		sink   = &RecordSink{Err: crash, FailAfter: 1}
+		source = &RecordSource{Records: records}
+	)
+
+	e = replicate(t, source, sink, nil)
+	assert.ErrorIs(t, e, crash)
+	assert.Equal(t, records[:1], sink.Records())
+
+	e = replicate(t, source, sink, sink.Last())
+	assert.NoError(t, e)
+	assert.Equal(t, records, sink.Records())
+
+	source = &RecordSource{
+		Records:   records,
+		Err:       errors.New("outage"),
+		FailAfter: 1,
+	}
+	sink = new(RecordSink)
+
+	e = replicate(t, source, sink, nil)
+	assert.True(t,
+		errors.As(e, &aborted),
+	)
+	assert.Equal(t, "outage", aborted.Reason)
+	assert.Equal(t, records[:1], sink.Records())
+
+	return
+}
+
+func replicate(t *testing.T, source *RecordSource, sink *RecordSink,
+	after []byte,
+) error {
+	// Transmits the records of source after after to sink over a Transport,
+	// returning the error of the sink.
+
+	var (
+		conn      net.Conn
+		transport = NewTransport(Link{}, Link{})
+	)
+
+	conn = dialPair(t, transport, func(server net.Conn) {
+		source.Transmit(
+			bl.NewEncoder(server, nil), after,
+		)
+
+		server.Close()
+	})
+
+	return sink.Receive(
+		bl.NewDecoder(conn, nil),
+	)
+}
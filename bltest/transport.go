@@ -0,0 +1,279 @@
+// Package bltest provides fakes for testing replication by Encoders and
+// Decoders deterministically and without sockets: a Transport whose
+// connections suffer latency, limited bandwidth and injected faults, readers
+// and writers that inject failures into streams, and sources and sinks of
+// records.
+package bltest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// ErrFault is the error returned by a write interrupted by FaultDisconnect, or
+// by the truncation of a stream described by Chaos.
+var ErrFault = errors.New("injected fault")
+
+// A FaultKind is a kind of Fault.
+type FaultKind int
+
+const (
+	// FaultDisconnect closes the connection at the Offset of the Fault, as
+	// though the network had failed. The write that reaches the Offset writes
+	// the bytes before it and returns ErrFault.
+	FaultDisconnect FaultKind = iota
+
+	// FaultCorrupt inverts the bits of the byte at the Offset of the Fault.
+	FaultCorrupt
+
+	// FaultStall blocks the write that reaches the Offset of the Fault, and
+	// every later one, until the connection is closed, as though the peer
+	// had stopped reading or the network had silently dropped the
+	// connection.
+	FaultStall
+)
+
+// A Fault is injected into the bytes written on one connection of a
+// Transport.
+type Fault struct {
+	Kind FaultKind
+
+	// Conn is the ordinal, counted from 0, of the connection among those
+	// dialled, or those accepted, on the Transport, so that a test can fail
+	// an attempt and let a retry succeed.
+	Conn int
+
+	// Offset is the number of bytes written on the connection before the
+	// Fault, not negative.
+	Offset int64
+}
+
+// A Link describes the conditions suffered by the bytes written in one
+// direction over the connections of a Transport.
+type Link struct {
+	// Latency delays each write.
+	Latency time.Duration
+
+	// Bandwidth, if not zero, limits the rate of transmission, in bytes per
+	// second, by further delaying each write by the time taken to transmit
+	// its bytes.
+	Bandwidth int
+
+	// Faults are injected into the bytes written.
+	Faults []Fault
+}
+
+// A Transport is a [bl.Transport] that connects peers within the process,
+// as does [bl.MemoryTransport], over connections whose conditions are
+// described by a Link for each direction. A write is delayed before any of
+// its bytes are delivered, and so blocks the writer, as on a network whose
+// buffers are full. Transports are safe for concurrent use by multiple
+// goroutines.
+type Transport struct {
+	memory   *bl.MemoryTransport
+	dialled  Link
+	accepted Link
+	mutex    sync.Mutex
+	dials    int
+	accepts  int
+}
+
+// NewTransport returns a new Transport whose dialled connections write under
+// the conditions of the dialled Link, and whose accepted connections, under
+// those of the accepted Link.
+func NewTransport(dialled, accepted Link) *Transport {
+	return &Transport{
+		memory:   bl.NewMemoryTransport(),
+		dialled:  dialled,
+		accepted: accepted,
+	}
+}
+
+// Dial implements [bl.Transport].
+func (t *Transport) Dial(ctx context.Context, address string) (
+	conn net.Conn, e error,
+) {
+	conn, e = t.memory.Dial(ctx, address)
+	if e != nil {
+		return
+	}
+
+	return t.condition(conn, t.dialled, &t.dials), nil
+}
+
+// Listen implements [bl.Transport].
+func (t *Transport) Listen(ctx context.Context, address string) (
+	listener net.Listener, e error,
+) {
+	listener, e = t.memory.Listen(ctx, address)
+	if e != nil {
+		return
+	}
+
+	return &conditionedListener{
+		Listener:  listener,
+		transport: t,
+	}, nil
+}
+
+// Dials returns the number of connections dialled on the Transport.
+func (t *Transport) Dials() int {
+	t.mutex.Lock()
+
+	defer t.mutex.Unlock()
+
+	return t.dials
+}
+
+func (t *Transport) condition(conn net.Conn, link Link, count *int) net.Conn {
+	// Wraps conn to suffer the conditions of link, as the connection
+	// numbered by count, which it increments.
+
+	var (
+		c = &conditionedConn{
+			Conn:   conn,
+			link:   link,
+			closed: make(chan struct{}),
+		}
+		fault Fault
+	)
+
+	t.mutex.Lock()
+
+	defer t.mutex.Unlock()
+
+	for _, fault = range link.Faults {
+		if fault.Conn == *count {
+			c.faults = append(c.faults, fault)
+		}
+	}
+
+	slices.SortStableFunc(c.faults, func(a, b Fault) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	*count++
+
+	return c
+}
+
+type conditionedListener struct {
+	net.Listener
+
+	transport *Transport
+}
+
+func (l *conditionedListener) Accept() (conn net.Conn, e error) {
+	conn, e = l.Listener.Accept()
+	if e != nil {
+		return
+	}
+
+	return l.transport.condition(conn,
+		l.transport.accepted, &l.transport.accepts,
+	), nil
+}
+
+type conditionedConn struct {
+	net.Conn
+
+	link    Link
+	mutex   sync.Mutex // serialises writes
+	faults  []Fault    // pending, by Offset
+	written int64
+	closed  chan struct{}
+	closing sync.Once
+}
+
+func (c *conditionedConn) Write(b []byte) (n int, e error) {
+	var (
+		fault Fault
+		m     int
+	)
+
+	c.mutex.Lock()
+
+	defer c.mutex.Unlock()
+
+	c.delay(len(b))
+
+	for len(c.faults) > 0 && c.faults[0].Offset < c.written+int64(len(b)) {
+		fault, c.faults = c.faults[0], c.faults[1:]
+
+		m, e = c.write(b[:max(fault.Offset-c.written, 0)])
+		if e != nil {
+			return n + m, e
+		}
+
+		n, b = n+m, b[m:]
+
+		switch fault.Kind {
+		case FaultDisconnect:
+			c.Close()
+
+			return n, ErrFault
+
+		case FaultStall:
+			<-c.closed
+
+			return n, net.ErrClosed
+		}
+
+		m, e = c.write([]byte{^b[0]})
+		if e != nil {
+			return n + m, e
+		}
+
+		n, b = n+m, b[m:]
+	}
+
+	m, e = c.write(b)
+
+	return n + m, e
+}
+
+func (c *conditionedConn) write(b []byte) (n int, e error) {
+	// Writes b to the underlying connection, counting the bytes written. The
+	// caller must hold c.mutex.
+
+	n, e = c.Conn.Write(b)
+
+	c.written += int64(n)
+
+	return
+}
+
+func (c *conditionedConn) delay(n int) {
+	// Sleeps for the latency of the link and the time taken to transmit n
+	// bytes at its bandwidth.
+
+	var (
+		d = c.link.Latency
+	)
+
+	if c.link.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second /
+			time.Duration(c.link.Bandwidth)
+	}
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+
+	return
+}
+
+func (c *conditionedConn) Close() error {
+	c.closing.Do(func() {
+		close(c.closed)
+	})
+
+	return c.Conn.Close()
+}
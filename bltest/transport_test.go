@@ -0,0 +1,171 @@
+package bltest
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportConditions(t *testing.T) {
+	var (
+		b         = make([]byte, 100)
+		conn      net.Conn
+		e         error
+		elapsed   time.Duration
+		start     time.Time
+		transport = NewTransport(
+			Link{Latency: 10 * time.Millisecond, Bandwidth: 2000},
+			Link{},
+		)
+	)
+
+	conn = dialPair(t, transport, func(server net.Conn) {
+		io.Copy(io.Discard, server)
+	})
+
+	start = time.Now()
+
+	_, e = conn.Write(b)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	elapsed = time.Since(start)
+
+	assert.GreaterOrEqual(t, elapsed, 60*time.Millisecond) // 10 + 100/2000 s
+	assert.Equal(t, 1, transport.Dials())
+
+	return
+}
+
+func TestTransportFaults(t *testing.T) {
+	var (
+		conn      net.Conn
+		e         error
+		n         int
+		received  = make(chan []byte, 1)
+		transport = NewTransport(
+			Link{
+				Faults: []Fault{
+					{Kind: FaultCorrupt, Conn: 0, Offset: 2},
+					{Kind: FaultDisconnect, Conn: 0, Offset: 4},
+					{Kind: FaultStall, Conn: 1, Offset: 1},
+				},
+			},
+			Link{},
+		)
+	)
+
+	conn = dialPair(t, transport, func(server net.Conn) {
+		var (
+			b []byte
+		)
+
+		b, _ = io.ReadAll(server)
+
+		received <- b
+	})
+
+	n, e = conn.Write([]byte{0, 1, 2, 3, 4, 5})
+	assert.Equal(t, 4, n)
+	assert.ErrorIs(t, e, ErrFault)
+	assert.Equal(t, []byte{0, 1, 0xfd, 3}, <-received)
+
+	_, e = conn.Write([]byte{0})
+	assert.ErrorIs(t, e, io.ErrClosedPipe)
+
+	conn = dialPair(t, transport, func(server net.Conn) {
+		io.Copy(io.Discard, server)
+	})
+
+	time.AfterFunc(10*time.Millisecond, func() { conn.Close() })
+
+	n, e = conn.Write([]byte{0, 1})
+	assert.Equal(t, 1, n)
+	assert.True(t,
+		errors.Is(e, net.ErrClosed),
+	)
+
+	return
+}
+
+func TestTransportCorruptionDetected(t *testing.T) {
+	var (
+		conn      net.Conn
+		e         error
+		transport = NewTransport(
+			Link{},
+			Link{
+				Faults: []Fault{
+					{Kind: FaultCorrupt, Conn: 0, Offset: 5},
+				},
+			},
+		)
+	)
+
+	conn = dialPair(t, transport, func(server net.Conn) {
+		var (
+			encoder = bl.NewEncoder(server, crc32.NewIEEE())
+		)
+
+		encoder.Encode([]byte("key"), []byte("val"))
+		encoder.Close()
+
+		server.Close()
+	})
+
+	_, _, e = bl.NewDecoder(conn, crc32.NewIEEE()).Decode()
+	assert.Error(t, e)
+
+	return
+}
+
+func dialPair(t *testing.T, transport *Transport, serve func(net.Conn)) (
+	conn net.Conn,
+) {
+	// Returns a connection dialled on the transport to a listener that
+	// serves the next connection that it accepts.
+
+	var (
+		e        error
+		listener net.Listener
+	)
+
+	listener, e = transport.Listen(context.Background(), t.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	go func() {
+		var (
+			e      error
+			server net.Conn
+		)
+
+		server, e = listener.Accept()
+
+		listener.Close()
+
+		if e != nil {
+			return
+		}
+
+		serve(server)
+	}()
+
+	conn, e = transport.Dial(context.Background(), t.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return
+}
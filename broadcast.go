@@ -0,0 +1,421 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// A SlowConsumerPolicy determines what a Broadcaster does with a record for a
+// Sink whose queue is full.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerBuffer queues the record regardless, without bound.
+	SlowConsumerBuffer SlowConsumerPolicy = iota
+
+	// SlowConsumerDrop discards the record, for that Sink only. Dropping a
+	// record invalidates batch checksums, signatures and deduplication
+	// references, so the policy suits only streams that use none of them.
+	SlowConsumerDrop
+
+	// SlowConsumerDisconnect detaches the Sink.
+	SlowConsumerDisconnect
+)
+
+// A Broadcaster is an [io.Writer] that receives encoded records once, as the
+// destination of an Encoder or of Relay, and delivers each whole record to
+// every attached Sink. Each Sink is written by a goroutine of its own, so that
+// a slow consumer does not hold up the others, but only as far as its queue
+// and SlowConsumerPolicy allow. It is safe for concurrent use by multiple
+// goroutines.
+type Broadcaster struct {
+	mutex    sync.Mutex
+	queueLen int
+	sinks    map[*Sink]struct{}
+	pending  []byte
+	closed   bool
+	workers  sync.WaitGroup
+}
+
+// NewBroadcaster returns a new Broadcaster that queues up to queueLen records
+// for each Sink before applying its SlowConsumerPolicy.
+func NewBroadcaster(queueLen int) (b *Broadcaster) {
+	b = &Broadcaster{
+		queueLen: queueLen,
+		sinks:    make(map[*Sink]struct{}),
+	}
+
+	return
+}
+
+// Attach attaches the [io.Writer] as a Sink, which receives every record
+// written to the Broadcaster from the next whole record onwards. A Sink
+// attached after the first record misses any control records before it, such
+// as those announcing features, so it should usually be attached up front.
+func (b *Broadcaster) Attach(writer io.Writer, policy SlowConsumerPolicy) (
+	s *Sink,
+) {
+	s = &Sink{
+		broadcaster: b,
+		writer:      writer,
+		policy:      policy,
+	}
+
+	s.ready = sync.NewCond(&s.mutex)
+
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		s.done = true
+		s.e = fmt.Errorf("broadcaster closed")
+
+		return
+	}
+
+	b.sinks[s] = struct{}{}
+
+	b.workers.Add(1)
+
+	go s.work()
+
+	return
+}
+
+// Write implements [io.Writer]. It never fails on account of a Sink, but
+// buffers a partial record until the rest of it is written.
+func (b *Broadcaster) Write(p []byte) (n int, e error) {
+	defer errorf("could not broadcast", &e)
+
+	var (
+		consumed bool
+		header   Header
+		key      []byte
+		record   []byte
+		s        *Sink
+	)
+
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return 0, fmt.Errorf("broadcaster closed")
+	}
+
+	b.pending = append(b.pending, p...)
+
+	for {
+		header, e = ParseHeader(b.pending)
+		if e != nil {
+			break
+		}
+
+		if len(b.pending) < header.RecordLen() {
+			break
+		}
+
+		record = b.pending[:header.RecordLen():header.RecordLen()]
+
+		key = nil
+
+		if !header.IsControl() {
+			key = record[header.Len() : header.Len()+header.KeyLen]
+		}
+
+		for s = range b.sinks {
+			s.enqueue(record, key)
+		}
+
+		b.pending = b.pending[header.RecordLen():]
+
+		consumed = true
+	}
+
+	if consumed {
+		// Queued records share the array, which must not be appended to.
+
+		b.pending = append([]byte(nil), b.pending...)
+	}
+
+	return len(p), nil
+}
+
+// Close waits for every Sink to write the records queued for it and detaches
+// them all. It does not close the underlying [io.Writer] of any Sink. Close
+// returns an error if the Broadcaster holds part of a record.
+func (b *Broadcaster) Close() (e error) {
+	defer errorf("could not close broadcaster", &e)
+
+	var (
+		s *Sink
+	)
+
+	b.mutex.Lock()
+
+	b.closed = true
+
+	for s = range b.sinks {
+		s.mutex.Lock()
+
+		s.finish(nil)
+
+		s.mutex.Unlock()
+	}
+
+	if len(b.pending) > 0 {
+		e = fmt.Errorf("stream ended within record: %w", io.ErrUnexpectedEOF)
+	}
+
+	b.mutex.Unlock()
+
+	b.workers.Wait()
+
+	return
+}
+
+func (b *Broadcaster) detach(s *Sink) {
+	// Removes s from the set of sinks, so that it receives no more records.
+
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	delete(b.sinks, s)
+
+	return
+}
+
+// A Sink is a destination attached to a Broadcaster.
+type Sink struct {
+	broadcaster *Broadcaster
+	writer      io.Writer
+	policy      SlowConsumerPolicy
+
+	mutex      sync.Mutex
+	ready      *sync.Cond
+	queue      [][]byte
+	dropped    int
+	done       bool
+	e          error
+	subscribed bool
+	topics     [][]byte // key prefixes
+}
+
+// Detach detaches the Sink from its Broadcaster once the records already
+// queued for it are written.
+func (s *Sink) Detach() {
+	s.broadcaster.detach(s)
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	s.finish(nil)
+
+	return
+}
+
+// Subscribe restricts the data records that the Sink receives to those whose
+// keys begin with one of the given prefixes, or with that of an earlier
+// subscription. A Sink receives every record until it first subscribes, and
+// every control record regardless. Records are filtered before they are
+// queued, so that those of other topics cost the consumer nothing. As with
+// SlowConsumerDrop, filtering invalidates batch checksums, signatures and
+// deduplication references.
+func (s *Sink) Subscribe(prefixes ...[]byte) {
+	var (
+		prefix []byte
+	)
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	s.subscribed = true
+
+	for _, prefix = range prefixes {
+		if !slices.ContainsFunc(s.topics, prefixEquals(prefix)) {
+			s.topics = append(s.topics,
+				bytes.Clone(prefix),
+			)
+		}
+	}
+
+	return
+}
+
+// Unsubscribe cancels the subscription of the Sink to the given prefixes.
+// Once all are cancelled, the Sink receives control records only.
+func (s *Sink) Unsubscribe(prefixes ...[]byte) {
+	var (
+		prefix []byte
+	)
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	for _, prefix = range prefixes {
+		s.topics = slices.DeleteFunc(s.topics, prefixEquals(prefix))
+	}
+
+	return
+}
+
+func prefixEquals(prefix []byte) func([]byte) bool {
+	// Returns a function reporting whether a topic is the given prefix.
+
+	return func(topic []byte) bool {
+		return bytes.Equal(topic, prefix)
+	}
+}
+
+// Dropped returns the number of records discarded under SlowConsumerDrop.
+func (s *Sink) Dropped() int {
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	return s.dropped
+}
+
+// Err returns the error, if any, that caused the Sink to be detached, such as
+// a failure of its [io.Writer] or its disconnection as a slow consumer.
+func (s *Sink) Err() error {
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	return s.e
+}
+
+func (s *Sink) enqueue(record, key []byte) {
+	// Queues a record for s, subject to its subscriptions and policy. The key
+	// is nil if the record is a control record. The caller must hold the
+	// mutex of the Broadcaster, but not that of s.
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	switch {
+	case s.done:
+		return
+
+	case key != nil && !s.admits(key):
+		return
+
+	case len(s.queue) < s.broadcaster.queueLen:
+
+	case s.policy == SlowConsumerDrop:
+		s.dropped++
+
+		return
+
+	case s.policy == SlowConsumerDisconnect:
+		delete(s.broadcaster.sinks, s)
+
+		s.queue = nil
+
+		s.finish(
+			fmt.Errorf("sink disconnected as slow consumer"),
+		)
+
+		return
+	}
+
+	s.queue = append(s.queue, record)
+
+	s.ready.Signal()
+
+	return
+}
+
+func (s *Sink) admits(key []byte) bool {
+	// Returns true if s subscribes to a prefix of key, or to nothing in
+	// particular. The caller must hold s.mutex.
+
+	var (
+		topic []byte
+	)
+
+	if !s.subscribed {
+		return true
+	}
+
+	for _, topic = range s.topics {
+		if bytes.HasPrefix(key, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Sink) finish(e error) {
+	// Marks s as receiving no more records, recording e if it is the first
+	// error. The caller must hold s.mutex.
+
+	if s.e == nil {
+		s.e = e
+	}
+
+	s.done = true
+
+	s.ready.Signal()
+
+	return
+}
+
+func (s *Sink) work() {
+	// Writes queued records to the io.Writer until s is done and its queue is
+	// empty, or until a write fails.
+
+	var (
+		e      error
+		record []byte
+	)
+
+	defer s.broadcaster.workers.Done()
+
+	for {
+		s.mutex.Lock()
+
+		for len(s.queue) == 0 && !s.done {
+			s.ready.Wait()
+		}
+
+		if len(s.queue) == 0 {
+			s.mutex.Unlock()
+
+			return
+		}
+
+		record = s.queue[0]
+
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+
+		s.mutex.Unlock()
+
+		_, e = s.writer.Write(record)
+		if e != nil {
+			s.broadcaster.detach(s)
+
+			s.mutex.Lock()
+
+			s.queue = nil
+
+			s.finish(e)
+
+			s.mutex.Unlock()
+
+			return
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroadcaster(t *testing.T) {
+	const (
+		records = 8
+	)
+
+	var (
+		broadcaster = NewBroadcaster(2)
+		buffers     [3]bytes.Buffer
+		decoder     *Decoder
+		e           error
+		encoder     *Encoder
+		gate        = make(chan struct{})
+		i           int
+		key         []byte
+		sinks       [4]*Sink
+	)
+
+	sinks[0] = broadcaster.Attach(&buffers[0], SlowConsumerBuffer)
+
+	sinks[1] = broadcaster.Attach(
+		&gatedWriter{gate, &buffers[1]}, SlowConsumerDrop,
+	)
+
+	sinks[2] = broadcaster.Attach(
+		&gatedWriter{gate, &buffers[2]}, SlowConsumerDisconnect,
+	)
+
+	sinks[3] = broadcaster.Attach(failingWriter{}, SlowConsumerBuffer)
+
+	encoder = NewEncoder(broadcaster, fnv.New32a())
+
+	for i = 0; i < records; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte{byte(i)}, make([]byte, i)),
+		)
+	}
+
+	close(gate)
+
+	assert.NoError(t, broadcaster.Close())
+
+	assert.Less(t, buffers[1].Len(), buffers[0].Len())
+	assert.Less(t, buffers[2].Len(), buffers[0].Len())
+
+	decoder = NewDecoder(&buffers[0], fnv.New32a())
+
+	for i = 0; i < records; i++ {
+		key, _, e = decoder.Decode()
+
+		assert.NoError(t, e)
+		assert.Equal(t, []byte{byte(i)}, key)
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	assert.NoError(t, sinks[0].Err())
+	assert.Zero(t, sinks[0].Dropped())
+
+	assert.NoError(t, sinks[1].Err())
+	assert.NotZero(t, sinks[1].Dropped())
+
+	_, e = Verify(&buffers[1], fnv.New32a())
+
+	assert.NoError(t, e)
+
+	assert.Error(t, sinks[2].Err())
+
+	_, e = Verify(&buffers[2], fnv.New32a())
+
+	assert.NoError(t, e)
+
+	assert.Error(t, sinks[3].Err())
+
+	_, e = broadcaster.Write([]byte{0})
+
+	assert.Error(t, e)
+
+	return
+}
+
+func TestBroadcasterSubscriptions(t *testing.T) {
+	var (
+		broadcaster = NewBroadcaster(16)
+		buffers     [3]bytes.Buffer
+		e           error
+		encoder     = NewEncoder(broadcaster, fnv.New32a())
+		i           int
+		key         []byte
+		keys        [3][][]byte
+		sinks       [3]*Sink
+	)
+
+	for i = range sinks {
+		sinks[i] = broadcaster.Attach(&buffers[i], SlowConsumerBuffer)
+	}
+
+	sinks[1].Subscribe([]byte("user/"), []byte("order/"))
+	sinks[1].Unsubscribe([]byte("order/"))
+	sinks[2].Subscribe([]byte("nothing/"))
+	sinks[2].Unsubscribe([]byte("nothing/"))
+
+	for _, key = range [][]byte{
+		[]byte("order/1"), []byte("user/1"), []byte("user/2"), {},
+	} {
+		assert.NoError(t,
+			encoder.Encode(key, []byte("val")),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+	assert.NoError(t,
+		broadcaster.Close(),
+	)
+
+	for i = range buffers {
+		keys[i], e = decodeKeys(&buffers[i])
+		assert.NoError(t, e)
+	}
+
+	assert.Len(t, keys[0], 4)
+	assert.Equal(t,
+		[][]byte{[]byte("user/1"), []byte("user/2")},
+		keys[1],
+	)
+	assert.Empty(t, keys[2])
+
+	return
+}
+
+func decodeKeys(reader io.Reader) (keys [][]byte, e error) {
+	// Returns the keys of the records of the stream read from reader.
+
+	var (
+		decoder = NewDecoder(reader, fnv.New32a())
+		key     []byte
+	)
+
+	for {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			return keys, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		keys = append(keys, key)
+	}
+}
+
+func TestBroadcasterPartialRecord(t *testing.T) {
+	var (
+		broadcaster = NewBroadcaster(1)
+		buffer      bytes.Buffer
+		stream      bytes.Buffer
+	)
+
+	broadcaster.Attach(&buffer, SlowConsumerBuffer)
+
+	assert.NoError(t,
+		NewEncoder(&stream, nil).Encode([]byte("key"), []byte("val")),
+	)
+
+	_, _ = broadcaster.Write(stream.Bytes()[:4])
+	_, _ = broadcaster.Write(stream.Bytes()[4:])
+	_, _ = broadcaster.Write(stream.Bytes()[:1])
+
+	assert.ErrorIs(t, broadcaster.Close(), io.ErrUnexpectedEOF)
+	assert.Equal(t, stream.Bytes(), buffer.Bytes())
+
+	return
+}
+
+type gatedWriter struct {
+	gate   chan struct{}
+	writer io.Writer
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+
+	return w.writer.Write(p)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("failing writer")
+}
@@ -0,0 +1,136 @@
+package bottledlightning
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A BudgetPolicy determines what a Decoder does with a record that does not fit
+// in what remains of its MemoryBudget.
+type BudgetPolicy int
+
+const (
+	// BudgetBlock waits for other Decoders to release memory, holding up the
+	// stream.
+	BudgetBlock BudgetPolicy = iota
+
+	// BudgetReject fails at once with a BudgetExceededError, so that a
+	// server can shed load. The stream cannot be resumed, since the record
+	// is left unread.
+	BudgetReject
+)
+
+// A BudgetExceededError reports a record refused under BudgetReject. See
+// WithMemoryBudget.
+type BudgetExceededError struct {
+	Needed    int64
+	Available int64
+}
+
+func (e BudgetExceededError) Error() string {
+	return fmt.Sprintf("memory budget exceeded: record needs %d bytes, %d "+
+		"available",
+		e.Needed, e.Available,
+	)
+}
+
+// A MemoryBudget bounds the memory held in records by the Decoders that share
+// it, such as those of the streams of every client of a server. See
+// WithMemoryBudget. It is safe for concurrent use by multiple goroutines.
+type MemoryBudget struct {
+	limit    int64
+	mutex    sync.Mutex
+	released *sync.Cond
+	inUse    int64
+}
+
+// NewMemoryBudget returns a new MemoryBudget of limit bytes. The budget is
+// soft: a record larger than the limit is admitted while no other holds
+// memory, rather than never, and the memory taken by decompression and other
+// transformations of values is not counted.
+func NewMemoryBudget(limit int64) (b *MemoryBudget) {
+	b = &MemoryBudget{
+		limit: limit,
+	}
+
+	b.released = sync.NewCond(&b.mutex)
+
+	return
+}
+
+// InUse returns the number of bytes of the MemoryBudget currently held.
+func (b *MemoryBudget) InUse() int64 {
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	return b.inUse
+}
+
+func (b *MemoryBudget) acquire(n int64, policy BudgetPolicy) (e error) {
+	// Takes n bytes from the budget, waiting for them under BudgetBlock.
+
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	for b.inUse > 0 && b.inUse+n > b.limit {
+		if policy == BudgetReject {
+			return BudgetExceededError{
+				Needed:    n,
+				Available: max(b.limit-b.inUse, 0),
+			}
+		}
+
+		b.released.Wait()
+	}
+
+	b.inUse += n
+
+	return
+}
+
+func (b *MemoryBudget) release(n int64) {
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	b.inUse -= n
+
+	b.released.Broadcast()
+
+	return
+}
+
+func (d *Decoder) reserveMemory(n int64) (e error) {
+	// Takes n bytes from the MemoryBudget of d, if any, for the record about
+	// to be read. The caller must hold d.mutex.
+
+	if d.options.memoryBudget == nil || n == 0 {
+		return
+	}
+
+	e = d.options.memoryBudget.acquire(n, d.options.budgetPolicy)
+	if e != nil {
+		return
+	}
+
+	d.budgeted = n
+
+	return
+}
+
+func (d *Decoder) releaseMemory() {
+	// Returns to the MemoryBudget of d the bytes held for the record last
+	// read. The caller must hold d.mutex.
+
+	if d.budgeted == 0 {
+		return
+	}
+
+	d.options.memoryBudget.release(d.budgeted)
+
+	d.budgeted = 0
+
+	return
+}
@@ -0,0 +1,131 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	var (
+		budget   = NewMemoryBudget(10)
+		decoded  = make(chan error)
+		e        error
+		exceeded BudgetExceededError
+		first    *Decoder
+		key      []byte
+	)
+
+	first = NewDecoder(
+		bytes.NewReader(
+			encodeBudgetTestStream(t, "key", "val"), // 6 bytes per record
+		),
+		nil,
+		WithMemoryBudget(budget, BudgetBlock),
+	)
+
+	_, _, e = first.Decode()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, int64(6), budget.InUse())
+
+	_, _, e = NewDecoder(
+		bytes.NewReader(
+			encodeBudgetTestStream(t, "key", "val"),
+		),
+		nil,
+		WithMemoryBudget(budget, BudgetReject),
+	).Decode()
+	assert.True(t,
+		errors.As(e, &exceeded),
+	)
+	assert.Equal(t, BudgetExceededError{Needed: 6, Available: 4}, exceeded)
+
+	go func() {
+		var (
+			e error
+		)
+
+		key, _, e = NewDecoder(
+			bytes.NewReader(
+				encodeBudgetTestStream(t, "big", "value.."), // 10 bytes
+			),
+			nil,
+			WithMemoryBudget(budget, BudgetBlock),
+		).Decode()
+
+		decoded <- e
+	}()
+
+	select {
+	case <-decoded:
+		t.Fatal("decoded beyond budget")
+
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	first.Close()
+
+	assert.NoError(t, <-decoded)
+	assert.Equal(t, []byte("big"), key)
+	assert.Equal(t, int64(10), budget.InUse())
+
+	return
+}
+
+func TestMemoryBudgetOversized(t *testing.T) {
+	var (
+		budget  = NewMemoryBudget(4)
+		decoder *Decoder
+		e       error
+	)
+
+	decoder = NewDecoder(
+		bytes.NewReader(
+			encodeBudgetTestStream(t, "key", "val"),
+		),
+		nil,
+		WithMemoryBudget(budget, BudgetReject),
+	)
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e) // admitted while the budget is otherwise free
+
+	assert.Equal(t, int64(6), budget.InUse())
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e) // the first is released first
+
+	_, _, e = decoder.Decode()
+	assert.Error(t, e) // end of stream
+
+	assert.Equal(t, int64(0), budget.InUse())
+
+	return
+}
+
+func encodeBudgetTestStream(t *testing.T, key, val string) []byte {
+	// Returns a stream of two records of the given key and value.
+
+	var (
+		buffer  bytes.Buffer
+		encoder = NewEncoder(&buffer, nil)
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte(key), []byte(val)),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte(key+"2"), []byte(val)),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,287 @@
+package bottledlightning
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A bundle is a tar archive of the artifacts of a backup, such as a stream and
+// its sidecar files, as regular files followed by a last member, named
+// bundleSumsName, listing the SHA-256 digest of each in the format of the
+// sha256sum utility, so that an extracted bundle can also be checked with
+// "sha256sum -c".
+const (
+	bundleSumsName   = "SHA256SUMS"
+	bundleSumsMaxLen = 1 << 20
+)
+
+// A BundleWriter writes a bundle, a tar archive of the artifacts of a backup
+// with a list of their SHA-256 digests for integrity. BundleWriters are not
+// safe for concurrent use by multiple goroutines.
+type BundleWriter struct {
+	tar   *tar.Writer
+	names []string
+	sums  bytes.Buffer
+}
+
+// NewBundleWriter returns a new BundleWriter that writes a bundle to the
+// [io.Writer].
+func NewBundleWriter(writer io.Writer) *BundleWriter {
+	return &BundleWriter{
+		tar: tar.NewWriter(writer),
+	}
+}
+
+// Add adds an artifact of the given name and size, read from the [io.Reader],
+// to the bundle. Names must be unique, and SHA256SUMS is reserved. If Add
+// fails, the bundle is incomplete and should be discarded.
+func (b *BundleWriter) Add(name string, reader io.Reader, size int64) (
+	e error,
+) {
+	defer errorf("could not add artifact to bundle", &e)
+
+	var (
+		digest = sha256.New()
+		n      int64
+	)
+
+	if name == bundleSumsName || slices.Contains(b.names, name) {
+		return fmt.Errorf("name %q unavailable", name)
+	}
+
+	e = b.writeHeader(name, size)
+	if e != nil {
+		return
+	}
+
+	n, e = io.Copy(io.MultiWriter(b.tar, digest),
+		io.LimitReader(reader, size),
+	)
+	if e != nil {
+		return
+	}
+
+	if n < size {
+		return fmt.Errorf("artifact %q shorter than size: %w", name,
+			io.ErrUnexpectedEOF,
+		)
+	}
+
+	b.names = append(b.names, name)
+
+	fmt.Fprintf(&b.sums, "%x  %s\n", digest.Sum(nil), name)
+
+	return
+}
+
+// Close writes the list of digests and the end of the archive. It does not
+// close the underlying [io.Writer].
+func (b *BundleWriter) Close() (e error) {
+	defer errorf("could not close bundle", &e)
+
+	e = b.writeHeader(bundleSumsName,
+		int64(b.sums.Len()),
+	)
+	if e != nil {
+		return
+	}
+
+	_, e = b.tar.Write(
+		b.sums.Bytes(),
+	)
+	if e != nil {
+		return
+	}
+
+	e = b.tar.Close()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (b *BundleWriter) writeHeader(name string, size int64) error {
+	// Begins a member of the archive for a regular file.
+
+	return b.tar.WriteHeader(
+		&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0o644,
+			ModTime:  time.Now(),
+			Format:   tar.FormatPAX,
+		},
+	)
+}
+
+// A BundleReader reads the artifacts of a bundle written by a BundleWriter in
+// turn, verifying their digests. Artifacts are verified together at the end of
+// the bundle, so their contents should not be trusted until Next returns
+// [io.EOF]. BundleReaders are not safe for concurrent use by multiple
+// goroutines.
+type BundleReader struct {
+	tar    *tar.Reader
+	name   string
+	digest hash.Hash
+	member io.Reader
+	sums   map[string]string
+	done   bool
+}
+
+// NewBundleReader returns a new BundleReader that reads a bundle from the
+// [io.Reader].
+func NewBundleReader(reader io.Reader) *BundleReader {
+	return &BundleReader{
+		tar:  tar.NewReader(reader),
+		sums: make(map[string]string),
+	}
+}
+
+// Next advances to the next artifact of the bundle, which Read then reads, and
+// returns its name. The rest of the current artifact, if any, is skipped. At
+// the end of the bundle, Next verifies the digests of all artifacts and
+// returns [io.EOF] if they match.
+func (b *BundleReader) Next() (name string, e error) {
+	defer errorf("could not read bundle", &e)
+
+	var (
+		header *tar.Header
+	)
+
+	if b.done {
+		return "", io.EOF
+	}
+
+	e = b.finish()
+	if e != nil {
+		return
+	}
+
+	header, e = b.tar.Next()
+	if e == io.EOF {
+		return "", fmt.Errorf("bundle ended without %s: %w", bundleSumsName,
+			io.ErrUnexpectedEOF,
+		)
+	}
+
+	if e != nil {
+		return
+	}
+
+	switch {
+	case header.Typeflag != tar.TypeReg:
+		return "", fmt.Errorf("member %q not a regular file", header.Name)
+
+	case header.Name == bundleSumsName:
+		e = b.verify()
+		if e != nil {
+			return
+		}
+
+		b.done = true
+
+		return "", io.EOF
+
+	case b.sums[header.Name] != "":
+		return "", fmt.Errorf("duplicate artifact %q", header.Name)
+	}
+
+	b.name = header.Name
+	b.digest = sha256.New()
+	b.member = io.TeeReader(b.tar, b.digest)
+
+	return b.name, nil
+}
+
+// Read implements [io.Reader], reading the current artifact.
+func (b *BundleReader) Read(p []byte) (n int, e error) {
+	if b.member == nil {
+		return 0, io.EOF
+	}
+
+	return b.member.Read(p)
+}
+
+func (b *BundleReader) finish() (e error) {
+	// Reads the rest of the current artifact, if any, and notes its digest.
+
+	if b.member == nil {
+		return
+	}
+
+	_, e = io.Copy(io.Discard, b.member)
+	if e != nil {
+		return
+	}
+
+	b.sums[b.name] = hex.EncodeToString(
+		b.digest.Sum(nil),
+	)
+
+	b.member = nil
+
+	return
+}
+
+func (b *BundleReader) verify() (e error) {
+	// Reads the list of digests and checks it against those computed, and
+	// checks that the list ends the bundle.
+
+	var (
+		listed  = make(map[string]bool)
+		name    string
+		ok      bool
+		scanner = bufio.NewScanner(
+			io.LimitReader(b.tar, bundleSumsMaxLen),
+		)
+		sum string
+	)
+
+	for scanner.Scan() {
+		sum, name, ok = strings.Cut(scanner.Text(), "  ")
+
+		switch {
+		case !ok:
+			return fmt.Errorf("malformed %s", bundleSumsName)
+
+		case b.sums[name] == "":
+			return fmt.Errorf("artifact %q missing", name)
+
+		case b.sums[name] != sum:
+			return fmt.Errorf("digest of artifact %q does not match", name)
+		}
+
+		listed[name] = true
+	}
+
+	e = scanner.Err()
+	if e != nil {
+		return
+	}
+
+	for name = range b.sums {
+		if !listed[name] {
+			return fmt.Errorf("artifact %q not listed in %s", name,
+				bundleSumsName,
+			)
+		}
+	}
+
+	_, e = b.tar.Next()
+	if e != io.EOF {
+		return fmt.Errorf("members follow %s", bundleSumsName)
+	}
+
+	return nil
+}
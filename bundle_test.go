@@ -0,0 +1,86 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundle(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		contents []byte
+		e        error
+		name     string
+		reader   *BundleReader
+		writer   = NewBundleWriter(&buffer)
+	)
+
+	assert.NoError(t,
+		writer.Add("stream", strings.NewReader("records"), 7),
+	)
+
+	assert.NoError(t,
+		writer.Add("manifest", strings.NewReader("{}"), 2),
+	)
+
+	assert.Error(t,
+		writer.Add("stream", strings.NewReader("again"), 5),
+	)
+
+	assert.NoError(t, writer.Close())
+
+	reader = NewBundleReader(bytes.NewReader(buffer.Bytes()))
+
+	name, e = reader.Next()
+
+	assert.NoError(t, e)
+	assert.Equal(t, "stream", name)
+
+	contents, e = io.ReadAll(reader)
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("records"), contents)
+
+	name, e = reader.Next()
+
+	assert.NoError(t, e)
+	assert.Equal(t, "manifest", name)
+
+	_, e = reader.Next()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	_, e = reader.Next()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	buffer.Bytes()[bytes.Index(buffer.Bytes(), []byte("records"))] ^= 1
+
+	reader = NewBundleReader(bytes.NewReader(buffer.Bytes()))
+
+	for e = nil; e == nil; {
+		_, e = reader.Next()
+	}
+
+	assert.ErrorContains(t, e, `digest of artifact "stream" does not match`)
+
+	assert.Error(t,
+		NewBundleWriter(io.Discard).Add("short", strings.NewReader("abc"), 4),
+	)
+
+	reader = NewBundleReader(
+		bytes.NewReader(buffer.Bytes()[:buffer.Len()-2048]),
+	)
+
+	for e = nil; e == nil; {
+		_, e = reader.Next()
+	}
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	return
+}
@@ -0,0 +1,209 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// An LMDBTxn is a write transaction of lmdb-go, as is an *lmdb.Txn over
+// databases identified by DBI, an lmdb.DBI, or of any bindings with the same
+// methods.
+type LMDBTxn[DBI any] interface {
+	Put(dbi DBI, key, val []byte, flags uint) error
+	Del(dbi DBI, key, val []byte) error
+	Commit() error
+	Abort()
+}
+
+// A CaptureTxn wraps an LMDBTxn, taking the place of the transaction for the
+// writes of an application, and tees its puts and deletions into an Encoder
+// once committed, as does a MirrorSink, so that the stream records exactly
+// the changes committed, in order of commit, at the cost of replacing the
+// calls to Put, Del, Commit and Abort of the application:
+//
+//	runtime.LockOSThread()
+//	defer runtime.UnlockOSThread()
+//
+//	txn, e = env.BeginTxn(nil, 0)
+//	...
+//	capture = bl.NewCaptureTxn[lmdb.DBI](txn, encoder, names)
+//	defer capture.Abort()
+//
+//	e = capture.Put(dbi, key, val, 0)
+//	...
+//	e = capture.Commit()
+//
+// Reads go to the transaction itself. Puts with lmdb.Reserve, whose values
+// are written after the call, and writes by cursor are not captured.
+//
+// A CaptureTxn serves a single transaction and is not safe for concurrent
+// use, but any number of them, and of MirrorSinks, may share an Encoder.
+type CaptureTxn[DBI comparable] struct {
+	txn     LMDBTxn[DBI]
+	encoder *Encoder
+	names   map[DBI]string
+	changes []captureChange[DBI]
+	done    bool
+}
+
+type captureChange[DBI comparable] struct {
+	dbi     DBI
+	key     []byte
+	val     []byte
+	deleted bool
+}
+
+// NewCaptureTxn returns a CaptureTxn writing to the LMDBTxn and teeing its
+// changes into the Encoder. If names is not nil, the records of each
+// database are tagged by SetSource with its name in names, the empty string
+// if absent, as that of the main database.
+func NewCaptureTxn[DBI comparable](txn LMDBTxn[DBI], encoder *Encoder,
+	names map[DBI]string,
+) *CaptureTxn[DBI] {
+	return &CaptureTxn[DBI]{
+		txn:     txn,
+		encoder: encoder,
+		names:   names,
+	}
+}
+
+// Put stores the value under the key in the database of the transaction, as
+// directed by the flags of lmdb-go, and holds the change for the stream until
+// Commit if it succeeds.
+func (t *CaptureTxn[DBI]) Put(dbi DBI, key, val []byte, flags uint) (
+	e error,
+) {
+	defer errorf("could not put to captured transaction", &e)
+
+	if t.done {
+		return fmt.Errorf("transaction committed or aborted")
+	}
+
+	e = t.txn.Put(dbi, key, val, flags)
+	if e != nil {
+		return
+	}
+
+	t.changes = append(t.changes,
+		captureChange[DBI]{
+			dbi: dbi,
+			key: bytes.Clone(key),
+			val: bytes.Clone(val),
+		},
+	)
+
+	return
+}
+
+// Del removes the key from the database of the transaction, or only its
+// duplicate val if val is not nil, and holds the change for the stream until
+// Commit if it succeeds, as a tombstone carrying val.
+func (t *CaptureTxn[DBI]) Del(dbi DBI, key, val []byte) (e error) {
+	defer errorf("could not delete from captured transaction", &e)
+
+	if t.done {
+		return fmt.Errorf("transaction committed or aborted")
+	}
+
+	e = t.txn.Del(dbi, key, val)
+	if e != nil {
+		return
+	}
+
+	t.changes = append(t.changes,
+		captureChange[DBI]{
+			dbi:     dbi,
+			key:     bytes.Clone(key),
+			val:     bytes.Clone(val),
+			deleted: true,
+		},
+	)
+
+	return
+}
+
+// Commit commits the transaction and then encodes its changes, in the order in
+// which they were made, between the markers of Encoder.BeginTransaction and
+// CommitTransaction, so that they can be applied downstream at once. Commits
+// sharing an Encoder are serialised, so that the changes of each are contiguous
+// in the stream. If the transaction fails to commit, nothing is encoded; if
+// encoding fails, the transaction remains committed but the stream lacks some
+// of its changes, and the Encoder should be aborted.
+func (t *CaptureTxn[DBI]) Commit() (e error) {
+	defer errorf("could not commit captured transaction", &e)
+
+	var (
+		change captureChange[DBI]
+		xmv    XMetaValue
+	)
+
+	if t.done {
+		return fmt.Errorf("transaction committed or aborted")
+	}
+
+	t.done = true
+
+	t.encoder.mirrorMutex.Lock()
+
+	defer t.encoder.mirrorMutex.Unlock()
+
+	e = t.txn.Commit()
+	if e != nil {
+		return
+	}
+
+	if len(t.changes) == 0 {
+		return
+	}
+
+	e = t.encoder.BeginTransaction(nil)
+	if e != nil {
+		return
+	}
+
+	for _, change = range t.changes {
+		if t.names != nil {
+			e = t.encoder.SetSource(t.names[change.dbi])
+			if e != nil {
+				return
+			}
+		}
+
+		xmv = 0
+
+		if change.deleted {
+			xmv = NewXMetaValue(0, XMetaFlagTombstone)
+		}
+
+		e = t.encoder.EncodeX(change.key, change.val, xmv)
+		if e != nil {
+			return
+		}
+	}
+
+	e = t.encoder.CommitTransaction()
+	if e != nil {
+		return
+	}
+
+	t.changes = nil
+
+	return
+}
+
+// Abort aborts the transaction and discards its changes. It does nothing if
+// the transaction has been committed or aborted already, so that it may be
+// deferred.
+func (t *CaptureTxn[DBI]) Abort() {
+	if t.done {
+		return
+	}
+
+	t.done = true
+
+	t.txn.Abort()
+
+	t.changes = nil
+
+	return
+}
@@ -0,0 +1,139 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A dbiTxn is an LMDBTxn over maps, one per database, standing in for an
+// *lmdb.Txn.
+type dbiTxn struct {
+	txns map[uint32]*mapTxn
+	fail error
+}
+
+func (t *dbiTxn) Put(dbi uint32, key, val []byte, flags uint) error {
+	return t.txns[dbi].Put(key, val)
+}
+
+func (t *dbiTxn) Del(dbi uint32, key, val []byte) error {
+	return t.txns[dbi].Del(key)
+}
+
+func (t *dbiTxn) Commit() error {
+	var (
+		txn *mapTxn
+	)
+
+	if t.fail != nil {
+		return t.fail
+	}
+
+	for _, txn = range t.txns {
+		txn.Commit()
+	}
+
+	return nil
+}
+
+func (t *dbiTxn) Abort() {
+	return
+}
+
+func newDBITxn(main, users map[string]string) *dbiTxn {
+	return &dbiTxn{
+		txns: map[uint32]*mapTxn{
+			1: newMapTxn(main),
+			2: newMapTxn(users),
+		},
+	}
+}
+
+func TestCaptureTxn(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		capture *CaptureTxn[uint32]
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		key     []byte
+		main    = map[string]string{"a": "0"}
+		names   = map[uint32]string{2: "users"}
+		records []string
+		txn     *dbiTxn
+		users   = map[string]string{}
+		val     []byte
+		xmv     byte
+	)
+
+	capture = NewCaptureTxn(newDBITxn(main, users), encoder, names)
+
+	assert.NoError(t,
+		capture.Put(2, []byte("u"), []byte("1"), 0),
+	)
+	assert.NoError(t,
+		capture.Del(1, []byte("a"), nil),
+	)
+	assert.NoError(t,
+		capture.Commit(),
+	)
+	assert.Error(t,
+		capture.Put(2, []byte("v"), []byte("2"), 0),
+	)
+
+	// Neither an aborted transaction nor one that fails to commit reaches
+	// the stream.
+
+	capture = NewCaptureTxn(newDBITxn(main, users), encoder, names)
+
+	assert.NoError(t,
+		capture.Put(2, []byte("v"), []byte("2"), 0),
+	)
+
+	capture.Abort()
+	capture.Abort()
+
+	txn = newDBITxn(main, users)
+	txn.fail = errors.New("map full")
+
+	capture = NewCaptureTxn(txn, encoder, names)
+
+	assert.NoError(t,
+		capture.Put(1, []byte("w"), []byte("3"), 0),
+	)
+	assert.ErrorIs(t, capture.Commit(), txn.fail)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.Equal(t, map[string]string{}, main)
+	assert.Equal(t, map[string]string{"u": "1"}, users)
+
+	decoder = NewDecoder(&buffer, nil)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		if XMetaValue(xmv).HasFlag(XMetaFlagTombstone) {
+			val = []byte("deleted")
+		}
+
+		records = append(records,
+			decoder.Source()+"/"+string(key)+" "+string(val),
+		)
+	}
+
+	assert.Equal(t, []string{"users/u 1", "/a deleted"}, records)
+
+	return
+}
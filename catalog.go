@@ -0,0 +1,421 @@
+package bottledlightning
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name under which a manifest is found by OpenCatalog,
+// whether as a file or as an artifact of a bundle.
+const ManifestName = "manifest.json"
+
+// A Catalog indexes the snapshots of a backup directory, or of a bucket
+// presented as an [io/fs.FS], by their manifests, so as to tell which
+// snapshots can be restored, and from which files. Snapshots are related by
+// the Parent of their manifests: a full snapshot has none, and an increment
+// is restored by loading its ancestors first. Catalogs are safe for
+// concurrent use by multiple goroutines, being immutable once opened.
+type Catalog struct {
+	fsys      fs.FS
+	entries   []CatalogEntry // by Created
+	snapshots map[string]int // indices into entries, by Snapshot
+}
+
+// A CatalogEntry is a snapshot found by OpenCatalog.
+type CatalogEntry struct {
+	Manifest Manifest
+
+	// Path is the path of the bundle holding the manifest and streams of the
+	// snapshot if Bundle is true, or else of its manifest, whose streams are
+	// files of the same directory named as in the manifest.
+	Path   string
+	Bundle bool
+
+	// Missing lists the paths of streams described by the manifest but
+	// absent from the directory.
+	Missing []string
+}
+
+// Restorable returns an error if the snapshot is incomplete by itself: if any
+// of its streams is missing, or aborted. Its ancestors are not considered;
+// see Catalog.Chain.
+func (c CatalogEntry) Restorable() (e error) {
+	var (
+		stream ManifestStream
+	)
+
+	if len(c.Missing) > 0 {
+		return fmt.Errorf("snapshot %q misses stream %q", c.Manifest.Snapshot,
+			c.Missing[0],
+		)
+	}
+
+	for _, stream = range c.Manifest.Streams {
+		if stream.Aborted {
+			return fmt.Errorf("stream %q of snapshot %q aborted", stream.Name,
+				c.Manifest.Snapshot,
+			)
+		}
+	}
+
+	return
+}
+
+// Files returns the paths of the files holding the snapshot, namely its bundle
+// or its manifest followed by its streams, in the order of the manifest.
+func (c CatalogEntry) Files() (paths []string) {
+	var (
+		stream ManifestStream
+	)
+
+	paths = []string{c.Path}
+
+	if c.Bundle {
+		return
+	}
+
+	for _, stream = range c.Manifest.Streams {
+		paths = append(paths,
+			path.Join(
+				path.Dir(c.Path), stream.Name,
+			),
+		)
+	}
+
+	return
+}
+
+// OpenCatalog walks the [io/fs.FS] and returns a Catalog of the snapshots
+// described by the manifests found, as files named ManifestName, or as
+// artifacts of that name in bundles, taken to be the files named with the
+// suffix ".tar", which are read in full so as to verify their digests. An
+// error is returned if a manifest or bundle is invalid, or if two manifests
+// describe the same snapshot.
+func OpenCatalog(fsys fs.FS) (c *Catalog, e error) {
+	defer errorf("could not open catalog", &e)
+
+	var (
+		entry CatalogEntry
+		i     int
+	)
+
+	c = &Catalog{
+		fsys:      fsys,
+		snapshots: make(map[string]int),
+	}
+
+	e = fs.WalkDir(fsys, ".",
+		func(name string, d fs.DirEntry, e error) error {
+			var (
+				found bool
+			)
+
+			if e != nil || d.IsDir() {
+				return e
+			}
+
+			switch {
+			case d.Name() == ManifestName:
+				entry, e = readCatalogManifest(fsys, name)
+
+			case strings.HasSuffix(name, ".tar"):
+				entry, found, e = readCatalogBundle(fsys, name)
+				if !found {
+					return e
+				}
+
+			default:
+				return nil
+			}
+
+			if e != nil {
+				return fmt.Errorf("%s: %w", name, e)
+			}
+
+			c.entries = append(c.entries, entry)
+
+			return nil
+		},
+	)
+	if e != nil {
+		return nil, e
+	}
+
+	slices.SortStableFunc(c.entries,
+		func(a, b CatalogEntry) int {
+			return a.Manifest.Created.Compare(b.Manifest.Created)
+		},
+	)
+
+	for i, entry = range c.entries {
+		_, e = c.entry(entry.Manifest.Snapshot)
+		if e == nil {
+			return nil, fmt.Errorf("snapshot %q described by %s and %s",
+				entry.Manifest.Snapshot,
+				c.entries[c.snapshots[entry.Manifest.Snapshot]].Path,
+				entry.Path,
+			)
+		}
+
+		c.snapshots[entry.Manifest.Snapshot] = i
+	}
+
+	return c, nil
+}
+
+func readCatalogManifest(fsys fs.FS, name string) (
+	entry CatalogEntry, e error,
+) {
+	// Reads the manifest of the given path, and notes its missing streams.
+
+	var (
+		file   fs.File
+		stream string
+	)
+
+	file, e = fsys.Open(name)
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	entry.Path = name
+
+	entry.Manifest, e = ReadManifest(file)
+	if e != nil {
+		return
+	}
+
+	for _, stream = range entry.Files()[1:] {
+		_, e = fs.Stat(fsys, stream)
+		if errors.Is(e, fs.ErrNotExist) {
+			entry.Missing = append(entry.Missing, stream)
+
+			e = nil
+
+			continue
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func readCatalogBundle(fsys fs.FS, name string) (
+	entry CatalogEntry, found bool, e error,
+) {
+	// Reads the manifest of the bundle of the given path, if it has one, and
+	// notes its missing streams.
+
+	var (
+		artifact string
+		bundle   *BundleReader
+		file     fs.File
+		present  = make(map[string]bool)
+		stream   ManifestStream
+	)
+
+	file, e = fsys.Open(name)
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	entry.Path = name
+	entry.Bundle = true
+
+	bundle = NewBundleReader(file)
+
+	for {
+		artifact, e = bundle.Next()
+		if errors.Is(e, io.EOF) {
+			e = nil
+
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		if artifact != ManifestName {
+			present[artifact] = true
+
+			continue
+		}
+
+		entry.Manifest, e = ReadManifest(bundle)
+		if e != nil {
+			return
+		}
+
+		found = true
+	}
+
+	if !found {
+		return
+	}
+
+	for _, stream = range entry.Manifest.Streams {
+		if !present[stream.Name] {
+			entry.Missing = append(entry.Missing, stream.Name)
+		}
+	}
+
+	return
+}
+
+// Snapshots returns the snapshots of the catalog, in order of creation.
+func (c *Catalog) Snapshots() []CatalogEntry {
+	return slices.Clone(c.entries)
+}
+
+// Dependents returns the snapshots that are increments of the given snapshot,
+// directly or not, in order of creation, which cannot be restored without it.
+func (c *Catalog) Dependents(snapshot string) (dependents []CatalogEntry) {
+	var (
+		ancestors = map[string]bool{snapshot: true}
+		entry     CatalogEntry
+	)
+
+	// Parents are created before their increments, so a single pass in
+	// order of creation finds every descendant.
+
+	for _, entry = range c.entries {
+		if entry.Manifest.Parent != "" && ancestors[entry.Manifest.Parent] {
+			ancestors[entry.Manifest.Snapshot] = true
+
+			dependents = append(dependents, entry)
+		}
+	}
+
+	return
+}
+
+// Chain returns the snapshots to be restored, in order, so as to restore the
+// given snapshot: a full snapshot followed by its increments up to and
+// including the given snapshot. An error is returned if any of them is
+// missing or not restorable, or belongs to another environment.
+func (c *Catalog) Chain(snapshot string) (chain []CatalogEntry, e error) {
+	var (
+		entry CatalogEntry
+		seen  = make(map[string]bool)
+	)
+
+	for snapshot != "" {
+		if seen[snapshot] {
+			return nil, fmt.Errorf("snapshot %q is its own ancestor", snapshot)
+		}
+
+		seen[snapshot] = true
+
+		entry, e = c.entry(snapshot)
+		if e != nil {
+			return nil, e
+		}
+
+		e = entry.Restorable()
+		if e != nil {
+			return nil, e
+		}
+
+		if len(chain) > 0 &&
+			entry.Manifest.Environment != chain[0].Manifest.Environment {
+			return nil, fmt.Errorf("snapshot %q of environment %q has parent "+
+				"%q of environment %q", chain[0].Manifest.Snapshot,
+				chain[0].Manifest.Environment, snapshot,
+				entry.Manifest.Environment,
+			)
+		}
+
+		chain = append([]CatalogEntry{entry}, chain...)
+
+		snapshot = entry.Manifest.Parent
+	}
+
+	return
+}
+
+// Latest returns the chain, as by Chain, of the latest restorable snapshot of
+// the environment created no later than at, so as to restore the environment
+// as it was at that time. A zero time means the latest of all.
+func (c *Catalog) Latest(environment string, at time.Time) (
+	chain []CatalogEntry, e error,
+) {
+	var (
+		entry CatalogEntry
+		i     int
+	)
+
+	for i = len(c.entries) - 1; i >= 0; i-- {
+		entry = c.entries[i]
+
+		switch {
+		case entry.Manifest.Environment != environment:
+			continue
+
+		case !at.IsZero() && entry.Manifest.Created.After(at):
+			continue
+		}
+
+		chain, e = c.Chain(entry.Manifest.Snapshot)
+		if e == nil {
+			return
+		}
+	}
+
+	return nil, fmt.Errorf("no restorable snapshot of environment %q: %w",
+		environment, fs.ErrNotExist,
+	)
+}
+
+// Files returns the paths of the files needed to restore the environment as
+// it was at the given time, as by Latest, in the order of restoration.
+func (c *Catalog) Files(environment string, at time.Time) (
+	paths []string, e error,
+) {
+	var (
+		chain []CatalogEntry
+		entry CatalogEntry
+	)
+
+	chain, e = c.Latest(environment, at)
+	if e != nil {
+		return
+	}
+
+	for _, entry = range chain {
+		paths = append(paths,
+			entry.Files()...,
+		)
+	}
+
+	return
+}
+
+func (c *Catalog) entry(snapshot string) (entry CatalogEntry, e error) {
+	var (
+		i  int
+		ok bool
+	)
+
+	i, ok = c.snapshots[snapshot]
+	if !ok {
+		return entry, fmt.Errorf("snapshot %q not found: %w", snapshot,
+			fs.ErrNotExist,
+		)
+	}
+
+	return c.entries[i], nil
+}
@@ -0,0 +1,156 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	var (
+		bundle  bytes.Buffer
+		catalog *Catalog
+		chain   []CatalogEntry
+		e       error
+		epoch   = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		fsys    = fstest.MapFS{
+			"full/main.bl":   &fstest.MapFile{},
+			"incr1/main.bl":  &fstest.MapFile{},
+			"broken/main.bl": &fstest.MapFile{},
+			"other.txt":      &fstest.MapFile{},
+		}
+		manifest *fstest.MapFile
+		paths    []string
+		writer   = NewBundleWriter(&bundle)
+	)
+
+	fsys["full/manifest.json"] = catalogTestManifest(t, "full", "",
+		epoch, false,
+	)
+	fsys["incr1/manifest.json"] = catalogTestManifest(t, "incr1", "full",
+		epoch.Add(time.Hour), false,
+	)
+	fsys["broken/manifest.json"] = catalogTestManifest(t, "broken", "incr2",
+		epoch.Add(3*time.Hour), true,
+	)
+
+	assert.NoError(t,
+		writer.Add("main.bl", strings.NewReader(""), 0),
+	)
+	manifest = catalogTestManifest(t, "incr2", "incr1",
+		epoch.Add(2*time.Hour), false,
+	)
+
+	assert.NoError(t,
+		writer.Add(ManifestName, bytes.NewReader(manifest.Data),
+			int64(len(manifest.Data)),
+		),
+	)
+	assert.NoError(t,
+		writer.Close(),
+	)
+
+	fsys["incr2.tar"] = &fstest.MapFile{
+		Data: bundle.Bytes(),
+	}
+
+	catalog, e = OpenCatalog(fsys)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	assert.Len(t,
+		catalog.Snapshots(), 4,
+	)
+	assert.Len(t,
+		catalog.Dependents("full"), 3,
+	)
+
+	// The latest snapshot has an aborted stream, so the latest restorable
+	// one is its parent, held in a bundle.
+
+	chain, e = catalog.Latest("env", time.Time{})
+	assert.NoError(t, e)
+
+	if assert.Len(t, chain, 3) {
+		assert.Equal(t, "incr2", chain[2].Manifest.Snapshot)
+		assert.True(t, chain[2].Bundle)
+	}
+
+	paths, e = catalog.Files("env", epoch.Add(90*time.Minute))
+	assert.NoError(t, e)
+	assert.Equal(t,
+		[]string{
+			"full/manifest.json", "full/main.bl",
+			"incr1/manifest.json", "incr1/main.bl",
+		},
+		paths,
+	)
+
+	_, e = catalog.Latest("env", epoch.Add(-time.Hour))
+	assert.ErrorIs(t, e, fs.ErrNotExist)
+
+	_, e = catalog.Latest("other", time.Time{})
+	assert.ErrorIs(t, e, fs.ErrNotExist)
+
+	_, e = catalog.Chain("broken")
+	assert.Error(t, e)
+
+	_, e = catalog.Chain("missing")
+	assert.ErrorIs(t, e, fs.ErrNotExist)
+
+	// A snapshot whose stream is absent is not restorable, and neither are
+	// its increments.
+
+	delete(fsys, "full/main.bl")
+
+	catalog, e = OpenCatalog(fsys)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	_, e = catalog.Latest("env", time.Time{})
+	assert.ErrorIs(t, e, fs.ErrNotExist)
+
+	fsys["copy/manifest.json"] = fsys["full/manifest.json"]
+
+	_, e = OpenCatalog(fsys)
+	assert.Error(t, e)
+
+	return
+}
+
+func catalogTestManifest(t *testing.T, snapshot, parent string,
+	created time.Time, aborted bool,
+) *fstest.MapFile {
+	var (
+		buffer bytes.Buffer
+	)
+
+	assert.NoError(t,
+		WriteManifest(&buffer,
+			Manifest{
+				Environment: "env",
+				Snapshot:    snapshot,
+				Parent:      parent,
+				Created:     created,
+				Streams: []ManifestStream{
+					{
+						Name:    "main.bl",
+						SHA256:  strings.Repeat("0", 64),
+						Aborted: aborted,
+					},
+				},
+			},
+		),
+	)
+
+	return &fstest.MapFile{
+		Data: buffer.Bytes(),
+	}
+}
@@ -0,0 +1,380 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"time"
+)
+
+// A ChangePoller is a best-effort source of changes to a store without a
+// change feed, such as LMDB. It polls the store, opening a snapshot every
+// interval, and transmits to an Encoder the records put since the previous
+// snapshot, and tombstones, marked with XMetaFlagTombstone, of those deleted,
+// for consumers such as an InvalidationFeed, without changes to the
+// application writing the store.
+//
+// The poller divides the keys into ranges of about RangeLen records, and
+// keeps the SHA-256 digest of each range and the previous snapshot open. A
+// poll reads the new snapshot in full, but only to digest its ranges; the
+// records of the ranges whose digests differ are then compared between the
+// snapshots, and only those changed are transmitted. Every poll with changes
+// ends with a checkpoint marker identifying it by its number, eight bytes
+// big-endian, from one, so that the changes of a poll can be applied at once.
+//
+// Changes are observed at the granularity of polls: a record put and deleted
+// between two polls is not seen, and one changed several times is seen once.
+// As the previous snapshot is held open for an interval, an LMDB writer
+// cannot reuse the pages freed meanwhile, and the environment may grow.
+type ChangePoller struct {
+	// Open opens a snapshot of the current state of the store.
+	Open func() (StoreSnapshot, error)
+
+	// Interval is the time between polls, one second if zero, and RangeLen
+	// the number of records per range, 1024 if zero.
+	Interval time.Duration
+	RangeLen int
+
+	// Initial, if true, has the first snapshot transmitted in full, as the
+	// changes of poll zero, rather than taken as the baseline.
+	Initial bool
+}
+
+// A pollRange is a range of keys of a snapshot, from start, nil for the first
+// range, up to the start of the next range, and the digest of its records.
+type pollRange struct {
+	start  []byte
+	digest [sha256.Size]byte
+}
+
+// Run polls the store until the context is done, and returns its error, or
+// until an error opening or reading a snapshot, or transmitting a change,
+// which it returns. It neither closes nor aborts the Encoder.
+func (p ChangePoller) Run(ctx context.Context, encoder *Encoder) (e error) {
+	defer errorf("could not poll changes", &e)
+
+	var (
+		next     StoreSnapshot
+		previous StoreSnapshot
+		ranges   []pollRange
+		seq      uint64
+		timer    *time.Timer
+	)
+
+	previous, e = p.Open()
+	if e != nil {
+		return
+	}
+
+	defer func() {
+		previous.Close()
+	}()
+
+	_, ranges, e = p.digest(previous, nil)
+	if e != nil {
+		return
+	}
+
+	if p.Initial {
+		e = p.transmit(encoder, previous)
+		if e != nil {
+			return
+		}
+	}
+
+	for {
+		timer = time.NewTimer(
+			cmp.Or(p.Interval, time.Second),
+		)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+
+		case <-timer.C:
+		}
+
+		next, e = p.Open()
+		if e != nil {
+			return
+		}
+
+		seq++
+
+		ranges, e = p.compare(encoder, previous, next, ranges, seq)
+		if e != nil {
+			next.Close()
+
+			return
+		}
+
+		previous.Close()
+		previous = next
+	}
+}
+
+func (p ChangePoller) compare(encoder *Encoder, previous, next StoreSnapshot,
+	ranges []pollRange, seq uint64,
+) (fresh []pollRange, e error) {
+	// Digests the next snapshot, by the ranges of the previous and anew,
+	// transmits the changes of the ranges whose digests differ, and returns
+	// the new ranges.
+
+	var (
+		bounds  = make([][]byte, len(ranges))
+		changed bool
+		digests [][sha256.Size]byte
+		end     []byte
+		i       int
+		n       int
+	)
+
+	for i = range ranges {
+		bounds[i] = ranges[i].start
+	}
+
+	digests, fresh, e = p.digest(next, bounds)
+	if e != nil {
+		return
+	}
+
+	for i = range ranges {
+		if digests[i] == ranges[i].digest {
+			continue
+		}
+
+		end = nil
+
+		if i+1 < len(ranges) {
+			end = ranges[i+1].start
+		}
+
+		n, e = p.diff(encoder, previous, next, ranges[i].start, end)
+		if e != nil {
+			return
+		}
+
+		changed = changed || n > 0
+	}
+
+	if changed {
+		e = encoder.Checkpoint(
+			binary.BigEndian.AppendUint64(nil, seq),
+		)
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (p ChangePoller) digest(s StoreSnapshot, bounds [][]byte) (
+	digests [][sha256.Size]byte, fresh []pollRange, e error,
+) {
+	// Reads the snapshot in full, and returns the digests of its records in
+	// the ranges starting at bounds, and anew in ranges of RangeLen records.
+
+	var (
+		bounded  = sha256.New()
+		fixed    = sha256.New()
+		i        int
+		key      []byte
+		n        int
+		rangeLen = cmp.Or(p.RangeLen, 1024)
+		val      []byte
+	)
+
+	fresh = []pollRange{{}}
+
+	if len(bounds) > 0 {
+		digests = make([][sha256.Size]byte, len(bounds))
+	}
+
+	for key, val, e = s.Seek(nil); e == nil; key, val, e = s.Next() {
+		for i+1 < len(bounds) && bytes.Compare(key, bounds[i+1]) >= 0 {
+			copy(digests[i][:], bounded.Sum(nil))
+			bounded.Reset()
+
+			i++
+		}
+
+		if n > 0 && n%rangeLen == 0 {
+			copy(fresh[len(fresh)-1].digest[:], fixed.Sum(nil))
+			fixed.Reset()
+
+			fresh = append(fresh,
+				pollRange{
+					start: bytes.Clone(key),
+				},
+			)
+		}
+
+		digestRecord(fixed, key, val)
+		digestRecord(bounded, key, val)
+
+		n++
+	}
+
+	if !errors.Is(e, io.EOF) {
+		return nil, nil, e
+	}
+
+	for ; i < len(bounds); i++ {
+		copy(digests[i][:], bounded.Sum(nil))
+		bounded.Reset()
+	}
+
+	copy(fresh[len(fresh)-1].digest[:], fixed.Sum(nil))
+
+	return digests, fresh, nil
+}
+
+func (p ChangePoller) diff(encoder *Encoder, previous, next StoreSnapshot,
+	start, end []byte,
+) (n int, e error) {
+	// Transmits the changes between the snapshots of the records whose keys
+	// are at least start and less than end, unbounded if nil, merging the
+	// records of both in key order.
+
+	var (
+		ka []byte
+		kb []byte
+		va []byte
+		vb []byte
+	)
+
+	ka, va, e = seekRange(previous, start, end)
+	if e != nil {
+		return
+	}
+
+	kb, vb, e = seekRange(next, start, end)
+	if e != nil {
+		return
+	}
+
+	for ka != nil || kb != nil {
+		switch {
+		case ka == nil || kb != nil && bytes.Compare(kb, ka) < 0:
+			e = encoder.Encode(kb, vb)
+			if e != nil {
+				return
+			}
+
+			n++
+
+			kb, vb, e = nextInRange(next, end)
+
+		case kb == nil || bytes.Compare(ka, kb) < 0:
+			e = encoder.EncodeX(ka, nil,
+				NewXMetaValue(0, XMetaFlagTombstone),
+			)
+			if e != nil {
+				return
+			}
+
+			n++
+
+			ka, va, e = nextInRange(previous, end)
+
+		default:
+			if !bytes.Equal(va, vb) {
+				e = encoder.Encode(kb, vb)
+				if e != nil {
+					return
+				}
+
+				n++
+			}
+
+			ka, va, e = nextInRange(previous, end)
+			if e == nil {
+				kb, vb, e = nextInRange(next, end)
+			}
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (p ChangePoller) transmit(encoder *Encoder, s StoreSnapshot) (e error) {
+	// Transmits every record of the snapshot, and the checkpoint marker of
+	// poll zero.
+
+	var (
+		key []byte
+		val []byte
+	)
+
+	for key, val, e = s.Seek(nil); e == nil; key, val, e = s.Next() {
+		e = encoder.Encode(key, val)
+		if e != nil {
+			return
+		}
+	}
+
+	if !errors.Is(e, io.EOF) {
+		return
+	}
+
+	return encoder.Checkpoint(
+		make([]byte, 8),
+	)
+}
+
+func seekRange(s StoreSnapshot, start, end []byte) (k, v []byte, e error) {
+	// Seeks the first record of the range, returning a nil key if it has
+	// none.
+
+	k, v, e = s.Seek(start)
+
+	return endRange(k, v, e, end)
+}
+
+func nextInRange(s StoreSnapshot, end []byte) (k, v []byte, e error) {
+	k, v, e = s.Next()
+
+	return endRange(k, v, e, end)
+}
+
+func endRange(k, v []byte, e error, end []byte) ([]byte, []byte, error) {
+	switch {
+	case errors.Is(e, io.EOF):
+		return nil, nil, nil
+
+	case e != nil:
+		return nil, nil, e
+
+	case end != nil && bytes.Compare(k, end) >= 0:
+		return nil, nil, nil
+	}
+
+	return k, v, nil
+}
+
+func digestRecord(h hash.Hash, key, val []byte) {
+	// Digests the record unambiguously, by prefixing the key with its length.
+
+	h.Write(
+		binary.AppendUvarint(nil, uint64(len(key))),
+	)
+	h.Write(key)
+	h.Write(
+		binary.AppendUvarint(nil, uint64(len(val))),
+	)
+	h.Write(val)
+
+	return
+}
@@ -0,0 +1,138 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangePoller(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		cancel  context.CancelFunc
+		ctx     context.Context
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		id      []byte
+		ids     []byte
+		key     []byte
+		records []string
+		seeks   int
+		states  = []map[string]string{
+			{"a": "1", "b": "1", "c": "1", "d": "1", "e": "1", "f": "1"},
+			{"a": "1", "b": "1", "c": "2", "e": "1", "f": "1", "g": "1"},
+			{"a": "1", "b": "1", "c": "2", "e": "1", "f": "1", "g": "1"},
+			{"a": "1", "b": "1", "bb": "1", "c": "2", "e": "1", "f": "1",
+				"g": "1",
+			},
+		}
+		val []byte
+		xmv byte
+	)
+
+	ctx, cancel = context.WithCancel(
+		context.Background(),
+	)
+
+	defer cancel()
+
+	e = ChangePoller{
+		Open: func() (StoreSnapshot, error) {
+			var (
+				k string
+				s = &fakeSnapshot{
+					vals:  states[0],
+					seeks: &seeks,
+				}
+			)
+
+			for k = range states[0] {
+				s.keys = append(s.keys, k)
+			}
+
+			slices.Sort(s.keys)
+
+			// The last state is kept, should a poll begin before the
+			// cancellation is seen.
+
+			if len(states) == 1 {
+				cancel()
+			} else {
+				states = states[1:]
+			}
+
+			return s, nil
+		},
+		Interval: time.Millisecond,
+		RangeLen: 2,
+		Initial:  true,
+	}.Run(ctx, encoder)
+
+	assert.ErrorIs(t, e, context.Canceled)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// The first snapshot is transmitted in full, and each later poll only
+	// the changes of the ranges whose digests differ, so that the unchanged
+	// ranges are never compared record by record.
+
+	decoder = NewDecoder(
+		bytes.NewReader(buffer.Bytes()), nil,
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		if XMetaValue(xmv).HasFlag(XMetaFlagTombstone) {
+			records = append(records, string(key)+" deleted")
+
+			continue
+		}
+
+		records = append(records, string(key)+"="+string(val))
+	}
+
+	assert.Equal(t,
+		[]string{"a=1", "b=1", "c=1", "d=1", "e=1", "f=1",
+			"c=2", "d deleted", "g=1",
+			"bb=1",
+		},
+		records,
+	)
+	assert.Equal(t, 4, seeks)
+
+	// Polls without changes are not marked.
+
+	decoder = NewDecoder(
+		bytes.NewReader(buffer.Bytes()), nil,
+	)
+
+	for {
+		id, e = decoder.NextCheckpoint()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+		assert.Len(t, id, 8)
+
+		ids = append(ids, id[7])
+	}
+
+	assert.Equal(t, []byte{0, 1, 3}, ids)
+
+	return
+}
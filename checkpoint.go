@@ -0,0 +1,90 @@
+package bottledlightning
+
+type flusher interface {
+	Flush() error
+}
+
+// Checkpoint transmits a control record marking a restart point identified by
+// id, after ending the current batch if the stream is checksummed in batches.
+// It then flushes the underlying [io.Writer], if it implements Flush, as do a
+// [bufio.Writer] and an FECWriter, and syncs it, if it implements Sync, so
+// that the marker and every record before it are durable once Checkpoint
+// returns. See also Decoder.NextCheckpoint.
+func (n *Encoder) Checkpoint(id []byte) (e error) {
+	defer errorf("could not checkpoint", &e)
+
+	var (
+		ok     bool
+		writer flusher
+	)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	if n.features&featureBatchChecksum != 0 {
+		e = n.endBatch()
+		if e != nil {
+			return
+		}
+	}
+
+	e = n.writeControl(controlCheckpoint, id)
+	if e != nil {
+		return
+	}
+
+	writer, ok = n.dest.(flusher)
+	if ok {
+		e = writer.Flush()
+		if e != nil {
+			return
+		}
+	}
+
+	e = n.sync()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// NextCheckpoint receives and discards records up to and including the next
+// checkpoint marker, and returns its identifier, so that the next call to
+// Decode returns the first record after the restart point. It returns
+// [io.EOF] if the stream ends first.
+func (d *Decoder) NextCheckpoint() (id []byte, e error) {
+	defer errorf("could not find checkpoint", &e)
+
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	d.checkpoint = nil
+	d.awaitCheckpoint = true
+
+	defer func() { d.awaitCheckpoint = false }()
+
+	for d.checkpoint == nil {
+		_, _, _, e = d.next()
+		if e != nil {
+			return
+		}
+	}
+
+	return d.checkpoint, nil
+}
+
+func (d *Decoder) receiveCheckpoint(payload []byte) {
+	// Notes a checkpoint marker received in a control record, if awaited.
+
+	if d.awaitCheckpoint {
+		d.checkpoint = append([]byte{}, payload...)
+	}
+}
@@ -0,0 +1,94 @@
+package bottledlightning
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		i       int
+		id      []byte
+		key     []byte
+		writer  = bufio.NewWriter(&buffer)
+	)
+
+	encoder = NewEncoder(writer, fnv.New32a(), WithBatchChecksum(4))
+
+	for i = 0; i < 6; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte(fmt.Sprint(i)), []byte("val")),
+		)
+
+		switch i {
+		case 2:
+			assert.Zero(t, buffer.Len())
+
+			assert.NoError(t,
+				encoder.Checkpoint([]byte("first")),
+			)
+
+			assert.Equal(t, 0, writer.Buffered())
+
+		case 4:
+			assert.NoError(t,
+				encoder.Checkpoint(nil),
+			)
+		}
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.NoError(t,
+		writer.Flush(),
+	)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), fnv.New32a())
+
+	for i = 0; i < 6; i++ {
+		key, _, e = decoder.Decode()
+
+		assert.NoError(t, e)
+		assert.Equal(t, []byte(fmt.Sprint(i)), key)
+	}
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	id, e = decoder.NextCheckpoint()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("first"), id)
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("3"), key)
+
+	id, e = decoder.NextCheckpoint()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte{}, id)
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("5"), key)
+
+	_, e = decoder.NextCheckpoint()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
@@ -0,0 +1,154 @@
+package bottledlightning
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+)
+
+// A Checksum identifies a 32-bit checksum algorithm by which the records of a
+// stream may be verified, for configurations and external metadata that must
+// name the [hash.Hash32] given to an Encoder and its Decoders, and for the
+// stream itself to announce, as configured by WithChecksum. The values are
+// stable and may be stored.
+type Checksum byte
+
+const (
+	// ChecksumNone denotes records without checksums.
+	ChecksumNone Checksum = iota
+
+	// ChecksumCRC32C denotes CRC-32 with the Castagnoli polynomial, computed
+	// by [hash/crc32] with SSE4.2 instructions on amd64 and the CRC32
+	// instructions of ARMv8 on arm64, and so many times faster than FNV-1a
+	// where they are available. It is the recommended checksum. See
+	// NewCRC32C.
+	ChecksumCRC32C
+
+	// ChecksumFNV32a denotes 32-bit FNV-1a, as by [hash/fnv.New32a], which
+	// is computed in pure Go.
+	ChecksumFNV32a
+
+	// ChecksumCRC32 denotes CRC-32 with the IEEE polynomial, as by
+	// [hash/crc32.NewIEEE], which is accelerated much as CRC-32C is, but
+	// detects fewer of the errors to which storage and networks are prone.
+	ChecksumCRC32
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// NewCRC32C returns a new [hash.Hash32] computing CRC-32C, the recommended
+// checksum of records. See ChecksumCRC32C.
+func NewCRC32C() hash.Hash32 {
+	return crc32.New(castagnoli)
+}
+
+// ParseChecksum returns the Checksum of the given name, as returned by
+// Checksum.String, or ChecksumNone if the name is "none" or empty.
+func ParseChecksum(name string) (c Checksum, e error) {
+	if name == "" {
+		return ChecksumNone, nil
+	}
+
+	for c = ChecksumNone; c <= ChecksumCRC32; c++ {
+		if c.String() == name {
+			return
+		}
+	}
+
+	return ChecksumNone, fmt.Errorf("unknown checksum %q", name)
+}
+
+// New returns a new [hash.Hash32] computing the checksum, or nil if it is
+// ChecksumNone or unknown.
+func (c Checksum) New() hash.Hash32 {
+	switch c {
+	case ChecksumCRC32C:
+		return NewCRC32C()
+
+	case ChecksumFNV32a:
+		return fnv.New32a()
+
+	case ChecksumCRC32:
+		return crc32.NewIEEE()
+	}
+
+	return nil
+}
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumNone:
+		return "none"
+
+	case ChecksumCRC32C:
+		return "crc32c"
+
+	case ChecksumFNV32a:
+		return "fnv32a"
+
+	case ChecksumCRC32:
+		return "crc32"
+	}
+
+	return fmt.Sprintf("checksum(%d)", byte(c))
+}
+
+// Checksum returns the checksum announced by the stream so far, as by
+// WithChecksum, and whether one has been announced. It is announced before
+// the first record.
+func (d *Decoder) Checksum() (c Checksum, announced bool) {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.checksum, d.checksumAnnounced
+}
+
+func (n *Encoder) announceChecksum() (e error) {
+	// Announces the checksum of the stream if so configured and if yet to be
+	// announced. The caller must hold n.mutex.
+
+	if n.options.checksum == nil || n.checksumSent {
+		return
+	}
+
+	if (*n.options.checksum == ChecksumNone) != (n.hasher == nil) {
+		return fmt.Errorf("checksum %s does not agree with hasher",
+			*n.options.checksum,
+		)
+	}
+
+	e = n.writeControl(controlChecksum,
+		[]byte{byte(*n.options.checksum)},
+	)
+	if e != nil {
+		return
+	}
+
+	n.checksumSent = true
+
+	return
+}
+
+func (d *Decoder) receiveChecksum(payload []byte) (e error) {
+	// Notes the announced checksum, and verifies the records that follow by
+	// it if the Decoder was given no hash.Hash32 and is not within a batch,
+	// whose checksum would then cover only some of its records. A checksum
+	// unknown to this Decoder, announced by a newer Encoder, is not verified.
+
+	if len(payload) != 1 {
+		return fmt.Errorf("malformed checksum control record")
+	}
+
+	d.checksum = Checksum(payload[0])
+	d.checksumAnnounced = true
+
+	if d.hasher == nil && d.batched == 0 {
+		d.hasher = d.checksum.New()
+	}
+
+	return
+}
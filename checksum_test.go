@@ -0,0 +1,182 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func BenchmarkChecksum(b *testing.B) {
+	var (
+		checksum Checksum
+		val      = bytes.Repeat([]byte("v"), 4096)
+	)
+
+	for checksum = ChecksumCRC32C; checksum <= ChecksumCRC32; checksum++ {
+		b.Run(checksum.String(), func(b *testing.B) {
+			var (
+				hasher = checksum.New()
+				i      int
+			)
+
+			b.SetBytes(
+				int64(len(val)),
+			)
+
+			for i = 0; i < b.N; i++ {
+				hasher.Reset()
+				hasher.Write(val)
+				hasher.Sum32()
+			}
+		})
+	}
+
+	return
+}
+
+func BenchmarkEncoderChecksum(b *testing.B) {
+	var (
+		checksum Checksum
+		key      = []byte("key")
+		val      = bytes.Repeat([]byte("v"), 4096)
+	)
+
+	for checksum = ChecksumNone; checksum <= ChecksumCRC32; checksum++ {
+		b.Run(checksum.String(), func(b *testing.B) {
+			var (
+				e       error
+				encoder = NewEncoder(io.Discard, checksum.New())
+				i       int
+			)
+
+			b.SetBytes(
+				int64(len(key) + len(val)),
+			)
+
+			for i = 0; i < b.N; i++ {
+				e = encoder.Encode(key, val)
+				if e != nil {
+					b.Error(e)
+				}
+			}
+		})
+	}
+
+	return
+}
+
+func TestChecksum(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		checksum Checksum
+		e        error
+		parsed   Checksum
+	)
+
+	for checksum = ChecksumNone; checksum <= ChecksumCRC32; checksum++ {
+		parsed, e = ParseChecksum(
+			checksum.String(),
+		)
+		assert.NoError(t, e)
+		assert.Equal(t, checksum, parsed)
+
+		buffer.Reset()
+
+		assert.NoError(t,
+			NewEncoder(&buffer, checksum.New()).Encode(
+				[]byte("key"), []byte("val"),
+			),
+		)
+
+		_, _, e = NewDecoder(&buffer, checksum.New()).Decode()
+		assert.NoError(t, e)
+	}
+
+	parsed, e = ParseChecksum("")
+	assert.NoError(t, e)
+	assert.Equal(t, ChecksumNone, parsed)
+	assert.Nil(t,
+		ChecksumNone.New(),
+	)
+
+	_, e = ParseChecksum("md5")
+	assert.Error(t, e)
+
+	assert.Equal(t,
+		crc32.Checksum([]byte("val"), crc32.MakeTable(crc32.Castagnoli)),
+		crc32.Checksum([]byte("val"), castagnoli),
+	)
+	assert.Equal(t, uint32(0xe3069283),
+		func() uint32 {
+			var (
+				hasher = NewCRC32C()
+			)
+
+			hasher.Write([]byte("123456789"))
+
+			return hasher.Sum32()
+		}(),
+	) // the check value of CRC-32C
+
+	return
+}
+
+func TestChecksumAnnounced(t *testing.T) {
+	var (
+		announced bool
+		buffer    bytes.Buffer
+		checksum  Checksum
+		decoder   *Decoder
+		e         error
+		encoder   *Encoder
+		stream    []byte
+	)
+
+	encoder = NewEncoder(&buffer, NewCRC32C(),
+		WithChecksum(ChecksumCRC32C),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// A Decoder given no hasher verifies the records by the checksum that
+	// the stream announces.
+
+	decoder = NewDecoder(
+		bytes.NewReader(buffer.Bytes()), nil,
+	)
+
+	_, announced = decoder.Checksum()
+	assert.False(t, announced)
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e)
+
+	checksum, announced = decoder.Checksum()
+	assert.True(t, announced)
+	assert.Equal(t, ChecksumCRC32C, checksum)
+
+	stream = bytes.Clone(
+		buffer.Bytes(),
+	)
+	stream[bytes.Index(stream, []byte("val"))] ^= 0xff
+
+	_, _, e = NewDecoder(bytes.NewReader(stream), nil).Decode()
+	assert.ErrorContains(t, e, "checksum does not match")
+
+	// The announced checksum must agree with the hasher.
+
+	assert.Error(t,
+		NewEncoder(io.Discard, nil, WithChecksum(ChecksumCRC32C)).
+			Encode([]byte("key"), []byte("val")),
+	)
+
+	return
+}
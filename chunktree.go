@@ -0,0 +1,320 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A chunk tree sidecar consists of
+//
+//   - the magic bytes chunkTreeMagic,
+//   - the length of a chunk in 4 bytes and the size of the archive in 8,
+//   - the leaf of every chunk in order, each the SHA-256 digest of a zero byte
+//     followed by the chunk, as in RFC 6962, and
+//   - the length of the signature of the root in 2 bytes, followed by the
+//     signature, if any.
+//
+// Integers are big-endian. The signature is an Ed25519 signature of the magic
+// bytes, the lengths and the root, so that it also binds the chunking.
+const (
+	chunkTreeMagic = "BLMRKL\x00\x01"
+)
+
+// A ChunkTree is a Merkle tree over fixed-size chunks of an archive, such as
+// an encoded stream kept in object storage. Written alongside the archive as a
+// sidecar, and with its root signed, it allows a consumer that fetches a byte
+// range of the archive to verify just that range. A ChunkTree is an
+// [io.Writer] to which the archive is written, such as by way of WithMirror
+// or [io.MultiWriter]. ChunkTrees are not safe for concurrent use by multiple
+// goroutines.
+type ChunkTree struct {
+	chunkLen  int
+	size      int64
+	leaves    [][]byte
+	pending   []byte
+	signature []byte
+	sealed    bool // read from a sidecar, and so complete
+}
+
+// NewChunkTree returns a new, empty ChunkTree with chunks of the given length.
+func NewChunkTree(chunkLen int) (t *ChunkTree, e error) {
+	defer errorf("could not create chunk tree", &e)
+
+	if chunkLen <= 0 || int64(chunkLen) > 1<<32-1 {
+		return nil, fmt.Errorf("invalid chunk length")
+	}
+
+	t = &ChunkTree{
+		chunkLen: chunkLen,
+	}
+
+	return
+}
+
+// Write adds the bytes to the archive that the tree describes. It discards
+// any signature, which no longer applies. A tree read by ReadChunkTree cannot
+// be written.
+func (t *ChunkTree) Write(b []byte) (n int, e error) {
+	var (
+		l int
+	)
+
+	if t.sealed {
+		return 0, fmt.Errorf("could not write to chunk tree: " +
+			"tree read from sidecar",
+		)
+	}
+
+	t.signature = nil
+
+	for n < len(b) {
+		l = min(t.chunkLen-len(t.pending), len(b)-n)
+
+		t.pending = append(t.pending, b[n:n+l]...)
+		t.size += int64(l)
+
+		n += l
+
+		if len(t.pending) == t.chunkLen {
+			t.leaves = append(t.leaves, chunkLeaf(t.pending))
+			t.pending = t.pending[:0]
+		}
+	}
+
+	return
+}
+
+// ChunkLen returns the length of a chunk.
+func (t *ChunkTree) ChunkLen() int {
+	return t.chunkLen
+}
+
+// Size returns the length of the archive.
+func (t *ChunkTree) Size() int64 {
+	return t.size
+}
+
+// Root returns the root of the tree.
+func (t *ChunkTree) Root() []byte {
+	var (
+		leaf []byte
+		tree merkleTree
+	)
+
+	for _, leaf = range t.allLeaves() {
+		tree.add(leaf)
+	}
+
+	return tree.root()
+}
+
+// Sign signs the root of the tree, for the signature to be written with it.
+func (t *ChunkTree) Sign(key ed25519.PrivateKey) {
+	t.signature = ed25519.Sign(key, t.signed())
+
+	return
+}
+
+// Verify returns an error unless the tree carries a valid signature of its
+// root by the given key.
+func (t *ChunkTree) Verify(key ed25519.PublicKey) (e error) {
+	switch {
+	case t.signature == nil:
+		return fmt.Errorf("chunk tree not signed")
+
+	case !ed25519.Verify(key, t.signed(), t.signature):
+		return fmt.Errorf("invalid chunk tree signature")
+	}
+
+	return
+}
+
+// AlignRange returns the smallest range of whole chunks of the archive that
+// covers the given range, which a consumer should fetch in order to verify it.
+func (t *ChunkTree) AlignRange(offset, length int64) (start, end int64) {
+	var (
+		l = int64(t.chunkLen)
+	)
+
+	start = max(offset/l*l, 0)
+	end = min((offset+length+l-1)/l*l, t.size)
+
+	return
+}
+
+// VerifyRange returns an error unless b holds the bytes of the archive at the
+// given offset, which must span whole chunks, as returned by AlignRange. The
+// tree itself should be verified first, such as by Verify, for the range to be
+// trusted.
+func (t *ChunkTree) VerifyRange(offset int64, b []byte) (e error) {
+	defer errorf("could not verify range", &e)
+
+	var (
+		end    = offset + int64(len(b))
+		i      int64
+		l      = int64(t.chunkLen)
+		leaves = t.allLeaves()
+	)
+
+	switch {
+	case offset < 0 || offset%l != 0:
+		return fmt.Errorf("offset %d not at start of chunk", offset)
+
+	case end > t.size:
+		return fmt.Errorf("range ends beyond archive")
+
+	case end%l != 0 && end != t.size:
+		return fmt.Errorf("range ends within chunk")
+	}
+
+	for i = offset / l; len(b) > 0; i++ {
+		l = min(int64(t.chunkLen), int64(len(b)))
+
+		if !bytes.Equal(chunkLeaf(b[:l]), leaves[i]) {
+			return fmt.Errorf("chunk at offset %d does not match tree",
+				i*int64(t.chunkLen),
+			)
+		}
+
+		b = b[l:]
+	}
+
+	return
+}
+
+func (t *ChunkTree) allLeaves() [][]byte {
+	// Returns the leaves of the tree, including that of a final partial
+	// chunk.
+
+	if len(t.pending) == 0 {
+		return t.leaves
+	}
+
+	return append(t.leaves[:len(t.leaves):len(t.leaves)],
+		chunkLeaf(t.pending),
+	)
+}
+
+func (t *ChunkTree) signed() []byte {
+	// Returns the message of which the signature is made.
+
+	var (
+		b = []byte(chunkTreeMagic)
+	)
+
+	b = binary.BigEndian.AppendUint32(b, uint32(t.chunkLen))
+	b = binary.BigEndian.AppendUint64(b, uint64(t.size))
+
+	return append(b, t.Root()...)
+}
+
+func chunkLeaf(chunk []byte) []byte {
+	var (
+		leaf = sha256.New()
+	)
+
+	leaf.Write([]byte{0})
+	leaf.Write(chunk)
+
+	return leaf.Sum(nil)
+}
+
+// WriteChunkTree writes the tree to the [io.Writer] as a sidecar, with its
+// signature if signed.
+func WriteChunkTree(writer io.Writer, t *ChunkTree) (e error) {
+	defer errorf("could not write chunk tree", &e)
+
+	var (
+		b    = []byte(chunkTreeMagic)
+		leaf []byte
+	)
+
+	b = binary.BigEndian.AppendUint32(b, uint32(t.chunkLen))
+	b = binary.BigEndian.AppendUint64(b, uint64(t.size))
+
+	for _, leaf = range t.allLeaves() {
+		b = append(b, leaf...)
+	}
+
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.signature)))
+	b = append(b, t.signature...)
+
+	_, e = writer.Write(b)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// ReadChunkTree reads a sidecar written by WriteChunkTree from the
+// [io.Reader].
+func ReadChunkTree(reader io.Reader) (t *ChunkTree, e error) {
+	defer errorf("could not read chunk tree", &e)
+
+	var (
+		count  int64
+		head   = make([]byte, len(chunkTreeMagic)+4+8)
+		i      int64
+		leaf   []byte
+		sigLen = make([]byte, 2)
+	)
+
+	_, e = io.ReadFull(reader, head)
+	if e != nil {
+		return nil, unexpectedEOF(e)
+	}
+
+	if string(head[:len(chunkTreeMagic)]) != chunkTreeMagic {
+		return nil, fmt.Errorf("not a chunk tree")
+	}
+
+	t = &ChunkTree{
+		chunkLen: int(
+			binary.BigEndian.Uint32(head[len(chunkTreeMagic):]),
+		),
+		size: int64(
+			binary.BigEndian.Uint64(head[len(chunkTreeMagic)+4:]),
+		),
+		sealed: true,
+	}
+
+	if t.chunkLen == 0 || t.size < 0 ||
+		t.size > math.MaxInt64-int64(t.chunkLen) {
+		return nil, fmt.Errorf("malformed chunk tree")
+	}
+
+	count = (t.size + int64(t.chunkLen) - 1) / int64(t.chunkLen)
+
+	for i = 0; i < count; i++ {
+		leaf = make([]byte, sha256.Size)
+
+		_, e = io.ReadFull(reader, leaf)
+		if e != nil {
+			return nil, unexpectedEOF(e)
+		}
+
+		t.leaves = append(t.leaves, leaf)
+	}
+
+	_, e = io.ReadFull(reader, sigLen)
+	if e != nil {
+		return nil, unexpectedEOF(e)
+	}
+
+	if binary.BigEndian.Uint16(sigLen) > 0 {
+		t.signature = make([]byte, binary.BigEndian.Uint16(sigLen))
+
+		_, e = io.ReadFull(reader, t.signature)
+		if e != nil {
+			return nil, unexpectedEOF(e)
+		}
+	}
+
+	return
+}
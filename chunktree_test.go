@@ -0,0 +1,131 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkTree(t *testing.T) {
+	var (
+		archive    bytes.Buffer
+		e          error
+		encoder    *Encoder
+		i          int
+		other      ed25519.PublicKey
+		private    ed25519.PrivateKey
+		public     ed25519.PublicKey
+		read       *ChunkTree
+		sidecar    bytes.Buffer
+		start, end int64
+		tampered   []byte
+		tree       *ChunkTree
+	)
+
+	public, private, e = ed25519.GenerateKey(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	other, _, e = ed25519.GenerateKey(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	_, e = NewChunkTree(0)
+
+	assert.Error(t, e)
+
+	tree, e = NewChunkTree(64)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	encoder = NewEncoder(&archive, fnv.New32a(), WithMirror(tree))
+
+	for i = 0; i < 100; i++ {
+		encoder.Encode([]byte{byte(i)}, bytes.Repeat([]byte{byte(i)}, i))
+	}
+
+	assert.NoError(t, encoder.Close())
+
+	assert.Equal(t, int64(archive.Len()), tree.Size())
+	assert.NotZero(t, tree.Size()%64)
+
+	assert.Error(t, tree.Verify(public))
+
+	tree.Sign(private)
+
+	assert.NoError(t, WriteChunkTree(&sidecar, tree))
+
+	read, e = ReadChunkTree(bytes.NewReader(sidecar.Bytes()))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.NoError(t, read.Verify(public))
+	assert.Error(t, read.Verify(other))
+	assert.Equal(t, tree.Root(), read.Root())
+	assert.Equal(t, 64, read.ChunkLen())
+
+	_, e = read.Write([]byte("more"))
+
+	assert.Error(t, e)
+
+	start, end = read.AlignRange(100, 200)
+
+	assert.Equal(t, int64(64), start)
+	assert.Equal(t, int64(320), end)
+
+	assert.NoError(t,
+		read.VerifyRange(start, archive.Bytes()[start:end]),
+	)
+
+	start, end = read.AlignRange(read.Size()-1, 10)
+
+	assert.Equal(t, read.Size(), end)
+
+	assert.NoError(t,
+		read.VerifyRange(start, archive.Bytes()[start:end]),
+	)
+
+	assert.ErrorContains(t,
+		read.VerifyRange(1, archive.Bytes()[1:65]),
+		"not at start of chunk",
+	)
+
+	assert.ErrorContains(t,
+		read.VerifyRange(0, archive.Bytes()[:65]),
+		"ends within chunk",
+	)
+
+	tampered = bytes.Clone(archive.Bytes()[64:320])
+	tampered[100] ^= 1
+
+	assert.ErrorContains(t,
+		read.VerifyRange(64, tampered),
+		"chunk at offset 128 does not match",
+	)
+
+	tree.Write([]byte("more"))
+
+	assert.Error(t, tree.Verify(public))
+
+	sidecar.Bytes()[len(chunkTreeMagic)+12] ^= 1
+
+	read, e = ReadChunkTree(bytes.NewReader(sidecar.Bytes()))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Error(t, read.Verify(public))
+
+	_, e = ReadChunkTree(bytes.NewReader(sidecar.Bytes()[:sidecar.Len()-1]))
+
+	assert.Error(t, e)
+
+	return
+}
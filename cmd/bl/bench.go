@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/gen"
+)
+
+func bench(args []string, stdout io.Writer) (e error) {
+	var (
+		flags   = flag.NewFlagSet("bench", flag.ContinueOnError)
+		records = flags.Int("records", 100000, "number of records")
+		keyLen  = flags.String("keys", "uniform:8:64",
+			"distribution of key lengths: fixed:N, uniform:LOW:HIGH or "+
+				"exp:MEAN:HIGH",
+		)
+		valLen = flags.String("vals", "exp:1024:65536",
+			"distribution of value lengths, as for -keys",
+		)
+		seed     = flags.Uint64("seed", 1, "seed of the pseudo-random workload")
+		checksum = flags.String("checksum", "crc32c",
+			"checksum of records: crc32c, fnv32a, crc32, or none if empty",
+		)
+		compression = flags.String("compress", "none",
+			"compression of values: none, deflate, lzw or auto",
+		)
+
+		generator *gen.Generator
+		hasher    hash.Hash32
+		key       []byte
+		keys      [][]byte
+		ok        bool
+		stream    bytes.Buffer
+		val       []byte
+		vals      [][]byte
+		workload  gen.Workload
+		writer    recordWriter
+	)
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	workload = gen.Workload{
+		Records: *records,
+		Seed:    *seed,
+	}
+
+	workload.KeyLen, e = parseDistribution(*keyLen)
+	if e != nil {
+		return
+	}
+
+	workload.ValLen, e = parseDistribution(*valLen)
+	if e != nil {
+		return
+	}
+
+	generator = gen.NewGenerator(workload)
+
+	for {
+		key, val, ok = generator.Next()
+		if !ok {
+			break
+		}
+
+		keys = append(keys, key)
+		vals = append(vals, val)
+	}
+
+	writer, e = newRecordWriter("bl", *checksum, *compression, &stream)
+	if e != nil {
+		return
+	}
+
+	e = measure(stdout, "encode", len(keys), &stream,
+		func() (e error) {
+			var (
+				i int
+			)
+
+			for i = range keys {
+				e = writer.Write(keys[i], vals[i], 0)
+				if e != nil {
+					return
+				}
+			}
+
+			return writer.Close()
+		},
+	)
+	if e != nil {
+		return
+	}
+
+	e = measure(stdout, "decode", len(keys), &stream,
+		func() (e error) {
+			var (
+				reader recordReader
+			)
+
+			reader, e = newRecordReader("bl", *checksum,
+				bytes.NewReader(stream.Bytes()),
+			)
+			if e != nil {
+				return
+			}
+
+			for {
+				_, _, _, e = reader.Read()
+				if errors.Is(e, io.EOF) {
+					return nil
+				}
+
+				if e != nil {
+					return
+				}
+			}
+		},
+	)
+	if e != nil {
+		return
+	}
+
+	hasher, e = newHasher(*checksum)
+	if e != nil {
+		return
+	}
+
+	e = measure(stdout, "verify", len(keys), &stream,
+		func() (e error) {
+			_, e = bl.Verify(
+				bytes.NewReader(stream.Bytes()), hasher,
+			)
+
+			return
+		},
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func measure(stdout io.Writer, name string, records int,
+	stream *bytes.Buffer, f func() error,
+) (
+	e error,
+) {
+	// Times f and reports its throughput in records and in bytes of stream,
+	// once f has run.
+
+	var (
+		elapsed time.Duration
+		start   = time.Now()
+	)
+
+	e = f()
+	if e != nil {
+		return fmt.Errorf("could not %s: %w", name, e)
+	}
+
+	elapsed = time.Since(start)
+
+	_, e = fmt.Fprintf(stdout,
+		"%-8s %10d records %12d bytes %10s %12.0f records/s %10.1f MB/s\n",
+		name, records, stream.Len(), elapsed.Round(time.Microsecond),
+		float64(records)/elapsed.Seconds(),
+		float64(stream.Len())/elapsed.Seconds()/1e6,
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func parseDistribution(spec string) (d gen.Distribution, e error) {
+	// Parses a distribution of lengths given as a name and parameters
+	// separated by colons.
+
+	var (
+		field  string
+		fields = strings.Split(spec, ":")
+		params []float64
+		param  float64
+	)
+
+	for _, field = range fields[1:] {
+		param, e = strconv.ParseFloat(field, 64)
+		if e != nil {
+			return nil, fmt.Errorf("malformed distribution %q", spec)
+		}
+
+		params = append(params, param)
+	}
+
+	switch {
+	case fields[0] == "fixed" && len(params) == 1:
+		return gen.Fixed(int(params[0])), nil
+
+	case fields[0] == "uniform" && len(params) == 2:
+		return gen.Uniform(int(params[0]), int(params[1])), nil
+
+	case fields[0] == "exp" && len(params) == 2:
+		return gen.Exponential(params[0], int(params[1])), nil
+	}
+
+	return nil, fmt.Errorf("malformed distribution %q", spec)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBench(t *testing.T) {
+	var (
+		output bytes.Buffer
+	)
+
+	assert.NoError(t,
+		run("bench",
+			[]string{"-records", "100", "-keys", "fixed:16",
+				"-vals", "uniform:0:100", "-compress", "auto",
+			},
+			nil, &output,
+		),
+	)
+
+	assert.Contains(t, output.String(), "encode")
+	assert.Contains(t, output.String(), "decode")
+	assert.Contains(t, output.String(), "verify")
+	assert.Contains(t, output.String(), " 100 records")
+
+	assert.ErrorContains(t,
+		run("bench", []string{"-keys", "normal:3"}, nil, &output),
+		"malformed distribution",
+	)
+
+	return
+}
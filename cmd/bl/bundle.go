@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func bundle(args []string, stdin io.Reader, stdout io.Writer) (e error) {
+	var (
+		flags = flag.NewFlagSet("bundle", flag.ContinueOnError)
+		force = flags.Bool("force", false,
+			"write the bundle even to a terminal",
+		)
+
+		base   string
+		file   *os.File
+		info   os.FileInfo
+		name   string
+		names  = make(map[string]bool)
+		piped  []byte
+		writer *bl.BundleWriter
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl bundle [flags] artifact|-...")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+
+		return fmt.Errorf("artifacts required")
+	}
+
+	e = checkBinaryOutput(stdout, *force)
+	if e != nil {
+		return
+	}
+
+	writer = bl.NewBundleWriter(stdout)
+
+	for _, name = range flags.Args() {
+		base = filepath.Base(name)
+
+		if name == stdio {
+			base = "stdin"
+		}
+
+		if names[base] {
+			return fmt.Errorf("duplicate artifact name %q", base)
+		}
+
+		names[base] = true
+
+		// An artifact read from standard input is held in memory, as its
+		// size precedes it in the bundle.
+
+		if name == stdio {
+			e = checkInput(stdin)
+			if e != nil {
+				return
+			}
+
+			piped, e = io.ReadAll(stdin)
+			if e != nil {
+				return
+			}
+
+			e = writer.Add(base, bytes.NewReader(piped), int64(len(piped)))
+			if e != nil {
+				return
+			}
+
+			continue
+		}
+
+		file, e = os.Open(name)
+		if e != nil {
+			return
+		}
+
+		info, e = file.Stat()
+		if e == nil {
+			e = writer.Add(base, file, info.Size())
+		}
+
+		file.Close()
+
+		if e != nil {
+			return
+		}
+	}
+
+	return writer.Close()
+}
+
+func unbundle(args []string, stdin io.Reader) (e error) {
+	var (
+		flags = flag.NewFlagSet("unbundle", flag.ContinueOnError)
+		dir   = flags.String("C", ".", "directory in which to extract artifacts")
+
+		file   *os.File
+		input  io.ReadCloser
+		name   string
+		reader *bl.BundleReader
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl unbundle [flags] [bundle|-]")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+
+		return fmt.Errorf("at most one bundle expected, got %d", flags.NArg())
+	}
+
+	input, e = openInput(cmp.Or(flags.Arg(0), stdio), stdin)
+	if e != nil {
+		return
+	}
+
+	defer input.Close()
+
+	reader = bl.NewBundleReader(input)
+
+	for {
+		name, e = reader.Next()
+		if errors.Is(e, io.EOF) {
+			return verifyManifest(*dir)
+		}
+
+		if e != nil {
+			return
+		}
+
+		if !filepath.IsLocal(name) || filepath.Base(name) != name {
+			return fmt.Errorf("artifact name %q not a plain file name", name)
+		}
+
+		file, e = os.Create(
+			filepath.Join(*dir, name),
+		)
+		if e != nil {
+			return
+		}
+
+		_, e = io.Copy(file, reader)
+		if e == nil {
+			e = file.Close()
+		} else {
+			file.Close()
+		}
+
+		if e != nil {
+			return
+		}
+	}
+}
+
+func openArtifact(reader io.Reader, name string) (
+	bundle *bl.BundleReader, e error,
+) {
+	// Returns a reader of the bundle that the io.Reader holds, positioned at
+	// the named artifact.
+
+	var (
+		next string
+	)
+
+	bundle = bl.NewBundleReader(reader)
+
+	for next != name {
+		next, e = bundle.Next()
+		if errors.Is(e, io.EOF) {
+			return nil, fmt.Errorf("no artifact %q in bundle", name)
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func verifyBundle(bundle *bl.BundleReader) (e error) {
+	// Reads the rest of the bundle, verifying the digests of its artifacts.
+
+	for {
+		_, e = bundle.Next()
+		if errors.Is(e, io.EOF) {
+			return nil
+		}
+
+		if e != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestBundle(t *testing.T) {
+	var (
+		bundled   bytes.Buffer
+		dir       = t.TempDir()
+		extracted = t.TempDir()
+		output    bytes.Buffer
+		stream    bytes.Buffer
+	)
+
+	bl.NewEncoder(&stream, nil).Encode([]byte("key"), []byte("val"))
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "stream.bl"), stream.Bytes(), 0o644),
+	)
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "notes"), []byte("release notes"), 0o644),
+	)
+
+	assert.NoError(t,
+		run("bundle",
+			[]string{
+				filepath.Join(dir, "stream.bl"), filepath.Join(dir, "notes"),
+			},
+			nil, &bundled,
+		),
+	)
+
+	assert.NoError(t,
+		run("unbundle", []string{"-C", extracted},
+			bytes.NewReader(bundled.Bytes()), nil,
+		),
+	)
+
+	assert.FileExists(t, filepath.Join(extracted, "notes"))
+	assert.FileExists(t, filepath.Join(extracted, "stream.bl"))
+
+	assert.NoError(t,
+		run("convert", []string{"-artifact", "stream.bl", "-to", "jsonl"},
+			bytes.NewReader(bundled.Bytes()), &output,
+		),
+	)
+
+	assert.Contains(t, output.String(), "key")
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "bundle"), bundled.Bytes(), 0o644),
+	)
+
+	assert.NoError(t,
+		run("diff",
+			[]string{"-artifact", "stream.bl",
+				filepath.Join(dir, "bundle"), filepath.Join(dir, "bundle"),
+			},
+			nil, &output,
+		),
+	)
+
+	assert.Error(t,
+		run("convert", []string{"-artifact", "missing"},
+			bytes.NewReader(bundled.Bytes()), &output,
+		),
+	)
+
+	bundled.Bytes()[bytes.Index(bundled.Bytes(), []byte("release notes"))] ^= 1
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-artifact", "stream.bl"},
+			bytes.NewReader(bundled.Bytes()), &output,
+		),
+		"does not match",
+	)
+
+	return
+}
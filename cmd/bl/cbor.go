@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Records are represented in CBOR (RFC 8949) as a sequence (RFC 8742) of
+// arrays, each of three items: the key and the value as byte strings, and the
+// extended metadata value as an unsigned integer. Only definite lengths are
+// supported.
+
+const (
+	cborUint   = 0
+	cborBytes  = 2
+	cborArray  = 4
+	cborMaxLen = 1 << 32
+)
+
+type cborReader struct {
+	reader io.Reader
+}
+
+func newCBORReader(reader io.Reader) cborReader {
+	return cborReader{
+		reader: reader,
+	}
+}
+
+func (r cborReader) Read() (key, val []byte, xmv byte, e error) {
+	var (
+		n uint64
+	)
+
+	n, e = r.readHead(cborArray)
+	if e != nil {
+		return
+	}
+
+	if n != 3 {
+		return nil, nil, 0, fmt.Errorf("CBOR record of %d items, not 3", n)
+	}
+
+	key, e = r.readBytes()
+	if e != nil {
+		return nil, nil, 0, unexpectedEOF(e)
+	}
+
+	val, e = r.readBytes()
+	if e != nil {
+		return nil, nil, 0, unexpectedEOF(e)
+	}
+
+	n, e = r.readHead(cborUint)
+	if e != nil {
+		return nil, nil, 0, unexpectedEOF(e)
+	}
+
+	if n > 0xf {
+		return nil, nil, 0, fmt.Errorf("extended metadata value %d too large",
+			n,
+		)
+	}
+
+	return key, val, byte(n), nil
+}
+
+func (r cborReader) readHead(major byte) (n uint64, e error) {
+	// Reads the head of a data item of the given major type, and returns its
+	// argument.
+
+	var (
+		b    = make([]byte, 8)
+		info byte
+		l    int
+	)
+
+	_, e = io.ReadFull(r.reader, b[:1])
+	if e != nil {
+		return
+	}
+
+	if b[0]>>5 != major {
+		return 0, fmt.Errorf("unexpected CBOR major type %d", b[0]>>5)
+	}
+
+	info = b[0] & 0x1f
+
+	switch {
+	case info < 24:
+		return uint64(info), nil
+
+	case info <= 27:
+		l = 1 << (info - 24)
+
+	default:
+		return 0, fmt.Errorf("unsupported CBOR additional information %d",
+			info,
+		)
+	}
+
+	b[0] = 0
+
+	_, e = io.ReadFull(r.reader, b[8-l:])
+	if e != nil {
+		return 0, unexpectedEOF(e)
+	}
+
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (r cborReader) readBytes() (b []byte, e error) {
+	var (
+		n uint64
+	)
+
+	n, e = r.readHead(cborBytes)
+	if e != nil {
+		return
+	}
+
+	if n > cborMaxLen {
+		return nil, fmt.Errorf("CBOR byte string too long")
+	}
+
+	b = make([]byte, n)
+
+	_, e = io.ReadFull(r.reader, b)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+type cborWriter struct {
+	writer io.Writer
+}
+
+func newCBORWriter(writer io.Writer) cborWriter {
+	return cborWriter{
+		writer: writer,
+	}
+}
+
+func (w cborWriter) Write(key, val []byte, xmv byte) (e error) {
+	var (
+		b []byte
+	)
+
+	b = appendCBORHead(b, cborArray, 3)
+	b = appendCBORHead(b, cborBytes, uint64(len(key)))
+	b = append(b, key...)
+	b = appendCBORHead(b, cborBytes, uint64(len(val)))
+
+	_, e = w.writer.Write(b)
+	if e != nil {
+		return
+	}
+
+	_, e = w.writer.Write(val)
+	if e != nil {
+		return
+	}
+
+	_, e = w.writer.Write(
+		appendCBORHead(nil, cborUint, uint64(xmv)),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (w cborWriter) Close() error {
+	return nil
+}
+
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+
+	case n <= 0xff:
+		return append(b, major<<5|24, byte(n))
+
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25),
+			uint16(n),
+		)
+
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26),
+			uint32(n),
+		)
+	}
+
+	return binary.BigEndian.AppendUint64(append(b, major<<5|27), n)
+}
+
+func unexpectedEOF(e error) error {
+	// Returns io.ErrUnexpectedEOF in place of io.EOF, for a record cut short.
+
+	if errors.Is(e, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return e
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A completedCommand is a command offered by shell completion, with its
+// flags.
+type completedCommand struct {
+	name  string
+	flags []completedFlag
+}
+
+// A completedFlag is a flag offered by shell completion. A boolean flag takes
+// no value; any other takes one of its values if it lists any, or else a file
+// name.
+type completedFlag struct {
+	name    string
+	boolean bool
+	values  []string
+}
+
+var (
+	checksums = []string{"crc32c", "fnv32a", "crc32"}
+	renders   = []string{"utf8", "hex", "base64", "length"}
+
+	// completions lists the commands and their flags, kept in step with the
+	// flag sets of the commands by the tests.
+	completions = []completedCommand{
+		{"bench", []completedFlag{
+			{"records", false, nil},
+			{"keys", false, nil},
+			{"vals", false, nil},
+			{"seed", false, nil},
+			{"checksum", false, checksums},
+			{"compress", false, []string{"none", "deflate", "lzw", "auto"}},
+		}},
+		{"bundle", []completedFlag{
+			{"force", true, nil},
+		}},
+		{"completion", nil},
+		{"convert", []completedFlag{
+			{"from", false, []string{"bl", "jsonl", "cbor", "mdbdump",
+				"tree",
+			}},
+			{"to", false, []string{"bl", "jsonl", "cbor", "mdbdump", "csv",
+				"tsv", "parquet", "tree",
+			}},
+			{"dir", false, nil},
+			{"render-key", false, renders},
+			{"render-val", false, renders},
+			{"in-checksum", false, checksums},
+			{"checksum", false, checksums},
+			{"compress", false, []string{"none", "deflate", "lzw", "auto"}},
+			{"artifact", false, nil},
+			{"digest-values", true, nil},
+			{"timestamp", false, nil},
+			{"keys", false, nil},
+			{"key", false, nil},
+			{"o", false, nil},
+			{"force", true, nil},
+		}},
+		{"diff", []completedFlag{
+			{"checksum", false, checksums},
+			{"digests", true, nil},
+			{"artifact", false, nil},
+			{"output", false, []string{"text", "json"}},
+		}},
+		{"fetch", []completedFlag{
+			{"o", false, nil},
+			{"force", true, nil},
+			{"load", false, nil},
+			{"mdb-load", false, nil},
+			{"no-subdir", true, nil},
+			{"db", false, nil},
+			{"checksum", false, checksums},
+			{"auth-file", false, nil},
+			{"retries", false, nil},
+			{"retry-delay", false, nil},
+			{"progress", true, nil},
+			{"unknown-comparators", true, nil},
+		}},
+		{"get", []completedFlag{
+			{"checksum", false, checksums},
+			{"key-format", false, []string{"hex", "raw"}},
+			{"format", false, []string{"raw", "hex", "base64"}},
+			{"force", true, nil},
+		}},
+		{"manifest", []completedFlag{
+			{"env", false, nil},
+			{"snapshot", false, nil},
+			{"parent", false, nil},
+			{"checksum", false, checksums},
+		}},
+		{"replicate", []completedFlag{
+			{"config", false, nil},
+			{"once", true, nil},
+		}},
+		{"serve", []completedFlag{
+			{"addr", false, nil},
+			{"mdb-dump", false, nil},
+			{"no-subdir", true, nil},
+			{"checksum", false, checksums},
+			{"auth-file", false, nil},
+			{"tls-cert", false, nil},
+			{"tls-key", false, nil},
+			{"index-dir", false, nil},
+			{"keep-serving", true, nil},
+		}},
+		{"unbundle", []completedFlag{
+			{"C", false, nil},
+		}},
+	}
+)
+
+func completion(args []string, stdout io.Writer) (e error) {
+	var (
+		flags = flag.NewFlagSet("completion", flag.ContinueOnError)
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl completion bash|zsh|fish")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+
+		return fmt.Errorf("shell required")
+	}
+
+	switch flags.Arg(0) {
+	case "bash":
+		_, e = io.WriteString(stdout, bashCompletion())
+
+	case "zsh":
+		// Zsh completes by the bash function, through its emulation of
+		// bash completion.
+
+		_, e = io.WriteString(stdout,
+			"#compdef bl\n\n"+
+				"autoload -U +X bashcompinit && bashcompinit\n\n"+
+				bashCompletion(),
+		)
+
+	case "fish":
+		_, e = io.WriteString(stdout, fishCompletion())
+
+	default:
+		return fmt.Errorf("unknown shell %q", flags.Arg(0))
+	}
+
+	return
+}
+
+func bashCompletion() string {
+	var (
+		builder strings.Builder
+		command completedCommand
+		f       completedFlag
+		names   []string
+	)
+
+	for _, command = range completions {
+		names = append(names, command.name)
+	}
+
+	fmt.Fprintf(&builder, "# bash completion of bl\n\n"+
+		"_bl() {\n"+
+		"\tlocal cur=${COMP_WORDS[COMP_CWORD]} "+
+		"prev=${COMP_WORDS[COMP_CWORD-1]} flags=\n\n"+
+		"\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n"+
+		"\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n"+
+		"\t\treturn\n"+
+		"\tfi\n\n"+
+		"\tcase \"${COMP_WORDS[1]} $prev\" in\n",
+		strings.Join(names, " "),
+	)
+
+	// Flags may be given with one dash or two.
+
+	for _, command = range completions {
+		for _, f = range command.flags {
+			if f.values == nil {
+				continue
+			}
+
+			fmt.Fprintf(&builder, "\t%q | %q)\n"+
+				"\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n"+
+				"\t\treturn\n"+
+				"\t\t;;\n",
+				command.name+" -"+f.name, command.name+" --"+f.name,
+				strings.Join(f.values, " "),
+			)
+		}
+	}
+
+	builder.WriteString("\tesac\n\n" +
+		"\tcase \"${COMP_WORDS[1]}\" in\n",
+	)
+
+	for _, command = range completions {
+		names = names[:0]
+
+		for _, f = range command.flags {
+			names = append(names, "-"+f.name)
+		}
+
+		fmt.Fprintf(&builder, "\t%s)\n\t\tflags=%q\n\t\t;;\n",
+			command.name, strings.Join(names, " "),
+		)
+	}
+
+	builder.WriteString("\tesac\n\n" +
+		"\tif [[ $cur == -* ]]; then\n" +
+		"\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n" +
+		"\telse\n" +
+		"\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n" +
+		"\tfi\n" +
+		"}\n\n" +
+		"complete -o filenames -F _bl bl\n",
+	)
+
+	return builder.String()
+}
+
+func fishCompletion() string {
+	var (
+		builder strings.Builder
+		command completedCommand
+		f       completedFlag
+	)
+
+	builder.WriteString("# fish completion of bl\n\n")
+
+	for _, command = range completions {
+		fmt.Fprintf(&builder,
+			"complete -c bl -f -n __fish_use_subcommand -a %s\n",
+			command.name,
+		)
+	}
+
+	// Go flags are long options given with a single dash, as fish calls
+	// old-style options.
+
+	for _, command = range completions {
+		for _, f = range command.flags {
+			fmt.Fprintf(&builder,
+				"complete -c bl -n '__fish_seen_subcommand_from %s' -o %s",
+				command.name, f.name,
+			)
+
+			switch {
+			case f.boolean:
+
+			case f.values != nil:
+				fmt.Fprintf(&builder, " -x -a '%s'",
+					strings.Join(f.values, " "),
+				)
+
+			default:
+				builder.WriteString(" -r -F")
+			}
+
+			builder.WriteString("\n")
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletions(t *testing.T) {
+	var (
+		arg     string
+		command completedCommand
+		e       error
+		f       completedFlag
+	)
+
+	// Every flag completed is one of its command, which stops at -h having
+	// parsed it, and every boolean flag is one.
+
+	for _, command = range completions {
+		for _, f = range command.flags {
+			arg = "-" + f.name + "="
+
+			if f.boolean {
+				arg += "true"
+			}
+
+			e = run(command.name, []string{arg, "-h"}, nil, nil)
+
+			assert.NotContains(t, e.Error(), "not defined",
+				"%s -%s", command.name, f.name,
+			)
+
+			if f.boolean {
+				assert.Contains(t, e.Error(), "help requested",
+					"%s -%s", command.name, f.name,
+				)
+			}
+		}
+	}
+
+	return
+}
+
+func TestCompletion(t *testing.T) {
+	var (
+		command *exec.Cmd
+		e       error
+		output  bytes.Buffer
+	)
+
+	assert.NoError(t,
+		run("completion", []string{"bash"}, nil, &output),
+	)
+	assert.Contains(t, output.String(),
+		"\t\"convert -to\" | \"convert --to\")\n"+
+			"\t\tCOMPREPLY=($(compgen -W "+
+			"\"bl jsonl cbor mdbdump csv tsv parquet tree\" -- \"$cur\"))\n",
+	)
+	assert.Contains(t, output.String(),
+		"\tdiff)\n\t\tflags=\"-checksum -digests -artifact -output\"\n",
+	)
+
+	_, e = exec.LookPath("bash")
+	if e == nil {
+		command = exec.Command("bash", "-n")
+		command.Stdin = strings.NewReader(output.String())
+
+		assert.NoError(t,
+			command.Run(),
+		)
+	}
+
+	output.Reset()
+
+	assert.NoError(t,
+		run("completion", []string{"zsh"}, nil, &output),
+	)
+	assert.True(t,
+		strings.HasPrefix(output.String(), "#compdef bl\n"),
+	)
+
+	output.Reset()
+
+	assert.NoError(t,
+		run("completion", []string{"fish"}, nil, &output),
+	)
+	assert.Contains(t, output.String(),
+		"complete -c bl -n '__fish_seen_subcommand_from get' -o format "+
+			"-x -a 'raw hex base64'\n",
+	)
+	assert.Contains(t, output.String(),
+		"complete -c bl -n '__fish_seen_subcommand_from fetch' -o progress\n",
+	)
+
+	assert.Error(t,
+		run("completion", []string{"tcsh"}, nil, &output),
+	)
+
+	return
+}
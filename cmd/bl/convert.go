@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/parquet"
+)
+
+// A recordReader yields records one at a time, returning io.EOF after the
+// last.
+type recordReader interface {
+	Read() (key, val []byte, xmv byte, e error)
+}
+
+// A recordWriter consumes records one at a time. Close completes the output
+// without closing the underlying io.Writer.
+type recordWriter interface {
+	Write(key, val []byte, xmv byte) error
+	Close() error
+}
+
+func convert(args []string, stdin io.Reader, stdout io.Writer) (e error) {
+	var (
+		buffered = bufio.NewWriter(stdout)
+		flags    = flag.NewFlagSet("convert", flag.ContinueOnError)
+		from     = flags.String("from", "bl",
+			"input format: bl, jsonl, cbor, mdbdump, or tree to read the "+
+				"files under -dir",
+		)
+		to = flags.String("to", "bl",
+			"output format: bl, jsonl, cbor, mdbdump, csv, tsv, parquet, or "+
+				"tree to write files under -dir (mdbdump and tree carry no "+
+				"metadata)",
+		)
+		dir = flags.String("dir", "",
+			"directory of tree input or output, a file per record at a path "+
+				"made of its key",
+		)
+		keyRendering = flags.String("render-key", "utf8",
+			"rendering of csv and tsv keys: utf8, hex, base64, or length "+
+				"in bytes",
+		)
+		valRendering = flags.String("render-val", "utf8",
+			"rendering of csv and tsv values: utf8, hex, base64, or length "+
+				"in bytes",
+		)
+		inChecksum = flags.String("in-checksum", "",
+			"checksum of bl input records: crc32c, fnv32a, crc32, or none "+
+				"if empty",
+		)
+		checksum = flags.String("checksum", "",
+			"checksum of bl output records: crc32c, fnv32a, crc32, or none "+
+				"if empty",
+		)
+		compression = flags.String("compress", "none",
+			"compression of bl output values: none, deflate, lzw, or auto "+
+				"to deflate values that look compressible",
+		)
+		artifact = flags.String("artifact", "",
+			"if not empty, read the named artifact of a bundle on standard "+
+				"input, verifying the bundle",
+		)
+		digestValues = flags.Bool("digest-values", false,
+			"replace parquet values with their SHA-256 digests",
+		)
+		timestamp = flags.String("timestamp", "",
+			"if not empty, add a column holding this RFC 3339 time, such as "+
+				"that of the backup, to every parquet row",
+		)
+		keysFile = flags.String("keys", "",
+			"if not empty, convert only the records of the hex-encoded keys "+
+				"listed one per line in the named file",
+		)
+		out = flags.String("o", stdio,
+			"file to which to write the output, or - for standard output",
+		)
+		force = flags.Bool("force", false,
+			"write binary output even to a terminal",
+		)
+		bundle *bl.BundleReader
+		file   io.ReadCloser
+		filter *keyFilter
+		input  io.Reader
+		key    []byte
+		output *os.File
+		keys   = make(hexKeys)
+		reader recordReader
+		stamp  time.Time
+		tree   *bl.TreeWriter
+		val    []byte
+		writer recordWriter
+		xmv    byte
+	)
+
+	flags.Var(keys, "key",
+		"convert only the record of the hex-encoded key, and of any others "+
+			"given by -key or -keys",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl convert [flags] [input]")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+
+		return fmt.Errorf("at most one input expected, got %d", flags.NArg())
+	}
+
+	if (*from == "tree" || *to == "tree") && *dir == "" {
+		return fmt.Errorf("tree input or output requires -dir")
+	}
+
+	if *keysFile != "" {
+		e = keys.readFile(*keysFile)
+		if e != nil {
+			return
+		}
+	}
+
+	// Input is read from the file named, if any, and output written to the
+	// file given by -o; either may be standard input or output, the default,
+	// so that conversions chain in pipelines.
+
+	if *from != "tree" {
+		file, e = openInput(cmp.Or(flags.Arg(0), stdio), stdin)
+		if e != nil {
+			return
+		}
+
+		defer file.Close()
+
+		input = bufio.NewReader(file)
+	}
+
+	switch {
+	case *to == "tree":
+
+	case *out != stdio:
+		output, e = os.Create(*out)
+		if e != nil {
+			return
+		}
+
+		defer func() {
+			if output.Close() != nil && e == nil {
+				e = fmt.Errorf("could not close %s", *out)
+			}
+		}()
+
+		buffered = bufio.NewWriter(output)
+
+	case *to != "jsonl" && *to != "mdbdump" && *to != "csv" && *to != "tsv":
+		e = checkBinaryOutput(stdout, *force)
+		if e != nil {
+			return
+		}
+	}
+
+	if *artifact != "" {
+		bundle, e = openArtifact(input, *artifact)
+		if e != nil {
+			return
+		}
+
+		input = bundle
+	}
+
+	if *from == "tree" {
+		reader, e = newTreeReader(*dir)
+	} else {
+		reader, e = newRecordReader(*from, *inChecksum, input)
+	}
+
+	if e != nil {
+		return
+	}
+
+	if len(keys) > 0 {
+		filter = &keyFilter{
+			reader: reader,
+			keys:   keys,
+		}
+
+		reader = filter
+	}
+
+	switch *to {
+	case "csv", "tsv":
+		writer, e = newCSVWriter(buffered, *to == "tsv",
+			*keyRendering, *valRendering,
+		)
+
+	case "parquet":
+		if *timestamp != "" {
+			stamp, e = time.Parse(time.RFC3339, *timestamp)
+			if e != nil {
+				return
+			}
+		}
+
+		writer = parquetWriter{
+			parquet.NewWriter(buffered,
+				parquet.Options{
+					ValueDigests: *digestValues,
+					Timestamp:    stamp,
+				},
+			),
+		}
+
+	case "tree":
+		tree, e = bl.NewTreeWriter(*dir)
+
+		writer = treeWriter{tree}
+
+	default:
+		writer, e = newRecordWriter(*to, *checksum, *compression, buffered)
+	}
+
+	if e != nil {
+		return
+	}
+
+	for {
+		key, val, xmv, e = reader.Read()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		e = writer.Write(key, val, xmv)
+		if e != nil {
+			return
+		}
+	}
+
+	e = writer.Close()
+	if e != nil {
+		return
+	}
+
+	if bundle != nil {
+		e = verifyBundle(bundle)
+		if e != nil {
+			return
+		}
+	}
+
+	e = buffered.Flush()
+	if e != nil {
+		return
+	}
+
+	if filter != nil && len(filter.keys) > 0 {
+		return fmt.Errorf("%d of the keys not found, including %x",
+			len(filter.keys), filter.keys.first(),
+		)
+	}
+
+	return
+}
+
+// hexKeys is the set of keys given to convert by -key and -keys, by their
+// decoded value.
+type hexKeys map[string]bool
+
+func (k hexKeys) String() string {
+	return ""
+}
+
+func (k hexKeys) Set(s string) (e error) {
+	var (
+		key []byte
+	)
+
+	key, e = hex.DecodeString(s)
+	if e != nil {
+		return
+	}
+
+	k[string(key)] = true
+
+	return
+}
+
+func (k hexKeys) readFile(name string) (e error) {
+	var (
+		b    []byte
+		line []byte
+	)
+
+	b, e = os.ReadFile(name)
+	if e != nil {
+		return
+	}
+
+	for _, line = range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		e = k.Set(string(line))
+		if e != nil {
+			return fmt.Errorf("%s: %w", name, e)
+		}
+	}
+
+	return
+}
+
+func (k hexKeys) first() []byte {
+	// Returns the least of the keys, for a deterministic report.
+
+	var (
+		key   string
+		least string
+		ok    bool
+	)
+
+	for key = range k {
+		if !ok || key < least {
+			least, ok = key, true
+		}
+	}
+
+	return []byte(least)
+}
+
+// A keyFilter yields only the records of the keys in its set, removing each
+// key from the set as its first record is yielded, and ends once the set is
+// empty, so that a sparse restore need not read the rest of a full dump.
+type keyFilter struct {
+	reader recordReader
+	keys   hexKeys
+}
+
+func (f *keyFilter) Read() (key, val []byte, xmv byte, e error) {
+	for len(f.keys) > 0 {
+		key, val, xmv, e = f.reader.Read()
+		if e != nil {
+			return
+		}
+
+		if f.keys[string(key)] {
+			delete(f.keys, string(key))
+
+			return
+		}
+	}
+
+	return nil, nil, 0, io.EOF
+}
+
+func newRecordReader(format, checksum string, reader io.Reader) (
+	r recordReader, e error,
+) {
+	var (
+		hasher hash.Hash32
+	)
+
+	switch format {
+	case "bl":
+		hasher, e = newHasher(checksum)
+		if e != nil {
+			return
+		}
+
+		return blReader{bl.NewDecoder(reader, hasher)}, nil
+
+	case "jsonl":
+		return newJSONLReader(reader), nil
+
+	case "cbor":
+		return newCBORReader(reader), nil
+
+	case "mdbdump":
+		return newMDBDumpReader(reader), nil
+	}
+
+	return nil, fmt.Errorf("unknown input format %q", format)
+}
+
+func newRecordWriter(format, checksum, compression string, writer io.Writer) (
+	w recordWriter, e error,
+) {
+	var (
+		hasher hash.Hash32
+		opts   []bl.Option
+	)
+
+	switch format {
+	case "bl":
+		hasher, e = newHasher(checksum)
+		if e != nil {
+			return
+		}
+
+		switch compression {
+		case "none":
+
+		case "deflate":
+			opts = append(opts, bl.WithCompression(
+				func([]byte, []byte) bl.Compression {
+					return bl.CompressionDeflate
+				},
+			))
+
+		case "lzw":
+			opts = append(opts, bl.WithCompression(
+				func([]byte, []byte) bl.Compression {
+					return bl.CompressionLZW
+				},
+			))
+
+		case "auto":
+			opts = append(opts, bl.WithCompression(
+				bl.SniffCompression(bl.CompressionDeflate),
+			))
+
+		default:
+			return nil, fmt.Errorf("unknown compression %q", compression)
+		}
+
+		return blWriter{bl.NewEncoder(writer, hasher, opts...)}, nil
+
+	case "jsonl":
+		return newJSONLWriter(writer), nil
+
+	case "cbor":
+		return newCBORWriter(writer), nil
+
+	case "mdbdump":
+		return newMDBDumpWriter(writer), nil
+	}
+
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+func newHasher(name string) (hasher hash.Hash32, e error) {
+	var (
+		checksum bl.Checksum
+	)
+
+	checksum, e = bl.ParseChecksum(name)
+	if e != nil {
+		return
+	}
+
+	return checksum.New(), nil
+}
+
+type blReader struct {
+	decoder *bl.Decoder
+}
+
+func (r blReader) Read() (key, val []byte, xmv byte, e error) {
+	return r.decoder.DecodeX()
+}
+
+type blWriter struct {
+	encoder *bl.Encoder
+}
+
+func (w blWriter) Write(key, val []byte, xmv byte) error {
+	return w.encoder.EncodeX(key, val, bl.XMetaValue(xmv))
+}
+
+// SetDatabase tags the records that follow with the name of their database.
+func (w blWriter) SetDatabase(name string) error {
+	return w.encoder.SetSource(name)
+}
+
+// SetDatabaseFlags records the flags of the database of the records that
+// follow.
+func (w blWriter) SetDatabaseFlags(flags bl.DatabaseFlags) error {
+	return w.encoder.SetDatabaseFlags(flags)
+}
+
+// SetComparator tags the records that follow with the custom comparator of
+// their database.
+func (w blWriter) SetComparator(id string) error {
+	return w.encoder.SetComparator(id)
+}
+
+func (w blWriter) Close() error {
+	return w.encoder.Close()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestConvert(t *testing.T) {
+	var (
+		e       error
+		format  string
+		input   bytes.Buffer
+		key     []byte
+		output  bytes.Buffer
+		record  testRecord
+		records = []testRecord{
+			{[]byte("a"), []byte("alpha"), 0},
+			{[]byte("b"), bytes.Repeat([]byte("beta"), 100), 5},
+			{[]byte("c\\\x00"), []byte{}, 15},
+		}
+		reader    recordReader
+		roundTrip bytes.Buffer
+		val       []byte
+		writer    recordWriter
+		xmv       byte
+	)
+
+	writer = blWriter{bl.NewEncoder(&input, nil)}
+
+	for _, record = range records {
+		assert.NoError(t,
+			writer.Write(record.key, record.val, record.xmv),
+		)
+	}
+
+	for _, format = range []string{"jsonl", "cbor", "mdbdump", "bl"} {
+		output.Reset()
+
+		assert.NoError(t,
+			run("convert",
+				[]string{"-to", format, "-checksum", "crc32c",
+					"-compress", "auto",
+				},
+				bytes.NewReader(input.Bytes()), &output,
+			),
+		)
+
+		if format != "mdbdump" {
+			roundTrip.Reset()
+
+			assert.NoError(t,
+				run("convert",
+					[]string{"-from", format, "-in-checksum", "crc32c"},
+					bytes.NewReader(output.Bytes()), &roundTrip,
+				),
+			)
+
+			assert.Equal(t, input.Bytes(), roundTrip.Bytes(), format)
+		}
+
+		reader, e = newRecordReader(format, "crc32c", &output)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		for _, record = range records {
+			key, val, xmv, e = reader.Read()
+			if e != nil {
+				t.Fatal(format, e)
+			}
+
+			assert.Equal(t, record.key, key, format)
+			assert.Equal(t, string(record.val), string(val), format)
+
+			if format != "mdbdump" {
+				assert.Equal(t, record.xmv, xmv, format)
+			}
+		}
+
+		_, _, _, e = reader.Read()
+
+		assert.True(t, errors.Is(e, io.EOF), format)
+	}
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-to", "xml"}, &input, io.Discard),
+		"unknown output format",
+	)
+
+	assert.ErrorContains(t,
+		run("frobnicate", nil, &input, io.Discard),
+		"unknown command",
+	)
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-from", "cbor"},
+			strings.NewReader("\x83\x41"), io.Discard,
+		),
+		"unexpected EOF",
+	)
+
+	// Extended metadata values past XMetaValueF are refused by both readers
+	// rather than truncated.
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-from", "cbor"},
+			strings.NewReader("\x83\x41a\x40\x10"), io.Discard,
+		),
+		"extended metadata value 16 too large",
+	)
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-from", "jsonl"},
+			strings.NewReader(`{"key":"YQ==","val":"","xmv":16}`), io.Discard,
+		),
+		"extended metadata value 16 too large",
+	)
+
+	return
+}
+
+func TestConvertKeys(t *testing.T) {
+	var (
+		e      error
+		i      int
+		input  bytes.Buffer
+		key    []byte
+		keys   = filepath.Join(t.TempDir(), "keys")
+		output bytes.Buffer
+		reader recordReader
+		writer = blWriter{bl.NewEncoder(&input, nil)}
+	)
+
+	for i = 0; i < 100; i++ {
+		assert.NoError(t,
+			writer.Write([]byte{byte(i)}, []byte{'v'}, 0),
+		)
+	}
+
+	assert.NoError(t,
+		writer.Close(),
+	)
+	assert.NoError(t,
+		os.WriteFile(keys, []byte("05\n\n2a\n"), 0o644),
+	)
+	assert.NoError(t,
+		run("convert",
+			[]string{"-to", "jsonl", "-key", "03", "-keys", keys},
+			bytes.NewReader(input.Bytes()), &output,
+		),
+	)
+
+	reader = newJSONLReader(&output)
+
+	for _, i = range []int{3, 5, 42} {
+		key, _, _, e = reader.Read()
+		assert.NoError(t, e)
+		assert.Equal(t, []byte{byte(i)}, key)
+	}
+
+	_, _, _, e = reader.Read()
+	assert.ErrorIs(t, e, io.EOF)
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-key", "ff", "-key", "07"},
+			bytes.NewReader(input.Bytes()), io.Discard,
+		),
+		"1 of the keys not found, including ff",
+	)
+
+	return
+}
+
+type testRecord struct {
+	key, val []byte
+	xmv      byte
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A renderer renders a key or value as a field of CSV or TSV.
+type renderer func(b []byte) string
+
+func newRenderer(name string) (r renderer, e error) {
+	switch name {
+	case "utf8":
+		return func(b []byte) string {
+			return strings.ToValidUTF8(string(b), "\uFFFD")
+		}, nil
+
+	case "hex":
+		return hex.EncodeToString, nil
+
+	case "base64":
+		return base64.StdEncoding.EncodeToString, nil
+
+	case "length":
+		return func(b []byte) string {
+			return strconv.Itoa(len(b))
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown rendering %q", name)
+}
+
+// A csvWriter writes records as rows of CSV, or of TSV, headed by the names
+// of the columns. Fields are quoted as RFC 4180 requires, with the tab in
+// place of the comma for TSV, so that any key or value survives the trip to a
+// spreadsheet. Rows are written as they come, so that large inputs need not
+// fit in memory.
+type csvWriter struct {
+	writer    *csv.Writer
+	renderKey renderer
+	renderVal renderer
+	row       []string
+}
+
+func newCSVWriter(writer io.Writer, tabs bool, key, val string) (
+	w *csvWriter, e error,
+) {
+	w = &csvWriter{
+		writer: csv.NewWriter(writer),
+		row:    make([]string, 3),
+	}
+
+	if tabs {
+		w.writer.Comma = '\t'
+	}
+
+	w.renderKey, e = newRenderer(key)
+	if e != nil {
+		return nil, e
+	}
+
+	w.renderVal, e = newRenderer(val)
+	if e != nil {
+		return nil, e
+	}
+
+	e = w.writer.Write([]string{"key", "val", "xmv"})
+	if e != nil {
+		return nil, e
+	}
+
+	return
+}
+
+func (w *csvWriter) Write(key, val []byte, xmv byte) error {
+	w.row[0] = w.renderKey(key)
+	w.row[1] = w.renderVal(val)
+	w.row[2] = strconv.Itoa(int(xmv))
+
+	return w.writer.Write(w.row)
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+
+	return w.writer.Error()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestConvertCSV(t *testing.T) {
+	var (
+		input  bytes.Buffer
+		output bytes.Buffer
+		writer = blWriter{bl.NewEncoder(&input, nil)}
+	)
+
+	assert.NoError(t,
+		writer.Write([]byte("a,b"), []byte("say \"hi\"\n"), 3),
+	)
+	assert.NoError(t,
+		writer.Write([]byte("c\td"), []byte{0xff, 0}, 0),
+	)
+	assert.NoError(t,
+		writer.Close(),
+	)
+
+	assert.NoError(t,
+		run("convert", []string{"-to", "csv"},
+			bytes.NewReader(input.Bytes()), &output,
+		),
+	)
+	assert.Equal(t,
+		"key,val,xmv\n"+
+			"\"a,b\",\"say \"\"hi\"\"\n\",3\n"+
+			"c\td,\uFFFD\x00,0\n",
+		output.String(),
+	)
+
+	output.Reset()
+
+	assert.NoError(t,
+		run("convert",
+			[]string{"-to", "tsv", "-render-key", "base64",
+				"-render-val", "length",
+			},
+			bytes.NewReader(input.Bytes()), &output,
+		),
+	)
+	assert.Equal(t,
+		"key\tval\txmv\nYSxi\t9\t3\nYwlk\t2\t0\n",
+		output.String(),
+	)
+
+	output.Reset()
+
+	assert.NoError(t,
+		run("convert", []string{"-to", "tsv", "-render-val", "hex"},
+			bytes.NewReader(input.Bytes()), &output,
+		),
+	)
+	assert.Equal(t,
+		"key\tval\txmv\n"+
+			"a,b\t73617920226869220a\t3\n"+
+			"\"c\td\"\tff00\t0\n",
+		output.String(),
+	)
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-to", "csv", "-render-key", "rot13"},
+			bytes.NewReader(input.Bytes()), &output,
+		),
+		"unknown rendering",
+	)
+
+	return
+}
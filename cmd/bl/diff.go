@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A diffJSON is the report of diff in JSON. Keys are base64-encoded, as
+// encoding/json does for byte slices, and lists are never null.
+type diffJSON struct {
+	Equal     bool     `json:"equal"`
+	OnlyInA   [][]byte `json:"only_in_a"`
+	OnlyInB   [][]byte `json:"only_in_b"`
+	Differing [][]byte `json:"differing"`
+	Matching  int      `json:"matching"`
+}
+
+func diff(args []string, stdin io.Reader, stdout io.Writer) (e error) {
+	var (
+		flags    = flag.NewFlagSet("diff", flag.ContinueOnError)
+		checksum = flags.String("checksum", "",
+			"checksum of records: crc32c, fnv32a, crc32, or none if empty",
+		)
+		digests = flags.Bool("digests", false,
+			"compare digests of values, retaining less in memory",
+		)
+		artifact = flags.String("artifact", "",
+			"if not empty, compare the named artifacts of two bundles, "+
+				"verifying the bundles",
+		)
+		output = flags.String("output", "text",
+			"format of the report: text, a line per key, or json, an object "+
+				"for scripts",
+		)
+
+		a, b    io.ReadCloser
+		bundles [2]*bl.BundleReader
+		hasher  hash.Hash32
+		i       int
+		inputs  [2]io.Reader
+		key     []byte
+		opts    []bl.Option
+		report  bl.DiffReport
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl diff [flags] a|- b|-")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+
+		return fmt.Errorf("two streams required")
+	}
+
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	hasher, e = newHasher(*checksum)
+	if e != nil {
+		return
+	}
+
+	if *digests {
+		opts = append(opts, bl.WithValueDigests())
+	}
+
+	if flags.Arg(0) == stdio && flags.Arg(1) == stdio {
+		return fmt.Errorf("at most one stream may be standard input")
+	}
+
+	a, e = openInput(flags.Arg(0), stdin)
+	if e != nil {
+		return
+	}
+
+	defer a.Close()
+
+	b, e = openInput(flags.Arg(1), stdin)
+	if e != nil {
+		return
+	}
+
+	defer b.Close()
+
+	inputs = [2]io.Reader{a, b}
+
+	if *artifact != "" {
+		for i = range inputs {
+			bundles[i], e = openArtifact(inputs[i], *artifact)
+			if e != nil {
+				return fmt.Errorf("%s: %w", flags.Arg(i), e)
+			}
+
+			inputs[i] = bundles[i]
+		}
+	}
+
+	report, e = bl.Compare(inputs[0], inputs[1], hasher, opts...)
+	if e != nil {
+		return
+	}
+
+	for i = range bundles {
+		if bundles[i] == nil {
+			continue
+		}
+
+		e = verifyBundle(bundles[i])
+		if e != nil {
+			return fmt.Errorf("%s: %w", flags.Arg(i), e)
+		}
+	}
+
+	switch *output {
+	case "json":
+		e = json.NewEncoder(stdout).Encode(
+			diffJSON{
+				Equal:     report.Equal(),
+				OnlyInA:   append([][]byte{}, report.OnlyInA...),
+				OnlyInB:   append([][]byte{}, report.OnlyInB...),
+				Differing: append([][]byte{}, report.Differing...),
+				Matching:  report.Matching,
+			},
+		)
+		if e != nil {
+			return
+		}
+
+	case "text":
+		for _, key = range report.OnlyInA {
+			fmt.Fprintf(stdout, "- %q\n", key)
+		}
+
+		for _, key = range report.OnlyInB {
+			fmt.Fprintf(stdout, "+ %q\n", key)
+		}
+
+		for _, key = range report.Differing {
+			fmt.Fprintf(stdout, "~ %q\n", key)
+		}
+	}
+
+	if !report.Equal() {
+		return fmt.Errorf("streams differ: %d only in %s, %d only in %s, "+
+			"%d differing, %d matching",
+			len(report.OnlyInA), flags.Arg(0),
+			len(report.OnlyInB), flags.Arg(1),
+			len(report.Differing), report.Matching,
+		)
+	}
+
+	return
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestDiff(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		output bytes.Buffer
+		stream bytes.Buffer
+	)
+
+	bl.NewEncoder(&stream, nil).Encode([]byte("a"), []byte("val"))
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "a"), stream.Bytes(), 0o644),
+	)
+
+	bl.NewEncoder(&stream, nil).Encode([]byte("b"), []byte("val"))
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "b"), stream.Bytes(), 0o644),
+	)
+
+	assert.NoError(t,
+		run("diff",
+			[]string{filepath.Join(dir, "a"), filepath.Join(dir, "a")},
+			nil, &output,
+		),
+	)
+
+	assert.Empty(t, output.String())
+
+	assert.ErrorContains(t,
+		run("diff",
+			[]string{"-digests",
+				filepath.Join(dir, "a"), filepath.Join(dir, "b"),
+			},
+			nil, &output,
+		),
+		"streams differ: 0 only in",
+	)
+
+	assert.Equal(t, "+ \"b\"\n", output.String())
+
+	output.Reset()
+
+	assert.ErrorContains(t,
+		run("diff",
+			[]string{"-output", "json",
+				filepath.Join(dir, "a"), filepath.Join(dir, "b"),
+			},
+			nil, &output,
+		),
+		"streams differ",
+	)
+
+	assert.JSONEq(t,
+		`{"equal":false,"only_in_a":[],"only_in_b":["Yg=="],`+
+			`"differing":[],"matching":1}`,
+		output.String(),
+	)
+
+	return
+}
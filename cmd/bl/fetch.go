@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A databaseWriter is a recordWriter that writes the records of several
+// databases, those of each following a call to SetDatabase, and the flags and
+// custom comparator of each database given by SetDatabaseFlags and
+// SetComparator before its first record.
+type databaseWriter interface {
+	recordWriter
+	SetDatabase(name string) error
+	SetDatabaseFlags(flags bl.DatabaseFlags) error
+	SetComparator(id string) error
+}
+
+// A fetcher pulls the databases of an environment served by bl serve, one at
+// a time, resuming the transfer of a database after the last record received
+// if it is interrupted, and then ends its session at the server.
+type fetcher struct {
+	base     *url.URL
+	client   *http.Client
+	user     string
+	password string
+	checksum string
+	retries  int
+	delay    time.Duration
+	progress io.Writer
+	records  int64
+	reported time.Time
+
+	// opts configure the Decoders of the databases, and warnings, if not
+	// nil, receives a warning about each database loaded without its
+	// custom comparator.
+	opts     []bl.Option
+	warnings io.Writer
+	warned   map[string]bool
+}
+
+// A writeError is an error in writing records locally, as opposed to in
+// fetching them, and so is not retried.
+type writeError struct {
+	error
+}
+
+func (e writeError) Unwrap() error {
+	return e.error
+}
+
+func fetch(args []string, stdout io.Writer) (e error) {
+	var (
+		flags = flag.NewFlagSet("fetch", flag.ContinueOnError)
+		out   = flags.String("o", "",
+			"if not empty or -, write the stream to this file rather than "+
+				"to standard output",
+		)
+		force = flags.Bool("force", false,
+			"write the stream even to a terminal",
+		)
+		load = flags.String("load", "",
+			"if not empty, load the records into this LMDB environment "+
+				"with mdb_load rather than write a stream",
+		)
+		mdbLoad = flags.String("mdb-load", "mdb_load",
+			"path of the mdb_load utility of LMDB",
+		)
+		noSubdir = flags.Bool("no-subdir", false,
+			"the environment given by -load is a file rather than a "+
+				"directory, as for mdb_load -n",
+		)
+		db = flags.String("db", "",
+			"if given, fetch only this database, or the main database if "+
+				"empty, rather than every named database",
+		)
+		checksum = flags.String("checksum", "",
+			"checksum of records, verified on receipt and appended to "+
+				"those written: crc32c, fnv32a, crc32, or none if empty",
+		)
+		authFile = flags.String("auth-file", "",
+			"if not empty, a file holding user:password, sent by HTTP "+
+				"basic authentication",
+		)
+		retries = flags.Int("retries", 3,
+			"number of times to resume a transfer that fails without "+
+				"progress",
+		)
+		delay = flags.Duration("retry-delay", time.Second,
+			"delay before resuming a transfer",
+		)
+		progress = flags.Bool("progress", false,
+			"report progress on standard error every second",
+		)
+		unknownComparators = flags.Bool("unknown-comparators", false,
+			"load databases ordered by a custom comparator, which "+
+				"mdb_load cannot apply, under the default comparator "+
+				"rather than fail",
+		)
+
+		auth      []byte
+		command   *exec.Cmd
+		databases []string
+		f         *fetcher
+		file      *os.File
+		hasher    hash.Hash32
+		name      string
+		ok        bool
+		output    = bufio.NewWriter(stdout)
+		stdin     io.WriteCloser
+		writer    databaseWriter
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl fetch [flags] url")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+
+		return fmt.Errorf("expected one URL, got %d", flags.NArg())
+	}
+
+	if *out != "" && *load != "" {
+		return fmt.Errorf("-o and -load are mutually exclusive")
+	}
+
+	f = &fetcher{
+		client:   http.DefaultClient,
+		checksum: *checksum,
+		retries:  *retries,
+		delay:    *delay,
+		warned:   make(map[string]bool),
+	}
+
+	// A stream carries the comparators of its databases, whereas mdb_load
+	// cannot apply them.
+
+	if *load == "" || *unknownComparators {
+		f.opts = append(f.opts, bl.WithUnknownComparators())
+	}
+
+	if *load != "" {
+		f.warnings = os.Stderr
+	}
+
+	f.base, e = url.Parse(
+		strings.TrimSuffix(flags.Arg(0), "/"),
+	)
+	if e != nil {
+		return
+	}
+
+	if *progress {
+		f.progress = os.Stderr
+	}
+
+	if *authFile != "" {
+		auth, e = os.ReadFile(*authFile)
+		if e != nil {
+			return
+		}
+
+		f.user, f.password, ok = strings.Cut(
+			strings.TrimSpace(string(auth)), ":",
+		)
+		if !ok || f.user == "" {
+			return fmt.Errorf("auth file not of the form user:password")
+		}
+	}
+
+	databases = []string{*db}
+
+	if !isFlagSet(flags, "db") {
+		databases, e = f.databases()
+		if e != nil {
+			return
+		}
+	}
+
+	if *load == "" && (*out == "" || *out == stdio) {
+		e = checkBinaryOutput(stdout, *force)
+		if e != nil {
+			return
+		}
+	}
+
+	switch {
+	case *load != "":
+		command = exec.Command(*mdbLoad)
+
+		if *noSubdir {
+			command.Args = append(command.Args, "-n")
+		}
+
+		command.Args = append(command.Args, *load)
+		command.Stdout = os.Stderr
+		command.Stderr = os.Stderr
+
+		stdin, e = command.StdinPipe()
+		if e != nil {
+			return
+		}
+
+		e = command.Start()
+		if e != nil {
+			return
+		}
+
+		defer func() {
+			stdin.Close()
+
+			if command.Wait() != nil && e == nil {
+				e = fmt.Errorf("%s failed", *mdbLoad)
+			}
+		}()
+
+		output = bufio.NewWriter(stdin)
+		writer = newMDBDumpWriter(output)
+
+	case *out != "" && *out != stdio:
+		file, e = os.Create(*out)
+		if e != nil {
+			return
+		}
+
+		defer func() {
+			if file.Close() != nil && e == nil {
+				e = fmt.Errorf("could not close %s", *out)
+			}
+		}()
+
+		output = bufio.NewWriter(file)
+
+		fallthrough
+
+	default:
+		hasher, e = newHasher(*checksum)
+		if e != nil {
+			return
+		}
+
+		writer = blWriter{bl.NewEncoder(output, hasher)}
+	}
+
+	for _, name = range databases {
+		e = writer.SetDatabase(name)
+		if e != nil {
+			return
+		}
+
+		e = f.fetchDatabase(name, writer)
+		if e != nil {
+			return fmt.Errorf("could not fetch database %q: %w", name, e)
+		}
+	}
+
+	e = writer.Close()
+	if e != nil {
+		return
+	}
+
+	e = output.Flush()
+	if e != nil {
+		return
+	}
+
+	e = f.end()
+	if e != nil {
+		return fmt.Errorf("could not end session: %w", e)
+	}
+
+	if f.progress != nil {
+		fmt.Fprintf(f.progress, "fetched %d records from %d databases\n",
+			f.records, len(databases),
+		)
+	}
+
+	return
+}
+
+func (f *fetcher) databases() (names []string, e error) {
+	// Returns the names of the named databases listed by the server, or
+	// that of the main database alone if there are none.
+
+	var (
+		body     []byte
+		response *http.Response
+	)
+
+	response, e = f.get("/databases", nil)
+	if e != nil {
+		return
+	}
+
+	defer response.Body.Close()
+
+	body, e = io.ReadAll(response.Body)
+	if e != nil {
+		return
+	}
+
+	names = strings.Fields(
+		string(body),
+	)
+
+	if len(names) == 0 {
+		names = []string{""}
+	}
+
+	return
+}
+
+func (f *fetcher) fetchDatabase(name string, writer databaseWriter) (
+	e error,
+) {
+	// Fetches the records of the named database and writes them, resuming
+	// after the last record written if the transfer fails, until it fails
+	// f.retries times in a row without progress.
+
+	var (
+		after    []byte
+		failures int
+		received int64
+	)
+
+	for {
+		received, after, e = f.attempt(name, after, writer)
+
+		switch {
+		case e == nil:
+			return
+
+		case errors.As(e, new(writeError)),
+			errors.As(e, new(bl.UnknownComparatorError)):
+			return
+		}
+
+		if received > 0 {
+			failures = 0
+		}
+
+		failures++
+
+		if failures > f.retries {
+			return
+		}
+
+		if f.progress != nil {
+			fmt.Fprintf(f.progress, "resuming after %d records: %v\n",
+				f.records, e,
+			)
+		}
+
+		time.Sleep(f.delay)
+	}
+}
+
+func (f *fetcher) attempt(name string, after []byte, writer databaseWriter) (
+	received int64, last []byte, e error,
+) {
+	// Requests the records of the named database whose keys sort after
+	// after, if not nil, and writes them, returning the number received and
+	// the key of the last, or after if none.
+
+	var (
+		decoder  *bl.Decoder
+		hasher   hash.Hash32
+		key      []byte
+		query    = url.Values{"db": {name}}
+		response *http.Response
+		val      []byte
+		xmv      byte
+	)
+
+	last = after
+
+	if after != nil {
+		query.Set("after", hex.EncodeToString(after))
+	}
+
+	response, e = f.get("/dump", query)
+	if e != nil {
+		return
+	}
+
+	defer response.Body.Close()
+
+	hasher, e = newHasher(f.checksum)
+	if e != nil {
+		return
+	}
+
+	decoder = bl.NewDecoder(response.Body, hasher, f.opts...)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			return received, last, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		// Keys ordered by a custom comparator cannot be checked.
+
+		if last != nil && decoder.Comparator() == "" &&
+			decoder.DatabaseFlags().Compare(key, last) <= 0 {
+			return received, last,
+				fmt.Errorf("server sent key out of order")
+		}
+
+		f.warnComparator(name,
+			decoder.Comparator(),
+		)
+
+		e = writer.SetDatabaseFlags(
+			decoder.DatabaseFlags(),
+		)
+		if e == nil {
+			e = writer.SetComparator(
+				decoder.Comparator(),
+			)
+		}
+
+		if e == nil {
+			e = writer.Write(key, val, xmv)
+		}
+
+		if e != nil {
+			return received, last, writeError{e}
+		}
+
+		received++
+		last = key
+
+		f.report()
+	}
+}
+
+func (f *fetcher) warnComparator(name, comparator string) {
+	// Warns, once per database, that the named database is loaded without
+	// its custom comparator, if so configured.
+
+	if f.warnings == nil || comparator == "" || f.warned[name] {
+		return
+	}
+
+	fmt.Fprintf(f.warnings, "warning: database %q is ordered by custom "+
+		"comparator %q, which mdb_load cannot apply\n", name, comparator,
+	)
+
+	f.warned[name] = true
+}
+
+func (f *fetcher) end() (e error) {
+	// Ends the session at the server, so that a server serving one fetch
+	// exits, now that every database has been fetched.
+
+	var (
+		response *http.Response
+	)
+
+	response, e = f.request(http.MethodPost, "/done", nil)
+	if e != nil {
+		return
+	}
+
+	response.Body.Close()
+
+	return
+}
+
+func (f *fetcher) get(path string, query url.Values) (
+	response *http.Response, e error,
+) {
+	return f.request(http.MethodGet, path, query)
+}
+
+func (f *fetcher) request(method, path string, query url.Values) (
+	response *http.Response, e error,
+) {
+	var (
+		request *http.Request
+		target  = *f.base
+	)
+
+	target.Path += path
+	target.RawQuery = query.Encode()
+
+	request, e = http.NewRequest(method, target.String(), nil)
+	if e != nil {
+		return
+	}
+
+	if f.user != "" {
+		request.SetBasicAuth(f.user, f.password)
+	}
+
+	response, e = f.client.Do(request)
+	if e != nil {
+		return
+	}
+
+	if response.StatusCode/100 != 2 {
+		response.Body.Close()
+
+		return nil, fmt.Errorf("server responded %s", response.Status)
+	}
+
+	return
+}
+
+func (f *fetcher) report() {
+	// Counts a record fetched, reporting progress at most once a second.
+
+	f.records++
+
+	if f.progress == nil || time.Since(f.reported) < time.Second {
+		return
+	}
+
+	f.reported = time.Now()
+
+	fmt.Fprintf(f.progress, "fetched %d records\n", f.records)
+
+	return
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) (set bool) {
+	// Returns true if the named flag was given on the command line.
+
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+
+	return
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetch(t *testing.T) {
+	var (
+		address string
+		dir     = t.TempDir()
+		done    <-chan error
+		dumped  []byte
+		e       error
+		mdbDump = filepath.Join(dir, "mdb_dump")
+		mdbLoad = filepath.Join(dir, "mdb_load")
+		proxy   *httptest.Server
+		stream  strings.Builder
+	)
+
+	assert.NoError(t, os.WriteFile(mdbDump, []byte(testMDBDump), 0o755))
+
+	assert.NoError(t,
+		os.WriteFile(mdbLoad, []byte("#!/bin/sh\ncat > \"$1\"\n"), 0o755),
+	)
+
+	// A one-shot server stays up for a fetch of every database, one at a
+	// time, resumed after a transfer cut short, and exits once it is done.
+
+	address, done = startServe(t, "-mdb-dump", mdbDump, dir)
+
+	proxy = httptest.NewServer(
+		cuttingProxy(address),
+	)
+
+	defer proxy.Close()
+
+	assert.NoError(t,
+		run("fetch", []string{"-retry-delay", "0", proxy.URL}, nil, &stream),
+	)
+
+	assert.Equal(t,
+		[]string{
+			"users/a=1", "users/b=2", "users/c=3",
+			"groups/a=1", "groups/b=2", "groups/c=3",
+		},
+		decodeAll(t,
+			strings.NewReader(stream.String()),
+		),
+	)
+
+	assertServeDone(t, done)
+
+	// A failed fetch does not end the session, and a later one does.
+
+	address, done = startServe(t, "-mdb-dump", mdbDump, dir)
+
+	assert.ErrorContains(t,
+		run("fetch",
+			[]string{"-db", "users", "-retries", "0", address + "/nowhere"},
+			nil, io.Discard,
+		),
+		"404 Not Found",
+	)
+
+	assert.NoError(t,
+		run("fetch",
+			[]string{"-db", "groups", "-mdb-load", mdbLoad,
+				"-load", filepath.Join(dir, "loaded"), address,
+			},
+			nil, io.Discard,
+		),
+	)
+
+	assertServeDone(t, done)
+
+	dumped, e = os.ReadFile(filepath.Join(dir, "loaded"))
+
+	assert.NoError(t, e)
+
+	assert.Equal(t,
+		"VERSION=3\nformat=bytevalue\ndatabase=groups\ntype=btree\n"+
+			"HEADER=END\n 61\n 31\n 62\n 32\n 63\n 33\nDATA=END\n",
+		string(dumped),
+	)
+
+	return
+}
+
+func startServe(t *testing.T, args ...string) (
+	address string, done <-chan error,
+) {
+	// Runs bl serve on a free port, returning its URL and a channel that
+	// receives its outcome once it exits.
+
+	var (
+		e       error
+		outcome = make(chan error, 1)
+		output  *io.PipeReader
+		writer  *io.PipeWriter
+	)
+
+	output, writer = io.Pipe()
+
+	go func() {
+		outcome <- run("serve",
+			append([]string{"-addr", "127.0.0.1:0"}, args...),
+			nil, writer,
+		)
+
+		writer.Close()
+	}()
+
+	address, e = bufio.NewReader(output).ReadString('\n')
+
+	assert.NoError(t, e)
+
+	go io.Copy(io.Discard, output)
+
+	return "http://" + strings.Fields(address)[3], outcome
+}
+
+func assertServeDone(t *testing.T, done <-chan error) {
+	select {
+	case e := <-done:
+		assert.NoError(t, e)
+
+	case <-time.After(10 * time.Second):
+		t.Fatal("bl serve still serving")
+	}
+
+	return
+}
+
+func cuttingProxy(address string) http.Handler {
+	// Forwards requests to the server at address, cutting the first dump
+	// short, after all but its last record.
+
+	var (
+		cut   bool
+		mutex sync.Mutex
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			body     []byte
+			e        error
+			request  *http.Request
+			response *http.Response
+		)
+
+		request, e = http.NewRequest(r.Method,
+			address+r.URL.RequestURI(), nil,
+		)
+		if e != nil {
+			panic(e)
+		}
+
+		response, e = http.DefaultClient.Do(request)
+		if e != nil {
+			panic(http.ErrAbortHandler)
+		}
+
+		defer response.Body.Close()
+
+		body, _ = io.ReadAll(response.Body)
+
+		mutex.Lock()
+
+		if cut || r.URL.Path != "/dump" {
+			mutex.Unlock()
+
+			w.WriteHeader(response.StatusCode)
+			w.Write(body)
+
+			return
+		}
+
+		cut = true
+
+		mutex.Unlock()
+
+		w.Write(body[:len(body)-3])
+		w.(http.Flusher).Flush()
+
+		panic(http.ErrAbortHandler)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"unicode/utf8"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func get(args []string, stdin io.Reader, stdout io.Writer) (e error) {
+	var (
+		flags    = flag.NewFlagSet("get", flag.ContinueOnError)
+		checksum = flags.String("checksum", "",
+			"checksum of records: crc32c, fnv32a, crc32, or none if empty",
+		)
+		keyFormat = flags.String("key-format", "hex",
+			"encoding of the key argument: hex or raw",
+		)
+		format = flags.String("format", "raw",
+			"encoding of the value printed: raw, hex or base64",
+		)
+		force = flags.Bool("force", false,
+			"print a raw value that is not text even to a terminal",
+		)
+
+		file     io.ReadCloser
+		found    bool
+		hasher   hash.Hash32
+		key      []byte
+		seekable bool
+		seeker   io.Seeker
+		val      []byte
+
+		apply = func(_, v []byte, _ bl.XMetaValue) error {
+			found, val = true, v
+
+			return nil
+		}
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl get [flags] dump|- key")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+
+		return fmt.Errorf("dump and key required")
+	}
+
+	switch *keyFormat {
+	case "hex":
+		key, e = hex.DecodeString(
+			flags.Arg(1),
+		)
+		if e != nil {
+			return
+		}
+
+	case "raw":
+		key = []byte(
+			flags.Arg(1),
+		)
+
+	default:
+		return fmt.Errorf("unknown key format %q", *keyFormat)
+	}
+
+	if *format != "raw" && *format != "hex" && *format != "base64" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	hasher, e = newHasher(*checksum)
+	if e != nil {
+		return
+	}
+
+	file, e = openInput(flags.Arg(0), stdin)
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	// A dump sorted by key, ideally with seek markers, is searched;
+	// any other is scanned from the start, as is standard input, which
+	// cannot be rewound.
+
+	seeker, seekable = file.(io.Seeker)
+
+	if seekable {
+		_, e = bl.KeyExtraction{
+			Keys:  [][]byte{key},
+			Apply: apply,
+			Seek:  true,
+		}.Run(
+			bl.NewDecoder(file, hasher),
+		)
+	}
+
+	if !seekable || e != nil {
+		if seekable {
+			_, e = seeker.Seek(0, io.SeekStart)
+			if e != nil {
+				return
+			}
+		}
+
+		hasher, e = newHasher(*checksum)
+		if e != nil {
+			return
+		}
+
+		_, e = bl.KeyExtraction{
+			Keys:  [][]byte{key},
+			Apply: apply,
+		}.Run(
+			bl.NewDecoder(file, hasher),
+		)
+		if e != nil {
+			return
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("key %x not found", key)
+	}
+
+	switch *format {
+	case "raw":
+		if !utf8.Valid(val) {
+			e = checkBinaryOutput(stdout, *force)
+			if e != nil {
+				return
+			}
+		}
+
+		_, e = stdout.Write(val)
+
+	case "hex":
+		_, e = fmt.Fprintln(stdout, hex.EncodeToString(val))
+
+	case "base64":
+		_, e = fmt.Fprintln(stdout, base64.StdEncoding.EncodeToString(val))
+	}
+
+	if e != nil {
+		return
+	}
+
+	return
+}
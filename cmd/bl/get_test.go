@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestGet(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		encoder *bl.Encoder
+		i       int
+		name    string
+		others  bytes.Buffer
+		output  bytes.Buffer
+		sorted  bytes.Buffer
+	)
+
+	for _, encoder = range []*bl.Encoder{
+		bl.NewEncoder(&sorted, bl.NewCRC32C(),
+			bl.WithSortedKeys(), bl.WithSeekMarkers(8),
+		),
+		bl.NewEncoder(&others, bl.NewCRC32C()),
+	} {
+		for i = 0; i < 100; i++ {
+			assert.NoError(t,
+				encoder.Encode([]byte(fmt.Sprintf("key%03d", i)),
+					[]byte(fmt.Sprint(i)),
+				),
+			)
+		}
+
+		assert.NoError(t,
+			encoder.Close(),
+		)
+	}
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "sorted.bl"), sorted.Bytes(), 0o644),
+	)
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "others.bl"), others.Bytes(), 0o644),
+	)
+
+	for _, name = range []string{"sorted.bl", "others.bl"} {
+		output.Reset()
+
+		assert.NoError(t,
+			run("get",
+				[]string{"-checksum", "crc32c", "-key-format", "raw",
+					filepath.Join(dir, name), "key042",
+				},
+				nil, &output,
+			),
+		)
+		assert.Equal(t, "42", output.String(), name)
+
+		output.Reset()
+
+		assert.NoError(t,
+			run("get",
+				[]string{"-checksum", "crc32c", "-format", "base64",
+					filepath.Join(dir, name), "6b6579303939",
+				},
+				nil, &output,
+			),
+		)
+		assert.Equal(t, "OTk=\n", output.String(), name)
+
+		assert.ErrorContains(t,
+			run("get",
+				[]string{"-checksum", "crc32c", "-key-format", "raw",
+					filepath.Join(dir, name), "key100",
+				},
+				nil, io.Discard,
+			),
+			"not found",
+		)
+	}
+
+	return
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// A jsonRecord is a line of JSONL. Keys and values are base64-encoded, as
+// encoding/json does for byte slices.
+type jsonRecord struct {
+	Key []byte `json:"key"`
+	Val []byte `json:"val"`
+	XMV byte   `json:"xmv,omitempty"`
+}
+
+type jsonlReader struct {
+	decoder *json.Decoder
+}
+
+func newJSONLReader(reader io.Reader) jsonlReader {
+	return jsonlReader{
+		decoder: json.NewDecoder(reader),
+	}
+}
+
+func (r jsonlReader) Read() (key, val []byte, xmv byte, e error) {
+	var (
+		record jsonRecord
+	)
+
+	e = r.decoder.Decode(&record)
+	if e != nil {
+		return
+	}
+
+	if record.XMV > 0xf {
+		return nil, nil, 0, fmt.Errorf("extended metadata value %d too large",
+			record.XMV,
+		)
+	}
+
+	return record.Key, record.Val, record.XMV, nil
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func newJSONLWriter(writer io.Writer) jsonlWriter {
+	return jsonlWriter{
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+func (w jsonlWriter) Write(key, val []byte, xmv byte) error {
+	return w.encoder.Encode(
+		jsonRecord{Key: key, Val: val, XMV: xmv},
+	)
+}
+
+func (w jsonlWriter) Close() error {
+	return nil
+}
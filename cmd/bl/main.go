@@ -0,0 +1,217 @@
+// Command bl converts between bottled-lightning streams and other
+// representations of LMDB records, and benchmarks the codec.
+//
+// Usage:
+//
+//	bl <command> [flags]
+//
+// The commands are:
+//
+//	bench     measure encode, decode and verify throughput on a synthetic
+//	          workload
+//	bundle    package artifacts such as streams in a bundle on standard
+//	          output
+//	completion
+//	          print the shell completion script of bash, zsh or fish
+//	convert   convert records read on standard input to another format
+//	diff      report the differences between two streams
+//	fetch     pull the databases of an environment served by bl serve,
+//	          resuming interrupted transfers, into a stream or environment
+//	get       print the value of a key in a stream file
+//	manifest  describe streams of a snapshot in a manifest on standard output
+//	replicate replicate an LMDB environment to streams, as a snapshot and
+//	          then its changes, as described by a configuration file
+//	serve     serve the databases of an LMDB environment as streams over
+//	          HTTP until the first complete dump or fetch
+//	unbundle  extract and verify the artifacts of a bundle read on standard
+//	          input
+//
+// The serve command dumps an environment with the mdb_dump utility of LMDB,
+// which opens it read-only, at /dump, or a single database at /dump?db=name.
+// Given after=hex, the hexadecimal encoding of a key, it serves only the
+// records of the database whose keys sort after it, so that an interrupted copy
+// can be resumed, as does the fetch command; it resumes after a key, and does
+// not carry changes to the keys before it. Given -index-dir, serve keeps the
+// index of each dump of a single database, named in the Bl-Index header of the
+// response, and since=name dumps only the changes since that dump, with
+// tombstones of the keys deleted, as an incremental dump. Unless given
+// -keep-serving, it exits after a complete dump of every database at /dump, or
+// once a client ends its session with a POST to /done, as the fetch command
+// does after fetching every database it was asked for. A copy across hosts is
+// then a one-liner on each side:
+//
+//	bl serve -checksum fnv32a /var/lib/app/data
+//	bl fetch -checksum fnv32a -load /var/lib/app/data http://host:8080
+//
+// Given -key or -keys, convert extracts only the records of the listed keys,
+// stopping once it has found them all, so that a few corrupted records can be
+// restored from a full dump without loading the rest:
+//
+//	bl convert -to mdbdump -key 6b6579 < full.bl | mdb_load -n data.mdb
+//
+// For spreadsheets, convert writes CSV or TSV, rendering keys and values
+// as UTF-8, hex, base64 or their length, as given by -render-key and
+// -render-val:
+//
+//	bl convert -to csv -render-val length < backup.bl > sizes.csv
+//
+// For analytics at scale, it writes Parquet files, which DuckDB or Spark
+// query directly, holding the key, value or its digest, sizes and metadata
+// of every record:
+//
+//	bl convert -to parquet -digest-values < backup.bl > backup.parquet
+//
+// For ordinary Unix tools, and golden files, it writes records as files
+// under the directory given by -dir, a file per record, at a path made of
+// its key, whose slashes separate directories and whose other awkward bytes
+// are escaped as %XX, and reads such a tree back:
+//
+//	bl convert -to tree -dir /tmp/backup < backup.bl
+//	grep -rl needle /tmp/backup
+//	bl convert -from tree -dir /tmp/backup > golden.bl
+//
+// The get command tells what a key held at the time of a dump without a
+// restore. It searches a stream sorted by key, binary-searching its seek
+// markers if it has any, and scans any other stream:
+//
+//	bl get -key-format raw -format hex backup.bl user/42
+//
+// Bundles are tar archives listing the SHA-256 digest of each artifact. The
+// convert and diff commands read a stream from within bundles directly if
+// given the -artifact flag. If a bundle holds a manifest.json, as written by
+// the manifest command, unbundle also verifies the streams that it describes.
+//
+// Commands read streams from the files named, or from standard input in
+// place of a file named -, and write to standard output unless given -o, so
+// that they chain in pipelines without temporary files:
+//
+//	bl fetch http://host:8080 | bl convert -to jsonl | jq -r .key
+//	bl fetch http://host:8080 | bl diff yesterday.bl -
+//
+// Commands that write binary output refuse to write it to a terminal unless
+// given -force, and those that read a stream refuse to wait on a terminal.
+//
+// For scripts, diff reports in JSON given -output json, listing the keys
+// only in either stream, and those differing, in base64:
+//
+//	bl diff -output json a.bl b.bl | jq -r '.only_in_b[] | @base64d'
+//
+// Shells complete commands, flags and the values of flags by the script that
+// the completion command prints:
+//
+//	source <(bl completion bash)
+//	bl completion fish > ~/.config/fish/completions/bl.fish
+//
+// The replicate command keeps copies of an environment up to date. Its first
+// pass sends a snapshot of the records, and each pass after it, an interval
+// apart, the records changed since, and tombstones of those deleted, each
+// pass ending with a checkpoint marker holding its sequence number. As LMDB
+// keeps no log of changes, every pass dumps the environment, and tells what
+// changed by the digests of the records kept in a state file. Destinations
+// are connections, which receive every pass, or directories, in which each
+// pass with changes is written to a file named after its sequence number.
+// Given listen, it serves /healthz and Prometheus metrics at /metrics:
+//
+//	{
+//		"environment": "/var/lib/app/data",
+//		"databases": ["users"],
+//		"prefixes": ["user/"],
+//		"destinations": ["tcp://replica:9000", "/var/backups/app"],
+//		"state": "/var/lib/bl/app.state",
+//		"interval": "30s",
+//		"listen": "localhost:9100"
+//	}
+//
+// Run "bl <command> -h" for the flags of a command.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	usage = `usage: bl <command> [flags]
+
+commands:
+  bench     measure encode, decode and verify throughput on a synthetic
+            workload
+  bundle    package artifacts such as streams in a bundle on standard
+            output
+  completion
+            print the shell completion script of bash, zsh or fish
+  convert   convert records read on standard input to another format
+  diff      report the differences between two streams
+  fetch     pull the databases of an environment served by bl serve,
+            resuming interrupted transfers, into a stream or environment
+  get       print the value of a key in a stream file
+  manifest  describe streams of a snapshot in a manifest on standard output
+  replicate replicate an LMDB environment to streams, as a snapshot and
+            then its changes, as described by a configuration file
+  serve     serve the databases of an LMDB environment as streams over
+            HTTP until the first complete dump or fetch
+  unbundle  extract and verify the artifacts of a bundle read on standard
+            input
+`
+)
+
+func main() {
+	var (
+		e error
+	)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+
+		os.Exit(2)
+	}
+
+	e = run(os.Args[1], os.Args[2:], os.Stdin, os.Stdout)
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "bl: %v\n", e)
+
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string, stdin io.Reader, stdout io.Writer) (
+	e error,
+) {
+	switch command {
+	case "bench":
+		return bench(args, stdout)
+
+	case "bundle":
+		return bundle(args, stdin, stdout)
+
+	case "completion":
+		return completion(args, stdout)
+
+	case "convert":
+		return convert(args, stdin, stdout)
+
+	case "diff":
+		return diff(args, stdin, stdout)
+
+	case "fetch":
+		return fetch(args, stdout)
+
+	case "get":
+		return get(args, stdin, stdout)
+
+	case "manifest":
+		return manifest(args, stdin, stdout)
+
+	case "replicate":
+		return replicate(args, stdout)
+
+	case "serve":
+		return serve(args, stdout)
+
+	case "unbundle":
+		return unbundle(args, stdin)
+	}
+
+	return fmt.Errorf("unknown command %q\n%s", command, usage)
+}
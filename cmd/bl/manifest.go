@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func manifest(args []string, stdin io.Reader, stdout io.Writer) (e error) {
+	var (
+		flags       = flag.NewFlagSet("manifest", flag.ContinueOnError)
+		environment = flags.String("env", "",
+			"identity of the source LMDB environment",
+		)
+		snapshot = flags.String("snapshot", "", "identifier of the snapshot")
+		parent   = flags.String("parent", "",
+			"identifier of the snapshot of which this is an increment",
+		)
+		checksum = flags.String("checksum", "",
+			"checksum of records: crc32c, fnv32a, crc32, or none if empty",
+		)
+
+		arg    string
+		dbi    string
+		file   io.ReadCloser
+		hasher hash.Hash32
+		m      bl.Manifest
+		name   string
+		ok     bool
+		path   string
+		piped  bool
+		stream bl.ManifestStream
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(),
+			"usage: bl manifest [flags] [dbi=]stream|-...",
+		)
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	m = bl.Manifest{
+		Environment: *environment,
+		Snapshot:    *snapshot,
+		Parent:      *parent,
+		Created:     time.Now().UTC(),
+	}
+
+	for _, arg = range flags.Args() {
+		dbi, path, ok = strings.Cut(arg, "=")
+		if !ok {
+			dbi, path = "", arg
+		}
+
+		// A stream read from standard input is named stdin.
+
+		name = filepath.Base(path)
+
+		if path == stdio {
+			if piped {
+				return fmt.Errorf("at most one stream may be standard input")
+			}
+
+			name, piped = "stdin", true
+		}
+
+		file, e = openInput(path, stdin)
+		if e != nil {
+			return
+		}
+
+		hasher, e = newHasher(*checksum)
+		if e == nil {
+			stream, e = bl.DescribeStream(name, dbi, file, hasher)
+		}
+
+		file.Close()
+
+		if e != nil {
+			return
+		}
+
+		m.Streams = append(m.Streams, stream)
+	}
+
+	return bl.WriteManifest(stdout, m)
+}
+
+func verifyManifest(dir string) (e error) {
+	// Validates the manifest among the artifacts extracted to dir, if any, and
+	// verifies the streams that it describes.
+
+	var (
+		file   *os.File
+		m      bl.Manifest
+		stream bl.ManifestStream
+	)
+
+	file, e = os.Open(
+		filepath.Join(dir, bl.ManifestName),
+	)
+	if os.IsNotExist(e) {
+		return nil
+	}
+
+	if e != nil {
+		return
+	}
+
+	m, e = bl.ReadManifest(file)
+
+	file.Close()
+
+	if e != nil {
+		return
+	}
+
+	for _, stream = range m.Streams {
+		switch {
+		case !filepath.IsLocal(stream.Name):
+			return fmt.Errorf("stream name %q not local", stream.Name)
+
+		case stream.Aborted:
+			return fmt.Errorf("stream %q aborted, and so incomplete",
+				stream.Name,
+			)
+		}
+
+		file, e = os.Open(
+			filepath.Join(dir, stream.Name),
+		)
+		if e != nil {
+			return
+		}
+
+		e = stream.Verify(file, nil)
+
+		file.Close()
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestManifest(t *testing.T) {
+	var (
+		bundled  bytes.Buffer
+		dir      = t.TempDir()
+		manifest bytes.Buffer
+		m        bl.Manifest
+		e        error
+		stream   bytes.Buffer
+	)
+
+	bl.NewEncoder(&stream, nil).Encode([]byte("key"), []byte("val"))
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "main.bl"), stream.Bytes(), 0o644),
+	)
+
+	assert.NoError(t,
+		run("manifest",
+			[]string{"-env", "test", "-snapshot", "1",
+				"users=" + filepath.Join(dir, "main.bl"),
+			},
+			nil, &manifest,
+		),
+	)
+
+	m, e = bl.ReadManifest(bytes.NewReader(manifest.Bytes()))
+
+	assert.NoError(t, e)
+	assert.Equal(t, "users", m.Streams[0].DBI)
+	assert.Equal(t, int64(1), m.Streams[0].Records)
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, bl.ManifestName), manifest.Bytes(),
+			0o644,
+		),
+	)
+
+	assert.NoError(t,
+		run("bundle",
+			[]string{
+				filepath.Join(dir, "main.bl"),
+				filepath.Join(dir, bl.ManifestName),
+			},
+			nil, &bundled,
+		),
+	)
+
+	assert.NoError(t,
+		run("unbundle", []string{"-C", t.TempDir()},
+			bytes.NewReader(bundled.Bytes()), nil,
+		),
+	)
+
+	bl.NewEncoder(&stream, nil).Encode([]byte("key"), []byte("val"))
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "main.bl"), stream.Bytes(), 0o644),
+	)
+
+	bundled.Reset()
+
+	assert.NoError(t,
+		run("bundle",
+			[]string{
+				filepath.Join(dir, "main.bl"),
+				filepath.Join(dir, bl.ManifestName),
+			},
+			nil, &bundled,
+		),
+	)
+
+	assert.ErrorContains(t,
+		run("unbundle", []string{"-C", t.TempDir()},
+			bytes.NewReader(bundled.Bytes()), nil,
+		),
+		"does not match manifest",
+	)
+
+	return
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// The text format of mdb_dump consists of a header of name=value lines ending
+// with HEADER=END, then a line per key and per value, each indented by a space
+// and encoded as hexadecimal or, with format=print, as printable characters
+// with backslash escapes, and finally DATA=END. With the -a flag, mdb_dump
+// writes several such sections, one per database. Flags of a database that
+// determine the order of its keys, such as reversekey=1, are in its header.
+// The text format carries no extended metadata.
+
+type mdbDumpReader struct {
+	reader   *bufio.Reader
+	print    bool
+	inData   bool
+	database string
+	flags    bl.DatabaseFlags
+}
+
+func newMDBDumpReader(reader io.Reader) *mdbDumpReader {
+	return &mdbDumpReader{
+		reader: bufio.NewReader(reader),
+	}
+}
+
+func (r *mdbDumpReader) Read() (key, val []byte, xmv byte, e error) {
+	for !r.inData {
+		e = r.readHeader()
+		if e != nil {
+			return
+		}
+	}
+
+	key, e = r.readDatum()
+	if e != nil {
+		return
+	}
+
+	if key == nil {
+		r.inData = false
+
+		return r.Read()
+	}
+
+	val, e = r.readDatum()
+	if e != nil {
+		return nil, nil, 0, unexpectedEOF(e)
+	}
+
+	if val == nil {
+		return nil, nil, 0, fmt.Errorf("mdb_dump key without value")
+	}
+
+	return
+}
+
+// Flags returns the flags, among those of bl.DatabaseFlags, of the database of
+// the section from which the last record was read.
+func (r *mdbDumpReader) Flags() bl.DatabaseFlags {
+	return r.flags
+}
+
+// Database returns the name of the database of the section from which the
+// last record was read, or the empty string for the main database.
+func (r *mdbDumpReader) Database() string {
+	return r.database
+}
+
+func (r *mdbDumpReader) readHeader() (e error) {
+	// Reads a header up to and including HEADER=END, returning io.EOF if there
+	// is none.
+
+	var (
+		line []byte
+	)
+
+	r.print = false
+	r.database = ""
+	r.flags = 0
+
+	for {
+		line, e = r.readLine()
+		if e != nil {
+			if len(line) > 0 {
+				e = unexpectedEOF(e)
+			}
+
+			return
+		}
+
+		switch string(line) {
+		case "HEADER=END":
+			r.inData = true
+
+			return
+
+		case "format=print":
+			r.print = true
+
+		case "format=bytevalue":
+			r.print = false
+
+		case "reversekey=1":
+			r.flags |= bl.DatabaseReverseKey
+
+		default:
+			if bytes.HasPrefix(line, []byte("database=")) {
+				r.database = string(line[len("database="):])
+			}
+		}
+	}
+}
+
+func (r *mdbDumpReader) readDatum() (datum []byte, e error) {
+	// Reads a key or value line and returns it decoded, or nil at DATA=END.
+
+	var (
+		line []byte
+	)
+
+	line, e = r.readLine()
+	if e != nil {
+		return nil, unexpectedEOF(e)
+	}
+
+	if string(line) == "DATA=END" {
+		return nil, nil
+	}
+
+	if len(line) == 0 || line[0] != ' ' {
+		return nil, fmt.Errorf("malformed mdb_dump line %q", line)
+	}
+
+	line = line[1:]
+
+	if !r.print {
+		datum = make([]byte, hex.DecodedLen(len(line)))
+
+		_, e = hex.Decode(datum, line)
+		if e != nil {
+			return
+		}
+
+		return
+	}
+
+	return unescapePrint(line)
+}
+
+func (r *mdbDumpReader) readLine() (line []byte, e error) {
+	line, e = r.reader.ReadBytes('\n')
+
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+
+	if e == io.EOF && len(line) > 0 {
+		return line, nil
+	}
+
+	return
+}
+
+func unescapePrint(line []byte) (datum []byte, e error) {
+	// Decodes the print format, in which a backslash is followed by another
+	// or by two hexadecimal digits.
+
+	var (
+		b uint64
+		i int
+	)
+
+	datum = make([]byte, 0, len(line))
+
+	for i = 0; i < len(line); i++ {
+		if line[i] != '\\' {
+			datum = append(datum, line[i])
+
+			continue
+		}
+
+		if i+1 < len(line) && line[i+1] == '\\' {
+			datum = append(datum, '\\')
+
+			i++
+
+			continue
+		}
+
+		if i+2 >= len(line) {
+			return nil, fmt.Errorf("truncated escape in mdb_dump line")
+		}
+
+		b, e = strconv.ParseUint(string(line[i+1:i+3]), 16, 8)
+		if e != nil {
+			return
+		}
+
+		datum = append(datum, byte(b))
+
+		i += 2
+	}
+
+	return
+}
+
+type mdbDumpWriter struct {
+	writer   io.Writer
+	began    bool
+	database string
+	flags    bl.DatabaseFlags
+}
+
+func newMDBDumpWriter(writer io.Writer) *mdbDumpWriter {
+	return &mdbDumpWriter{
+		writer: writer,
+	}
+}
+
+func (w *mdbDumpWriter) Write(key, val []byte, _ byte) (e error) {
+	var (
+		datum []byte
+	)
+
+	e = w.begin()
+	if e != nil {
+		return
+	}
+
+	for _, datum = range [][]byte{key, val} {
+		_, e = fmt.Fprintf(w.writer, " %x\n", datum)
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (w *mdbDumpWriter) Close() (e error) {
+	e = w.begin()
+	if e != nil {
+		return
+	}
+
+	_, e = io.WriteString(w.writer, "DATA=END\n")
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// SetDatabase causes the records that follow to be written in a section of
+// their own for the named database, or the main database if name is empty,
+// as does mdb_dump -a, unless they would be in the same section already.
+func (w *mdbDumpWriter) SetDatabase(name string) (e error) {
+	if w.began && name == w.database {
+		return
+	}
+
+	if w.began {
+		_, e = io.WriteString(w.writer, "DATA=END\n")
+		if e != nil {
+			return
+		}
+	}
+
+	w.began = false
+	w.database = name
+	w.flags = 0
+
+	return
+}
+
+// SetDatabaseFlags sets the flags of the database of the records that follow,
+// which mdb_load applies when it creates the database, unless the section of
+// the database has begun already.
+func (w *mdbDumpWriter) SetDatabaseFlags(flags bl.DatabaseFlags) (e error) {
+	if w.began {
+		return
+	}
+
+	w.flags = flags
+
+	return
+}
+
+// SetComparator does nothing, since the text format cannot name a custom
+// comparator, nor mdb_load apply one.
+func (w *mdbDumpWriter) SetComparator(string) error {
+	return nil
+}
+
+func (w *mdbDumpWriter) begin() (e error) {
+	var (
+		database string
+	)
+
+	if w.began {
+		return
+	}
+
+	if w.database != "" {
+		database = "database=" + w.database + "\n"
+	}
+
+	if w.flags&bl.DatabaseReverseKey != 0 {
+		database += "reversekey=1\n"
+	}
+
+	_, e = io.WriteString(w.writer,
+		"VERSION=3\nformat=bytevalue\n"+database+"type=btree\nHEADER=END\n",
+	)
+	if e != nil {
+		return
+	}
+
+	w.began = true
+
+	return
+}
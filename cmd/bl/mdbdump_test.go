@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestMDBDumpReader(t *testing.T) {
+	var (
+		e      error
+		key    []byte
+		reader = newMDBDumpReader(
+			strings.NewReader(`VERSION=3
+format=print
+type=btree
+mapsize=1048576
+maxreaders=126
+db_pagesize=4096
+HEADER=END
+ key\\1
+ val\00ue
+DATA=END
+VERSION=3
+format=bytevalue
+database=other
+type=btree
+reversekey=1
+HEADER=END
+ 6b6579
+ 76616c
+DATA=END
+`,
+			),
+		)
+		val []byte
+	)
+
+	key, val, _, e = reader.Read()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte(`key\1`), key)
+	assert.Equal(t, []byte("val\x00ue"), val)
+	assert.Zero(t,
+		reader.Flags(),
+	)
+
+	key, val, _, e = reader.Read()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("key"), key)
+	assert.Equal(t, []byte("val"), val)
+	assert.Equal(t, bl.DatabaseReverseKey, reader.Flags())
+
+	_, _, _, e = reader.Read()
+
+	assert.True(t, errors.Is(e, io.EOF))
+
+	_, _, _, e = newMDBDumpReader(
+		strings.NewReader("HEADER=END\n 6b6579\n"),
+	).Read()
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	return
+}
+
+func TestMDBDumpWriter(t *testing.T) {
+	var (
+		buffer strings.Builder
+		writer = newMDBDumpWriter(&buffer)
+	)
+
+	assert.NoError(t,
+		writer.SetDatabase("reversed"),
+	)
+	assert.NoError(t,
+		writer.SetDatabaseFlags(bl.DatabaseReverseKey),
+	)
+	assert.NoError(t,
+		writer.Write([]byte("key"), []byte("val"), 0),
+	)
+	assert.NoError(t,
+		writer.SetDatabase(""),
+	)
+	assert.NoError(t,
+		writer.Write([]byte("key"), []byte("val"), 0),
+	)
+	assert.NoError(t,
+		writer.Close(),
+	)
+
+	assert.Equal(t,
+		"VERSION=3\nformat=bytevalue\ndatabase=reversed\nreversekey=1\n"+
+			"type=btree\nHEADER=END\n 6b6579\n 76616c\nDATA=END\n"+
+			"VERSION=3\nformat=bytevalue\ntype=btree\nHEADER=END\n"+
+			" 6b6579\n 76616c\nDATA=END\n",
+		buffer.String(),
+	)
+
+	return
+}
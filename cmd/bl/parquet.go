@@ -0,0 +1,19 @@
+package main
+
+import (
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/parquet"
+)
+
+// A parquetWriter writes records as the rows of a Parquet file.
+type parquetWriter struct {
+	writer *parquet.Writer
+}
+
+func (w parquetWriter) Write(key, val []byte, xmv byte) error {
+	return w.writer.Write(key, val, bl.XMetaValue(xmv))
+}
+
+func (w parquetWriter) Close() error {
+	return w.writer.Close()
+}
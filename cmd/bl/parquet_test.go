@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestConvertParquet(t *testing.T) {
+	var (
+		input  bytes.Buffer
+		output bytes.Buffer
+		writer = blWriter{bl.NewEncoder(&input, nil)}
+	)
+
+	assert.NoError(t,
+		writer.Write([]byte("a"), bytes.Repeat([]byte("v"), 1000), 0),
+	)
+	assert.NoError(t,
+		writer.Close(),
+	)
+
+	assert.NoError(t,
+		run("convert",
+			[]string{"-to", "parquet", "-digest-values",
+				"-timestamp", "2024-05-01T00:00:00Z",
+			},
+			bytes.NewReader(input.Bytes()), &output,
+		),
+	)
+	assert.True(t,
+		bytes.HasPrefix(output.Bytes(), []byte("PAR1")),
+	)
+	assert.True(t,
+		bytes.HasSuffix(output.Bytes(), []byte("PAR1")),
+	)
+	assert.Contains(t, output.String(), "value_sha256")
+	assert.Less(t, output.Len(), 1000)
+
+	assert.Error(t,
+		run("convert", []string{"-to", "parquet", "-timestamp", "May 1"},
+			bytes.NewReader(input.Bytes()), &output,
+		),
+	)
+
+	return
+}
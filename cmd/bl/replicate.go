@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A replicateConfig configures bl replicate, as read from its JSON file.
+type replicateConfig struct {
+	// Environment is the path of the source LMDB environment, a file rather
+	// than a directory if NoSubdir, dumped by the mdb_dump utility at
+	// MDBDump, mdb_dump by default.
+	Environment string `json:"environment"`
+	NoSubdir    bool   `json:"no_subdir"`
+	MDBDump     string `json:"mdb_dump"`
+
+	// Databases, if not empty, restricts replication to the named
+	// databases, the main database being named by the empty string, and
+	// Prefixes to the records whose keys begin with one of them.
+	Databases []string `json:"databases"`
+	Prefixes  []string `json:"prefixes"`
+
+	// Destinations receive a stream for every pass: network addresses, of
+	// the form tcp://host:port or unix:///path, to each of which a
+	// connection is made, and directories, in each of which a stream file
+	// is written.
+	Destinations []string `json:"destinations"`
+
+	// State is the path of the file in which the digests of the records
+	// replicated are kept between passes, as a stream.
+	State string `json:"state"`
+
+	// Interval is the time between passes, as a Go duration, 1m by default.
+	Interval string `json:"interval"`
+
+	// Checksum is the checksum of records of the streams written: crc32c,
+	// fnv32a, crc32, or none if empty.
+	Checksum string `json:"checksum"`
+
+	// Listen, if not empty, is the address at which health and metrics are
+	// served.
+	Listen string `json:"listen"`
+}
+
+// A replicaKey identifies a record by its database and key.
+type replicaKey struct {
+	db  string
+	key string
+}
+
+// A replicator replicates an LMDB environment by passes, each of which dumps
+// the environment and transmits, to every destination, a stream of the
+// records changed since the previous pass, and tombstones of those deleted.
+// The first pass, without state, transmits every record, as a snapshot. As
+// LMDB keeps no log of changes, the records replicated are told apart by the
+// digests of the previous pass, kept in the state file, which is only
+// replaced once every destination has received a pass, so that a failed
+// pass is retried in full. Every stream ends with a checkpoint marker
+// identifying the pass by its sequence number, eight bytes big-endian; a pass
+// without changes, sent as a heartbeat, carries the sequence number of the
+// last pass with changes, so that a checkpoint identifier always stands for
+// the same records.
+type replicator struct {
+	config   replicateConfig
+	interval time.Duration
+	log      io.Writer
+
+	mutex     sync.Mutex
+	passes    int64
+	failures  int64
+	records   int64
+	sequence  uint64
+	succeeded time.Time
+	failure   error
+}
+
+func replicate(args []string, stdout io.Writer) (e error) {
+	var (
+		flags  = flag.NewFlagSet("replicate", flag.ContinueOnError)
+		config = flags.String("config", "", "path of the JSON configuration")
+		once   = flags.Bool("once", false,
+			"make a single pass and exit, as from cron, rather than run as a "+
+				"daemon",
+		)
+
+		cancel   context.CancelFunc
+		ctx      context.Context
+		listener net.Listener
+		r        *replicator
+		server   *http.Server
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl replicate -config file")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if *config == "" {
+		flags.Usage()
+
+		return fmt.Errorf("configuration required")
+	}
+
+	r, e = newReplicator(*config)
+	if e != nil {
+		return
+	}
+
+	if *once {
+		return r.pass(
+			context.Background(),
+		)
+	}
+
+	ctx, cancel = signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM,
+	)
+
+	defer cancel()
+
+	if r.config.Listen != "" {
+		listener, e = net.Listen("tcp", r.config.Listen)
+		if e != nil {
+			return
+		}
+
+		server = &http.Server{Handler: r}
+
+		go server.Serve(listener)
+
+		defer server.Close()
+
+		fmt.Fprintf(stdout, "serving health and metrics on %s\n",
+			listener.Addr(),
+		)
+	}
+
+	r.run(ctx)
+
+	return
+}
+
+func newReplicator(path string) (r *replicator, e error) {
+	var (
+		b           []byte
+		decoder     *json.Decoder
+		destination string
+	)
+
+	r = &replicator{
+		log: os.Stderr,
+	}
+
+	b, e = os.ReadFile(path)
+	if e != nil {
+		return
+	}
+
+	decoder = json.NewDecoder(
+		bytes.NewReader(b),
+	)
+
+	decoder.DisallowUnknownFields()
+
+	e = decoder.Decode(&r.config)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %w", path, e)
+	}
+
+	switch {
+	case r.config.Environment == "":
+		return nil, fmt.Errorf("%s: no environment", path)
+
+	case r.config.State == "":
+		return nil, fmt.Errorf("%s: no state file", path)
+
+	case len(r.config.Destinations) == 0:
+		return nil, fmt.Errorf("%s: no destinations", path)
+	}
+
+	for _, destination = range r.config.Destinations {
+		if strings.Contains(destination, "://") &&
+			!strings.HasPrefix(destination, "tcp://") &&
+			!strings.HasPrefix(destination, "unix://") {
+			return nil, fmt.Errorf("%s: unknown destination %q", path,
+				destination,
+			)
+		}
+	}
+
+	r.config.MDBDump = cmp.Or(r.config.MDBDump, "mdb_dump")
+
+	r.interval, e = time.ParseDuration(
+		cmp.Or(r.config.Interval, "1m"),
+	)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %w", path, e)
+	}
+
+	_, e = newHasher(r.config.Checksum)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %w", path, e)
+	}
+
+	return
+}
+
+func (r *replicator) run(ctx context.Context) {
+	// Makes passes, one interval apart, until the context is done.
+
+	var (
+		e     error
+		timer *time.Timer
+	)
+
+	for {
+		e = r.pass(ctx)
+		if e != nil && ctx.Err() == nil {
+			fmt.Fprintf(r.log, "bl replicate: %v\n", e)
+		}
+
+		timer = time.NewTimer(r.interval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *replicator) pass(ctx context.Context) (e error) {
+	// Makes a pass, and accounts for it in the metrics.
+
+	var (
+		changes  int64
+		sequence uint64
+	)
+
+	sequence, changes, e = r.replicate(ctx)
+
+	r.mutex.Lock()
+
+	defer r.mutex.Unlock()
+
+	r.passes++
+	r.failure = e
+
+	if e != nil {
+		r.failures++
+
+		return fmt.Errorf("pass failed: %w", e)
+	}
+
+	r.records += changes
+	r.sequence = sequence
+	r.succeeded = time.Now()
+
+	return
+}
+
+func (r *replicator) replicate(ctx context.Context) (
+	sequence uint64, changes int64, e error,
+) {
+	// Transmits the records changed since the previous pass, and replaces
+	// the state if there were any, returning the sequence number of the
+	// state and the number of records transmitted.
+
+	var (
+		buffered  *bufio.Writer
+		command   *exec.Cmd
+		current   = make(map[replicaKey][sha256.Size]byte)
+		digest    [sha256.Size]byte
+		dump      *mdbDumpReader
+		encoder   *bl.Encoder
+		found     bool
+		hasher    hash.Hash32
+		heartbeat bool
+		id        replicaKey
+		key       []byte
+		next      uint64
+		output    io.ReadCloser
+		previous  map[replicaKey][sha256.Size]byte
+		sink      *replicaSink
+		stderr    bytes.Buffer
+		stale     []replicaKey
+		val       []byte
+		xmv       byte
+	)
+
+	sequence, previous, e = readReplicaState(r.config.State)
+	if e != nil {
+		return
+	}
+
+	hasher, _ = newHasher(r.config.Checksum)
+
+	sink, e = openReplicaSink(ctx, r.config.Destinations)
+	if e != nil {
+		return
+	}
+
+	buffered = bufio.NewWriter(sink)
+	encoder = bl.NewEncoder(buffered, hasher)
+
+	// A failed pass is aborted, so that network destinations can tell it
+	// from a complete one, and its stream files removed.
+
+	defer func() {
+		if e != nil {
+			encoder.Abort(e)
+			buffered.Flush()
+			sink.abort()
+		}
+	}()
+
+	command = exec.CommandContext(ctx, r.config.MDBDump, "-a")
+
+	if r.config.NoSubdir {
+		command.Args = append(command.Args, "-n")
+	}
+
+	command.Args = append(command.Args, r.config.Environment)
+	command.Stderr = &stderr
+
+	output, e = command.StdoutPipe()
+	if e != nil {
+		return
+	}
+
+	e = command.Start()
+	if e != nil {
+		return
+	}
+
+	dump = newMDBDumpReader(output)
+
+	for {
+		key, val, xmv, e = dump.Read()
+		if e != nil {
+			break
+		}
+
+		if !r.admits(dump.Database(), key) {
+			continue
+		}
+
+		id = replicaKey{dump.Database(), string(key)}
+
+		digest = sha256.Sum256(
+			append(val, xmv),
+		)
+
+		current[id] = digest
+
+		if previous[id] == digest {
+			continue
+		}
+
+		e = encoder.SetSource(id.db)
+		if e == nil {
+			e = encoder.SetDatabaseFlags(
+				dump.Flags(),
+			)
+		}
+
+		if e == nil {
+			e = encoder.EncodeX(key, val, bl.XMetaValue(xmv))
+		}
+
+		if e != nil {
+			break
+		}
+
+		changes++
+	}
+
+	if !errors.Is(e, io.EOF) {
+		output.Close()
+		command.Wait()
+
+		return
+	}
+
+	e = command.Wait()
+	if e != nil {
+		return sequence, 0, fmt.Errorf("%s: %w: %s", r.config.MDBDump, e,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	for id = range previous {
+		_, found = current[id]
+		if !found {
+			stale = append(stale, id)
+		}
+	}
+
+	slices.SortFunc(stale, compareReplicaKeys)
+
+	for _, id = range stale {
+		e = encoder.SetSource(id.db)
+		if e == nil {
+			e = encoder.EncodeX([]byte(id.key), nil,
+				bl.NewXMetaValue(0, bl.XMetaFlagTombstone),
+			)
+		}
+
+		if e != nil {
+			return
+		}
+
+		changes++
+	}
+
+	// A pass without changes, other than the first, is sent to network
+	// destinations, for which it serves as a heartbeat, but not kept in
+	// directories, and so does not advance the sequence number.
+
+	heartbeat = changes == 0 && previous != nil
+
+	next = sequence
+
+	if !heartbeat {
+		next++
+	}
+
+	e = encoder.Checkpoint(
+		binary.BigEndian.AppendUint64(nil, next),
+	)
+	if e == nil {
+		e = encoder.Close()
+	}
+
+	if e == nil {
+		e = buffered.Flush()
+	}
+
+	if e != nil {
+		return
+	}
+
+	if heartbeat {
+		return sequence, 0, sink.commit(sequence, false)
+	}
+
+	e = sink.commit(sequence+1, true)
+	if e != nil {
+		return
+	}
+
+	e = writeReplicaState(r.config.State, sequence+1, current)
+	if e != nil {
+		return
+	}
+
+	return sequence + 1, changes, nil
+}
+
+func (r *replicator) admits(db string, key []byte) bool {
+	var (
+		prefix string
+	)
+
+	if len(r.config.Databases) > 0 && !slices.Contains(r.config.Databases, db) {
+		return false
+	}
+
+	if len(r.config.Prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix = range r.config.Prefixes {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServeHTTP serves the health of the replicator at /healthz, healthy once a
+// pass has succeeded and as long as the last did, and its metrics at
+// /metrics, in the text format of Prometheus.
+func (r *replicator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+
+	defer r.mutex.Unlock()
+
+	switch req.URL.Path {
+	case "/healthz":
+		switch {
+		case r.failure != nil:
+			http.Error(w, r.failure.Error(), http.StatusServiceUnavailable)
+
+		case r.succeeded.IsZero():
+			http.Error(w, "no pass yet", http.StatusServiceUnavailable)
+
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		fmt.Fprintf(w, "# TYPE bl_replicate_passes_total counter\n"+
+			"bl_replicate_passes_total %d\n"+
+			"# TYPE bl_replicate_failures_total counter\n"+
+			"bl_replicate_failures_total %d\n"+
+			"# TYPE bl_replicate_records_total counter\n"+
+			"bl_replicate_records_total %d\n"+
+			"# TYPE bl_replicate_sequence gauge\n"+
+			"bl_replicate_sequence %d\n"+
+			"# TYPE bl_replicate_last_success_timestamp_seconds gauge\n"+
+			"bl_replicate_last_success_timestamp_seconds %d\n",
+			r.passes, r.failures, r.records, r.sequence,
+			r.lastSuccess(),
+		)
+
+	default:
+		http.NotFound(w, req)
+	}
+
+	return
+}
+
+func (r *replicator) lastSuccess() int64 {
+	if r.succeeded.IsZero() {
+		return 0
+	}
+
+	return r.succeeded.Unix()
+}
+
+func compareReplicaKeys(a, b replicaKey) int {
+	return cmp.Or(
+		strings.Compare(a.db, b.db),
+		strings.Compare(a.key, b.key),
+	)
+}
+
+func readReplicaState(path string) (sequence uint64,
+	digests map[replicaKey][sha256.Size]byte, e error,
+) {
+	// Reads the sequence number and digests of the previous pass, or returns
+	// a nil map if there was none.
+
+	var (
+		decoder *bl.Decoder
+		file    *os.File
+		id      []byte
+		key     []byte
+		val     []byte
+	)
+
+	file, e = os.Open(path)
+	if errors.Is(e, os.ErrNotExist) {
+		return 0, nil, nil
+	}
+
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	decoder = bl.NewDecoder(
+		bufio.NewReader(file), nil,
+	)
+
+	id, e = decoder.NextCheckpoint()
+	if e != nil {
+		return 0, nil, fmt.Errorf("%s: %w", path, e)
+	}
+
+	if len(id) != 8 {
+		return 0, nil, fmt.Errorf("%s: malformed sequence number", path)
+	}
+
+	sequence = binary.BigEndian.Uint64(id)
+	digests = make(map[replicaKey][sha256.Size]byte)
+
+	for {
+		key, val, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			return sequence, digests, nil
+		}
+
+		if e == nil && len(val) != sha256.Size {
+			e = fmt.Errorf("malformed digest of key %x", key)
+		}
+
+		if e != nil {
+			return 0, nil, fmt.Errorf("%s: %w", path, e)
+		}
+
+		digests[replicaKey{decoder.Source(), string(key)}] = [sha256.Size]byte(
+			val,
+		)
+	}
+}
+
+func writeReplicaState(path string, sequence uint64,
+	digests map[replicaKey][sha256.Size]byte,
+) (e error) {
+	// Replaces the state file atomically, by renaming a new file over it.
+
+	var (
+		buffered *bufio.Writer
+		digest   [sha256.Size]byte
+		encoder  *bl.Encoder
+		file     *os.File
+		id       replicaKey
+		ids      []replicaKey
+	)
+
+	file, e = os.CreateTemp(filepath.Dir(path), ".state-*")
+	if e != nil {
+		return
+	}
+
+	defer func() {
+		if e != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	buffered = bufio.NewWriter(file)
+	encoder = bl.NewEncoder(buffered, nil)
+
+	e = encoder.Checkpoint(
+		binary.BigEndian.AppendUint64(nil, sequence),
+	)
+	if e != nil {
+		return
+	}
+
+	for id = range digests {
+		ids = append(ids, id)
+	}
+
+	slices.SortFunc(ids, compareReplicaKeys)
+
+	for _, id = range ids {
+		digest = digests[id]
+
+		e = encoder.SetSource(id.db)
+		if e == nil {
+			e = encoder.Encode([]byte(id.key), digest[:])
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	e = encoder.Close()
+	if e == nil {
+		e = buffered.Flush()
+	}
+
+	if e == nil {
+		e = file.Sync()
+	}
+
+	if e == nil {
+		e = file.Close()
+	}
+
+	if e != nil {
+		return
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// A replicaSink writes a pass to every destination at once: over a
+// connection to each network destination, and to a temporary file in each
+// directory, renamed after the sequence number of the pass if kept.
+type replicaSink struct {
+	writer io.Writer
+	conns  []net.Conn
+	files  []*os.File
+}
+
+func openReplicaSink(ctx context.Context, destinations []string) (
+	s *replicaSink, e error,
+) {
+	var (
+		conn        net.Conn
+		destination string
+		dialer      net.Dialer
+		file        *os.File
+		writers     []io.Writer
+	)
+
+	s = new(replicaSink)
+
+	defer func() {
+		if e != nil {
+			s.abort()
+		}
+	}()
+
+	for _, destination = range destinations {
+		switch {
+		case strings.HasPrefix(destination, "tcp://"):
+			conn, e = dialer.DialContext(ctx, "tcp",
+				strings.TrimPrefix(destination, "tcp://"),
+			)
+
+		case strings.HasPrefix(destination, "unix://"):
+			conn, e = dialer.DialContext(ctx, "unix",
+				strings.TrimPrefix(destination, "unix://"),
+			)
+
+		default:
+			file, e = os.CreateTemp(destination, ".pass-*")
+			if e != nil {
+				return nil, e
+			}
+
+			s.files = append(s.files, file)
+			writers = append(writers, file)
+
+			continue
+		}
+
+		if e != nil {
+			return nil, fmt.Errorf("%s: %w", destination, e)
+		}
+
+		s.conns = append(s.conns, conn)
+		writers = append(writers, conn)
+	}
+
+	s.writer = io.MultiWriter(writers...)
+
+	return
+}
+
+func (s *replicaSink) Write(b []byte) (n int, e error) {
+	return s.writer.Write(b)
+}
+
+func (s *replicaSink) commit(sequence uint64, keep bool) (e error) {
+	// Closes the connections, and keeps the stream files, named after the
+	// sequence number, or removes them.
+
+	var (
+		conn net.Conn
+		file *os.File
+	)
+
+	for _, conn = range s.conns {
+		e = cmp.Or(e, conn.Close())
+	}
+
+	for _, file = range s.files {
+		if !keep {
+			file.Close()
+			os.Remove(file.Name())
+
+			continue
+		}
+
+		e = cmp.Or(e, file.Sync(), file.Close())
+		e = cmp.Or(e,
+			os.Rename(file.Name(),
+				filepath.Join(filepath.Dir(file.Name()),
+					fmt.Sprintf("%020d.bl", sequence),
+				),
+			),
+		)
+	}
+
+	return
+}
+
+func (s *replicaSink) abort() {
+	var (
+		conn net.Conn
+		file *os.File
+	)
+
+	for _, conn = range s.conns {
+		conn.Close()
+	}
+
+	for _, file = range s.files {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	return
+}
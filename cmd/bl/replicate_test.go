@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// testReplicaDump fakes mdb_dump by printing the file named dump in the
+// environment directory, which the tests rewrite between passes.
+const testReplicaDump = `#!/bin/sh
+for env; do :; done
+cat "$env/dump"
+`
+
+func TestReplicate(t *testing.T) {
+	var (
+		config   []byte
+		dir      = t.TempDir()
+		e        error
+		env      = filepath.Join(dir, "env")
+		matches  []string
+		mdbDump  = filepath.Join(dir, "mdb_dump")
+		path     = filepath.Join(dir, "replicate.json")
+		r        *replicator
+		recorder *httptest.ResponseRecorder
+		replicas = filepath.Join(dir, "replicas")
+	)
+
+	assert.NoError(t,
+		os.WriteFile(mdbDump, []byte(testReplicaDump), 0o755),
+	)
+	assert.NoError(t,
+		os.Mkdir(env, 0o755),
+	)
+	assert.NoError(t,
+		os.Mkdir(replicas, 0o755),
+	)
+
+	config, e = json.Marshal(
+		replicateConfig{
+			Environment:  env,
+			MDBDump:      mdbDump,
+			Databases:    []string{"users"},
+			Prefixes:     []string{"u/"},
+			Destinations: []string{replicas},
+			State:        filepath.Join(dir, "state"),
+		},
+	)
+	assert.NoError(t, e)
+	assert.NoError(t,
+		os.WriteFile(path, config, 0o644),
+	)
+
+	// The first pass sends a snapshot of the records admitted by the
+	// filters.
+
+	writeReplicaDump(t, env, "u/a", "1", "u/b", "2", "x/c", "3")
+
+	assert.NoError(t,
+		run("replicate", []string{"-config", path, "-once"}, nil, nil),
+	)
+	assert.Equal(t,
+		[]string{"u/a=1", "u/b=2"},
+		readReplica(t, filepath.Join(replicas, "00000000000000000001.bl")),
+	)
+
+	// The next pass sends only the changes, with tombstones of the records
+	// deleted.
+
+	writeReplicaDump(t, env, "u/b", "4", "u/d", "5", "x/c", "6")
+
+	assert.NoError(t,
+		run("replicate", []string{"-config", path, "-once"}, nil, nil),
+	)
+	assert.Equal(t,
+		[]string{"u/b=4", "u/d=5", "u/a deleted"},
+		readReplica(t, filepath.Join(replicas, "00000000000000000002.bl")),
+	)
+
+	// A pass without changes leaves no file.
+
+	assert.NoError(t,
+		run("replicate", []string{"-config", path, "-once"}, nil, nil),
+	)
+
+	matches, e = filepath.Glob(
+		filepath.Join(replicas, "*"),
+	)
+	assert.NoError(t, e)
+	assert.Len(t, matches, 2)
+
+	// Health and metrics follow the passes.
+
+	r, e = newReplicator(path)
+	assert.NoError(t, e)
+
+	r.log = io.Discard
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+	)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	writeReplicaDump(t, env, "u/b", "7")
+
+	assert.NoError(t,
+		r.pass(
+			context.Background(),
+		),
+	)
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+	)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	assert.Contains(t, recorder.Body.String(), "bl_replicate_sequence 3\n")
+	assert.Contains(t, recorder.Body.String(),
+		"bl_replicate_records_total 2\n",
+	)
+
+	assert.NoError(t,
+		os.Remove(
+			filepath.Join(env, "dump"),
+		),
+	)
+	assert.Error(t,
+		r.pass(
+			context.Background(),
+		),
+	)
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/healthz", nil),
+	)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	assert.Contains(t, recorder.Body.String(),
+		"bl_replicate_failures_total 1\n",
+	)
+
+	return
+}
+
+func TestReplicateHeartbeat(t *testing.T) {
+	// A pass without changes is sent to network destinations with the
+	// checkpoint of the last pass with changes, so that the next pass with
+	// changes is not mistaken for it.
+
+	var (
+		dir      = t.TempDir()
+		env      = filepath.Join(dir, "env")
+		e        error
+		listener net.Listener
+		mdbDump  = filepath.Join(dir, "mdb_dump")
+		received = make(chan uint64, 3)
+		r        *replicator
+		socket   = filepath.Join(dir, "socket")
+	)
+
+	assert.NoError(t,
+		os.WriteFile(mdbDump, []byte(testReplicaDump), 0o755),
+	)
+	assert.NoError(t,
+		os.Mkdir(env, 0o755),
+	)
+
+	listener, e = net.Listen("unix", socket)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	defer listener.Close()
+
+	go func() {
+		var (
+			conn net.Conn
+			e    error
+			id   []byte
+		)
+
+		for {
+			conn, e = listener.Accept()
+			if e != nil {
+				return
+			}
+
+			id, e = bl.NewDecoder(conn, nil).NextCheckpoint()
+
+			conn.Close()
+
+			if e != nil {
+				received <- 0
+
+				continue
+			}
+
+			received <- binary.BigEndian.Uint64(id)
+		}
+	}()
+
+	r = &replicator{
+		config: replicateConfig{
+			Environment:  env,
+			MDBDump:      mdbDump,
+			Destinations: []string{"unix://" + socket},
+			State:        filepath.Join(dir, "state"),
+		},
+		log: io.Discard,
+	}
+
+	for _, records := range [][]string{
+		{"u/a", "1"},
+		{"u/a", "1"},
+		{"u/a", "2"},
+	} {
+		writeReplicaDump(t, env, records...)
+
+		assert.NoError(t,
+			r.pass(
+				context.Background(),
+			),
+		)
+	}
+
+	assert.Equal(t, uint64(1), <-received)
+	assert.Equal(t, uint64(1), <-received) // heartbeat
+	assert.Equal(t, uint64(2), <-received)
+
+	return
+}
+
+func writeReplicaDump(t *testing.T, env string, records ...string) {
+	// Writes a dump of the users database holding the records, given as
+	// alternating keys and values, and of the main database.
+
+	var (
+		b bytes.Buffer
+		i int
+	)
+
+	b.WriteString("VERSION=3\nformat=print\ntype=btree\nHEADER=END\n" +
+		" main\n 0\nDATA=END\n" +
+		"VERSION=3\nformat=print\ndatabase=users\ntype=btree\nHEADER=END\n",
+	)
+
+	for i = 0; i < len(records); i += 2 {
+		b.WriteString(" " + records[i] + "\n " + records[i+1] + "\n")
+	}
+
+	b.WriteString("DATA=END\n")
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(env, "dump"), b.Bytes(), 0o644),
+	)
+
+	return
+}
+
+func readReplica(t *testing.T, path string) (records []string) {
+	// Reads the records of a stream file written by a pass, tombstones
+	// described as deleted.
+
+	var (
+		b       []byte
+		decoder *bl.Decoder
+		e       error
+		key     []byte
+		val     []byte
+		xmv     byte
+	)
+
+	b, e = os.ReadFile(path)
+	assert.NoError(t, e)
+
+	decoder = bl.NewDecoder(
+		bytes.NewReader(b), nil,
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+		assert.Equal(t, "users", decoder.Source())
+
+		if bl.XMetaValue(xmv).HasFlag(bl.XMetaFlagTombstone) {
+			records = append(records, string(key)+" deleted")
+
+			continue
+		}
+
+		records = append(records, string(key)+"="+string(val))
+	}
+
+	return
+}
@@ -0,0 +1,742 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A dumpServer serves the records of an LMDB environment, as dumped by the
+// mdb_dump utility of LMDB, which opens the environment read-only, as streams
+// at /dump. The query parameter db selects a single database, the main
+// database if empty, and after, the hexadecimal encoding of a key, restricts
+// the stream to records of that database whose keys sort after it, so that an
+// interrupted transfer can be resumed after the last key received; updates and
+// deletions of keys at or before after are not sent. Given a directory for
+// indexes, the server keeps there the index of every dump of a single database
+// without after, named in the Bl-Index header of the response, and the query
+// parameter since, the name of such an index, makes the dump incremental, as
+// by bl.DiffDump: only the records put or changed since that dump are sent,
+// with tombstones of those deleted. Records are tagged with the name of their
+// database. The names of the databases are listed at /databases, one per line.
+// Unless kept serving, the server is done after a complete dump of every
+// database, or once a client that fetches the databases one at a time ends its
+// session by a POST to /done.
+type dumpServer struct {
+	environment string
+	mdbDump     string
+	noSubdir    bool
+	checksum    string
+	indexDir    string
+	user        string
+	password    string
+	done        chan struct{}
+	served      sync.Once
+	log         io.Writer
+}
+
+func serve(args []string, stdout io.Writer) (e error) {
+	var (
+		flags   = flag.NewFlagSet("serve", flag.ContinueOnError)
+		address = flags.String("addr", ":8080", "address on which to listen")
+		mdbDump = flags.String("mdb-dump", "mdb_dump",
+			"path of the mdb_dump utility of LMDB",
+		)
+		noSubdir = flags.Bool("no-subdir", false,
+			"the environment is a file rather than a directory, as for "+
+				"mdb_dump -n",
+		)
+		checksum = flags.String("checksum", "",
+			"checksum of records: crc32c, fnv32a, crc32, or none if empty",
+		)
+		authFile = flags.String("auth-file", "",
+			"if not empty, a file holding user:password, required of "+
+				"clients by HTTP basic authentication",
+		)
+		certFile = flags.String("tls-cert", "",
+			"if not empty, serve HTTPS with this certificate file",
+		)
+		keyFile = flags.String("tls-key", "",
+			"private key file of the certificate given by -tls-cert",
+		)
+		indexDir = flags.String("index-dir", "",
+			"if not empty, a directory in which to keep the index of each "+
+				"dump of a single database, against which a later dump "+
+				"with since= is incremental",
+		)
+		keepServing = flags.Bool("keep-serving", false,
+			"serve until interrupted rather than until the first complete "+
+				"dump of every database, or the end of the first fetch",
+		)
+
+		auth     []byte
+		listener net.Listener
+		ok       bool
+		server   *http.Server
+		handler  *dumpServer
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bl serve [flags] environment")
+
+		flags.PrintDefaults()
+	}
+
+	e = flags.Parse(args)
+	if e != nil {
+		return
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+
+		return fmt.Errorf("expected one environment, got %d", flags.NArg())
+	}
+
+	if (*certFile == "") != (*keyFile == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+
+	_, e = newHasher(*checksum)
+	if e != nil {
+		return
+	}
+
+	handler = &dumpServer{
+		environment: flags.Arg(0),
+		mdbDump:     *mdbDump,
+		noSubdir:    *noSubdir,
+		checksum:    *checksum,
+		indexDir:    *indexDir,
+		done:        make(chan struct{}),
+		log:         stdout,
+	}
+
+	if *authFile != "" {
+		auth, e = os.ReadFile(*authFile)
+		if e != nil {
+			return
+		}
+
+		handler.user, handler.password, ok = strings.Cut(
+			strings.TrimSpace(string(auth)), ":",
+		)
+		if !ok || handler.user == "" {
+			return fmt.Errorf("auth file not of the form user:password")
+		}
+	}
+
+	listener, e = net.Listen("tcp", *address)
+	if e != nil {
+		return
+	}
+
+	server = &http.Server{
+		Handler: handler,
+	}
+
+	if !*keepServing {
+		go func() {
+			<-handler.done
+
+			server.Shutdown(
+				context.Background(),
+			)
+		}()
+	}
+
+	fmt.Fprintf(stdout, "serving %s on %s\n", handler.environment,
+		listener.Addr(),
+	)
+
+	if *certFile != "" {
+		e = server.ServeTLS(listener, *certFile, *keyFile)
+	} else {
+		e = server.Serve(listener)
+	}
+
+	if errors.Is(e, http.ErrServerClosed) {
+		return nil
+	}
+
+	return
+}
+
+func (s *dumpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		after    []byte
+		db       string
+		e        error
+		hasher   hash.Hash32
+		index    string
+		password string
+		records  int64
+		since    string
+		single   bool
+		user     string
+	)
+
+	if s.user != "" {
+		user, password, _ = r.BasicAuth()
+
+		if subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password),
+				[]byte(s.password),
+			) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bl"`)
+
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+	}
+
+	if r.URL.Path == "/done" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		fmt.Fprintf(s.log, "session of %s ended\n", r.RemoteAddr)
+
+		w.WriteHeader(http.StatusNoContent)
+
+		s.served.Do(func() { close(s.done) })
+
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	switch r.URL.Path {
+	case "/databases":
+		s.list(w, r)
+
+		return
+
+	case "/dump":
+
+	default:
+		http.NotFound(w, r)
+
+		return
+	}
+
+	db, single = r.URL.Query().Get("db"), r.URL.Query().Has("db")
+
+	if r.URL.Query().Has("after") {
+		if !single {
+			http.Error(w, "after requires db", http.StatusBadRequest)
+
+			return
+		}
+
+		after, e = hex.DecodeString(r.URL.Query().Get("after"))
+		if e != nil {
+			http.Error(w, "after not hexadecimal", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	if r.URL.Query().Has("since") {
+		switch since = r.URL.Query().Get("since"); {
+		case !single || s.indexDir == "":
+			http.Error(w, "since requires db and an index directory",
+				http.StatusBadRequest,
+			)
+
+			return
+
+		case after != nil:
+			http.Error(w, "since excludes after", http.StatusBadRequest)
+
+			return
+
+		case !indexOf(since, db):
+			http.Error(w, "since not an index of db", http.StatusBadRequest)
+
+			return
+		}
+
+		_, e = os.Stat(
+			filepath.Join(s.indexDir, since),
+		)
+		if e != nil {
+			http.Error(w, "unknown index", http.StatusNotFound)
+
+			return
+		}
+	}
+
+	hasher, e = newHasher(s.checksum)
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	if single && after == nil && s.indexDir != "" {
+		index, e = newIndexName(db)
+		if e != nil {
+			http.Error(w, e.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Bl-Index", index)
+
+		records, e = s.diffDump(r.Context(), w, hasher, db, since, index)
+	} else {
+		records, e = s.dump(r.Context(), w, hasher, db, single, after)
+	}
+
+	if e != nil {
+		fmt.Fprintf(s.log, "dump to %s failed: %v\n", r.RemoteAddr, e)
+
+		return
+	}
+
+	fmt.Fprintf(s.log, "dumped %d records to %s\n", records, r.RemoteAddr)
+
+	// A dump of a single database is one of a session, ended at /done.
+
+	if !single {
+		s.served.Do(func() { close(s.done) })
+	}
+
+	return
+}
+
+func (s *dumpServer) dump(ctx context.Context, w io.Writer, hasher hash.Hash32,
+	db string, single bool, after []byte,
+) (
+	records int64, e error,
+) {
+	// Transmits the records of the given database, or of all databases unless
+	// single, whose keys sort after after, if not nil. A failure once the
+	// response has begun aborts the stream, so that the client can tell it
+	// from a complete one.
+
+	var (
+		args    []string
+		command *exec.Cmd
+		encoder = bl.NewEncoder(w, hasher)
+		key     []byte
+		output  io.ReadCloser
+		reader  *mdbDumpReader
+		stderr  bytes.Buffer
+		val     []byte
+		xmv     byte
+	)
+
+	defer func() {
+		if e != nil {
+			encoder.Abort(e)
+		}
+	}()
+
+	if s.noSubdir {
+		args = append(args, "-n")
+	}
+
+	switch {
+	case !single:
+		args = append(args, "-a")
+
+	case db != "":
+		args = append(args, "-s", db)
+	}
+
+	command = exec.CommandContext(ctx, s.mdbDump,
+		append(args, s.environment)...,
+	)
+
+	command.Stderr = &stderr
+
+	output, e = command.StdoutPipe()
+	if e != nil {
+		return
+	}
+
+	e = command.Start()
+	if e != nil {
+		return
+	}
+
+	reader = newMDBDumpReader(output)
+
+	for {
+		key, val, xmv, e = reader.Read()
+		if e != nil {
+			break
+		}
+
+		if after != nil && reader.Flags().Compare(key, after) <= 0 {
+			continue
+		}
+
+		e = encoder.SetSource(
+			reader.Database(),
+		)
+		if e == nil {
+			e = encoder.SetDatabaseFlags(
+				reader.Flags(),
+			)
+		}
+
+		if e == nil {
+			e = encoder.EncodeX(key, val, bl.XMetaValue(xmv))
+		}
+
+		if e != nil {
+			output.Close()
+			command.Wait()
+
+			return
+		}
+
+		records++
+	}
+
+	if !errors.Is(e, io.EOF) {
+		output.Close()
+		command.Wait()
+
+		return
+	}
+
+	e = command.Wait()
+	if e != nil {
+		return records, fmt.Errorf("%s: %w: %s", s.mdbDump, e,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	e = encoder.Close()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (s *dumpServer) diffDump(ctx context.Context, w io.Writer,
+	hasher hash.Hash32, db, since, index string,
+) (
+	records int64, e error,
+) {
+	// Transmits the records of the given database put or changed since the
+	// dump whose index is named since, every record if since is empty, and
+	// tombstones of those deleted, and keeps the index of this dump, named
+	// index, for the next.
+
+	var (
+		dump     bl.DiffDump
+		encoder  = bl.NewEncoder(w, hasher)
+		file     *os.File
+		path     = filepath.Join(s.indexDir, index)
+		previous *os.File
+	)
+
+	defer func() {
+		if e != nil {
+			encoder.Abort(e)
+		}
+	}()
+
+	if since != "" {
+		previous, e = os.Open(
+			filepath.Join(s.indexDir, since),
+		)
+		if e != nil {
+			return
+		}
+
+		defer previous.Close()
+
+		dump.Previous = bl.NewDecoder(previous, nil)
+	}
+
+	file, e = os.Create(path + ".tmp")
+	if e != nil {
+		return
+	}
+
+	defer func() {
+		file.Close()
+
+		if e != nil {
+			os.Remove(path + ".tmp")
+		}
+	}()
+
+	dump.Index = bl.NewEncoder(file, nil)
+
+	dump.Open = func() (_ bl.StoreSnapshot, e error) {
+		var (
+			snapshot *mdbDumpSnapshot
+		)
+
+		snapshot, e = s.snapshot(ctx, db)
+		if e != nil {
+			return
+		}
+
+		e = encoder.SetSource(db)
+		if e == nil {
+			e = encoder.SetDatabaseFlags(snapshot.flags)
+		}
+
+		if e != nil {
+			snapshot.Close()
+
+			return
+		}
+
+		return snapshot, nil
+	}
+
+	records, e = dump.Run(encoder)
+	if e != nil {
+		return
+	}
+
+	e = dump.Index.Close()
+	if e != nil {
+		return
+	}
+
+	e = file.Close()
+	if e != nil {
+		return
+	}
+
+	e = os.Rename(path+".tmp", path)
+	if e != nil {
+		return
+	}
+
+	e = encoder.Close()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (s *dumpServer) snapshot(ctx context.Context, db string) (
+	snapshot *mdbDumpSnapshot, e error,
+) {
+	// Starts mdb_dump on the given database and reads its first record, so
+	// that the flags of the database are known before any key is compared.
+
+	var (
+		args []string
+	)
+
+	if s.noSubdir {
+		args = append(args, "-n")
+	}
+
+	if db != "" {
+		args = append(args, "-s", db)
+	}
+
+	snapshot = &mdbDumpSnapshot{
+		mdbDump: s.mdbDump,
+		command: exec.CommandContext(ctx, s.mdbDump,
+			append(args, s.environment)...,
+		),
+	}
+
+	snapshot.command.Stderr = &snapshot.stderr
+
+	snapshot.output, e = snapshot.command.StdoutPipe()
+	if e != nil {
+		return
+	}
+
+	e = snapshot.command.Start()
+	if e != nil {
+		return
+	}
+
+	snapshot.reader = newMDBDumpReader(snapshot.output)
+
+	snapshot.key, snapshot.val, _, snapshot.first = snapshot.reader.Read()
+
+	snapshot.flags = snapshot.reader.Flags()
+
+	return
+}
+
+// An mdbDumpSnapshot is a bl.StoreSnapshot of a database as dumped by
+// mdb_dump, which holds a read-only transaction for as long as it runs. It
+// cannot seek but to the first record.
+type mdbDumpSnapshot struct {
+	mdbDump string
+	command *exec.Cmd
+	output  io.ReadCloser
+	stderr  bytes.Buffer
+	reader  *mdbDumpReader
+	flags   bl.DatabaseFlags
+	key     []byte
+	val     []byte
+	first   error
+	waited  bool
+}
+
+func (s *mdbDumpSnapshot) Seek(key []byte) (k, v []byte, e error) {
+	if key != nil {
+		return nil, nil, fmt.Errorf("mdb_dump cannot seek to a key")
+	}
+
+	if s.first != nil {
+		return nil, nil, s.end(s.first)
+	}
+
+	return s.key, s.val, nil
+}
+
+func (s *mdbDumpSnapshot) Next() (k, v []byte, e error) {
+	k, v, _, e = s.reader.Read()
+	if e != nil {
+		return nil, nil, s.end(e)
+	}
+
+	return
+}
+
+// Flags returns the flags of the database, as read from the header of its
+// dump.
+func (s *mdbDumpSnapshot) Flags() bl.DatabaseFlags {
+	return s.flags
+}
+
+func (s *mdbDumpSnapshot) Close() error {
+	if !s.waited {
+		s.output.Close()
+		s.command.Wait()
+	}
+
+	return nil
+}
+
+func (s *mdbDumpSnapshot) end(e error) error {
+	// Returns io.EOF at the end of a complete dump, or else the failure of
+	// mdb_dump.
+
+	if !errors.Is(e, io.EOF) {
+		return e
+	}
+
+	s.waited = true
+
+	e = s.command.Wait()
+	if e != nil {
+		return fmt.Errorf("%s: %w: %s", s.mdbDump, e,
+			strings.TrimSpace(s.stderr.String()),
+		)
+	}
+
+	return io.EOF
+}
+
+func newIndexName(db string) (name string, e error) {
+	// Returns a new name for an index of the given database: its name in
+	// hexadecimal, so that the index cannot be mistaken for that of another,
+	// and a random suffix.
+
+	var (
+		suffix = make([]byte, 8)
+	)
+
+	_, e = rand.Read(suffix)
+	if e != nil {
+		return
+	}
+
+	return hex.EncodeToString([]byte(db)) + "-" + hex.EncodeToString(suffix),
+		nil
+}
+
+func indexOf(name, db string) bool {
+	// Reports whether name is a name returned by newIndexName for the given
+	// database, and so also a safe file name.
+
+	var (
+		prefix string
+		suffix string
+	)
+
+	prefix, suffix, _ = strings.Cut(name, "-")
+
+	return prefix == hex.EncodeToString([]byte(db)) &&
+		len(suffix) == 16 && strings.Trim(suffix, "0123456789abcdef") == ""
+}
+
+func (s *dumpServer) list(w http.ResponseWriter, r *http.Request) {
+	// Lists the names of the named databases of the environment, one per
+	// line, as does mdb_dump -l.
+
+	var (
+		args    = []string{"-l"}
+		command *exec.Cmd
+		e       error
+		output  []byte
+		stderr  bytes.Buffer
+	)
+
+	if s.noSubdir {
+		args = append(args, "-n")
+	}
+
+	command = exec.CommandContext(r.Context(), s.mdbDump,
+		append(args, s.environment)...,
+	)
+
+	command.Stderr = &stderr
+
+	output, e = command.Output()
+	if e != nil {
+		fmt.Fprintf(s.log, "listing for %s failed: %v: %s\n", r.RemoteAddr, e,
+			strings.TrimSpace(stderr.String()),
+		)
+
+		http.Error(w, "could not list databases",
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	w.Write(output)
+
+	return
+}
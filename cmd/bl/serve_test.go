@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+const testMDBDump = `#!/bin/sh
+for env; do :; done
+if [ "$env" = "missing" ]; then
+	echo "mdb_env_open failed" >&2
+	exit 1
+fi
+if [ "$1" = "-l" ]; then
+	printf 'users\ngroups\n'
+	exit 0
+fi
+for db in users groups; do
+	if [ "$1" = "-s" ] && [ "$2" != "$db" ]; then
+		continue
+	fi
+	printf 'VERSION=3\nformat=bytevalue\ndatabase=%s\ntype=btree\n' "$db"
+	printf 'HEADER=END\n 61\n 31\n 62\n 32\n 63\n 33\nDATA=END\n'
+done
+`
+
+const testMDBDumpFile = `#!/bin/sh
+for env; do :; done
+printf 'VERSION=3\nformat=bytevalue\ndatabase=%s\ntype=btree\n' "$2"
+printf 'HEADER=END\n'
+cat "$env/$2"
+printf 'DATA=END\n'
+`
+
+func TestServe(t *testing.T) {
+	var (
+		address  string
+		auth     = filepath.Join(t.TempDir(), "auth")
+		dir      = t.TempDir()
+		done     = make(chan error, 1)
+		mdbDump  = filepath.Join(dir, "mdb_dump")
+		output   *io.PipeReader
+		records  []string
+		response *http.Response
+		e        error
+		request  *http.Request
+		writer   *io.PipeWriter
+	)
+
+	assert.NoError(t, os.WriteFile(mdbDump, []byte(testMDBDump), 0o755))
+	assert.NoError(t, os.WriteFile(auth, []byte("alice:secret\n"), 0o600))
+
+	output, writer = io.Pipe()
+
+	go func() {
+		done <- run("serve",
+			[]string{"-addr", "127.0.0.1:0", "-mdb-dump", mdbDump,
+				"-checksum", "fnv32a", "-auth-file", auth, dir,
+			},
+			nil, writer,
+		)
+
+		writer.Close()
+	}()
+
+	address, e = bufio.NewReader(output).ReadString('\n')
+
+	assert.NoError(t, e)
+
+	go io.Copy(io.Discard, output)
+
+	address = "http://" + strings.Fields(address)[3]
+
+	response, e = http.Get(address + "/dump")
+
+	if assert.NoError(t, e) {
+		response.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	}
+
+	request, _ = http.NewRequest(http.MethodGet, address+"/dump?after=61", nil)
+
+	request.SetBasicAuth("alice", "secret")
+
+	response, e = http.DefaultClient.Do(request)
+
+	if assert.NoError(t, e) {
+		response.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	}
+
+	request, _ = http.NewRequest(http.MethodGet,
+		address+"/dump?db=groups&after=61", nil,
+	)
+
+	request.SetBasicAuth("alice", "secret")
+
+	response, e = http.DefaultClient.Do(request)
+
+	if assert.NoError(t, e) {
+		records = decodeAll(t, response.Body)
+
+		response.Body.Close()
+
+		assert.Equal(t, []string{"groups/b=2", "groups/c=3"}, records)
+	}
+
+	request, _ = http.NewRequest(http.MethodPost, address+"/done", nil)
+
+	request.SetBasicAuth("alice", "secret")
+
+	response, e = http.DefaultClient.Do(request)
+
+	if assert.NoError(t, e) {
+		response.Body.Close()
+
+		assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	}
+
+	assert.NoError(t, <-done) // one-shot: the end of the session ends serve
+
+	return
+}
+
+func TestServeDump(t *testing.T) {
+	var (
+		aborted bl.AbortedError
+		buffer  bytes.Buffer
+		dir     = t.TempDir()
+		e       error
+		server  *dumpServer
+	)
+
+	server = &dumpServer{
+		environment: dir,
+		mdbDump:     filepath.Join(dir, "mdb_dump"),
+		log:         io.Discard,
+	}
+
+	assert.NoError(t,
+		os.WriteFile(server.mdbDump, []byte(testMDBDump), 0o755),
+	)
+
+	_, e = server.dump(context.Background(), &buffer, nil, "", false, nil)
+
+	assert.NoError(t, e)
+
+	assert.Equal(t,
+		[]string{
+			"users/a=1", "users/b=2", "users/c=3",
+			"groups/a=1", "groups/b=2", "groups/c=3",
+		},
+		decodeAll(t, &buffer),
+	)
+
+	server.environment = "missing"
+
+	_, e = server.dump(context.Background(), &buffer, nil, "", true, nil)
+
+	assert.ErrorContains(t, e, "mdb_env_open failed")
+
+	_, _, e = bl.NewDecoder(&buffer, nil).Decode()
+
+	assert.True(t, errors.As(e, &aborted))
+
+	return
+}
+
+func TestServeSince(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		index    string
+		query    string
+		recorder *httptest.ResponseRecorder
+		server   *dumpServer
+		status   int
+		unknown  = "7573657273-" + strings.Repeat("0", 16)
+	)
+
+	server = &dumpServer{
+		environment: dir,
+		mdbDump:     filepath.Join(dir, "mdb_dump"),
+		indexDir:    t.TempDir(),
+		log:         io.Discard,
+	}
+
+	assert.NoError(t,
+		os.WriteFile(server.mdbDump, []byte(testMDBDumpFile), 0o755),
+	)
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "users"),
+			[]byte(" 61\n 31\n 62\n 32\n 63\n 33\n"), 0o644,
+		),
+	)
+
+	recorder = httptest.NewRecorder()
+
+	server.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/dump?db=users", nil),
+	)
+
+	assert.Equal(t,
+		[]string{"users/a=1", "users/b=2", "users/c=3"},
+		decodeAll(t, recorder.Body),
+	)
+
+	index = recorder.Header().Get("Bl-Index")
+
+	assert.FileExists(t,
+		filepath.Join(server.indexDir, index),
+	)
+
+	// b is updated, c deleted and d put.
+
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "users"),
+			[]byte(" 61\n 31\n 62\n 39\n 64\n 34\n"), 0o644,
+		),
+	)
+
+	recorder = httptest.NewRecorder()
+
+	server.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/dump?db=users&since="+index, nil),
+	)
+
+	assert.Equal(t,
+		[]string{"users/b=9", "users/c deleted", "users/d=4"},
+		decodeAll(t, recorder.Body),
+	)
+
+	index = recorder.Header().Get("Bl-Index")
+
+	recorder = httptest.NewRecorder()
+
+	server.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/dump?db=users&since="+index, nil),
+	)
+
+	assert.Empty(t,
+		decodeAll(t, recorder.Body),
+	)
+
+	for query, status = range map[string]int{
+		"since=" + index:                   http.StatusBadRequest,
+		"db=groups&since=" + index:         http.StatusBadRequest,
+		"db=users&after=61&since=" + index: http.StatusBadRequest,
+		"db=users&since=../users":          http.StatusBadRequest,
+		"db=users&since=7573657273-0123":   http.StatusBadRequest,
+		"db=users&since=" + unknown:        http.StatusNotFound,
+	} {
+		recorder = httptest.NewRecorder()
+
+		server.ServeHTTP(recorder,
+			httptest.NewRequest(http.MethodGet, "/dump?"+query, nil),
+		)
+
+		assert.Equal(t, status, recorder.Code, query)
+	}
+
+	return
+}
+
+func decodeAll(t *testing.T, reader io.Reader) (records []string) {
+	// Returns the records decoded as source/key=val, or source/key deleted
+	// for tombstones.
+
+	var (
+		decoder = bl.NewDecoder(reader, nil)
+		e       error
+		key     []byte
+		val     []byte
+		xmv     byte
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if e != nil {
+			break
+		}
+
+		if bl.XMetaValue(xmv).HasFlag(bl.XMetaFlagTombstone) {
+			records = append(records,
+				decoder.Source()+"/"+string(key)+" deleted",
+			)
+
+			continue
+		}
+
+		records = append(records,
+			decoder.Source()+"/"+string(key)+"="+string(val),
+		)
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdio is the file name that stands for standard input or output.
+const stdio = "-"
+
+func openInput(name string, stdin io.Reader) (r io.ReadCloser, e error) {
+	// Opens the named file, or returns standard input if the name is stdio,
+	// checking that it is not a terminal, on which no stream is expected.
+
+	if name != stdio {
+		return os.Open(name)
+	}
+
+	e = checkInput(stdin)
+	if e != nil {
+		return
+	}
+
+	return io.NopCloser(stdin), nil
+}
+
+func checkInput(stdin io.Reader) error {
+	if isTerminal(stdin) {
+		return fmt.Errorf("standard input is a terminal: " +
+			"redirect or pipe the input to it, or name a file",
+		)
+	}
+
+	return nil
+}
+
+func checkBinaryOutput(stdout io.Writer, force bool) error {
+	// Refuses to write binary output to a terminal, where it would garble
+	// the display, unless forced.
+
+	if !force && isTerminal(stdout) {
+		return fmt.Errorf("refusing to write binary output to a terminal: " +
+			"redirect or pipe it, or give -force",
+		)
+	}
+
+	return nil
+}
+
+func isTerminal(file any) bool {
+	// Reports whether the reader or writer is a character device other than
+	// the null device, as terminals are.
+
+	var (
+		e      error
+		info   os.FileInfo
+		null   os.FileInfo
+		ok     bool
+		stater interface {
+			Stat() (os.FileInfo, error)
+		}
+	)
+
+	stater, ok = file.(interface {
+		Stat() (os.FileInfo, error)
+	})
+	if !ok {
+		return false
+	}
+
+	info, e = stater.Stat()
+	if e != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, e = os.Stat(os.DevNull)
+
+	return e != nil || !os.SameFile(info, null)
+}
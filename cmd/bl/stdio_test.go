@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A fakeTerminal is a writer, or reader, that reports itself a terminal.
+type fakeTerminal struct {
+	bytes.Buffer
+}
+
+func (t *fakeTerminal) Stat() (os.FileInfo, error) {
+	return terminalInfo{}, nil
+}
+
+type terminalInfo struct {
+	os.FileInfo
+}
+
+func (terminalInfo) Mode() os.FileMode {
+	return os.ModeDevice | os.ModeCharDevice | 0o620
+}
+
+func TestIsTerminal(t *testing.T) {
+	var (
+		e    error
+		null *os.File
+	)
+
+	null, e = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	assert.NoError(t, e)
+
+	defer null.Close()
+
+	assert.True(t,
+		isTerminal(new(fakeTerminal)),
+	)
+	assert.False(t,
+		isTerminal(null),
+	)
+	assert.False(t,
+		isTerminal(new(bytes.Buffer)),
+	)
+
+	return
+}
+
+func TestStdio(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		encoder  *bl.Encoder
+		input    bytes.Buffer
+		output   bytes.Buffer
+		path     = filepath.Join(dir, "a.bl")
+		terminal fakeTerminal
+	)
+
+	encoder = bl.NewEncoder(&input, nil)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("k"), []byte("\xff\xfe")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.NoError(t,
+		os.WriteFile(path, input.Bytes(), 0o644),
+	)
+
+	// Binary output is refused on a terminal unless forced, and text output
+	// is not.
+
+	assert.ErrorContains(t,
+		run("convert", nil, bytes.NewReader(input.Bytes()), &terminal),
+		"refusing to write binary output to a terminal",
+	)
+	assert.NoError(t,
+		run("convert", []string{"-force"},
+			bytes.NewReader(input.Bytes()), &terminal,
+		),
+	)
+	assert.NoError(t,
+		run("convert", []string{"-to", "jsonl", path}, nil, &terminal),
+	)
+	assert.ErrorContains(t,
+		run("get", []string{"-key-format", "raw", path, "k"}, nil,
+			&terminal,
+		),
+		"refusing",
+	)
+	assert.ErrorContains(t,
+		run("bundle", []string{path}, nil, &terminal),
+		"refusing",
+	)
+
+	// No input is read from a terminal.
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-to", "jsonl"}, &terminal, &output),
+		"standard input is a terminal",
+	)
+
+	// Streams on standard input stand in for files named -, and output is
+	// written to the file given by -o.
+
+	assert.NoError(t,
+		run("get", []string{"-key-format", "raw", "-format", "hex", "-",
+			"k",
+		}, bytes.NewReader(input.Bytes()), &output),
+	)
+	assert.Equal(t, "fffe\n", output.String())
+
+	assert.NoError(t,
+		run("diff", []string{path, "-"}, bytes.NewReader(input.Bytes()),
+			&output,
+		),
+	)
+	assert.ErrorContains(t,
+		run("diff", []string{"-", "-"}, bytes.NewReader(input.Bytes()),
+			&output,
+		),
+		"at most one",
+	)
+
+	assert.NoError(t,
+		run("convert", []string{"-o", filepath.Join(dir, "b.bl"), "-"},
+			bytes.NewReader(input.Bytes()), &terminal,
+		),
+	)
+	assert.FileExists(t, filepath.Join(dir, "b.bl"))
+
+	output.Reset()
+
+	assert.NoError(t,
+		run("bundle", []string{"-"}, bytes.NewReader(input.Bytes()),
+			&output,
+		),
+	)
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, "bundle.tar"), output.Bytes(), 0o644),
+	)
+	assert.NoError(t,
+		run("unbundle", []string{"-C", dir, filepath.Join(dir, "bundle.tar")},
+			nil, nil,
+		),
+	)
+	assert.FileExists(t, filepath.Join(dir, "stdin"))
+
+	return
+}
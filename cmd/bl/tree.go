@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A treeReader reads the files of a directory tree as records without
+// metadata.
+type treeReader struct {
+	reader *bl.TreeReader
+}
+
+func newTreeReader(dir string) (r treeReader, e error) {
+	r.reader, e = bl.NewTreeReader(
+		os.DirFS(dir),
+	)
+
+	return
+}
+
+func (r treeReader) Read() (key, val []byte, xmv byte, e error) {
+	key, val, e = r.reader.Read()
+
+	return
+}
+
+// A treeWriter materializes records as the files of a directory tree.
+type treeWriter struct {
+	writer *bl.TreeWriter
+}
+
+func (w treeWriter) Write(key, val []byte, xmv byte) error {
+	return w.writer.Write(key, val, bl.XMetaValue(xmv))
+}
+
+func (w treeWriter) Close() error {
+	return nil
+}
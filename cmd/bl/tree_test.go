@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+func TestConvertTree(t *testing.T) {
+	var (
+		dir     = filepath.Join(t.TempDir(), "tree")
+		e       error
+		encoder *bl.Encoder
+		input   bytes.Buffer
+		output  bytes.Buffer
+		val     []byte
+	)
+
+	encoder = bl.NewEncoder(&input, nil)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("users/1"), []byte("ann")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("users/2 "), []byte("bob")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.NoError(t,
+		run("convert", []string{"-to", "tree", "-dir", dir},
+			&input, &output,
+		),
+	)
+
+	val, e = os.ReadFile(filepath.Join(dir, "users", "2%20"))
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("bob"), val)
+
+	output.Reset()
+
+	assert.NoError(t,
+		run("convert", []string{"-from", "tree", "-dir", dir, "-to", "csv"},
+			nil, &output,
+		),
+	)
+	assert.Equal(t,
+		"key,val,xmv\nusers/1,ann,0\nusers/2 ,bob,0\n",
+		output.String(),
+	)
+
+	assert.ErrorContains(t,
+		run("convert", []string{"-from", "tree"}, nil, &output),
+		"requires -dir",
+	)
+
+	return
+}
@@ -0,0 +1,141 @@
+package bottledlightning
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An InterleavePolicy determines the order in which Collect merges the records
+// of its sources.
+type InterleavePolicy int
+
+const (
+	// InterleaveArrival emits records in the order in which they are
+	// received, whatever their source.
+	InterleaveArrival InterleavePolicy = iota
+
+	// InterleaveRoundRobin emits one record from each source in turn, in the
+	// order in which the sources are given, skipping those that have ended. A
+	// source that is slow to produce holds up the others.
+	InterleaveRoundRobin
+)
+
+// A Source is a stream of records to be merged by Collect, and the identifier
+// with which to tag them.
+type Source struct {
+	ID      string
+	Decoder *Decoder
+}
+
+// Collect receives records from every Source concurrently and encodes them as
+// one stream on the Encoder, interleaved according to the InterleavePolicy.
+// Before each run of records from a Source, it transmits a control record
+// tagging them with the ID of the Source; see Decoder.Source. Collect returns
+// once every Source has ended, or upon the first error, which names the
+// Source. The Encoder must not otherwise be used until Collect returns.
+//
+// Following an error, a goroutine blocked in receiving from a Source returns
+// only once its Decoder does.
+func Collect(encoder *Encoder, policy InterleavePolicy, sources ...Source) (
+	e error,
+) {
+	defer errorf("could not collect streams", &e)
+
+	var (
+		channels  = make([]chan collected, len(sources))
+		done      = make(chan struct{})
+		ended     = make([]bool, len(sources))
+		i         int
+		item      collected
+		merged    = make(chan collected)
+		next      int
+		remaining = len(sources)
+	)
+
+	defer close(done)
+
+	for i = range sources {
+		channels[i] = merged
+
+		if policy == InterleaveRoundRobin {
+			channels[i] = make(chan collected)
+		}
+
+		go receive(&encoder.options, sources[i], i, channels[i], done)
+	}
+
+	for remaining > 0 {
+		if policy == InterleaveRoundRobin {
+			for ended[next] {
+				next = (next + 1) % len(sources)
+			}
+
+			item = <-channels[next]
+
+			next = (next + 1) % len(sources)
+		} else {
+			item = <-merged
+		}
+
+		switch {
+		case errors.Is(item.e, io.EOF):
+			ended[item.source] = true
+
+			remaining--
+
+			continue
+
+		case item.e != nil:
+			return fmt.Errorf("source %q: %w", sources[item.source].ID, item.e)
+		}
+
+		e = encoder.tagSource(sources[item.source].ID)
+		if e != nil {
+			return
+		}
+
+		e = encoder.EncodeX(item.key, item.val, XMetaValue(item.xmv))
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+type collected struct {
+	source int
+	key    []byte
+	val    []byte
+	xmv    byte
+	e      error
+}
+
+func receive(o *options, source Source, index int,
+	channel chan<- collected, done <-chan struct{},
+) {
+	// Sends records received from the source, the index-th, on channel until
+	// an error, including io.EOF, which is sent last, or until done is
+	// closed.
+
+	var (
+		item = collected{source: index}
+	)
+
+	o.labelGoroutine("collect", "bottledlightning.source", source.ID)
+
+	for {
+		item.key, item.val, item.xmv, item.e = source.Decoder.DecodeX()
+
+		select {
+		case channel <- item:
+		case <-done:
+			return
+		}
+
+		if item.e != nil {
+			return
+		}
+	}
+}
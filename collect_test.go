@@ -0,0 +1,102 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollect(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		key     []byte
+		order   []string
+		policy  InterleavePolicy
+		seen    map[string]int
+		val     []byte
+	)
+
+	for _, policy = range []InterleavePolicy{
+		InterleaveArrival, InterleaveRoundRobin,
+	} {
+		buffer.Reset()
+
+		assert.NoError(t,
+			Collect(NewEncoder(&buffer, fnv.New32a()), policy,
+				collectSource("a", 3),
+				collectSource("b", 1),
+				collectSource("c", 2),
+			),
+		)
+
+		decoder = NewDecoder(&buffer, fnv.New32a())
+		order = nil
+		seen = make(map[string]int)
+
+		for {
+			key, val, e = decoder.Decode()
+			if e != nil {
+				break
+			}
+
+			assert.Equal(t, decoder.Source(), string(key))
+			assert.Equal(t, fmt.Sprint(seen[string(key)]), string(val))
+
+			seen[string(key)]++
+
+			order = append(order, string(key))
+		}
+
+		assert.ErrorIs(t, e, io.EOF)
+		assert.Equal(t, map[string]int{"a": 3, "b": 1, "c": 2}, seen)
+
+		if policy == InterleaveRoundRobin {
+			assert.Equal(t, []string{"a", "b", "c", "a", "c", "a"}, order)
+		}
+	}
+
+	return
+}
+
+func TestCollectError(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		e      error
+	)
+
+	assert.NoError(t,
+		NewEncoder(&buffer, fnv.New32a()).Encode([]byte("k"), []byte("v")),
+	)
+
+	buffer.Truncate(buffer.Len() - 1)
+
+	e = Collect(NewEncoder(io.Discard, nil), InterleaveArrival,
+		collectSource("a", 100),
+		Source{"broken", NewDecoder(&buffer, fnv.New32a())},
+	)
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+	assert.ErrorContains(t, e, `"broken"`)
+
+	return
+}
+
+func collectSource(id string, records int) Source {
+	var (
+		buffer  bytes.Buffer
+		encoder = NewEncoder(&buffer, fnv.New32a())
+		i       int
+	)
+
+	for i = 0; i < records; i++ {
+		encoder.Encode([]byte(id), []byte(fmt.Sprint(i)))
+	}
+
+	return Source{id, NewDecoder(&buffer, fnv.New32a())}
+}
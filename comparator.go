@@ -0,0 +1,107 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// An UnknownComparatorError reports a record of a source whose keys are
+// ordered by a custom comparator, as tagged by Encoder.SetComparator, that is
+// not registered with the Decoder by WithComparator, and so cannot be loaded
+// faithfully. See WithUnknownComparators.
+type UnknownComparatorError struct {
+	Source     string
+	Comparator string
+}
+
+func (e UnknownComparatorError) Error() string {
+	return fmt.Sprintf("comparator %q of source %q not registered",
+		e.Comparator, e.Source,
+	)
+}
+
+// SetComparator tags the records of the current source, as set by SetSource,
+// with the identifier of the custom comparator, as set by mdb_set_compare,
+// by which the LMDB database from which they are dumped orders its keys, or
+// removes the tag if id is empty. A Decoder refuses to return the records of
+// the source unless a comparator of the same identifier is registered with
+// it, so that a loader does not restore them into a database sorted
+// otherwise. If the stream is declared or asserted sorted, the comparator
+// must be registered with the Encoder too, by WithComparator, and keys of the
+// source are then expected in its order.
+func (n *Encoder) SetComparator(id string) (e error) {
+	defer errorf("could not set comparator", &e)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if id == n.comparators[n.source] {
+		return
+	}
+
+	if id != "" && n.options.comparators[id] == nil &&
+		(n.options.features&featureSortedKeys != 0 || n.options.assertSorted) {
+		return fmt.Errorf("comparator %q not registered", id)
+	}
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	e = n.writeControl(controlComparator, []byte(id))
+	if e != nil {
+		return
+	}
+
+	if n.comparators == nil {
+		n.comparators = make(map[string]string)
+	}
+
+	n.comparators[n.source] = id
+
+	return
+}
+
+// Comparator returns the identifier of the custom comparator of the current
+// source, as tagged by Encoder.SetComparator, or the empty string if none is
+// tagged, so that a loader can open the target database with it.
+func (d *Decoder) Comparator() string {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.comparators[d.source]
+}
+
+func (o *options) keyOrder(flags DatabaseFlags, comparator string) func(
+	a, b []byte,
+) int {
+	// Returns the function comparing the keys of a source with the given
+	// flags and custom comparator, if registered.
+
+	if o.comparators[comparator] != nil {
+		return o.comparators[comparator]
+	}
+
+	return flags.Compare
+}
+
+func (d *Decoder) checkComparator() (e error) {
+	// Returns an error if the current source has a custom comparator that is
+	// not registered, unless so configured. The caller must hold d.mutex.
+
+	var (
+		id = d.comparators[d.source]
+	)
+
+	if id == "" || d.options.comparators[id] != nil ||
+		d.options.anyComparator {
+		return
+	}
+
+	return UnknownComparatorError{
+		Source:     d.source,
+		Comparator: id,
+	}
+}
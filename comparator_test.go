@@ -0,0 +1,88 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparator(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		byLength = func(a, b []byte) int {
+			// Orders keys by length first.
+
+			if len(a) != len(b) {
+				return len(a) - len(b)
+			}
+
+			return bytes.Compare(a, b)
+		}
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		key     []byte
+		unknown UnknownComparatorError
+	)
+
+	encoder = NewEncoder(&buffer, nil, WithAssertSorted())
+
+	assert.Error(t,
+		encoder.SetComparator("by-length"),
+	)
+
+	encoder = NewEncoder(&buffer, nil, WithAssertSorted(),
+		WithComparator("by-length", byLength),
+	)
+
+	assert.NoError(t,
+		encoder.SetComparator("by-length"),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("aa"), []byte("2")),
+	)
+	assert.Error(t,
+		encoder.Encode([]byte("c"), []byte("3")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	_, _, e = NewDecoder(bytes.NewReader(buffer.Bytes()), nil).Decode()
+	assert.True(t,
+		errors.As(e, &unknown),
+	)
+	assert.Equal(t,
+		UnknownComparatorError{Comparator: "by-length"},
+		unknown,
+	)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithUnknownComparators(),
+	)
+
+	key, _, e = decoder.Decode()
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("b"), key)
+	assert.Equal(t, "by-length",
+		decoder.Comparator(),
+	)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithAssertSorted(), WithComparator("by-length", byLength),
+	)
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e)
+
+	key, _, e = decoder.Decode()
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("aa"), key)
+
+	return
+}
@@ -0,0 +1,156 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"slices"
+)
+
+// A DiffReport lists the differences between two streams of records, as
+// returned by Compare. Keys are listed in ascending order.
+type DiffReport struct {
+	// OnlyInA and OnlyInB list the keys present in one stream but not the
+	// other.
+	OnlyInA [][]byte
+	OnlyInB [][]byte
+
+	// Differing lists the keys present in both streams with differing values
+	// or extended metadata values.
+	Differing [][]byte
+
+	// Matching is the number of keys present in both streams with equal values
+	// and extended metadata values.
+	Matching int
+}
+
+// Equal returns true if the compared streams hold the same records.
+func (r DiffReport) Equal() bool {
+	return len(r.OnlyInA) == 0 && len(r.OnlyInB) == 0 && len(r.Differing) == 0
+}
+
+// Compare receives every record from both [io.Reader]s, verifying the checksum
+// of each if the [hash.Hash32] is not nil, and reports the differences between
+// them, irrespective of the order of records. The options configure both
+// Decoders. Compare retains every key and value of stream a, or, if
+// configured with WithValueDigests, a digest of every value in its place, and
+// every key of stream b. If a key occurs more than once in a stream, its last
+// record counts.
+func Compare(a, b io.Reader, hasher hash.Hash32, opts ...Option) (
+	report DiffReport, e error,
+) {
+	defer errorf("could not compare streams", &e)
+
+	const (
+		onlyInB = iota
+		differing
+		matching
+	)
+
+	var (
+		decoder  *Decoder
+		key      []byte
+		keys     *[][]byte
+		ok       bool
+		options  = newOptions(opts)
+		outcome  int
+		outcomes = make(map[string]int)
+		previous []byte
+		records  = make(map[string][]byte)
+		s        string
+		val      []byte
+		xmv      byte
+	)
+
+	decoder = NewDecoder(a, hasher, opts...)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		records[string(key)] = options.retained(val, xmv)
+	}
+
+	e = nil
+
+	decoder = NewDecoder(b, hasher, opts...)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		previous, ok = records[string(key)]
+
+		switch {
+		case !ok:
+			outcomes[string(key)] = onlyInB
+
+		case bytes.Equal(previous, options.retained(val, xmv)):
+			outcomes[string(key)] = matching
+
+		default:
+			outcomes[string(key)] = differing
+		}
+	}
+
+	e = nil
+
+	for s = range records {
+		_, ok = outcomes[s]
+		if !ok {
+			report.OnlyInA = append(report.OnlyInA, []byte(s))
+		}
+	}
+
+	for s, outcome = range outcomes {
+		switch outcome {
+		case onlyInB:
+			report.OnlyInB = append(report.OnlyInB, []byte(s))
+
+		case differing:
+			report.Differing = append(report.Differing, []byte(s))
+
+		case matching:
+			report.Matching++
+		}
+	}
+
+	for _, keys = range []*[][]byte{
+		&report.OnlyInA, &report.OnlyInB, &report.Differing,
+	} {
+		slices.SortFunc(*keys, bytes.Compare)
+	}
+
+	return
+}
+
+func (o *options) retained(val []byte, xmv byte) []byte {
+	// Returns what Compare retains of a record: its value, or its digest if
+	// so configured, followed by its extended metadata value.
+
+	var (
+		digest [sha256.Size]byte
+	)
+
+	if o.valueDigests {
+		digest = sha256.Sum256(val)
+
+		val = digest[:]
+	}
+
+	return append(slices.Clip(val), xmv)
+}
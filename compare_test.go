@@ -0,0 +1,80 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	var (
+		a, b    bytes.Buffer
+		e       error
+		encoder *Encoder
+		opts    []Option
+		report  DiffReport
+	)
+
+	encoder = NewEncoder(&a, fnv.New32a())
+
+	encoder.Encode([]byte("same"), []byte("val"))
+	encoder.Encode([]byte("gone"), []byte("val"))
+	encoder.Encode([]byte("changed"), []byte("val"))
+	encoder.EncodeX([]byte("retagged"), []byte("val"), XMetaValue1)
+	encoder.Encode([]byte("rewritten"), []byte("old"))
+	encoder.Encode([]byte("rewritten"), []byte("new"))
+
+	encoder = NewEncoder(&b, fnv.New32a())
+
+	encoder.Encode([]byte("new"), []byte("val"))
+	encoder.Encode([]byte("changed"), []byte("lav"))
+	encoder.EncodeX([]byte("retagged"), []byte("val"), XMetaValue2)
+	encoder.Encode([]byte("rewritten"), []byte("new"))
+	encoder.Encode([]byte("same"), []byte("val"))
+	encoder.Encode([]byte("added"), []byte("val"))
+
+	for _, opts = range [][]Option{nil, {WithValueDigests()}} {
+		report, e = Compare(
+			bytes.NewReader(a.Bytes()),
+			bytes.NewReader(b.Bytes()),
+			fnv.New32a(), opts...,
+		)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t,
+			DiffReport{
+				OnlyInA:   [][]byte{[]byte("gone")},
+				OnlyInB:   [][]byte{[]byte("added"), []byte("new")},
+				Differing: [][]byte{[]byte("changed"), []byte("retagged")},
+				Matching:  2,
+			},
+			report,
+		)
+
+		assert.False(t, report.Equal())
+	}
+
+	report, e = Compare(
+		bytes.NewReader(a.Bytes()),
+		bytes.NewReader(a.Bytes()),
+		fnv.New32a(),
+	)
+
+	assert.NoError(t, e)
+	assert.True(t, report.Equal())
+	assert.Equal(t, 5, report.Matching)
+
+	_, e = Compare(
+		bytes.NewReader(a.Bytes()),
+		bytes.NewReader(b.Bytes()[:b.Len()-1]),
+		fnv.New32a(),
+	)
+
+	assert.Error(t, e)
+
+	return
+}
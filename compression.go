@@ -0,0 +1,212 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/lzw"
+	"fmt"
+	"io"
+)
+
+// A Compression identifies the codec by which the value of a record is
+// compressed, chosen per record by an Encoder configured with WithCompression.
+type Compression byte
+
+const (
+	// CompressionNone denotes a value transmitted as is.
+	CompressionNone Compression = iota
+
+	// CompressionDeflate denotes a value compressed with DEFLATE, as by
+	// [compress/flate] at its default level.
+	CompressionDeflate
+
+	// CompressionLZW denotes a value compressed with LZW, as by
+	// [compress/lzw] with LSB order and 8-bit literals, which is faster but
+	// less effective than DEFLATE.
+	CompressionLZW
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+
+	case CompressionDeflate:
+		return "deflate"
+
+	case CompressionLZW:
+		return "lzw"
+	}
+
+	return fmt.Sprintf("compression(%d)", byte(c))
+}
+
+// CompressionStats counts the records transmitted by an Encoder configured with
+// WithCompression, by whether their values were compressed.
+type CompressionStats struct {
+	Compressed  int
+	Passthrough int
+}
+
+// CompressionStats returns counts of the records transmitted so far, by
+// whether their values were compressed.
+func (n *Encoder) CompressionStats() CompressionStats {
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	return n.compressionStats
+}
+
+func (n *Encoder) countCompression(applied Compression) {
+	// Counts a record transmitted with the given codec. The caller must hold
+	// n.mutex.
+
+	if n.features&featureCompression == 0 {
+		return
+	}
+
+	if applied == CompressionNone {
+		n.compressionStats.Passthrough++
+	} else {
+		n.compressionStats.Compressed++
+	}
+}
+
+func (o *options) compress(key, val []byte) (
+	compressed []byte, applied Compression, e error,
+) {
+	// Returns val preceded by the codec chosen for it and compressed
+	// accordingly, and that codec.
+
+	if o.features&featureCompression == 0 {
+		return val, CompressionNone, nil
+	}
+
+	if o.adaptive != nil {
+		return o.adaptive.compress(val)
+	}
+
+	return compressWith(
+		CompressionSetting{
+			Codec: o.chooseCompression(key, val),
+			Level: flate.DefaultCompression,
+		},
+		val,
+	)
+}
+
+func compressWith(setting CompressionSetting, val []byte) (
+	compressed []byte, applied Compression, e error,
+) {
+	// Returns val preceded by the codec of the setting and compressed
+	// accordingly, and that codec. A value that does not shrink is
+	// transmitted as is.
+
+	var (
+		buffer     bytes.Buffer
+		compressor io.WriteCloser
+	)
+
+	buffer.WriteByte(
+		byte(setting.Codec),
+	)
+
+	switch setting.Codec {
+	case CompressionNone:
+		buffer.Write(val)
+
+		return buffer.Bytes(), CompressionNone, nil
+
+	case CompressionDeflate:
+		compressor, e = flate.NewWriter(&buffer, setting.Level)
+		if e != nil {
+			return
+		}
+
+	case CompressionLZW:
+		compressor = lzw.NewWriter(&buffer, lzw.LSB, 8)
+
+	default:
+		return nil, setting.Codec,
+			fmt.Errorf("unknown compression %v", setting.Codec)
+	}
+
+	_, e = compressor.Write(val)
+	if e != nil {
+		return
+	}
+
+	e = compressor.Close()
+	if e != nil {
+		return
+	}
+
+	if buffer.Len() > len(val) {
+		return append([]byte{byte(CompressionNone)}, val...),
+			CompressionNone, nil
+	}
+
+	return buffer.Bytes(), setting.Codec, nil
+}
+
+func (o *options) compressFrom(val io.Reader, size int64) (io.Reader, int64) {
+	// Returns a value of the given size, to be read from val, preceded by
+	// CompressionNone, since it cannot be compressed without being read in
+	// full.
+
+	if o.features&featureCompression == 0 {
+		return val, size
+	}
+
+	return io.MultiReader(bytes.NewReader([]byte{byte(CompressionNone)}), val),
+		size + 1
+}
+
+func (d *Decoder) decompress(val []byte) (decompressed []byte, e error) {
+	// Returns val decompressed by the codec that precedes it.
+
+	var (
+		decompressor io.ReadCloser
+	)
+
+	if len(val) == 0 {
+		return nil, fmt.Errorf("compression tag missing")
+	}
+
+	switch Compression(val[0]) {
+	case CompressionNone:
+		return val[1:], nil
+
+	case CompressionDeflate:
+		decompressor = flate.NewReader(
+			bytes.NewReader(val[1:]),
+		)
+
+	case CompressionLZW:
+		decompressor = lzw.NewReader(
+			bytes.NewReader(val[1:]), lzw.LSB, 8,
+		)
+
+	default:
+		return nil, fmt.Errorf("unknown compression %v", Compression(val[0]))
+	}
+
+	defer decompressor.Close()
+
+	// Guard against values that decompress beyond what LMDB would accept.
+
+	decompressed, e = io.ReadAll(
+		io.LimitReader(decompressor, lmdbMaxValLen+1),
+	)
+	if e != nil {
+		return
+	}
+
+	if len(decompressed) > lmdbMaxValLen {
+		return nil, fmt.Errorf("LMDB maximum value length (4 GiB) exceeded " +
+			"after decompression")
+	}
+
+	return
+}
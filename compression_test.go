@@ -0,0 +1,139 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/rand"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompression(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		decoder  *Decoder
+		e        error
+		encoder  *Encoder
+		expected []byte
+		noise    = make([]byte, 4096)
+		text     = bytes.Repeat([]byte("compressible "), 1000)
+		val      []byte
+	)
+
+	rand.Read(noise)
+
+	encoder = NewEncoder(&buffer, fnv.New32a(),
+		WithCompression(
+			func(key, val []byte) Compression {
+				switch string(key) {
+				case "deflate":
+					return CompressionDeflate
+
+				case "lzw":
+					return CompressionLZW
+				}
+
+				return CompressionNone
+			},
+		),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("deflate"), text),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("lzw"), text),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("deflate"), noise), // would not shrink
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("none"), []byte("val")),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("deflate"), bytes.NewReader(text),
+			int64(len(text)),
+		),
+	)
+
+	assert.Less(t, buffer.Len(), 2*len(text)+len(noise))
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	for _, expected = range [][]byte{text, text, noise, []byte("val"), text} {
+		_, val, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t, expected, val)
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	assert.Equal(t, "deflate", CompressionDeflate.String())
+
+	return
+}
+
+func TestSniffCompression(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		decoder  *Decoder
+		e        error
+		encoder  *Encoder
+		expected []byte
+		jpeg     = append([]byte{0xff, 0xd8, 0xff, 0xe0}, make([]byte, 1000)...)
+		noise    = make([]byte, 100000)
+		sniff    = SniffCompression(CompressionDeflate)
+		text     = bytes.Repeat([]byte("compressible "), 1000)
+		val      []byte
+	)
+
+	rand.Read(noise)
+
+	assert.Equal(t, CompressionDeflate, sniff(nil, text))
+	assert.Equal(t, CompressionDeflate, sniff(nil, make([]byte, 1000)))
+	assert.Equal(t, CompressionNone, sniff(nil, jpeg))
+	assert.Equal(t, CompressionNone, sniff(nil, noise))
+	assert.Equal(t, CompressionNone, sniff(nil, noise[:1000]))
+	assert.Equal(t, CompressionNone, sniff(nil, []byte("short")))
+
+	encoder = NewEncoder(&buffer, fnv.New32a(), WithCompression(sniff))
+
+	for _, val = range [][]byte{text, jpeg, noise, text} {
+		assert.NoError(t,
+			encoder.Encode([]byte("key"), val),
+		)
+	}
+
+	assert.Equal(t,
+		CompressionStats{Compressed: 2, Passthrough: 2},
+		encoder.CompressionStats(),
+	)
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	for _, expected = range [][]byte{text, jpeg, noise, text} {
+		_, val, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t, expected, val)
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
@@ -1,12 +1,17 @@
 package bottledlightning
 
-type xMetaValue byte
+// An XMetaValue is the 4-bit extended metadata value of a record, transmitted
+// and received by [Encoder.EncodeX] and [Decoder.DecodeX]. Its three least
+// significant bits are user bits, which can be assigned arbitrary meaning, and
+// its most significant bit is reserved for a flag defined by the format; see
+// XMetaValue.UserBits and XMetaValue.HasFlag.
+type XMetaValue byte
 
-// Extended metadata values XMetaValue[0, F] can be assigned arbitrary meaning
-// attached to records transmitted and received by [Encoder.EncodeX] and
-// [Decoder.DecodeX].
+// Extended metadata values XMetaValue[0, F]. Those from XMetaValue8 onwards
+// carry the flag XMetaFlagTombstone, and applications should prefer
+// NewXMetaValue to choosing among them directly.
 const (
-	XMetaValue0 xMetaValue = iota
+	XMetaValue0 XMetaValue = iota
 	XMetaValue1
 	XMetaValue2
 	XMetaValue3
@@ -25,10 +30,35 @@ const (
 )
 
 const (
-	lmdbMaxKeyLen = 511
-	lmdbMaxValLen = 1 << 32
-	maxUintLen32  = 4
-	offsetC       = 13
-	offsetM       = 9
-	offsetX       = 14
+	abortReasonMaxLen    = 1 << 10
+	asyncQueueLen        = 64
+	compressionMinValLen = 64
+	controlMaxValLen     = 1<<24 - 1
+	controlOverhead      = 2 + maxUintLen32 + 1 // header, length, kind
+	crcLen               = 4
+	dataKeyLen           = 32
+	dedupMinValLen       = 64
+	dedupRefLen          = 16
+	directIOAlignment    = 4096
+	directIOBufferLen    = 1 << 20
+	dumpTimeLayout       = "20060102T150405Z"
+	entropyRunLen        = 64
+	entropySampleLen     = 4096
+	entropyThreshold     = 7.5
+	envelopeSeqLen       = 8
+	fecLenLen            = 4
+	keyIDMaxLen          = 255
+	lmdbFirst            = 0 // MDB_FIRST
+	lmdbMaxKeyLen        = 511
+	lmdbMaxValLen        = 1 << 32
+	lmdbNext             = 8 // MDB_NEXT
+	loadTxnLen           = 1 << 10
+	maxUintLen32         = 4
+	offsetC              = 13
+	offsetM              = 9
+	offsetX              = 14
+	relayBufferLen       = 1 << 15
+	seekChunkLen         = 1 << 12
+	sizeWindowLen        = 1 << 12
+	verifyJobLen         = 1 << 20
 )
@@ -0,0 +1,271 @@
+// Package consul exports trees of the Consul KV store as streams of records,
+// and imports them back.
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/internal/wrap"
+)
+
+// A KV exports and imports a tree of the Consul KV store, by its HTTP
+// API, as a stream of records, to snapshot small configuration stores in the
+// same format as databases.
+//
+// The key of a record is that of its entry relative to the root of the tree,
+// and the slashes of hierarchical keys are kept as they are. As Consul keys
+// are text, every byte of a record key other than printable ASCII, that is a
+// control character, space, non-ASCII byte or percent sign, is escaped in the
+// Consul key as a percent sign followed by two upper-case hexadecimal digits,
+// so that keys of any bytes survive the round trip, and the keys of entries
+// exported are unescaped accordingly. Folder entries, whose keys end in a
+// slash and which hold no value, are not exported, and neither are the flags
+// of entries.
+type KV struct {
+	// Client issues the requests, or http.DefaultClient if nil.
+	Client *http.Client
+
+	// Address is the URL of the Consul agent, such as
+	// http://127.0.0.1:8500.
+	Address string
+
+	// Root is the key prefix of the tree, such as config/app/, or empty for
+	// the whole store.
+	Root string
+
+	// Token, if not empty, is the ACL token sent with every request.
+	Token string
+}
+
+type consulEntry struct {
+	Key   string
+	Value []byte
+}
+
+// Export encodes the entries of the tree on the Encoder, in the order of
+// their keys, and returns the number of records encoded. It does not close
+// the Encoder.
+func (c KV) Export(ctx context.Context, encoder *bl.Encoder) (
+	n int64, e error,
+) {
+	defer wrap.Errorf("could not export consul tree", &e)
+
+	var (
+		body    []byte
+		entries []consulEntry
+		entry   consulEntry
+		key     []byte
+	)
+
+	body, e = c.do(ctx, http.MethodGet, c.Root, "recurse=true", nil)
+	if e != nil {
+		return
+	}
+
+	if body == nil {
+		return
+	}
+
+	e = json.Unmarshal(body, &entries)
+	if e != nil {
+		return
+	}
+
+	for _, entry = range entries {
+		if strings.HasSuffix(entry.Key, "/") && len(entry.Value) == 0 {
+			continue
+		}
+
+		if !strings.HasPrefix(entry.Key, c.Root) {
+			return n, fmt.Errorf("entry %q outside tree", entry.Key)
+		}
+
+		key, e = Unescape(
+			strings.TrimPrefix(entry.Key, c.Root),
+		)
+		if e != nil {
+			return
+		}
+
+		e = encoder.Encode(key, entry.Value)
+		if e != nil {
+			return
+		}
+
+		n++
+	}
+
+	return
+}
+
+// Import writes the records of the Decoder to the tree until the end of the
+// stream, deleting the entries of those marked with XMetaFlagTombstone, and
+// returns the number of records written. Records are written one request at
+// a time, and so not atomically.
+func (c KV) Import(ctx context.Context, decoder *bl.Decoder) (
+	n int64, e error,
+) {
+	defer wrap.Errorf("could not import consul tree", &e)
+
+	var (
+		key []byte
+		val []byte
+		xmv byte
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			return n, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		if bl.XMetaValue(xmv).HasFlag(bl.XMetaFlagTombstone) {
+			_, e = c.do(ctx, http.MethodDelete, c.Root+Escape(key),
+				"", nil,
+			)
+		} else {
+			_, e = c.do(ctx, http.MethodPut, c.Root+Escape(key),
+				"", val,
+			)
+		}
+
+		if e != nil {
+			return n, fmt.Errorf("key %x: %w", key, e)
+		}
+
+		n++
+	}
+}
+
+func (c KV) do(ctx context.Context, method, key, query string,
+	val []byte,
+) (body []byte, e error) {
+	// Requests the key of the KV endpoint, returning the body of the
+	// response, or nil if the key is not found.
+
+	var (
+		client   = c.Client
+		i        int
+		request  *http.Request
+		response *http.Response
+		segments = strings.Split(key, "/")
+		target   string
+	)
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// Consul keys may hold characters special to URLs, such as question
+	// marks, which are escaped in the path.
+
+	for i = range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+
+	target = strings.TrimSuffix(c.Address, "/") + "/v1/kv/" +
+		strings.Join(segments, "/")
+
+	if query != "" {
+		target += "?" + query
+	}
+
+	request, e = http.NewRequestWithContext(ctx, method, target,
+		bytes.NewReader(val),
+	)
+	if e != nil {
+		return
+	}
+
+	if c.Token != "" {
+		request.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	response, e = client.Do(request)
+	if e != nil {
+		return
+	}
+
+	defer response.Body.Close()
+
+	body, e = io.ReadAll(response.Body)
+	if e != nil {
+		return
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return nil, nil
+
+	case response.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", response.Status,
+			bytes.TrimSpace(body),
+		)
+	}
+
+	return
+}
+
+// Escape returns the Consul key of a record key, as documented by KV.
+func Escape(key []byte) string {
+	var (
+		b       byte
+		builder strings.Builder
+	)
+
+	for _, b = range key {
+		if b > ' ' && b < 0x7f && b != '%' {
+			builder.WriteByte(b)
+
+			continue
+		}
+
+		fmt.Fprintf(&builder, "%%%02X", b)
+	}
+
+	return builder.String()
+}
+
+// Unescape returns the record key of a Consul key, reversing Escape.
+func Unescape(key string) (b []byte, e error) {
+	var (
+		i int
+	)
+
+	b = make([]byte, 0, len(key))
+
+	for i = 0; i < len(key); i++ {
+		if key[i] != '%' {
+			b = append(b, key[i])
+
+			continue
+		}
+
+		if i+3 > len(key) {
+			return nil, fmt.Errorf("malformed escape in key %q", key)
+		}
+
+		b, e = hex.AppendDecode(b, []byte(key[i+1:i+3]))
+		if e != nil {
+			return nil, fmt.Errorf("malformed escape in key %q", key)
+		}
+
+		i += 2
+	}
+
+	return
+}
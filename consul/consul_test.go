@@ -0,0 +1,192 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A fakeConsul serves the KV endpoint of the Consul HTTP API from memory.
+type fakeConsul struct {
+	mutex sync.Mutex
+	data  map[string][]byte
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		entries []consulEntry
+		k       string
+		key     = strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		keys    []string
+	)
+
+	f.mutex.Lock()
+
+	defer f.mutex.Unlock()
+
+	if r.Header.Get("X-Consul-Token") != "secret" {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		for k = range f.data {
+			if strings.HasPrefix(k, key) {
+				keys = append(keys, k)
+			}
+		}
+
+		if len(keys) == 0 {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		slices.Sort(keys)
+
+		for _, k = range keys {
+			entries = append(entries, consulEntry{k, f.data[k]})
+		}
+
+		json.NewEncoder(w).Encode(entries)
+
+	case http.MethodPut:
+		f.data[key], _ = io.ReadAll(r.Body)
+
+		io.WriteString(w, "true")
+
+	case http.MethodDelete:
+		delete(f.data, key)
+
+		io.WriteString(w, "true")
+	}
+
+	return
+}
+
+func TestKV(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		ctx     = context.Background()
+		decoder *bl.Decoder
+		e       error
+		encoder = bl.NewEncoder(&buffer, nil)
+		fake    = &fakeConsul{
+			data: map[string][]byte{
+				"config/app/":         nil,
+				"config/app/db/host":  []byte("localhost"),
+				"config/app/db/port":  []byte("5432"),
+				"config/app/stale":    []byte("x"),
+				"config/other/secret": []byte("s"),
+			},
+		}
+		key       []byte
+		keys      []string
+		kv        KV
+		n         int64
+		server    = httptest.NewServer(fake)
+		strange   = []byte("a b/%?\xff")
+		tombstone = bl.NewXMetaValue(0, bl.XMetaFlagTombstone)
+	)
+
+	defer server.Close()
+
+	kv = KV{
+		Address: server.URL,
+		Root:    "config/app/",
+		Token:   "secret",
+	}
+
+	assert.NoError(t,
+		encoder.Encode(strange, []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("stale"), nil, tombstone),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	n, e = kv.Import(ctx, bl.NewDecoder(&buffer, nil))
+	assert.NoError(t, e)
+	assert.EqualValues(t, 2, n)
+	assert.Equal(t, []byte("1"), fake.data["config/app/a%20b/%25?%FF"])
+	assert.NotContains(t, fake.data, "config/app/stale")
+
+	buffer.Reset()
+
+	encoder = bl.NewEncoder(&buffer, nil)
+
+	n, e = kv.Export(ctx, encoder)
+	assert.NoError(t, e)
+	assert.EqualValues(t, 3, n)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	decoder = bl.NewDecoder(&buffer, nil)
+
+	for {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		keys = append(keys, string(key))
+	}
+
+	assert.Equal(t,
+		[]string{string(strange), "db/host", "db/port"},
+		keys,
+	)
+
+	// An empty tree exports nothing, and a request refused fails.
+
+	n, e = KV{Address: server.URL, Root: "none/", Token: "secret"}.
+		Export(ctx, bl.NewEncoder(io.Discard, nil))
+	assert.NoError(t, e)
+	assert.Zero(t, n)
+
+	_, e = KV{Address: server.URL}.
+		Export(ctx, bl.NewEncoder(io.Discard, nil))
+	assert.ErrorContains(t, e, "403 Forbidden: ACL not found")
+
+	return
+}
+
+func TestEscape(t *testing.T) {
+	var (
+		e   error
+		key []byte
+	)
+
+	assert.Equal(t, "a/b%25c%0A%C3%A9", Escape([]byte("a/b%c\né")))
+
+	key, e = Unescape("a/b%25c%0A%C3%A9")
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("a/b%c\né"), key)
+
+	_, e = Unescape("a%2")
+	assert.Error(t, e)
+
+	_, e = Unescape("a%zz")
+	assert.Error(t, e)
+
+	return
+}
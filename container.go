@@ -0,0 +1,307 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"slices"
+)
+
+// A container packs several streams, such as one per LMDB database or tenant,
+// into a single file. It consists of
+//
+//   - the magic bytes containerMagic,
+//   - the streams, one after another,
+//   - a table of contents, holding for each stream in order the length of its
+//     name in 2 bytes, the name, and its offset and length in 8 bytes each,
+//     and
+//   - a footer, at a fixed offset from the end, holding the offset of the
+//     table of contents in 8 bytes, its CRC-32 (IEEE) checksum in 4 bytes and
+//     the magic bytes again.
+//
+// Integers are big-endian.
+const (
+	containerMagic     = "BLCNTR\x00\x01"
+	containerFooterLen = 8 + crcLen + 8
+)
+
+// A ContainerWriter writes a container of named streams, each of which can be
+// the destination of an Encoder. ContainerWriters are not safe for concurrent
+// use by multiple goroutines.
+type ContainerWriter struct {
+	writer  io.Writer
+	offset  int64
+	entries []containerEntry
+	closed  bool
+}
+
+type containerEntry struct {
+	name   string
+	offset int64
+	length int64
+}
+
+// NewContainerWriter returns a new ContainerWriter that writes a container to
+// the [io.Writer], beginning with its magic bytes.
+func NewContainerWriter(writer io.Writer) (c *ContainerWriter, e error) {
+	defer errorf("could not create container writer", &e)
+
+	c = &ContainerWriter{
+		writer: writer,
+	}
+
+	e = c.write([]byte(containerMagic))
+	if e != nil {
+		return nil, e
+	}
+
+	return
+}
+
+// Create begins a stream with the given name, which must be unique within the
+// container, ending the previous stream. Bytes written to the returned
+// [io.Writer] until the next call to Create or Close form the stream.
+func (c *ContainerWriter) Create(name string) (w io.Writer, e error) {
+	defer errorf("could not create container entry", &e)
+
+	var (
+		entry containerEntry
+	)
+
+	switch {
+	case c.closed:
+		return nil, fmt.Errorf("container writer closed")
+
+	case len(name) > 1<<16-1:
+		return nil, fmt.Errorf("name too long")
+	}
+
+	for _, entry = range c.entries {
+		if entry.name == name {
+			return nil, fmt.Errorf("duplicate name %q", name)
+		}
+	}
+
+	c.entries = append(c.entries,
+		containerEntry{name: name, offset: c.offset},
+	)
+
+	return containerEntryWriter{c, len(c.entries) - 1}, nil
+}
+
+// Close ends the last stream and writes the table of contents and footer. It
+// does not close the underlying [io.Writer].
+func (c *ContainerWriter) Close() (e error) {
+	defer errorf("could not close container writer", &e)
+
+	var (
+		entry  containerEntry
+		footer []byte
+		offset = c.offset
+		toc    []byte
+	)
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+
+	for _, entry = range c.entries {
+		toc = binary.BigEndian.AppendUint16(toc, uint16(len(entry.name)))
+		toc = append(toc, entry.name...)
+		toc = binary.BigEndian.AppendUint64(toc, uint64(entry.offset))
+		toc = binary.BigEndian.AppendUint64(toc, uint64(entry.length))
+	}
+
+	footer = binary.BigEndian.AppendUint64(footer, uint64(offset))
+	footer = binary.BigEndian.AppendUint32(footer, crc32.ChecksumIEEE(toc))
+	footer = append(footer, containerMagic...)
+
+	e = c.write(
+		append(toc, footer...),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (c *ContainerWriter) write(p []byte) (e error) {
+	// Writes p to the container, advancing the offset.
+
+	var (
+		n int
+	)
+
+	n, e = c.writer.Write(p)
+
+	c.offset += int64(n)
+
+	return
+}
+
+type containerEntryWriter struct {
+	container *ContainerWriter
+	index     int
+}
+
+func (w containerEntryWriter) Write(p []byte) (n int, e error) {
+	var (
+		c = w.container
+	)
+
+	if c.closed || w.index != len(c.entries)-1 {
+		return 0, fmt.Errorf("container entry %q ended",
+			c.entries[w.index].name,
+		)
+	}
+
+	n, e = c.writer.Write(p)
+
+	c.offset += int64(n)
+	c.entries[w.index].length += int64(n)
+
+	return
+}
+
+// A Container is a container of named streams, as written by a
+// ContainerWriter, that is open for reading. Containers are safe for
+// concurrent use by multiple goroutines, as are the [io.ReaderAt] values from
+// which they read.
+type Container struct {
+	reader  io.ReaderAt
+	entries []containerEntry
+}
+
+// OpenContainer reads the table of contents of the container of the given size
+// that the [io.ReaderAt] holds, such as an [os.File].
+func OpenContainer(reader io.ReaderAt, size int64) (c *Container, e error) {
+	defer errorf("could not open container", &e)
+
+	var (
+		entry   containerEntry
+		footer  = make([]byte, containerFooterLen)
+		l       int
+		magic   = make([]byte, len(containerMagic))
+		offset  int64
+		section []byte
+		toc     []byte
+	)
+
+	if size < int64(len(containerMagic)+containerFooterLen) {
+		return nil, fmt.Errorf("container too short")
+	}
+
+	_, e = reader.ReadAt(magic, 0)
+	if e != nil {
+		return nil, e
+	}
+
+	_, e = reader.ReadAt(footer, size-containerFooterLen)
+	if e != nil {
+		return nil, e
+	}
+
+	if string(magic) != containerMagic ||
+		!bytes.Equal(footer[8+crcLen:], []byte(containerMagic)) {
+		return nil, fmt.Errorf("not a container")
+	}
+
+	offset = int64(binary.BigEndian.Uint64(footer))
+
+	if offset < int64(len(containerMagic)) ||
+		offset > size-containerFooterLen {
+		return nil, fmt.Errorf("malformed footer")
+	}
+
+	toc = make([]byte, size-containerFooterLen-offset)
+
+	_, e = reader.ReadAt(toc, offset)
+	if e != nil {
+		return nil, e
+	}
+
+	if crc32.ChecksumIEEE(toc) != binary.BigEndian.Uint32(footer[8:]) {
+		return nil, fmt.Errorf("table of contents checksum does not match")
+	}
+
+	c = &Container{
+		reader: reader,
+	}
+
+	for section = toc; len(section) > 0; section = section[l+16:] {
+		if len(section) < 2 {
+			return nil, fmt.Errorf("malformed table of contents")
+		}
+
+		l = int(binary.BigEndian.Uint16(section))
+
+		section = section[2:]
+
+		if len(section) < l+16 {
+			return nil, fmt.Errorf("malformed table of contents")
+		}
+
+		entry = containerEntry{
+			name:   string(section[:l]),
+			offset: int64(binary.BigEndian.Uint64(section[l:])),
+			length: int64(binary.BigEndian.Uint64(section[l+8:])),
+		}
+
+		if entry.offset < int64(len(containerMagic)) ||
+			entry.length < 0 || entry.offset+entry.length > offset {
+			return nil, fmt.Errorf("entry %q out of bounds", entry.name)
+		}
+
+		c.entries = append(c.entries, entry)
+	}
+
+	return
+}
+
+// Names returns the names of the streams in the container, in the order in
+// which they were written.
+func (c *Container) Names() (names []string) {
+	var (
+		entry containerEntry
+	)
+
+	for _, entry = range c.entries {
+		names = append(names, entry.name)
+	}
+
+	return
+}
+
+// Open returns a new Decoder that will receive from the named stream, as would
+// a Decoder returned by NewDecoder. The stream is an [io.ReadSeeker], so that
+// the Decoder supports SeekToKey.
+func (c *Container) Open(name string, hasher hash.Hash32, opts ...Option) (
+	d *Decoder, e error,
+) {
+	defer errorf("could not open container entry", &e)
+
+	var (
+		i int
+	)
+
+	i = slices.IndexFunc(c.entries,
+		func(entry containerEntry) bool { return entry.name == name },
+	)
+	if i < 0 {
+		return nil, fmt.Errorf("no entry %q", name)
+	}
+
+	d = NewDecoder(
+		io.NewSectionReader(c.reader, c.entries[i].offset, c.entries[i].length),
+		hasher,
+		opts...,
+	)
+
+	return
+}
@@ -0,0 +1,94 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainer(t *testing.T) {
+	var (
+		buffer    bytes.Buffer
+		container *Container
+		decoder   *Decoder
+		e         error
+		key       []byte
+		name      string
+		val       []byte
+		writer    *ContainerWriter
+		entries   [2]io.Writer
+		i         int
+	)
+
+	writer, e = NewContainerWriter(&buffer)
+
+	assert.NoError(t, e)
+
+	for i, name = range []string{"alpha", "beta"} {
+		entries[i], e = writer.Create(name)
+
+		assert.NoError(t, e)
+
+		assert.NoError(t,
+			NewEncoder(entries[i], fnv.New32a()).Encode(
+				[]byte(name), []byte(name+"-value"),
+			),
+		)
+	}
+
+	_, e = writer.Create("alpha")
+
+	assert.Error(t, e)
+
+	_, e = entries[0].Write([]byte("late"))
+
+	assert.Error(t, e)
+
+	assert.NoError(t, writer.Close())
+
+	container, e = OpenContainer(
+		bytes.NewReader(buffer.Bytes()), int64(buffer.Len()),
+	)
+
+	assert.NoError(t, e)
+	assert.Equal(t, []string{"alpha", "beta"}, container.Names())
+
+	for _, name = range container.Names() {
+		decoder, e = container.Open(name, fnv.New32a())
+
+		assert.NoError(t, e)
+
+		key, val, e = decoder.Decode()
+
+		assert.NoError(t, e)
+		assert.Equal(t, []byte(name), key)
+		assert.Equal(t, []byte(name+"-value"), val)
+
+		_, _, e = decoder.Decode()
+
+		assert.ErrorIs(t, e, io.EOF)
+	}
+
+	_, e = container.Open("gamma", nil)
+
+	assert.Error(t, e)
+
+	buffer.Bytes()[buffer.Len()-containerFooterLen-1] ^= 1
+
+	_, e = OpenContainer(
+		bytes.NewReader(buffer.Bytes()), int64(buffer.Len()),
+	)
+
+	assert.Error(t, e)
+
+	_, e = OpenContainer(
+		bytes.NewReader(buffer.Bytes()), int64(buffer.Len()-1),
+	)
+
+	assert.Error(t, e)
+
+	return
+}
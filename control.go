@@ -0,0 +1,281 @@
+package bottledlightning
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Control records carry information about a stream rather than LMDB data. They
+// are framed like any other record, but are distinguished by an empty key and
+// a value length encoded non-minimally in four bytes---a combination that an
+// Encoder never produces for a data record. The first byte of the value
+// identifies the kind of control record, and the remainder is its payload.
+type controlKind byte
+
+const (
+	controlKeepalive controlKind = iota + 1
+	controlSource
+	controlFeatures
+	controlBatchChecksum
+	controlSignature
+	controlDataKey
+	controlSchema
+	controlSeekMarker
+	controlDedup
+	controlCheckpoint
+	controlSnapshotID
+	controlAbort
+	controlTraceContext
+	controlCompression
+	controlDatabaseFlags
+	controlComparator
+	controlPadding
+	controlTransactionBegin
+	controlTransactionCommit
+	controlChecksum
+)
+
+func isControl(x, k, v int) bool {
+	// Returns true if the header fields x, k and v describe a control record.
+
+	return k == 0 && x == maxUintLen32 && v <= controlMaxValLen
+}
+
+func (n *Encoder) writeControl(kind controlKind, payload []byte) (e error) {
+	// Writes a control record of the given kind. The caller must hold
+	// n.mutex.
+
+	var (
+		c      = n.hasher != nil && n.features&featureBatchChecksum == 0
+		header = make([]byte, 2, 2+maxUintLen32)
+		val    = append([]byte{byte(kind)}, payload...)
+	)
+
+	if len(val) > controlMaxValLen {
+		return fmt.Errorf("control record payload too long")
+	}
+
+	if c {
+		header[0] = 1 << (offsetC - 8)
+	}
+
+	header = binary.BigEndian.AppendUint32(header,
+		uint32(len(val)),
+	)
+
+	_, e = n.writer.Write(header)
+	if e != nil {
+		return
+	}
+
+	e = n.writeVal(val)
+	if e != nil {
+		return
+	}
+
+	if !c {
+		return
+	}
+
+	if n.features&featureHeaderChecksum != 0 {
+		n.hasher.Reset()
+
+		_, e = n.hasher.Write(header)
+		if e != nil {
+			return
+		}
+	}
+
+	e = n.writeChecksum(nil, val)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) keepalive() {
+	// Transmits a keepalive control record whenever the stream has been idle
+	// for the configured interval, until the Encoder is closed or a write
+	// fails. A failed write is left for the next call to Encode to discover.
+
+	var (
+		e       error
+		payload = make([]byte, 8)
+		ticker  = time.NewTicker(n.options.keepaliveInterval)
+		now     time.Time
+	)
+
+	defer n.workers.Done()
+
+	defer ticker.Stop()
+
+	n.options.labelGoroutine("keepalive")
+
+	for {
+		select {
+		case <-n.done:
+			return
+
+		case now = <-ticker.C:
+		}
+
+		n.mutex.Lock()
+
+		if now.Sub(n.lastWrite) < n.options.keepaliveInterval {
+			n.mutex.Unlock()
+
+			continue
+		}
+
+		binary.BigEndian.PutUint64(payload,
+			uint64(now.UnixNano()),
+		)
+
+		e = n.begin()
+		if e == nil {
+			e = n.writeControl(controlKeepalive, payload)
+		}
+
+		n.mutex.Unlock()
+
+		if e != nil {
+			return
+		}
+	}
+}
+
+func (d *Decoder) handleControl(val []byte) (e error) {
+	// Acts upon a control record. Control records of unknown kinds are
+	// ignored, so that streams produced by newer Encoders remain readable.
+
+	if len(val) == 0 {
+		return fmt.Errorf("control record kind missing")
+	}
+
+	switch controlKind(val[0]) {
+	case controlKeepalive:
+		if len(val) != 9 {
+			return fmt.Errorf("malformed keepalive control record")
+		}
+
+		if d.options.livenessMonitor == nil {
+			return
+		}
+
+		d.options.livenessMonitor(
+			time.Unix(0,
+				int64(binary.BigEndian.Uint64(val[1:])),
+			),
+		)
+
+	case controlPadding:
+		// Padding only aligns what follows; see WithBatchAlignment.
+
+	case controlBatchChecksum:
+		if len(val) != 1+maxUintLen32 {
+			return fmt.Errorf("malformed batch checksum control record")
+		}
+
+		e = d.verifyBatch(
+			binary.BigEndian.Uint32(val[1:]),
+		)
+		if e != nil {
+			return
+		}
+
+	case controlDataKey:
+		e = d.receiveKey(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlCheckpoint:
+		d.receiveCheckpoint(val[1:])
+
+	case controlChecksum:
+		e = d.receiveChecksum(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlTransactionBegin:
+		e = d.receiveTransactionBegin(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlTransactionCommit:
+		e = d.receiveTransactionCommit()
+		if e != nil {
+			return
+		}
+
+	case controlSnapshotID:
+		e = d.receiveSnapshotID(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlTraceContext:
+		d.traceContext, e = unmarshalTraceContext(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlComparator:
+		if d.comparators == nil {
+			d.comparators = make(map[string]string)
+		}
+
+		d.comparators[d.source] = string(val[1:])
+
+	case controlDatabaseFlags:
+		e = d.receiveDatabaseFlags(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlCompression:
+		e = d.receiveCompressionSetting(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlAbort:
+		return AbortedError{
+			Reason: string(val[1:]),
+		}
+
+	case controlDedup:
+		e = d.receiveDedup(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlSchema:
+		e = d.schema.unmarshal(val[1:])
+		if e != nil {
+			return
+		}
+
+	case controlFeatures:
+		if len(val) != 5 {
+			return fmt.Errorf("malformed features control record")
+		}
+
+		d.features = binary.BigEndian.Uint32(val[1:])
+
+		if d.features&^knownFeatures != 0 {
+			return fmt.Errorf("unsupported format features %#x",
+				d.features&^knownFeatures,
+			)
+		}
+
+	case controlSource:
+		d.source = string(val[1:])
+	}
+
+	return
+}
@@ -0,0 +1,101 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlKeepalive(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		e      error
+		key    []byte
+		val    []byte
+		sent   []time.Time
+
+		encoder *Encoder = NewEncoder(&buffer,
+			fnv.New32a(),
+			WithKeepalive(time.Millisecond),
+		)
+		decoder *Decoder = NewDecoder(&buffer,
+			fnv.New32a(),
+			WithLivenessMonitor(
+				func(t time.Time) { sent = append(sent, t) },
+			),
+		)
+	)
+
+	time.Sleep(10 * time.Millisecond)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	key, val, e = decoder.Decode()
+	if e != nil {
+		t.Error(e)
+	}
+
+	assert.Equal(t, []byte("key"), key)
+	assert.Equal(t, []byte("val"), val)
+
+	assert.NotEmpty(t, sent)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
+
+func TestControlWriteControl(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+
+		encoder *Encoder = NewEncoder(&buffer, nil)
+	)
+
+	assert.NoError(t,
+		encoder.writeControl(controlKeepalive, []byte{0xff}),
+	)
+
+	assert.Equal(t,
+		[]byte{
+			0b00000000, 0b00000000, // x = 4, c = 0, k = 0
+			0, 0, 0, 2, // v = 2
+			byte(controlKeepalive), 0xff,
+		},
+		buffer.Bytes(),
+	)
+
+	return
+}
+
+func TestControlIsControl(t *testing.T) {
+	assert.True(t,
+		isControl(4, 0, 1),
+	)
+
+	assert.False(t,
+		isControl(1, 0, 1),
+	)
+
+	assert.False(t,
+		isControl(4, 1, 1),
+	)
+
+	assert.False(t,
+		isControl(4, 0, 1<<24),
+	)
+
+	return
+}
@@ -0,0 +1,116 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// DatabaseFlags are the flags of an LMDB database that determine the order of
+// its keys, with the values of the corresponding flags of mdb_dbi_open, so
+// that a database restored from a stream is opened with the comparator of
+// the original. See Encoder.SetDatabaseFlags.
+type DatabaseFlags uint32
+
+const (
+	// DatabaseReverseKey denotes a database opened with MDB_REVERSEKEY, whose
+	// keys are compared as strings of bytes read from the end.
+	DatabaseReverseKey DatabaseFlags = 0x02
+)
+
+// Compare compares keys as does the comparator of a database with the flags,
+// returning a negative number if a sorts before b, a positive number if after,
+// and zero if they are equal.
+func (f DatabaseFlags) Compare(a, b []byte) int {
+	var (
+		i int
+	)
+
+	if f&DatabaseReverseKey == 0 {
+		return bytes.Compare(a, b)
+	}
+
+	for i = 1; i <= min(len(a), len(b)); i++ {
+		switch {
+		case a[len(a)-i] < b[len(b)-i]:
+			return -1
+
+		case a[len(a)-i] > b[len(b)-i]:
+			return 1
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+// SetDatabaseFlags records the flags of the LMDB database from which the
+// records of the current source, as set by SetSource, are dumped, for the
+// Decoder to report by DatabaseFlags, so that a loader can open the target
+// database alike. Keys of the source are then expected in the order of its
+// comparator by WithSortedKeys and WithAssertSorted.
+func (n *Encoder) SetDatabaseFlags(flags DatabaseFlags) (e error) {
+	defer errorf("could not set database flags", &e)
+
+	var (
+		payload = make([]byte, 4)
+	)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if flags == n.sourceFlags[n.source] {
+		return
+	}
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	binary.BigEndian.PutUint32(payload,
+		uint32(flags),
+	)
+
+	e = n.writeControl(controlDatabaseFlags, payload)
+	if e != nil {
+		return
+	}
+
+	if n.sourceFlags == nil {
+		n.sourceFlags = make(map[string]DatabaseFlags)
+	}
+
+	n.sourceFlags[n.source] = flags
+
+	return
+}
+
+// DatabaseFlags returns the flags of the LMDB database from which the records
+// of the current source were dumped, as recorded by
+// Encoder.SetDatabaseFlags, or zero if none are recorded.
+func (d *Decoder) DatabaseFlags() DatabaseFlags {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.sourceFlags[d.source]
+}
+
+func (d *Decoder) receiveDatabaseFlags(payload []byte) (e error) {
+	// Notes the flags of the database of the current source.
+
+	if len(payload) != 4 {
+		return fmt.Errorf("malformed database flags control record")
+	}
+
+	if d.sourceFlags == nil {
+		d.sourceFlags = make(map[string]DatabaseFlags)
+	}
+
+	d.sourceFlags[d.source] = DatabaseFlags(
+		binary.BigEndian.Uint32(payload),
+	)
+
+	return
+}
@@ -0,0 +1,86 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabaseFlagsCompare(t *testing.T) {
+	var (
+		reverse = DatabaseReverseKey
+	)
+
+	assert.Negative(t,
+		DatabaseFlags(0).Compare([]byte("ab"), []byte("ba")),
+	)
+	assert.Positive(t,
+		reverse.Compare([]byte("ab"), []byte("ba")),
+	)
+	assert.Negative(t,
+		reverse.Compare([]byte("b"), []byte("ab")),
+	)
+	assert.Positive(t,
+		reverse.Compare([]byte("ab"), []byte("b")),
+	)
+	assert.Zero(t,
+		reverse.Compare([]byte("ab"), []byte("ab")),
+	)
+
+	return
+}
+
+func TestDatabaseFlags(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil, WithSortedBySource())
+	)
+
+	// Keys in the order of MDB_REVERSEKEY are in order in their source
+	// only.
+
+	assert.NoError(t,
+		encoder.SetSource("reversed"),
+	)
+	assert.NoError(t,
+		encoder.SetDatabaseFlags(DatabaseReverseKey),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("ba"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("ab"), []byte("2")),
+	)
+	assert.NoError(t,
+		encoder.SetSource("main"),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("ab"), []byte("3")),
+	)
+	assert.Error(t,
+		encoder.Encode([]byte("aa"), []byte("4")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	decoder = NewDecoder(&buffer, nil, WithSortedBySource())
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e)
+	assert.Equal(t, DatabaseReverseKey, decoder.DatabaseFlags())
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e)
+	assert.Zero(t,
+		decoder.DatabaseFlags(),
+	)
+
+	return
+}
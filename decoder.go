@@ -1,29 +1,79 @@
 package bottledlightning
 
 import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"hash"
 	"io"
 	"sync"
+	"time"
 )
 
 // Inspired by [encoding/gob.Decoder] from the Go standard library, a Decoder
 // specialises in the receipt of LMDB key-value records transmitted by an
 // Encoder counterpart. It is safe for concurrent use by multiple goroutines.
 type Decoder struct {
-	reader io.Reader
-	hasher hash.Hash32
-	mutex  sync.Mutex
+	reader  io.Reader
+	hasher  hash.Hash32
+	mutex   sync.Mutex
+	options options
+	source  string
+
+	version            FormatVersion
+	features           uint32
+	schema             Schema
+	checksum           Checksum
+	checksumAnnounced  bool
+	auditStats         AuditStats
+	recordStats        RecordStats
+	sizes              sizeWindow
+	traceContext       map[string]string
+	compressionSetting CompressionSetting
+	lastKey            []byte
+	lastKeys           map[string][]byte // by source
+	sourceFlags        map[string]DatabaseFlags
+	comparators        map[string]string // by source
+	dedupCache         *dedupCache
+	checkpoint         []byte
+	awaitCheckpoint    bool
+	transaction        []byte // identifier, if within a transaction
+	inTransaction      bool
+	transactionsBegun  uint64
+	batched            int
+	digest             hash.Hash
+	snapshot           *merkleTree
+	snapshotPartial    bool
+	snapshotID         []byte
+	dataKey            cipher.AEAD
+	unsigned           int
+	budgeted           int64       // from the MemoryBudget, by the last record
+	skipped            ValueHandle // value of the last record, if lazy
+	head               []byte
+	tail               []byte
+	closed             bool
 }
 
 // NewDecoder returns a new Decoder that will receive from the [io.Reader], and
 // optionally verify the checksum of every record if the [hash.Hash32] is not
 // nil.
-func NewDecoder(reader io.Reader, hasher hash.Hash32) (d *Decoder) {
+func NewDecoder(reader io.Reader, hasher hash.Hash32, opts ...Option) (
+	d *Decoder,
+) {
 	d = &Decoder{
-		reader: reader,
-		hasher: hasher,
+		reader:  reader,
+		hasher:  hasher,
+		options: newOptions(opts),
+	}
+
+	if d.options.verifyingKey != nil {
+		d.digest = sha256.New()
+	}
+
+	if d.options.snapshotID {
+		d.snapshot = new(merkleTree)
 	}
 
 	return
@@ -46,44 +96,362 @@ func (d *Decoder) DecodeX() (key, val []byte, xmv byte, e error) {
 }
 
 func (d *Decoder) decode() (key, val []byte, xmv byte, e error) {
+	var (
+		handle ValueHandle
+	)
+
+	key, handle, xmv, e = d.decodeHandle(true)
+	if e != nil {
+		return
+	}
+
+	return key, handle.val, xmv, nil
+}
+
+func (d *Decoder) decodeHandle(load bool) (key []byte, handle ValueHandle,
+	xmv byte, e error,
+) {
+	// Receives the next data record, reading its value if it was skipped and
+	// load is true.
+
 	defer errorf("could not decode record", &e)
 
 	var (
-		c bool // a trailing 32-bit checksum is present if true
-		k int  // key length
-		v int  // value length
-		x int  // number of bytes representing value length
+		mapped []byte
+		val    []byte
 	)
 
 	d.mutex.Lock()
 
 	defer d.mutex.Unlock()
 
-	x, c, xmv, k, e = d.readXCMK()
+	defer d.countRecord(&key, &handle, &e)
+
+	key, val, xmv, e = d.next()
 	if e != nil {
 		return
 	}
 
-	v, e = d.readV(x)
+	handle = d.skipped
+
+	if handle.reader == nil {
+		handle = ValueHandle{
+			length: int64(len(val)),
+			val:    val,
+		}
+	}
+
+	mapped = d.mapKey(key)
+
+	e = d.options.profile.validate(mapped, handle.length)
 	if e != nil {
-		return
+		return nil, ValueHandle{}, 0, e
+	}
+
+	e = d.checkComparator()
+	if e != nil {
+		return nil, ValueHandle{}, 0, e
 	}
 
-	key, e = d.readKey(k)
+	e = d.orderKey(key)
 	if e != nil {
 		return
 	}
 
-	val, e = d.readVal(v)
+	key = mapped
+
+	e = d.options.audit(&d.auditStats, OpDecode, key, XMetaValue(xmv))
 	if e != nil {
+		return nil, ValueHandle{}, 0, e
+	}
+
+	if load && handle.reader != nil {
+		handle.val, e = handle.Bytes()
+		if e != nil {
+			return
+		}
+
+		handle.reader = nil
+	}
+
+	return
+}
+
+// Buffered returns a reader of the bytes that the Decoder has read from the
+// underlying [io.Reader] but not yet consumed, as does
+// [encoding/json.Decoder.Buffered], so that a stream embedded in a larger
+// protocol can be followed by other data. The reader is valid until the next
+// call to the Decoder. A Decoder reads no further than the record it returns,
+// so the reader is currently always empty, but callers should not rely on it.
+func (d *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(nil)
+}
+
+// Close releases the state that the Decoder retains between records, such as
+// its deduplication cache. It returns an error if any record already received
+// awaits verification by a trailer, namely the checksum of its batch or the
+// signature of the stream, that the Decoder has yet to receive. It does not
+// close the underlying [io.Reader].
+//
+// The Decoder cannot be used after Close, which does nothing if called again.
+func (d *Decoder) Close() (e error) {
+	defer errorf("could not close decoder", &e)
+
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	if d.closed {
 		return
 	}
 
-	if !c {
+	switch {
+	case d.batched > 0:
+		e = fmt.Errorf("closed before checksum of last batch")
+
+	case d.unsigned > 0:
+		e = fmt.Errorf("closed with unsigned records")
+	}
+
+	d.closed = true
+
+	d.releaseMemory()
+
+	d.skipped = ValueHandle{}
+	d.dedupCache = nil
+	d.lastKey = nil
+	d.lastKeys = nil
+	d.checkpoint = nil
+	d.dataKey = nil
+	d.digest = nil
+	d.snapshot = nil
+	d.head = nil
+	d.tail = nil
+
+	return
+}
+
+func (d *Decoder) next() (key, val []byte, xmv byte, e error) {
+	// Receives records until a data record, acting upon any control records
+	// on the way. The caller must hold d.mutex.
+
+	var (
+		c       bool // a trailing 32-bit checksum is present if true
+		control bool // the record is a control record if true
+		k       int  // key length
+		lazy    bool // the value is to be skipped if true
+		v       int  // value length
+		x       int  // number of bytes representing value length
+	)
+
+	if d.closed {
+		return nil, nil, 0, fmt.Errorf("decoder closed")
+	}
+
+	defer func() {
+		if e != nil {
+			d.releaseMemory()
+		}
+	}()
+
+	for {
+		d.releaseMemory()
+
+		d.skipped = ValueHandle{}
+
+		e = d.setReadDeadline()
+		if e != nil {
+			return
+		}
+
+		d.head = d.head[:0]
+		d.tail = d.tail[:0]
+
+		x, c, xmv, k, e = d.readXCMK()
+
+		switch {
+		case e != io.EOF:
+
+		case d.batched > 0:
+			e = fmt.Errorf("stream ended before checksum of last batch: %w",
+				io.ErrUnexpectedEOF,
+			)
+
+		case d.unsigned > 0:
+			e = fmt.Errorf("stream ended with unsigned records: %w",
+				io.ErrUnexpectedEOF,
+			)
+
+		case d.inTransaction:
+			e = fmt.Errorf("stream ended within transaction: %w",
+				io.ErrUnexpectedEOF,
+			)
+		}
+
+		if e != nil {
+			return
+		}
+
+		v, e = d.readV(x)
+		if e != nil {
+			e = unexpectedEOF(e)
+
+			return
+		}
+
+		control = isControl(x, k, v)
+
+		lazy = d.lazy(control, c, v)
+
+		if lazy {
+			e = d.reserveMemory(
+				int64(k),
+			)
+		} else {
+			e = d.reserveMemory(
+				int64(k + v),
+			)
+		}
+
+		if e != nil {
+			return
+		}
+
+		key, e = d.readKey(k)
+		if e != nil {
+			e = unexpectedEOF(e)
+
+			return
+		}
+
+		if lazy {
+			e = d.skipVal(v)
+		} else {
+			val, e = d.readVal(v)
+		}
+
+		if e != nil {
+			e = unexpectedEOF(e)
+
+			return
+		}
+
+		e = d.verify(c, control, key, val)
+		if e != nil {
+			e = unexpectedEOF(e)
+
+			return
+		}
+
+		e = d.detectVersion(control)
+		if e != nil {
+			return
+		}
+
+		e = d.digestRecord(control, key, val)
+		if e != nil {
+			return
+		}
+
+		if !control {
+			if !d.options.admitsMeta(xmv) && d.features&featureDedup == 0 {
+				d.snapshotPartial = true
+
+				continue
+			}
+
+			if d.features&featureEncryption != 0 {
+				val, e = d.open(key, val)
+				if e != nil {
+					return
+				}
+			}
+
+			if d.features&featureDedup != 0 {
+				val, e = d.resolve(val)
+				if e != nil {
+					return
+				}
+			}
+
+			if !d.options.admitsMeta(xmv) {
+				// The value is resolved only to keep the reference cache
+				// in step with the Encoder.
+
+				d.snapshotPartial = true
+
+				continue
+			}
+
+			if d.features&featureBlobSpill != 0 &&
+				d.options.blobReferences != nil {
+				// Only the blobs referenced by the stream are wanted.
+
+				return key, nil, xmv, d.referenceBlob(val)
+			}
+
+			if d.features&featureBlobSpill != 0 {
+				val, e = d.unspill(val)
+				if e != nil {
+					return
+				}
+			}
+
+			if d.features&featureCompression != 0 {
+				val, e = d.decompress(val)
+				if e != nil {
+					return
+				}
+			}
+
+			val, e = d.options.transformDecode(key, val)
+			if e != nil {
+				return
+			}
+
+			if d.snapshot != nil {
+				d.snapshot.add(
+					snapshotLeaf(key, val, XMetaValue(xmv)),
+				)
+			}
+
+			val = d.options.redact(key, val)
+
+			return
+		}
+
+		e = d.handleControl(val)
+		if e != nil {
+			return
+		}
+
+		if d.awaitCheckpoint && d.checkpoint != nil {
+			return nil, nil, 0, nil
+		}
+	}
+}
+
+func (d *Decoder) setReadDeadline() (e error) {
+	// Sets the read deadline of the underlying io.Reader for the next record,
+	// if so configured and if supported.
+
+	var (
+		ok     bool
+		reader interface{ SetReadDeadline(time.Time) error }
+	)
+
+	if d.options.ioDeadline <= 0 {
 		return
 	}
 
-	e = d.verifyChecksum(key, val)
+	reader, ok = d.reader.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return
+	}
+
+	e = reader.SetReadDeadline(
+		time.Now().Add(d.options.ioDeadline),
+	)
 	if e != nil {
 		return
 	}
@@ -100,14 +468,19 @@ func (d *Decoder) readXCMK() (x int, c bool, m byte, k int, e error) {
 	//   * K: 9 bits to represent len(key).
 
 	var (
+		b    = make([]byte, 2)
 		xcmk uint16
 	)
 
-	e = binary.Read(d.reader, binary.BigEndian, &xcmk)
+	_, e = io.ReadFull(d.reader, b)
 	if e != nil {
 		return
 	}
 
+	d.head = append(d.head, b...)
+
+	xcmk = binary.BigEndian.Uint16(b)
+
 	x = int(xcmk >> offsetX)
 
 	if x == 0 {
@@ -135,6 +508,8 @@ func (d *Decoder) readV(x int) (v int, e error) {
 		return
 	}
 
+	d.head = append(d.head, b[maxUintLen32-x:]...)
+
 	v = int(binary.BigEndian.Uint32(b))
 
 	return
@@ -166,6 +541,105 @@ func (d *Decoder) readVal(v int) (val []byte, e error) {
 	return
 }
 
+func (d *Decoder) verify(c, control bool, key, val []byte) (e error) {
+	// Verifies the checksum of a record if present, or adds a data record to
+	// the checksum of its batch if the batch checksum feature is in effect.
+	// The header bytes of the record, in d.head, are covered as the features
+	// in effect require.
+
+	var (
+		b     []byte
+		batch = d.features&featureBatchChecksum != 0
+	)
+
+	switch {
+	case batch && !control:
+		d.batched++
+
+		if d.hasher == nil {
+			return
+		}
+
+		for _, b = range [][]byte{d.head, key, val} {
+			_, e = d.hasher.Write(b)
+			if e != nil {
+				return
+			}
+		}
+
+		return
+
+	case !c:
+		return
+
+	case batch || d.hasher == nil:
+		// The checksum cannot be verified without disturbing that of the
+		// batch, or at all.
+
+		_, e = d.readTrailer()
+
+		return
+	}
+
+	if d.features&featureHeaderChecksum != 0 {
+		d.hasher.Reset()
+
+		_, e = d.hasher.Write(d.head)
+		if e != nil {
+			return
+		}
+	}
+
+	e = d.verifyChecksum(key, val)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (d *Decoder) verifyBatch(observed uint32) (e error) {
+	// Verifies the checksum of the batch ending with the preceding record, if
+	// d.hasher is not nil, and starts a new batch.
+
+	defer func() {
+		d.batched = 0
+
+		if d.hasher != nil {
+			d.hasher.Reset()
+		}
+	}()
+
+	if d.hasher == nil {
+		return
+	}
+
+	if d.hasher.Sum32() != observed {
+		return fmt.Errorf("computed batch checksum does not match observed")
+	}
+
+	return
+}
+
+func (d *Decoder) readTrailer() (observed uint32, e error) {
+	// Reads a trailing 32-bit checksum, retaining its bytes in d.tail.
+
+	var (
+		b = make([]byte, crcLen)
+	)
+
+	_, e = io.ReadFull(d.reader, b)
+	if e != nil {
+		return
+	}
+
+	d.tail = append(d.tail, b...)
+
+	observed = binary.BigEndian.Uint32(b)
+
+	return
+}
+
 func (d *Decoder) verifyChecksum(key, val []byte) (e error) {
 	// Reads and verifies a 32-bit checksum of the record if d.hasher is not
 	// nil; discards four bytes otherwise.
@@ -175,14 +649,12 @@ func (d *Decoder) verifyChecksum(key, val []byte) (e error) {
 		observed uint32
 	)
 
-	if d.hasher == nil {
-		_, e = io.CopyN(io.Discard, d.reader, maxUintLen32)
-
+	observed, e = d.readTrailer()
+	if e != nil {
 		return
 	}
 
-	e = binary.Read(d.reader, binary.BigEndian, &observed)
-	if e != nil {
+	if d.hasher == nil {
 		return
 	}
 
@@ -208,3 +680,15 @@ func (d *Decoder) verifyChecksum(key, val []byte) (e error) {
 
 	return
 }
+
+func unexpectedEOF(e error) error {
+	// Returns io.ErrUnexpectedEOF in place of io.EOF, for use where a record
+	// has been received in part, so that a truncated stream is not mistaken
+	// for one that ended cleanly.
+
+	if e == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return e
+}
@@ -5,7 +5,10 @@ import (
 	"hash"
 	"hash/fnv"
 	"io"
+	"net"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -110,6 +113,99 @@ func TestDecoder(t *testing.T) {
 	return
 }
 
+func TestDecoderClose(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+
+		encoder *Encoder = NewEncoder(&buffer, fnv.New32a(),
+			WithBatchChecksum(4),
+		)
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t, encoder.Close())
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), fnv.New32a())
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Error(t, decoder.Close())
+	assert.NoError(t, decoder.Close())
+
+	_, _, e = decoder.Decode()
+
+	assert.Error(t, e)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), fnv.New32a())
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.NoError(t, decoder.Close())
+
+	return
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		rest    []byte
+	)
+
+	assert.NoError(t,
+		NewEncoder(&buffer, fnv.New32a()).Encode([]byte("key"), []byte("val")),
+	)
+
+	buffer.WriteString("trailing")
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	rest, e = io.ReadAll(
+		io.MultiReader(decoder.Buffered(), &buffer),
+	)
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("trailing"), rest)
+
+	return
+}
+
+func TestDecoderWithIODeadline(t *testing.T) {
+	var (
+		reader, writer = net.Pipe()
+
+		decoder *Decoder = NewDecoder(reader, nil,
+			WithIODeadline(time.Millisecond),
+		)
+
+		e error
+	)
+
+	defer writer.Close()
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, os.ErrDeadlineExceeded)
+
+	return
+}
+
 func TestDecoderReadXCMK(t *testing.T) {
 	var (
 		c bool
@@ -0,0 +1,208 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Under the deduplication feature, the value of every data record is preceded
+// by a tag. A value that repeats one still held in the reference cache is
+// replaced by a reference, consisting of the leading bytes of its SHA-256
+// digest. Encoder and Decoder maintain identical caches by admitting the same
+// values in the same order and evicting the oldest first.
+const (
+	dedupLiteral byte = iota // value follows, not to be cached
+	dedupCached              // value follows, to be cached
+	dedupRef                 // reference to a cached value follows
+)
+
+type dedupSum [dedupRefLen]byte
+
+type dedupCache struct {
+	limit  int64
+	size   int64
+	order  []dedupSum
+	lens   map[dedupSum]int
+	values map[dedupSum][]byte // nil at the Encoder, which needs only sums
+}
+
+func newDedupCache(limit int64) *dedupCache {
+	return &dedupCache{
+		limit:  limit,
+		lens:   make(map[dedupSum]int),
+		values: make(map[dedupSum][]byte),
+	}
+}
+
+func (c *dedupCache) admits(val []byte) bool {
+	return len(val) >= dedupMinValLen && int64(len(val)) <= c.limit
+}
+
+func (c *dedupCache) add(sum dedupSum, l int, val []byte) {
+	// Caches a value of length l, which the cache must admit, evicting the
+	// oldest values as necessary. The value itself is retained unless nil.
+
+	var (
+		oldest dedupSum
+	)
+
+	c.order = append(c.order, sum)
+	c.lens[sum] = l
+	c.size += int64(l)
+
+	if val != nil {
+		c.values[sum] = val
+	}
+
+	for c.size > c.limit {
+		oldest, c.order = c.order[0], c.order[1:]
+
+		c.size -= int64(c.lens[oldest])
+
+		delete(c.lens, oldest)
+		delete(c.values, oldest)
+	}
+}
+
+func newDedupSum(val []byte) (sum dedupSum) {
+	var (
+		digest = sha256.Sum256(val)
+	)
+
+	copy(sum[:], digest[:])
+
+	return
+}
+
+func (n *Encoder) dedup(val []byte) []byte {
+	// Returns val tagged, or replaced by a reference if cached. The caller
+	// must hold n.mutex.
+
+	var (
+		ok  bool
+		sum dedupSum
+	)
+
+	if n.dedupCache == nil {
+		return val
+	}
+
+	if !n.dedupCache.admits(val) {
+		return append([]byte{dedupLiteral}, val...)
+	}
+
+	sum = newDedupSum(val)
+
+	_, ok = n.dedupCache.lens[sum]
+	if ok {
+		return append([]byte{dedupRef}, sum[:]...)
+	}
+
+	n.dedupCache.add(sum, len(val), nil)
+
+	return append([]byte{dedupCached}, val...)
+}
+
+func (n *Encoder) dedupFrom(val io.Reader, size int64) (io.Reader, int64) {
+	// Returns a value to be read from val tagged as not to be cached, since
+	// it cannot be looked up without being read in full. The caller must hold
+	// n.mutex.
+
+	if n.dedupCache == nil {
+		return val, size
+	}
+
+	return io.MultiReader(bytes.NewReader([]byte{dedupLiteral}), val),
+		size + 1
+}
+
+func (n *Encoder) beginDedup() (e error) {
+	// Announces the limit of the reference cache, and creates the cache, if
+	// deduplication is configured and yet to begin. The caller must hold
+	// n.mutex.
+
+	if n.features&featureDedup == 0 || n.dedupCache != nil {
+		return
+	}
+
+	e = n.writeControl(controlDedup,
+		binary.BigEndian.AppendUint64(nil,
+			uint64(n.options.dedupLimit),
+		),
+	)
+	if e != nil {
+		return
+	}
+
+	n.dedupCache = newDedupCache(n.options.dedupLimit)
+
+	return
+}
+
+func (d *Decoder) receiveDedup(payload []byte) (e error) {
+	// Creates the reference cache of the size announced in a control record.
+
+	if len(payload) != 8 {
+		return fmt.Errorf("malformed deduplication control record")
+	}
+
+	d.dedupCache = newDedupCache(
+		int64(binary.BigEndian.Uint64(payload)),
+	)
+
+	return
+}
+
+func (d *Decoder) resolve(val []byte) (resolved []byte, e error) {
+	// Returns the value that a tagged value or reference stands for.
+
+	var (
+		ok  bool
+		sum dedupSum
+	)
+
+	if d.dedupCache == nil {
+		return nil, fmt.Errorf("deduplicated record precedes reference cache")
+	}
+
+	if len(val) == 0 {
+		return nil, fmt.Errorf("deduplication tag missing")
+	}
+
+	switch val[0] {
+	case dedupLiteral:
+		return val[1:], nil
+
+	case dedupCached:
+		resolved = val[1:]
+
+		if !d.dedupCache.admits(resolved) {
+			return nil, fmt.Errorf("value not admissible to reference cache")
+		}
+
+		d.dedupCache.add(newDedupSum(resolved), len(resolved),
+			bytes.Clone(resolved),
+		)
+
+		return
+
+	case dedupRef:
+		if len(val) != 1+dedupRefLen {
+			return nil, fmt.Errorf("malformed value reference")
+		}
+
+		copy(sum[:], val[1:])
+
+		resolved, ok = d.dedupCache.values[sum]
+		if !ok {
+			return nil, fmt.Errorf("reference to value not in cache")
+		}
+
+		return bytes.Clone(resolved), nil
+	}
+
+	return nil, fmt.Errorf("unknown deduplication tag %d", val[0])
+}
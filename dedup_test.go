@@ -0,0 +1,117 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplication(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		i       int
+		kek     cipher.AEAD
+		key     []byte
+		large   = [][]byte{
+			bytes.Repeat([]byte{'a'}, 1000),
+			bytes.Repeat([]byte{'b'}, 1000),
+			bytes.Repeat([]byte{'c'}, 1000),
+		}
+		small = []byte("small")
+		val   []byte
+	)
+
+	for _, kek = range []cipher.AEAD{nil, newTestAEAD(t, "kek")} {
+		buffer.Reset()
+
+		if kek == nil {
+			encoder = NewEncoder(&buffer, fnv.New32a(),
+				WithDeduplication(2000),
+			)
+		} else {
+			encoder = NewEncoder(&buffer, fnv.New32a(),
+				WithDeduplication(2000),
+				WithEncryption("kek", kek),
+			)
+		}
+
+		for i = 0; i < 100; i++ {
+			assert.NoError(t,
+				encoder.Encode([]byte(fmt.Sprint(i)), large[i%2]),
+			)
+
+			assert.NoError(t,
+				encoder.Encode([]byte(fmt.Sprint(i)), small),
+			)
+		}
+
+		if kek == nil {
+			assert.Less(t, buffer.Len(), 2*len(large[0])+200*30)
+		}
+
+		// Caching the third value evicts the first, which must then be
+		// transmitted again in full.
+
+		assert.NoError(t,
+			encoder.Encode([]byte("c"), large[2]),
+		)
+
+		assert.NoError(t,
+			encoder.Encode([]byte("a"), large[0]),
+		)
+
+		assert.NoError(t,
+			encoder.EncodeFrom([]byte("b"), bytes.NewReader(large[1]), 1000),
+		)
+
+		decoder = NewDecoder(&buffer, fnv.New32a(),
+			WithDecryption(
+				func(string) (cipher.AEAD, error) {
+					return kek, nil
+				},
+			),
+		)
+
+		for i = 0; i < 100; i++ {
+			key, val, e = decoder.Decode()
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			assert.Equal(t, []byte(fmt.Sprint(i)), key)
+			assert.Equal(t, large[i%2], val)
+
+			val[0] = 'x' // must not corrupt the cache
+
+			_, val, e = decoder.Decode()
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			assert.Equal(t, small, val)
+		}
+
+		for _, i = range []int{2, 0, 1} {
+			_, val, e = decoder.Decode()
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			assert.Equal(t, large[i], val)
+		}
+
+		_, _, e = decoder.Decode()
+
+		assert.ErrorIs(t, e, io.EOF)
+	}
+
+	return
+}
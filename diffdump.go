@@ -0,0 +1,231 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A StoreSnapshot is a consistent view of an ordered key-value store, such as
+// an LMDB read-only transaction and a cursor on a database of it. Keys must
+// sort as by [bytes.Compare], as in an LMDB database without a custom
+// comparator, unless the snapshot has a method Flags() DatabaseFlags, in which
+// case DiffDump sorts them as by the Compare of its flags, as for a database
+// opened with MDB_REVERSEKEY. The slices returned need only remain valid until
+// the next call.
+type StoreSnapshot interface {
+	// Seek returns the first record whose key is not less than key, the
+	// first of all if key is nil, and Next the record after the last
+	// returned. Both return io.EOF past the last record.
+	Seek(key []byte) (k, v []byte, e error)
+	Next() (k, v []byte, e error)
+
+	// Close ends the snapshot, as by aborting the transaction.
+	Close() error
+}
+
+// A DiffDump dumps a snapshot of an ordered key-value store, such as an LMDB
+// database, as an incremental of the previous dump: only the records put or
+// changed since, and tombstones of those deleted, records whose extended
+// metadata value carries XMetaFlagTombstone, which a Loader applies as
+// deletions. It needs not the previous snapshot but only its index, a sidecar
+// stream of the keys of the snapshot, in order, each with the SHA-256 digest of
+// its value, which every dump writes for the next.
+//
+// Applied in order with a Loader, a full dump and its incrementals reconstruct
+// the store as of the last.
+type DiffDump struct {
+	// Open opens the snapshot to dump.
+	Open func() (StoreSnapshot, error)
+
+	// Previous, if not nil, decodes the index of the previous dump; if nil,
+	// the dump is full.
+	Previous *Decoder
+
+	// Index, if not nil, encodes the index of this dump. It is neither
+	// closed nor aborted.
+	Index *Encoder
+}
+
+// Run dumps the snapshot to the Encoder, and returns the number of records and
+// tombstones encoded. It neither closes nor aborts the Encoder.
+func (d DiffDump) Run(encoder *Encoder) (changes int64, e error) {
+	defer errorf("could not dump differences", &e)
+
+	var (
+		digest   [sha256.Size]byte
+		flags    DatabaseFlags
+		ki       []byte
+		ks       []byte
+		previous []byte
+		s        StoreSnapshot
+		vi       []byte
+		vs       []byte
+	)
+
+	s, e = d.Open()
+	if e != nil {
+		return
+	}
+
+	defer s.Close()
+
+	flags = snapshotFlags(s)
+
+	ks, vs, e = snapshotRecord(
+		s.Seek(nil),
+	)
+	if e != nil {
+		return
+	}
+
+	ki, vi, e = d.nextIndexed(flags, nil)
+	if e != nil {
+		return
+	}
+
+	for ks != nil || ki != nil {
+		switch {
+		case ki == nil || ks != nil && flags.Compare(ks, ki) < 0:
+			digest = sha256.Sum256(vs)
+
+			e = d.put(encoder, ks, vs, digest)
+			if e != nil {
+				return
+			}
+
+			changes++
+
+			ks, vs, e = snapshotRecord(
+				s.Next(),
+			)
+
+		case ks == nil || flags.Compare(ki, ks) < 0:
+			e = encoder.EncodeX(ki, nil,
+				NewXMetaValue(0, XMetaFlagTombstone),
+			)
+			if e != nil {
+				return
+			}
+
+			changes++
+
+			previous = bytes.Clone(ki)
+
+			ki, vi, e = d.nextIndexed(flags, previous)
+
+		default:
+			digest = sha256.Sum256(vs)
+
+			switch {
+			case bytes.Equal(vi, digest[:]):
+				e = d.index(ks, digest)
+
+			default:
+				e = d.put(encoder, ks, vs, digest)
+
+				changes++
+			}
+
+			if e != nil {
+				return
+			}
+
+			previous = bytes.Clone(ki)
+
+			ki, vi, e = d.nextIndexed(flags, previous)
+			if e != nil {
+				return
+			}
+
+			ks, vs, e = snapshotRecord(
+				s.Next(),
+			)
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (d DiffDump) nextIndexed(flags DatabaseFlags, previous []byte) (
+	key, digest []byte, e error,
+) {
+	// Returns the next entry of the previous index, a nil key past the last,
+	// verifying that the keys are ordered as by the flags.
+
+	if d.Previous == nil {
+		return
+	}
+
+	key, digest, e = d.Previous.Decode()
+
+	switch {
+	case errors.Is(e, io.EOF):
+		return nil, nil, nil
+
+	case e != nil:
+		return nil, nil, e
+
+	case len(digest) != sha256.Size:
+		return nil, nil, fmt.Errorf("malformed index entry of key %x", key)
+
+	case previous != nil && flags.Compare(key, previous) <= 0:
+		return nil, nil, fmt.Errorf("index out of order at key %x", key)
+	}
+
+	return
+}
+
+func (d DiffDump) put(encoder *Encoder, key, val []byte,
+	digest [sha256.Size]byte,
+) (e error) {
+	// Encodes a record put or changed since the previous dump, and indexes
+	// it.
+
+	e = encoder.Encode(key, val)
+	if e != nil {
+		return
+	}
+
+	return d.index(key, digest)
+}
+
+func (d DiffDump) index(key []byte, digest [sha256.Size]byte) (e error) {
+	if d.Index == nil {
+		return
+	}
+
+	return d.Index.Encode(key, digest[:])
+}
+
+func snapshotRecord(k, v []byte, e error) ([]byte, []byte, error) {
+	// Returns the record read from a snapshot, with a nil key past the last.
+
+	if errors.Is(e, io.EOF) {
+		return nil, nil, nil
+	}
+
+	return k, v, e
+}
+
+func snapshotFlags(s StoreSnapshot) (flags DatabaseFlags) {
+	// Returns the flags of the database of the snapshot, if it has them.
+
+	var (
+		ok       bool
+		snapshot interface{ Flags() DatabaseFlags }
+	)
+
+	snapshot, ok = s.(interface{ Flags() DatabaseFlags })
+	if ok {
+		flags = snapshot.Flags()
+	}
+
+	return
+}
@@ -0,0 +1,275 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A fakeSnapshot is a StoreSnapshot of a map, counting its seeks past the
+// first key if seeks is not nil.
+type fakeSnapshot struct {
+	keys  []string
+	vals  map[string]string
+	i     int
+	seeks *int
+}
+
+func (s *fakeSnapshot) Seek(key []byte) (k, v []byte, e error) {
+	if key != nil && s.seeks != nil {
+		*s.seeks++
+	}
+
+	s.i, _ = slices.BinarySearch(s.keys, string(key))
+
+	return s.record()
+}
+
+func (s *fakeSnapshot) Next() (k, v []byte, e error) {
+	s.i++
+
+	return s.record()
+}
+
+func (s *fakeSnapshot) record() (k, v []byte, e error) {
+	if s.i >= len(s.keys) {
+		return nil, nil, io.EOF
+	}
+
+	return []byte(s.keys[s.i]), []byte(s.vals[s.keys[s.i]]), nil
+}
+
+func (s *fakeSnapshot) Close() error {
+	return nil
+}
+
+func TestDiffDump(t *testing.T) {
+	var (
+		db      = make(map[string]string)
+		dumps   [2]bytes.Buffer
+		indices [2]bytes.Buffer
+		dump    DiffDump
+		e       error
+		encoder *Encoder
+		i       int
+		n       int64
+		records []string
+		store   = map[string]string{"a": "1", "b": "1", "c": "1"}
+	)
+
+	dump.Open = func() (StoreSnapshot, error) {
+		var (
+			key  string
+			keys []string
+		)
+
+		for key = range store {
+			keys = append(keys, key)
+		}
+
+		slices.Sort(keys)
+
+		return &fakeSnapshot{
+			keys: keys,
+			vals: maps.Clone(store),
+		}, nil
+	}
+
+	for i = range dumps {
+		encoder = NewEncoder(&dumps[i], nil)
+
+		dump.Index = NewEncoder(&indices[i], nil)
+
+		if i > 0 {
+			dump.Previous = NewDecoder(
+				bytes.NewReader(indices[i-1].Bytes()), nil,
+			)
+		}
+
+		n, e = dump.Run(encoder)
+		assert.NoError(t, e)
+		assert.Equal(t, []int64{3, 3}[i], n)
+
+		assert.NoError(t,
+			encoder.Close(),
+		)
+		assert.NoError(t,
+			dump.Index.Close(),
+		)
+
+		store["b"] = "2"
+		store["d"] = "1"
+
+		delete(store, "c")
+	}
+
+	records = diffDumpRecords(t, dumps[1].Bytes())
+
+	assert.Equal(t,
+		[]string{"b=2", "c deleted", "d=1"},
+		records,
+	)
+
+	// The full dump and the incremental, applied in order, reconstruct the
+	// store as of the second.
+
+	for i = range dumps {
+		_, e = Loader{
+			Begin: func() (LoadTxn, error) {
+				return newMapTxn(db), nil
+			},
+		}.Load(
+			NewDecoder(bytes.NewReader(dumps[i].Bytes()), nil),
+		)
+		assert.NoError(t, e)
+	}
+
+	assert.Equal(t,
+		map[string]string{"a": "1", "b": "2", "d": "1"},
+		db,
+	)
+
+	// The index of the second dump covers the unchanged record too.
+
+	assert.Equal(t,
+		[]string{"a", "b", "d"},
+		diffDumpKeys(t, indices[1].Bytes()),
+	)
+
+	return
+}
+
+// A reverseSnapshot is a fakeSnapshot of a database opened with
+// MDB_REVERSEKEY, whose keys must be given in the order of its comparator.
+type reverseSnapshot struct {
+	*fakeSnapshot
+}
+
+func (reverseSnapshot) Flags() DatabaseFlags {
+	return DatabaseReverseKey
+}
+
+func TestDiffDumpReverseKey(t *testing.T) {
+	var (
+		dump    DiffDump
+		e       error
+		encoder *Encoder
+		index   bytes.Buffer
+		stream  bytes.Buffer
+		store   = map[string]string{"ba": "1", "ca": "1", "ab": "1"}
+	)
+
+	dump = DiffDump{
+		Open: func() (StoreSnapshot, error) {
+			var (
+				key  string
+				keys []string
+			)
+
+			for key = range store {
+				keys = append(keys, key)
+			}
+
+			slices.SortFunc(keys,
+				func(a, b string) int {
+					return DatabaseReverseKey.Compare([]byte(a), []byte(b))
+				},
+			)
+
+			return reverseSnapshot{
+				&fakeSnapshot{keys: keys, vals: maps.Clone(store)},
+			}, nil
+		},
+		Index: NewEncoder(&index, nil),
+	}
+
+	_, e = dump.Run(
+		NewEncoder(io.Discard, nil),
+	)
+	assert.NoError(t, e)
+
+	assert.NoError(t,
+		dump.Index.Close(),
+	)
+
+	store["ab"] = "2"
+
+	delete(store, "ca")
+
+	// Merged in the order of the comparator, rather than of bytes, the
+	// snapshot and the index of the previous dump yield only the changes.
+
+	dump.Previous = NewDecoder(bytes.NewReader(index.Bytes()), nil)
+	dump.Index = nil
+
+	encoder = NewEncoder(&stream, nil)
+
+	_, e = dump.Run(encoder)
+	assert.NoError(t, e)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.Equal(t,
+		[]string{"ca deleted", "ab=2"},
+		diffDumpRecords(t, stream.Bytes()),
+	)
+
+	return
+}
+
+func diffDumpRecords(t *testing.T, stream []byte) (records []string) {
+	var (
+		decoder = NewDecoder(bytes.NewReader(stream), nil)
+		e       error
+		key     []byte
+		val     []byte
+		xmv     byte
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		if XMetaValue(xmv).HasFlag(XMetaFlagTombstone) {
+			records = append(records, string(key)+" deleted")
+
+			continue
+		}
+
+		records = append(records, string(key)+"="+string(val))
+	}
+
+	return
+}
+
+func diffDumpKeys(t *testing.T, stream []byte) (keys []string) {
+	var (
+		decoder = NewDecoder(bytes.NewReader(stream), nil)
+		e       error
+		key     []byte
+	)
+
+	for {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		keys = append(keys, string(key))
+	}
+
+	return
+}
@@ -1,11 +1,15 @@
 package bottledlightning
 
 import (
+	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"hash"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // An Encoder is modelled after [encoding/gob.Encoder] from the Go standard
@@ -27,20 +31,212 @@ import (
 //   - 1 bit to indicate the presence of a trailing 32-bit checksum, and
 //   - 4 bits for extended metadata---see defined constants.
 //
+// Depending on its options, an Encoder may interleave control records, which
+// carry information about the stream rather than data, with the records it is
+// asked to transmit. Decoders consume control records transparently.
+//
 // Encoders are safe for concurrent use by multiple goroutines.
 type Encoder struct {
-	writer io.Writer
-	hasher hash.Hash32
-	mutex  sync.Mutex
+	dest    io.Writer
+	writer  io.Writer
+	mirror  *mirror
+	aligner *countingWriter
+	hasher  hash.Hash32
+	mutex   sync.Mutex
+	options options
+	source  string
+
+	features         uint32
+	schemaSent       bool
+	checksumSent     bool
+	dedupCache       *dedupCache
+	compressionStats CompressionStats
+	announcedSetting CompressionSetting
+	settingAnnounced bool
+	recordStats      RecordStats
+	sizes            sizeWindow
+	auditStats       AuditStats
+	lastKey          []byte
+	lastKeys         map[string][]byte // by source
+	sourceFlags      map[string]DatabaseFlags
+	comparators      map[string]string // by source
+	unmarked         int
+	batched          int
+	digest           hash.Hash
+	snapshot         *merkleTree
+	snapshotID       []byte
+	dataKey          cipher.AEAD
+	dataKeyUses      uint64
+	lastWrite        time.Time
+	lastSync         time.Time
+	unsynced         int
+	done             chan struct{}
+	closing          sync.Once
+	closed           bool
+	workers          sync.WaitGroup
+	asyncMutex       sync.Mutex
+	asyncQueue       chan asyncRecord
+	asyncClosed      bool
+	asyncSenders     sync.WaitGroup
+	asyncSubmitted   atomic.Int64
+	asyncRejected    atomic.Int64
+	asyncHighWater   atomic.Int64
+	mirrorMutex      sync.Mutex
+	transaction      bool
 }
 
 // NewEncoder returns a new encoder that will transmit on the [io.Writer], and
 // optionally append a 32-bit checksum to every record if the [hash.Hash32] is
-// not nil.
-func NewEncoder(writer io.Writer, hasher hash.Hash32) (n *Encoder) {
+// not nil. NewCRC32C returns the recommended one.
+func NewEncoder(writer io.Writer, hasher hash.Hash32, opts ...Option) (
+	n *Encoder,
+) {
 	n = &Encoder{
-		writer: writer,
-		hasher: hasher,
+		dest:    writer,
+		writer:  writer,
+		hasher:  hasher,
+		options: newOptions(opts),
+		done:    make(chan struct{}),
+	}
+
+	if hasher == nil {
+		n.options.features &^= featureHeaderChecksum | featureBatchChecksum
+	}
+
+	n.options.restrictToPeer()
+
+	if len(n.options.mirrors) > 0 {
+		n.mirror = newMirror(
+			append([]io.Writer{writer}, n.options.mirrors...)...,
+		)
+
+		n.dest = n.mirror
+		n.writer = n.mirror
+	}
+
+	if n.options.signingKey != nil {
+		n.digest = sha256.New()
+
+		n.writer = io.MultiWriter(n.dest, n.digest)
+	}
+
+	if n.options.adaptiveInterval > 0 &&
+		n.options.features&featureCompression != 0 {
+		n.options.adaptive = newAdaptiveCompression(
+			n.options.adaptiveInterval, &n.options,
+		)
+
+		n.writer = &meteredWriter{
+			writer:   n.writer,
+			adaptive: n.options.adaptive,
+		}
+	}
+
+	if n.options.batchAlignment > 0 &&
+		n.options.features&featureBatchChecksum != 0 {
+		n.aligner = &countingWriter{
+			writer: n.writer,
+		}
+
+		n.writer = n.aligner
+	}
+
+	if n.options.snapshotID {
+		n.snapshot = new(merkleTree)
+	}
+
+	if n.options.keepaliveInterval > 0 {
+		n.lastWrite = time.Now()
+
+		n.workers.Add(1)
+
+		go n.keepalive()
+	}
+
+	n.lastSync = time.Now()
+
+	return
+}
+
+// Close stops any background activity of the Encoder, such as the
+// transmission of keepalive records, and waits for it to finish, after
+// transmitting any records queued by EncodeAsync. It then
+// transmits any trailers that the stream requires, namely the checksum of an
+// unfinished batch and the signature of the stream. If a sync policy is in
+// effect, Close then commits the stream to stable storage as would Sync. It
+// does not close the underlying [io.Writer].
+//
+// Close fails while a transaction begun by BeginTransaction is not
+// committed, leaving the stream open to CommitTransaction or Abort.
+//
+// The Encoder cannot be used after Close, which does nothing if called again.
+func (n *Encoder) Close() (e error) {
+	n.closing.Do(
+		func() { close(n.done) },
+	)
+
+	n.closeAsync()
+
+	n.workers.Wait()
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if n.closed {
+		return
+	}
+
+	// Trailers would tear the transaction; the caller may still commit it,
+	// or abort the stream.
+
+	if n.transaction {
+		return fmt.Errorf("could not close: transaction not committed")
+	}
+
+	defer func() { n.closed = true }()
+
+	if n.batched > 0 {
+		e = n.begin()
+		if e != nil {
+			return
+		}
+
+		e = n.endBatch()
+		if e != nil {
+			return
+		}
+	}
+
+	if n.snapshot != nil {
+		e = n.begin()
+		if e != nil {
+			return
+		}
+
+		e = n.writeSnapshotID()
+		if e != nil {
+			return
+		}
+	}
+
+	if n.digest != nil {
+		e = n.begin()
+		if e != nil {
+			return
+		}
+
+		e = n.writeSignature()
+		if e != nil {
+			return
+		}
+	}
+
+	if n.options.syncEvery > 0 || n.options.syncInterval > 0 {
+		e = n.sync()
+		if e != nil {
+			return
+		}
 	}
 
 	return
@@ -51,17 +247,77 @@ func (n *Encoder) Encode(key, val []byte) error {
 	return n.encode(key, val, XMetaValue0)
 }
 
-// EncodeX transmits a key-value record with extended metadata.
-func (n *Encoder) EncodeX(key, val []byte, xmv xMetaValue) error {
+// EncodeX transmits a key-value record with extended metadata, which must not
+// exceed XMetaValueF.
+func (n *Encoder) EncodeX(key, val []byte, xmv XMetaValue) error {
 	return n.encode(key, val, xmv)
 }
 
-func (n *Encoder) encode(key, val []byte, xmv xMetaValue) (e error) {
+// EncodeFrom transmits a key-value record whose value, of the given size, is
+// read from the [io.Reader]. Unless the Encoder appends checksums, the value is
+// copied by [io.Copy], which allows the operating system to transfer it from
+// an [os.File] to a [net.TCPConn] or another [os.File] without passing it
+// through user space.
+func (n *Encoder) EncodeFrom(key []byte, val io.Reader, size int64) error {
+	return n.encodeFrom(key, val, size, XMetaValue0)
+}
+
+// EncodeFromX is a variant of EncodeFrom that transmits extended metadata.
+func (n *Encoder) EncodeFromX(key []byte, val io.Reader, size int64,
+	xmv XMetaValue,
+) error {
+	return n.encodeFrom(key, val, size, xmv)
+}
+
+func (n *Encoder) encode(key, val []byte, xmv XMetaValue) (e error) {
 	// Transmits a key-value record with extended metadata.
 
 	defer errorf("could not encode record", &e)
 
-	e = n.validateLens(key, val)
+	var (
+		compression Compression
+		leaf        []byte
+		submitted   = int64(len(val))
+	)
+
+	defer n.countRecord(len(key), submitted, &e)
+
+	e = xmv.validate()
+	if e != nil {
+		return
+	}
+
+	e = n.options.profile.validate(key,
+		int64(len(val)),
+	)
+	if e != nil {
+		return
+	}
+
+	val = n.options.redact(key, val)
+
+	if n.snapshot != nil {
+		leaf = snapshotLeaf(key, val, xmv)
+	}
+
+	val, e = n.options.transformEncode(key, val)
+	if e != nil {
+		return
+	}
+
+	val, compression, e = n.options.compress(key, val)
+	if e != nil {
+		return
+	}
+
+	val, e = n.options.spill(val)
+	if e != nil {
+		return
+	}
+
+	e = n.validateLens(len(key),
+		int64(len(val)),
+	)
 	if e != nil {
 		return
 	}
@@ -70,12 +326,48 @@ func (n *Encoder) encode(key, val []byte, xmv xMetaValue) (e error) {
 
 	defer n.mutex.Unlock()
 
-	e = n.writeXCMK(key, val, xmv)
+	e = n.orderKey(key)
+	if e != nil {
+		return
+	}
+
+	e = n.options.audit(&n.auditStats, OpEncode, key, xmv)
+	if e != nil {
+		return
+	}
+
+	e = n.begin()
 	if e != nil {
 		return
 	}
 
-	e = n.writeV(val)
+	e = n.announceCompression()
+	if e != nil {
+		return
+	}
+
+	e = n.markSeek()
+	if e != nil {
+		return
+	}
+
+	val = n.dedup(val)
+
+	if n.dataKey != nil {
+		val, e = n.seal(key, val)
+		if e != nil {
+			return
+		}
+	}
+
+	e = n.writeXCMK(len(key), len(val), xmv)
+	if e != nil {
+		return
+	}
+
+	e = n.writeV(
+		len(val),
+	)
 	if e != nil {
 		return
 	}
@@ -90,11 +382,160 @@ func (n *Encoder) encode(key, val []byte, xmv xMetaValue) (e error) {
 		return
 	}
 
-	if n.hasher == nil {
+	switch {
+	case n.features&featureBatchChecksum != 0:
+		e = n.batchRecord(key, val)
+		if e != nil {
+			return
+		}
+
+	case n.hasher != nil:
+		e = n.writeChecksum(key, val)
+		if e != nil {
+			return
+		}
+	}
+
+	if n.snapshot != nil {
+		n.snapshot.add(leaf)
+	}
+
+	n.countCompression(compression)
+
+	e = n.syncByPolicy()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) encodeFrom(key []byte, val io.Reader, size int64,
+	xmv XMetaValue,
+) (
+	e error,
+) {
+	// Transmits a key-value record with extended metadata, reading the value
+	// from val.
+
+	defer errorf("could not encode record", &e)
+
+	var (
+		leaf      hash.Hash
+		submitted = size
+	)
+
+	defer n.countRecord(len(key), submitted, &e)
+
+	e = xmv.validate()
+	if e != nil {
+		return
+	}
+
+	e = n.options.profile.validate(key, size)
+	if e != nil {
+		return
+	}
+
+	val, size, e = n.options.redactFrom(key, val, size)
+	if e != nil {
+		return
+	}
+
+	if n.snapshot != nil {
+		leaf = newSnapshotLeaf(key, xmv, size)
+
+		val = io.TeeReader(val, leaf)
+	}
+
+	val, size, e = n.options.transformEncodeFrom(key, val, size)
+	if e != nil {
+		return
+	}
+
+	val, size = n.options.compressFrom(val, size)
+
+	val, size, e = n.options.spillFrom(val, size)
+	if e != nil {
 		return
 	}
 
-	e = n.writeChecksum(key, val)
+	e = n.validateLens(len(key), size)
+	if e != nil {
+		return
+	}
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	e = n.orderKey(key)
+	if e != nil {
+		return
+	}
+
+	e = n.options.audit(&n.auditStats, OpEncode, key, xmv)
+	if e != nil {
+		return
+	}
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	e = n.markSeek()
+	if e != nil {
+		return
+	}
+
+	val, size = n.dedupFrom(val, size)
+
+	if n.dataKey != nil {
+		val, size, e = n.sealFrom(key, val, size)
+		if e != nil {
+			return
+		}
+	}
+
+	e = n.writeXCMK(len(key), int(size), xmv)
+	if e != nil {
+		return
+	}
+
+	e = n.writeV(
+		int(size),
+	)
+	if e != nil {
+		return
+	}
+
+	e = n.writeKey(key)
+	if e != nil {
+		return
+	}
+
+	e = n.writeValFrom(key, val, size)
+	if e != nil {
+		return
+	}
+
+	if n.features&featureBatchChecksum != 0 {
+		e = n.countBatch()
+		if e != nil {
+			return
+		}
+	}
+
+	if n.snapshot != nil {
+		n.snapshot.add(
+			leaf.Sum(nil),
+		)
+	}
+
+	n.countCompression(CompressionNone)
+
+	e = n.syncByPolicy()
 	if e != nil {
 		return
 	}
@@ -102,28 +543,110 @@ func (n *Encoder) encode(key, val []byte, xmv xMetaValue) (e error) {
 	return
 }
 
-func (n *Encoder) validateLens(key, val []byte) error {
-	// Returns a descriptive error if either length of key or val exceeds the
-	// respective thresholds set by LMDB, or nil otherwise.
+func (n *Encoder) validateLens(k int, v int64) error {
+	// Returns a descriptive error if either key length k or value length v
+	// exceeds the respective thresholds set by LMDB, or nil otherwise.
 
-	if len(key) > lmdbMaxKeyLen {
+	if k > lmdbMaxKeyLen {
 		return fmt.Errorf("LMDB maximum key length (511 B) exceeded")
 	}
 
-	if len(val) > lmdbMaxValLen {
+	if v > lmdbMaxValLen {
 		return fmt.Errorf("LMDB maximum value length (4 GiB) exceeded")
 	}
 
 	return nil
 }
 
-func (n *Encoder) writeXCMK(key, val []byte, xmv xMetaValue) (e error) {
+func (n *Encoder) begin() (e error) {
+	// Prepares for the transmission of a record, announcing the features and
+	// schema of the stream beforehand if they are yet to be announced. The
+	// caller must hold n.mutex.
+
+	if n.closed {
+		return fmt.Errorf("encoder closed")
+	}
+
+	if n.options.keepaliveInterval > 0 {
+		n.lastWrite = time.Now()
+	}
+
+	e = n.setWriteDeadline()
+	if e != nil {
+		return
+	}
+
+	if n.features != n.options.features {
+		e = n.writeControl(controlFeatures,
+			binary.BigEndian.AppendUint32(nil, n.options.features),
+		)
+		if e != nil {
+			return
+		}
+
+		n.features = n.options.features
+	}
+
+	e = n.announceChecksum()
+	if e != nil {
+		return
+	}
+
+	e = n.writeSchema()
+	if e != nil {
+		return
+	}
+
+	e = n.beginDedup()
+	if e != nil {
+		return
+	}
+
+	if n.options.kek != nil && n.dataKey == nil {
+		e = n.rotateKey(n.options.kekID, n.options.kek)
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (n *Encoder) setWriteDeadline() (e error) {
+	// Sets the write deadline of the underlying io.Writer for the next record,
+	// if so configured and if supported.
+
+	var (
+		ok     bool
+		writer interface{ SetWriteDeadline(time.Time) error }
+	)
+
+	if n.options.ioDeadline <= 0 {
+		return
+	}
+
+	writer, ok = n.dest.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return
+	}
+
+	e = writer.SetWriteDeadline(
+		time.Now().Add(n.options.ioDeadline),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) writeXCMK(k, v int, xmv XMetaValue) (e error) {
 	// Writes the first two bytes, consisting of the following bit fields:
 	//   * X: 2 bits to encode the value of x, so that 1 <= x <= 4 represents
-	//     len(val),
+	//     value length v,
 	//   * C: 1 bit to indicate the presence of a trailing 32-bit checksum,
 	//   * M: 4 bits for extended metadata, and
-	//   * K: 9 bits to represent len(key).
+	//   * K: 9 bits to represent key length k.
 	//
 	//  1           0
 	//  5 4 3 2 1 0 9 8 7 6 5 4 3 2 1 0
@@ -132,18 +655,25 @@ func (n *Encoder) writeXCMK(key, val []byte, xmv xMetaValue) (e error) {
 	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 
 	var (
-		x = uint16(findX(val)%4) << offsetX
+		x = uint16(findX(v)%4) << offsetX
 		// 1: 0b01, 2: 0b10, 3: 0b11, 4: 0b00
 		c = uint16(1) << offsetC
 		m = uint16(xmv) << offsetM
-		k = uint16(len(key))
 	)
 
-	if n.hasher == nil {
+	e = xmv.validate()
+	if e != nil {
+		return
+	}
+
+	if n.hasher == nil || n.features&featureBatchChecksum != 0 {
 		c = 0
 	}
 
-	e = binary.Write(n.writer, binary.BigEndian, x|c|m|k)
+	e = n.writeHeader(
+		binary.BigEndian.AppendUint16(nil, x|c|m|uint16(k)),
+		true,
+	)
 	if e != nil {
 		return
 	}
@@ -151,18 +681,48 @@ func (n *Encoder) writeXCMK(key, val []byte, xmv xMetaValue) (e error) {
 	return
 }
 
-func (n *Encoder) writeV(val []byte) (e error) {
-	// Writes one to four bytes representing len(val).
+func (n *Encoder) writeV(v int) (e error) {
+	// Writes one to four bytes representing value length v.
 
 	var (
 		b = make([]byte, maxUintLen32)
 	)
 
 	binary.BigEndian.PutUint32(b,
-		uint32(len(val)),
+		uint32(v),
 	)
 
-	_, e = n.writer.Write(b[maxUintLen32-findX(val):])
+	e = n.writeHeader(b[maxUintLen32-findX(v):], false)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) writeHeader(b []byte, first bool) (e error) {
+	// Writes header bytes b of a data record, adding them to the checksum of
+	// the batch or the record if the respective feature is in effect. The first
+	// header bytes of a record start the checksum of the record afresh.
+
+	_, e = n.writer.Write(b)
+	if e != nil {
+		return
+	}
+
+	switch {
+	case n.features&featureBatchChecksum != 0:
+
+	case n.features&featureHeaderChecksum != 0:
+		if first {
+			n.hasher.Reset()
+		}
+
+	default:
+		return
+	}
+
+	_, e = n.hasher.Write(b)
 	if e != nil {
 		return
 	}
@@ -192,6 +752,50 @@ func (n *Encoder) writeVal(val []byte) (e error) {
 	return
 }
 
+func (n *Encoder) writeValFrom(key []byte, val io.Reader, size int64) (
+	e error,
+) {
+	// Copies the uninterpreted value from val, followed by a 32-bit checksum
+	// of the record if n.hasher is not nil and there is no checksum per batch
+	// instead.
+
+	var (
+		batch  = n.features&featureBatchChecksum != 0
+		writer = n.writer
+	)
+
+	if n.hasher != nil {
+		if !batch {
+			defer n.hasher.Reset()
+		}
+
+		_, e = n.hasher.Write(key)
+		if e != nil {
+			return
+		}
+
+		writer = io.MultiWriter(n.writer, n.hasher)
+	}
+
+	_, e = io.CopyN(writer, val, size)
+	if e != nil {
+		return
+	}
+
+	if n.hasher == nil || batch {
+		return
+	}
+
+	_, e = n.writer.Write(
+		n.hasher.Sum([]byte{}),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
 func (n *Encoder) writeChecksum(key, val []byte) (e error) {
 	// Writes a 32-bit checksum of the record.
 
@@ -217,13 +821,9 @@ func (n *Encoder) writeChecksum(key, val []byte) (e error) {
 	return
 }
 
-func findX(s []byte) (x int) {
+func findX(l int) (x int) {
 	// Returns the minimum number of bytes needed to encode an unsigned integer
-	// indicating the length of byte slice s.
-
-	var (
-		l int = len(s)
-	)
+	// indicating length l.
 
 	switch {
 	case l < 1<<8:
@@ -239,8 +839,6 @@ func findX(s []byte) (x int) {
 		return 4
 
 	default:
-		panic("byte slice s exceeds the maximum LMDB value size")
+		panic("length l exceeds the maximum LMDB value size")
 	}
-
-	return
 }
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"hash"
 	"hash/fnv"
+	"io"
+	"net"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -119,37 +123,185 @@ func TestEncoder(t *testing.T) {
 	return
 }
 
+func TestEncoderEncodeFrom(t *testing.T) {
+	var (
+		e        error
+		expected bytes.Buffer
+		file     *os.File
+		key      = []byte("key")
+		listener net.Listener
+		observed bytes.Buffer
+		server   net.Conn
+		val      = bytes.Repeat([]byte("val"), 1000)
+	)
+
+	assert.NoError(t,
+		NewEncoder(&expected, fnv.New32a()).
+			EncodeX(key, val, XMetaValue3),
+	)
+
+	assert.NoError(t,
+		NewEncoder(&observed, fnv.New32a()).
+			EncodeFromX(key, bytes.NewReader(val), int64(len(val)),
+				XMetaValue3,
+			),
+	)
+
+	assert.Equal(t, expected.Bytes(), observed.Bytes())
+
+	assert.Error(t,
+		NewEncoder(&observed, nil).
+			EncodeFrom(key, bytes.NewReader(val), int64(len(val))+1),
+	)
+
+	file, e = os.CreateTemp(t.TempDir(), "val")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer file.Close()
+
+	_, e = file.Write(val)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	_, e = file.Seek(0, io.SeekStart)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	listener, e = net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		var (
+			client net.Conn
+			e      error
+		)
+
+		client, e = net.Dial("tcp",
+			listener.Addr().String(),
+		)
+		if e != nil {
+			return
+		}
+
+		defer client.Close()
+
+		NewEncoder(client, nil).EncodeFrom(key, file, int64(len(val)))
+	}()
+
+	server, e = listener.Accept()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer server.Close()
+
+	expected.Reset()
+
+	assert.NoError(t,
+		NewEncoder(&expected, nil).Encode(key, val),
+	)
+
+	observed.Reset()
+
+	_, e = io.Copy(&observed, server)
+	if e != nil {
+		t.Error(e)
+	}
+
+	assert.Equal(t, expected.Bytes(), observed.Bytes())
+
+	return
+}
+
+func TestEncoderClose(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		length int
+
+		encoder *Encoder = NewEncoder(&buffer, fnv.New32a(),
+			WithBatchChecksum(4),
+		)
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	length = buffer.Len()
+
+	assert.NoError(t, encoder.Close())
+	assert.Greater(t, buffer.Len(), length)
+
+	length = buffer.Len()
+
+	assert.NoError(t, encoder.Close())
+	assert.Equal(t, length, buffer.Len())
+
+	assert.Error(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	return
+}
+
+func TestEncoderWithIODeadline(t *testing.T) {
+	var (
+		reader, writer = net.Pipe()
+
+		encoder *Encoder = NewEncoder(writer, nil,
+			WithIODeadline(time.Millisecond),
+		)
+	)
+
+	defer reader.Close()
+
+	assert.ErrorIs(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+		os.ErrDeadlineExceeded,
+	)
+
+	return
+}
+
 func TestEncoderValidateLens(t *testing.T) {
 	var (
 		buffer bytes.Buffer
-		key    []byte
-		val    []byte
+		k      int
+		v      int64
 
 		encoder *Encoder = NewEncoder(&buffer, nil)
 	)
 
-	key = make([]byte, 512)
+	k = 512
 
 	assert.Error(t,
-		encoder.validateLens(key, val),
+		encoder.validateLens(k, v),
 	)
 
-	key = make([]byte, 511)
+	k = 511
 
 	assert.NoError(t,
-		encoder.validateLens(key, val),
+		encoder.validateLens(k, v),
 	)
 
-	val = make([]byte, 4294967296)
+	v = 4294967296
 
 	assert.NoError(t,
-		encoder.validateLens(key, val),
+		encoder.validateLens(k, v),
 	)
 
-	val = make([]byte, 4294967297)
+	v = 4294967297
 
 	assert.Error(t,
-		encoder.validateLens(key, val),
+		encoder.validateLens(k, v),
 	)
 
 	return
@@ -158,14 +310,14 @@ func TestEncoderValidateLens(t *testing.T) {
 func TestEncoderWriteXCMK(t *testing.T) {
 	var (
 		buffer bytes.Buffer
-		key    = make([]byte, 341)
-		val    = make([]byte, 65536)
+		k      = 341
+		v      = 65536
 
 		encoder *Encoder = NewEncoder(&buffer, nil)
 	)
 
 	assert.NoError(t,
-		encoder.writeXCMK(key, val, XMetaValueA),
+		encoder.writeXCMK(k, v, XMetaValueA),
 	)
 
 	assert.Equal(t, []byte{0b11010101, 0b01010101},
@@ -174,15 +326,15 @@ func TestEncoderWriteXCMK(t *testing.T) {
 
 	buffer.Reset()
 
-	key = make([]byte, 170)
-	val = make([]byte, 16777216)
+	k = 170
+	v = 16777216
 
 	encoder = NewEncoder(&buffer,
 		fnv.New32a(),
 	)
 
 	assert.NoError(t,
-		encoder.writeXCMK(key, val, XMetaValue0),
+		encoder.writeXCMK(k, v, XMetaValue0),
 	)
 
 	assert.Equal(t, []byte{0b00100000, 0b10101010},
@@ -195,13 +347,13 @@ func TestEncoderWriteXCMK(t *testing.T) {
 func TestEncoderWriteV(t *testing.T) {
 	var (
 		buffer bytes.Buffer
-		val    = make([]byte, 1)
+		v      = 1
 
 		encoder *Encoder = NewEncoder(&buffer, nil)
 	)
 
 	assert.NoError(t,
-		encoder.writeV(val),
+		encoder.writeV(v),
 	)
 
 	assert.Equal(t, []byte{1},
@@ -210,10 +362,10 @@ func TestEncoderWriteV(t *testing.T) {
 
 	buffer.Reset()
 
-	val = make([]byte, 256)
+	v = 256
 
 	assert.NoError(t,
-		encoder.writeV(val),
+		encoder.writeV(v),
 	)
 
 	assert.Equal(t, []byte{1, 0},
@@ -222,10 +374,10 @@ func TestEncoderWriteV(t *testing.T) {
 
 	buffer.Reset()
 
-	val = make([]byte, 65536)
+	v = 65536
 
 	assert.NoError(t,
-		encoder.writeV(val),
+		encoder.writeV(v),
 	)
 
 	assert.Equal(t, []byte{1, 0, 0},
@@ -234,10 +386,10 @@ func TestEncoderWriteV(t *testing.T) {
 
 	buffer.Reset()
 
-	val = make([]byte, 16777216)
+	v = 16777216
 
 	assert.NoError(t,
-		encoder.writeV(val),
+		encoder.writeV(v),
 	)
 
 	assert.Equal(t, []byte{1, 0, 0, 0},
@@ -270,59 +422,59 @@ func TestEncoderWriteChecksum(t *testing.T) {
 
 func TestFindX(t *testing.T) {
 	var (
-		s []byte
+		l int
 	)
 
 	assert.Equal(t, 1,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 255)
+	l = 255
 
 	assert.Equal(t, 1,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 256)
+	l = 256
 
 	assert.Equal(t, 2,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 65535)
+	l = 65535
 
 	assert.Equal(t, 2,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 65536)
+	l = 65536
 
 	assert.Equal(t, 3,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 16777215)
+	l = 16777215
 
 	assert.Equal(t, 3,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 16777216)
+	l = 16777216
 
 	assert.Equal(t, 4,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 4294967295)
+	l = 4294967295
 
 	assert.Equal(t, 4,
-		findX(s),
+		findX(l),
 	)
 
-	s = make([]byte, 4294967296)
+	l = 4294967296
 
 	assert.Panics(t,
-		func() { findX(s) },
+		func() { findX(l) },
 	)
 
 	return
@@ -0,0 +1,230 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// RotateKey causes the Encoder to generate a new data key, wrap it with the
+// key-encryption key, and transmit it labelled with the identifier in a
+// control record. The value of every subsequent record is encrypted under the
+// new data key, and a Decoder switches keys upon receipt of the control
+// record, so that keys can be rotated without interrupting the stream. See
+// WithEncryption.
+func (n *Encoder) RotateKey(id string, kek cipher.AEAD) (e error) {
+	defer errorf("could not rotate key", &e)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	e = n.rotateKey(id, kek)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) rotateKey(id string, kek cipher.AEAD) (e error) {
+	// Generates a new data key and transmits it wrapped with kek. The caller
+	// must hold n.mutex.
+
+	var (
+		dataKey = make([]byte, dataKeyLen)
+		nonce   = make([]byte, kek.NonceSize())
+		label   []byte
+		payload []byte
+		aead    cipher.AEAD
+	)
+
+	if len(id) > keyIDMaxLen {
+		return fmt.Errorf("key identifier too long")
+	}
+
+	_, e = rand.Read(dataKey)
+	if e != nil {
+		return
+	}
+
+	_, e = rand.Read(nonce)
+	if e != nil {
+		return
+	}
+
+	aead, e = newDataKeyAEAD(dataKey)
+	if e != nil {
+		return
+	}
+
+	// The identifier is authenticated as additional data, which may not
+	// overlap the destination of the sealed data key.
+
+	label = append([]byte{byte(len(id))}, id...)
+
+	payload = append(bytes.Clone(label), nonce...)
+	payload = kek.Seal(payload, nonce, dataKey, label)
+
+	e = n.writeControl(controlDataKey, payload)
+	if e != nil {
+		return
+	}
+
+	n.dataKey = aead
+	n.dataKeyUses = 0
+
+	return
+}
+
+func (n *Encoder) seal(key, val []byte) (sealed []byte, e error) {
+	// Returns val encrypted under the current data key and authenticated
+	// together with key, preceded by the nonce. The caller must hold n.mutex.
+
+	var (
+		nonce = make([]byte, n.dataKey.NonceSize())
+	)
+
+	if len(val) > lmdbMaxValLen-len(nonce)-n.dataKey.Overhead() {
+		return nil, fmt.Errorf("LMDB maximum value length (4 GiB) exceeded " +
+			"after encryption")
+	}
+
+	// Nonces are never reused under a data key, which is rotated long before
+	// its counter could wrap.
+
+	n.dataKeyUses++
+
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], n.dataKeyUses)
+
+	sealed = n.dataKey.Seal(nonce, nonce, val, key)
+
+	return
+}
+
+func (n *Encoder) sealFrom(key []byte, val io.Reader, size int64) (
+	sealed io.Reader, sealedSize int64, e error,
+) {
+	// Reads a value of the given size from val and returns it encrypted as by
+	// seal. The caller must hold n.mutex.
+
+	var (
+		b = make([]byte, size)
+	)
+
+	_, e = io.ReadFull(val, b)
+	if e != nil {
+		return
+	}
+
+	b, e = n.seal(key, b)
+	if e != nil {
+		return
+	}
+
+	return bytes.NewReader(b), int64(len(b)), nil
+}
+
+func (d *Decoder) open(key, val []byte) (opened []byte, e error) {
+	// Returns val decrypted under the current data key and authenticated
+	// together with key.
+
+	var (
+		nonceLen int
+	)
+
+	if d.dataKey == nil {
+		return nil, fmt.Errorf("encrypted record precedes data key")
+	}
+
+	nonceLen = d.dataKey.NonceSize()
+
+	if len(val) < nonceLen {
+		return nil, fmt.Errorf("encrypted value too short")
+	}
+
+	opened, e = d.dataKey.Open(nil, val[:nonceLen], val[nonceLen:], key)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (d *Decoder) receiveKey(payload []byte) (e error) {
+	// Unwraps a data key received in a control record and makes it current.
+
+	var (
+		dataKey []byte
+		id      string
+		idLen   int
+		kek     cipher.AEAD
+		nonce   []byte
+	)
+
+	if d.options.keyring == nil {
+		return fmt.Errorf("stream is encrypted but no keyring is configured")
+	}
+
+	if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+		return fmt.Errorf("malformed data key control record")
+	}
+
+	idLen = int(payload[0])
+
+	id = string(payload[1 : 1+idLen])
+
+	kek, e = d.options.keyring(id)
+	if e != nil {
+		return
+	}
+
+	if len(payload) < 1+idLen+kek.NonceSize() {
+		return fmt.Errorf("malformed data key control record")
+	}
+
+	nonce = payload[1+idLen : 1+idLen+kek.NonceSize()]
+
+	dataKey, e = kek.Open(nil, nonce, payload[1+idLen+len(nonce):],
+		payload[:1+idLen],
+	)
+	if e != nil {
+		return fmt.Errorf("could not unwrap data key %q: %w", id, e)
+	}
+
+	d.dataKey, e = newDataKeyAEAD(dataKey)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func newDataKeyAEAD(dataKey []byte) (aead cipher.AEAD, e error) {
+	// Returns AES-256-GCM keyed with dataKey.
+
+	var (
+		block cipher.Block
+	)
+
+	block, e = aes.NewCipher(dataKey)
+	if e != nil {
+		return
+	}
+
+	aead, e = cipher.NewGCM(block)
+	if e != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,160 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryption(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		i       int
+		id      string
+		key     []byte
+		keks    = map[string]cipher.AEAD{}
+		val     []byte
+	)
+
+	for _, id = range []string{"2025", "2026"} {
+		keks[id] = newTestAEAD(t, id)
+	}
+
+	encoder = NewEncoder(&buffer, fnv.New32a(),
+		WithEncryption("2025", keks["2025"]),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("secret")),
+	)
+
+	assert.NoError(t,
+		encoder.RotateKey("2026", keks["2026"]),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("yek"),
+			bytes.NewReader([]byte("terces")), 6,
+		),
+	)
+
+	assert.NotContains(t, buffer.String(), "secret")
+	assert.NotContains(t, buffer.String(), "terces")
+
+	_, _, e = NewDecoder(bytes.NewReader(buffer.Bytes()), nil).Decode()
+
+	assert.ErrorContains(t, e, "no keyring")
+
+	decoder = NewDecoder(&buffer, fnv.New32a(),
+		WithDecryption(
+			func(id string) (kek cipher.AEAD, e error) {
+				var (
+					ok bool
+				)
+
+				kek, ok = keks[id]
+				if !ok {
+					return nil, fmt.Errorf("unknown key %q", id)
+				}
+
+				return
+			},
+		),
+	)
+
+	for i = 0; i < 2; i++ {
+		key, val, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t,
+			[][]byte{[]byte("key"), []byte("yek")}[i],
+			key,
+		)
+
+		assert.Equal(t,
+			[][]byte{[]byte("secret"), []byte("terces")}[i],
+			val,
+		)
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.True(t,
+		errors.Is(e, io.EOF),
+	)
+
+	return
+}
+
+func TestEncryptionTampering(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		e       error
+		encoded []byte
+		kek     = newTestAEAD(t, "kek")
+		keyring = func(string) (cipher.AEAD, error) { return kek, nil }
+	)
+
+	assert.NoError(t,
+		NewEncoder(&buffer, nil, WithEncryption("kek", kek)).
+			Encode([]byte("key"), []byte("secret")),
+	)
+
+	encoded = buffer.Bytes()
+
+	encoded[len(encoded)-(12+6+16)-3] ^= 1 // key of the record
+
+	_, _, e = NewDecoder(bytes.NewReader(encoded), nil,
+		WithDecryption(keyring),
+	).Decode()
+
+	assert.ErrorContains(t, e, "authentication failed")
+
+	_, _, e = NewDecoder(bytes.NewReader(encoded), nil,
+		WithDecryption(
+			func(string) (cipher.AEAD, error) {
+				return newTestAEAD(t, "other"), nil
+			},
+		),
+	).Decode()
+
+	assert.ErrorContains(t, e, "could not unwrap data key")
+
+	return
+}
+
+func newTestAEAD(t *testing.T, seed string) (aead cipher.AEAD) {
+	// Returns AES-256-GCM keyed deterministically by seed.
+
+	var (
+		block cipher.Block
+		e     error
+		key   = make([]byte, 32)
+	)
+
+	copy(key, seed)
+
+	block, e = aes.NewCipher(key)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	aead, e = cipher.NewGCM(block)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return
+}
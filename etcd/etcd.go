@@ -0,0 +1,248 @@
+// Package etcd exports trees of etcd keys as streams of records, and imports
+// them back.
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/internal/wrap"
+)
+
+const (
+	etcdPageLen = 1000
+)
+
+// A KV exports and imports a tree of etcd, by the JSON gateway of its v3
+// API, as a stream of records, to snapshot small configuration stores in the
+// same format as databases.
+//
+// The key of a record is that of its entry less the prefix of the tree. As
+// etcd keys are bytes, like record keys, no escaping is needed, and the
+// slashes of hierarchical keys are kept as they are. The revisions and leases
+// of entries are not exported.
+type KV struct {
+	// Client issues the requests, or http.DefaultClient if nil.
+	Client *http.Client
+
+	// Address is the URL of an etcd endpoint, such as
+	// http://127.0.0.1:2379.
+	Address string
+
+	// Root is the key prefix of the tree, such as /config/app/, or empty for
+	// the whole keyspace.
+	Root []byte
+
+	// Token, if not empty, is the authentication token sent with every
+	// request, as returned by the Authenticate method of the API.
+	Token string
+}
+
+type etcdRange struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+	Limit    int64  `json:"limit"`
+	Revision string `json:"revision,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	KVs []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+	More bool `json:"more"`
+}
+
+type etcdPut struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// Export encodes the entries of the tree on the Encoder, in the order of
+// their keys, and returns the number of records encoded. The tree is read a
+// page at a time, every page at the revision of the first, so that the
+// export is consistent. It does not close the Encoder.
+func (c KV) Export(ctx context.Context, encoder *bl.Encoder) (
+	n int64, e error,
+) {
+	defer wrap.Errorf("could not export etcd tree", &e)
+
+	var (
+		i        int
+		request  = etcdRange{Key: c.Root, Limit: etcdPageLen}
+		response etcdRangeResponse
+	)
+
+	// A range from the root to the key following all keys that begin with
+	// it, the end of the keyspace if none does, as etcd denotes by a zero
+	// byte.
+
+	request.RangeEnd = bytes.Clone(c.Root)
+
+	for i = len(request.RangeEnd) - 1; i >= 0; i-- {
+		if request.RangeEnd[i] < 0xff {
+			request.RangeEnd[i]++
+			request.RangeEnd = request.RangeEnd[:i+1]
+
+			break
+		}
+	}
+
+	if i < 0 {
+		request.RangeEnd = []byte{0}
+	}
+
+	if len(request.Key) == 0 {
+		request.Key = []byte{0}
+	}
+
+	for {
+		response = etcdRangeResponse{}
+
+		e = c.do(ctx, "range", request, &response)
+		if e != nil {
+			return
+		}
+
+		for i = range response.KVs {
+			if !bytes.HasPrefix(response.KVs[i].Key, c.Root) {
+				return n, fmt.Errorf("entry %x outside tree",
+					response.KVs[i].Key,
+				)
+			}
+
+			e = encoder.Encode(response.KVs[i].Key[len(c.Root):],
+				response.KVs[i].Value,
+			)
+			if e != nil {
+				return
+			}
+
+			n++
+		}
+
+		if !response.More || len(response.KVs) == 0 {
+			return
+		}
+
+		request.Key = append(
+			bytes.Clone(response.KVs[len(response.KVs)-1].Key), 0,
+		)
+		request.Revision = response.Header.Revision
+	}
+}
+
+// Import writes the records of the Decoder to the tree until the end of the
+// stream, deleting the entries of those marked with XMetaFlagTombstone, and
+// returns the number of records written. Records are written one request at
+// a time, and so not atomically.
+func (c KV) Import(ctx context.Context, decoder *bl.Decoder) (
+	n int64, e error,
+) {
+	defer wrap.Errorf("could not import etcd tree", &e)
+
+	var (
+		key     []byte
+		request etcdPut
+		val     []byte
+		xmv     byte
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			return n, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		request = etcdPut{
+			Key: append(bytes.Clone(c.Root), key...),
+		}
+
+		if bl.XMetaValue(xmv).HasFlag(bl.XMetaFlagTombstone) {
+			e = c.do(ctx, "deleterange", request, nil)
+		} else {
+			request.Value = val
+
+			e = c.do(ctx, "put", request, nil)
+		}
+
+		if e != nil {
+			return n, fmt.Errorf("key %x: %w", key, e)
+		}
+
+		n++
+	}
+}
+
+func (c KV) do(ctx context.Context, method string, request any,
+	response any,
+) (e error) {
+	// Calls the method of the KV service, decoding the response into the
+	// given value, if not nil.
+
+	var (
+		body   []byte
+		client = c.Client
+		req    *http.Request
+		resp   *http.Response
+		target = strings.TrimSuffix(c.Address, "/") + "/v3/kv/" + method
+	)
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	body, e = json.Marshal(request)
+	if e != nil {
+		return
+	}
+
+	req, e = http.NewRequestWithContext(ctx, http.MethodPost, target,
+		bytes.NewReader(body),
+	)
+	if e != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", c.Token)
+	}
+
+	resp, e = client.Do(req)
+	if e != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+
+	body, e = io.ReadAll(resp.Body)
+	if e != nil {
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	if response == nil {
+		return
+	}
+
+	return json.Unmarshal(body, response)
+}
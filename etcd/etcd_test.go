@@ -0,0 +1,180 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A fakeEtcd serves the KV service of the etcd v3 JSON gateway from memory,
+// returning ranges two entries at a time.
+type fakeEtcd struct {
+	mutex     sync.Mutex
+	data      map[string][]byte
+	revisions []string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		k       string
+		keys    []string
+		request struct {
+			etcdRange
+			Value []byte `json:"value"`
+		}
+		response etcdRangeResponse
+	)
+
+	f.mutex.Lock()
+
+	defer f.mutex.Unlock()
+
+	if r.Header.Get("Authorization") != "token" {
+		http.Error(w, `{"error":"invalid auth token"}`,
+			http.StatusUnauthorized,
+		)
+
+		return
+	}
+
+	json.NewDecoder(r.Body).Decode(&request)
+
+	switch strings.TrimPrefix(r.URL.Path, "/v3/kv/") {
+	case "range":
+		f.revisions = append(f.revisions, request.Revision)
+
+		for k = range f.data {
+			if k >= string(request.Key) &&
+				(string(request.RangeEnd) == "\x00" ||
+					k < string(request.RangeEnd)) {
+				keys = append(keys, k)
+			}
+		}
+
+		slices.Sort(keys)
+
+		response.Header.Revision = "7"
+		response.More = len(keys) > 2
+
+		for _, k = range keys[:min(len(keys), 2)] {
+			response.KVs = append(response.KVs,
+				struct {
+					Key   []byte `json:"key"`
+					Value []byte `json:"value"`
+				}{[]byte(k), f.data[k]},
+			)
+		}
+
+		json.NewEncoder(w).Encode(response)
+
+	case "put":
+		f.data[string(request.Key)] = request.Value
+
+		io.WriteString(w, "{}")
+
+	case "deleterange":
+		delete(f.data, string(request.Key))
+
+		io.WriteString(w, "{}")
+	}
+
+	return
+}
+
+func TestKV(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		ctx     = context.Background()
+		decoder *bl.Decoder
+		e       error
+		encoder = bl.NewEncoder(&buffer, nil)
+		fake    = &fakeEtcd{
+			data: map[string][]byte{
+				"/app/a":     []byte("1"),
+				"/app/b":     []byte("2"),
+				"/app/c":     []byte("3"),
+				"/app/stale": []byte("x"),
+				"/apq":       []byte("no"),
+			},
+		}
+		key       []byte
+		keys      []string
+		kv        KV
+		n         int64
+		server    = httptest.NewServer(fake)
+		tombstone = bl.NewXMetaValue(0, bl.XMetaFlagTombstone)
+		val       []byte
+	)
+
+	defer server.Close()
+
+	kv = KV{
+		Address: server.URL,
+		Root:    []byte("/app/"),
+		Token:   "token",
+	}
+
+	assert.NoError(t,
+		encoder.Encode([]byte("d/\xff"), []byte("4")),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("stale"), nil, tombstone),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	n, e = kv.Import(ctx, bl.NewDecoder(&buffer, nil))
+	assert.NoError(t, e)
+	assert.EqualValues(t, 2, n)
+	assert.Equal(t, []byte("4"), fake.data["/app/d/\xff"])
+	assert.NotContains(t, fake.data, "/app/stale")
+
+	buffer.Reset()
+
+	encoder = bl.NewEncoder(&buffer, nil)
+
+	n, e = kv.Export(ctx, encoder)
+	assert.NoError(t, e)
+	assert.EqualValues(t, 4, n)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// Every page after the first is read at the revision of the first.
+
+	assert.Equal(t, []string{"", "7"}, fake.revisions)
+
+	decoder = bl.NewDecoder(&buffer, nil)
+
+	for {
+		key, val, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		keys = append(keys, string(key)+"="+string(val))
+	}
+
+	assert.Equal(t, []string{"a=1", "b=2", "c=3", "d/\xff=4"}, keys)
+
+	_, e = KV{Address: server.URL}.
+		Export(ctx, bl.NewEncoder(io.Discard, nil))
+	assert.ErrorContains(t, e, "401 Unauthorized")
+
+	return
+}
@@ -0,0 +1,102 @@
+package bottledlightning
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"time"
+)
+
+// An ExpiryFunc returns the time at which a record expires, as embedded in its
+// key, value or extended metadata value by the application, or false if the
+// record never expires.
+type ExpiryFunc func(key, val []byte, xmv XMetaValue) (expiry time.Time,
+	ok bool)
+
+// TimestampExpiry returns an ExpiryFunc for values that begin with a
+// timestamp, as a big-endian count of nanoseconds since the Unix epoch, and
+// expire the given time-to-live after it. Values shorter than a timestamp
+// never expire.
+func TimestampExpiry(ttl time.Duration) ExpiryFunc {
+	return func(_, val []byte, _ XMetaValue) (expiry time.Time, ok bool) {
+		if len(val) < 8 {
+			return
+		}
+
+		expiry = time.Unix(0,
+			int64(binary.BigEndian.Uint64(val)),
+		)
+
+		return expiry.Add(ttl), true
+	}
+}
+
+// A CompactionReport counts the records of a stream kept and dropped by
+// Compact.
+type CompactionReport struct {
+	Kept    int
+	Expired int
+}
+
+// Compact receives every record from the [io.Reader], verifying the checksum
+// of each if the [hash.Hash32] is not nil, and re-encodes those that have not
+// expired at the given reference time to the [io.Writer], so that an archived
+// stream can be trimmed according to a retention policy without restoring it.
+// A record has expired if the ExpiryFunc returns a time not after the
+// reference time. The options configure both the Decoder and the Encoder, and
+// the order of the records kept is preserved.
+func Compact(writer io.Writer, reader io.Reader, hasher hash.Hash32,
+	at time.Time, expiry ExpiryFunc, opts ...Option,
+) (
+	report CompactionReport, e error,
+) {
+	defer errorf("could not compact stream", &e)
+
+	var (
+		decoder = NewDecoder(reader, hasher, opts...)
+		encoder = NewEncoder(writer, hasher, opts...)
+		expires time.Time
+		key     []byte
+		ok      bool
+		val     []byte
+		xmv     byte
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		expires, ok = expiry(key, val, XMetaValue(xmv))
+		if ok && !expires.After(at) {
+			report.Expired++
+
+			continue
+		}
+
+		e = encoder.EncodeX(key, val, XMetaValue(xmv))
+		if e != nil {
+			return
+		}
+
+		report.Kept++
+	}
+
+	e = decoder.Close()
+	if e != nil {
+		return
+	}
+
+	e = encoder.Close()
+	if e != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,85 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompact(t *testing.T) {
+	var (
+		at      = time.Unix(1000, 0)
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		input   bytes.Buffer
+		key     []byte
+		keys    []string
+		output  bytes.Buffer
+		report  CompactionReport
+		stamp   = func(t time.Time, s string) []byte {
+			return append(
+				binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano())),
+				s...,
+			)
+		}
+		val []byte
+		xmv byte
+	)
+
+	encoder = NewEncoder(&input, fnv.New32a(), WithDeduplication(4))
+
+	encoder.Encode([]byte("stale"), stamp(at.Add(-2*time.Hour), "val"))
+	encoder.EncodeX([]byte("fresh"), stamp(at.Add(-time.Minute), "val"),
+		XMetaValue1,
+	)
+	encoder.Encode([]byte("edge"), stamp(at.Add(-time.Hour), "val"))
+	encoder.Encode([]byte("bare"), []byte("val"))
+	encoder.Close()
+
+	report, e = Compact(&output, bytes.NewReader(input.Bytes()), fnv.New32a(),
+		at, TimestampExpiry(time.Hour), WithDeduplication(4),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, CompactionReport{Kept: 2, Expired: 2}, report)
+
+	decoder = NewDecoder(&output, fnv.New32a(), WithDeduplication(4))
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if !assert.NoError(t, e) {
+			break
+		}
+
+		keys = append(keys, string(key))
+
+		if string(key) == "fresh" {
+			assert.Equal(t, stamp(at.Add(-time.Minute), "val"), val)
+			assert.Equal(t, byte(XMetaValue1), xmv)
+		}
+	}
+
+	assert.Equal(t, []string{"fresh", "bare"}, keys)
+
+	_, e = Compact(io.Discard,
+		bytes.NewReader(input.Bytes()[:input.Len()-1]), fnv.New32a(),
+		at, TimestampExpiry(time.Hour), WithDeduplication(4),
+	)
+
+	assert.Error(t, e)
+
+	return
+}
@@ -0,0 +1,61 @@
+package bottledlightning
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// PublishExpvar registers the statistics of the Encoder with [expvar], under
+// names beginning with prefix, so that services exposing /debug/vars report
+// them: prefix+".records", ".compression", ".audit", ".async_queue" and
+// ".sizes", holding RecordStats, CompressionStats, AuditStats,
+// AsyncQueueStats and SizeStats respectively. Values are read when scraped. It
+// returns an error if any of the names is already registered, as it would be
+// by an earlier call with the same prefix.
+func (n *Encoder) PublishExpvar(prefix string) (e error) {
+	defer errorf("could not publish Encoder statistics", &e)
+
+	return publishExpvar(prefix, map[string]func() any{
+		"records":     func() any { return n.RecordStats() },
+		"compression": func() any { return n.CompressionStats() },
+		"audit":       func() any { return n.AuditStats() },
+		"async_queue": func() any { return n.AsyncQueueStats() },
+		"sizes":       func() any { return n.SizeStats() },
+	})
+}
+
+// PublishExpvar registers the statistics of the Decoder with [expvar], as does
+// Encoder.PublishExpvar: prefix+".records", ".audit" and ".sizes".
+func (d *Decoder) PublishExpvar(prefix string) (e error) {
+	defer errorf("could not publish Decoder statistics", &e)
+
+	return publishExpvar(prefix, map[string]func() any{
+		"records": func() any { return d.RecordStats() },
+		"audit":   func() any { return d.AuditStats() },
+		"sizes":   func() any { return d.SizeStats() },
+	})
+}
+
+func publishExpvar(prefix string, vars map[string]func() any) (e error) {
+	// Registers each of vars with expvar under prefix, after checking that
+	// none of the names is taken, since expvar.Publish panics if one is.
+
+	var (
+		name string
+		read func() any
+	)
+
+	for name = range vars {
+		if expvar.Get(prefix+"."+name) != nil {
+			return fmt.Errorf("expvar %q already registered", prefix+"."+name)
+		}
+	}
+
+	for name, read = range vars {
+		expvar.Publish(prefix+"."+name,
+			expvar.Func(read),
+		)
+	}
+
+	return
+}
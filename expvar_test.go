@@ -0,0 +1,49 @@
+package bottledlightning
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	var (
+		decoder *Decoder
+		encoder *Encoder
+		records RecordStats
+	)
+
+	encoder = NewEncoder(io.Discard, nil)
+
+	assert.NoError(t, encoder.PublishExpvar("test.encoder"))
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t,
+		json.Unmarshal(
+			[]byte(expvar.Get("test.encoder.records").String()),
+			&records,
+		),
+	)
+
+	assert.Equal(t, RecordStats{Records: 1, Bytes: 6}, records)
+	assert.NotNil(t, expvar.Get("test.encoder.async_queue"))
+
+	assert.ErrorContains(t,
+		NewEncoder(io.Discard, nil).PublishExpvar("test.encoder"),
+		"already registered",
+	)
+
+	decoder = NewDecoder(nil, nil)
+
+	assert.NoError(t, decoder.PublishExpvar("test.decoder"))
+	assert.NotNil(t, expvar.Get("test.decoder.sizes"))
+	assert.Nil(t, expvar.Get("test.decoder.compression"))
+
+	return
+}
@@ -0,0 +1,31 @@
+package bottledlightning
+
+// Features are optional revisions of the format, which an Encoder announces in
+// a control record before the first record to which they apply.
+const (
+	featureHeaderChecksum uint32 = 1 << iota
+	featureBatchChecksum
+	featureEncryption
+	featureSortedKeys
+	featureDedup
+	featureBlobSpill
+	featureCompression
+)
+
+const (
+	knownFeatures = featureHeaderChecksum | featureBatchChecksum |
+		featureEncryption | featureSortedKeys | featureDedup |
+		featureBlobSpill | featureCompression
+)
+
+// featureNames names the features in external metadata such as manifests, in
+// the order of their bits.
+var featureNames = []string{
+	"header-checksum",
+	"batch-checksum",
+	"encryption",
+	"sorted-keys",
+	"dedup",
+	"blob-spill",
+	"compression",
+}
@@ -0,0 +1,92 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeaturesHeaderChecksum(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		e      error
+		key    []byte
+		val    []byte
+		xmv    byte
+
+		decoder *Decoder
+		encoder *Encoder
+		tamper  = func(options ...Option) {
+			// Encodes two records and flips a metadata bit of the second,
+			// whose 13 bytes end the stream.
+
+			buffer.Reset()
+
+			encoder = NewEncoder(&buffer, fnv.New32a(), options...)
+
+			encoder.EncodeX([]byte("key"), []byte("val"), XMetaValue1)
+			encoder.EncodeFrom([]byte("yek"),
+				bytes.NewReader([]byte("lav")), 3,
+			)
+
+			buffer.Bytes()[buffer.Len()-13] ^= 1 << (offsetM - 8)
+
+			decoder = NewDecoder(&buffer, fnv.New32a())
+		}
+	)
+
+	tamper()
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	_, _, xmv, e = decoder.DecodeX()
+
+	assert.NoError(t, e) // damage goes undetected
+	assert.Equal(t, byte(XMetaValue1), xmv)
+
+	tamper(
+		WithHeaderChecksum(),
+	)
+
+	key, val, xmv, e = decoder.DecodeX()
+	if e != nil {
+		t.Error(e)
+	}
+
+	assert.Equal(t, []byte("key"), key)
+	assert.Equal(t, []byte("val"), val)
+	assert.Equal(t, byte(XMetaValue1), xmv)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "checksum")
+
+	return
+}
+
+func TestFeaturesUnknown(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+	)
+
+	assert.NoError(t,
+		encoder.writeControl(controlFeatures, []byte{0x80, 0, 0, 0}),
+	)
+
+	_, _, e = NewDecoder(&buffer, nil).Decode()
+
+	assert.ErrorContains(t, e, "unsupported format features")
+
+	_, _, e = NewDecoder(&buffer, nil).Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
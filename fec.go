@@ -0,0 +1,339 @@
+package bottledlightning
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// An FECWriter adds forward error correction to a stream, such as that of an
+// Encoder destined for tape or a lossy link. It groups the bytes written to it
+// into blocks, splits each block into data shards of equal length, and
+// appends Reed-Solomon parity shards. Every shard carries a CRC-32C checksum
+// by which an FECReader recognises it as damaged, and the FECReader can
+// reconstruct a block from any combination of undamaged shards as numerous as
+// the data shards.
+//
+// Each block begins with 4 bytes, protected like the rest, to hold the number
+// of bytes of the stream that it carries; the last block is padded.
+type FECWriter struct {
+	writer   io.Writer
+	coding   gfMatrix
+	shards   [][]byte
+	block    []byte
+	n        int
+	shardLen int
+}
+
+// NewFECWriter returns a new FECWriter that will transmit on the [io.Writer]
+// blocks of dataShards shards of shardLen bytes each, followed by parityShards
+// parity shards. Up to parityShards damaged shards per block can be repaired,
+// at the cost of (parityShards / dataShards) overhead. The sum of dataShards
+// and parityShards may not exceed 256.
+func NewFECWriter(writer io.Writer, dataShards, parityShards, shardLen int) (
+	w *FECWriter, e error,
+) {
+	defer errorf("could not create FEC writer", &e)
+
+	w = &FECWriter{
+		writer:   writer,
+		shardLen: shardLen,
+	}
+
+	w.coding, w.shards, w.block, e = newFECCode(dataShards, parityShards,
+		shardLen,
+	)
+	if e != nil {
+		return nil, e
+	}
+
+	return
+}
+
+// Write implements [io.Writer]. Bytes are transmitted a block at a time.
+func (w *FECWriter) Write(p []byte) (n int, e error) {
+	var (
+		c int
+	)
+
+	for len(p) > 0 {
+		c = copy(w.block[fecLenLen+w.n:], p)
+
+		w.n += c
+		n += c
+
+		p = p[c:]
+
+		if fecLenLen+w.n < len(w.block) {
+			continue
+		}
+
+		e = w.Flush()
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Flush transmits any buffered bytes as a block, padded if necessary.
+func (w *FECWriter) Flush() (e error) {
+	var (
+		i, j  int
+		shard []byte
+	)
+
+	if w.n == 0 {
+		return
+	}
+
+	binary.BigEndian.PutUint32(w.block,
+		uint32(w.n),
+	)
+
+	clear(w.block[fecLenLen+w.n:])
+
+	for i = len(w.coding[0]); i < len(w.shards); i++ {
+		clear(w.shards[i])
+
+		for j = 0; j < len(w.coding[0]); j++ {
+			gfMulAdd(w.shards[i], w.shards[j], w.coding[i][j])
+		}
+	}
+
+	for _, shard = range w.shards {
+		_, e = w.writer.Write(shard)
+		if e != nil {
+			return
+		}
+
+		_, e = w.writer.Write(
+			binary.BigEndian.AppendUint32(nil,
+				crc32.Checksum(shard, castagnoli),
+			),
+		)
+		if e != nil {
+			return
+		}
+	}
+
+	w.n = 0
+
+	return
+}
+
+// Close flushes the FECWriter. It does not close the underlying [io.Writer].
+func (w *FECWriter) Close() error {
+	return w.Flush()
+}
+
+// An FECReader receives a stream transmitted by an FECWriter, repairing
+// damaged shards where possible.
+type FECReader struct {
+	reader    io.Reader
+	coding    gfMatrix
+	shards    [][]byte
+	block     []byte
+	raw       []byte
+	remainder []byte
+	repaired  int
+	shardLen  int
+}
+
+// NewFECReader returns a new FECReader that will receive from the [io.Reader]
+// blocks of the shape given to the corresponding FECWriter.
+func NewFECReader(reader io.Reader, dataShards, parityShards, shardLen int) (
+	r *FECReader, e error,
+) {
+	defer errorf("could not create FEC reader", &e)
+
+	r = &FECReader{
+		reader:   reader,
+		shardLen: shardLen,
+	}
+
+	r.coding, r.shards, r.block, e = newFECCode(dataShards, parityShards,
+		shardLen,
+	)
+	if e != nil {
+		return nil, e
+	}
+
+	r.raw = make([]byte,
+		len(r.shards)*(shardLen+crcLen),
+	)
+
+	return
+}
+
+// Read implements [io.Reader]. It returns an error if a block is damaged
+// beyond repair.
+func (r *FECReader) Read(p []byte) (n int, e error) {
+	for len(r.remainder) == 0 {
+		e = r.readBlock()
+		if e != nil {
+			return
+		}
+	}
+
+	n = copy(p, r.remainder)
+
+	r.remainder = r.remainder[n:]
+
+	return
+}
+
+// Repaired returns the number of damaged shards repaired so far.
+func (r *FECReader) Repaired() int {
+	return r.repaired
+}
+
+func (r *FECReader) readBlock() (e error) {
+	// Receives a block, repairs it if necessary and possible, and makes the
+	// bytes of the stream that it carries available to Read. At the end of
+	// the stream, it returns io.EOF unwrapped, as io.Reader requires.
+
+	var (
+		damaged  []int
+		i        int
+		intact   []int
+		l        int
+		observed uint32
+		offset   int
+	)
+
+	_, e = io.ReadFull(r.reader, r.raw)
+	if e != nil {
+		return
+	}
+
+	for i = range r.shards {
+		offset = i * (r.shardLen + crcLen)
+
+		copy(r.shards[i], r.raw[offset:offset+r.shardLen])
+
+		observed = binary.BigEndian.Uint32(
+			r.raw[offset+r.shardLen:],
+		)
+
+		if crc32.Checksum(r.shards[i], castagnoli) == observed {
+			intact = append(intact, i)
+		} else {
+			damaged = append(damaged, i)
+		}
+	}
+
+	e = r.repair(intact, damaged)
+	if e != nil {
+		return fmt.Errorf("could not repair FEC block: %w", e)
+	}
+
+	l = int(
+		binary.BigEndian.Uint32(r.block),
+	)
+
+	if l > len(r.block)-fecLenLen {
+		return fmt.Errorf("FEC block length out of range")
+	}
+
+	r.remainder = r.block[fecLenLen : fecLenLen+l]
+
+	return
+}
+
+func (r *FECReader) repair(intact, damaged []int) (e error) {
+	// Reconstructs any damaged data shards from the first intact shards as
+	// numerous as the data shards.
+
+	var (
+		dataShards = len(r.coding[0])
+		i, j       int
+		inverse    gfMatrix
+		rows       gfMatrix
+		sources    [][]byte
+	)
+
+	if len(damaged) == 0 {
+		return
+	}
+
+	if len(intact) < dataShards {
+		return fmt.Errorf("%d shards damaged, beyond repair",
+			len(damaged),
+		)
+	}
+
+	for _, i = range intact[:dataShards] {
+		rows = append(rows, r.coding[i])
+
+		sources = append(sources, r.shards[i])
+	}
+
+	inverse, e = rows.invert()
+	if e != nil {
+		return
+	}
+
+	for _, i = range damaged {
+		if i >= dataShards {
+			continue
+		}
+
+		clear(r.shards[i])
+
+		for j = range sources {
+			gfMulAdd(r.shards[i], sources[j], inverse[i][j])
+		}
+	}
+
+	r.repaired += len(damaged)
+
+	return
+}
+
+func newFECCode(dataShards, parityShards, shardLen int) (
+	coding gfMatrix, shards [][]byte, block []byte, e error,
+) {
+	// Returns the coding matrix of the given Reed-Solomon code, and shards
+	// that are views of a block of data followed by parity shards.
+
+	var (
+		i int
+	)
+
+	switch {
+	case dataShards < 1 || parityShards < 0:
+		e = fmt.Errorf("invalid number of shards")
+
+	case dataShards+parityShards > 256:
+		e = fmt.Errorf("more than 256 shards")
+
+	case shardLen < 1 || dataShards*shardLen <= fecLenLen:
+		e = fmt.Errorf("shards too short")
+	}
+
+	if e != nil {
+		return
+	}
+
+	coding, e = newGFCodingMatrix(dataShards, dataShards+parityShards)
+	if e != nil {
+		return
+	}
+
+	block = make([]byte,
+		(dataShards+parityShards)*shardLen,
+	)
+
+	shards = make([][]byte, dataShards+parityShards)
+
+	for i = range shards {
+		shards[i] = block[i*shardLen : (i+1)*shardLen]
+	}
+
+	block = block[:dataShards*shardLen]
+
+	return
+}
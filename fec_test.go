@@ -0,0 +1,129 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFEC(t *testing.T) {
+	const (
+		dataShards   = 10
+		parityShards = 4
+		shardLen     = 64
+		stride       = shardLen + crcLen
+		blockLen     = (dataShards + parityShards) * stride
+	)
+
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoded []byte
+		encoder *Encoder
+		i       int
+		key     []byte
+		reader  *FECReader
+		val     []byte
+		writer  *FECWriter
+	)
+
+	writer, e = NewFECWriter(&buffer, dataShards, parityShards, shardLen)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	encoder = NewEncoder(writer, fnv.New32a())
+
+	for i = 0; i < 100; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte{'k', byte(i)},
+				bytes.Repeat([]byte{byte(i)}, i),
+			),
+		)
+	}
+
+	assert.NoError(t,
+		writer.Close(),
+	)
+
+	assert.Zero(t, buffer.Len()%blockLen)
+
+	encoded = bytes.Clone(buffer.Bytes())
+
+	for i = 0; i < len(encoded); i += blockLen {
+		encoded[i] ^= 0xff              // first data shard
+		encoded[i+3*stride+7] ^= 0x01   // fourth data shard
+		encoded[i+9*stride+63] ^= 0x80  // last data shard
+		encoded[i+10*stride+stride-1]++ // checksum of first parity shard
+	}
+
+	reader, e = NewFECReader(bytes.NewReader(encoded),
+		dataShards, parityShards, shardLen,
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	decoder = NewDecoder(reader, fnv.New32a())
+
+	for i = 0; i < 100; i++ {
+		key, val, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t, []byte{'k', byte(i)}, key)
+		assert.Equal(t, bytes.Repeat([]byte{byte(i)}, i), val)
+	}
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	assert.Equal(t, 4*len(encoded)/blockLen,
+		reader.Repaired(),
+	)
+
+	encoded[5*stride] ^= 0xff // a fifth damaged shard in the first block
+
+	reader, e = NewFECReader(bytes.NewReader(encoded),
+		dataShards, parityShards, shardLen,
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	_, e = io.ReadAll(reader)
+
+	assert.ErrorContains(t, e, "beyond repair")
+
+	return
+}
+
+func TestFECParameters(t *testing.T) {
+	var (
+		e error
+	)
+
+	_, e = NewFECWriter(io.Discard, 0, 1, 64)
+
+	assert.Error(t, e)
+
+	_, e = NewFECWriter(io.Discard, 200, 57, 64)
+
+	assert.Error(t, e)
+
+	_, e = NewFECReader(bytes.NewReader(nil), 1, 1, 4)
+
+	assert.Error(t, e)
+
+	_, e = NewFECReader(bytes.NewReader(nil), 200, 56, 1)
+
+	assert.NoError(t, e)
+
+	return
+}
@@ -0,0 +1,208 @@
+package bottledlightning
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// A FileOption configures a File created by CreateFile.
+type FileOption func(*fileOptions)
+
+type fileOptions struct {
+	directIO      bool
+	preallocation int64
+}
+
+// WithDirectIO causes CreateFile to open the file for direct I/O, bypassing
+// the page cache, where supported. Writes are then buffered and issued in
+// aligned blocks, as direct I/O requires. On platforms or file systems that do
+// not support direct I/O, the option has no effect.
+func WithDirectIO() FileOption {
+	return func(o *fileOptions) {
+		o.directIO = true
+	}
+}
+
+// WithPreallocation causes CreateFile to reserve the given number of bytes of
+// storage for the file up front, where supported, so that writes do not
+// contend with block allocation on a busy disk. The apparent size of the file
+// is unaffected. On platforms or file systems that do not support
+// preallocation, the option has no effect.
+func WithPreallocation(size int64) FileOption {
+	return func(o *fileOptions) {
+		o.preallocation = size
+	}
+}
+
+// A File is a file on the local file system that is suitable as the
+// destination of an Encoder, including one with a sync policy such as
+// WithSyncEvery. Files are not safe for concurrent use by multiple
+// goroutines, but an Encoder serialises its writes.
+type File struct {
+	file   *os.File
+	buffer []byte // aligned for direct I/O; nil otherwise
+	n      int    // number of bytes in buffer
+	offset int64  // offset in file of buffer[0], or of the next write
+}
+
+// CreateFile creates or truncates the named file, as does [os.Create], and
+// then syncs its parent directory so that the existence of the file survives
+// a loss of power.
+func CreateFile(name string, opts ...FileOption) (f *File, e error) {
+	defer errorf("could not create file", &e)
+
+	var (
+		o   fileOptions
+		opt FileOption
+	)
+
+	for _, opt = range opts {
+		opt(&o)
+	}
+
+	f = new(File)
+
+	e = f.open(name, o)
+	if e != nil {
+		return nil, e
+	}
+
+	e = syncDir(
+		filepath.Dir(name),
+	)
+	if e != nil {
+		f.file.Close()
+
+		return nil, e
+	}
+
+	return
+}
+
+// Write implements [io.Writer].
+func (f *File) Write(p []byte) (n int, e error) {
+	var (
+		c int
+	)
+
+	if f.buffer == nil {
+		n, e = f.file.Write(p)
+
+		f.offset += int64(n)
+
+		return
+	}
+
+	for len(p) > 0 {
+		c = copy(f.buffer[f.n:], p)
+
+		f.n += c
+		n += c
+
+		p = p[c:]
+
+		if f.n < len(f.buffer) {
+			continue
+		}
+
+		e = f.flush()
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Sync commits the contents of the file to stable storage. Under direct I/O,
+// a trailing partial block is written padded with zeroes, which Close trims.
+func (f *File) Sync() (e error) {
+	if f.buffer != nil {
+		e = f.flush()
+		if e != nil {
+			return
+		}
+	}
+
+	e = f.file.Sync()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Close writes any buffered bytes, trims the file to the length of what was
+// written, releasing any unused preallocated storage, and closes the file. It
+// does not sync the file.
+func (f *File) Close() (e error) {
+	defer f.file.Close()
+
+	if f.buffer != nil {
+		e = f.flush()
+		if e != nil {
+			return
+		}
+	}
+
+	e = f.file.Truncate(f.offset +
+		int64(f.n),
+	)
+	if e != nil {
+		return
+	}
+
+	e = f.file.Close()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (f *File) flush() (e error) {
+	// Writes the buffer under direct I/O, padding a trailing partial block with
+	// zeroes. Whole blocks are then discarded from the buffer, while a partial
+	// block is retained to be rewritten in full by the next flush.
+
+	var (
+		whole  = f.n &^ (directIOAlignment - 1)
+		padded = (f.n + directIOAlignment - 1) &^ (directIOAlignment - 1)
+	)
+
+	clear(f.buffer[f.n:padded])
+
+	_, e = f.file.WriteAt(f.buffer[:padded], f.offset)
+	if e != nil {
+		return
+	}
+
+	f.n = copy(f.buffer, f.buffer[whole:f.n])
+
+	f.offset += int64(whole)
+
+	return
+}
+
+func syncDir(name string) (e error) {
+	// Syncs the named directory, committing changes to its entries to stable
+	// storage.
+
+	var (
+		dir *os.File
+	)
+
+	dir, e = os.Open(name)
+	if e != nil {
+		return
+	}
+
+	defer dir.Close()
+
+	e = dir.Sync()
+	if e != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,79 @@
+package bottledlightning
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fallocKeepSize = 0x1 // FALLOC_FL_KEEP_SIZE
+)
+
+func (f *File) open(name string, o fileOptions) (e error) {
+	// Opens the named file for writing, for direct I/O if so configured and
+	// supported by the file system, and preallocates storage if so configured
+	// and supported.
+
+	const (
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	)
+
+	if o.directIO {
+		f.file, e = os.OpenFile(name, flag|syscall.O_DIRECT, 0666)
+
+		switch {
+		case e == nil:
+			f.buffer = alignedBuffer(directIOBufferLen)
+
+		case errors.Is(e, syscall.EINVAL):
+			e = nil
+
+		default:
+			return
+		}
+	}
+
+	if f.file == nil {
+		f.file, e = os.OpenFile(name, flag, 0666)
+		if e != nil {
+			return
+		}
+	}
+
+	if o.preallocation <= 0 {
+		return
+	}
+
+	e = syscall.Fallocate(int(f.file.Fd()), fallocKeepSize, 0,
+		o.preallocation,
+	)
+	if errors.Is(e, syscall.EOPNOTSUPP) {
+		e = nil
+	}
+
+	if e != nil {
+		f.file.Close()
+
+		return
+	}
+
+	return
+}
+
+func alignedBuffer(size int) []byte {
+	// Returns a byte slice of the given size whose first element is aligned
+	// for direct I/O.
+
+	var (
+		b   = make([]byte, size+directIOAlignment)
+		off = int(uintptr(unsafe.Pointer(&b[0])) & (directIOAlignment - 1))
+	)
+
+	if off > 0 {
+		off = directIOAlignment - off
+	}
+
+	return b[off : off+size : off+size]
+}
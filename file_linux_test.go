@@ -0,0 +1,38 @@
+package bottledlightning
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileFlush(t *testing.T) {
+	var (
+		e    error
+		file = &File{
+			buffer: alignedBuffer(directIOAlignment * 2),
+		}
+	)
+
+	file.file, e = os.Create(
+		filepath.Join(t.TempDir(), "dump"),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer file.file.Close()
+
+	file.n = directIOAlignment + 1
+
+	assert.NoError(t,
+		file.flush(),
+	)
+
+	assert.Equal(t, 1, file.n)
+	assert.Equal(t, int64(directIOAlignment), file.offset)
+
+	return
+}
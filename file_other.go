@@ -0,0 +1,19 @@
+//go:build !linux
+
+package bottledlightning
+
+import (
+	"os"
+)
+
+func (f *File) open(name string, o fileOptions) (e error) {
+	// Opens the named file for writing. Direct I/O and preallocation are not
+	// supported on this platform.
+
+	f.file, e = os.Create(name)
+	if e != nil {
+		return
+	}
+
+	return
+}
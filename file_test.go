@@ -0,0 +1,106 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	var (
+		e    error
+		file *File
+		name = filepath.Join(t.TempDir(), "dump")
+		read []byte
+	)
+
+	file, e = CreateFile(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.NoError(t,
+		NewEncoder(file, nil, WithSyncEvery(1)).
+			Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t,
+		file.Close(),
+	)
+
+	read, e = os.ReadFile(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, []byte{0b01000000, 3, 3, 'k', 'e', 'y', 'v', 'a', 'l'},
+		read,
+	)
+
+	_, e = CreateFile(
+		filepath.Join(name, "impossible"),
+	)
+
+	assert.Error(t, e)
+
+	return
+}
+
+func TestFileDirectIO(t *testing.T) {
+	var (
+		e      error
+		file   *File
+		info   os.FileInfo
+		name   = filepath.Join(t.TempDir(), "dump")
+		read   []byte
+		record = bytes.Repeat([]byte{0xa5}, 3000)
+		i      int
+	)
+
+	file, e = CreateFile(name,
+		WithDirectIO(),
+		WithPreallocation(1<<24),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for i = 0; i < 1000; i++ {
+		_, e = file.Write(record)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if i%100 == 0 {
+			assert.NoError(t,
+				file.Sync(),
+			)
+		}
+	}
+
+	assert.NoError(t,
+		file.Close(),
+	)
+
+	info, e = os.Stat(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, int64(3000000), info.Size())
+
+	read, e = os.ReadFile(name)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t,
+		bytes.Repeat(record, 1000),
+		read,
+	)
+
+	return
+}
@@ -0,0 +1,67 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// A FormatVersion identifies a revision of the framing of streams. A Decoder
+// detects the version of a stream from its first record, and receives streams
+// of either version transparently unless pinned to one by WithFormatVersion.
+type FormatVersion int
+
+const (
+	// FormatV1 is the original framing, of records each with an optional
+	// checksum, as written by Encoders that predate control records. Its
+	// streams begin with a data record and so announce no features.
+	FormatV1 FormatVersion = iota + 1
+
+	// FormatV2 is the framing of streams that begin with a control record,
+	// announcing features such as batch checksums, encryption or compression.
+	// Encoders write streams that use no such feature in FormatV1.
+	FormatV2
+)
+
+// FormatVersion returns the format version of the stream, as detected from
+// its first record, or zero if no record has been received.
+func (d *Decoder) FormatVersion() FormatVersion {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.version
+}
+
+func (d *Decoder) detectVersion(control bool) (e error) {
+	// Detects the format version of the stream from its first record, and
+	// refuses a stream of any other version than that to which the Decoder
+	// is pinned, if any. A stream of FormatV1 carries no announcement of
+	// features, but may hold control records after its first record, such
+	// as keepalives, which a Decoder pinned to FormatV1 refuses as would
+	// the decoders that predate them.
+
+	var (
+		pinned = d.options.formatVersion
+	)
+
+	if d.version == 0 {
+		d.version = FormatV1
+
+		if control {
+			d.version = FormatV2
+		}
+	}
+
+	switch {
+	case pinned != 0 && d.version != pinned:
+		return fmt.Errorf("stream of format version %d, not %d",
+			d.version, pinned,
+		)
+
+	case pinned == FormatV1 && control:
+		return fmt.Errorf("control record in stream of format version %d",
+			FormatV1,
+		)
+	}
+
+	return
+}
@@ -0,0 +1,90 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatVersion(t *testing.T) {
+	var (
+		decoder *Decoder
+		e       error
+		encode  func(...Option) []byte
+		legacy  []byte
+		current []byte
+	)
+
+	encode = func(opts ...Option) []byte {
+		var (
+			buffer  bytes.Buffer
+			encoder = NewEncoder(&buffer, fnv.New32a(), opts...)
+		)
+
+		encoder.Encode([]byte("key"), []byte("val"))
+		encoder.Checkpoint([]byte("id"))
+		encoder.Encode([]byte("yek"), []byte("lav"))
+		encoder.Close()
+
+		return buffer.Bytes()
+	}
+
+	legacy = encode()
+	current = encode(WithBatchChecksum(4))
+
+	decoder = NewDecoder(bytes.NewReader(legacy), fnv.New32a())
+
+	assert.Zero(t, decoder.FormatVersion())
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, FormatV1, decoder.FormatVersion())
+
+	decoder = NewDecoder(bytes.NewReader(current), fnv.New32a())
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, FormatV2, decoder.FormatVersion())
+
+	decoder = NewDecoder(bytes.NewReader(current), fnv.New32a(),
+		WithFormatVersion(FormatV2),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	decoder = NewDecoder(bytes.NewReader(legacy), fnv.New32a(),
+		WithFormatVersion(FormatV2),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "format version 1, not 2")
+
+	decoder = NewDecoder(bytes.NewReader(current), fnv.New32a(),
+		WithFormatVersion(FormatV1),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "format version 2, not 1")
+
+	decoder = NewDecoder(bytes.NewReader(legacy), fnv.New32a(),
+		WithFormatVersion(FormatV1),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "control record in stream of format version 1")
+
+	return
+}
@@ -0,0 +1,239 @@
+package bottledlightning
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TreePath returns the path, relative to the root of a tree of files, of
+// the file holding the value of a record key, for use with ordinary tools,
+// and for golden files. Slashes in the key separate directories. Every byte
+// other than printable ASCII, that is a control character, space, non-ASCII
+// byte or percent sign, is escaped as a percent sign followed by two
+// upper-case hexadecimal digits, as are slashes that would leave a path
+// element empty, such as leading ones, and the dots of path elements that
+// are dots alone. The empty key has no path.
+func TreePath(key []byte) (path string, e error) {
+	var (
+		elements []string
+		i        int
+		segments = strings.Split(string(key), "/")
+	)
+
+	if len(key) == 0 {
+		return "", fmt.Errorf("empty key has no path")
+	}
+
+	// A slash separates elements only if neither is empty; otherwise it
+	// joins them, escaped.
+
+	for i = range segments {
+		if i > 0 && (segments[i] == "" || segments[i-1] == "") {
+			elements[len(elements)-1] += "%2F" +
+				treeEscape(segments[i])
+		} else {
+			elements = append(elements, treeEscape(segments[i]))
+		}
+	}
+
+	for i = range elements {
+		if elements[i] == "." || elements[i] == ".." {
+			elements[i] = strings.Repeat("%2E", len(elements[i]))
+		}
+	}
+
+	return strings.Join(elements, "/"), nil
+}
+
+// TreeKey returns the record key of a path relative to the root of a tree of
+// files, reversing TreePath.
+func TreeKey(path string) (key []byte, e error) {
+	key, e = treeUnescape(path)
+	if e != nil {
+		return
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+
+	return
+}
+
+func treeEscape(segment string) string {
+	// Escapes every byte of the segment other than printable ASCII, and
+	// percent signs, as documented by TreePath.
+
+	var (
+		b       byte
+		builder strings.Builder
+		i       int
+	)
+
+	for i = 0; i < len(segment); i++ {
+		b = segment[i]
+
+		if b > ' ' && b < 0x7f && b != '%' {
+			builder.WriteByte(b)
+
+			continue
+		}
+
+		fmt.Fprintf(&builder, "%%%02X", b)
+	}
+
+	return builder.String()
+}
+
+func treeUnescape(path string) (key []byte, e error) {
+	// Reverses treeEscape, along with the escaped slashes and dots of
+	// TreePath.
+
+	var (
+		i int
+	)
+
+	key = make([]byte, 0, len(path))
+
+	for i = 0; i < len(path); i++ {
+		if path[i] != '%' {
+			key = append(key, path[i])
+
+			continue
+		}
+
+		if i+3 > len(path) {
+			return nil, fmt.Errorf("malformed escape in path %q", path)
+		}
+
+		key, e = hex.AppendDecode(key, []byte(path[i+1:i+3]))
+		if e != nil {
+			return nil, fmt.Errorf("malformed escape in path %q", path)
+		}
+
+		i += 2
+	}
+
+	return
+}
+
+// A TreeWriter materializes records as files under a directory, the value of
+// every record in the file at the TreePath of its key, creating directories
+// as needed, and removing the files of records marked with
+// XMetaFlagTombstone. A key that is a directory of another, such as a and
+// a/b, cannot both be files, and so fails. Metadata other than tombstones is
+// not kept.
+type TreeWriter struct {
+	dir string
+}
+
+// NewTreeWriter returns a TreeWriter of the directory, which is created if
+// it does not exist.
+func NewTreeWriter(dir string) (w *TreeWriter, e error) {
+	e = os.MkdirAll(dir, 0o755)
+	if e != nil {
+		return nil, fmt.Errorf("could not create tree: %w", e)
+	}
+
+	return &TreeWriter{dir}, nil
+}
+
+// Write writes the value of a record to its file, or removes the file of a
+// tombstone.
+func (w *TreeWriter) Write(key, val []byte, xmv XMetaValue) (e error) {
+	defer errorf("could not write tree file", &e)
+
+	var (
+		path string
+	)
+
+	path, e = TreePath(key)
+	if e != nil {
+		return
+	}
+
+	path = filepath.Join(w.dir, filepath.FromSlash(path))
+
+	if xmv.HasFlag(XMetaFlagTombstone) {
+		e = os.Remove(path)
+		if errors.Is(e, fs.ErrNotExist) {
+			return nil
+		}
+
+		return
+	}
+
+	e = os.MkdirAll(filepath.Dir(path), 0o755)
+	if e != nil {
+		return
+	}
+
+	return os.WriteFile(path, val, 0o644)
+}
+
+// A TreeReader reads a tree of files, such as one written by a TreeWriter,
+// as records, the key of every regular file being the TreeKey of its path.
+// Files are read one at a time, in the order in which fs.WalkDir visits them,
+// which is lexical by escaped path element, and so not the byte order of
+// their keys: the records are not sorted by key. Other files, such as
+// symbolic links, are skipped.
+type TreeReader struct {
+	fsys  fs.FS
+	paths []string
+}
+
+// NewTreeReader returns a TreeReader of the file system, such as one
+// returned by os.DirFS, having listed its files.
+func NewTreeReader(fsys fs.FS) (r *TreeReader, e error) {
+	r = &TreeReader{
+		fsys: fsys,
+	}
+
+	e = fs.WalkDir(fsys, ".",
+		func(path string, entry fs.DirEntry, e error) error {
+			if e != nil {
+				return e
+			}
+
+			if entry.Type().IsRegular() {
+				r.paths = append(r.paths, path)
+			}
+
+			return nil
+		},
+	)
+	if e != nil {
+		return nil, fmt.Errorf("could not list tree: %w", e)
+	}
+
+	return
+}
+
+// Read returns the next record, or io.EOF after the last.
+func (r *TreeReader) Read() (key, val []byte, e error) {
+	if len(r.paths) == 0 {
+		return nil, nil, io.EOF
+	}
+
+	key, e = TreeKey(r.paths[0])
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not read tree file %s: %w",
+			r.paths[0], e,
+		)
+	}
+
+	val, e = fs.ReadFile(r.fsys, r.paths[0])
+	if e != nil {
+		return nil, nil, fmt.Errorf("could not read tree file: %w", e)
+	}
+
+	r.paths = r.paths[1:]
+
+	return
+}
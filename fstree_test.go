@@ -0,0 +1,118 @@
+package bottledlightning
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreePath(t *testing.T) {
+	var (
+		e    error
+		key  []byte
+		path string
+		test struct {
+			key  string
+			path string
+		}
+	)
+
+	for _, test = range []struct {
+		key  string
+		path string
+	}{
+		{"a", "a"},
+		{"a/b/c", "a/b/c"},
+		{"/a", "%2Fa"},
+		{"a/", "a%2F"},
+		{"a//b", "a%2F%2Fb"},
+		{"/", "%2F"},
+		{"./..", "%2E/%2E%2E"},
+		{"a b%\x00\xff", "a%20b%25%00%FF"},
+	} {
+		path, e = TreePath([]byte(test.key))
+		assert.NoError(t, e)
+		assert.Equal(t, test.path, path)
+
+		key, e = TreeKey(path)
+		assert.NoError(t, e)
+		assert.Equal(t, test.key, string(key))
+	}
+
+	_, e = TreePath(nil)
+	assert.Error(t, e)
+
+	for _, path = range []string{"", "a%2", "a%zz"} {
+		_, e = TreeKey(path)
+		assert.Error(t, e, path)
+	}
+
+	return
+}
+
+func TestTree(t *testing.T) {
+	var (
+		dir       = t.TempDir()
+		e         error
+		key       []byte
+		reader    *TreeReader
+		records   []string
+		tombstone = NewXMetaValue(0, XMetaFlagTombstone)
+		val       []byte
+		writer    *TreeWriter
+	)
+
+	writer, e = NewTreeWriter(filepath.Join(dir, "tree"))
+	assert.NoError(t, e)
+
+	assert.NoError(t,
+		writer.Write([]byte("users/1"), []byte("ann"), 0),
+	)
+	assert.NoError(t,
+		writer.Write([]byte("users/2"), []byte("bob"), 0),
+	)
+	assert.NoError(t,
+		writer.Write([]byte("/etc/..."), []byte("x"), 0),
+	)
+	assert.NoError(t,
+		writer.Write([]byte("users/2"), nil, tombstone),
+	)
+	assert.NoError(t,
+		writer.Write([]byte("missing"), nil, tombstone),
+	)
+	assert.Error(t,
+		writer.Write([]byte("users/1/a"), []byte("y"), 0),
+	)
+
+	val, e = os.ReadFile(filepath.Join(dir, "tree", "users", "1"))
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("ann"), val)
+
+	// Links, and other files that are not regular, are skipped.
+
+	assert.NoError(t,
+		os.Symlink("users/1", filepath.Join(dir, "tree", "link")),
+	)
+
+	reader, e = NewTreeReader(os.DirFS(filepath.Join(dir, "tree")))
+	assert.NoError(t, e)
+
+	for {
+		key, val, e = reader.Read()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		records = append(records, string(key)+"="+string(val))
+	}
+
+	assert.Equal(t, []string{"/etc/...=x", "users/1=ann"}, records)
+
+	return
+}
@@ -0,0 +1,196 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// Arithmetic in GF(2^8) modulo the primitive polynomial x^8+x^4+x^3+x^2+1, and
+// the matrices over it from which Reed-Solomon codes are constructed.
+
+var (
+	gfExp [510]byte
+	gfLog [256]int
+)
+
+func init() {
+	var (
+		i int
+		x = 1
+	)
+
+	for i = 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+
+		x <<= 1
+
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfDiv(a, b byte) byte {
+	// Returns a/b; b must not be zero.
+
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[gfLog[a]+255-gfLog[b]]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[(gfLog[a]*n)%255]
+}
+
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) (m gfMatrix) {
+	var (
+		i int
+	)
+
+	m = make(gfMatrix, rows)
+
+	for i = range m {
+		m[i] = make([]byte, cols)
+	}
+
+	return
+}
+
+func newGFCodingMatrix(dataShards, totalShards int) (m gfMatrix, e error) {
+	// Returns a totalShards x dataShards matrix whose top dataShards rows form
+	// the identity, so that the code is systematic, and any dataShards rows of
+	// which are linearly independent, so that any dataShards shards suffice to
+	// reconstruct the data. It is derived from a Vandermonde matrix by
+	// multiplication with the inverse of its top square.
+
+	var (
+		i, j int
+		top  gfMatrix
+		v    = newGFMatrix(totalShards, dataShards)
+	)
+
+	for i = 0; i < totalShards; i++ {
+		for j = 0; j < dataShards; j++ {
+			v[i][j] = gfPow(byte(i), j)
+		}
+	}
+
+	top, e = v[:dataShards].invert()
+	if e != nil {
+		return
+	}
+
+	m = v.multiply(top)
+
+	return
+}
+
+func (m gfMatrix) multiply(n gfMatrix) (p gfMatrix) {
+	var (
+		i, j, k int
+	)
+
+	p = newGFMatrix(len(m), len(n[0]))
+
+	for i = range m {
+		for j = range n[0] {
+			for k = range n {
+				p[i][j] ^= gfMul(m[i][k], n[k][j])
+			}
+		}
+	}
+
+	return
+}
+
+func (m gfMatrix) invert() (inverse gfMatrix, e error) {
+	// Inverts square matrix m by Gauss-Jordan elimination.
+
+	var (
+		i, j, r int
+		n       = len(m)
+		pivot   byte
+		scale   byte
+		work    = newGFMatrix(n, 2*n)
+	)
+
+	for i = 0; i < n; i++ {
+		copy(work[i], m[i])
+
+		work[i][n+i] = 1
+	}
+
+	for i = 0; i < n; i++ {
+		for r = i; r < n && work[r][i] == 0; r++ {
+		}
+
+		if r == n {
+			return nil, fmt.Errorf("singular matrix")
+		}
+
+		work[i], work[r] = work[r], work[i]
+
+		pivot = work[i][i]
+
+		for j = range work[i] {
+			work[i][j] = gfDiv(work[i][j], pivot)
+		}
+
+		for r = 0; r < n; r++ {
+			if r == i || work[r][i] == 0 {
+				continue
+			}
+
+			scale = work[r][i]
+
+			for j = range work[r] {
+				work[r][j] ^= gfMul(scale, work[i][j])
+			}
+		}
+	}
+
+	inverse = newGFMatrix(n, n)
+
+	for i = 0; i < n; i++ {
+		copy(inverse[i], work[i][n:])
+	}
+
+	return
+}
+
+func gfMulAdd(dst, src []byte, c byte) {
+	// Adds c times src to dst, element-wise.
+
+	var (
+		i int
+	)
+
+	if c == 0 {
+		return
+	}
+
+	for i = range src {
+		dst[i] ^= gfMul(c, src[i])
+	}
+}
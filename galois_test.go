@@ -0,0 +1,43 @@
+package bottledlightning
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGaloisInvert(t *testing.T) {
+	var (
+		coding  gfMatrix
+		e       error
+		inverse gfMatrix
+		rows    gfMatrix
+	)
+
+	coding, e = newGFCodingMatrix(4, 7)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t,
+		gfMatrix{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}},
+		coding[:4],
+	)
+
+	rows = gfMatrix{coding[1], coding[4], coding[5], coding[6]}
+
+	inverse, e = rows.invert()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, coding[:4],
+		inverse.multiply(rows),
+	)
+
+	_, e = gfMatrix{{1, 2}, {2, 4}}.invert()
+
+	assert.Error(t, e)
+
+	return
+}
@@ -0,0 +1,278 @@
+package bottledlightning
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+)
+
+// A GarbageCollection configures Catalog.CollectGarbage.
+type GarbageCollection struct {
+	// Retain lists the snapshots to retain, along with the snapshots of
+	// which they are increments. It must not be empty.
+	Retain []string
+
+	// Blobs is the store to which the streams of the catalog spill values,
+	// if any.
+	Blobs CollectableBlobStore
+
+	// Remove removes the file of the catalog at the given path, such as by
+	// [os.Remove] once joined to the directory of the catalog. If Remove is
+	// nil, files are reported but not removed.
+	Remove func(name string) error
+
+	// NewHasher returns the [hash.Hash32] with which the streams were
+	// encoded, if any, and Options configure their Decoders, such as with
+	// WithDecryption.
+	NewHasher func() hash.Hash32
+	Options   []Option
+
+	// DryRun causes CollectGarbage to report what it would delete without
+	// deleting anything.
+	DryRun bool
+}
+
+// A GarbageReport describes what Catalog.CollectGarbage deleted, or would
+// delete in a dry run.
+type GarbageReport struct {
+	// Retained lists the snapshots retained, in order of creation.
+	Retained []string
+
+	// Files lists the paths of the files of snapshots not retained.
+	Files []string
+
+	// Blobs lists the digests of the blobs referenced by no stream of a
+	// retained snapshot, and Referenced counts those that are.
+	Blobs      [][]byte
+	Referenced int
+}
+
+// CollectGarbage deletes the files of the snapshots of the catalog that are
+// not retained, and the blobs of the store that no stream of a retained
+// snapshot references, as configured, and reports them. Every retained
+// snapshot must be restorable, and every stream of it readable, so that
+// nothing is deleted on the strength of an incomplete scan; otherwise an
+// error is returned before anything is deleted. Files are deleted before
+// blobs, so that an interrupted collection never leaves a catalogued
+// snapshot without its blobs.
+//
+// Blobs spilled by an Encoder whose snapshot is not yet catalogued are not
+// referenced, so CollectGarbage must not run concurrently with Encoders
+// spilling to the same store.
+func (c *Catalog) CollectGarbage(gc GarbageCollection) (
+	report GarbageReport, e error,
+) {
+	defer errorf("could not collect garbage", &e)
+
+	var (
+		chain      []CatalogEntry
+		digest     []byte
+		entry      CatalogEntry
+		name       string
+		referenced = make(map[string]bool)
+		retained   = make(map[string]bool)
+		retain     []string
+		snapshot   string
+		used       = make(map[string]bool)
+	)
+
+	if len(gc.Retain) == 0 {
+		return report, fmt.Errorf("no snapshot to retain")
+	}
+
+	for _, snapshot = range gc.Retain {
+		chain, e = c.Chain(snapshot)
+		if e != nil {
+			return
+		}
+
+		for _, entry = range chain {
+			retained[entry.Manifest.Snapshot] = true
+
+			for _, name = range entry.Files() {
+				used[name] = true
+			}
+		}
+	}
+
+	for _, entry = range c.entries {
+		if retained[entry.Manifest.Snapshot] {
+			retain = append(retain, entry.Manifest.Snapshot)
+
+			e = c.referenceBlobs(entry, gc, referenced)
+			if e != nil {
+				return
+			}
+
+			continue
+		}
+
+		for _, name = range entry.Files() {
+			if !used[name] && !slices.Contains(entry.Missing, name) {
+				report.Files = append(report.Files, name)
+			}
+		}
+	}
+
+	report.Retained = retain
+	report.Referenced = len(referenced)
+
+	if gc.Blobs != nil {
+		e = gc.Blobs.Blobs(
+			func(digest []byte) error {
+				if !referenced[string(digest)] {
+					report.Blobs = append(report.Blobs, digest)
+				}
+
+				return nil
+			},
+		)
+		if e != nil {
+			return
+		}
+	}
+
+	if gc.DryRun {
+		return
+	}
+
+	if gc.Remove != nil {
+		for _, name = range report.Files {
+			e = gc.Remove(name)
+			if e != nil {
+				return
+			}
+		}
+	}
+
+	for _, digest = range report.Blobs {
+		e = gc.Blobs.Delete(digest)
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (c *Catalog) referenceBlobs(entry CatalogEntry, gc GarbageCollection,
+	referenced map[string]bool,
+) (e error) {
+	// Notes the digests of the blobs referenced by the streams of the
+	// snapshot.
+
+	var (
+		artifact string
+		bundle   *BundleReader
+		file     io.ReadCloser
+		name     string
+		names    = make(map[string]bool)
+		stream   ManifestStream
+	)
+
+	for _, stream = range entry.Manifest.Streams {
+		names[stream.Name] = true
+	}
+
+	if !entry.Bundle {
+		for _, name = range entry.Files()[1:] {
+			file, e = c.fsys.Open(name)
+			if e != nil {
+				return
+			}
+
+			e = readBlobReferences(file, gc, referenced)
+
+			file.Close()
+
+			if e != nil {
+				return fmt.Errorf("%s: %w", name, e)
+			}
+		}
+
+		return
+	}
+
+	file, e = c.fsys.Open(entry.Path)
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	bundle = NewBundleReader(file)
+
+	// A stream named by the manifest but missing from the bundle would leave
+	// the scan incomplete.
+
+	for {
+		artifact, e = bundle.Next()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		if !names[artifact] {
+			continue
+		}
+
+		delete(names, artifact)
+
+		e = readBlobReferences(bundle, gc, referenced)
+		if e != nil {
+			return fmt.Errorf("%s: %s: %w", entry.Path, artifact, e)
+		}
+	}
+
+	for _, stream = range entry.Manifest.Streams {
+		if names[stream.Name] {
+			return fmt.Errorf("%s: stream %s missing", entry.Path,
+				stream.Name,
+			)
+		}
+	}
+
+	return nil
+}
+
+func readBlobReferences(reader io.Reader, gc GarbageCollection,
+	referenced map[string]bool,
+) (e error) {
+	// Notes the digests of the blobs referenced by the stream read from
+	// reader.
+
+	var (
+		decoder *Decoder
+		hasher  hash.Hash32
+	)
+
+	if gc.NewHasher != nil {
+		hasher = gc.NewHasher()
+	}
+
+	decoder = NewDecoder(reader, hasher,
+		append(slices.Clip(gc.Options),
+			func(o *options) {
+				o.blobReferences = func(digest []byte) {
+					referenced[string(digest)] = true
+				}
+			},
+		)...,
+	)
+
+	for {
+		_, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			return nil
+		}
+
+		if e != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	var (
+		blobs   int
+		catalog *Catalog
+		dir     = t.TempDir()
+		e       error
+		report  GarbageReport
+		store   *DirBlobStore
+		stream  []byte
+	)
+
+	store, e = NewDirBlobStore(
+		filepath.Join(t.TempDir(), "blobs"),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	gcTestSnapshot(t, dir, store, "full", "", 0, []byte("old"))
+	gcTestSnapshot(t, dir, store, "incr", "full", time.Hour, []byte("kept"))
+	gcTestSnapshot(t, dir, store, "next", "", 2*time.Hour, []byte("new"))
+
+	catalog, e = OpenCatalog(
+		os.DirFS(dir),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	_, e = catalog.CollectGarbage(GarbageCollection{})
+	assert.Error(t, e)
+
+	_, e = catalog.CollectGarbage(
+		GarbageCollection{
+			Retain: []string{"missing"},
+		},
+	)
+	assert.Error(t, e)
+
+	// Retaining an increment retains its parent, and so the blob that only
+	// the parent references.
+
+	report, e = catalog.CollectGarbage(
+		GarbageCollection{
+			Retain: []string{"incr"},
+			Blobs:  store,
+			Remove: func(name string) error {
+				return os.Remove(
+					filepath.Join(dir, filepath.FromSlash(name)),
+				)
+			},
+			DryRun: true,
+		},
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, []string{"full", "incr"}, report.Retained)
+	assert.Equal(t,
+		[]string{"next/manifest.json", "next/main.bl"},
+		report.Files,
+	)
+	assert.Len(t, report.Blobs, 1)
+	assert.Equal(t, 2, report.Referenced)
+
+	assert.FileExists(t,
+		filepath.Join(dir, "next", "main.bl"),
+	)
+
+	report, e = catalog.CollectGarbage(
+		GarbageCollection{
+			Retain: []string{"incr"},
+			Blobs:  store,
+			Remove: func(name string) error {
+				return os.Remove(
+					filepath.Join(dir, filepath.FromSlash(name)),
+				)
+			},
+		},
+	)
+	assert.NoError(t, e)
+	assert.Len(t, report.Blobs, 1)
+
+	assert.NoFileExists(t,
+		filepath.Join(dir, "next", "main.bl"),
+	)
+
+	assert.NoError(t,
+		store.Blobs(
+			func([]byte) error {
+				blobs++
+
+				return nil
+			},
+		),
+	)
+	assert.Equal(t, 2, blobs)
+
+	catalog, e = OpenCatalog(
+		os.DirFS(dir),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Len(t,
+		catalog.Snapshots(), 2,
+	)
+
+	stream, e = os.ReadFile(
+		filepath.Join(dir, "incr", "main.bl"),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	_, _, e = NewDecoder(bytes.NewReader(stream), nil,
+		WithBlobStore(store, 0),
+	).Decode()
+	assert.NoError(t, e)
+
+	return
+}
+
+func TestCollectGarbageMissingStream(t *testing.T) {
+	// A bundle found missing a stream of its manifest when scanned, as when
+	// rewritten since the catalog was opened, fails the collection before
+	// the blobs that stream may reference are deleted.
+
+	var (
+		blobs    int
+		catalog  *Catalog
+		dir      = t.TempDir()
+		e        error
+		fsys     fstest.MapFS
+		manifest []byte
+		store    *DirBlobStore
+		stream   []byte
+	)
+
+	store, e = NewDirBlobStore(
+		filepath.Join(t.TempDir(), "blobs"),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	gcTestSnapshot(t, dir, store, "full", "", 0, []byte("kept"))
+
+	manifest, e = os.ReadFile(
+		filepath.Join(dir, "full", ManifestName),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	stream, e = os.ReadFile(
+		filepath.Join(dir, "full", "main.bl"),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	fsys = fstest.MapFS{
+		"full.tar": gcTestBundle(t, ManifestName, string(manifest),
+			"main.bl", string(stream),
+		),
+	}
+
+	catalog, e = OpenCatalog(fsys)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	fsys["full.tar"] = gcTestBundle(t, ManifestName, string(manifest))
+
+	_, e = catalog.CollectGarbage(
+		GarbageCollection{
+			Retain: []string{"full"},
+			Blobs:  store,
+		},
+	)
+	assert.ErrorContains(t, e, "stream main.bl missing")
+
+	assert.NoError(t,
+		store.Blobs(
+			func([]byte) error {
+				blobs++
+
+				return nil
+			},
+		),
+	)
+	assert.Equal(t, 1, blobs)
+
+	return
+}
+
+func gcTestBundle(t *testing.T, artifacts ...string) *fstest.MapFile {
+	// Returns a bundle of the artifacts, given as alternating names and
+	// contents.
+
+	var (
+		bundle bytes.Buffer
+		i      int
+		writer = NewBundleWriter(&bundle)
+	)
+
+	for i = 0; i < len(artifacts); i += 2 {
+		assert.NoError(t,
+			writer.Add(artifacts[i],
+				strings.NewReader(artifacts[i+1]),
+				int64(len(artifacts[i+1])),
+			),
+		)
+	}
+
+	assert.NoError(t,
+		writer.Close(),
+	)
+
+	return &fstest.MapFile{Data: bundle.Bytes()}
+}
+
+func gcTestSnapshot(t *testing.T, dir string, store BlobStore,
+	snapshot, parent string, created time.Duration, val []byte,
+) {
+	// Writes a snapshot of one stream, spilling its one value as a blob.
+
+	var (
+		buffer   bytes.Buffer
+		e        error
+		encoder  *Encoder
+		manifest bytes.Buffer
+		stream   ManifestStream
+	)
+
+	encoder = NewEncoder(&buffer, nil, WithBlobStore(store, 1))
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), val),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	stream, e = DescribeStream("main.bl", "",
+		bytes.NewReader(buffer.Bytes()), nil,
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.NoError(t,
+		WriteManifest(&manifest,
+			Manifest{
+				Environment: "env",
+				Snapshot:    snapshot,
+				Parent:      parent,
+				Created: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).
+					Add(created),
+				Streams: []ManifestStream{stream},
+			},
+		),
+	)
+
+	assert.NoError(t,
+		os.MkdirAll(filepath.Join(dir, snapshot), 0o755),
+	)
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, snapshot, "main.bl"),
+			buffer.Bytes(), 0o644,
+		),
+	)
+	assert.NoError(t,
+		os.WriteFile(filepath.Join(dir, snapshot, ManifestName),
+			manifest.Bytes(), 0o644,
+		),
+	)
+
+	return
+}
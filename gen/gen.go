@@ -0,0 +1,222 @@
+// Package gen generates synthetic workloads of LMDB key-value records, for
+// benchmarking and testing Encoders and Decoders.
+package gen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+)
+
+const (
+	lmdbMaxKeyLen = 511
+	recentLen     = 16
+)
+
+// A Distribution is a distribution of key or value lengths.
+type Distribution interface {
+	// Sample returns a length drawn from the distribution using the source of
+	// randomness.
+	Sample(r *rand.Rand) int
+}
+
+// Fixed returns the Distribution of lengths that are always n.
+func Fixed(n int) Distribution {
+	return fixed(n)
+}
+
+type fixed int
+
+func (d fixed) Sample(*rand.Rand) int {
+	return int(d)
+}
+
+// Uniform returns the Distribution of lengths uniformly distributed between
+// low and high inclusive.
+func Uniform(low, high int) Distribution {
+	return uniform{low, high}
+}
+
+type uniform struct {
+	low, high int
+}
+
+func (d uniform) Sample(r *rand.Rand) int {
+	return d.low + r.IntN(d.high-d.low+1)
+}
+
+// Exponential returns the Distribution of lengths exponentially distributed
+// with the given mean, and truncated at high, which models workloads of
+// mostly small values with a long tail of large ones.
+func Exponential(mean float64, high int) Distribution {
+	return exponential{mean, high}
+}
+
+type exponential struct {
+	mean float64
+	high int
+}
+
+func (d exponential) Sample(r *rand.Rand) int {
+	return min(
+		int(math.Round(r.ExpFloat64()*d.mean)),
+		d.high,
+	)
+}
+
+// A Workload describes a synthetic stream of records.
+type Workload struct {
+	// Records is the number of records in the stream.
+	Records int
+
+	// KeyLen and ValLen are the distributions of key and value lengths. Key
+	// lengths are truncated at the LMDB maximum of 511 bytes.
+	KeyLen Distribution
+	ValLen Distribution
+
+	// XMetaValues is the number of distinct extended metadata values, up to
+	// the 8 that differ only in their user bits, drawn uniformly for each
+	// record. If it is zero, every record carries XMetaValue0.
+	XMetaValues int
+
+	// Sorted causes keys to be generated in strictly ascending order, as
+	// LMDB would return them, by prefixing each with the ordinal of its record
+	// in big-endian, as few bytes wide as the number of Records allows. Keys
+	// are then no shorter than the prefix.
+	Sorted bool
+
+	// DuplicateRatio is the proportion, between 0 and 1, of records whose
+	// value repeats that of one of the 16 most recent records with a fresh
+	// value, for exercising deduplication.
+	DuplicateRatio float64
+
+	// Seed determines the pseudo-random sequence from which the records are
+	// drawn, so that a Workload always generates the same records.
+	Seed uint64
+}
+
+// A Generator generates the records of a Workload in turn.
+type Generator struct {
+	workload Workload
+	rand     *rand.Rand
+	n        int
+	recent   [][]byte
+	width    int
+}
+
+// NewGenerator returns a new Generator of the records of the Workload.
+func NewGenerator(workload Workload) (g *Generator) {
+	g = &Generator{
+		workload: workload,
+		rand: rand.New(
+			rand.NewPCG(workload.Seed, workload.Seed),
+		),
+	}
+
+	if workload.Sorted {
+		for g.width = 1; g.width < 8 && workload.Records>>(8*g.width) > 0; {
+			g.width++
+		}
+	}
+
+	return
+}
+
+// Next returns the next record of the Workload, or ok false if there are no
+// more. The key and value are newly allocated.
+func (g *Generator) Next() (key, val []byte, ok bool) {
+	key, val, _, ok = g.NextX()
+
+	return
+}
+
+// NextX is a variant of Next that also returns the extended metadata value of
+// the record.
+func (g *Generator) NextX() (key, val []byte, xmv byte, ok bool) {
+	if g.n == g.workload.Records {
+		return
+	}
+
+	key = g.key()
+
+	val = g.val()
+
+	if g.workload.XMetaValues > 0 {
+		xmv = byte(
+			g.rand.IntN(min(g.workload.XMetaValues, 8)),
+		)
+	}
+
+	g.n++
+
+	return key, val, xmv, true
+}
+
+func (g *Generator) key() (key []byte) {
+	var (
+		i int
+	)
+
+	key = g.bytes(
+		min(g.workload.KeyLen.Sample(g.rand), lmdbMaxKeyLen),
+	)
+
+	if !g.workload.Sorted {
+		return
+	}
+
+	if len(key) < g.width {
+		key = append(key, make([]byte, g.width-len(key))...)
+	}
+
+	for i = 0; i < g.width; i++ {
+		key[i] = byte(g.n >> (8 * (g.width - 1 - i)))
+	}
+
+	return
+}
+
+func (g *Generator) val() (val []byte) {
+	if len(g.recent) > 0 && g.rand.Float64() < g.workload.DuplicateRatio {
+		return bytes.Clone(
+			g.recent[g.rand.IntN(len(g.recent))],
+		)
+	}
+
+	val = g.bytes(
+		g.workload.ValLen.Sample(g.rand),
+	)
+
+	if g.workload.DuplicateRatio > 0 {
+		if len(g.recent) == recentLen {
+			g.recent = g.recent[1:]
+		}
+
+		g.recent = append(g.recent,
+			bytes.Clone(val),
+		)
+	}
+
+	return
+}
+
+func (g *Generator) bytes(n int) (b []byte) {
+	// Returns n pseudo-random bytes.
+
+	var (
+		i int
+	)
+
+	b = make([]byte, n)
+
+	for i = 0; i+8 <= n; i += 8 {
+		binary.LittleEndian.PutUint64(b[i:], g.rand.Uint64())
+	}
+
+	for ; i < n; i++ {
+		b[i] = byte(g.rand.Uint32())
+	}
+
+	return
+}
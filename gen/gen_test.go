@@ -0,0 +1,132 @@
+package gen
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistributions(t *testing.T) {
+	var (
+		i     int
+		l     int
+		r     = rand.New(rand.NewPCG(1, 1))
+		total int
+	)
+
+	assert.Equal(t, 7, Fixed(7).Sample(r))
+
+	for i = 0; i < 10000; i++ {
+		l = Uniform(3, 5).Sample(r)
+
+		assert.GreaterOrEqual(t, l, 3)
+		assert.LessOrEqual(t, l, 5)
+
+		l = Exponential(100, 1000).Sample(r)
+
+		assert.GreaterOrEqual(t, l, 0)
+		assert.LessOrEqual(t, l, 1000)
+
+		total += l
+	}
+
+	assert.InDelta(t, 100, float64(total)/10000, 5)
+
+	return
+}
+
+func TestGenerator(t *testing.T) {
+	var (
+		a, b       *Generator
+		key, val   []byte
+		key2, val2 []byte
+		n          int
+		ok         bool
+		workload   = Workload{
+			Records: 1000,
+			KeyLen:  Uniform(600, 700),
+			ValLen:  Exponential(100, 300),
+			Seed:    42,
+		}
+	)
+
+	a = NewGenerator(workload)
+	b = NewGenerator(workload)
+
+	for {
+		key, val, ok = a.Next()
+		if !ok {
+			break
+		}
+
+		n++
+
+		assert.Len(t, key, lmdbMaxKeyLen) // truncated
+		assert.LessOrEqual(t, len(val), 300)
+
+		key2, val2, ok = b.Next()
+
+		assert.True(t, ok)
+		assert.Equal(t, key, key2) // deterministic
+		assert.Equal(t, val, val2)
+	}
+
+	assert.Equal(t, workload.Records, n)
+
+	key, _, _ = NewGenerator(workload).Next()
+
+	workload.Seed++
+
+	key2, _, _ = NewGenerator(workload).Next()
+
+	assert.NotEqual(t, key, key2)
+
+	return
+}
+
+func TestGeneratorShape(t *testing.T) {
+	var (
+		duplicates int
+		generator  = NewGenerator(
+			Workload{
+				Records:        10000,
+				KeyLen:         Uniform(0, 4),
+				ValLen:         Fixed(100),
+				XMetaValues:    3,
+				Sorted:         true,
+				DuplicateRatio: 0.25,
+			},
+		)
+		key, prev []byte
+		ok        bool
+		seen      = make(map[string]bool)
+		val       []byte
+		xmv       byte
+		xmvs      = make(map[byte]bool)
+	)
+
+	for {
+		key, val, xmv, ok = generator.NextX()
+		if !ok {
+			break
+		}
+
+		assert.GreaterOrEqual(t, len(key), 2)
+		assert.Less(t, string(prev), string(key))
+
+		if seen[string(val)] {
+			duplicates++
+		}
+
+		seen[string(val)] = true
+		xmvs[xmv] = true
+
+		prev = key
+	}
+
+	assert.InDelta(t, 2500, duplicates, 250)
+	assert.Equal(t, map[byte]bool{0: true, 1: true, 2: true}, xmvs)
+
+	return
+}
@@ -0,0 +1,109 @@
+package bottledlightning
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A Header holds the fields of the header of a record, as laid out in the
+// documentation of Encoder, so that integrations such as proxies can inspect
+// and route records without decoding their keys and values.
+type Header struct {
+	// X is the number of bytes, from 1 to 4, representing ValLen.
+	X int
+
+	// Checksummed indicates the presence of a 32-bit checksum trailing the
+	// value.
+	Checksummed bool
+
+	Meta   XMetaValue
+	KeyLen int
+	ValLen int
+}
+
+// ParseHeader parses the header at the start of b, which may extend beyond it.
+// It returns an error wrapping [io.ErrUnexpectedEOF] if b is too short to hold
+// the whole header.
+func ParseHeader(b []byte) (h Header, e error) {
+	var (
+		v    = make([]byte, maxUintLen32)
+		xcmk uint16
+	)
+
+	if len(b) < 2 {
+		return h, fmt.Errorf("could not parse header: %w", io.ErrUnexpectedEOF)
+	}
+
+	xcmk = binary.BigEndian.Uint16(b)
+
+	h.X = int(xcmk >> offsetX)
+
+	if h.X == 0 {
+		h.X = 4
+	}
+
+	h.Checksummed = (xcmk>>offsetC)&1 == 1
+	h.Meta = XMetaValue(xcmk>>offsetM) & XMetaValueF
+	h.KeyLen = int(xcmk & lmdbMaxKeyLen)
+
+	if len(b) < h.Len() {
+		return h, fmt.Errorf("could not parse header: %w", io.ErrUnexpectedEOF)
+	}
+
+	copy(v[maxUintLen32-h.X:], b[2:h.Len()])
+
+	h.ValLen = int(binary.BigEndian.Uint32(v))
+
+	return
+}
+
+// Append appends the encoded header to b and returns the extended slice. If X
+// is zero, the fewest bytes that can represent ValLen are used. Fields are not
+// validated, but truncated to their widths.
+func (h Header) Append(b []byte) []byte {
+	var (
+		c uint16
+		x = h.X
+	)
+
+	if x == 0 {
+		x = findX(h.ValLen)
+	}
+
+	if h.Checksummed {
+		c = 1 << offsetC
+	}
+
+	b = binary.BigEndian.AppendUint16(b,
+		uint16(x%4)<<offsetX|c|uint16(h.Meta&XMetaValueF)<<offsetM|
+			uint16(h.KeyLen&lmdbMaxKeyLen),
+	)
+
+	return append(b,
+		binary.BigEndian.AppendUint32(nil, uint32(h.ValLen))[maxUintLen32-x:]...,
+	)
+}
+
+// Len returns the length of the encoded header in bytes.
+func (h Header) Len() int {
+	return 2 + h.X
+}
+
+// RecordLen returns the length in bytes of the whole record that the header
+// begins, including any checksum.
+func (h Header) RecordLen() (l int) {
+	l = h.Len() + h.KeyLen + h.ValLen
+
+	if h.Checksummed {
+		l += crcLen
+	}
+
+	return
+}
+
+// IsControl returns true if the header begins a control record, which carries
+// information about the stream rather than LMDB data.
+func (h Header) IsControl() bool {
+	return isControl(h.X, h.KeyLen, h.ValLen)
+}
@@ -0,0 +1,66 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		e      error
+		header Header
+	)
+
+	assert.NoError(t,
+		NewEncoder(&buffer, fnv.New32a()).EncodeX([]byte("key"),
+			make([]byte, 300), XMetaValueB,
+		),
+	)
+
+	header, e = ParseHeader(buffer.Bytes())
+
+	assert.NoError(t, e)
+	assert.Equal(t,
+		Header{
+			X:           2,
+			Checksummed: true,
+			Meta:        XMetaValueB,
+			KeyLen:      3,
+			ValLen:      300,
+		},
+		header,
+	)
+
+	assert.Equal(t, 4, header.Len())
+	assert.Equal(t, buffer.Len(), header.RecordLen())
+	assert.False(t, header.IsControl())
+
+	assert.Equal(t, buffer.Bytes()[:4], header.Append(nil))
+
+	header.X = 0
+	header.ValLen = 1 << 20
+
+	assert.Equal(t, 3, len(header.Append(nil))-2)
+
+	header, e = ParseHeader(
+		Header{X: 4, ValLen: 9}.Append(nil),
+	)
+
+	assert.NoError(t, e)
+	assert.True(t, header.IsControl())
+
+	_, e = ParseHeader(buffer.Bytes()[:3])
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	_, e = ParseHeader(nil)
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	return
+}
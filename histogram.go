@@ -0,0 +1,139 @@
+package bottledlightning
+
+import (
+	"math/bits"
+)
+
+// A SizeHistogram counts records by a size in bytes, in buckets of powers of
+// two: Buckets[0] counts sizes of zero, and Buckets[i], for i > 0, sizes of at
+// least 2^(i-1) and less than 2^i bytes.
+type SizeHistogram struct {
+	Buckets [34]int
+}
+
+// Count returns the number of records counted.
+func (h SizeHistogram) Count() (count int) {
+	var (
+		n int
+	)
+
+	for _, n = range h.Buckets {
+		count += n
+	}
+
+	return
+}
+
+// Quantile returns an upper bound on the q-quantile of the sizes counted, for
+// 0 <= q <= 1, namely the greatest size of the bucket into which it falls,
+// or zero if no record is counted. Quantile(0.99) is, for example, a size no
+// more than twice that at or under which 99% of the records fall.
+func (h SizeHistogram) Quantile(q float64) int64 {
+	var (
+		count = h.Count()
+		i     int
+		rank  int
+		seen  int
+	)
+
+	if count == 0 {
+		return 0
+	}
+
+	rank = max(int(q*float64(count)+0.5), 1)
+
+	for i = range h.Buckets {
+		seen += h.Buckets[i]
+
+		if seen >= rank {
+			break
+		}
+	}
+
+	return bucketMax(i)
+}
+
+// SizeStats describes the sizes of the keys and values of the records most
+// recently transmitted by an Encoder or returned by a Decoder, up to Window of
+// them, as given to the Encoder or returned by the Decoder rather than as
+// transmitted, for adaptive decisions such as the sizing of batches, buffers
+// and compression thresholds, and for capacity planning. A Decoder reads no
+// further than the record it returns, so a caller wanting read-ahead can wrap
+// its reader in a [bufio.Reader] sized, for example, to Values.Quantile(0.99).
+type SizeStats struct {
+	Keys   SizeHistogram
+	Values SizeHistogram
+	Window int
+}
+
+// SizeStats returns histograms of the sizes of the keys and values of the
+// records most recently transmitted.
+func (n *Encoder) SizeStats() (stats SizeStats) {
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	stats.Keys = n.sizes.keys
+	stats.Values = n.sizes.vals
+	stats.Window = sizeWindowLen
+
+	return
+}
+
+// SizeStats returns histograms of the sizes of the keys and values of the
+// records most recently returned.
+func (d *Decoder) SizeStats() (stats SizeStats) {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	stats.Keys = d.sizes.keys
+	stats.Values = d.sizes.vals
+	stats.Window = sizeWindowLen
+
+	return
+}
+
+type sizeWindow struct {
+	keys SizeHistogram
+	vals SizeHistogram
+	ring [][2]uint8 // buckets of key and value sizes, oldest at next if full
+	next int
+}
+
+func (w *sizeWindow) add(k int, v int64) {
+	// Counts a record of key size k and value size v, forgetting the oldest
+	// record counted if the window is full.
+
+	var (
+		buckets = [2]uint8{
+			uint8(bits.Len64(uint64(k))),
+			uint8(bits.Len64(uint64(v))),
+		}
+	)
+
+	if len(w.ring) < sizeWindowLen {
+		w.ring = append(w.ring, buckets)
+	} else {
+		w.keys.Buckets[w.ring[w.next][0]]--
+		w.vals.Buckets[w.ring[w.next][1]]--
+
+		w.ring[w.next] = buckets
+		w.next = (w.next + 1) % sizeWindowLen
+	}
+
+	w.keys.Buckets[buckets[0]]++
+	w.vals.Buckets[buckets[1]]++
+
+	return
+}
+
+func bucketMax(i int) int64 {
+	// Returns the greatest size counted in bucket i of a SizeHistogram.
+
+	if i == 0 {
+		return 0
+	}
+
+	return 1<<i - 1
+}
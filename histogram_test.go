@@ -0,0 +1,75 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeStats(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		i       int
+		stats   SizeStats
+	)
+
+	encoder = NewEncoder(&buffer, nil,
+		WithCompression(
+			func(_, _ []byte) Compression { return CompressionDeflate },
+		),
+	)
+
+	assert.Zero(t, encoder.SizeStats().Values.Quantile(0.5))
+
+	assert.NoError(t,
+		encoder.Encode(nil, nil),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), bytes.Repeat([]byte("v"), 1000)),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("key"), strings.NewReader("val"), 3),
+	)
+
+	assert.Error(t,
+		encoder.Encode(make([]byte, 512), nil),
+	)
+
+	stats = encoder.SizeStats()
+
+	assert.Equal(t, sizeWindowLen, stats.Window)
+	assert.Equal(t, 3, stats.Keys.Count())
+	assert.Equal(t, []int{1, 0, 2}, stats.Keys.Buckets[:3])
+	assert.Equal(t, 1, stats.Values.Buckets[10]) // uncompressed size
+	assert.Equal(t, int64(3), stats.Values.Quantile(0.5))
+	assert.Equal(t, int64(1023), stats.Values.Quantile(1))
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil)
+
+	for e == nil {
+		_, _, e = decoder.Decode()
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.Equal(t, stats, decoder.SizeStats())
+
+	for i = 0; i < sizeWindowLen; i++ {
+		encoder.Encode([]byte("k"), []byte("v"))
+	}
+
+	stats = encoder.SizeStats()
+
+	assert.Equal(t, sizeWindowLen, stats.Values.Count())
+	assert.Equal(t, sizeWindowLen, stats.Values.Buckets[1])
+	assert.Equal(t, int64(1), stats.Values.Quantile(1))
+
+	return
+}
@@ -0,0 +1,19 @@
+// Package wrap prefixes the errors of the packages of the module that adapt
+// streams to other services and formats, as does the root package its own.
+package wrap
+
+import (
+	"fmt"
+)
+
+// Errorf prefixes the error at errPtr, if not nil, with prefix, wrapping it.
+// It is meant to be deferred by a function whose errors it describes.
+func Errorf(prefix string, errPtr *error) {
+	if *errPtr == nil {
+		return
+	}
+
+	*errPtr = fmt.Errorf("%s: %w", prefix, *errPtr)
+
+	return
+}
@@ -0,0 +1,34 @@
+package wrap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorf(t *testing.T) {
+	var (
+		cause = errors.New("ka-BOOM!")
+		e     error
+
+		f = func(err bool) (e error) {
+			defer Errorf("oops", &e)
+
+			if err {
+				e = cause
+			}
+
+			return
+		}
+	)
+
+	assert.Nil(t, f(false))
+
+	e = f(true)
+
+	assert.Equal(t, "oops: ka-BOOM!", e.Error())
+	assert.ErrorIs(t, e, cause)
+
+	return
+}
@@ -0,0 +1,215 @@
+package bottledlightning
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// An InvalidationFeed tails a stream of changes, in which records marked with
+// XMetaFlagTombstone record deletions and others record puts, on behalf of a
+// read cache, delivering the keys changed to its callbacks in batches. Changes
+// to a key within a batch are coalesced into the last, so that a key is
+// delivered once per batch, as invalidated if last deleted and as updated if
+// last put.
+type InvalidationFeed struct {
+	// Invalidate is called with the keys to be evicted from the cache, and
+	// Update with the keys to be refreshed and their new values. If Update
+	// is nil, keys put are passed to Invalidate as well. An error returned
+	// by either stops the feed.
+	Invalidate func(keys [][]byte) error
+	Update     func(keys, vals [][]byte) error
+
+	// BatchLen is the number of distinct keys after which a batch is
+	// delivered, one if zero, and BatchDelay the longest time a change
+	// waits for its batch to be delivered, unbounded if zero.
+	BatchLen   int
+	BatchDelay time.Duration
+}
+
+// Run receives the changes of the stream from the Decoder and delivers them
+// until the end of the stream, at which it delivers the last batch and returns
+// nil, or until an error, which it returns. If the context is done, Run
+// delivers the batch pending and returns the error of the context; a Decode
+// under way continues in the background until the underlying [io.Reader] of
+// the Decoder is closed or yields a record.
+func (f InvalidationFeed) Run(ctx context.Context, decoder *Decoder) (e error) {
+	defer errorf("could not run invalidation feed", &e)
+
+	var (
+		batch   = newInvalidationBatch()
+		done    = make(chan struct{})
+		item    collected
+		records = make(chan collected)
+		timeout <-chan time.Time
+	)
+
+	defer close(done)
+
+	go func() {
+		var (
+			item collected
+		)
+
+		for {
+			item.key, item.val, item.xmv, item.e = decoder.DecodeX()
+
+			select {
+			case records <- item:
+			case <-done:
+				return
+			}
+
+			if item.e != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case item = <-records:
+
+		case <-timeout:
+			timeout = nil
+
+			e = f.deliver(batch)
+			if e != nil {
+				return
+			}
+
+			continue
+
+		case <-ctx.Done():
+			e = f.deliver(batch)
+			if e != nil {
+				return
+			}
+
+			return ctx.Err()
+		}
+
+		if errors.Is(item.e, io.EOF) {
+			return f.deliver(batch)
+		}
+
+		if item.e != nil {
+			return item.e
+		}
+
+		batch.add(item.key, item.val,
+			XMetaValue(item.xmv).HasFlag(XMetaFlagTombstone),
+		)
+
+		if len(batch.keys) >= max(f.BatchLen, 1) {
+			e = f.deliver(batch)
+			if e != nil {
+				return
+			}
+
+			timeout = nil
+
+			continue
+		}
+
+		if timeout == nil && f.BatchDelay > 0 {
+			timeout = time.After(f.BatchDelay)
+		}
+	}
+}
+
+func (f InvalidationFeed) deliver(batch *invalidationBatch) (e error) {
+	// Delivers the batch to the callbacks, and empties it.
+
+	var (
+		i    int
+		key  []byte
+		keys [][]byte
+		vals [][]byte
+	)
+
+	defer batch.reset()
+
+	for i, key = range batch.keys {
+		if batch.deleted[i] || f.Update == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) > 0 && f.Invalidate != nil {
+		e = f.Invalidate(keys)
+		if e != nil {
+			return
+		}
+	}
+
+	if f.Update == nil {
+		return
+	}
+
+	keys = nil
+
+	for i, key = range batch.keys {
+		if !batch.deleted[i] {
+			keys = append(keys, key)
+			vals = append(vals, batch.vals[i])
+		}
+	}
+
+	if len(keys) > 0 {
+		e = f.Update(keys, vals)
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// An invalidationBatch accumulates the changes to distinct keys, in order of
+// their first change.
+type invalidationBatch struct {
+	index   map[string]int
+	keys    [][]byte
+	vals    [][]byte
+	deleted []bool
+}
+
+func newInvalidationBatch() *invalidationBatch {
+	return &invalidationBatch{
+		index: make(map[string]int),
+	}
+}
+
+func (b *invalidationBatch) add(key, val []byte, deleted bool) {
+	// Records a change to key, superseding any earlier one in the batch.
+
+	var (
+		i  int
+		ok bool
+	)
+
+	i, ok = b.index[string(key)]
+	if ok {
+		b.vals[i], b.deleted[i] = val, deleted
+
+		return
+	}
+
+	b.index[string(key)] = len(b.keys)
+
+	b.keys = append(b.keys, key)
+	b.vals = append(b.vals, val)
+	b.deleted = append(b.deleted, deleted)
+
+	return
+}
+
+func (b *invalidationBatch) reset() {
+	clear(b.index)
+
+	b.keys, b.vals, b.deleted = nil, nil, nil
+
+	return
+}
@@ -0,0 +1,123 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidationFeed(t *testing.T) {
+	var (
+		buffer      bytes.Buffer
+		encoder     = NewEncoder(&buffer, nil)
+		i           int
+		invalidated [][][]byte
+		keys        = []string{"a", "b", "a", "c", "d"}
+		tombstone   = NewXMetaValue(0, XMetaFlagTombstone)
+		updated     []string
+		xmvs        = []XMetaValue{0, 0, tombstone, tombstone, 0}
+	)
+
+	for i = range keys {
+		assert.NoError(t,
+			encoder.EncodeX([]byte(keys[i]), []byte(keys[i]+"1"), xmvs[i]),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.NoError(t,
+		InvalidationFeed{
+			Invalidate: func(keys [][]byte) error {
+				invalidated = append(invalidated, keys)
+
+				return nil
+			},
+			Update: func(keys, vals [][]byte) error {
+				var (
+					i int
+				)
+
+				for i = range keys {
+					updated = append(updated,
+						fmt.Sprintf("%s=%s", keys[i], vals[i]),
+					)
+				}
+
+				return nil
+			},
+			BatchLen: 3,
+		}.Run(context.Background(), NewDecoder(&buffer, nil)),
+	)
+
+	// The put of a is superseded by its deletion within the first batch.
+
+	assert.Equal(t,
+		[][][]byte{{[]byte("a"), []byte("c")}},
+		invalidated,
+	)
+	assert.Equal(t, []string{"b=b1", "d=d1"}, updated)
+
+	return
+}
+
+func TestInvalidationFeedDelay(t *testing.T) {
+	var (
+		batches = make(chan [][]byte, 1)
+		cancel  context.CancelFunc
+		ctx     context.Context
+		e       = make(chan error, 1)
+		encoder *Encoder
+		reader  *io.PipeReader
+		writer  *io.PipeWriter
+	)
+
+	reader, writer = io.Pipe()
+
+	encoder = NewEncoder(writer, nil)
+
+	ctx, cancel = context.WithCancel(
+		context.Background(),
+	)
+
+	defer cancel()
+
+	go func() {
+		e <- InvalidationFeed{
+			Invalidate: func(keys [][]byte) error {
+				batches <- keys
+
+				return nil
+			},
+			BatchLen:   100,
+			BatchDelay: 10 * time.Millisecond,
+		}.Run(ctx, NewDecoder(reader, nil))
+	}()
+
+	// The stream is idle after one change, which is delivered once its
+	// batch is due.
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("1")),
+	)
+
+	assert.Equal(t,
+		[][]byte{[]byte("a")},
+		<-batches,
+	)
+
+	cancel()
+
+	assert.ErrorIs(t, <-e, context.Canceled)
+
+	writer.Close()
+
+	return
+}
@@ -0,0 +1,32 @@
+package bottledlightning
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+func (o *options) labelGoroutine(role string, labels ...string) {
+	// Labels the calling goroutine, for profiling, with the stream label, if
+	// so configured, and the role of the goroutine, followed by any other
+	// labels given as pairs of keys and values.
+
+	if o.streamLabel == "" {
+		return
+	}
+
+	labels = append(
+		[]string{
+			"bottledlightning.stream", o.streamLabel,
+			"bottledlightning.role", role,
+		},
+		labels...,
+	)
+
+	pprof.SetGoroutineLabels(
+		pprof.WithLabels(context.Background(),
+			pprof.Labels(labels...),
+		),
+	)
+
+	return
+}
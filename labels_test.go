@@ -0,0 +1,48 @@
+package bottledlightning
+
+import (
+	"io"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamLabel(t *testing.T) {
+	var (
+		encoder *Encoder
+		labels  func() string
+	)
+
+	labels = func() string {
+		var (
+			profile strings.Builder
+		)
+
+		pprof.Lookup("goroutine").WriteTo(&profile, 1)
+
+		return profile.String()
+	}
+
+	encoder = NewEncoder(io.Discard, nil,
+		WithKeepalive(time.Hour),
+		WithStreamLabel("orders"),
+	)
+
+	assert.Eventually(t,
+		func() bool {
+			return strings.Contains(labels(),
+				`"bottledlightning.role":"keepalive"`,
+			)
+		},
+		time.Second, time.Millisecond,
+	)
+
+	assert.Contains(t, labels(), `"bottledlightning.stream":"orders"`)
+
+	assert.NoError(t, encoder.Close())
+
+	return
+}
@@ -0,0 +1,141 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// A ValueHandle refers to the value of a record returned by
+// Decoder.DecodeHandle. The value of a record skipped under WithLazyValues is
+// read from the underlying [io.ReaderAt] of the Decoder only when the handle
+// is opened, and so only while the reader remains open; any other value is
+// held in memory.
+type ValueHandle struct {
+	reader io.ReaderAt
+	offset int64
+	length int64
+	val    []byte
+}
+
+// Len returns the length of the value.
+func (h ValueHandle) Len() int64 {
+	return h.length
+}
+
+// Offset returns the offset of the value in the underlying reader of the
+// Decoder, or -1 if the value is held in memory.
+func (h ValueHandle) Offset() int64 {
+	if h.reader == nil {
+		return -1
+	}
+
+	return h.offset
+}
+
+// Open returns a reader of the value, which may be called any number of times.
+func (h ValueHandle) Open() io.Reader {
+	if h.reader == nil {
+		return bytes.NewReader(h.val)
+	}
+
+	return io.NewSectionReader(h.reader, h.offset, h.length)
+}
+
+// Bytes returns the value, reading it in full if it is not held in memory.
+func (h ValueHandle) Bytes() (val []byte, e error) {
+	if h.reader == nil {
+		return h.val, nil
+	}
+
+	val = make([]byte, h.length)
+
+	_, e = io.ReadFull(
+		h.Open(), val,
+	)
+	if e != nil {
+		return nil, fmt.Errorf("could not read value: %w", unexpectedEOF(e))
+	}
+
+	return
+}
+
+// DecodeHandle is a variant of DecodeX that returns a handle to the value of
+// the record rather than the value itself, so that a Decoder configured with
+// WithLazyValues can skip over large values without reading them.
+func (d *Decoder) DecodeHandle() (key []byte, handle ValueHandle, xmv byte,
+	e error,
+) {
+	return d.decodeHandle(false)
+}
+
+type lazyReader interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+func (d *Decoder) lazy(control, c bool, v int) bool {
+	// Returns true if the value of length v of the record about to be read
+	// can be skipped, being large and of no concern to the Decoder, which
+	// must otherwise transform or verify it.
+
+	const (
+		transformed = featureEncryption | featureDedup | featureBlobSpill |
+			featureCompression
+	)
+
+	switch {
+	case d.options.lazyThreshold <= 0 || control:
+		return false
+
+	case int64(v) <= d.options.lazyThreshold:
+		return false
+
+	case d.hasher != nil &&
+		(c || d.features&featureBatchChecksum != 0):
+		return false
+
+	case d.features&transformed != 0:
+		return false
+	}
+
+	return d.digest == nil && d.snapshot == nil &&
+		d.options.decodeTransform == nil && d.options.redactMatch == nil
+}
+
+func (d *Decoder) skipVal(v int) (e error) {
+	// Seeks past v bytes containing the uninterpreted value, keeping a handle
+	// to them, having checked that the last of them is present.
+
+	var (
+		end    int64
+		n      int
+		ok     bool
+		reader lazyReader
+	)
+
+	reader, ok = d.reader.(lazyReader)
+	if !ok {
+		return fmt.Errorf("underlying reader does not implement io.Seeker " +
+			"and io.ReaderAt, as lazy values require",
+		)
+	}
+
+	end, e = reader.Seek(int64(v), io.SeekCurrent)
+	if e != nil {
+		return
+	}
+
+	n, _ = reader.ReadAt(make([]byte, 1), end-1)
+	if n == 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	d.skipped = ValueHandle{
+		reader: reader,
+		offset: end - int64(v),
+		length: int64(v),
+	}
+
+	return
+}
@@ -0,0 +1,152 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazyValues(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		handle  ValueHandle
+		key     []byte
+		large   = bytes.Repeat([]byte("v"), 100)
+		reader  *countingReaderAt
+		val     []byte
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("small")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), large),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	reader = &countingReaderAt{
+		Reader: bytes.NewReader(buffer.Bytes()),
+	}
+
+	decoder = NewDecoder(reader, nil, WithLazyValues(10))
+
+	key, handle, _, e = decoder.DecodeHandle()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, []byte("a"), key)
+	assert.Equal(t, int64(-1), handle.Offset())
+
+	val, e = handle.Bytes()
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("small"), val)
+
+	key, handle, _, e = decoder.DecodeHandle()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, []byte("b"), key)
+	assert.Equal(t, int64(100), handle.Len())
+	assert.Equal(t, int64(len(buffer.Bytes())-100), handle.Offset())
+	assert.Less(t, reader.read, len(buffer.Bytes())-100+2)
+
+	_, _, _, e = decoder.DecodeHandle()
+	assert.ErrorIs(t, e, io.EOF)
+
+	val, e = io.ReadAll(
+		handle.Open(),
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, large, val)
+
+	assert.Equal(t,
+		RecordStats{Records: 2, Bytes: 2 + 5 + 100},
+		decoder.RecordStats(),
+	)
+
+	_, val, e = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithLazyValues(10),
+	).Decode()
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("small"), val)
+
+	return
+}
+
+func TestLazyValuesDecode(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, crc32.NewIEEE())
+		handle  ValueHandle
+		large   = bytes.Repeat([]byte("v"), 100)
+		val     []byte
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), large),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// Checksums verified by the Decoder require the value to be read.
+
+	_, handle, _, e = NewDecoder(bytes.NewReader(buffer.Bytes()),
+		crc32.NewIEEE(), WithLazyValues(10),
+	).DecodeHandle()
+	assert.NoError(t, e)
+	assert.Equal(t, int64(-1), handle.Offset())
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithLazyValues(10),
+	)
+
+	_, val, e = decoder.Decode()
+	assert.NoError(t, e)
+	assert.Equal(t, large, val)
+
+	_, _, e = NewDecoder(bytes.NewReader(buffer.Bytes()[:buffer.Len()-10]),
+		nil, WithLazyValues(10),
+	).Decode()
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	_, _, e = NewDecoder(
+		io.MultiReader(
+			bytes.NewReader(buffer.Bytes()),
+		),
+		nil, WithLazyValues(10),
+	).Decode()
+	assert.Error(t, e) // not seekable
+	assert.False(t,
+		errors.Is(e, io.EOF),
+	)
+
+	return
+}
+
+type countingReaderAt struct {
+	*bytes.Reader
+
+	read int
+}
+
+func (r *countingReaderAt) Read(b []byte) (n int, e error) {
+	n, e = r.Reader.Read(b)
+
+	r.read += n
+
+	return
+}
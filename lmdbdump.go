@@ -0,0 +1,143 @@
+package bottledlightning
+
+import (
+	"runtime"
+	"slices"
+)
+
+// An LMDBReadTxn is a read-only transaction of lmdb-go, as is an *lmdb.Txn
+// begun with lmdb.Readonly over databases identified by DBI, an lmdb.DBI, with
+// cursors of type Cursor, an *lmdb.Cursor, or of any bindings with the same
+// methods.
+type LMDBReadTxn[DBI any, Cursor LMDBCursor] interface {
+	OpenCursor(dbi DBI) (Cursor, error)
+	Abort()
+}
+
+// An LMDBCursor is a cursor of lmdb-go, as is an *lmdb.Cursor, positioned by
+// the operations of LMDB, such as MDB_FIRST and MDB_NEXT.
+type LMDBCursor interface {
+	Get(setkey, setval []byte, op uint) (key, val []byte, e error)
+	Close()
+}
+
+// An LMDBDump dumps named databases of an LMDB environment to an Encoder, all
+// within a single read-only transaction, so that the stream is a consistent
+// snapshot of the databases as of one point in time, the records of each
+// tagged by SetSource with its name:
+//
+//	dump = bl.LMDBDump[lmdb.DBI, *lmdb.Cursor]{
+//		Begin: func() (bl.LMDBReadTxn[lmdb.DBI, *lmdb.Cursor], error) {
+//			return env.BeginTxn(nil, lmdb.Readonly)
+//		},
+//		Databases:  map[string]lmdb.DBI{"": main, "users": users},
+//		IsNotFound: lmdb.IsNotFound,
+//	}
+//
+//	records, e = dump.Run(encoder)
+//
+// Unless the environment is opened with MDB_NOTLS, a read-only transaction is
+// bound to the thread that begins it, so Run locks its goroutine to its thread
+// from before Begin until the transaction is aborted, and the transaction must
+// not be shared. Being held open for the whole dump, it keeps an LMDB writer
+// from reusing the pages freed meanwhile, and the environment may grow.
+type LMDBDump[DBI any, Cursor LMDBCursor] struct {
+	// Begin begins the read-only transaction.
+	Begin func() (LMDBReadTxn[DBI, Cursor], error)
+
+	// Databases are the databases to dump, by name, the empty string for
+	// the main database. They are dumped in order of name.
+	Databases map[string]DBI
+
+	// IsNotFound reports whether an error of a cursor is MDB_NOTFOUND, as
+	// does lmdb.IsNotFound.
+	IsNotFound func(error) bool
+}
+
+// Run dumps the databases to the Encoder, and returns the number of records
+// encoded. It neither closes nor aborts the Encoder.
+func (d LMDBDump[DBI, Cursor]) Run(encoder *Encoder) (records int64, e error) {
+	defer errorf("could not dump LMDB databases", &e)
+
+	var (
+		name  string
+		names = make([]string, 0, len(d.Databases))
+		n     int64
+		txn   LMDBReadTxn[DBI, Cursor]
+	)
+
+	for name = range d.Databases {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	runtime.LockOSThread()
+
+	defer runtime.UnlockOSThread()
+
+	txn, e = d.Begin()
+	if e != nil {
+		return
+	}
+
+	defer txn.Abort()
+
+	for _, name = range names {
+		n, e = d.dump(encoder, txn, name)
+
+		records += n
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (d LMDBDump[DBI, Cursor]) dump(encoder *Encoder,
+	txn LMDBReadTxn[DBI, Cursor], name string,
+) (records int64, e error) {
+	// Dumps the records of a database, tagged with its name, by a cursor of
+	// the transaction.
+
+	var (
+		cursor Cursor
+		key    []byte
+		op     uint = lmdbFirst
+		val    []byte
+	)
+
+	e = encoder.SetSource(name)
+	if e != nil {
+		return
+	}
+
+	cursor, e = txn.OpenCursor(d.Databases[name])
+	if e != nil {
+		return
+	}
+
+	defer cursor.Close()
+
+	for {
+		key, val, e = cursor.Get(nil, nil, op)
+		if e != nil && d.IsNotFound(e) {
+			return records, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		e = encoder.Encode(key, val)
+		if e != nil {
+			return
+		}
+
+		records++
+
+		op = lmdbNext
+	}
+}
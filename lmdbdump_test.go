@@ -0,0 +1,140 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errNotFound = errors.New("MDB_NOTFOUND")
+
+// A readTxn is an LMDBReadTxn over maps, one per database, standing in for a
+// read-only *lmdb.Txn, counting its cursors open.
+type readTxn struct {
+	dbs     map[uint32]map[string]string
+	cursors int
+	aborted bool
+}
+
+func (t *readTxn) OpenCursor(dbi uint32) (*readCursor, error) {
+	var (
+		cursor = &readCursor{txn: t, vals: t.dbs[dbi]}
+		key    string
+	)
+
+	for key = range cursor.vals {
+		cursor.keys = append(cursor.keys, key)
+	}
+
+	slices.Sort(cursor.keys)
+
+	t.cursors++
+
+	return cursor, nil
+}
+
+func (t *readTxn) Abort() {
+	t.aborted = true
+
+	return
+}
+
+type readCursor struct {
+	txn  *readTxn
+	keys []string
+	vals map[string]string
+	i    int
+}
+
+func (c *readCursor) Get(setkey, setval []byte, op uint) (
+	key, val []byte, e error,
+) {
+	switch op {
+	case lmdbFirst:
+		c.i = 0
+
+	case lmdbNext:
+		c.i++
+	}
+
+	if c.i >= len(c.keys) {
+		return nil, nil, errNotFound
+	}
+
+	return []byte(c.keys[c.i]), []byte(c.vals[c.keys[c.i]]), nil
+}
+
+func (c *readCursor) Close() {
+	c.txn.cursors--
+
+	return
+}
+
+func TestLMDBDump(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		dump    LMDBDump[uint32, *readCursor]
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		key     []byte
+		n       int64
+		records []string
+		txn     *readTxn
+		val     []byte
+	)
+
+	txn = &readTxn{
+		dbs: map[uint32]map[string]string{
+			1: {"b": "1", "a": "1"},
+			2: {"alice": "2"},
+			3: {},
+		},
+	}
+
+	dump = LMDBDump[uint32, *readCursor]{
+		Begin: func() (LMDBReadTxn[uint32, *readCursor], error) {
+			return txn, nil
+		},
+		Databases: map[string]uint32{"users": 2, "": 1, "empty": 3},
+		IsNotFound: func(e error) bool {
+			return errors.Is(e, errNotFound)
+		},
+	}
+
+	n, e = dump.Run(encoder)
+	assert.NoError(t, e)
+	assert.Equal(t, int64(3), n)
+	assert.True(t, txn.aborted)
+	assert.Zero(t, txn.cursors)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	decoder = NewDecoder(&buffer, nil)
+
+	for {
+		key, val, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		records = append(records,
+			decoder.Source()+":"+string(key)+"="+string(val),
+		)
+	}
+
+	assert.Equal(t,
+		[]string{":a=1", ":b=1", "users:alice=2"},
+		records,
+	)
+
+	return
+}
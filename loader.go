@@ -0,0 +1,402 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A LoadTxn is a write transaction on an LMDB database, as provided by the
+// bindings of an application, into which a Loader applies records. Get returns
+// the value of a key and whether it is present, and Del of a key that is
+// absent does nothing.
+type LoadTxn interface {
+	Put(key, val []byte) error
+	Del(key []byte) error
+	Get(key []byte) (val []byte, found bool, e error)
+	Commit() error
+	Abort()
+}
+
+// A ConflictAction determines what a Loader does with a record whose key is
+// present in the database already.
+type ConflictAction int
+
+const (
+	// ConflictReplace stores the incoming value, as if there were no
+	// conflict.
+	ConflictReplace ConflictAction = iota
+
+	// ConflictKeep leaves the existing value in place.
+	ConflictKeep
+
+	// ConflictMerge stores the value returned by the resolver.
+	ConflictMerge
+
+	// ConflictAbort fails the load, aborting its write transaction.
+	ConflictAbort
+)
+
+// A Loader applies the records of a stream to an LMDB database, in write
+// transactions that it begins as needed: tombstones, records whose extended
+// metadata value carries XMetaFlagTombstone, as written by DiffDump, as
+// deletions of their keys, and every other record as a put of its key to its
+// value.
+//
+// Unless OffsetKey is nil, the Loader stores, under that reserved key and in
+// the same write transaction as the records, the offset of the stream, the
+// number of its records applied so far, eight bytes big-endian. Before
+// applying any record, it skips as many records of the stream as the offset
+// stored, so that a stream replayed after a crash, from its start, is applied
+// exactly once, without any bookkeeping by the application. A record of the
+// reserved key fails the load.
+//
+// The records of a transaction of the stream, as marked by
+// Encoder.BeginTransaction and CommitTransaction, are applied in the same
+// write transaction, so that the database never exposes part of one.
+type Loader struct {
+	// Begin begins a write transaction on the database.
+	Begin func() (LoadTxn, error)
+
+	// OffsetKey, if not nil, is the reserved key under which the offset of
+	// the stream is stored.
+	OffsetKey []byte
+
+	// TxnLen is the number of records after which a write transaction is
+	// committed, at the end of any transaction of the stream, 1024 if zero.
+	TxnLen int
+
+	// Resolve, if not nil, is called for every put of a key present in the
+	// database, with the existing and incoming values, and decides what is
+	// stored. Deletions are applied regardless.
+	Resolve func(key, existing, incoming []byte) ([]byte, ConflictAction)
+}
+
+// Load applies the records of the Decoder until the end of the stream, and
+// returns the number committed, not counting those skipped as applied already.
+// Write transactions are committed as configured and at the end of the
+// stream; should the load fail, the records applied since the last commit
+// are aborted, and the offset stored remains that of the last commit.
+func (l Loader) Load(decoder *Decoder) (n int64, e error) {
+	defer errorf("could not load stream", &e)
+
+	var (
+		begun    uint64
+		inTxn    bool
+		key      []byte
+		offset   uint64
+		pending  int
+		seen     uint64
+		split    bool
+		txn      LoadTxn
+		txnLen   = l.TxnLen
+		val      []byte
+		wasBegun uint64
+		wasInTxn bool
+		xmv      byte
+	)
+
+	if txnLen <= 0 {
+		txnLen = loadTxnLen
+	}
+
+	defer func() {
+		if txn != nil {
+			txn.Abort()
+		}
+	}()
+
+	txn, e = l.Begin()
+	if e != nil {
+		return
+	}
+
+	offset, e = l.offset(txn)
+	if e != nil {
+		return
+	}
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		seen++
+
+		// A write transaction is committed only between transactions of
+		// the stream, and so not if the previous record and this one belong
+		// to the same.
+
+		wasInTxn, wasBegun = inTxn, begun
+
+		inTxn, begun = decoder.transactionState()
+
+		if seen <= offset {
+			continue
+		}
+
+		split = wasInTxn && inTxn && begun == wasBegun
+
+		if pending >= txnLen && !split {
+			e = l.commit(txn, seen-1)
+			if e != nil {
+				return
+			}
+
+			n += int64(pending)
+
+			pending, txn = 0, nil
+		}
+
+		if txn == nil {
+			txn, e = l.Begin()
+			if e != nil {
+				return
+			}
+		}
+
+		_, e = l.apply(txn, key, val, XMetaValue(xmv), false)
+		if e != nil {
+			return
+		}
+
+		pending++
+	}
+
+	if pending == 0 {
+		return n, nil
+	}
+
+	e = l.commit(txn, seen)
+	if e != nil {
+		return
+	}
+
+	n += int64(pending)
+
+	txn = nil
+
+	return
+}
+
+// A LoadPlan reports what a Loader would do with a stream, as found by Plan.
+type LoadPlan struct {
+	// Inserts, Updates and Deletes are the numbers of records that would
+	// store a key absent from the database, store a key present, and delete
+	// a key present, respectively. Records that would change nothing, such
+	// as tombstones of absent keys and puts kept by Resolve, are not
+	// counted.
+	Inserts int64
+	Updates int64
+	Deletes int64
+}
+
+// Plan is a dry run of Load: it reads the records of the Decoder until the end
+// of the stream, skipping those applied already as does Load, and classifies
+// each against the database, as read by LoadTxn.Get, consulting Resolve as
+// Load would. The records are applied to a single write transaction, so that
+// each is classified against those before it, which Plan aborts rather than
+// commits. A record that LMDB would refuse, as by ProfileLMDBStrict, fails the
+// plan, as does one refused by the validation profile of the Decoder.
+func (l Loader) Plan(decoder *Decoder) (plan LoadPlan, e error) {
+	defer errorf("could not plan load of stream", &e)
+
+	var (
+		change loadChange
+		key    []byte
+		offset uint64
+		seen   uint64
+		txn    LoadTxn
+		val    []byte
+		xmv    byte
+	)
+
+	txn, e = l.Begin()
+	if e != nil {
+		return
+	}
+
+	defer txn.Abort()
+
+	offset, e = l.offset(txn)
+	if e != nil {
+		return
+	}
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			return plan, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		seen++
+
+		if seen <= offset {
+			continue
+		}
+
+		e = ProfileLMDBStrict.validate(key,
+			int64(len(val)),
+		)
+		if e != nil {
+			return plan, fmt.Errorf("record %d: %w", seen, e)
+		}
+
+		change, e = l.apply(txn, key, val, XMetaValue(xmv), true)
+		if e != nil {
+			return
+		}
+
+		switch change {
+		case loadInsert:
+			plan.Inserts++
+
+		case loadUpdate:
+			plan.Updates++
+
+		case loadDelete:
+			plan.Deletes++
+		}
+	}
+}
+
+type loadChange int
+
+const (
+	loadUnchanged loadChange = iota
+	loadInsert
+	loadUpdate
+	loadDelete
+)
+
+func (l Loader) offset(txn LoadTxn) (offset uint64, e error) {
+	// Returns the offset stored under the reserved key, if any.
+
+	var (
+		found  bool
+		stored []byte
+	)
+
+	if l.OffsetKey == nil {
+		return
+	}
+
+	stored, found, e = txn.Get(l.OffsetKey)
+	if e != nil {
+		return
+	}
+
+	if found && len(stored) != 8 {
+		return 0, fmt.Errorf("malformed offset under reserved key")
+	}
+
+	if found {
+		offset = binary.BigEndian.Uint64(stored)
+	}
+
+	return
+}
+
+func (l Loader) apply(txn LoadTxn, key, val []byte, xmv XMetaValue,
+	classify bool,
+) (
+	change loadChange, e error,
+) {
+	// Applies a record to the write transaction, resolving any conflict with
+	// an existing value, and returns the change made. Unless classify is
+	// true, the presence of the key is looked up only if needed to resolve a
+	// conflict, and a change to an absent key reported as an insert.
+
+	var (
+		action    ConflictAction
+		existing  []byte
+		found     bool
+		merged    []byte
+		tombstone = xmv.HasFlag(XMetaFlagTombstone)
+	)
+
+	if l.OffsetKey != nil && bytes.Equal(key, l.OffsetKey) {
+		return change, fmt.Errorf("record of reserved key %x", key)
+	}
+
+	if classify || l.Resolve != nil && !tombstone {
+		existing, found, e = txn.Get(key)
+		if e != nil {
+			return
+		}
+	}
+
+	switch {
+	case tombstone && classify && !found:
+		return loadUnchanged, nil
+
+	case tombstone:
+		return loadDelete, txn.Del(key)
+
+	case found && l.Resolve != nil:
+		merged, action = l.Resolve(key, existing, val)
+	}
+
+	switch action {
+	case ConflictKeep:
+		return loadUnchanged, nil
+
+	case ConflictMerge:
+		val = merged
+
+	case ConflictAbort:
+		return change, fmt.Errorf("conflict on key %x", key)
+	}
+
+	change = loadInsert
+
+	if found {
+		change = loadUpdate
+	}
+
+	e = txn.Put(key, val)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (l Loader) commit(txn LoadTxn, offset uint64) (e error) {
+	// Stores the offset, if so configured, and commits the write
+	// transaction.
+
+	if l.OffsetKey != nil {
+		e = txn.Put(l.OffsetKey,
+			binary.BigEndian.AppendUint64(nil, offset),
+		)
+		if e != nil {
+			return
+		}
+	}
+
+	return txn.Commit()
+}
+
+func (d *Decoder) transactionState() (inTransaction bool, begun uint64) {
+	// Returns whether the last record received belongs to a transaction,
+	// and the number of transactions begun so far, which tells it apart from
+	// those before.
+
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.inTransaction, d.transactionsBegun
+}
@@ -0,0 +1,488 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A mapTxn is a LoadTxn over a map, standing in for an LMDB write transaction,
+// whose changes are pending until committed.
+type mapTxn struct {
+	db      map[string]string
+	pending map[string]*string
+	fail    error
+}
+
+func (t *mapTxn) Put(key, val []byte) error {
+	var (
+		v = string(val)
+	)
+
+	t.pending[string(key)] = &v
+
+	return nil
+}
+
+func (t *mapTxn) Del(key []byte) error {
+	t.pending[string(key)] = nil
+
+	return nil
+}
+
+func (t *mapTxn) Get(key []byte) (val []byte, found bool, e error) {
+	var (
+		pending *string
+		v       string
+	)
+
+	pending, found = t.pending[string(key)]
+	if found {
+		if pending == nil {
+			return nil, false, nil
+		}
+
+		return []byte(*pending), true, nil
+	}
+
+	v, found = t.db[string(key)]
+
+	return []byte(v), found, nil
+}
+
+func (t *mapTxn) Commit() error {
+	var (
+		key string
+		val *string
+	)
+
+	if t.fail != nil {
+		return t.fail
+	}
+
+	for key, val = range t.pending {
+		if val == nil {
+			delete(t.db, key)
+		} else {
+			t.db[key] = *val
+		}
+	}
+
+	return nil
+}
+
+func (t *mapTxn) Abort() {
+	clear(t.pending)
+
+	return
+}
+
+func newMapTxn(db map[string]string) *mapTxn {
+	return &mapTxn{
+		db:      db,
+		pending: make(map[string]*string),
+	}
+}
+
+func TestLoader(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		crash   int
+		db      = make(map[string]string)
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		fail    error
+		key     string
+		loader  Loader
+		n       int64
+	)
+
+	loader = Loader{
+		Begin: func() (LoadTxn, error) {
+			var (
+				txn = newMapTxn(db)
+			)
+
+			txn.fail = fail
+
+			return txn, nil
+		},
+		OffsetKey: []byte("\x00offset"),
+		TxnLen:    2,
+	}
+
+	for _, key = range []string{"b", "c", "d", "e"} {
+		assert.NoError(t,
+			encoder.Encode([]byte(key), []byte("1")),
+		)
+
+		if key == "d" {
+			crash = buffer.Len()
+		}
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// A crash within the fourth record leaves the first write transaction
+	// committed, with its offset, and the second aborted.
+
+	n, e = loader.Load(
+		NewDecoder(
+			io.MultiReader(
+				bytes.NewReader(buffer.Bytes()[:crash+1]),
+				iotest.ErrReader(errors.New("crash")),
+			),
+			nil,
+		),
+	)
+	assert.ErrorContains(t, e, "crash")
+	assert.Equal(t, int64(2), n)
+
+	assert.Equal(t,
+		map[string]string{
+			"\x00offset": "\x00\x00\x00\x00\x00\x00\x00\x02",
+			"b":          "1",
+			"c":          "1",
+		},
+		db,
+	)
+
+	// A replay applies only the rest of the stream, and another nothing.
+
+	n, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, int64(2), n)
+
+	assert.Equal(t,
+		map[string]string{
+			"\x00offset": "\x00\x00\x00\x00\x00\x00\x00\x04",
+			"b":          "1",
+			"c":          "1",
+			"d":          "1",
+			"e":          "1",
+		},
+		db,
+	)
+
+	n, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, int64(0), n)
+
+	// A record of the reserved key fails the load, as does a failed commit,
+	// leaving the database as it was.
+
+	buffer.Reset()
+
+	encoder = NewEncoder(&buffer, nil)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("f"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode(loader.OffsetKey, []byte("x")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	db["\x00offset"] = "\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	_, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.ErrorContains(t, e, "reserved key")
+
+	fail = errors.New("full")
+
+	_, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()[:5]), nil),
+	)
+	assert.ErrorContains(t, e, "full")
+
+	assert.NotContains(t, db, "f")
+
+	return
+}
+
+func TestLoaderResolve(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		db      = map[string]string{"a": "0", "b": "0", "c": "0", "z": "0"}
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		key     string
+		loader  Loader
+	)
+
+	loader = Loader{
+		Begin: func() (LoadTxn, error) {
+			return newMapTxn(db), nil
+		},
+		Resolve: func(key, existing, incoming []byte) ([]byte, ConflictAction) {
+			switch string(key) {
+			case "a":
+				return nil, ConflictKeep
+
+			case "b":
+				return append(existing, incoming...), ConflictMerge
+
+			case "z":
+				return nil, ConflictAbort
+			}
+
+			return nil, ConflictReplace
+		},
+	}
+
+	for _, key = range []string{"a", "b", "c", "d"} {
+		assert.NoError(t,
+			encoder.Encode([]byte(key), []byte("1")),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	_, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.NoError(t, e)
+
+	assert.Equal(t,
+		map[string]string{"a": "0", "b": "01", "c": "1", "d": "1", "z": "0"},
+		db,
+	)
+
+	// Aborting leaves the database as it was.
+
+	buffer.Reset()
+
+	encoder = NewEncoder(&buffer, nil)
+
+	for _, key = range []string{"y", "z"} {
+		assert.NoError(t,
+			encoder.Encode([]byte(key), []byte("1")),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	_, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.ErrorContains(t, e, "conflict on key 7a")
+
+	assert.Equal(t,
+		map[string]string{"a": "0", "b": "01", "c": "1", "d": "1", "z": "0"},
+		db,
+	)
+
+	return
+}
+
+func TestLoaderTransaction(t *testing.T) {
+	var (
+		begun   int
+		buffer  bytes.Buffer
+		db      = map[string]string{"a": "0"}
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		key     string
+		loader  Loader
+		n       int64
+	)
+
+	loader = Loader{
+		Begin: func() (LoadTxn, error) {
+			begun++
+
+			return newMapTxn(db), nil
+		},
+		OffsetKey: []byte("\x00offset"),
+		TxnLen:    1,
+	}
+
+	assert.NoError(t,
+		encoder.BeginTransaction(nil),
+	)
+
+	for _, key = range []string{"b", "c", "d"} {
+		assert.NoError(t,
+			encoder.Encode([]byte(key), []byte("1")),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.CommitTransaction(),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("a"), nil,
+			NewXMetaValue(0, XMetaFlagTombstone),
+		),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("e"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// A crash past the transaction of the stream leaves it applied in full,
+	// in one write transaction, and its offset stored, but nothing after it.
+
+	n, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()[:buffer.Len()-2]), nil),
+	)
+	assert.Error(t, e)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, 2, begun)
+
+	assert.Equal(t,
+		map[string]string{
+			"\x00offset": "\x00\x00\x00\x00\x00\x00\x00\x03",
+			"a":          "0",
+			"b":          "1",
+			"c":          "1",
+			"d":          "1",
+		},
+		db,
+	)
+
+	n, e = loader.Load(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, int64(2), n)
+
+	assert.Equal(t,
+		map[string]string{
+			"\x00offset": "\x00\x00\x00\x00\x00\x00\x00\x05",
+			"b":          "1",
+			"c":          "1",
+			"d":          "1",
+			"e":          "1",
+		},
+		db,
+	)
+
+	return
+}
+
+func TestLoaderPlan(t *testing.T) {
+	var (
+		begun   int
+		buffer  bytes.Buffer
+		db      = map[string]string{"a": "0", "b": "0", "k": "0"}
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		loader  Loader
+		plan    LoadPlan
+		txn     *mapTxn
+	)
+
+	loader = Loader{
+		Begin: func() (LoadTxn, error) {
+			txn = newMapTxn(db)
+
+			begun++
+
+			return txn, nil
+		},
+		Resolve: func(key, existing, incoming []byte) ([]byte, ConflictAction) {
+			if string(key) == "k" {
+				return nil, ConflictKeep
+			}
+
+			return nil, ConflictReplace
+		},
+	}
+
+	// An update, an insert, a deletion, a tombstone of an absent key, a put
+	// kept by Resolve, and an update of the key inserted before.
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("c"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("b"), nil,
+			NewXMetaValue(0, XMetaFlagTombstone),
+		),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("z"), nil,
+			NewXMetaValue(0, XMetaFlagTombstone),
+		),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("k"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("c"), []byte("2")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	plan, e = loader.Plan(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, LoadPlan{Inserts: 1, Updates: 2, Deletes: 1}, plan)
+
+	// The write transaction is aborted, not committed, and the database
+	// left as it was.
+
+	assert.Equal(t, 1, begun)
+	assert.Empty(t, txn.pending)
+	assert.Equal(t, map[string]string{"a": "0", "b": "0", "k": "0"}, db)
+
+	// A record that LMDB would refuse fails the plan, as does one refused by
+	// the validation profile of the Decoder.
+
+	buffer.Reset()
+
+	encoder = NewEncoder(&buffer, nil)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("long"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode(nil, []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	_, e = loader.Plan(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil),
+	)
+	assert.ErrorAs(t, e, &EmptyKeyError{})
+	assert.ErrorContains(t, e, "record 2")
+
+	_, e = loader.Plan(
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+			WithValidationProfile(
+				NewValidationProfile("short", LimitKeyLen(2)),
+			),
+		),
+	)
+	assert.ErrorAs(t, e, &ValidationError{})
+
+	return
+}
@@ -0,0 +1,270 @@
+package bottledlightning
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"time"
+)
+
+// ManifestVersion is the version of the manifest format written by
+// WriteManifest and the latest understood by ReadManifest.
+const ManifestVersion = 1
+
+// A Manifest describes a snapshot of LMDB databases dumped as streams, so that
+// the snapshot can be restored, or identified as incomplete or damaged, before
+// any stream is loaded. It is serialised as JSON, typically in a bundle
+// alongside the streams that it describes.
+type Manifest struct {
+	Version int `json:"version"`
+
+	// Environment identifies the source LMDB environment, such as by host and
+	// path.
+	Environment string `json:"environment"`
+
+	// Snapshot identifies the snapshot, and Parent the snapshot of which it is
+	// an increment, if any.
+	Snapshot string `json:"snapshot"`
+	Parent   string `json:"parent,omitempty"`
+
+	Created time.Time        `json:"created"`
+	Streams []ManifestStream `json:"streams"`
+}
+
+// A ManifestStream describes one stream of a snapshot, as returned by
+// DescribeStream.
+type ManifestStream struct {
+	// Name is the name of the stream, such as that of an artifact of a
+	// bundle, and DBI the name of the LMDB database that it holds, which is
+	// empty for the main database.
+	Name string `json:"name"`
+	DBI  string `json:"dbi"`
+
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+
+	// Features lists the revisions of the format that a Decoder must support
+	// to receive the stream.
+	Features []string `json:"features,omitempty"`
+
+	// SnapshotID is the snapshot ID that the stream carries, if it was
+	// encoded with WithSnapshotID, in hexadecimal.
+	SnapshotID string `json:"snapshot_id,omitempty"`
+
+	// Aborted is true if the stream ends with a record of its abort by
+	// Encoder.Abort, and so is incomplete.
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+// DescribeStream reads a stream from the [io.Reader], checking its framing and
+// also its checksums if the [hash.Hash32] is not nil, as would Relay, and
+// returns a ManifestStream describing it under the given name and DBI. Values
+// need not be decrypted to be counted.
+func DescribeStream(name, dbi string, reader io.Reader, hasher hash.Hash32) (
+	stream ManifestStream, e error,
+) {
+	defer errorf("could not describe stream", &e)
+
+	var (
+		digest = sha256.New()
+		i      int
+		r      = &relay{
+			writer: digest,
+			reader: reader,
+			hasher: hasher,
+			buffer: make([]byte, relayBufferLen),
+		}
+	)
+
+	for {
+		e = r.next()
+		if e == io.EOF {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	stream = ManifestStream{
+		Name:    name,
+		DBI:     dbi,
+		Records: r.records,
+		Bytes:   r.written,
+		SHA256:  hex.EncodeToString(digest.Sum(nil)),
+		Aborted: r.aborted,
+	}
+
+	if r.snapshotID != nil {
+		stream.SnapshotID = hex.EncodeToString(r.snapshotID)
+	}
+
+	for i = range featureNames {
+		if r.announced&(1<<i) != 0 {
+			stream.Features = append(stream.Features, featureNames[i])
+		}
+	}
+
+	return stream, nil
+}
+
+// Verify reads the stream from the [io.Reader] and returns an error unless it
+// matches the description, so that a loader can refuse a damaged or
+// substituted stream before loading it.
+func (s ManifestStream) Verify(reader io.Reader, hasher hash.Hash32) (
+	e error,
+) {
+	var (
+		observed ManifestStream
+	)
+
+	observed, e = DescribeStream(s.Name, s.DBI, reader, hasher)
+	if e != nil {
+		return
+	}
+
+	switch {
+	case observed.SHA256 != s.SHA256:
+		return fmt.Errorf("digest of stream %q does not match manifest", s.Name)
+
+	case observed.Records != s.Records || observed.Bytes != s.Bytes:
+		return fmt.Errorf("length of stream %q does not match manifest",
+			s.Name,
+		)
+
+	case observed.SnapshotID != s.SnapshotID:
+		return fmt.Errorf("snapshot ID of stream %q does not match manifest",
+			s.Name,
+		)
+
+	case observed.Aborted != s.Aborted:
+		return fmt.Errorf("abort of stream %q does not match manifest",
+			s.Name,
+		)
+	}
+
+	return
+}
+
+// Validate returns an error if the manifest is of an unsupported version, is
+// missing required fields, or describes a stream that needs a feature that
+// this package does not support.
+func (m Manifest) Validate() (e error) {
+	defer errorf("invalid manifest", &e)
+
+	var (
+		feature string
+		names   = make(map[string]bool)
+		stream  ManifestStream
+	)
+
+	switch {
+	case m.Version < 1 || m.Version > ManifestVersion:
+		return fmt.Errorf("unsupported version %d", m.Version)
+
+	case m.Environment == "":
+		return fmt.Errorf("environment missing")
+
+	case m.Snapshot == "":
+		return fmt.Errorf("snapshot missing")
+
+	case m.Parent == m.Snapshot:
+		return fmt.Errorf("snapshot is its own parent")
+	}
+
+	for _, stream = range m.Streams {
+		switch {
+		case stream.Name == "":
+			return fmt.Errorf("stream name missing")
+
+		case names[stream.Name]:
+			return fmt.Errorf("duplicate stream %q", stream.Name)
+
+		case stream.Records < 0 || stream.Bytes < 0:
+			return fmt.Errorf("negative length of stream %q", stream.Name)
+		}
+
+		names[stream.Name] = true
+
+		if len(stream.SHA256) != 2*sha256.Size {
+			return fmt.Errorf("malformed digest of stream %q", stream.Name)
+		}
+
+		_, e = hex.DecodeString(stream.SHA256)
+		if e != nil {
+			return fmt.Errorf("malformed digest of stream %q", stream.Name)
+		}
+
+		if stream.SnapshotID != "" && len(stream.SnapshotID) != 2*sha256.Size {
+			return fmt.Errorf("malformed snapshot ID of stream %q", stream.Name)
+		}
+
+		_, e = hex.DecodeString(stream.SnapshotID)
+		if e != nil {
+			return fmt.Errorf("malformed snapshot ID of stream %q", stream.Name)
+		}
+
+		for _, feature = range stream.Features {
+			if !slices.Contains(featureNames, feature) {
+				return fmt.Errorf("stream %q needs unsupported feature %q",
+					stream.Name, feature,
+				)
+			}
+		}
+	}
+
+	return
+}
+
+// ReadManifest reads a manifest from the [io.Reader] and validates it.
+// Unknown fields are ignored, so that manifests written by later versions of
+// this package of the same format version remain readable.
+func ReadManifest(reader io.Reader) (m Manifest, e error) {
+	defer errorf("could not read manifest", &e)
+
+	e = json.NewDecoder(reader).Decode(&m)
+	if e != nil {
+		return
+	}
+
+	e = m.Validate()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// WriteManifest validates the manifest and writes it to the [io.Writer] as
+// indented JSON. A zero Version is taken to be ManifestVersion.
+func WriteManifest(writer io.Writer, m Manifest) (e error) {
+	defer errorf("could not write manifest", &e)
+
+	var (
+		encoder = json.NewEncoder(writer)
+	)
+
+	if m.Version == 0 {
+		m.Version = ManifestVersion
+	}
+
+	e = m.Validate()
+	if e != nil {
+		return
+	}
+
+	encoder.SetIndent("", "\t")
+
+	e = encoder.Encode(m)
+	if e != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,87 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		e        error
+		encoder  *Encoder
+		manifest Manifest
+		read     Manifest
+		stream   bytes.Buffer
+	)
+
+	encoder = NewEncoder(&stream, fnv.New32a(),
+		WithHeaderChecksum(), WithSortedKeys(),
+	)
+
+	assert.NoError(t, encoder.Encode([]byte("a"), []byte("one")))
+	assert.NoError(t, encoder.Encode([]byte("b"), []byte("two")))
+
+	manifest = Manifest{
+		Environment: "db1:/var/lib/app",
+		Snapshot:    "2",
+		Parent:      "1",
+		Created:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Streams:     make([]ManifestStream, 1),
+	}
+
+	manifest.Streams[0], e = DescribeStream("main.bl", "",
+		bytes.NewReader(stream.Bytes()), fnv.New32a(),
+	)
+
+	assert.NoError(t, e)
+	assert.Equal(t, int64(2), manifest.Streams[0].Records)
+	assert.Equal(t, int64(stream.Len()), manifest.Streams[0].Bytes)
+	assert.Equal(t, []string{"header-checksum", "sorted-keys"},
+		manifest.Streams[0].Features,
+	)
+
+	assert.NoError(t, WriteManifest(&buffer, manifest))
+
+	read, e = ReadManifest(&buffer)
+
+	manifest.Version = ManifestVersion
+
+	assert.NoError(t, e)
+	assert.Equal(t, manifest, read)
+
+	assert.NoError(t,
+		read.Streams[0].Verify(bytes.NewReader(stream.Bytes()), nil),
+	)
+
+	stream.Bytes()[stream.Len()-5] ^= 1
+
+	assert.ErrorContains(t,
+		read.Streams[0].Verify(bytes.NewReader(stream.Bytes()), nil),
+		"does not match",
+	)
+
+	read.Streams[0].Features = append(read.Streams[0].Features, "teleport")
+
+	assert.ErrorContains(t, read.Validate(), "unsupported feature")
+
+	read.Streams[0].Features = nil
+	read.Streams[0].SnapshotID = "abc"
+
+	assert.ErrorContains(t, read.Validate(), "malformed snapshot ID")
+
+	read.Streams[0].SnapshotID = ""
+	read.Streams = append(read.Streams[:1], read.Streams[0])
+
+	assert.ErrorContains(t, read.Validate(), "duplicate stream")
+
+	read.Version = ManifestVersion + 1
+
+	assert.ErrorContains(t, read.Validate(), "unsupported version")
+
+	return
+}
@@ -0,0 +1,267 @@
+// Package memcached warms memcached servers from streams of records.
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/internal/wrap"
+)
+
+const (
+	memcachedPipelineLen = 64
+	memcachedMaxKeyLen   = 250
+	memcachedMaxRelative = 30 * 24 * time.Hour
+
+	// Magic bytes, opcodes and statuses of the memcached binary protocol.
+
+	memcachedRequest  = 0x80
+	memcachedResponse = 0x81
+	memcachedNoop     = 0x0a
+	memcachedSetQ     = 0x11
+	memcachedDeleteQ  = 0x14
+	memcachedNotFound = 0x0001
+	memcachedHeadLen  = 24
+)
+
+// A Warm loads a stream of records into memcached, to warm a cache
+// from a snapshot, such as after a deployment: every record marked with
+// XMetaFlagTombstone as a delete of its key, and every other as a set of its
+// key to its value. Commands are pipelined over the binary protocol, quietly,
+// so that the server replies only to those that fail.
+type Warm struct {
+	// Prefix, if not empty, restricts the records loaded to those whose keys
+	// begin with it.
+	Prefix []byte
+
+	// TTL, if not nil, returns the time to live of a record, none if zero.
+	// Times to live beyond 30 days are sent as absolute expiry times, as
+	// memcached requires.
+	TTL func(key, val []byte, xmv bl.XMetaValue) time.Duration
+
+	// PipelineLen is the number of commands sent before their replies are
+	// awaited, 64 if zero.
+	PipelineLen int
+}
+
+// Run loads the records of the Decoder over the connection until the end of
+// the stream, and returns the number of commands executed. Deleting a key
+// that is not cached is not an error. It returns the first error replied by
+// the server, naming the key. The context is consulted between pipelines; a
+// connection whose reads and writes should be interrupted must have
+// deadlines of its own.
+func (m Warm) Run(ctx context.Context, conn io.ReadWriter,
+	decoder *bl.Decoder,
+) (n int64, e error) {
+	defer wrap.Errorf("could not warm memcached", &e)
+
+	var (
+		key    []byte
+		keys   [][]byte
+		length = m.PipelineLen
+		reader = bufio.NewReader(conn)
+		val    []byte
+		writer = bufio.NewWriter(conn)
+		xmv    byte
+	)
+
+	if length <= 0 {
+		length = memcachedPipelineLen
+	}
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		if !bytes.HasPrefix(key, m.Prefix) {
+			continue
+		}
+
+		if len(key) > memcachedMaxKeyLen {
+			return n, fmt.Errorf("key %x longer than %d bytes",
+				key, memcachedMaxKeyLen,
+			)
+		}
+
+		if bl.XMetaValue(xmv).HasFlag(bl.XMetaFlagTombstone) {
+			memcachedCommand(writer, memcachedDeleteQ, len(keys), key,
+				nil, nil,
+			)
+		} else {
+			memcachedCommand(writer, memcachedSetQ, len(keys), key,
+				m.extras(key, val, bl.XMetaValue(xmv)), val,
+			)
+		}
+
+		keys = append(keys, bytes.Clone(key))
+
+		if len(keys) < length {
+			continue
+		}
+
+		e = memcachedExchange(ctx, reader, writer, keys)
+		if e != nil {
+			return
+		}
+
+		n += int64(len(keys))
+
+		keys = keys[:0]
+	}
+
+	e = memcachedExchange(ctx, reader, writer, keys)
+	if e != nil {
+		return
+	}
+
+	return n + int64(len(keys)), nil
+}
+
+func (m Warm) extras(key, val []byte, xmv bl.XMetaValue) []byte {
+	// Returns the extras of a set: flags, always zero, and the expiration.
+
+	var (
+		expiration uint32
+		ttl        time.Duration
+	)
+
+	if m.TTL != nil {
+		ttl = m.TTL(key, val, xmv)
+	}
+
+	switch {
+	case ttl > memcachedMaxRelative:
+		expiration = uint32(time.Now().Add(ttl).Unix())
+
+	case ttl > 0:
+		expiration = uint32((ttl + time.Second - 1) / time.Second)
+	}
+
+	return binary.BigEndian.AppendUint32(make([]byte, 4), expiration)
+}
+
+// An Error is an error replied by a memcached server.
+type Error struct {
+	Status  uint16
+	Message string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("status %#04x: %s", e.Status, e.Message)
+}
+
+func memcachedCommand(writer *bufio.Writer, opcode byte, opaque int,
+	key, extras, val []byte,
+) {
+	// Buffers a request, identified by its opaque value.
+
+	var (
+		header [memcachedHeadLen]byte
+	)
+
+	header[0] = memcachedRequest
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(header[8:],
+		uint32(len(extras)+len(key)+len(val)),
+	)
+	binary.BigEndian.PutUint32(header[12:], uint32(opaque))
+
+	writer.Write(header[:])
+	writer.Write(extras)
+	writer.Write(key)
+	writer.Write(val)
+
+	return
+}
+
+func memcachedExchange(ctx context.Context, reader *bufio.Reader,
+	writer *bufio.Writer, keys [][]byte,
+) (e error) {
+	// Sends the buffered requests, one per key, followed by a no-op, whose
+	// reply follows those of any requests that failed, and receives the
+	// replies, returning the first error replied, naming its key.
+
+	var (
+		body   []byte
+		first  error
+		header [memcachedHeadLen]byte
+		opaque uint32
+		status uint16
+	)
+
+	if len(keys) == 0 {
+		return
+	}
+
+	e = ctx.Err()
+	if e != nil {
+		return
+	}
+
+	memcachedCommand(writer, memcachedNoop, len(keys), nil, nil, nil)
+
+	e = writer.Flush()
+	if e != nil {
+		return
+	}
+
+	for {
+		_, e = io.ReadFull(reader, header[:])
+		if e != nil {
+			return
+		}
+
+		if header[0] != memcachedResponse {
+			return fmt.Errorf("malformed reply")
+		}
+
+		body = make([]byte, binary.BigEndian.Uint32(header[8:]))
+
+		_, e = io.ReadFull(reader, body)
+		if e != nil {
+			return
+		}
+
+		if header[1] == memcachedNoop {
+			return first
+		}
+
+		opaque = binary.BigEndian.Uint32(header[12:])
+		if opaque >= uint32(len(keys)) {
+			return fmt.Errorf("reply to unknown request %d", opaque)
+		}
+
+		status = binary.BigEndian.Uint16(header[6:])
+
+		if header[1] == memcachedDeleteQ && status == memcachedNotFound {
+			continue
+		}
+
+		if first == nil {
+			// The message follows any extras and key.
+
+			body = body[min(len(body),
+				int(header[4])+int(binary.BigEndian.Uint16(header[2:])),
+			):]
+
+			first = fmt.Errorf("key %x: %w", keys[opaque],
+				Error{status, string(body)},
+			)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+type fakeMemcached struct {
+	// Serves quiet sets and deletes, and no-ops, on a map, refusing values
+	// longer than four bytes, and recording the expiration of every key.
+
+	data        map[string][]byte
+	expirations map[string]uint32
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	var (
+		body   []byte
+		e      error
+		found  bool
+		header [memcachedHeadLen]byte
+		key    []byte
+		reader = bufio.NewReader(conn)
+		val    []byte
+		writer = bufio.NewWriter(conn)
+	)
+
+	defer conn.Close()
+
+	for {
+		_, e = io.ReadFull(reader, header[:])
+		if e != nil {
+			return
+		}
+
+		body = make([]byte, binary.BigEndian.Uint32(header[8:]))
+
+		_, e = io.ReadFull(reader, body)
+		if e != nil {
+			return
+		}
+
+		key = body[header[4]:][:binary.BigEndian.Uint16(header[2:])]
+		val = body[int(header[4])+len(key):]
+
+		switch header[1] {
+		case memcachedSetQ:
+			if len(val) > 4 {
+				f.reply(writer, header, 0x0003, "Too large.")
+
+				continue
+			}
+
+			f.data[string(key)] = val
+			f.expirations[string(key)] = binary.BigEndian.Uint32(body[4:])
+
+		case memcachedDeleteQ:
+			_, found = f.data[string(key)]
+			if !found {
+				f.reply(writer, header, memcachedNotFound, "Not found")
+
+				continue
+			}
+
+			delete(f.data, string(key))
+
+		case memcachedNoop:
+			f.reply(writer, header, 0, "")
+
+			writer.Flush()
+		}
+	}
+}
+
+func (f *fakeMemcached) reply(writer *bufio.Writer,
+	request [memcachedHeadLen]byte, status uint16, message string,
+) {
+	var (
+		header [memcachedHeadLen]byte
+	)
+
+	header[0] = memcachedResponse
+	header[1] = request[1]
+	binary.BigEndian.PutUint16(header[6:], status)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(message)))
+	copy(header[12:16], request[12:16])
+
+	writer.Write(header[:])
+	writer.WriteString(message)
+
+	return
+}
+
+func TestWarm(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		client  net.Conn
+		e       error
+		encoder = bl.NewEncoder(&buffer, nil)
+		fake    = &fakeMemcached{
+			data:        make(map[string][]byte),
+			expirations: make(map[string]uint32),
+		}
+		n         int64
+		server    net.Conn
+		tombstone = bl.NewXMetaValue(0, bl.XMetaFlagTombstone)
+		warm      = Warm{
+			Prefix: []byte("user:"),
+			TTL: func(key, val []byte, xmv bl.XMetaValue) time.Duration {
+				if bytes.Equal(key, []byte("user:c")) {
+					return 365 * 24 * time.Hour
+				}
+
+				return time.Duration(len(val)) * time.Minute
+			},
+			PipelineLen: 2,
+		}
+	)
+
+	client, server = net.Pipe()
+
+	defer client.Close()
+
+	go fake.serve(server)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("user:a"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("session:a"), []byte("2")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("user:b"), []byte("22")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("user:c"), []byte("333")),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("user:b"), nil, tombstone),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("user:z"), nil, tombstone),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	n, e = warm.Run(context.Background(), client, bl.NewDecoder(&buffer, nil))
+	assert.NoError(t, e)
+	assert.EqualValues(t, 5, n)
+	assert.Equal(t,
+		map[string][]byte{"user:a": []byte("1"), "user:c": []byte("333")},
+		fake.data,
+	)
+	assert.EqualValues(t, 60, fake.expirations["user:a"])
+	assert.InDelta(t,
+		time.Now().Add(365*24*time.Hour).Unix(),
+		fake.expirations["user:c"], 5,
+	)
+
+	// A set refused by the server fails the warming once the replies of its
+	// pipeline are received.
+
+	buffer.Reset()
+
+	encoder = bl.NewEncoder(&buffer, nil)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("user:big"), []byte("55555")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("user:d"), []byte("4")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	_, e = Warm{}.Run(context.Background(),
+		client, bl.NewDecoder(&buffer, nil),
+	)
+	assert.ErrorAs(t, e, &Error{})
+	assert.ErrorContains(t, e,
+		"key 757365723a626967: status 0x0003: Too large.",
+	)
+	assert.Equal(t, []byte("4"), fake.data["user:d"])
+	assert.Zero(t, fake.expirations["user:d"])
+
+	return
+}
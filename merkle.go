@@ -0,0 +1,71 @@
+package bottledlightning
+
+import (
+	"crypto/sha256"
+)
+
+type merkleTree struct {
+	// Holds the roots of the complete subtrees of the leaves added so far,
+	// in order of decreasing size, as a binary counter would.
+
+	roots [][]byte
+	sizes []int
+}
+
+func merkleNode(left, right []byte) []byte {
+	var (
+		node = sha256.New()
+	)
+
+	node.Write([]byte{1})
+	node.Write(left)
+	node.Write(right)
+
+	return node.Sum(nil)
+}
+
+func (t *merkleTree) add(leaf []byte) {
+	var (
+		i = len(t.roots)
+	)
+
+	t.roots = append(t.roots, leaf)
+	t.sizes = append(t.sizes, 1)
+
+	for i > 0 && t.sizes[i-1] == t.sizes[i] {
+		t.roots[i-1] = merkleNode(t.roots[i-1], t.roots[i])
+		t.sizes[i-1] *= 2
+
+		t.roots = t.roots[:i]
+		t.sizes = t.sizes[:i]
+
+		i--
+	}
+
+	return
+}
+
+func (t *merkleTree) root() (root []byte) {
+	// Returns the root of the tree, folding the complete subtrees from the
+	// right, which yields the same tree as RFC 6962 for any number of
+	// leaves.
+
+	var (
+		i int
+		r [sha256.Size]byte
+	)
+
+	if len(t.roots) == 0 {
+		r = sha256.Sum256(nil)
+
+		return r[:]
+	}
+
+	root = t.roots[len(t.roots)-1]
+
+	for i = len(t.roots) - 2; i >= 0; i-- {
+		root = merkleNode(t.roots[i], root)
+	}
+
+	return
+}
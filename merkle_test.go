@@ -0,0 +1,42 @@
+package bottledlightning
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleTree(t *testing.T) {
+	var (
+		i      int
+		leaves [][]byte
+		tree   merkleTree
+	)
+
+	assert.Equal(t,
+		sha256.New().Sum(nil),
+		tree.root(),
+	)
+
+	for i = 0; i < 5; i++ {
+		leaves = append(leaves,
+			[]byte{byte(i)},
+		)
+
+		tree.add(leaves[i])
+	}
+
+	assert.Equal(t,
+		merkleNode(
+			merkleNode(
+				merkleNode(leaves[0], leaves[1]),
+				merkleNode(leaves[2], leaves[3]),
+			),
+			leaves[4],
+		),
+		tree.root(),
+	)
+
+	return
+}
@@ -0,0 +1,201 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// A Publisher delivers messages to a message broker such as Kafka or NATS
+// JetStream. The key is intended for partitioning, so that all messages
+// concerning the same LMDB key are delivered in order.
+type Publisher interface {
+	Publish(key, message []byte) error
+}
+
+// A Subscriber receives messages, in order, from a message broker such as
+// Kafka or NATS JetStream. Next returns [io.EOF] when there are no more
+// messages.
+type Subscriber interface {
+	Next() (message []byte, e error)
+}
+
+// A MessageEncoder is a variant of Encoder that publishes each record as a
+// separate message, enabling fan-out through a message broker. Every message
+// is an envelope consisting of
+//
+//   - 8 bytes to hold a sequence number, incremented per message, and
+//   - the record, framed exactly as by an Encoder, metadata and checksum
+//     included.
+//
+// MessageEncoders are safe for concurrent use by multiple goroutines.
+type MessageEncoder struct {
+	publisher Publisher
+	encoder   *Encoder
+	buffer    bytes.Buffer
+	sequence  uint64
+	mutex     sync.Mutex
+}
+
+// NewMessageEncoder returns a new MessageEncoder that will publish through the
+// Publisher, numbering messages from the given sequence number onwards, and
+// optionally append a 32-bit checksum to every record if the [hash.Hash32] is
+// not nil.
+func NewMessageEncoder(publisher Publisher, hasher hash.Hash32,
+	sequence uint64,
+) (
+	m *MessageEncoder,
+) {
+	m = &MessageEncoder{
+		publisher: publisher,
+		sequence:  sequence,
+	}
+
+	m.encoder = NewEncoder(&m.buffer, hasher)
+
+	return
+}
+
+// Encode publishes a key-value record.
+func (m *MessageEncoder) Encode(key, val []byte) error {
+	return m.encode(key, val, XMetaValue0)
+}
+
+// EncodeX publishes a key-value record with extended metadata.
+func (m *MessageEncoder) EncodeX(key, val []byte, xmv XMetaValue) error {
+	return m.encode(key, val, xmv)
+}
+
+// Sequence returns the sequence number that will be assigned to the next
+// message.
+func (m *MessageEncoder) Sequence() uint64 {
+	m.mutex.Lock()
+
+	defer m.mutex.Unlock()
+
+	return m.sequence
+}
+
+// Close closes the underlying Encoder, publishing as a final message any
+// trailers that the stream requires. It does not close the Publisher.
+func (m *MessageEncoder) Close() (e error) {
+	defer errorf("could not close message encoder", &e)
+
+	m.mutex.Lock()
+
+	defer m.mutex.Unlock()
+
+	m.buffer.Reset()
+
+	e = binary.Write(&m.buffer, binary.BigEndian, m.sequence)
+	if e != nil {
+		return
+	}
+
+	e = m.encoder.Close()
+	if e != nil {
+		return
+	}
+
+	if m.buffer.Len() == envelopeSeqLen {
+		return
+	}
+
+	e = m.publisher.Publish(nil,
+		m.buffer.Bytes(),
+	)
+	if e != nil {
+		return
+	}
+
+	m.sequence++
+
+	return
+}
+
+func (m *MessageEncoder) encode(key, val []byte, xmv XMetaValue) (e error) {
+	defer errorf("could not publish record", &e)
+
+	m.mutex.Lock()
+
+	defer m.mutex.Unlock()
+
+	m.buffer.Reset()
+
+	e = binary.Write(&m.buffer, binary.BigEndian, m.sequence)
+	if e != nil {
+		return
+	}
+
+	e = m.encoder.encode(key, val, xmv)
+	if e != nil {
+		return
+	}
+
+	e = m.publisher.Publish(key,
+		m.buffer.Bytes(),
+	)
+	if e != nil {
+		return
+	}
+
+	m.sequence++
+
+	return
+}
+
+// A MessageReader reconstructs, from messages published by a MessageEncoder,
+// a stream of records that can be received by a Decoder.
+type MessageReader struct {
+	subscriber Subscriber
+	remainder  []byte
+	sequence   uint64
+}
+
+// NewMessageReader returns a new MessageReader that will receive messages
+// through the Subscriber.
+func NewMessageReader(subscriber Subscriber) (r *MessageReader) {
+	r = &MessageReader{
+		subscriber: subscriber,
+	}
+
+	return
+}
+
+// Read implements [io.Reader]. It returns [io.EOF] once the Subscriber has no
+// more messages.
+func (r *MessageReader) Read(p []byte) (n int, e error) {
+	var (
+		message []byte
+	)
+
+	for len(r.remainder) == 0 {
+		message, e = r.subscriber.Next()
+		if e != nil {
+			return
+		}
+
+		if len(message) < envelopeSeqLen {
+			return 0, fmt.Errorf("message envelope too short")
+		}
+
+		r.sequence = binary.BigEndian.Uint64(message)
+
+		r.remainder = message[envelopeSeqLen:]
+	}
+
+	n = copy(p, r.remainder)
+
+	r.remainder = r.remainder[n:]
+
+	return
+}
+
+// Sequence returns the sequence number of the message most recently received.
+// Because a Decoder reads no further than the record it returns, this is the
+// sequence number of that record.
+func (r *MessageReader) Sequence() uint64 {
+	return r.sequence
+}
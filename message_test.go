@@ -0,0 +1,102 @@
+package bottledlightning
+
+import (
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testBroker struct {
+	keys     [][]byte
+	messages [][]byte
+}
+
+func (b *testBroker) Publish(key, message []byte) error {
+	b.keys = append(b.keys, key)
+	b.messages = append(b.messages,
+		append([]byte{}, message...),
+	)
+
+	return nil
+}
+
+func (b *testBroker) Next() (message []byte, e error) {
+	if len(b.messages) == 0 {
+		return nil, io.EOF
+	}
+
+	message, b.messages = b.messages[0], b.messages[1:]
+
+	return
+}
+
+func TestMessage(t *testing.T) {
+	var (
+		broker testBroker
+		e      error
+		key    []byte
+		val    []byte
+		xmv    byte
+
+		encoder = NewMessageEncoder(&broker, fnv.New32a(), 41)
+		reader  = NewMessageReader(&broker)
+		decoder = NewDecoder(reader, fnv.New32a())
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("alpha"), []byte("one")),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeX([]byte("beta"), []byte("two"), XMetaValue7),
+	)
+
+	assert.Equal(t, uint64(43),
+		encoder.Sequence(),
+	)
+
+	assert.Equal(t, [][]byte{[]byte("alpha"), []byte("beta")},
+		broker.keys,
+	)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.Error(t,
+		encoder.Encode([]byte("gamma"), []byte("three")),
+	)
+
+	assert.Len(t, broker.messages, 2)
+
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 41},
+		broker.messages[0][:envelopeSeqLen],
+	)
+
+	key, val, e = decoder.Decode()
+	if e != nil {
+		t.Error(e)
+	}
+
+	assert.Equal(t, []byte("alpha"), key)
+	assert.Equal(t, []byte("one"), val)
+	assert.Equal(t, uint64(41), reader.Sequence())
+
+	key, val, xmv, e = decoder.DecodeX()
+	if e != nil {
+		t.Error(e)
+	}
+
+	assert.Equal(t, []byte("beta"), key)
+	assert.Equal(t, []byte("two"), val)
+	assert.Equal(t, byte(XMetaValue7), xmv)
+	assert.Equal(t, uint64(42), reader.Sequence())
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
@@ -0,0 +1,64 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// An XMetaFlag is a bit of an XMetaValue reserved for a meaning defined by the
+// format, which applications must not assign a meaning of their own.
+type XMetaFlag byte
+
+const (
+	// XMetaFlagTombstone marks a record as recording the deletion of its key
+	// rather than a value, for streams of changes such as the incremental
+	// dumps of DiffDump, which a Loader applies as deletions.
+	XMetaFlagTombstone XMetaFlag = 1 << 3
+)
+
+const (
+	xMetaUserMask = 1<<3 - 1
+)
+
+// NewXMetaValue returns the XMetaValue carrying the three least significant
+// bits of userBits and the given flags.
+func NewXMetaValue(userBits byte, flags ...XMetaFlag) (xmv XMetaValue) {
+	var (
+		flag XMetaFlag
+	)
+
+	xmv = XMetaValue(userBits & xMetaUserMask)
+
+	for _, flag = range flags {
+		xmv |= XMetaValue(flag)
+	}
+
+	return
+}
+
+// UserBits returns the bits of the XMetaValue available to applications,
+// which are unaffected by any flags that the format may define.
+func (xmv XMetaValue) UserBits() byte {
+	return byte(xmv) & xMetaUserMask
+}
+
+// HasFlag returns true if the XMetaValue carries the flag.
+func (xmv XMetaValue) HasFlag(flag XMetaFlag) bool {
+	return XMetaFlag(xmv)&flag == flag
+}
+
+func (xmv XMetaValue) validate() error {
+	// Returns an error if the XMetaValue does not fit in the four bits of the
+	// M field of a record header.
+
+	if xmv > XMetaValueF {
+		return fmt.Errorf("extended metadata value %#x out of range", byte(xmv))
+	}
+
+	return nil
+}
+
+func (o *options) admitsMeta(xmv byte) bool {
+	// Returns true unless a metadata filter is configured that excludes xmv.
+
+	return !o.metaFiltered || o.metaFilter&(1<<(xmv&byte(XMetaValueF))) != 0
+}
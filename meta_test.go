@@ -0,0 +1,96 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXMetaValue(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		e      error
+		xmv    byte
+	)
+
+	assert.Equal(t, XMetaValue5, NewXMetaValue(5))
+	assert.Equal(t, XMetaValueD, NewXMetaValue(5, XMetaFlagTombstone))
+	assert.Equal(t, XMetaValue8, NewXMetaValue(0xf8, XMetaFlagTombstone))
+
+	assert.Equal(t, byte(5), XMetaValueD.UserBits())
+	assert.True(t, XMetaValueD.HasFlag(XMetaFlagTombstone))
+	assert.False(t, XMetaValue7.HasFlag(XMetaFlagTombstone))
+
+	assert.NoError(t,
+		NewEncoder(&buffer, nil).EncodeX([]byte("key"), nil,
+			NewXMetaValue(3, XMetaFlagTombstone),
+		),
+	)
+
+	_, _, xmv, e = NewDecoder(&buffer, nil).DecodeX()
+
+	assert.NoError(t, e)
+	assert.Equal(t, byte(3), XMetaValue(xmv).UserBits())
+	assert.True(t, XMetaValue(xmv).HasFlag(XMetaFlagTombstone))
+
+	// A value wider than the four bits of the header would corrupt it, and is
+	// refused before anything is written.
+
+	buffer.Reset()
+
+	assert.ErrorContains(t,
+		NewEncoder(&buffer, nil).EncodeX([]byte("key"), nil, XMetaValueF+1),
+		"extended metadata value 0x10 out of range",
+	)
+	assert.ErrorContains(t,
+		NewEncoder(&buffer, nil).EncodeFromX([]byte("key"),
+			bytes.NewReader(nil), 0, 0x80,
+		),
+		"out of range",
+	)
+	assert.ErrorContains(t,
+		NewEncoder(&buffer, nil).writeXCMK(3, 0, 0xff),
+		"out of range",
+	)
+	assert.Zero(t, buffer.Len())
+
+	return
+}
+
+func TestMetaFilter(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, fnv.New32a(), WithDeduplication(1<<10))
+		key     []byte
+		keys    []string
+		val     = bytes.Repeat([]byte("v"), dedupMinValLen)
+	)
+
+	assert.NoError(t, encoder.EncodeX([]byte("config"), val, XMetaValue1))
+	assert.NoError(t, encoder.EncodeX([]byte("data"), val, XMetaValue2))
+	assert.NoError(t, encoder.EncodeX([]byte("gone"), val, XMetaValueA))
+	assert.NoError(t, encoder.EncodeX([]byte("more"), val, XMetaValue2))
+
+	decoder = NewDecoder(&buffer, fnv.New32a(),
+		WithMetaFilter(XMetaValue2, XMetaValueA),
+	)
+
+	for {
+		key, _, e = decoder.Decode()
+		if e != nil {
+			break
+		}
+
+		keys = append(keys, string(key))
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.Equal(t, []string{"data", "gone", "more"}, keys)
+
+	return
+}
@@ -0,0 +1,162 @@
+package bottledlightning
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+type mirror struct {
+	// Fans writes out to several destinations, as would io.MultiWriter, but
+	// fails a destination on its first error rather than the whole write, so
+	// that the others are unaffected. A write fails only if every destination
+	// has failed.
+
+	writers []io.Writer
+	errs    []error
+}
+
+func newMirror(writers ...io.Writer) *mirror {
+	return &mirror{
+		writers: writers,
+		errs:    make([]error, len(writers)),
+	}
+}
+
+func (m *mirror) Write(b []byte) (n int, e error) {
+	var (
+		i int
+	)
+
+	for i = range m.writers {
+		if m.errs[i] != nil {
+			continue
+		}
+
+		_, m.errs[i] = m.writers[i].Write(b)
+	}
+
+	e = m.err()
+	if e != nil {
+		return
+	}
+
+	return len(b), nil
+}
+
+func (m *mirror) Flush() (e error) {
+	m.each(
+		func(writer io.Writer) error {
+			var (
+				ok bool
+				w  flusher
+			)
+
+			w, ok = writer.(flusher)
+			if !ok {
+				return nil
+			}
+
+			return w.Flush()
+		},
+	)
+
+	return m.err()
+}
+
+func (m *mirror) Sync() (e error) {
+	m.each(
+		func(writer io.Writer) error {
+			var (
+				ok bool
+				w  syncer
+			)
+
+			w, ok = writer.(syncer)
+			if !ok {
+				return nil
+			}
+
+			return w.Sync()
+		},
+	)
+
+	return m.err()
+}
+
+func (m *mirror) SetWriteDeadline(t time.Time) (e error) {
+	m.each(
+		func(writer io.Writer) error {
+			var (
+				ok bool
+				w  interface{ SetWriteDeadline(time.Time) error }
+			)
+
+			w, ok = writer.(interface{ SetWriteDeadline(time.Time) error })
+			if !ok {
+				return nil
+			}
+
+			return w.SetWriteDeadline(t)
+		},
+	)
+
+	return m.err()
+}
+
+func (m *mirror) each(f func(io.Writer) error) {
+	// Applies f to every destination that has not failed, failing those for
+	// which it returns an error.
+
+	var (
+		i int
+	)
+
+	for i = range m.writers {
+		if m.errs[i] == nil {
+			m.errs[i] = f(m.writers[i])
+		}
+	}
+
+	return
+}
+
+func (m *mirror) err() error {
+	// Returns an error joining those of every destination if all have
+	// failed, and nil otherwise.
+
+	var (
+		e    error
+		errs []error
+		i    int
+	)
+
+	for i, e = range m.errs {
+		if e == nil {
+			return nil
+		}
+
+		errs = append(errs,
+			fmt.Errorf("destination %d: %w", i, e),
+		)
+	}
+
+	return errors.Join(errs...)
+}
+
+// MirrorErrors returns, for the [io.Writer] passed to NewEncoder followed by
+// each configured with WithMirror, in order, the error on which the Encoder
+// stopped writing to it, or nil if it has not failed. It returns nil if no
+// mirrors are configured.
+func (n *Encoder) MirrorErrors() (errs []error) {
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if n.mirror == nil {
+		return
+	}
+
+	return append(errs, n.mirror.errs...)
+}
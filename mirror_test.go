@@ -0,0 +1,63 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirror(t *testing.T) {
+	var (
+		a, b    bytes.Buffer
+		e       error
+		encoder *Encoder
+		errs    []error
+		key     []byte
+		val     []byte
+	)
+
+	encoder = NewEncoder(&a, fnv.New32a(),
+		WithMirror(failingWriter{}, &b),
+		WithBatchChecksum(2),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t, encoder.Close())
+
+	assert.Equal(t, a.Bytes(), b.Bytes())
+
+	errs = encoder.MirrorErrors()
+
+	if assert.Len(t, errs, 3) {
+		assert.NoError(t, errs[0])
+		assert.Error(t, errs[1])
+		assert.NoError(t, errs[2])
+	}
+
+	key, val, e = NewDecoder(&b, fnv.New32a()).Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("key"), key)
+	assert.Equal(t, []byte("val"), val)
+
+	encoder = NewEncoder(failingWriter{}, nil,
+		WithMirror(failingWriter{}),
+	)
+
+	assert.Error(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.Len(t, encoder.MirrorErrors(), 2)
+
+	assert.Nil(t,
+		NewEncoder(&a, nil).MirrorErrors(),
+	)
+
+	return
+}
@@ -0,0 +1,171 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A MirrorTxn is a write transaction on an LMDB database, as provided by the
+// bindings of an application, to which a MirrorSink applies changes.
+type MirrorTxn interface {
+	Put(key, val []byte) error
+	Del(key []byte) error
+	Commit() error
+	Abort()
+}
+
+// A MirrorSink applies changes to a MirrorTxn and, once the transaction is
+// committed, forwards them to an Encoder, deletions as records carrying
+// XMetaFlagTombstone, so that the stream records exactly the transactions
+// applied locally, in the order in which they commit. Changes of a transaction
+// that is aborted, or fails to commit, are never transmitted.
+//
+// A MirrorSink serves a single transaction and is not safe for concurrent use,
+// but any number of them may share an Encoder.
+type MirrorSink struct {
+	txn     MirrorTxn
+	encoder *Encoder
+	changes []mirrorChange
+	done    bool
+}
+
+type mirrorChange struct {
+	key     []byte
+	val     []byte
+	deleted bool
+}
+
+// NewMirrorSink returns a MirrorSink applying changes to the MirrorTxn and
+// forwarding them to the Encoder.
+func NewMirrorSink(txn MirrorTxn, encoder *Encoder) *MirrorSink {
+	return &MirrorSink{
+		txn:     txn,
+		encoder: encoder,
+	}
+}
+
+// Put stores the value under the key in the transaction, and holds the change
+// for the stream until Commit.
+func (s *MirrorSink) Put(key, val []byte) (e error) {
+	defer errorf("could not put to mirror", &e)
+
+	if s.done {
+		return fmt.Errorf("transaction committed or aborted")
+	}
+
+	e = s.txn.Put(key, val)
+	if e != nil {
+		return
+	}
+
+	s.changes = append(s.changes,
+		mirrorChange{
+			key: bytes.Clone(key),
+			val: bytes.Clone(val),
+		},
+	)
+
+	return
+}
+
+// Delete removes the key in the transaction, and holds the change for the
+// stream until Commit.
+func (s *MirrorSink) Delete(key []byte) (e error) {
+	defer errorf("could not delete from mirror", &e)
+
+	if s.done {
+		return fmt.Errorf("transaction committed or aborted")
+	}
+
+	e = s.txn.Del(key)
+	if e != nil {
+		return
+	}
+
+	s.changes = append(s.changes,
+		mirrorChange{
+			key:     bytes.Clone(key),
+			deleted: true,
+		},
+	)
+
+	return
+}
+
+// Commit commits the transaction and then encodes its changes, in the order in
+// which they were applied, between the markers of Encoder.BeginTransaction and
+// CommitTransaction. Commits of the MirrorSinks of an Encoder are serialised,
+// so that the changes of each are contiguous in the stream. If the transaction
+// fails to commit, nothing is encoded; if encoding fails, the transaction
+// remains committed but the stream lacks some of its changes, and the Encoder
+// should be aborted.
+func (s *MirrorSink) Commit() (e error) {
+	defer errorf("could not commit mirror", &e)
+
+	var (
+		change mirrorChange
+		xmv    XMetaValue
+	)
+
+	if s.done {
+		return fmt.Errorf("transaction committed or aborted")
+	}
+
+	s.done = true
+
+	s.encoder.mirrorMutex.Lock()
+
+	defer s.encoder.mirrorMutex.Unlock()
+
+	e = s.txn.Commit()
+	if e != nil {
+		return
+	}
+
+	if len(s.changes) == 0 {
+		return
+	}
+
+	e = s.encoder.BeginTransaction(nil)
+	if e != nil {
+		return
+	}
+
+	for _, change = range s.changes {
+		xmv = 0
+
+		if change.deleted {
+			xmv = NewXMetaValue(0, XMetaFlagTombstone)
+		}
+
+		e = s.encoder.EncodeX(change.key, change.val, xmv)
+		if e != nil {
+			return
+		}
+	}
+
+	e = s.encoder.CommitTransaction()
+	if e != nil {
+		return
+	}
+
+	s.changes = nil
+
+	return
+}
+
+// Abort aborts the transaction and discards its changes. It does nothing if
+// the transaction has been committed or aborted already.
+func (s *MirrorSink) Abort() {
+	if s.done {
+		return
+	}
+
+	s.done = true
+
+	s.txn.Abort()
+
+	s.changes = nil
+
+	return
+}
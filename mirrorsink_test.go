@@ -0,0 +1,93 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorSink(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		db      = map[string]string{"a": "0"}
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		key     []byte
+		sink    *MirrorSink
+		txn     *mapTxn
+		val     []byte
+		xmv     byte
+	)
+
+	sink = NewMirrorSink(newMapTxn(db), encoder)
+
+	assert.NoError(t,
+		sink.Put([]byte("b"), []byte("1")),
+	)
+	assert.NoError(t,
+		sink.Delete([]byte("a")),
+	)
+	assert.NoError(t,
+		sink.Commit(),
+	)
+	assert.Error(t,
+		sink.Put([]byte("c"), []byte("2")),
+	)
+
+	// Neither an aborted transaction nor one that fails to commit reaches
+	// the stream.
+
+	sink = NewMirrorSink(newMapTxn(db), encoder)
+
+	assert.NoError(t,
+		sink.Put([]byte("c"), []byte("2")),
+	)
+
+	sink.Abort()
+
+	assert.Error(t,
+		sink.Commit(),
+	)
+
+	txn = newMapTxn(db)
+	txn.fail = errors.New("map full")
+
+	sink = NewMirrorSink(txn, encoder)
+
+	assert.NoError(t,
+		sink.Put([]byte("d"), []byte("3")),
+	)
+	assert.ErrorIs(t, sink.Commit(), txn.fail)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.Equal(t, map[string]string{"b": "1"}, db)
+
+	decoder = NewDecoder(&buffer, nil)
+
+	key, val, xmv, e = decoder.DecodeX()
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("b"), key)
+	assert.Equal(t, []byte("1"), val)
+	assert.False(t,
+		XMetaValue(xmv).HasFlag(XMetaFlagTombstone),
+	)
+
+	key, _, xmv, e = decoder.DecodeX()
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("a"), key)
+	assert.True(t,
+		XMetaValue(xmv).HasFlag(XMetaFlagTombstone),
+	)
+
+	_, _, _, e = decoder.DecodeX()
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
@@ -0,0 +1,172 @@
+package bottledlightning
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// A handshake message consists of the magic bytes handshakeMagic, followed by
+// a bitmap of features, in the order of their bits, and a bitmap of
+// compression codecs, by value, in 4 bytes each. Integers are big-endian.
+const (
+	handshakeMagic = "BLHS\x00\x01"
+	handshakeLen   = len(handshakeMagic) + 4 + 4
+)
+
+// Capabilities list the revisions of the format that a peer supports, namely
+// features by name, such as "batch-checksum" or "compression", and
+// compression codecs.
+type Capabilities struct {
+	Features     []string
+	Compressions []Compression
+}
+
+// LocalCapabilities returns the capabilities of this package.
+func LocalCapabilities() Capabilities {
+	return Capabilities{
+		Features: slices.Clone(featureNames),
+		Compressions: []Compression{
+			CompressionNone, CompressionDeflate, CompressionLZW,
+		},
+	}
+}
+
+// Handshake sends the local capabilities to the peer at the other end of the
+// connection, receives those of the peer, and returns the capabilities that
+// both support, for use with WithCapabilities, so that peers of different
+// versions can interoperate during a rolling upgrade. Both peers must call
+// Handshake before any records are transmitted.
+func Handshake(conn io.ReadWriter, local Capabilities) (
+	agreed Capabilities, e error,
+) {
+	defer errorf("could not perform handshake", &e)
+
+	var (
+		codecs   uint32
+		features uint32
+		message  = make([]byte, handshakeLen)
+		sent     = make(chan error, 1)
+	)
+
+	features, codecs, e = local.bitmaps()
+	if e != nil {
+		return
+	}
+
+	go func() {
+		var (
+			b = []byte(handshakeMagic)
+			e error
+		)
+
+		b = binary.BigEndian.AppendUint32(b, features)
+		b = binary.BigEndian.AppendUint32(b, codecs)
+
+		_, e = conn.Write(b)
+
+		sent <- e
+	}()
+
+	_, e = io.ReadFull(conn, message)
+
+	if e == nil {
+		e = <-sent
+	}
+
+	if e != nil {
+		return
+	}
+
+	if string(message[:len(handshakeMagic)]) != handshakeMagic {
+		return agreed, fmt.Errorf("peer did not send handshake")
+	}
+
+	features &= binary.BigEndian.Uint32(message[len(handshakeMagic):])
+	codecs &= binary.BigEndian.Uint32(message[len(handshakeMagic)+4:])
+
+	return newCapabilities(features, codecs), nil
+}
+
+func (o *options) restrictToPeer() {
+	// Withdraws the features and codecs that the peer does not support, if
+	// so configured, except encryption, which is never withdrawn.
+
+	var (
+		choose = o.chooseCompression
+	)
+
+	if !o.negotiated {
+		return
+	}
+
+	o.features &^= knownFeatures &^ featureEncryption &^ o.peerFeatures
+
+	if choose == nil {
+		return
+	}
+
+	o.chooseCompression = func(key, val []byte) (codec Compression) {
+		codec = choose(key, val)
+
+		if o.peerCodecs&(1<<codec) == 0 {
+			return CompressionNone
+		}
+
+		return
+	}
+
+	return
+}
+
+func (c Capabilities) bitmaps() (features, codecs uint32, e error) {
+	// Returns the capabilities as bitmaps of features and codecs.
+
+	var (
+		codec Compression
+		i     int
+		name  string
+	)
+
+	for _, name = range c.Features {
+		i = slices.Index(featureNames, name)
+		if i < 0 {
+			return 0, 0, fmt.Errorf("unknown feature %q", name)
+		}
+
+		features |= 1 << i
+	}
+
+	for _, codec = range c.Compressions {
+		if codec > CompressionLZW {
+			return 0, 0, fmt.Errorf("unknown compression %s", codec)
+		}
+
+		codecs |= 1 << codec
+	}
+
+	return
+}
+
+func newCapabilities(features, codecs uint32) (c Capabilities) {
+	// Returns the capabilities given as bitmaps of features and codecs.
+
+	var (
+		i int
+	)
+
+	for i = range featureNames {
+		if features&(1<<i) != 0 {
+			c.Features = append(c.Features, featureNames[i])
+		}
+	}
+
+	for i = 0; i < 32; i++ {
+		if codecs&(1<<i) != 0 {
+			c.Compressions = append(c.Compressions, Compression(i))
+		}
+	}
+
+	return
+}
@@ -0,0 +1,121 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshake(t *testing.T) {
+	var (
+		a, b    net.Conn
+		agreed  [2]Capabilities
+		buffer  bytes.Buffer
+		decoder *Decoder
+		done    = make(chan struct{})
+		e       error
+		encoder *Encoder
+		stream  ManifestStream
+		val     []byte
+	)
+
+	a, b = net.Pipe()
+
+	go func() {
+		defer close(done)
+
+		agreed[1], _ = Handshake(b, Capabilities{
+			Features:     []string{"header-checksum", "compression"},
+			Compressions: []Compression{CompressionNone, CompressionDeflate},
+		})
+	}()
+
+	agreed[0], e = Handshake(a, LocalCapabilities())
+
+	<-done
+
+	assert.NoError(t, e)
+	assert.Equal(t, agreed[0], agreed[1])
+
+	assert.Equal(t,
+		Capabilities{
+			Features:     []string{"header-checksum", "compression"},
+			Compressions: []Compression{CompressionNone, CompressionDeflate},
+		},
+		agreed[0],
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a(),
+		WithHeaderChecksum(),
+		WithBatchChecksum(4),
+		WithDeduplication(1<<10),
+		WithCompression(
+			func(_, _ []byte) Compression { return CompressionLZW },
+		),
+		WithCapabilities(agreed[0]),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), bytes.Repeat([]byte("val"), 100)),
+	)
+
+	assert.NoError(t, encoder.Close())
+
+	assert.Equal(t,
+		CompressionStats{Passthrough: 1},
+		encoder.CompressionStats(),
+	)
+
+	stream, e = DescribeStream("stream", "", bytes.NewReader(buffer.Bytes()),
+		fnv.New32a(),
+	)
+
+	assert.NoError(t, e)
+	assert.Equal(t, []string{"header-checksum", "compression"}, stream.Features)
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	_, val, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, bytes.Repeat([]byte("val"), 100), val)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	buffer.Reset()
+
+	encoder = NewEncoder(&buffer, nil,
+		WithEncryption("kek", newTestAEAD(t, "kek")),
+		WithCapabilities(agreed[0]),
+	)
+
+	encoder.Encode([]byte("key"), []byte("val"))
+	encoder.Close()
+
+	stream, e = DescribeStream("stream", "", &buffer, nil)
+
+	assert.NoError(t, e)
+	assert.Contains(t, stream.Features, "encryption")
+
+	_, e = Handshake(
+		struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(make([]byte, handshakeLen)), io.Discard},
+		LocalCapabilities(),
+	)
+
+	assert.ErrorContains(t, e, "peer did not send handshake")
+
+	_, e = Handshake(&buffer, Capabilities{Features: []string{"teleport"}})
+
+	assert.ErrorContains(t, e, "unknown feature")
+
+	return
+}
@@ -0,0 +1,637 @@
+package bottledlightning
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// An Option configures an Encoder or a Decoder. Options that concern only one
+// end of a stream are ignored by the other.
+type Option func(*options)
+
+type options struct {
+	adaptive          *adaptiveCompression
+	adaptiveInterval  time.Duration
+	anyComparator     bool
+	assertSorted      bool
+	asyncQueueLen     int
+	auditHook         func(Op, []byte, XMetaValue) error
+	batchAlignment    int
+	batchLen          int
+	beginTransaction  func([]byte) error
+	blobReferences    func(digest []byte)
+	blobStore         BlobStore
+	blobThreshold     int64
+	budgetPolicy      BudgetPolicy
+	checksum          *Checksum
+	chooseCompression func([]byte, []byte) Compression
+	commitTransaction func([]byte) error
+	comparators       map[string]func([]byte, []byte) int
+	decodeTransform   func([]byte, []byte) ([]byte, error)
+	dedupLimit        int64
+	encodeTransform   func([]byte, []byte) ([]byte, error)
+	features          uint32
+	formatVersion     FormatVersion
+	ioDeadline        time.Duration
+	kek               cipher.AEAD
+	kekID             string
+	keepaliveInterval time.Duration
+	keyring           func(string) (cipher.AEAD, error)
+	lazyThreshold     int64
+	livenessMonitor   func(time.Time)
+	memoryBudget      *MemoryBudget
+	metaFilter        uint16 // bit i admits XMetaValue i
+	metaFiltered      bool
+	mirrors           []io.Writer
+	negotiated        bool
+	peerCodecs        uint32
+	peerFeatures      uint32
+	profile           ValidationProfile
+	queueFullPolicy   QueueFullPolicy
+	redactMatch       func([]byte) bool
+	redactReplace     func([]byte) []byte
+	schema            *Schema
+	seekMarkerEvery   int
+	signingKey        ed25519.PrivateKey
+	snapshotID        bool
+	sortedBySource    bool
+	sourceMap         map[string]SourceTarget
+	streamLabel       string
+	syncEvery         int
+	syncInterval      time.Duration
+	valueDigests      bool
+	verifyingKey      ed25519.PublicKey
+}
+
+func newOptions(opts []Option) (o options) {
+	// Returns the configuration resulting from applying opts in order.
+
+	var (
+		opt Option
+	)
+
+	o.asyncQueueLen = asyncQueueLen
+
+	for _, opt = range opts {
+		opt(&o)
+	}
+
+	return
+}
+
+// WithAdaptiveCompression causes an Encoder to compress the value of every
+// record with the codec and level that would transmit values in the least
+// time, chosen anew every interval by weighing the throughput of the link, as
+// measured by the time taken by writes, against the cost and effect of each
+// setting, as measured on the values compressed. Only codecs supported by the
+// peer are chosen if the Encoder is also configured with WithCapabilities.
+// Every change of setting is announced in a control record, which a Decoder
+// reports by Decoder.CompressionSetting. It supersedes WithCompression.
+func WithAdaptiveCompression(interval time.Duration) Option {
+	return func(o *options) {
+		if interval <= 0 {
+			return
+		}
+
+		o.adaptiveInterval = interval
+		o.features |= featureCompression
+	}
+}
+
+// WithAssertSorted causes an Encoder to refuse to encode, and a Decoder to
+// refuse to return, a record whose key does not sort strictly after that of
+// the preceding record under the default LMDB comparator, so that pipelines
+// relying on MDB_APPEND or on Decoder.SeekToKey fail fast. Unlike
+// WithSortedKeys, it declares nothing in the stream.
+func WithAssertSorted() Option {
+	return func(o *options) {
+		o.assertSorted = true
+	}
+}
+
+// WithAsyncQueue sets the length of the submission queue of EncodeAsync, which
+// is otherwise 64 records, and what EncodeAsync does when the queue is full.
+// A length of zero makes every submission wait for, or under QueueFullReject
+// fail unless, the background goroutine is ready to receive it.
+func WithAsyncQueue(length int, policy QueueFullPolicy) Option {
+	return func(o *options) {
+		o.asyncQueueLen = max(length, 0)
+		o.queueFullPolicy = policy
+	}
+}
+
+// WithAuditHook causes an Encoder and a Decoder to report the key and metadata
+// of every record to the hook, for an audit trail, immediately before
+// transmitting or returning the record. Records are reported one at a time, in
+// the order of the stream. If the hook returns an error, the record is vetoed:
+// it is not transmitted or returned, and the error is returned wrapped instead,
+// so that the caller can abort or carry on. Decisions are counted by the
+// AuditStats methods.
+func WithAuditHook(hook func(op Op, key []byte, xmv XMetaValue) error) Option {
+	return func(o *options) {
+		o.auditHook = hook
+	}
+}
+
+// WithBatchAlignment causes an Encoder configured with WithBatchChecksum to
+// pad the stream after the checksum of every batch with a padding control
+// record, so that every batch begins at a multiple of size bytes from the
+// start of the stream, such as the block size of a tape or the page size of a
+// disk. Archives on block-oriented media can then be read block-aligned, and
+// decoding resumed at the first batch boundary after a damaged block. A
+// Decoder ignores padding without configuration. Sizes beyond 16 MiB are
+// ignored.
+func WithBatchAlignment(size int) Option {
+	return func(o *options) {
+		if size <= 0 || size > controlMaxValLen {
+			return
+		}
+
+		o.batchAlignment = size
+	}
+}
+
+// WithBatchChecksum causes an Encoder to replace the checksum of every record
+// with a checksum of every batch of n records, headers included, transmitted
+// in a control record after the last record of the batch. This cuts the
+// overhead of checksums on streams of small records, at the expense of the
+// granularity with which damage is detected: a Decoder returns the records of
+// a batch before verifying it, and reports a mismatch upon receipt of the
+// checksum. A final, possibly shorter, batch ends when the Encoder is closed.
+// The Encoder announces this revision of the format in a control record at the
+// start of the stream, and a Decoder adapts to it without configuration. The
+// option has no effect on an Encoder that does not append checksums.
+func WithBatchChecksum(n int) Option {
+	return func(o *options) {
+		if n <= 0 {
+			return
+		}
+
+		o.batchLen = n
+		o.features |= featureBatchChecksum
+	}
+}
+
+// WithBlobStore causes an Encoder to spill every value of at least threshold
+// bytes to the BlobStore, and to transmit only its digest in its place,
+// keeping the stream small and letting successive snapshots share blobs. The
+// Encoder announces this revision of the format in a control record at the
+// start of the stream. A Decoder, which ignores threshold, retrieves spilled
+// values from the BlobStore and verifies their digests.
+func WithBlobStore(store BlobStore, threshold int64) Option {
+	return func(o *options) {
+		o.blobStore = store
+
+		if threshold <= 0 {
+			return
+		}
+
+		o.blobThreshold = threshold
+		o.features |= featureBlobSpill
+	}
+}
+
+// WithCapabilities causes an Encoder to restrict itself to the capabilities
+// of its peer, as agreed by Handshake, withdrawing any feature or compression
+// codec that the peer does not support. Encryption is never withdrawn, so that
+// an Encoder that encrypts fails at the peer rather than transmit in the
+// clear.
+func WithCapabilities(agreed Capabilities) Option {
+	return func(o *options) {
+		var (
+			c     Capabilities
+			codec Compression
+			name  string
+		)
+
+		// Unknown features and codecs cannot have been agreed, and are
+		// ignored.
+
+		for _, name = range agreed.Features {
+			if slices.Contains(featureNames, name) {
+				c.Features = append(c.Features, name)
+			}
+		}
+
+		for _, codec = range agreed.Compressions {
+			if codec <= CompressionLZW {
+				c.Compressions = append(c.Compressions, codec)
+			}
+		}
+
+		o.negotiated = true
+		o.peerFeatures, o.peerCodecs, _ = c.bitmaps()
+	}
+}
+
+// WithChecksum causes an Encoder to announce, in a control record before the
+// first record, the checksum computed by the [hash.Hash32] given to
+// NewEncoder, which must agree with it, so that the stream names its own
+// checksum. A Decoder given no hash.Hash32 verifies the records that follow
+// by the announced checksum, if it knows it. See Decoder.Checksum.
+func WithChecksum(c Checksum) Option {
+	return func(o *options) {
+		o.checksum = &c
+	}
+}
+
+// WithComparator registers the custom comparator, as set on an LMDB database
+// by mdb_set_compare, under the given identifier, so that a Decoder returns
+// the records of sources tagged with it by Encoder.SetComparator, and so that
+// an Encoder and a Decoder check the order of their keys with it if the
+// stream is declared or asserted sorted. Comparators return a negative
+// number, zero or a positive number as a sorts before, with or after b.
+func WithComparator(id string, compare func(a, b []byte) int) Option {
+	return func(o *options) {
+		if o.comparators == nil {
+			o.comparators = make(map[string]func([]byte, []byte) int)
+		}
+
+		o.comparators[id] = compare
+	}
+}
+
+// WithCompression causes an Encoder to compress the value of every record with
+// the codec returned by choose for that record, so that a stream can mix, for
+// example, compressible text with values already compressed, which are best
+// transmitted as is. A value that would not shrink is transmitted as is
+// regardless, and values passed to EncodeFrom are never compressed. The choice
+// is recorded in a byte preceding the value, and the Encoder announces this
+// revision of the format in a control record at the start of the stream. A
+// Decoder adapts to it without configuration.
+func WithCompression(choose func(key, val []byte) Compression) Option {
+	return func(o *options) {
+		o.chooseCompression = choose
+		o.features |= featureCompression
+	}
+}
+
+// WithDecryption causes a Decoder to decrypt the values of records encrypted
+// by an Encoder configured with WithEncryption. The keyring returns the
+// key-encryption key with the given identifier, with which the Decoder unwraps
+// every data key that it receives.
+func WithDecryption(keyring func(id string) (cipher.AEAD, error)) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+// WithDeduplication causes an Encoder to replace the value of a record with a
+// short reference, derived from its SHA-256 digest, if it repeats a value of
+// at least 64 bytes among the most recent values totalling no more than limit
+// bytes. A Decoder reconstructs values from a cache of the same size, which
+// the Encoder announces in a control record at the start of the stream, along
+// with this revision of the format. Values passed to EncodeFrom are neither
+// replaced nor cached. The option has no effect if limit <= 0.
+func WithDeduplication(limit int64) Option {
+	return func(o *options) {
+		if limit <= 0 {
+			return
+		}
+
+		o.dedupLimit = limit
+		o.features |= featureDedup
+	}
+}
+
+// WithEncryption causes an Encoder to encrypt the value of every record using
+// AES-256-GCM under a data key of its own generation, which it transmits,
+// wrapped with the key-encryption key and labelled with the identifier, in a
+// control record before the first record. Keys, which LMDB needs in plaintext
+// to order records, are authenticated along with values but not encrypted.
+// The Encoder announces encryption in a control record at the start of the
+// stream, so that Decoders that cannot decrypt values refuse the stream
+// rather than return ciphertext. See also Encoder.RotateKey.
+func WithEncryption(id string, kek cipher.AEAD) Option {
+	return func(o *options) {
+		o.kek = kek
+		o.kekID = id
+		o.features |= featureEncryption
+	}
+}
+
+// WithFormatVersion pins a Decoder to the given format version, so that it
+// refuses a stream of any other, rather than detecting the version of each
+// stream and receiving either. See FormatVersion.
+func WithFormatVersion(v FormatVersion) Option {
+	return func(o *options) {
+		o.formatVersion = v
+	}
+}
+
+// WithHeaderChecksum causes an Encoder to extend the checksum of every record
+// to cover its header, so that damage to the length and metadata fields is
+// detected as surely as damage to the key or value. The Encoder announces this
+// revision of the format in a control record at the start of the stream, and
+// a Decoder adapts to it without configuration. The option has no effect on an
+// Encoder that does not append checksums.
+func WithHeaderChecksum() Option {
+	return func(o *options) {
+		o.features |= featureHeaderChecksum
+	}
+}
+
+// WithIODeadline bounds the time an Encoder or a Decoder may spend on the
+// transmission or receipt of any one record. Before each record, the write
+// or read deadline of the underlying stream is set to d from now, provided
+// that the stream implements SetWriteDeadline or SetReadDeadline
+// respectively, as does a [net.Conn]. The option has no effect otherwise.
+func WithIODeadline(d time.Duration) Option {
+	return func(o *options) {
+		o.ioDeadline = d
+	}
+}
+
+// WithKeepalive causes an Encoder to transmit a lightweight keepalive control
+// record whenever no record has been transmitted for the given interval, so
+// that intermediaries do not tear down idle connections. Decoders consume
+// keepalive records transparently.
+func WithKeepalive(interval time.Duration) Option {
+	return func(o *options) {
+		o.keepaliveInterval = interval
+	}
+}
+
+// WithLazyValues causes a Decoder to skip over, rather than read, any value
+// longer than threshold bytes, returning a ValueHandle to it from
+// DecodeHandle, so that a scan of the keys of a large archive does not read
+// its values. The underlying reader of the Decoder must implement
+// [io.Seeker] and [io.ReaderAt], as do [os.File] and [io.SectionReader].
+// Values that the Decoder must verify or transform, such as those covered by
+// a checksum that it verifies or by a signature, or compressed, encrypted or
+// deduplicated ones, are read regardless. Decode and DecodeX read skipped
+// values once the record is otherwise decoded.
+func WithLazyValues(threshold int64) Option {
+	return func(o *options) {
+		o.lazyThreshold = threshold
+	}
+}
+
+// WithLivenessMonitor causes a Decoder to call the monitor with the
+// transmission time of every keepalive control record it consumes.
+func WithLivenessMonitor(monitor func(sent time.Time)) Option {
+	return func(o *options) {
+		o.livenessMonitor = monitor
+	}
+}
+
+// WithMemoryBudget causes a Decoder to take the memory for each record that it
+// reads, as declared by the header of the record, from the MemoryBudget, which
+// may be shared with other Decoders, and to hold it until the next call to the
+// Decoder, once the caller is presumed done with the record, or Close. A
+// record that does not fit waits or fails according to the BudgetPolicy.
+func WithMemoryBudget(budget *MemoryBudget, policy BudgetPolicy) Option {
+	return func(o *options) {
+		o.memoryBudget = budget
+		o.budgetPolicy = policy
+	}
+}
+
+// WithMetaFilter causes a Decoder to return only records carrying one of the
+// allowed extended metadata values, and to skip others. Skipped records are
+// still verified, but their values are not decrypted, decompressed or fetched
+// from a BlobStore, except as deduplication requires.
+func WithMetaFilter(allowed ...XMetaValue) Option {
+	return func(o *options) {
+		var (
+			xmv XMetaValue
+		)
+
+		o.metaFilter = 0
+		o.metaFiltered = true
+
+		for _, xmv = range allowed {
+			o.metaFilter |= 1 << (xmv & XMetaValueF)
+		}
+	}
+}
+
+// WithMirror causes an Encoder to write the stream to each [io.Writer] as well
+// as to the one passed to NewEncoder, such as to local disk and to an object
+// store at once. Unlike with [io.MultiWriter], a destination that fails is
+// abandoned while the Encoder carries on writing to the others, and fails only
+// once every destination has failed. The error of each destination is
+// reported by Encoder.MirrorErrors. Flush, Sync and write deadlines apply to
+// every destination that supports them.
+func WithMirror(writers ...io.Writer) Option {
+	return func(o *options) {
+		o.mirrors = append(o.mirrors, writers...)
+	}
+}
+
+// WithRedaction causes an Encoder or a Decoder to replace the value of every
+// record whose key satisfies match with the result of replace, such as
+// RedactToSHA256 or a function returned by RedactToPlaceholder, so that a
+// production stream can be sanitised before it is shared. Keys, metadata and
+// the structure of the stream are preserved. An Encoder redacts values before
+// encrypting them and a Decoder after decrypting them.
+func WithRedaction(match func(key []byte) bool,
+	replace func(val []byte) []byte,
+) Option {
+	return func(o *options) {
+		o.redactMatch = match
+		o.redactReplace = replace
+	}
+}
+
+// WithSchema causes an Encoder to declare, in a control record before the
+// first record, how the values of the stream are encoded. See Decoder.Schema.
+func WithSchema(schema Schema) Option {
+	return func(o *options) {
+		o.schema = &schema
+	}
+}
+
+// WithSeekMarkers causes an Encoder to transmit a seek marker, a control
+// record bearing a fixed pattern of bytes that can be found by scanning, before
+// every n-th record, so that Decoder.SeekToKey can resume decoding from the
+// middle of the stream. The option has no effect if n <= 0.
+func WithSeekMarkers(n int) Option {
+	return func(o *options) {
+		if n <= 0 {
+			return
+		}
+
+		o.seekMarkerEvery = n
+	}
+}
+
+// WithSnapshotID causes an Encoder to compute a snapshot ID, the root of a
+// Merkle tree over the digests of the records that it transmits, and to
+// transmit it when closed, so that snapshots can be catalogued and
+// deduplicated by content. The ID depends only on the keys, metadata and
+// values of the records and their order, not on how they are encoded. A
+// Decoder so configured recomputes the ID and refuses a stream whose ID does
+// not match its records. See Encoder.SnapshotID and Decoder.SnapshotID.
+func WithSnapshotID() Option {
+	return func(o *options) {
+		o.snapshotID = true
+	}
+}
+
+// WithSortedBySource causes an Encoder and a Decoder to assert, as would
+// WithAssertSorted, that keys are sorted within each source, such as each
+// LMDB database of a stream of several, rather than across the stream, so
+// that a loader can still use MDB_APPEND for each database when records of
+// different databases are interleaved. Records are attributed to the source
+// with which they are tagged; see Encoder.SetSource and Collect. A stream
+// declared sorted by WithSortedKeys remains sorted across the stream.
+func WithSortedBySource() Option {
+	return func(o *options) {
+		o.assertSorted = true
+		o.sortedBySource = true
+	}
+}
+
+// WithSortedKeys causes an Encoder to declare that it transmits records in
+// strictly ascending order of key under the default LMDB comparator, and to
+// refuse to encode a record that would falsify the declaration. A Decoder
+// can then binary-search the stream; see Decoder.SeekToKey. The Encoder
+// announces the declaration in a control record at the start of the stream.
+func WithSortedKeys() Option {
+	return func(o *options) {
+		o.features |= featureSortedKeys
+	}
+}
+
+// WithSourceMap causes a Decoder to restore the records of each source named
+// in mapping into the given target, so that a stream can be restored into an
+// environment whose databases are named or structured differently from those
+// of the original without editing the stream. Decoder.Source reports the name
+// of the target, and keys are prefixed as the target specifies, so that
+// several sources can be merged into one. Sources absent from mapping are
+// restored as they are. Options that check the order of keys, such as
+// WithSortedBySource, apply to the keys as transmitted, before prefixing.
+func WithSourceMap(mapping map[string]SourceTarget) Option {
+	return func(o *options) {
+		o.sourceMap = maps.Clone(mapping)
+	}
+}
+
+// WithStreamLabel causes the goroutines that an Encoder runs in the
+// background, such as those transmitting keepalive records or records queued
+// by EncodeAsync, and those that Collect runs to receive from each Source, to
+// carry the [runtime/pprof] labels "bottledlightning.stream", set to id, and
+// "bottledlightning.role", so that CPU profiles of services handling many
+// streams attribute time to each. Collect also sets "bottledlightning.source"
+// to the ID of the Source.
+func WithStreamLabel(id string) Option {
+	return func(o *options) {
+		o.streamLabel = id
+	}
+}
+
+// WithStrict enables strict mode, in which an Encoder refuses to encode, and a
+// Decoder refuses to return, a record that LMDB would refuse to store, namely
+// one with a zero-length key, failing with an EmptyKeyError. It is equivalent
+// to WithValidationProfile(ProfileLMDBStrict).
+func WithStrict() Option {
+	return WithValidationProfile(ProfileLMDBStrict)
+}
+
+// WithSyncEvery causes an Encoder to commit the underlying stream to stable
+// storage after every n records, provided that the stream implements Sync, as
+// does an [os.File]. Syncing after every record maximises durability at the
+// expense of throughput; larger values of n trade one for the other.
+func WithSyncEvery(n int) Option {
+	return func(o *options) {
+		o.syncEvery = n
+	}
+}
+
+// WithSyncInterval causes an Encoder to commit the underlying stream to stable
+// storage after any record transmitted at least the given interval since the
+// last sync, provided that the stream implements Sync, as does an [os.File].
+func WithSyncInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = interval
+	}
+}
+
+// WithSigningKey causes an Encoder to sign the stream with the Ed25519 private
+// key, so that its provenance can be proven. When the Encoder is closed, it
+// transmits in a control record a signature of the SHA-256 digest of every
+// byte that precedes the record.
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(o *options) {
+		o.signingKey = key
+	}
+}
+
+// WithTransactions causes a Decoder to call begin with the identifier of
+// every transaction that it enters, as marked by Encoder.BeginTransaction,
+// before returning its first record, and commit with the same identifier once
+// it has returned the last, as marked by Encoder.CommitTransaction, so that an
+// applier can apply each transaction of the source in one transaction of its
+// own. An error returned by either is returned by the call to Decode.
+func WithTransactions(begin, commit func(id []byte) error) Option {
+	return func(o *options) {
+		o.beginTransaction = begin
+		o.commitTransaction = commit
+	}
+}
+
+// WithTransform causes an Encoder to transform every value with the encode
+// function before transmitting it, and a Decoder to transform every value
+// received with the decode function, for purposes such as envelope encryption
+// or the migration of values to a new format. Either function may be nil. The
+// lengths and checksums of records are computed after transformation, and an
+// error returned by either function fails the record. Values are transformed
+// after any redaction by an Encoder, and before any redaction by a Decoder, and
+// are read into memory to be transformed by Encoder.EncodeFrom.
+func WithTransform(encode, decode func(key, val []byte) ([]byte, error)) Option {
+	return func(o *options) {
+		o.encodeTransform = encode
+		o.decodeTransform = decode
+	}
+}
+
+// WithUnknownComparators causes a Decoder to return the records of sources
+// tagged by Encoder.SetComparator with a custom comparator that is not
+// registered by WithComparator, rather than an UnknownComparatorError, for
+// loaders that knowingly restore them into databases sorted otherwise.
+// Decoder.Comparator reports the comparator, so that the loader can warn.
+func WithUnknownComparators() Option {
+	return func(o *options) {
+		o.anyComparator = true
+	}
+}
+
+// WithValidationProfile causes an Encoder to refuse to encode, and a Decoder to
+// refuse to return, a record that breaks a rule of the ValidationProfile,
+// failing with a ValidationError or an EmptyKeyError. Lengths are those of the
+// key and value given to the Encoder, and of those returned by the Decoder.
+func WithValidationProfile(p ValidationProfile) Option {
+	return func(o *options) {
+		o.profile = p
+
+		if p.requireSorted {
+			o.assertSorted = true
+		}
+	}
+}
+
+// WithValueDigests causes Compare to retain a SHA-256 digest of every value of
+// the first stream rather than the value itself, which saves memory when
+// values are large, at the cost of hashing every value of both streams. The
+// option has no effect on an Encoder or a Decoder.
+func WithValueDigests() Option {
+	return func(o *options) {
+		o.valueDigests = true
+	}
+}
+
+// WithVerifyingKey causes a Decoder to verify signatures in the stream against
+// the Ed25519 public key, as transmitted by an Encoder configured with
+// WithSigningKey. The Decoder returns records before the signature that covers
+// them is received, but reports an error if the signature is invalid, or if
+// the stream ends without one.
+func WithVerifyingKey(key ed25519.PublicKey) Option {
+	return func(o *options) {
+		o.verifyingKey = key
+	}
+}
@@ -0,0 +1,372 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// An Encoder instruments a [bl.Encoder]. The stream is covered by a span,
+// "bottledlightning.encode", which begins when the Encoder is instrumented and
+// ends when it is closed or aborted, and whose trace context is transmitted
+// to the Decoder by [bl.Encoder.SetTraceContext]. An Encoder is safe for
+// concurrent use by multiple goroutines.
+type Encoder struct {
+	encoder *bl.Encoder
+	stream  stream
+}
+
+// NewEncoder instruments the [bl.Encoder] with the configuration, which may be
+// nil. The span of the stream is a child of any span in ctx. NewEncoder
+// transmits its trace context before any record, so it must be called before
+// the Encoder is used.
+func NewEncoder(ctx context.Context, encoder *bl.Encoder, config *Config) (
+	n *Encoder, e error,
+) {
+	var (
+		carrier = make(propagation.MapCarrier)
+	)
+
+	n = &Encoder{
+		encoder: encoder,
+	}
+
+	n.stream.instruments, e = newInstruments(config)
+	if e != nil {
+		return nil, fmt.Errorf("could not instrument encoder: %w", e)
+	}
+
+	n.stream.start(ctx, bl.OpEncode, trace.SpanKindProducer, time.Now())
+
+	n.stream.propagator.Inject(n.stream.ctx, carrier)
+
+	if len(carrier) == 0 {
+		return
+	}
+
+	e = encoder.SetTraceContext(carrier)
+	if e != nil {
+		n.stream.end(e)
+
+		return nil, fmt.Errorf("could not instrument encoder: %w", e)
+	}
+
+	return
+}
+
+// Context returns a context holding the span of the stream, for the spans of
+// work that the stream entails.
+func (n *Encoder) Context() context.Context {
+	return n.stream.ctx
+}
+
+// Encode transmits a key-value record, as does [bl.Encoder.Encode].
+func (n *Encoder) Encode(key, val []byte) error {
+	return n.encode(key, val, bl.XMetaValue0)
+}
+
+// EncodeX transmits a key-value record with extended metadata, as does
+// [bl.Encoder.EncodeX].
+func (n *Encoder) EncodeX(key, val []byte, xmv bl.XMetaValue) error {
+	return n.encode(key, val, xmv)
+}
+
+func (n *Encoder) encode(key, val []byte, xmv bl.XMetaValue) (e error) {
+	n.stream.mutex.Lock()
+
+	defer n.stream.mutex.Unlock()
+
+	n.stream.begin()
+
+	e = n.encoder.EncodeX(key, val, xmv)
+
+	n.stream.count(key, val, e)
+
+	return
+}
+
+// Close closes the Encoder, as does [bl.Encoder.Close], and ends the span of
+// the stream.
+func (n *Encoder) Close() (e error) {
+	e = n.encoder.Close()
+
+	n.stream.mutex.Lock()
+
+	defer n.stream.mutex.Unlock()
+
+	n.stream.end(e)
+
+	return
+}
+
+// Abort aborts the stream, as does [bl.Encoder.Abort], and ends the span of
+// the stream with an error status.
+func (n *Encoder) Abort(cause error) (e error) {
+	var (
+		aborted bl.AbortedError
+	)
+
+	if cause != nil {
+		aborted.Reason = cause.Error()
+	}
+
+	e = n.encoder.Abort(cause)
+
+	n.stream.mutex.Lock()
+
+	defer n.stream.mutex.Unlock()
+
+	if e != nil {
+		n.stream.end(e)
+
+		return
+	}
+
+	n.stream.end(aborted)
+
+	return
+}
+
+// A Decoder instruments a [bl.Decoder]. The stream is covered by a span,
+// "bottledlightning.decode", which begins when the first record is requested
+// and ends when the Decoder is closed. The span is a child of the span of the
+// Encoder whose trace context the stream carries, if any, and otherwise of
+// any span in the context given to NewDecoder. A Decoder is safe for
+// concurrent use by multiple goroutines.
+type Decoder struct {
+	decoder *bl.Decoder
+	parent  context.Context
+	stream  stream
+}
+
+// NewDecoder instruments the [bl.Decoder] with the configuration, which may be
+// nil.
+func NewDecoder(ctx context.Context, decoder *bl.Decoder, config *Config) (
+	d *Decoder, e error,
+) {
+	d = &Decoder{
+		decoder: decoder,
+		parent:  ctx,
+	}
+
+	d.stream.instruments, e = newInstruments(config)
+	if e != nil {
+		return nil, fmt.Errorf("could not instrument decoder: %w", e)
+	}
+
+	return
+}
+
+// Context returns a context holding the span of the stream, for the spans of
+// work that the stream entails, or the context given to NewDecoder if no
+// record has been requested.
+func (d *Decoder) Context() context.Context {
+	d.stream.mutex.Lock()
+
+	defer d.stream.mutex.Unlock()
+
+	if d.stream.span == nil {
+		return d.parent
+	}
+
+	return d.stream.ctx
+}
+
+// Decode receives the next record, as does [bl.Decoder.Decode].
+func (d *Decoder) Decode() (key, val []byte, e error) {
+	key, val, _, e = d.decode()
+
+	return
+}
+
+// DecodeX receives the next record and its extended metadata, as does
+// [bl.Decoder.DecodeX].
+func (d *Decoder) DecodeX() (key, val []byte, xmv byte, e error) {
+	return d.decode()
+}
+
+func (d *Decoder) decode() (key, val []byte, xmv byte, e error) {
+	// Receives the next record, first starting the span of the stream once
+	// the trace context, which precedes the first record, has been received.
+
+	var (
+		now = time.Now()
+	)
+
+	d.stream.mutex.Lock()
+
+	defer d.stream.mutex.Unlock()
+
+	d.stream.begin()
+
+	key, val, xmv, e = d.decoder.DecodeX()
+
+	if d.stream.span == nil {
+		d.stream.start(
+			d.stream.propagator.Extract(d.parent,
+				propagation.MapCarrier(d.decoder.TraceContext()),
+			),
+			bl.OpDecode, trace.SpanKindConsumer, now,
+		)
+	}
+
+	d.stream.count(key, val, e)
+
+	return
+}
+
+// Close closes the Decoder, as does [bl.Decoder.Close], and ends the span of
+// the stream.
+func (d *Decoder) Close() (e error) {
+	e = d.decoder.Close()
+
+	d.stream.mutex.Lock()
+
+	defer d.stream.mutex.Unlock()
+
+	if d.stream.span == nil {
+		return
+	}
+
+	d.stream.end(e)
+
+	return
+}
+
+type stream struct {
+	instruments
+
+	mutex   sync.Mutex
+	attrs   metric.MeasurementOption
+	ctx     context.Context
+	span    trace.Span
+	records int64
+	batch   trace.Span
+	batched int
+	started time.Time // of the pending batch
+	ended   bool
+}
+
+func (s *stream) start(parent context.Context, op bl.Op, kind trace.SpanKind,
+	at time.Time,
+) {
+	// Starts the span of the stream at the given time. The caller must hold
+	// s.mutex, unless the stream is not yet shared.
+
+	s.attrs = opAttribute(op)
+
+	s.ctx, s.span = s.tracer.Start(parent, "bottledlightning."+op.String(),
+		trace.WithSpanKind(kind),
+		trace.WithTimestamp(at),
+	)
+
+	return
+}
+
+func (s *stream) begin() {
+	// Notes the start of a batch, if a record is about to be the first of
+	// one. The caller must hold s.mutex.
+
+	if s.batched == 0 {
+		s.started = time.Now()
+	}
+
+	return
+}
+
+func (s *stream) count(key, val []byte, e error) {
+	// Records a record encoded or decoded, or the error that prevented it.
+	// The end of the stream ends the pending batch. The caller must hold
+	// s.mutex.
+
+	switch {
+	case errors.Is(e, io.EOF):
+		s.endBatch()
+
+		return
+
+	case e != nil:
+		s.errors.Add(s.ctx, 1, s.attrs)
+		s.span.RecordError(e)
+
+		return
+	}
+
+	if s.batchLen > 0 && s.batch == nil {
+		_, s.batch = s.tracer.Start(s.ctx, "bottledlightning.batch",
+			trace.WithTimestamp(s.started),
+		)
+	}
+
+	s.records++
+
+	s.instruments.records.Add(s.ctx, 1, s.attrs)
+
+	s.size.Record(s.ctx,
+		int64(len(key)+len(val)),
+		s.attrs,
+	)
+
+	s.batched++
+
+	if s.batched == s.batchLen {
+		s.endBatch()
+	}
+
+	return
+}
+
+func (s *stream) endBatch() {
+	// Ends the span of the pending batch, if any. The caller must hold
+	// s.mutex.
+
+	if s.batch != nil {
+		s.batch.SetAttributes(
+			attribute.Int("bottledlightning.batch.records", s.batched),
+		)
+
+		s.batch.End()
+	}
+
+	s.batch = nil
+	s.batched = 0
+
+	return
+}
+
+func (s *stream) end(e error) {
+	// Ends the spans of the pending batch and of the stream, with an error
+	// status if e is not nil, unless they have ended already. The caller
+	// must hold s.mutex.
+
+	if s.ended {
+		return
+	}
+
+	s.ended = true
+
+	s.endBatch()
+
+	s.span.SetAttributes(
+		attribute.Int64("bottledlightning.records", s.records),
+	)
+
+	if e != nil {
+		s.span.RecordError(e)
+		s.span.SetStatus(codes.Error, e.Error())
+	}
+
+	s.span.End()
+
+	return
+}
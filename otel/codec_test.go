@@ -0,0 +1,169 @@
+package otel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCodec(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		config   *Config
+		ctx      context.Context
+		decoder  *Decoder
+		e        error
+		encoder  *Encoder
+		i        int
+		metrics  metricdata.ResourceMetrics
+		reader   = sdkmetric.NewManualReader()
+		recorder = tracetest.NewSpanRecorder()
+		span     sdktrace.ReadOnlySpan
+		spans    map[string][]sdktrace.ReadOnlySpan
+	)
+
+	config = &Config{
+		TracerProvider: sdktrace.NewTracerProvider(
+			sdktrace.WithSpanProcessor(recorder),
+		),
+		MeterProvider: sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(reader),
+		),
+		Propagator: propagation.TraceContext{},
+		BatchLen:   2,
+	}
+
+	ctx, _ = config.TracerProvider.Tracer("test").Start(
+		context.Background(), "producer",
+	)
+
+	encoder, e = NewEncoder(ctx,
+		bl.NewEncoder(&buffer, fnv.New32a(), bl.WithBatchChecksum(2)),
+		config,
+	)
+
+	assert.NoError(t, e)
+
+	for i = 0; i < 3; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte(fmt.Sprint(i)), []byte("val")),
+		)
+	}
+
+	assert.NoError(t, encoder.Close())
+
+	decoder, e = NewDecoder(context.Background(),
+		bl.NewDecoder(&buffer, fnv.New32a()),
+		config,
+	)
+
+	assert.NoError(t, e)
+
+	for e == nil {
+		_, _, e = decoder.Decode()
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.NoError(t, decoder.Close())
+
+	spans = make(map[string][]sdktrace.ReadOnlySpan)
+
+	for _, span = range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	if assert.Len(t, spans["bottledlightning.encode"], 1) &&
+		assert.Len(t, spans["bottledlightning.decode"], 1) {
+		assert.Equal(t,
+			spans["bottledlightning.encode"][0].SpanContext().SpanID(),
+			spans["bottledlightning.decode"][0].Parent().SpanID(),
+		)
+
+		assert.True(t,
+			spans["bottledlightning.decode"][0].Parent().IsRemote(),
+		)
+	}
+
+	assert.Len(t, spans["bottledlightning.batch"], 4)
+
+	assert.NoError(t,
+		reader.Collect(context.Background(), &metrics),
+	)
+
+	assert.Equal(t, int64(6), sum(metrics, "bottledlightning.records"))
+
+	return
+}
+
+func TestEncoderAbort(t *testing.T) {
+	var (
+		encoder  *Encoder
+		e        error
+		recorder = tracetest.NewSpanRecorder()
+		spans    []sdktrace.ReadOnlySpan
+	)
+
+	encoder, e = NewEncoder(context.Background(),
+		bl.NewEncoder(io.Discard, nil),
+		&Config{
+			TracerProvider: sdktrace.NewTracerProvider(
+				sdktrace.WithSpanProcessor(recorder),
+			),
+		},
+	)
+
+	assert.NoError(t, e)
+	assert.NoError(t, encoder.Abort(fmt.Errorf("disk full")))
+	assert.NoError(t, encoder.Close())
+
+	spans = recorder.Ended()
+
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Equal(t,
+			"stream aborted: disk full",
+			spans[0].Status().Description,
+		)
+	}
+
+	return
+}
+
+func sum(metrics metricdata.ResourceMetrics, name string) (total int64) {
+	// Returns the sum of the data points of the named counter.
+
+	var (
+		point  metricdata.DataPoint[int64]
+		points []metricdata.DataPoint[int64]
+		record metricdata.Metrics
+		scope  metricdata.ScopeMetrics
+	)
+
+	for _, scope = range metrics.ScopeMetrics {
+		for _, record = range scope.Metrics {
+			if record.Name != name {
+				continue
+			}
+
+			points = record.Data.(metricdata.Sum[int64]).DataPoints
+
+			for _, point = range points {
+				total += point.Value
+			}
+		}
+	}
+
+	return
+}
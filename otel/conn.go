@@ -0,0 +1,132 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A Conn instruments a [net.Conn] over which a stream is transmitted or
+// received, such as one returned by [bl.DialTLS]. The connection is covered by
+// a span, "bottledlightning.connection", which ends when the Conn is closed,
+// and the bytes transmitted and received are counted.
+type Conn struct {
+	net.Conn
+
+	instruments instruments
+	ctx         context.Context
+	span        trace.Span
+	mutex       sync.Mutex
+	read        int64
+	written     int64
+	closed      bool
+}
+
+var (
+	receive = metric.WithAttributes(
+		attribute.String("network.io.direction", "receive"),
+	)
+	transmit = metric.WithAttributes(
+		attribute.String("network.io.direction", "transmit"),
+	)
+)
+
+// NewConn instruments the connection with the configuration, which may be nil.
+// The span of the connection is a child of any span in ctx.
+func NewConn(ctx context.Context, conn net.Conn, config *Config) (
+	c *Conn, e error,
+) {
+	c = &Conn{
+		Conn: conn,
+	}
+
+	c.instruments, e = newInstruments(config)
+	if e != nil {
+		return nil, fmt.Errorf("could not instrument connection: %w", e)
+	}
+
+	c.ctx, c.span = c.instruments.tracer.Start(ctx,
+		"bottledlightning.connection",
+		trace.WithAttributes(
+			attribute.String("network.local.address",
+				conn.LocalAddr().String(),
+			),
+			attribute.String("network.peer.address",
+				conn.RemoteAddr().String(),
+			),
+		),
+	)
+
+	return
+}
+
+// Context returns a context holding the span of the connection, to be given
+// to NewEncoder or NewDecoder so that the span of the stream is its child.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// Read reads from the connection, counting the bytes received.
+func (c *Conn) Read(b []byte) (n int, e error) {
+	n, e = c.Conn.Read(b)
+
+	c.instruments.io.Add(c.ctx, int64(n), receive)
+
+	c.mutex.Lock()
+
+	defer c.mutex.Unlock()
+
+	c.read += int64(n)
+
+	return
+}
+
+// Write writes to the connection, counting the bytes transmitted.
+func (c *Conn) Write(b []byte) (n int, e error) {
+	n, e = c.Conn.Write(b)
+
+	c.instruments.io.Add(c.ctx, int64(n), transmit)
+
+	c.mutex.Lock()
+
+	defer c.mutex.Unlock()
+
+	c.written += int64(n)
+
+	return
+}
+
+// Close closes the connection and ends its span.
+func (c *Conn) Close() (e error) {
+	e = c.Conn.Close()
+
+	c.mutex.Lock()
+
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+
+	c.span.SetAttributes(
+		attribute.Int64("bottledlightning.connection.received", c.read),
+		attribute.Int64("bottledlightning.connection.transmitted", c.written),
+	)
+
+	if e != nil {
+		c.span.RecordError(e)
+		c.span.SetStatus(codes.Error, e.Error())
+	}
+
+	c.span.End()
+
+	return
+}
@@ -0,0 +1,80 @@
+package otel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestConn(t *testing.T) {
+	var (
+		a, b     net.Conn
+		conn     *Conn
+		config   *Config
+		e        error
+		metrics  metricdata.ResourceMetrics
+		reader   = sdkmetric.NewManualReader()
+		recorder = tracetest.NewSpanRecorder()
+		spans    []sdktrace.ReadOnlySpan
+	)
+
+	config = &Config{
+		TracerProvider: sdktrace.NewTracerProvider(
+			sdktrace.WithSpanProcessor(recorder),
+		),
+		MeterProvider: sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(reader),
+		),
+	}
+
+	a, b = net.Pipe()
+
+	conn, e = NewConn(context.Background(), a, config)
+
+	assert.NoError(t, e)
+
+	go func() {
+		b.Write([]byte("hello"))
+		io.Copy(io.Discard, b)
+	}()
+
+	_, e = io.ReadFull(conn, make([]byte, 5))
+
+	assert.NoError(t, e)
+
+	_, e = conn.Write([]byte("hi"))
+
+	assert.NoError(t, e)
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, conn.Close())
+
+	spans = recorder.Ended()
+
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "bottledlightning.connection", spans[0].Name())
+
+		assert.Contains(t, spans[0].Attributes(),
+			attribute.Int64("bottledlightning.connection.received", 5),
+		)
+
+		assert.Contains(t, spans[0].Attributes(),
+			attribute.Int64("bottledlightning.connection.transmitted", 2),
+		)
+	}
+
+	assert.NoError(t,
+		reader.Collect(context.Background(), &metrics),
+	)
+
+	assert.Equal(t, int64(7), sum(metrics, "bottledlightning.connection.io"))
+
+	return
+}
@@ -0,0 +1,127 @@
+// Package otel instruments Encoders, Decoders and the connections over which
+// they stream with OpenTelemetry spans and metrics, and propagates trace
+// context from Encoder to Decoder through the stream itself, so that the flow
+// of records between services appears in traces. It is a module of its own,
+// so that programs that do not use OpenTelemetry do not depend on it.
+//
+// The following metrics are recorded, with the attribute
+// "bottledlightning.op" of "encode" or "decode", or "network.io.direction" of
+// "transmit" or "receive":
+//
+//   - bottledlightning.records, the number of records encoded or decoded,
+//   - bottledlightning.record.size, the sizes of their keys and values,
+//   - bottledlightning.errors, the number of records that could not be
+//     encoded or decoded, and
+//   - bottledlightning.connection.io, the bytes transmitted and received by
+//     instrumented connections.
+package otel
+
+import (
+	"fmt"
+
+	bl "github.com/encodingx/bottled-lightning"
+	global "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	scope = "github.com/encodingx/bottled-lightning/otel"
+)
+
+// A Config configures instrumentation. Zero fields denote the global
+// providers and propagator of [go.opentelemetry.io/otel].
+type Config struct {
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+	Propagator     propagation.TextMapPropagator
+
+	// BatchLen, if positive, is the number of records covered by each batch
+	// span, a child of the span of the stream. It should match that given to
+	// WithBatchChecksum, if any. If zero, no batch spans are recorded.
+	BatchLen int
+}
+
+type instruments struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	batchLen   int
+	records    metric.Int64Counter
+	size       metric.Int64Histogram
+	errors     metric.Int64Counter
+	io         metric.Int64Counter
+}
+
+func newInstruments(config *Config) (i instruments, e error) {
+	// Returns the tracer and instruments of the configuration, which may be
+	// nil, completed with defaults.
+
+	var (
+		c     Config
+		meter metric.Meter
+	)
+
+	if config != nil {
+		c = *config
+	}
+
+	if c.TracerProvider == nil {
+		c.TracerProvider = global.GetTracerProvider()
+	}
+
+	if c.MeterProvider == nil {
+		c.MeterProvider = global.GetMeterProvider()
+	}
+
+	if c.Propagator == nil {
+		c.Propagator = global.GetTextMapPropagator()
+	}
+
+	i.tracer = c.TracerProvider.Tracer(scope)
+	i.propagator = c.Propagator
+	i.batchLen = c.BatchLen
+
+	meter = c.MeterProvider.Meter(scope)
+
+	i.records, e = meter.Int64Counter("bottledlightning.records",
+		metric.WithDescription("Records encoded or decoded"),
+		metric.WithUnit("{record}"),
+	)
+	if e != nil {
+		return i, fmt.Errorf("could not create instrument: %w", e)
+	}
+
+	i.size, e = meter.Int64Histogram("bottledlightning.record.size",
+		metric.WithDescription("Sizes of the keys and values of records"),
+		metric.WithUnit("By"),
+	)
+	if e != nil {
+		return i, fmt.Errorf("could not create instrument: %w", e)
+	}
+
+	i.errors, e = meter.Int64Counter("bottledlightning.errors",
+		metric.WithDescription("Records that could not be encoded or decoded"),
+		metric.WithUnit("{error}"),
+	)
+	if e != nil {
+		return i, fmt.Errorf("could not create instrument: %w", e)
+	}
+
+	i.io, e = meter.Int64Counter("bottledlightning.connection.io",
+		metric.WithDescription("Bytes transmitted and received by connections"),
+		metric.WithUnit("By"),
+	)
+	if e != nil {
+		return i, fmt.Errorf("could not create instrument: %w", e)
+	}
+
+	return
+}
+
+func opAttribute(op bl.Op) metric.MeasurementOption {
+	return metric.WithAttributes(
+		attribute.String("bottledlightning.op", op.String()),
+	)
+}
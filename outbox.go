@@ -0,0 +1,522 @@
+package bottledlightning
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	outboxChunkLen       = 1 << 16
+	outboxPositionName   = "position"
+	outboxSegmentExt     = ".outbox"
+	outboxSegmentNameLen = 16
+)
+
+// An Outbox is a durable local queue of the bytes of a stream, for a producer
+// that must not lose records while its remote sink is unavailable. An Encoder
+// writes into the Outbox as into any [io.Writer], and Ship drains it to the
+// sink in the background, as the sink becomes available.
+//
+// The Outbox keeps the bytes in files of a directory, called segments, each
+// of which is sealed and synced once it exceeds the segment length, and
+// removed once shipped in full. Bytes not yet in a sealed segment are durable
+// once synced, as by the sync policy of the Encoder, such as WithSyncEvery.
+// The position up to which the bytes have been shipped is recorded in the
+// directory, so that shipping resumes there when the Outbox is reopened;
+// bytes shipped but not yet recorded when the process stops are shipped
+// again.
+type Outbox struct {
+	dir        string
+	segmentLen int64
+	mutex      sync.Mutex
+	segment    *File
+	seq        uint64 // of segment
+	written    int64  // to segment
+	notify     chan struct{}
+	closed     bool
+	shipping   sync.Mutex
+	shipped    outboxPosition
+	reading    *os.File
+	readingSeq uint64
+}
+
+type outboxPosition struct {
+	seq    uint64
+	offset int64
+}
+
+// OpenOutbox opens the Outbox in the named directory, creating the directory
+// if necessary, with the given segment length in bytes. Bytes left unshipped
+// in the directory by an earlier Outbox are shipped before any written anew.
+func OpenOutbox(dir string, segmentLen int64) (o *Outbox, e error) {
+	defer errorf("could not open outbox", &e)
+
+	var (
+		seqs []uint64
+	)
+
+	e = os.MkdirAll(dir, 0o755)
+	if e != nil {
+		return
+	}
+
+	o = &Outbox{
+		dir:        dir,
+		segmentLen: segmentLen,
+		notify:     make(chan struct{}),
+	}
+
+	seqs, e = o.segments()
+	if e != nil {
+		return nil, e
+	}
+
+	e = o.readPosition()
+	if e != nil {
+		return nil, e
+	}
+
+	// A new segment follows any partly shipped one, even if since lost.
+
+	o.seq = o.shipped.seq
+
+	if o.shipped.offset > 0 {
+		o.seq++
+	}
+
+	if len(seqs) > 0 {
+		o.seq = max(o.seq, seqs[len(seqs)-1]+1)
+	}
+
+	if len(seqs) > 0 && seqs[0] > o.shipped.seq {
+		o.shipped = outboxPosition{seq: seqs[0]}
+	}
+
+	o.segment, e = CreateFile(
+		o.segmentName(o.seq),
+	)
+	if e != nil {
+		return nil, e
+	}
+
+	return
+}
+
+// Write implements [io.Writer], appending to the current segment, and sealing
+// it if it is then full.
+func (o *Outbox) Write(b []byte) (n int, e error) {
+	defer errorf("could not write to outbox", &e)
+
+	o.mutex.Lock()
+
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return 0, fmt.Errorf("outbox closed")
+	}
+
+	n, e = o.segment.Write(b)
+
+	o.written += int64(n)
+
+	close(o.notify)
+
+	o.notify = make(chan struct{})
+
+	if e != nil {
+		return
+	}
+
+	if o.written < o.segmentLen {
+		return
+	}
+
+	e = o.seal()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Sync commits the bytes written to the current segment to stable storage.
+func (o *Outbox) Sync() (e error) {
+	defer errorf("could not sync outbox", &e)
+
+	o.mutex.Lock()
+
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return fmt.Errorf("outbox closed")
+	}
+
+	e = o.segment.Sync()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Close syncs and closes the current segment, leaving any unshipped bytes in
+// the directory. A call to Ship under way returns once it has shipped the
+// bytes written before Close.
+func (o *Outbox) Close() (e error) {
+	defer errorf("could not close outbox", &e)
+
+	o.mutex.Lock()
+
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return
+	}
+
+	o.closed = true
+
+	close(o.notify)
+
+	e = o.segment.Sync()
+	if e != nil {
+		o.segment.Close()
+
+		return
+	}
+
+	e = o.segment.Close()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Ship writes the bytes of the Outbox, in the order in which they were
+// written, to the sink, and waits for more, until the context is done, the
+// Outbox is closed and drained or the sink fails, and returns the error. Bytes
+// count as acknowledged once written to the sink and, if it implements them,
+// flushed and synced, whereupon their position is recorded and fully shipped
+// segments are removed. Following an error, Ship may be called again,
+// typically with a new sink, to resume where it stopped. Only one call to Ship
+// runs at a time.
+func (o *Outbox) Ship(ctx context.Context, sink io.Writer) (e error) {
+	defer errorf("could not ship outbox", &e)
+
+	var (
+		buffer = make([]byte, outboxChunkLen)
+		closed bool
+		n      int
+		notify chan struct{}
+		seq    uint64
+		size   int64
+	)
+
+	o.shipping.Lock()
+
+	defer o.shipping.Unlock()
+
+	defer o.closeReading()
+
+	for {
+		o.mutex.Lock()
+
+		closed, notify, seq, size = o.closed, o.notify, o.seq, o.written
+
+		o.mutex.Unlock()
+
+		if o.shipped.seq < seq {
+			size, e = o.segmentSize(o.shipped.seq)
+			if errors.Is(e, fs.ErrNotExist) {
+				o.shipped = outboxPosition{seq: o.shipped.seq + 1}
+
+				continue
+			}
+
+			if e != nil {
+				return
+			}
+		}
+
+		if o.shipped.offset < size {
+			n, e = o.read(buffer[:min(int64(len(buffer)),
+				size-o.shipped.offset,
+			)])
+			if e != nil {
+				return
+			}
+
+			e = o.send(sink, buffer[:n])
+			if e != nil {
+				return
+			}
+
+			o.shipped.offset += int64(n)
+
+			e = o.writePosition()
+			if e != nil {
+				return
+			}
+
+			continue
+		}
+
+		if o.shipped.seq < seq {
+			e = o.remove(o.shipped.seq)
+			if e != nil {
+				return
+			}
+
+			continue
+		}
+
+		if closed {
+			return fmt.Errorf("outbox closed")
+		}
+
+		select {
+		case <-notify:
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (o *Outbox) seal() (e error) {
+	// Syncs and closes the current segment, and starts the next. The caller
+	// must hold o.mutex.
+
+	e = o.segment.Sync()
+	if e != nil {
+		return
+	}
+
+	e = o.segment.Close()
+	if e != nil {
+		return
+	}
+
+	o.segment, e = CreateFile(
+		o.segmentName(o.seq + 1),
+	)
+	if e != nil {
+		o.closed = true
+
+		return
+	}
+
+	o.seq++
+
+	o.written = 0
+
+	return
+}
+
+func (o *Outbox) read(b []byte) (n int, e error) {
+	// Reads the bytes at the shipped position. The caller must hold
+	// o.shipping.
+
+	if o.reading == nil || o.readingSeq != o.shipped.seq {
+		o.closeReading()
+
+		o.reading, e = os.Open(
+			o.segmentName(o.shipped.seq),
+		)
+		if e != nil {
+			return
+		}
+
+		o.readingSeq = o.shipped.seq
+	}
+
+	n, e = o.reading.ReadAt(b, o.shipped.offset)
+	if errors.Is(e, io.EOF) && n == len(b) {
+		e = nil
+	}
+
+	return
+}
+
+func (o *Outbox) send(sink io.Writer, b []byte) (e error) {
+	// Writes the bytes to the sink, and flushes and syncs it if it supports
+	// either.
+
+	var (
+		f  flusher
+		ok bool
+		s  syncer
+	)
+
+	_, e = sink.Write(b)
+	if e != nil {
+		return
+	}
+
+	f, ok = sink.(flusher)
+	if ok {
+		e = f.Flush()
+		if e != nil {
+			return
+		}
+	}
+
+	s, ok = sink.(syncer)
+	if ok {
+		e = s.Sync()
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (o *Outbox) remove(seq uint64) (e error) {
+	// Removes the fully shipped, sealed segment, and advances the shipped
+	// position to the next. The caller must hold o.shipping.
+
+	o.closeReading()
+
+	o.shipped = outboxPosition{seq: seq + 1}
+
+	e = o.writePosition()
+	if e != nil {
+		return
+	}
+
+	e = os.Remove(
+		o.segmentName(seq),
+	)
+	if e != nil && !errors.Is(e, fs.ErrNotExist) {
+		return
+	}
+
+	return nil
+}
+
+func (o *Outbox) closeReading() {
+	if o.reading != nil {
+		o.reading.Close()
+
+		o.reading = nil
+	}
+
+	return
+}
+
+func (o *Outbox) segments() (seqs []uint64, e error) {
+	// Returns the sequence numbers of the segments in the directory, in
+	// ascending order.
+
+	var (
+		entries []os.DirEntry
+		entry   os.DirEntry
+		name    string
+		ok      bool
+		seq     uint64
+	)
+
+	entries, e = os.ReadDir(o.dir)
+	if e != nil {
+		return
+	}
+
+	for _, entry = range entries {
+		name, ok = strings.CutSuffix(entry.Name(), outboxSegmentExt)
+		if !ok || len(name) != outboxSegmentNameLen {
+			continue
+		}
+
+		seq, e = strconv.ParseUint(name, 16, 64)
+		if e != nil {
+			return nil, e
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	return
+}
+
+func (o *Outbox) segmentName(seq uint64) string {
+	return filepath.Join(o.dir,
+		fmt.Sprintf("%0*x%s", outboxSegmentNameLen, seq, outboxSegmentExt),
+	)
+}
+
+func (o *Outbox) segmentSize(seq uint64) (size int64, e error) {
+	var (
+		info fs.FileInfo
+	)
+
+	info, e = os.Stat(
+		o.segmentName(seq),
+	)
+	if e != nil {
+		return
+	}
+
+	return info.Size(), nil
+}
+
+func (o *Outbox) readPosition() (e error) {
+	var (
+		b []byte
+	)
+
+	b, e = os.ReadFile(
+		filepath.Join(o.dir, outboxPositionName),
+	)
+	if errors.Is(e, fs.ErrNotExist) {
+		return nil
+	}
+
+	if e != nil {
+		return
+	}
+
+	if len(b) != 16 {
+		return fmt.Errorf("malformed position")
+	}
+
+	o.shipped = outboxPosition{
+		seq:    binary.BigEndian.Uint64(b),
+		offset: int64(binary.BigEndian.Uint64(b[8:])),
+	}
+
+	return
+}
+
+func (o *Outbox) writePosition() (e error) {
+	// Replaces the recorded shipped position atomically.
+
+	var (
+		b    = make([]byte, 16)
+		name = filepath.Join(o.dir, outboxPositionName)
+	)
+
+	binary.BigEndian.PutUint64(b, o.shipped.seq)
+	binary.BigEndian.PutUint64(b[8:], uint64(o.shipped.offset))
+
+	e = os.WriteFile(name+".tmp", b, 0o644)
+	if e != nil {
+		return
+	}
+
+	e = os.Rename(name+".tmp", name)
+	if e != nil {
+		return
+	}
+
+	return
+}
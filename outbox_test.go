@@ -0,0 +1,133 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type downWriter struct {
+	// Fails every write after so many.
+
+	writer io.Writer
+	writes int
+}
+
+func (w *downWriter) Write(b []byte) (n int, e error) {
+	if w.writes == 0 {
+		return 0, errors.New("sink down")
+	}
+
+	w.writes--
+
+	return w.writer.Write(b)
+}
+
+func TestOutbox(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		dir     = t.TempDir()
+		e       error
+		encoder *Encoder
+		entries []os.DirEntry
+		i       int
+		key     []byte
+		outbox  *Outbox
+		shipped = make(chan error, 1)
+	)
+
+	outbox, e = OpenOutbox(dir, 64)
+	assert.NoError(t, e)
+
+	encoder = NewEncoder(outbox, nil)
+
+	for i = 0; i < 20; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte(fmt.Sprintf("key%02d", i)), []byte("val")),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// The sink fails part way, and the rest is shipped from a reopened
+	// outbox.
+
+	assert.Error(t,
+		outbox.Ship(context.Background(),
+			&downWriter{writer: &buffer, writes: 1},
+		),
+	)
+	assert.NotZero(t,
+		buffer.Len(),
+	)
+	assert.NoError(t,
+		outbox.Close(),
+	)
+
+	outbox, e = OpenOutbox(dir, 64)
+	assert.NoError(t, e)
+
+	go func() {
+		shipped <- outbox.Ship(context.Background(), &buffer)
+	}()
+
+	assert.NoError(t,
+		outbox.Close(),
+	)
+	assert.Error(t, <-shipped)
+
+	decoder = NewDecoder(&buffer, nil)
+
+	for i = 0; i < 20; i++ {
+		key, _, e = decoder.Decode()
+		assert.NoError(t, e)
+		assert.Equal(t, fmt.Sprintf("key%02d", i), string(key))
+	}
+
+	_, _, e = decoder.Decode()
+	assert.ErrorIs(t, e, io.EOF)
+
+	// Only the empty current segment and the position remain.
+
+	entries, e = os.ReadDir(dir)
+	assert.NoError(t, e)
+	assert.Len(t, entries, 2)
+
+	return
+}
+
+func TestOutboxShipCancel(t *testing.T) {
+	var (
+		cancel context.CancelFunc
+		ctx    context.Context
+		e      error
+		outbox *Outbox
+	)
+
+	outbox, e = OpenOutbox(t.TempDir(), 64)
+	assert.NoError(t, e)
+
+	defer outbox.Close()
+
+	ctx, cancel = context.WithCancel(
+		context.Background(),
+	)
+
+	cancel()
+
+	assert.ErrorIs(t,
+		outbox.Ship(ctx, io.Discard),
+		context.Canceled,
+	)
+
+	return
+}
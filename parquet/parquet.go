@@ -0,0 +1,503 @@
+// Package parquet writes streams of records as Parquet files, for analytics
+// over the contents of backups.
+package parquet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/internal/wrap"
+)
+
+const (
+	parquetMagic       = "PAR1"
+	parquetRowGroupLen = 1 << 16
+	parquetRowGroupMax = 1 << 26 // bytes buffered
+
+	// Physical types, converted types, encodings and page types of the
+	// Parquet format.
+
+	parquetInt32           = 1
+	parquetInt64           = 2
+	parquetByteArray       = 6
+	parquetTimestampMillis = 9
+	parquetPlain           = 0
+	parquetRLE             = 3
+	parquetDataPage        = 0
+)
+
+// Options configure a Writer.
+type Options struct {
+	// ValueDigests, if true, replaces the value column by a value_sha256
+	// column holding the SHA-256 digest of every value, keeping the file
+	// small when only the presence or identity of values matters.
+	ValueDigests bool
+
+	// Timestamp, if not zero, adds a timestamp column holding it in every
+	// row, such as the time of the backup, so that files of several
+	// backups can be queried together.
+	Timestamp time.Time
+
+	// RowGroupLen is the number of rows of a row group, 65536 if zero. A
+	// row group also ends once 64 MiB of values are buffered.
+	RowGroupLen int
+}
+
+// A Writer writes records to an [io.Writer] as a Parquet file, for
+// analytics over the contents of backups with tools such as DuckDB or Spark.
+// Every record is a row of the columns key, value (or value_sha256),
+// key_size, value_size, meta, holding its XMetaValue, and, if configured,
+// timestamp. Rows are buffered in memory one row group at a time, so that
+// large inputs are written as they come.
+type Writer struct {
+	writer  io.Writer
+	options Options
+	offset  int64
+	started bool
+	rows    int // of the current row group
+	total   int64
+	columns []*parquetColumn
+	groups  []parquetRowGroup
+}
+
+type parquetColumn struct {
+	name      string
+	kind      int32
+	converted int32 // or -1
+	data      []byte
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// NewWriter returns a Writer writing to the [io.Writer].
+func NewWriter(writer io.Writer, options Options) (
+	w *Writer,
+) {
+	w = &Writer{
+		writer:  writer,
+		options: options,
+		columns: []*parquetColumn{
+			{name: "key", kind: parquetByteArray, converted: -1},
+			{name: "value", kind: parquetByteArray, converted: -1},
+			{name: "key_size", kind: parquetInt32, converted: -1},
+			{name: "value_size", kind: parquetInt64, converted: -1},
+			{name: "meta", kind: parquetInt32, converted: -1},
+		},
+	}
+
+	if options.ValueDigests {
+		w.columns[1].name = "value_sha256"
+	}
+
+	if !options.Timestamp.IsZero() {
+		w.columns = append(w.columns,
+			&parquetColumn{
+				name:      "timestamp",
+				kind:      parquetInt64,
+				converted: parquetTimestampMillis,
+			},
+		)
+	}
+
+	if w.options.RowGroupLen <= 0 {
+		w.options.RowGroupLen = parquetRowGroupLen
+	}
+
+	return
+}
+
+// Write adds a row for the record.
+func (w *Writer) Write(key, val []byte, xmv bl.XMetaValue) (e error) {
+	defer wrap.Errorf("could not write parquet row", &e)
+
+	var (
+		buffered int
+		column   *parquetColumn
+		digest   [sha256.Size]byte
+		value    = val
+	)
+
+	if w.options.ValueDigests {
+		digest = sha256.Sum256(val)
+
+		value = digest[:]
+	}
+
+	w.columns[0].appendBytes(key)
+	w.columns[1].appendBytes(value)
+	w.columns[2].appendInt32(int32(len(key)))
+	w.columns[3].appendInt64(int64(len(val)))
+	w.columns[4].appendInt32(int32(xmv))
+
+	if !w.options.Timestamp.IsZero() {
+		w.columns[5].appendInt64(w.options.Timestamp.UnixMilli())
+	}
+
+	w.rows++
+
+	for _, column = range w.columns {
+		buffered += len(column.data)
+	}
+
+	if w.rows < w.options.RowGroupLen && buffered < parquetRowGroupMax {
+		return
+	}
+
+	e = w.flush()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Close writes any buffered rows and the footer of the file. It does not
+// close the underlying [io.Writer].
+func (w *Writer) Close() (e error) {
+	defer wrap.Errorf("could not close parquet file", &e)
+
+	var (
+		footer []byte
+	)
+
+	e = w.start()
+	if e != nil {
+		return
+	}
+
+	if w.rows > 0 {
+		e = w.flush()
+		if e != nil {
+			return
+		}
+	}
+
+	footer = w.fileMetaData()
+
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+
+	e = w.write(footer)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (w *Writer) start() (e error) {
+	// Writes the leading magic number, unless already written.
+
+	if w.started {
+		return
+	}
+
+	w.started = true
+
+	return w.write(
+		[]byte(parquetMagic),
+	)
+}
+
+func (w *Writer) flush() (e error) {
+	// Writes the buffered rows as a row group of one page per column.
+
+	var (
+		column *parquetColumn
+		group  = parquetRowGroup{rows: int64(w.rows)}
+		header []byte
+		offset int64
+	)
+
+	e = w.start()
+	if e != nil {
+		return
+	}
+
+	for _, column = range w.columns {
+		if len(column.data) > 1<<31-1 {
+			return fmt.Errorf("column %s too large", column.name)
+		}
+
+		header = parquetPageHeader(w.rows, len(column.data))
+
+		offset = w.offset
+
+		e = w.write(header)
+		if e != nil {
+			return
+		}
+
+		e = w.write(column.data)
+		if e != nil {
+			return
+		}
+
+		group.chunks = append(group.chunks,
+			parquetChunk{
+				offset: offset,
+				size:   w.offset - offset,
+				values: int64(w.rows),
+			},
+		)
+
+		group.size += w.offset - offset
+
+		column.data = column.data[:0]
+	}
+
+	w.groups = append(w.groups, group)
+
+	w.total += int64(w.rows)
+
+	w.rows = 0
+
+	return
+}
+
+func (w *Writer) write(b []byte) (e error) {
+	var (
+		n int
+	)
+
+	n, e = w.writer.Write(b)
+
+	w.offset += int64(n)
+
+	return
+}
+
+func (w *Writer) fileMetaData() []byte {
+	// Returns the FileMetaData structure of the file.
+
+	var (
+		chunk  parquetChunk
+		column *parquetColumn
+		group  parquetRowGroup
+		i      int
+		t      = newThriftWriter()
+	)
+
+	t.i32(1, 1)
+
+	t.list(2, thriftStruct, len(w.columns)+1)
+	t.beginElement()
+	t.binary(4, []byte("schema"))
+	t.i32(5, int32(len(w.columns)))
+	t.end()
+
+	for _, column = range w.columns {
+		t.beginElement()
+		t.i32(1, column.kind)
+		t.i32(3, 0) // required
+		t.binary(4, []byte(column.name))
+
+		if column.converted >= 0 {
+			t.i32(6, column.converted)
+		}
+
+		t.end()
+	}
+
+	t.i64(3, w.total)
+
+	t.list(4, thriftStruct, len(w.groups))
+
+	for _, group = range w.groups {
+		t.beginElement()
+		t.list(1, thriftStruct, len(group.chunks))
+
+		for i, chunk = range group.chunks {
+			t.beginElement()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, w.columns[i].kind)
+			t.list(2, thriftI32, 1)
+			t.element32(parquetPlain)
+			t.list(3, thriftBinary, 1)
+			t.elementBinary([]byte(w.columns[i].name))
+			t.i32(4, 0) // uncompressed
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+
+		t.i64(2, group.size)
+		t.i64(3, group.rows)
+		t.end()
+	}
+
+	t.binary(6, []byte("bottled-lightning"))
+
+	return t.end()
+}
+
+func parquetPageHeader(values, size int) []byte {
+	// Returns the PageHeader structure of a data page of plainly encoded,
+	// required values, which therefore carries no levels.
+
+	var (
+		t = newThriftWriter()
+	)
+
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(size))
+	t.i32(3, int32(size))
+	t.beginStruct(5)
+	t.i32(1, int32(values))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.end()
+
+	return t.end()
+}
+
+func (c *parquetColumn) appendBytes(b []byte) {
+	c.data = binary.LittleEndian.AppendUint32(c.data, uint32(len(b)))
+	c.data = append(c.data, b...)
+
+	return
+}
+
+func (c *parquetColumn) appendInt32(n int32) {
+	c.data = binary.LittleEndian.AppendUint32(c.data, uint32(n))
+
+	return
+}
+
+func (c *parquetColumn) appendInt64(n int64) {
+	c.data = binary.LittleEndian.AppendUint64(c.data, uint64(n))
+
+	return
+}
+
+// Types of the Thrift compact protocol, in which Parquet metadata is encoded.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// A thriftWriter encodes a structure in the Thrift compact protocol.
+type thriftWriter struct {
+	b    []byte
+	last []int16 // identifier of the last field of each open structure
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{
+		last: []int16{0},
+	}
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	var (
+		delta = id - t.last[len(t.last)-1]
+	)
+
+	if delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|kind)
+	} else {
+		t.b = append(t.b, kind)
+		t.b = binary.AppendVarint(t.b, int64(id))
+	}
+
+	t.last[len(t.last)-1] = id
+
+	return
+}
+
+func (t *thriftWriter) i32(id int16, n int32) {
+	t.field(id, thriftI32)
+	t.element32(n)
+
+	return
+}
+
+func (t *thriftWriter) i64(id int16, n int64) {
+	t.field(id, thriftI64)
+
+	t.b = binary.AppendVarint(t.b, n)
+
+	return
+}
+
+func (t *thriftWriter) binary(id int16, b []byte) {
+	t.field(id, thriftBinary)
+	t.elementBinary(b)
+
+	return
+}
+
+func (t *thriftWriter) list(id int16, kind byte, n int) {
+	// Begins a list of n elements of the kind, which follow as elements.
+
+	t.field(id, thriftList)
+
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|kind)
+	} else {
+		t.b = append(t.b, 0xf0|kind)
+		t.b = binary.AppendUvarint(t.b, uint64(n))
+	}
+
+	return
+}
+
+func (t *thriftWriter) element32(n int32) {
+	t.b = binary.AppendVarint(t.b, int64(n))
+
+	return
+}
+
+func (t *thriftWriter) elementBinary(b []byte) {
+	t.b = binary.AppendUvarint(t.b, uint64(len(b)))
+	t.b = append(t.b, b...)
+
+	return
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+
+	return
+}
+
+func (t *thriftWriter) beginElement() {
+	// Begins a structure that is an element of a list.
+
+	t.last = append(t.last, 0)
+
+	return
+}
+
+func (t *thriftWriter) end() []byte {
+	// Ends the innermost open structure, and returns the encoding so far.
+
+	t.b = append(t.b, 0)
+
+	t.last = t.last[:len(t.last)-1]
+
+	return t.b
+}
@@ -0,0 +1,216 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+type thriftReader struct {
+	// Decodes the Thrift compact protocol generically, into maps of fields
+	// by identifier, for inspecting Parquet metadata in tests.
+
+	b []byte
+}
+
+func (r *thriftReader) varint() int64 {
+	var (
+		n int
+		v int64
+	)
+
+	v, n = binary.Varint(r.b)
+
+	r.b = r.b[n:]
+
+	return v
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	var (
+		n int
+		v uint64
+	)
+
+	v, n = binary.Uvarint(r.b)
+
+	r.b = r.b[n:]
+
+	return v
+}
+
+func (r *thriftReader) value(kind byte) any {
+	var (
+		elem byte
+		list []any
+		n    uint64
+		v    []byte
+	)
+
+	switch kind {
+	case thriftI32, thriftI64:
+		return r.varint()
+
+	case thriftBinary:
+		n = r.uvarint()
+
+		v, r.b = r.b[:n], r.b[n:]
+
+		return string(v)
+
+	case thriftList:
+		elem, n = r.b[0]&0xf, uint64(r.b[0]>>4)
+
+		r.b = r.b[1:]
+
+		if n == 15 {
+			n = r.uvarint()
+		}
+
+		for ; n > 0; n-- {
+			list = append(list, r.value(elem))
+		}
+
+		return list
+
+	case thriftStruct:
+		return r.structure()
+	}
+
+	panic(fmt.Sprint("unexpected thrift type ", kind))
+}
+
+func (r *thriftReader) structure() map[int64]any {
+	var (
+		fields = make(map[int64]any)
+		id     int64
+		kind   byte
+	)
+
+	for {
+		kind = r.b[0] & 0xf
+
+		if r.b[0] == 0 {
+			r.b = r.b[1:]
+
+			return fields
+		}
+
+		if r.b[0]>>4 == 0 {
+			r.b = r.b[1:]
+
+			id = r.varint()
+		} else {
+			id += int64(r.b[0] >> 4)
+
+			r.b = r.b[1:]
+		}
+
+		fields[id] = r.value(kind)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		chunk   map[int64]any
+		column  map[int64]any
+		columns []any
+		footer  []byte
+		groups  []any
+		i       int
+		meta    map[int64]any
+		names   []string
+		offset  int64
+		page    map[int64]any
+		reader  *thriftReader
+		schema  []any
+		stamp   = time.UnixMilli(1700000000000)
+		writer  *Writer
+	)
+
+	writer = NewWriter(&buffer,
+		Options{Timestamp: stamp, RowGroupLen: 2},
+	)
+
+	for i = 0; i < 3; i++ {
+		assert.NoError(t,
+			writer.Write([]byte(fmt.Sprint("k", i)), []byte("vv"),
+				bl.XMetaValue(i),
+			),
+		)
+	}
+
+	assert.NoError(t,
+		writer.Close(),
+	)
+
+	assert.Equal(t, parquetMagic, string(buffer.Bytes()[:4]))
+	assert.Equal(t, parquetMagic, string(buffer.Bytes()[buffer.Len()-4:]))
+
+	footer = buffer.Bytes()[:buffer.Len()-8]
+	footer = footer[len(footer)-int(binary.LittleEndian.Uint32(
+		buffer.Bytes()[buffer.Len()-8:],
+	)):]
+
+	reader = &thriftReader{footer}
+
+	meta = reader.structure()
+
+	assert.Empty(t, reader.b)
+	assert.EqualValues(t, 3, meta[3])
+
+	schema = meta[2].([]any)
+
+	for i = range schema {
+		names = append(names, schema[i].(map[int64]any)[4].(string))
+	}
+
+	assert.Equal(t,
+		[]string{"schema", "key", "value", "key_size", "value_size", "meta",
+			"timestamp",
+		},
+		names,
+	)
+
+	// Two row groups of two and one rows; the key column of the second
+	// holds k2 alone.
+
+	groups = meta[4].([]any)
+
+	assert.Len(t, groups, 2)
+	assert.EqualValues(t, 1, groups[1].(map[int64]any)[3])
+
+	columns = groups[1].(map[int64]any)[1].([]any)
+
+	assert.Len(t, columns, 6)
+
+	chunk = columns[0].(map[int64]any)[3].(map[int64]any)
+	offset = chunk[9].(int64)
+
+	reader = &thriftReader{buffer.Bytes()[offset:]}
+
+	page = reader.structure()
+
+	assert.EqualValues(t, 1, page[5].(map[int64]any)[1])
+	assert.Equal(t, []byte("\x02\x00\x00\x00k2"), reader.b[:page[2].(int64)])
+
+	column = columns[5].(map[int64]any)[3].(map[int64]any)
+
+	reader = &thriftReader{buffer.Bytes()[column[9].(int64):]}
+
+	page = reader.structure()
+
+	assert.Equal(t,
+		binary.LittleEndian.AppendUint64(nil, uint64(stamp.UnixMilli())),
+		reader.b[:8],
+	)
+
+	return
+}
@@ -0,0 +1,406 @@
+package bottledlightning
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A RangeConfig configures a RangeReader. Zero fields take the defaults
+// described.
+type RangeConfig struct {
+	// Client issues the requests, or http.DefaultClient if nil.
+	Client *http.Client
+
+	// ChunkLen is the number of bytes requested at a time, 1 MiB by default.
+	ChunkLen int
+
+	// ReadAhead is the number of chunks following the one being read that
+	// are requested in the background, none by default.
+	ReadAhead int
+
+	// Retries is the number of times a request that fails with an error that
+	// may be transient is retried, and Backoff the delay before the first
+	// retry, doubled before each other, 100 ms by default.
+	Retries int
+	Backoff time.Duration
+}
+
+// A RangeReader reads a remote archive, such as a stream in object storage,
+// by HTTP Range requests, so that a Decoder can restore from it without the
+// archive being downloaded first. It reads the archive one chunk at a time,
+// optionally requesting the following chunks in the background, and retries
+// requests that fail transiently. Every request after the first is
+// conditional upon the ETag of the archive, if the server provides one, so
+// that a RangeReader fails rather than splice together two versions of an
+// archive replaced while being read. RangeReaders are safe for concurrent use
+// by multiple goroutines, although concurrent calls to Read make little sense.
+type RangeReader struct {
+	url    string
+	config RangeConfig
+	size   int64
+	etag   string
+
+	context context.Context
+	cancel  context.CancelFunc
+	mutex   sync.Mutex
+	offset  int64
+	chunks  map[int64]*rangeChunk
+	workers sync.WaitGroup
+}
+
+type rangeChunk struct {
+	done chan struct{}
+	data []byte
+	e    error
+}
+
+// NewRangeReader returns a RangeReader of the archive at the URL, having
+// requested its first chunk to learn its size and ETag. The configuration,
+// which may be nil, is copied and completed with defaults.
+func NewRangeReader(url string, config *RangeConfig) (
+	r *RangeReader, e error,
+) {
+	defer errorf("could not open remote archive", &e)
+
+	var (
+		chunk = &rangeChunk{
+			done: make(chan struct{}),
+		}
+	)
+
+	r = &RangeReader{
+		url:    url,
+		chunks: map[int64]*rangeChunk{0: chunk},
+	}
+
+	if config != nil {
+		r.config = *config
+	}
+
+	if r.config.Client == nil {
+		r.config.Client = http.DefaultClient
+	}
+
+	if r.config.ChunkLen <= 0 {
+		r.config.ChunkLen = 1 << 20
+	}
+
+	if r.config.Backoff <= 0 {
+		r.config.Backoff = 100 * time.Millisecond
+	}
+
+	r.context, r.cancel = context.WithCancel(
+		context.Background(),
+	)
+
+	chunk.data, e = r.fetch(0, true)
+
+	close(chunk.done)
+
+	if e != nil {
+		r.cancel()
+
+		return nil, e
+	}
+
+	return
+}
+
+// Size returns the length of the archive.
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+// Read implements [io.Reader], reading the archive from where the previous
+// call left off, and requesting chunks ahead if so configured.
+func (r *RangeReader) Read(b []byte) (n int, e error) {
+	var (
+		chunk []byte
+		i     int64
+		index int64
+	)
+
+	r.mutex.Lock()
+
+	defer r.mutex.Unlock()
+
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	index = r.offset / int64(r.config.ChunkLen)
+
+	for i = range r.chunks {
+		if i < index {
+			delete(r.chunks, i)
+		}
+	}
+
+	for i = index; i <= index+int64(r.config.ReadAhead); i++ {
+		r.request(i)
+	}
+
+	chunk, e = r.chunk(index)
+	if e != nil {
+		return 0, fmt.Errorf("could not read remote archive: %w", e)
+	}
+
+	n = copy(b, chunk[r.offset-index*int64(r.config.ChunkLen):])
+
+	r.offset += int64(n)
+
+	return
+}
+
+// ReadAt implements [io.ReaderAt], reading the chunks that cover the range
+// without disturbing Read.
+func (r *RangeReader) ReadAt(b []byte, offset int64) (n int, e error) {
+	var (
+		chunk []byte
+		index int64
+		l     = int64(r.config.ChunkLen)
+	)
+
+	if offset < 0 {
+		return 0, fmt.Errorf("could not read remote archive: " +
+			"negative offset",
+		)
+	}
+
+	for n < len(b) && offset < r.size {
+		index = offset / l
+
+		chunk, e = r.fetch(index, false)
+		if e != nil {
+			return n, fmt.Errorf("could not read remote archive: %w", e)
+		}
+
+		n += copy(b[n:], chunk[offset-index*l:])
+
+		offset = index*l + int64(len(chunk))
+	}
+
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return
+}
+
+// Close cancels any requests in progress and waits for them to finish.
+func (r *RangeReader) Close() (e error) {
+	r.cancel()
+
+	r.workers.Wait()
+
+	return
+}
+
+func (r *RangeReader) request(index int64) {
+	// Requests a chunk in the background unless already requested or beyond
+	// the end of the archive. The caller must hold r.mutex.
+
+	var (
+		chunk *rangeChunk
+		ok    bool
+	)
+
+	_, ok = r.chunks[index]
+	if ok || index*int64(r.config.ChunkLen) >= r.size {
+		return
+	}
+
+	chunk = &rangeChunk{
+		done: make(chan struct{}),
+	}
+
+	r.chunks[index] = chunk
+
+	r.workers.Add(1)
+
+	go func() {
+		defer r.workers.Done()
+
+		defer close(chunk.done)
+
+		chunk.data, chunk.e = r.fetch(index, false)
+	}()
+
+	return
+}
+
+func (r *RangeReader) chunk(index int64) (data []byte, e error) {
+	// Waits for a chunk requested by request, discarding it if it failed so
+	// that it will be requested anew. The caller must hold r.mutex.
+
+	var (
+		chunk = r.chunks[index]
+	)
+
+	<-chunk.done
+
+	if chunk.e != nil {
+		delete(r.chunks, index)
+	}
+
+	return chunk.data, chunk.e
+}
+
+func (r *RangeReader) fetch(index int64, first bool) (data []byte, e error) {
+	// Requests a chunk, retrying transient failures with exponential
+	// backoff. The first request learns the size and ETag of the archive.
+
+	var (
+		attempt int
+		backoff = r.config.Backoff
+		retry   bool
+	)
+
+	for attempt = 0; ; attempt++ {
+		data, retry, e = r.get(index, first)
+		if e == nil || !retry || attempt == r.config.Retries {
+			return
+		}
+
+		select {
+		case <-r.context.Done():
+			return nil, r.context.Err()
+
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (r *RangeReader) get(index int64, first bool) (
+	data []byte, retry bool, e error,
+) {
+	// Issues a single request for a chunk, reporting whether a failure may be
+	// transient.
+
+	var (
+		end      int64
+		request  *http.Request
+		response *http.Response
+		start    = index * int64(r.config.ChunkLen)
+	)
+
+	end = start + int64(r.config.ChunkLen) - 1
+
+	if !first {
+		end = min(end, r.size-1)
+	}
+
+	request, e = http.NewRequestWithContext(r.context, http.MethodGet, r.url,
+		nil,
+	)
+	if e != nil {
+		return
+	}
+
+	request.Header.Set("Range",
+		fmt.Sprintf("bytes=%d-%d", start, end),
+	)
+
+	if r.etag != "" {
+		request.Header.Set("If-Match", r.etag)
+	}
+
+	response, e = r.config.Client.Do(request)
+	if e != nil {
+		return nil, r.context.Err() == nil, e
+	}
+
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusPreconditionFailed:
+		return nil, false, fmt.Errorf("remote archive changed while read")
+
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable &&
+		first:
+		// The archive is empty.
+
+		r.size, e = parseContentRange(
+			response.Header.Get("Content-Range"),
+		)
+		if e == nil && r.size != 0 {
+			e = fmt.Errorf("range of first chunk not satisfiable")
+		}
+
+		r.etag = response.Header.Get("ETag")
+
+		return
+
+	case response.StatusCode == http.StatusOK && first &&
+		response.ContentLength == 0:
+		// The archive is empty, and the server ignored the range.
+
+		r.etag = response.Header.Get("ETag")
+
+		return
+
+	case response.StatusCode == http.StatusTooManyRequests ||
+		response.StatusCode >= 500:
+		return nil, true, fmt.Errorf("server responded %s", response.Status)
+
+	case response.StatusCode != http.StatusPartialContent:
+		return nil, false, fmt.Errorf("server responded %s to range request",
+			response.Status,
+		)
+	}
+
+	if first {
+		r.size, e = parseContentRange(
+			response.Header.Get("Content-Range"),
+		)
+		if e != nil {
+			return
+		}
+
+		r.etag = response.Header.Get("ETag")
+
+		end = min(end, r.size-1)
+	}
+
+	if r.etag != "" && response.Header.Get("ETag") != r.etag {
+		return nil, false, fmt.Errorf("remote archive changed while read")
+	}
+
+	data = make([]byte, end-start+1)
+
+	_, e = io.ReadFull(response.Body, data)
+	if e != nil {
+		return nil, r.context.Err() == nil, e
+	}
+
+	return
+}
+
+func parseContentRange(s string) (size int64, e error) {
+	// Returns the complete length given by a Content-Range header, of the
+	// form "bytes 0-99/1000" or "bytes */1000".
+
+	var (
+		ok bool
+	)
+
+	_, s, ok = strings.Cut(s, "/")
+	if !ok || s == "*" {
+		return 0, fmt.Errorf("archive size unknown")
+	}
+
+	size, e = strconv.ParseInt(s, 10, 64)
+	if e != nil || size < 0 {
+		return 0, fmt.Errorf("malformed Content-Range")
+	}
+
+	return
+}
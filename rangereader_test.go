@@ -0,0 +1,179 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeReader(t *testing.T) {
+	const (
+		records = 200
+	)
+
+	var (
+		archive  []byte
+		b        = make([]byte, 100)
+		buffer   bytes.Buffer
+		decoder  *Decoder
+		e        error
+		encoder  *Encoder
+		failures int
+		i        int
+		key      []byte
+		mutex    sync.Mutex
+		reader   *RangeReader
+		server   *httptest.Server
+		version  = "v1"
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a())
+
+	for i = 0; i < records; i++ {
+		encoder.Encode([]byte(fmt.Sprint(i)), bytes.Repeat([]byte{byte(i)}, i))
+	}
+
+	encoder.Close()
+
+	archive = buffer.Bytes()
+
+	server = httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+
+				defer mutex.Unlock()
+
+				if failures > 0 {
+					failures--
+
+					w.WriteHeader(http.StatusServiceUnavailable)
+
+					return
+				}
+
+				w.Header().Set("ETag", `"`+version+`"`)
+
+				http.ServeContent(w, r, "", time.Time{},
+					bytes.NewReader(archive),
+				)
+			},
+		),
+	)
+
+	defer server.Close()
+
+	failures = 2
+
+	reader, e = NewRangeReader(server.URL, &RangeConfig{
+		ChunkLen:  1000,
+		ReadAhead: 2,
+		Retries:   2,
+		Backoff:   time.Millisecond,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, int64(len(archive)), reader.Size())
+
+	decoder = NewDecoder(reader, fnv.New32a())
+
+	for i = 0; ; i++ {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if !assert.NoError(t, e) {
+			break
+		}
+
+		assert.Equal(t, fmt.Sprint(i), string(key))
+	}
+
+	assert.Equal(t, records, i)
+
+	_, e = reader.ReadAt(b, 950)
+
+	assert.NoError(t, e)
+	assert.Equal(t, archive[950:1050], b)
+
+	_, e = reader.ReadAt(b, int64(len(archive)-10))
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	mutex.Lock()
+
+	failures = 3
+
+	mutex.Unlock()
+
+	_, e = reader.ReadAt(b, 0)
+
+	assert.ErrorContains(t, e, "503")
+
+	mutex.Lock()
+
+	failures = 0
+	version = "v2"
+
+	mutex.Unlock()
+
+	_, e = reader.ReadAt(b, 0)
+
+	assert.ErrorContains(t, e, "changed")
+
+	assert.NoError(t, reader.Close())
+
+	mutex.Lock()
+
+	archive = nil
+
+	mutex.Unlock()
+
+	reader, e = NewRangeReader(server.URL, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Zero(t, reader.Size())
+
+	_, e = reader.Read(b)
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
+
+func TestRangeReaderUnsupported(t *testing.T) {
+	var (
+		e      error
+		server *httptest.Server
+	)
+
+	server = httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("whole archive"))
+			},
+		),
+	)
+
+	defer server.Close()
+
+	_, e = NewRangeReader(server.URL, nil)
+
+	assert.ErrorContains(t, e, "to range request")
+
+	return
+}
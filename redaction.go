@@ -0,0 +1,62 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+)
+
+// RedactToPlaceholder returns a redaction function, for use with
+// WithRedaction, that replaces every value with the placeholder.
+func RedactToPlaceholder(placeholder []byte) func(val []byte) []byte {
+	return func([]byte) []byte {
+		return placeholder
+	}
+}
+
+// RedactToSHA256 is a redaction function, for use with WithRedaction, that
+// replaces a value with its SHA-256 digest, so that equal values remain
+// recognisably equal without being disclosed.
+func RedactToSHA256(val []byte) []byte {
+	var (
+		digest = sha256.Sum256(val)
+	)
+
+	return digest[:]
+}
+
+func (o *options) redact(key, val []byte) []byte {
+	// Returns the value of a record, redacted if its key so requires.
+
+	if o.redactMatch == nil || !o.redactMatch(key) {
+		return val
+	}
+
+	return o.redactReplace(val)
+}
+
+func (o *options) redactFrom(key []byte, val io.Reader, size int64) (
+	redacted io.Reader, redactedSize int64, e error,
+) {
+	// Returns the value of a record, of the given size, to be read from val,
+	// redacted if its key so requires.
+
+	var (
+		b []byte
+	)
+
+	if o.redactMatch == nil || !o.redactMatch(key) {
+		return val, size, nil
+	}
+
+	b = make([]byte, size)
+
+	_, e = io.ReadFull(val, b)
+	if e != nil {
+		return
+	}
+
+	b = o.redactReplace(b)
+
+	return bytes.NewReader(b), int64(len(b)), nil
+}
@@ -0,0 +1,90 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedaction(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		digest  = sha256.Sum256([]byte("hunter2"))
+		e       error
+		encoder *Encoder
+		key     []byte
+		secret  = func(key []byte) bool {
+			return bytes.HasPrefix(key, []byte("secret/"))
+		}
+		val []byte
+		xmv byte
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a(),
+		WithRedaction(secret, RedactToPlaceholder([]byte("REDACTED"))),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeX([]byte("secret/a"), []byte("hunter2"), XMetaValue5),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("secret/b"),
+			bytes.NewReader([]byte("hunter2")), 7,
+		),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("public"), []byte("hello")),
+	)
+
+	assert.NotContains(t, buffer.String(), "hunter2")
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	key, val, xmv, e = decoder.DecodeX()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, []byte("secret/a"), key)
+	assert.Equal(t, []byte("REDACTED"), val)
+	assert.Equal(t, byte(XMetaValue5), xmv)
+
+	_, val, e = decoder.Decode()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, []byte("REDACTED"), val)
+
+	_, val, e = decoder.Decode()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, []byte("hello"), val)
+
+	encoder = NewEncoder(&buffer, fnv.New32a())
+
+	assert.NoError(t,
+		encoder.Encode([]byte("secret/c"), []byte("hunter2")),
+	)
+
+	decoder = NewDecoder(&buffer, fnv.New32a(),
+		WithRedaction(secret, RedactToSHA256),
+	)
+
+	_, val, e = decoder.Decode()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, digest[:], val)
+
+	return
+}
@@ -0,0 +1,462 @@
+// Package redis replays streams of changes against Redis servers, and scans
+// their keyspaces into streams.
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/internal/wrap"
+)
+
+const (
+	redisPipelineLen = 64
+	redisScanCount   = 100
+)
+
+// A Replay replays a stream of changes against a Redis server, for
+// consumers that keep their data in Redis: every record marked with
+// XMetaFlagTombstone as a DEL of its key, and every other as a SET of its key
+// to its value. Commands are pipelined and, if so configured, paced.
+type Replay struct {
+	// PipelineLen is the number of commands sent before their replies are
+	// awaited, 64 if zero.
+	PipelineLen int
+
+	// Rate, if positive, is the most commands sent per second.
+	Rate float64
+}
+
+// Run replays the records of the Decoder over the connection, which speaks
+// RESP, until the end of the stream, and returns the number of commands
+// executed. It returns the first error replied by the server, naming the
+// key. The context is consulted between pipelines; a connection whose reads
+// and writes should be interrupted must have deadlines of its own.
+func (r Replay) Run(ctx context.Context, conn io.ReadWriter,
+	decoder *bl.Decoder,
+) (n int64, e error) {
+	defer wrap.Errorf("could not replay to redis", &e)
+
+	var (
+		client = newRedisClient(conn)
+		key    []byte
+		keys   [][]byte
+		length = r.PipelineLen
+		start  = time.Now()
+		val    []byte
+		xmv    byte
+	)
+
+	if length <= 0 {
+		length = redisPipelineLen
+	}
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		if bl.XMetaValue(xmv).HasFlag(bl.XMetaFlagTombstone) {
+			client.command([]byte("DEL"), key)
+		} else {
+			client.command([]byte("SET"), key, val)
+		}
+
+		keys = append(keys, bytes.Clone(key))
+
+		if len(keys) < length {
+			continue
+		}
+
+		e = r.send(ctx, client, start, n, keys)
+		if e != nil {
+			return
+		}
+
+		n += int64(len(keys))
+
+		keys = keys[:0]
+	}
+
+	e = r.send(ctx, client, start, n, keys)
+	if e != nil {
+		return
+	}
+
+	return n + int64(len(keys)), nil
+}
+
+func (r Replay) send(ctx context.Context, client *redisClient,
+	start time.Time, sent int64, keys [][]byte,
+) (e error) {
+	// Sends the buffered commands, one per key, once the rate allows, and
+	// receives their replies.
+
+	if len(keys) == 0 {
+		return
+	}
+
+	e = r.pace(ctx, start, sent+int64(len(keys)))
+	if e != nil {
+		return
+	}
+
+	e = client.exchange(keys)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (r Replay) pace(ctx context.Context, start time.Time, sent int64) (
+	e error,
+) {
+	// Waits until sending the given number of commands since start keeps to
+	// the rate, or the context is done.
+
+	var (
+		due   time.Duration
+		timer *time.Timer
+	)
+
+	if r.Rate <= 0 {
+		return ctx.Err()
+	}
+
+	due = time.Duration(float64(sent)/r.Rate*float64(time.Second)) -
+		time.Since(start)
+	if due <= 0 {
+		return ctx.Err()
+	}
+
+	timer = time.NewTimer(due)
+
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A Scan encodes the string keys of a Redis server, and their values,
+// on an Encoder, the reverse of a Replay.
+type Scan struct {
+	// Match, if not empty, is the glob-style pattern of the keys to scan.
+	Match string
+
+	// Count is the number of keys requested of each SCAN, 100 if zero.
+	Count int
+}
+
+// Run scans the keyspace over the connection, which speaks RESP, encoding the
+// value of every key that holds a string, and returns the number of records
+// encoded. As SCAN guarantees, every key present throughout the scan is
+// encoded at least once; keys that change during it may be encoded more
+// than once, or not at all. It does not close the Encoder.
+func (s Scan) Run(ctx context.Context, conn io.ReadWriter,
+	encoder *bl.Encoder,
+) (n int64, e error) {
+	defer wrap.Errorf("could not scan redis", &e)
+
+	var (
+		args   [][]byte
+		client = newRedisClient(conn)
+		count  = s.Count
+		cursor = []byte("0")
+		i      int
+		keys   []any
+		reply  any
+		vals   []any
+	)
+
+	if count <= 0 {
+		count = redisScanCount
+	}
+
+	for {
+		e = ctx.Err()
+		if e != nil {
+			return
+		}
+
+		args = [][]byte{[]byte("SCAN"), cursor}
+
+		if s.Match != "" {
+			args = append(args, []byte("MATCH"), []byte(s.Match))
+		}
+
+		args = append(args, []byte("COUNT"), []byte(strconv.Itoa(count)))
+
+		reply, e = client.call(args...)
+		if e != nil {
+			return
+		}
+
+		cursor, keys, e = redisScanReply(reply)
+		if e != nil {
+			return
+		}
+
+		if len(keys) > 0 {
+			args = [][]byte{[]byte("MGET")}
+
+			for i = range keys {
+				args = append(args, keys[i].([]byte))
+			}
+
+			reply, e = client.call(args...)
+			if e != nil {
+				return
+			}
+
+			vals, _ = reply.([]any)
+			if len(vals) != len(keys) {
+				return n, fmt.Errorf("malformed MGET reply")
+			}
+
+			// Keys that do not hold strings, or that have since been
+			// deleted, have nil values.
+
+			for i = range keys {
+				if vals[i] == nil {
+					continue
+				}
+
+				e = encoder.Encode(keys[i].([]byte), vals[i].([]byte))
+				if e != nil {
+					return
+				}
+
+				n++
+			}
+		}
+
+		if string(cursor) == "0" {
+			return
+		}
+	}
+}
+
+func redisScanReply(reply any) (cursor []byte, keys []any, e error) {
+	var (
+		key   any
+		ok    bool
+		parts []any
+	)
+
+	parts, ok = reply.([]any)
+	if !ok || len(parts) != 2 {
+		return nil, nil, fmt.Errorf("malformed SCAN reply")
+	}
+
+	cursor, ok = parts[0].([]byte)
+	if !ok {
+		return nil, nil, fmt.Errorf("malformed SCAN cursor")
+	}
+
+	keys, ok = parts[1].([]any)
+	if !ok && parts[1] != nil {
+		return nil, nil, fmt.Errorf("malformed SCAN keys")
+	}
+
+	for _, key = range keys {
+		_, ok = key.([]byte)
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed SCAN key")
+		}
+	}
+
+	return
+}
+
+// An Error is an error replied by a Redis server.
+type Error struct {
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+// A redisClient pipelines commands over a connection speaking RESP, the
+// protocol of Redis.
+type redisClient struct {
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func newRedisClient(conn io.ReadWriter) *redisClient {
+	return &redisClient{
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+}
+
+func (c *redisClient) command(args ...[]byte) {
+	// Buffers a command, to be sent by exchange or call.
+
+	var (
+		arg []byte
+	)
+
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+
+	for _, arg = range args {
+		fmt.Fprintf(c.writer, "$%d\r\n", len(arg))
+
+		c.writer.Write(arg)
+		c.writer.WriteString("\r\n")
+	}
+
+	return
+}
+
+func (c *redisClient) exchange(keys [][]byte) (e error) {
+	// Sends the buffered commands, one per key, and receives their replies,
+	// returning the first error replied, naming its key, once all have been
+	// received.
+
+	var (
+		err   error
+		first error
+		i     int
+		reply any
+	)
+
+	e = c.writer.Flush()
+	if e != nil {
+		return
+	}
+
+	for i = range keys {
+		reply, e = c.receive()
+		if e != nil {
+			return
+		}
+
+		err, _ = reply.(error)
+		if err != nil && first == nil {
+			first = fmt.Errorf("key %x: %w", keys[i], err)
+		}
+	}
+
+	e = first
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (c *redisClient) call(args ...[]byte) (reply any, e error) {
+	// Sends a command and returns its reply, or the error replied.
+
+	var (
+		ok bool
+	)
+
+	c.command(args...)
+
+	e = c.writer.Flush()
+	if e != nil {
+		return
+	}
+
+	reply, e = c.receive()
+	if e != nil {
+		return
+	}
+
+	e, ok = reply.(error)
+	if ok {
+		return nil, e
+	}
+
+	return reply, nil
+}
+
+func (c *redisClient) receive() (reply any, e error) {
+	// Receives a reply: a string, integer, bulk string or array, returned as
+	// a []byte, int64, []byte or []any respectively, nil, or a Error.
+
+	var (
+		i     int64
+		items []any
+		line  []byte
+		n     int64
+		val   []byte
+	)
+
+	line, e = c.reader.ReadSlice('\n')
+	if e != nil {
+		return
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply")
+	}
+
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return append([]byte(nil), line[1:]...), nil
+
+	case '-':
+		return Error{string(line[1:])}, nil
+
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	}
+
+	n, e = strconv.ParseInt(string(line[1:]), 10, 64)
+	if e != nil {
+		return
+	}
+
+	switch {
+	case n < 0:
+		return nil, nil
+
+	case line[0] == '$':
+		val = make([]byte, n+2)
+
+		_, e = io.ReadFull(c.reader, val)
+		if e != nil {
+			return
+		}
+
+		return val[:n], nil
+
+	case line[0] == '*':
+		items = make([]any, n)
+
+		for i = range n {
+			items[i], e = c.receive()
+			if e != nil {
+				return
+			}
+		}
+
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("malformed reply")
+}
@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+type fakeRedis struct {
+	// Serves SET, DEL, SCAN and MGET on a map, refusing to SET the key
+	// "bad". Keys of lists are held as nil, and so are not strings.
+
+	data map[string][]byte
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	var (
+		args    []any
+		client  = newRedisClient(conn)
+		command any
+		e       error
+		writer  = bufio.NewWriter(conn)
+	)
+
+	defer conn.Close()
+
+	for {
+		command, e = client.receive()
+		if e != nil {
+			return
+		}
+
+		args = command.([]any)
+
+		f.reply(writer, string(args[0].([]byte)), args[1:])
+
+		if client.reader.Buffered() == 0 {
+			writer.Flush()
+		}
+	}
+}
+
+func (f *fakeRedis) reply(w *bufio.Writer, command string, args []any) {
+	var (
+		arg    any
+		cursor int
+		count  = 10
+		i      int
+		keys   []string
+		match  = "*"
+		ok     bool
+		val    []byte
+		found  []string
+	)
+
+	switch command {
+	case "SET":
+		if string(args[0].([]byte)) == "bad" {
+			w.WriteString("-ERR refused\r\n")
+
+			return
+		}
+
+		f.data[string(args[0].([]byte))] = args[1].([]byte)
+
+		w.WriteString("+OK\r\n")
+
+	case "DEL":
+		delete(f.data, string(args[0].([]byte)))
+
+		w.WriteString(":1\r\n")
+
+	case "SCAN":
+		cursor, _ = strconv.Atoi(string(args[0].([]byte)))
+
+		for i = 1; i < len(args); i += 2 {
+			switch string(args[i].([]byte)) {
+			case "MATCH":
+				match = string(args[i+1].([]byte))
+
+			case "COUNT":
+				count, _ = strconv.Atoi(string(args[i+1].([]byte)))
+			}
+		}
+
+		for arg = range f.data {
+			keys = append(keys, arg.(string))
+		}
+
+		slices.Sort(keys)
+
+		for i = cursor; i < len(keys) && i < cursor+count; i++ {
+			ok, _ = path.Match(match, keys[i])
+			if ok {
+				found = append(found, keys[i])
+			}
+		}
+
+		if i == len(keys) {
+			i = 0
+		}
+
+		fmt.Fprintf(w, "*2\r\n$%d\r\n%d\r\n*%d\r\n",
+			len(strconv.Itoa(i)), i, len(found),
+		)
+
+		for _, arg = range found {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg.(string)), arg)
+		}
+
+	case "MGET":
+		fmt.Fprintf(w, "*%d\r\n", len(args))
+
+		for _, arg = range args {
+			val = f.data[string(arg.([]byte))]
+			if val == nil {
+				w.WriteString("$-1\r\n")
+			} else {
+				fmt.Fprintf(w, "$%d\r\n%s\r\n", len(val), val)
+			}
+		}
+	}
+
+	return
+}
+
+func TestReplay(t *testing.T) {
+	var (
+		buffer    bytes.Buffer
+		client    net.Conn
+		e         error
+		encoder   = bl.NewEncoder(&buffer, nil)
+		fake      = &fakeRedis{data: map[string][]byte{"list": nil}}
+		n         int64
+		server    net.Conn
+		start     time.Time
+		tombstone = bl.NewXMetaValue(0, bl.XMetaFlagTombstone)
+	)
+
+	client, server = net.Pipe()
+
+	defer client.Close()
+
+	go fake.serve(server)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), []byte("2")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("c"), []byte("3")),
+	)
+	assert.NoError(t,
+		encoder.EncodeX([]byte("b"), nil, tombstone),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	start = time.Now()
+
+	n, e = Replay{PipelineLen: 3, Rate: 100}.Run(context.Background(),
+		client, bl.NewDecoder(&buffer, nil),
+	)
+	assert.NoError(t, e)
+	assert.EqualValues(t, 4, n)
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+	assert.Equal(t,
+		map[string][]byte{"a": []byte("1"), "c": []byte("3"), "list": nil},
+		fake.data,
+	)
+
+	// A command refused by the server fails the replay once the replies of
+	// its pipeline are received.
+
+	buffer.Reset()
+
+	encoder = bl.NewEncoder(&buffer, nil)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("bad"), []byte("4")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("d"), []byte("5")),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	_, e = Replay{}.Run(context.Background(),
+		client, bl.NewDecoder(&buffer, nil),
+	)
+	assert.ErrorAs(t, e, &Error{})
+	assert.ErrorContains(t, e, "key 626164: ERR refused")
+	assert.Equal(t, []byte("5"), fake.data["d"])
+
+	return
+}
+
+func TestScan(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		client  net.Conn
+		decoder *bl.Decoder
+		e       error
+		encoder = bl.NewEncoder(&buffer, nil)
+		fake    = &fakeRedis{
+			data: map[string][]byte{
+				"a": []byte("1"), "b": []byte("2"), "list": nil,
+				"x": []byte("3"),
+			},
+		}
+		key    []byte
+		keys   []string
+		n      int64
+		server net.Conn
+	)
+
+	client, server = net.Pipe()
+
+	defer client.Close()
+
+	go fake.serve(server)
+
+	n, e = Scan{Match: "[a-l]*", Count: 1}.Run(context.Background(),
+		client, encoder,
+	)
+	assert.NoError(t, e)
+	assert.EqualValues(t, 2, n)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	decoder = bl.NewDecoder(&buffer, nil)
+
+	for {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		keys = append(keys, string(key))
+	}
+
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	return
+}
@@ -0,0 +1,386 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// Relay copies every record from the [io.Reader] to the [io.Writer] without
+// decoding it, as a proxy or protocol gateway that merely forwards a stream
+// might, and returns the number of bytes written. Framing is checked, and so
+// are checksums if the [hash.Hash32] is not nil, but keys and values pass
+// through a buffer of fixed size rather than being held in memory whole; only
+// control records, which are small, are read in full.
+//
+// The bytes of a record are forwarded before its checksum is verified, so a
+// damaged record may reach the destination before Relay returns an error. Of
+// the options, only WithIODeadline is effective, and it applies to both ends.
+func Relay(dst io.Writer, src io.Reader, hasher hash.Hash32, opts ...Option) (
+	n int64, e error,
+) {
+	defer errorf("could not relay stream", &e)
+
+	var (
+		r = &relay{
+			writer:  dst,
+			reader:  src,
+			hasher:  hasher,
+			options: newOptions(opts),
+			buffer:  make([]byte, relayBufferLen),
+		}
+	)
+
+	defer func() {
+		n = r.written
+	}()
+
+	for {
+		e = r.next()
+		if e == io.EOF {
+			return n, nil
+		}
+
+		if e != nil {
+			return
+		}
+	}
+}
+
+type relay struct {
+	writer  io.Writer
+	reader  io.Reader
+	hasher  hash.Hash32
+	options options
+	buffer  []byte
+
+	features   uint32
+	announced  uint32 // union of features announced so far
+	batched    int
+	records    int64 // number of data records
+	snapshotID []byte
+	aborted    bool
+	written    int64
+}
+
+func (r *relay) next() (e error) {
+	// Forwards one record, verifying it as a Decoder would and acting upon it
+	// if it is a control record that affects verification.
+
+	var (
+		batch   bool
+		control bool
+		hashed  bool
+		head    []byte
+		header  Header
+		val     []byte
+	)
+
+	header, head, e = r.readHeader()
+	switch {
+	case e != io.EOF, r.aborted:
+
+	case r.batched > 0:
+		e = fmt.Errorf("stream ended before checksum of last batch: %w",
+			io.ErrUnexpectedEOF,
+		)
+	}
+
+	if e != nil {
+		return
+	}
+
+	batch = r.features&featureBatchChecksum != 0
+	control = header.IsControl()
+
+	if batch && !control {
+		r.batched++
+	}
+
+	switch {
+	case r.hasher == nil:
+
+	case batch && !control:
+		hashed = true
+
+	case batch || !header.Checksummed:
+
+	default:
+		r.hasher.Reset()
+
+		hashed = true
+
+		if r.features&featureHeaderChecksum == 0 {
+			head = nil
+		}
+	}
+
+	if hashed {
+		_, e = r.hasher.Write(head)
+		if e != nil {
+			return
+		}
+	}
+
+	if control {
+		val, e = r.readControl(header.ValLen, hashed)
+	} else {
+		e = r.copyBody(header.KeyLen+header.ValLen, hashed)
+	}
+
+	if e != nil {
+		return
+	}
+
+	if header.Checksummed && (control || !batch) {
+		e = r.copyTrailer(hashed)
+		if e != nil {
+			return
+		}
+	}
+
+	if !control {
+		r.records++
+
+		return
+	}
+
+	e = r.handleControl(val)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (r *relay) readHeader() (header Header, head []byte, e error) {
+	// Reads and forwards the header of the next record. A stream that ends
+	// cleanly before the header yields io.EOF.
+
+	var (
+		x int
+	)
+
+	head = make([]byte, 2, 2+maxUintLen32)
+
+	e = r.setDeadlines()
+	if e != nil {
+		return
+	}
+
+	_, e = io.ReadFull(r.reader, head)
+	if e != nil {
+		return
+	}
+
+	x = int(head[0] >> (offsetX - 8))
+
+	if x == 0 {
+		x = maxUintLen32
+	}
+
+	head = head[:2+x]
+
+	_, e = io.ReadFull(r.reader, head[2:])
+	if e != nil {
+		return header, head, unexpectedEOF(e)
+	}
+
+	header, e = ParseHeader(head)
+	if e != nil {
+		return
+	}
+
+	e = r.write(head)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (r *relay) readControl(v int, hashed bool) (val []byte, e error) {
+	// Reads and forwards the value of a control record, which is retained.
+
+	val = make([]byte, v)
+
+	_, e = io.ReadFull(r.reader, val)
+	if e != nil {
+		return nil, unexpectedEOF(e)
+	}
+
+	if hashed {
+		_, e = r.hasher.Write(val)
+		if e != nil {
+			return
+		}
+	}
+
+	e = r.write(val)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (r *relay) copyBody(l int, hashed bool) (e error) {
+	// Forwards the l bytes of the key and value of a data record through
+	// r.buffer.
+
+	var (
+		c int
+	)
+
+	for l > 0 {
+		c, e = io.ReadFull(r.reader,
+			r.buffer[:min(l, len(r.buffer))],
+		)
+		if e != nil {
+			return unexpectedEOF(e)
+		}
+
+		if hashed {
+			_, e = r.hasher.Write(r.buffer[:c])
+			if e != nil {
+				return
+			}
+		}
+
+		e = r.write(r.buffer[:c])
+		if e != nil {
+			return
+		}
+
+		l -= c
+	}
+
+	return
+}
+
+func (r *relay) copyTrailer(hashed bool) (e error) {
+	// Forwards a trailing 32-bit checksum, verifying it if hashed.
+
+	var (
+		b = make([]byte, crcLen)
+	)
+
+	_, e = io.ReadFull(r.reader, b)
+	if e != nil {
+		return unexpectedEOF(e)
+	}
+
+	if hashed {
+		defer r.hasher.Reset()
+
+		if r.hasher.Sum32() != binary.BigEndian.Uint32(b) {
+			return fmt.Errorf("computed checksum does not match observed")
+		}
+	}
+
+	e = r.write(b)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (r *relay) handleControl(val []byte) (e error) {
+	// Acts upon the control records that affect verification, and notes the
+	// snapshot ID and any abort. The rest are forwarded without
+	// interpretation.
+
+	if len(val) == 0 {
+		return fmt.Errorf("control record kind missing")
+	}
+
+	switch controlKind(val[0]) {
+	case controlFeatures:
+		if len(val) != 5 {
+			return fmt.Errorf("malformed features control record")
+		}
+
+		r.features = binary.BigEndian.Uint32(val[1:])
+		r.announced |= r.features
+
+	case controlBatchChecksum:
+		if len(val) != 1+maxUintLen32 {
+			return fmt.Errorf("malformed batch checksum control record")
+		}
+
+		r.batched = 0
+
+		if r.hasher == nil {
+			return
+		}
+
+		defer r.hasher.Reset()
+
+		if r.hasher.Sum32() != binary.BigEndian.Uint32(val[1:]) {
+			return fmt.Errorf("computed batch checksum does not match observed")
+		}
+
+	case controlSnapshotID:
+		r.snapshotID = bytes.Clone(val[1:])
+
+	case controlAbort:
+		r.aborted = true
+	}
+
+	return
+}
+
+func (r *relay) write(b []byte) (e error) {
+	// Writes b to the destination, counting the bytes written.
+
+	var (
+		n int
+	)
+
+	n, e = r.writer.Write(b)
+
+	r.written += int64(n)
+
+	return
+}
+
+func (r *relay) setDeadlines() (e error) {
+	// Sets the read and write deadlines of the source and destination for the
+	// next record, if so configured and where supported.
+
+	var (
+		ok     bool
+		reader interface{ SetReadDeadline(time.Time) error }
+		writer interface{ SetWriteDeadline(time.Time) error }
+	)
+
+	if r.options.ioDeadline <= 0 {
+		return
+	}
+
+	reader, ok = r.reader.(interface{ SetReadDeadline(time.Time) error })
+	if ok {
+		e = reader.SetReadDeadline(
+			time.Now().Add(r.options.ioDeadline),
+		)
+		if e != nil {
+			return
+		}
+	}
+
+	writer, ok = r.writer.(interface{ SetWriteDeadline(time.Time) error })
+	if ok {
+		e = writer.SetWriteDeadline(
+			time.Now().Add(r.options.ioDeadline),
+		)
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,106 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelay(t *testing.T) {
+	var (
+		e      error
+		n      int64
+		opt    []Option
+		opts   [][]Option
+		output bytes.Buffer
+		source bytes.Buffer
+		stream []byte
+	)
+
+	opts = [][]Option{
+		nil,
+		{WithHeaderChecksum()},
+		{WithBatchChecksum(2)},
+	}
+
+	for _, opt = range opts {
+		source.Reset()
+
+		relayEncode(t, NewEncoder(&source, fnv.New32a(), opt...))
+
+		stream = bytes.Clone(source.Bytes())
+
+		output.Reset()
+
+		n, e = Relay(&output, &source, fnv.New32a())
+
+		assert.NoError(t, e)
+		assert.Equal(t, int64(len(stream)), n)
+		assert.Equal(t, stream, output.Bytes())
+
+		relayDecode(t, NewDecoder(&output, fnv.New32a()))
+
+		stream[len(stream)-relayBufferLen] ^= 1
+
+		_, e = Relay(io.Discard, bytes.NewReader(stream), fnv.New32a())
+
+		assert.Error(t, e)
+
+		_, e = Relay(io.Discard, bytes.NewReader(stream), nil)
+
+		assert.NoError(t, e)
+
+		_, e = Relay(io.Discard, bytes.NewReader(stream[:len(stream)-1]), nil)
+
+		assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+	}
+
+	return
+}
+
+func relayEncode(t *testing.T, encoder *Encoder) {
+	assert.NoError(t,
+		encoder.EncodeX([]byte("a"), []byte("one"), XMetaValue3),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), make([]byte, 3*relayBufferLen)),
+	)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	return
+}
+
+func relayDecode(t *testing.T, decoder *Decoder) {
+	var (
+		e   error
+		key []byte
+		val []byte
+		xmv byte
+	)
+
+	key, val, xmv, e = decoder.DecodeX()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("a"), key)
+	assert.Equal(t, []byte("one"), val)
+	assert.Equal(t, byte(XMetaValue3), xmv)
+
+	key, val, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("b"), key)
+	assert.Equal(t, make([]byte, 3*relayBufferLen), val)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
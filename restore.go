@@ -0,0 +1,106 @@
+package bottledlightning
+
+import (
+	"hash"
+	"io/fs"
+	"time"
+)
+
+// A RestoreTarget is the point as of which Archive.RestoreTo restores a
+// database: the dump of Sequence, unless zero, or else the last dump created at
+// or before Time, unless zero, or else the last dump of all.
+type RestoreTarget struct {
+	Sequence uint64
+	Time     time.Time
+}
+
+// A Restore configures Archive.RestoreTo.
+type Restore struct {
+	// Begin begins a write transaction on the LMDB database into which the
+	// dumps are loaded.
+	Begin func() (LoadTxn, error)
+
+	// NewHasher returns the [hash.Hash32] with which the dumps were
+	// encoded, if any, and Options configure their Decoders.
+	NewHasher func() hash.Hash32
+	Options   []Option
+
+	// TxnLen is the number of records after which a write transaction is
+	// committed, as for a Loader.
+	TxnLen int
+}
+
+// RestoreTo loads the database as of the target from the archive, applying the
+// dumps of its chain, as by Chain, in order with a Loader, tombstones as
+// deletions. The database should be empty to begin with. It returns the number
+// of records applied, which may be nonzero on error, as the database is left
+// as loaded so far.
+func (a *Archive) RestoreTo(target RestoreTarget, r Restore) (
+	records int64, e error,
+) {
+	defer errorf("could not restore from archive", &e)
+
+	var (
+		chain []ArchiveDump
+		dump  ArchiveDump
+		n     int64
+		seq   = target.Sequence
+	)
+
+	switch {
+	case seq != 0:
+
+	case !target.Time.IsZero():
+		seq, e = a.SequenceAt(target.Time)
+		if e != nil {
+			return
+		}
+
+	case len(a.dumps) > 0:
+		seq = a.dumps[len(a.dumps)-1].Sequence
+	}
+
+	chain, e = a.Chain(seq)
+	if e != nil {
+		return
+	}
+
+	for _, dump = range chain {
+		n, e = a.load(dump, r)
+
+		records += n
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (a *Archive) load(dump ArchiveDump, r Restore) (n int64, e error) {
+	// Applies the records of a dump to the database.
+
+	var (
+		file   fs.File
+		hasher hash.Hash32
+	)
+
+	file, e = a.fsys.Open(dump.Name)
+	if e != nil {
+		return
+	}
+
+	defer file.Close()
+
+	if r.NewHasher != nil {
+		hasher = r.NewHasher()
+	}
+
+	return Loader{
+		Begin:  r.Begin,
+		TxnLen: r.TxnLen,
+	}.Load(
+		NewDecoder(file, hasher, r.Options...),
+	)
+}
@@ -0,0 +1,113 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreTo(t *testing.T) {
+	var (
+		archive *Archive
+		db      map[string]string
+		e       error
+		epoch   = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		fsys    = make(fstest.MapFS)
+		records int64
+		restore Restore
+	)
+
+	restore.Begin = func() (LoadTxn, error) {
+		return newMapTxn(db), nil
+	}
+
+	// A full dump, an incremental deleting and adding a record, and another
+	// changing a record, one every hour.
+
+	fsys[DumpName(1, epoch, true)] = restoreTestDump(t, "a=1", "b=1")
+	fsys[DumpName(2, epoch.Add(time.Hour), false)] = restoreTestDump(t,
+		"b", "c=2",
+	)
+	fsys[DumpName(3, epoch.Add(2*time.Hour), false)] = restoreTestDump(t,
+		"a=3",
+	)
+
+	archive, e = OpenArchive(fsys)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	db = make(map[string]string)
+
+	records, e = archive.RestoreTo(
+		RestoreTarget{Time: epoch.Add(90 * time.Minute)}, restore,
+	)
+	assert.NoError(t, e)
+	assert.Equal(t, int64(4), records)
+	assert.Equal(t, map[string]string{"a": "1", "c": "2"}, db)
+
+	db = make(map[string]string)
+
+	records, e = archive.RestoreTo(RestoreTarget{Sequence: 1}, restore)
+	assert.NoError(t, e)
+	assert.Equal(t, int64(2), records)
+	assert.Equal(t, map[string]string{"a": "1", "b": "1"}, db)
+
+	db = make(map[string]string)
+
+	records, e = archive.RestoreTo(RestoreTarget{}, restore)
+	assert.NoError(t, e)
+	assert.Equal(t, int64(5), records)
+	assert.Equal(t, map[string]string{"a": "3", "c": "2"}, db)
+
+	_, e = archive.RestoreTo(
+		RestoreTarget{Time: epoch.Add(-time.Hour)}, restore,
+	)
+	assert.ErrorContains(t, e, "no dump created")
+
+	_, e = archive.RestoreTo(RestoreTarget{Sequence: 4}, restore)
+	assert.ErrorContains(t, e, "no dump of sequence 4")
+
+	return
+}
+
+func restoreTestDump(t *testing.T, records ...string) *fstest.MapFile {
+	// Returns a dump of the records, given as "key=val", or as a bare key
+	// for a tombstone.
+
+	var (
+		buffer  bytes.Buffer
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		found   bool
+		key     string
+		record  string
+		val     string
+	)
+
+	for _, record = range records {
+		key, val, found = strings.Cut(record, "=")
+
+		switch {
+		case found:
+			e = encoder.Encode([]byte(key), []byte(val))
+
+		default:
+			e = encoder.EncodeX([]byte(key), nil,
+				NewXMetaValue(0, XMetaFlagTombstone),
+			)
+		}
+
+		assert.NoError(t, e)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	return &fstest.MapFile{Data: buffer.Bytes()}
+}
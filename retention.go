@@ -0,0 +1,286 @@
+package bottledlightning
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DumpName returns the conventional name of a dump in an Archive, of the given
+// sequence number, created at the given time, and full or incremental, such as
+// "00000000000000000001-20260101T000000Z-full.bl". Names sort in order of
+// sequence.
+func DumpName(seq uint64, created time.Time, full bool) string {
+	var (
+		kind = "incr"
+	)
+
+	if full {
+		kind = "full"
+	}
+
+	return fmt.Sprintf("%020d-%s-%s.bl",
+		seq, created.UTC().Format(dumpTimeLayout), kind,
+	)
+}
+
+// An ArchiveDump describes a dump of an Archive, as named by DumpName.
+type ArchiveDump struct {
+	Name     string
+	Sequence uint64
+	Created  time.Time
+	Full     bool
+}
+
+// An Archive is a directory, or an object-store prefix exposed as an [fs.FS],
+// of the full and incremental dumps of a database, named by DumpName, each
+// incremental holding the changes since the dump of the previous sequence, as
+// written by DiffDump. The names are the manifest of the archive: they alone
+// determine the chain of dumps from which to restore each. Files of other names
+// are ignored.
+type Archive struct {
+	fsys  fs.FS
+	dumps []ArchiveDump
+}
+
+// OpenArchive lists the dumps of the archive in the root of the [fs.FS].
+func OpenArchive(fsys fs.FS) (a *Archive, e error) {
+	defer errorf("could not open archive", &e)
+
+	var (
+		dump    ArchiveDump
+		entries []fs.DirEntry
+		entry   fs.DirEntry
+		i       int
+		ok      bool
+	)
+
+	entries, e = fs.ReadDir(fsys, ".")
+	if e != nil {
+		return
+	}
+
+	a = &Archive{fsys: fsys}
+
+	for _, entry = range entries {
+		dump, ok = parseDumpName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		a.dumps = append(a.dumps, dump)
+	}
+
+	for i = 1; i < len(a.dumps); i++ {
+		if a.dumps[i].Sequence == a.dumps[i-1].Sequence {
+			return nil, fmt.Errorf("dumps %s and %s of the same sequence",
+				a.dumps[i-1].Name, a.dumps[i].Name,
+			)
+		}
+	}
+
+	return
+}
+
+// Dumps returns the dumps of the archive, in order of sequence.
+func (a *Archive) Dumps() []ArchiveDump {
+	return slices.Clone(a.dumps)
+}
+
+// Next returns the name of the next dump to write to the archive, full or
+// incremental, created at the given time. The first dump of an archive must be
+// full.
+func (a *Archive) Next(full bool, created time.Time) string {
+	var (
+		seq uint64 = 1
+	)
+
+	if len(a.dumps) > 0 {
+		seq = a.dumps[len(a.dumps)-1].Sequence + 1
+	}
+
+	return DumpName(seq, created, full)
+}
+
+// Chain returns the minimal chain of dumps from which to restore the database
+// as of the dump of the given sequence: the nearest full dump at or before it,
+// and every incremental since, in order. It fails if there is no such dump, or
+// if the chain is broken by a missing sequence.
+func (a *Archive) Chain(seq uint64) (chain []ArchiveDump, e error) {
+	defer errorf("could not find chain of dumps", &e)
+
+	var (
+		found bool
+		i     int
+	)
+
+	i, found = slices.BinarySearchFunc(a.dumps, seq,
+		func(dump ArchiveDump, seq uint64) int {
+			return cmp.Compare(dump.Sequence, seq)
+		},
+	)
+	if !found {
+		return nil, fmt.Errorf("no dump of sequence %d", seq)
+	}
+
+	for ; i >= 0; i-- {
+		if a.dumps[i].Sequence != seq {
+			return nil, fmt.Errorf("no dump of sequence %d", seq)
+		}
+
+		chain = append(chain, a.dumps[i])
+
+		if a.dumps[i].Full {
+			slices.Reverse(chain)
+
+			return
+		}
+
+		seq--
+	}
+
+	return nil, fmt.Errorf("no full dump before sequence %d", seq+1)
+}
+
+// SequenceAt returns the sequence of the last dump created at or before the
+// given time, from which to restore the database as of then.
+func (a *Archive) SequenceAt(at time.Time) (seq uint64, e error) {
+	var (
+		i int
+	)
+
+	for i = len(a.dumps) - 1; i >= 0; i-- {
+		if !a.dumps[i].Created.After(at) {
+			return a.dumps[i].Sequence, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no dump created at or before %s",
+		at.UTC().Format(time.RFC3339),
+	)
+}
+
+// A RetentionPolicy determines the dumps of an Archive that Prune retains, of
+// those restorable: a dump is retained if it is among the last KeepLast, or
+// created within KeepWithin before the time of evaluation, and so are the
+// dumps of its chain.
+type RetentionPolicy struct {
+	KeepLast   int
+	KeepWithin time.Duration
+}
+
+// Prune removes the dumps not retained by the policy as of now by calling
+// remove with the name of each, as by [os.Remove] of the file in the directory
+// of the archive, and returns the names of those removed, or, if remove is nil,
+// of those it would remove. Dumps that are not restorable, their chain broken,
+// are never retained, so the archive should not be pruned while a dump is
+// being written to it.
+func (a *Archive) Prune(policy RetentionPolicy, now time.Time,
+	remove func(name string) error,
+) (removed []string, e error) {
+	defer errorf("could not prune archive", &e)
+
+	var (
+		chain    []ArchiveDump
+		cutoff   = now.Add(-policy.KeepWithin)
+		dump     ArchiveDump
+		i        int
+		kept     int
+		retained = make(map[uint64]bool)
+		within   bool
+	)
+
+	for i = len(a.dumps) - 1; i >= 0; i-- {
+		chain, e = a.Chain(a.dumps[i].Sequence)
+		if e != nil {
+			e = nil
+
+			continue
+		}
+
+		within = policy.KeepWithin > 0 && !a.dumps[i].Created.Before(cutoff)
+
+		if kept >= policy.KeepLast && !within {
+			continue
+		}
+
+		kept++
+
+		for _, dump = range chain {
+			retained[dump.Sequence] = true
+		}
+	}
+
+	for i = 0; i < len(a.dumps); i++ {
+		if retained[a.dumps[i].Sequence] {
+			continue
+		}
+
+		if remove != nil {
+			e = remove(a.dumps[i].Name)
+			if e != nil {
+				return
+			}
+		}
+
+		removed = append(removed, a.dumps[i].Name)
+	}
+
+	if remove == nil {
+		return
+	}
+
+	a.dumps = slices.DeleteFunc(a.dumps,
+		func(dump ArchiveDump) bool {
+			return !retained[dump.Sequence]
+		},
+	)
+
+	return
+}
+
+func parseDumpName(name string) (dump ArchiveDump, ok bool) {
+	// Parses a name of the form returned by DumpName.
+
+	var (
+		e     error
+		parts []string
+	)
+
+	if !strings.HasSuffix(name, ".bl") {
+		return
+	}
+
+	parts = strings.Split(strings.TrimSuffix(name, ".bl"), "-")
+	if len(parts) != 3 || len(parts[0]) != 20 {
+		return
+	}
+
+	dump.Name = name
+
+	dump.Sequence, e = strconv.ParseUint(parts[0], 10, 64)
+	if e != nil {
+		return
+	}
+
+	dump.Created, e = time.Parse(dumpTimeLayout, parts[1])
+	if e != nil {
+		return
+	}
+
+	switch parts[2] {
+	case "full":
+		dump.Full = true
+
+	case "incr":
+
+	default:
+		return
+	}
+
+	return dump, true
+}
@@ -0,0 +1,151 @@
+package bottledlightning
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpName(t *testing.T) {
+	var (
+		created = time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+		dump    ArchiveDump
+		name    string
+		ok      bool
+	)
+
+	assert.Equal(t,
+		"00000000000000000007-20260102T020405Z-full.bl",
+		DumpName(7, created, true),
+	)
+
+	dump, ok = parseDumpName(DumpName(8, created, false))
+	assert.True(t, ok)
+	assert.Equal(t,
+		ArchiveDump{
+			Name:     "00000000000000000008-20260102T020405Z-incr.bl",
+			Sequence: 8,
+			Created:  created.UTC(),
+		},
+		dump,
+	)
+
+	for _, name = range []string{
+		"00000000000000000008-20260102T020405Z-incr",
+		"00000000000000000008-20260102T020405Z-part.bl",
+		"8-20260102T020405Z-full.bl",
+		"00000000000000000008-yesterday-full.bl",
+	} {
+		_, ok = parseDumpName(name)
+		assert.False(t, ok, name)
+	}
+
+	return
+}
+
+func TestArchive(t *testing.T) {
+	var (
+		archive *Archive
+		chain   []ArchiveDump
+		e       error
+		epoch   = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		fsys    = fstest.MapFS{
+			"README":     &fstest.MapFile{},
+			"index.json": &fstest.MapFile{},
+		}
+		full    bool
+		removed []string
+		seq     uint64
+	)
+
+	// Sequences 1 to 3 are a full dump and incrementals, 4 an incremental
+	// whose chain is broken by the loss of 3, and 5 and 6 another full dump
+	// and incremental, one every hour.
+
+	archive, e = OpenArchive(fsys)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	for seq = 1; seq <= 6; seq++ {
+		full = seq == 1 || seq == 5
+
+		fsys[archive.Next(full, epoch.Add(time.Duration(seq)*time.Hour))] =
+			&fstest.MapFile{}
+
+		archive, e = OpenArchive(fsys)
+		if !assert.NoError(t, e) {
+			return
+		}
+	}
+
+	assert.Len(t, archive.Dumps(), 6)
+
+	delete(fsys, DumpName(3, epoch.Add(3*time.Hour), false))
+
+	archive, e = OpenArchive(fsys)
+	if !assert.NoError(t, e) {
+		return
+	}
+
+	chain, e = archive.Chain(2)
+	assert.NoError(t, e)
+	assert.Equal(t, archive.Dumps()[:2], chain)
+
+	_, e = archive.Chain(3)
+	assert.ErrorContains(t, e, "no dump of sequence 3")
+
+	_, e = archive.Chain(4)
+	assert.ErrorContains(t, e, "no dump of sequence 3")
+
+	chain, e = archive.Chain(6)
+	assert.NoError(t, e)
+	assert.Equal(t, archive.Dumps()[3:], chain)
+
+	seq, e = archive.SequenceAt(epoch.Add(150 * time.Minute))
+	assert.NoError(t, e)
+	assert.Equal(t, uint64(2), seq)
+
+	_, e = archive.SequenceAt(epoch)
+	assert.ErrorContains(t, e, "no dump created")
+
+	// The last dump is retained by count, and with it the full dump of its
+	// chain; the unrestorable incremental is never retained.
+
+	removed, e = archive.Prune(RetentionPolicy{KeepLast: 1},
+		epoch.Add(7*time.Hour), nil,
+	)
+	assert.NoError(t, e)
+	assert.Equal(t,
+		[]string{
+			DumpName(1, epoch.Add(1*time.Hour), true),
+			DumpName(2, epoch.Add(2*time.Hour), false),
+			DumpName(4, epoch.Add(4*time.Hour), false),
+		},
+		removed,
+	)
+	assert.Len(t, archive.Dumps(), 5)
+
+	// Within the period, sequence 2 is retained too, and its chain.
+
+	removed, e = archive.Prune(
+		RetentionPolicy{KeepLast: 1, KeepWithin: 5*time.Hour + time.Minute},
+		epoch.Add(7*time.Hour),
+		func(name string) error {
+			delete(fsys, name)
+
+			return nil
+		},
+	)
+	assert.NoError(t, e)
+	assert.Equal(t,
+		[]string{DumpName(4, epoch.Add(4*time.Hour), false)},
+		removed,
+	)
+	assert.Len(t, archive.Dumps(), 4)
+	assert.Len(t, fsys, 6)
+
+	return
+}
@@ -0,0 +1,174 @@
+package bottledlightning
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A RetryPolicy configures a RetryWriter. Zero fields take the defaults
+// described.
+type RetryPolicy struct {
+	// Retries is the number of times a write that fails with a retryable
+	// error is retried, and Backoff the delay before the first retry, 100 ms
+	// by default, doubled before each other up to MaxBackoff, if positive.
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable classifies errors as retryable, or Transient does if nil.
+	Retryable func(e error) bool
+}
+
+// Transient returns true if the error is a timeout, such as one caused by a
+// deadline set by WithIODeadline, or a short write, after which a write may
+// succeed if retried.
+func Transient(e error) bool {
+	var (
+		netError net.Error
+	)
+
+	switch {
+	case errors.Is(e, os.ErrDeadlineExceeded), errors.Is(e, io.ErrShortWrite):
+		return true
+
+	case errors.As(e, &netError):
+		return netError.Timeout()
+	}
+
+	return false
+}
+
+// A RetryWriter is an [io.Writer] that retries writes failing with errors
+// that its RetryPolicy classifies as retryable, with exponential backoff, so
+// that a brief disruption during a long dump need not abort it. The bytes of
+// a write are retained until written in full: a retry resumes where a partial
+// write left off, so that every byte reaches the destination once and in
+// order, which keeps the framing of records intact. It is safe for concurrent
+// use by multiple goroutines.
+type RetryWriter struct {
+	writer  io.Writer
+	policy  RetryPolicy
+	mutex   sync.Mutex
+	retried int
+}
+
+// NewRetryWriter returns a new RetryWriter that writes to the [io.Writer].
+// The policy, which may be nil, is copied and completed with defaults.
+func NewRetryWriter(writer io.Writer, policy *RetryPolicy) (w *RetryWriter) {
+	w = &RetryWriter{
+		writer: writer,
+	}
+
+	if policy != nil {
+		w.policy = *policy
+	}
+
+	if w.policy.Backoff <= 0 {
+		w.policy.Backoff = 100 * time.Millisecond
+	}
+
+	if w.policy.Retryable == nil {
+		w.policy.Retryable = Transient
+	}
+
+	return
+}
+
+// Write writes b to the underlying [io.Writer], retrying as the policy
+// allows. It returns the error of the last attempt if the policy gives up.
+func (w *RetryWriter) Write(b []byte) (n int, e error) {
+	var (
+		attempt int
+		backoff = w.policy.Backoff
+		m       int
+	)
+
+	w.mutex.Lock()
+
+	defer w.mutex.Unlock()
+
+	for attempt = 0; ; attempt++ {
+		m, e = w.writer.Write(b[n:])
+
+		n += m
+
+		if e == nil && n < len(b) {
+			e = io.ErrShortWrite
+		}
+
+		if e == nil {
+			return
+		}
+
+		if attempt == w.policy.Retries || !w.policy.Retryable(e) {
+			return n, fmt.Errorf("could not write after %d attempts: %w",
+				attempt+1, e,
+			)
+		}
+
+		time.Sleep(backoff)
+
+		w.retried++
+
+		backoff *= 2
+
+		if w.policy.MaxBackoff > 0 {
+			backoff = min(backoff, w.policy.MaxBackoff)
+		}
+	}
+}
+
+// Flush flushes the underlying [io.Writer], if it supports Flush, so that
+// Encoder.Checkpoint reaches through the RetryWriter.
+func (w *RetryWriter) Flush() (e error) {
+	var (
+		ok     bool
+		writer flusher
+	)
+
+	writer, ok = w.writer.(flusher)
+	if !ok {
+		return
+	}
+
+	w.mutex.Lock()
+
+	defer w.mutex.Unlock()
+
+	return writer.Flush()
+}
+
+// Sync commits the underlying [io.Writer] to stable storage, if it supports
+// Sync, so that the sync policies of an Encoder reach through the
+// RetryWriter.
+func (w *RetryWriter) Sync() (e error) {
+	var (
+		ok     bool
+		writer syncer
+	)
+
+	writer, ok = w.writer.(syncer)
+	if !ok {
+		return
+	}
+
+	w.mutex.Lock()
+
+	defer w.mutex.Unlock()
+
+	return writer.Sync()
+}
+
+// Retried returns the number of retries made so far.
+func (w *RetryWriter) Retried() int {
+	w.mutex.Lock()
+
+	defer w.mutex.Unlock()
+
+	return w.retried
+}
@@ -0,0 +1,105 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryWriter(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		flaky   = &flakyWriter{writer: &buffer, every: 3}
+		i       int
+		key     []byte
+		n       int
+		writer  *RetryWriter
+	)
+
+	writer = NewRetryWriter(flaky, &RetryPolicy{
+		Retries: 1,
+		Backoff: time.Microsecond,
+	})
+
+	encoder = NewEncoder(writer, fnv.New32a(), WithBatchChecksum(4))
+
+	for i = 0; i < 20; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte(fmt.Sprint(i)), bytes.Repeat([]byte("v"), i)),
+		)
+	}
+
+	assert.NoError(t, encoder.Close())
+
+	assert.NotZero(t, writer.Retried())
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	for i = 0; ; i++ {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if !assert.NoError(t, e) {
+			break
+		}
+
+		assert.Equal(t, fmt.Sprint(i), string(key))
+	}
+
+	assert.Equal(t, 20, i)
+
+	n, e = NewRetryWriter(failingWriter{}, &RetryPolicy{Retries: 3}).
+		Write([]byte("val"))
+
+	assert.Zero(t, n)
+	assert.ErrorContains(t, e, "after 1 attempts")
+
+	writer = NewRetryWriter(failingWriter{}, &RetryPolicy{
+		Retries:   2,
+		Backoff:   time.Microsecond,
+		Retryable: func(error) bool { return true },
+	})
+
+	_, e = writer.Write([]byte("val"))
+
+	assert.ErrorContains(t, e, "after 3 attempts")
+	assert.Equal(t, 2, writer.Retried())
+
+	assert.True(t, Transient(fmt.Errorf("wrapped: %w", os.ErrDeadlineExceeded)))
+	assert.False(t, Transient(io.ErrClosedPipe))
+
+	return
+}
+
+type flakyWriter struct {
+	// Writes only the first byte of every so many writes, failing with a
+	// timeout.
+
+	writer io.Writer
+	every  int
+	writes int
+}
+
+func (w *flakyWriter) Write(p []byte) (n int, e error) {
+	w.writes++
+
+	if w.writes%w.every != 0 || len(p) < 2 {
+		return w.writer.Write(p)
+	}
+
+	n, _ = w.writer.Write(p[:1])
+
+	return n, os.ErrDeadlineExceeded
+}
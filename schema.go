@@ -0,0 +1,100 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// A ValueCodec identifies the encoding of the values of a stream.
+type ValueCodec byte
+
+const (
+	// CodecRaw denotes uninterpreted bytes. It is assumed of streams that
+	// declare no schema.
+	CodecRaw ValueCodec = iota
+
+	// CodecJSON denotes JSON documents.
+	CodecJSON
+
+	// CodecProtobuf denotes serialised protocol buffer messages, of the type
+	// named in Schema.Message.
+	CodecProtobuf
+
+	// CodecMsgpack denotes MessagePack objects.
+	CodecMsgpack
+)
+
+func (c ValueCodec) String() string {
+	switch c {
+	case CodecRaw:
+		return "raw"
+
+	case CodecJSON:
+		return "json"
+
+	case CodecProtobuf:
+		return "protobuf"
+
+	case CodecMsgpack:
+		return "msgpack"
+	}
+
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// A Schema is a hint, declared by an Encoder configured with WithSchema, as to
+// how the values of a stream are encoded, so that generic tools can display
+// and convert them intelligently instead of as opaque bytes. It is not
+// enforced: Decoders return values as transmitted regardless.
+type Schema struct {
+	Codec ValueCodec
+
+	// Message is the fully qualified name of the protocol buffer message type
+	// of the values, if Codec is CodecProtobuf.
+	Message string
+}
+
+func (s Schema) marshal() []byte {
+	return append([]byte{byte(s.Codec)}, s.Message...)
+}
+
+func (s *Schema) unmarshal(payload []byte) (e error) {
+	if len(payload) == 0 {
+		return fmt.Errorf("malformed schema control record")
+	}
+
+	s.Codec = ValueCodec(payload[0])
+	s.Message = string(payload[1:])
+
+	return
+}
+
+// Schema returns the schema declared by the stream so far, or the zero Schema,
+// denoting raw values, if none has been declared. A schema precedes the first
+// record to which it applies.
+func (d *Decoder) Schema() Schema {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.schema
+}
+
+func (n *Encoder) writeSchema() (e error) {
+	// Declares the schema of the stream if so configured and if yet to be
+	// declared. The caller must hold n.mutex.
+
+	if n.options.schema == nil || n.schemaSent {
+		return
+	}
+
+	e = n.writeControl(controlSchema,
+		n.options.schema.marshal(),
+	)
+	if e != nil {
+		return
+	}
+
+	n.schemaSent = true
+
+	return
+}
@@ -0,0 +1,43 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		schema  = Schema{
+			Codec:   CodecProtobuf,
+			Message: "example.v1.Account",
+		}
+		val []byte
+	)
+
+	assert.Equal(t, "protobuf", schema.Codec.String())
+	assert.Equal(t, "codec(9)", ValueCodec(9).String())
+
+	NewEncoder(&buffer, fnv.New32a(), WithSchema(schema)).Encode(
+		[]byte("key"), []byte("val"),
+	)
+
+	decoder = NewDecoder(&buffer, fnv.New32a())
+
+	assert.Equal(t, Schema{}, decoder.Schema())
+
+	_, val, e = decoder.Decode()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, []byte("val"), val)
+	assert.Equal(t, schema, decoder.Schema())
+
+	return
+}
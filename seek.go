@@ -0,0 +1,362 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// seekMarker is the payload of a seek marker control record, chosen at
+	// random, by which a Decoder recognises the record when scanning.
+	seekMarker = []byte{
+		0x5f, 0x1b, 0xc6, 0x93, 0x0e, 0xa7, 0x42, 0xd8,
+		0x7c, 0x21, 0xf4, 0x69, 0xb3, 0x0d, 0x8e, 0x55,
+	}
+
+	// seekPattern is the byte sequence that begins at the second byte of
+	// every seek marker control record, the first byte of which depends on
+	// the presence of a checksum.
+	seekPattern = append(
+		[]byte{0, 0, 0, 0, byte(1 + len(seekMarker)), byte(controlSeekMarker)},
+		seekMarker...,
+	)
+)
+
+// SeekToKey positions the Decoder so that the next call to Decode returns the
+// first record whose key sorts at or after key, or [io.EOF] if there is none.
+// Keys sort as declared by the stream: by the custom comparator set by
+// Encoder.SetComparator, if registered by WithComparator, or else by the
+// Compare of the flags set by Encoder.SetDatabaseFlags.
+// The stream must have been produced by an Encoder configured with
+// WithSortedKeys, must begin at offset 0 of an [io.ReadSeeker], and may not be
+// encrypted, signed, deduplicated or checksummed in batches. If the Encoder
+// was also configured with WithSeekMarkers, SeekToKey binary-searches the
+// markers, and reads only a few records between any two of them; otherwise,
+// it scans the stream from the start.
+func (d *Decoder) SeekToKey(key []byte) (e error) {
+	defer errorf("could not seek to key", &e)
+
+	var (
+		compare     func(a, b []byte) int
+		hi, lo, mid int64
+		marker      int64
+		ok          bool
+		seeker      io.ReadSeeker
+		size        int64
+		sought      []byte
+	)
+
+	seeker, ok = d.reader.(io.ReadSeeker)
+	if !ok {
+		return fmt.Errorf("underlying reader does not implement io.Seeker")
+	}
+
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	if d.digest != nil {
+		return fmt.Errorf("cannot seek in a signed stream")
+	}
+
+	size, e = seeker.Seek(0, io.SeekEnd)
+	if e != nil {
+		return
+	}
+
+	_, e = seeker.Seek(0, io.SeekStart)
+	if e != nil {
+		return
+	}
+
+	d.features = 0
+	d.lastKey = nil
+	d.lastKeys = nil
+
+	_, _, _, e = d.next()
+	if errors.Is(e, io.EOF) {
+		return nil
+	}
+
+	if e != nil {
+		return
+	}
+
+	switch {
+	case d.features&featureSortedKeys == 0:
+		return fmt.Errorf("stream not declared sorted by key")
+
+	case d.features&(featureBatchChecksum|featureEncryption|featureDedup) != 0:
+		return fmt.Errorf(
+			"cannot seek in an encrypted, batched or deduplicated stream",
+		)
+	}
+
+	compare = d.options.keyOrder(d.sourceFlags[d.source],
+		d.comparators[d.source],
+	)
+
+	// Find the last seek marker followed by a record whose key sorts before
+	// the sought key. Every marker from offset hi + 1 onwards is known to be
+	// followed by no such record.
+
+	hi = size
+
+	for lo < hi {
+		mid = lo + (hi-lo+1)/2
+
+		marker, ok, e = d.findSeekMarker(seeker, mid, hi)
+		if e != nil {
+			return
+		}
+
+		if ok {
+			_, e = seeker.Seek(marker, io.SeekStart)
+			if e != nil {
+				return
+			}
+
+			sought, _, _, e = d.next()
+
+			switch {
+			case errors.Is(e, io.EOF):
+				ok = false
+
+			case e != nil:
+				return
+
+			default:
+				ok = compare(sought, key) < 0
+			}
+		}
+
+		if ok {
+			lo = marker
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return d.scanToKey(seeker, lo, key, compare)
+}
+
+func (d *Decoder) scanToKey(seeker io.ReadSeeker, offset int64, key []byte,
+	compare func(a, b []byte) int,
+) (
+	e error,
+) {
+	// Receives records from the given offset onwards, and rewinds to the
+	// first whose key sorts at or after key, as by compare, or to the end of
+	// the stream.
+
+	var (
+		sought []byte
+	)
+
+	_, e = seeker.Seek(offset, io.SeekStart)
+	if e != nil {
+		return
+	}
+
+	for {
+		offset, e = seeker.Seek(0, io.SeekCurrent)
+		if e != nil {
+			return
+		}
+
+		sought, _, _, e = d.next()
+		if errors.Is(e, io.EOF) {
+			return nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		if compare(sought, key) >= 0 {
+			break
+		}
+	}
+
+	_, e = seeker.Seek(offset, io.SeekStart)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (d *Decoder) findSeekMarker(seeker io.ReadSeeker, from, to int64) (
+	offset int64, ok bool, e error,
+) {
+	// Returns the offset of the first seek marker that begins within the
+	// range [from, to], if any.
+
+	var (
+		chunk = make([]byte, seekChunkLen+len(seekPattern))
+		i     int
+		n     int
+		start int64
+	)
+
+	for start = from; start <= to; start += seekChunkLen {
+		_, e = seeker.Seek(start, io.SeekStart)
+		if e != nil {
+			return
+		}
+
+		// Each chunk overlaps the next by the length of the pattern, and
+		// includes the byte before its start, so that no marker is missed.
+
+		n, e = io.ReadFull(seeker,
+			chunk[:min(int64(len(chunk)), to-start+int64(len(seekPattern))+1)],
+		)
+
+		switch {
+		case errors.Is(e, io.EOF), errors.Is(e, io.ErrUnexpectedEOF):
+			e = nil
+
+		case e != nil:
+			return
+		}
+
+		for i = 0; i+len(seekPattern) < n; i++ {
+			if start+int64(i) > to {
+				return
+			}
+
+			if chunk[i]&^(1<<(offsetC-8)) != 0 ||
+				!bytes.Equal(chunk[i+1:i+1+len(seekPattern)], seekPattern) {
+				continue
+			}
+
+			return start + int64(i), true, nil
+		}
+
+		if n < seekChunkLen {
+			return
+		}
+	}
+
+	return
+}
+
+func (n *Encoder) markSeek() (e error) {
+	// Transmits a seek marker if one is due before the next record. The
+	// caller must hold n.mutex.
+
+	if n.options.seekMarkerEvery <= 0 {
+		return
+	}
+
+	if n.unmarked == n.options.seekMarkerEvery {
+		e = n.writeControl(controlSeekMarker, seekMarker)
+		if e != nil {
+			return
+		}
+
+		n.unmarked = 0
+	}
+
+	n.unmarked++
+
+	return
+}
+
+func (n *Encoder) orderKey(key []byte) (e error) {
+	// Returns an error if the stream is declared or asserted sorted by key and
+	// the given key does not sort after that of the preceding record, or of
+	// the preceding record of the same source if sorted by source. The
+	// caller must hold n.mutex.
+
+	var (
+		bySource = n.options.sortedBySource &&
+			n.options.features&featureSortedKeys == 0
+		compare = n.options.keyOrder(n.sourceFlags[n.source],
+			n.comparators[n.source],
+		)
+		last = n.lastKey
+	)
+
+	if n.options.features&featureSortedKeys == 0 && !n.options.assertSorted {
+		return
+	}
+
+	if bySource {
+		last = n.lastKeys[n.source]
+	}
+
+	if last != nil && compare(key, last) <= 0 {
+		return outOfOrder(bySource, n.source)
+	}
+
+	last = append([]byte{}, key...)
+
+	if !bySource {
+		n.lastKey = last
+
+		return
+	}
+
+	if n.lastKeys == nil {
+		n.lastKeys = make(map[string][]byte)
+	}
+
+	n.lastKeys[n.source] = last
+
+	return
+}
+
+func (d *Decoder) orderKey(key []byte) (e error) {
+	// Returns an error if the stream is declared or asserted sorted by key and
+	// the given key does not sort after that of the preceding record, or of
+	// the preceding record of the same source if sorted by source. The
+	// caller must hold d.mutex.
+
+	var (
+		bySource = d.options.sortedBySource &&
+			d.features&featureSortedKeys == 0
+		compare = d.options.keyOrder(d.sourceFlags[d.source],
+			d.comparators[d.source],
+		)
+		last = d.lastKey
+	)
+
+	if d.features&featureSortedKeys == 0 && !d.options.assertSorted {
+		return
+	}
+
+	if bySource {
+		last = d.lastKeys[d.source]
+	}
+
+	if last != nil && compare(key, last) <= 0 {
+		return outOfOrder(bySource, d.source)
+	}
+
+	last = append([]byte{}, key...)
+
+	if !bySource {
+		d.lastKey = last
+
+		return
+	}
+
+	if d.lastKeys == nil {
+		d.lastKeys = make(map[string][]byte)
+	}
+
+	d.lastKeys[d.source] = last
+
+	return
+}
+
+func outOfOrder(bySource bool, source string) error {
+	if bySource {
+		return fmt.Errorf("key out of order in source %q", source)
+	}
+
+	return fmt.Errorf("key out of order")
+}
@@ -0,0 +1,316 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoderSeekToKey(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		i       int
+		key     []byte
+		reader  *countingReadSeeker
+		val     []byte
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a(),
+		WithSortedKeys(),
+		WithSeekMarkers(16),
+	)
+
+	for i = 0; i < 10000; i += 2 {
+		assert.NoError(t,
+			encoder.Encode(
+				[]byte(fmt.Sprintf("key%05d", i)),
+				bytes.Repeat([]byte{'v'}, i%100),
+			),
+		)
+	}
+
+	assert.ErrorContains(t,
+		encoder.Encode([]byte("key00000"), nil),
+		"out of order",
+	)
+
+	reader = &countingReadSeeker{
+		ReadSeeker: bytes.NewReader(buffer.Bytes()),
+	}
+
+	decoder = NewDecoder(reader, fnv.New32a())
+
+	for _, i = range []int{0, 1, 2, 5001, 7776, 9998} {
+		reader.n = 0
+
+		assert.NoError(t,
+			decoder.SeekToKey([]byte(fmt.Sprintf("key%05d", i))),
+		)
+
+		assert.Less(t, reader.n, buffer.Len()/8)
+
+		key, val, e = decoder.Decode()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		assert.Equal(t, []byte(fmt.Sprintf("key%05d", i+i%2)), key)
+		assert.Len(t, val, (i+i%2)%100)
+	}
+
+	assert.NoError(t,
+		decoder.SeekToKey([]byte("key99999")),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	assert.NoError(t,
+		decoder.SeekToKey([]byte("a")),
+	)
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("key00000"), key)
+
+	buffer.Reset()
+
+	NewEncoder(&buffer, nil).Encode([]byte("key"), []byte("val"))
+
+	assert.ErrorContains(t,
+		NewDecoder(bytes.NewReader(buffer.Bytes()), nil).SeekToKey(nil),
+		"not declared sorted",
+	)
+
+	assert.ErrorContains(t,
+		NewDecoder(&buffer, nil).SeekToKey(nil),
+		"io.Seeker",
+	)
+
+	return
+}
+
+func TestDecoderSeekToKeyComparator(t *testing.T) {
+	var (
+		buffer     bytes.Buffer
+		descending = func(a, b []byte) int { return bytes.Compare(b, a) }
+		decoder    *Decoder
+		e          error
+		encoder    *Encoder
+		i          int
+		key        []byte
+		reversed   = func(i int) []byte {
+			// Returns the digits of i backwards, which sort as i under
+			// DatabaseReverseKey.
+
+			return []byte{
+				byte('0' + i%10), byte('0' + i/10%10), byte('0' + i/100),
+			}
+		}
+	)
+
+	// Keys sort as by the flags of the database.
+
+	encoder = NewEncoder(&buffer, nil, WithSortedKeys(), WithSeekMarkers(4))
+
+	assert.NoError(t,
+		encoder.SetDatabaseFlags(DatabaseReverseKey),
+	)
+
+	for i = 0; i < 200; i += 2 {
+		assert.NoError(t,
+			encoder.Encode(reversed(i), nil),
+		)
+	}
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil)
+
+	for _, i = range []int{0, 51, 100, 199} {
+		assert.NoError(t,
+			decoder.SeekToKey(reversed(i)),
+		)
+
+		key, _, e = decoder.Decode()
+
+		if i+i%2 < 200 {
+			assert.NoError(t, e)
+			assert.Equal(t, reversed(i+i%2), key, i)
+		} else {
+			assert.ErrorIs(t, e, io.EOF)
+		}
+	}
+
+	// Keys sort as by a registered custom comparator.
+
+	buffer.Reset()
+
+	encoder = NewEncoder(&buffer, nil, WithSortedKeys(), WithSeekMarkers(4),
+		WithComparator("descending", descending),
+	)
+
+	assert.NoError(t,
+		encoder.SetComparator("descending"),
+	)
+
+	for i = 198; i >= 0; i -= 2 {
+		assert.NoError(t,
+			encoder.Encode([]byte(fmt.Sprintf("key%03d", i)), nil),
+		)
+	}
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithComparator("descending", descending),
+	)
+
+	for _, i = range []int{199, 101, 50, 0} {
+		assert.NoError(t,
+			decoder.SeekToKey([]byte(fmt.Sprintf("key%03d", i))),
+		)
+
+		key, _, e = decoder.Decode()
+
+		assert.NoError(t, e)
+		assert.Equal(t, []byte(fmt.Sprintf("key%03d", i-i%2)), key, i)
+	}
+
+	return
+}
+
+type countingReadSeeker struct {
+	io.ReadSeeker
+	n int
+}
+
+func (r *countingReadSeeker) Read(p []byte) (n int, e error) {
+	n, e = r.ReadSeeker.Read(p)
+
+	r.n += n
+
+	return
+}
+
+func TestAssertSorted(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		key     []byte
+	)
+
+	encoder = NewEncoder(&buffer, nil, WithAssertSorted())
+
+	assert.NoError(t,
+		encoder.Encode(nil, []byte("val")),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), []byte("val")),
+	)
+
+	assert.ErrorContains(t,
+		encoder.Encode([]byte("a"), []byte("val")),
+		"out of order",
+	)
+
+	assert.ErrorContains(t,
+		encoder.Encode([]byte("b"), []byte("val")),
+		"out of order",
+	)
+
+	NewEncoder(&buffer, nil).Encode([]byte("a"), []byte("val"))
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil)
+
+	for e == nil {
+		_, _, e = decoder.Decode()
+	}
+
+	assert.ErrorIs(t, e, io.EOF) // unsorted input accepted
+
+	decoder = NewDecoder(&buffer, nil, WithAssertSorted())
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Empty(t, key)
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("b"), key)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "out of order")
+
+	return
+}
+
+func TestSortedBySource(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		key     []byte
+		keys    []string
+		record  string
+	)
+
+	encoder = NewEncoder(&buffer, nil, WithSortedBySource())
+
+	for _, record = range []string{"a/b", "b/a", "a/c", "b/b"} {
+		assert.NoError(t, encoder.SetSource(record[:1]))
+
+		assert.NoError(t,
+			encoder.Encode([]byte(record[2:]), []byte("val")),
+		)
+	}
+
+	assert.NoError(t, encoder.SetSource("a"))
+
+	assert.ErrorContains(t,
+		encoder.Encode([]byte("a"), []byte("val")),
+		`key out of order in source "a"`,
+	)
+
+	assert.NoError(t, encoder.Close())
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithSortedBySource(),
+	)
+
+	for {
+		key, _, e = decoder.Decode()
+		if e != nil {
+			break
+		}
+
+		keys = append(keys, decoder.Source()+"/"+string(key))
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.Equal(t, []string{"a/b", "b/a", "a/c", "b/b"}, keys)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithAssertSorted(),
+	)
+
+	for e = nil; e == nil; {
+		_, _, e = decoder.Decode()
+	}
+
+	assert.ErrorContains(t, e, "key out of order")
+
+	return
+}
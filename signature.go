@@ -0,0 +1,60 @@
+package bottledlightning
+
+import (
+	"crypto/ed25519"
+	"fmt"
+)
+
+func (n *Encoder) writeSignature() (e error) {
+	// Transmits a signature of the digest of every byte transmitted so far.
+	// The caller must hold n.mutex.
+
+	e = n.writeControl(controlSignature,
+		ed25519.Sign(n.options.signingKey,
+			n.digest.Sum([]byte{}),
+		),
+	)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (d *Decoder) digestRecord(control bool, key, val []byte) (e error) {
+	// Adds the bytes of a record to the digest of the stream, if the Decoder
+	// verifies signatures, after verifying the signature that the record
+	// carries, if any.
+
+	var (
+		b []byte
+	)
+
+	if d.digest == nil {
+		return
+	}
+
+	switch {
+	case !control:
+		d.unsigned++
+
+	case len(val) > 0 && controlKind(val[0]) == controlSignature:
+		if !ed25519.Verify(d.options.verifyingKey,
+			d.digest.Sum([]byte{}),
+			val[1:],
+		) {
+			return fmt.Errorf("invalid stream signature")
+		}
+
+		d.unsigned = 0
+	}
+
+	for _, b = range [][]byte{d.head, key, val, d.tail} {
+		_, e = d.digest.Write(b)
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
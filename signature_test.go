@@ -0,0 +1,115 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decode  func([]byte, ed25519.PublicKey) error
+		e       error
+		encoded []byte
+		encoder *Encoder
+		public  ed25519.PublicKey
+		private ed25519.PrivateKey
+		other   ed25519.PublicKey
+	)
+
+	public, private, e = ed25519.GenerateKey(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	other, _, e = ed25519.GenerateKey(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	decode = func(encoded []byte, key ed25519.PublicKey) (e error) {
+		// Decodes every record of the encoded stream, returning the first
+		// error other than io.EOF.
+
+		var (
+			decoder = NewDecoder(bytes.NewReader(encoded), nil,
+				WithVerifyingKey(key),
+			)
+		)
+
+		for e == nil {
+			_, _, e = decoder.Decode()
+		}
+
+		if errors.Is(e, io.EOF) {
+			return nil
+		}
+
+		return
+	}
+
+	encoder = NewEncoder(&buffer, fnv.New32a(),
+		WithSigningKey(private),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("yek"),
+			bytes.NewReader([]byte("lav")), 3,
+		),
+	)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	encoded = bytes.Clone(buffer.Bytes())
+
+	assert.NoError(t,
+		decode(encoded, public),
+	)
+
+	assert.ErrorContains(t,
+		decode(encoded, other),
+		"invalid stream signature",
+	)
+
+	assert.ErrorIs(t,
+		decode(encoded[:26], public), // the two records, unsigned
+		io.ErrUnexpectedEOF,
+	)
+
+	buffer.Reset()
+
+	encoder = NewEncoder(&buffer, nil,
+		WithSigningKey(private),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	encoded = bytes.Clone(buffer.Bytes())
+
+	encoded[3] ^= 1 // key of the record, which has no checksum
+
+	assert.ErrorContains(t,
+		decode(encoded, public),
+		"invalid stream signature",
+	)
+
+	return
+}
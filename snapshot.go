@@ -0,0 +1,113 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// A snapshot ID is the root of a Merkle tree, as in RFC 6962, whose leaves
+// are the records of a stream in order, so that streams holding the same
+// records in the same order share an ID however they are encoded. Each leaf
+// covers the length and bytes of the key, the extended metadata value, and
+// the length and bytes of the value as the application sees it: after any
+// redaction by the Encoder, but before any other transformation.
+
+func newSnapshotLeaf(key []byte, xmv XMetaValue, size int64) (
+	leaf hash.Hash,
+) {
+	// Returns a hash to which the value of a record, of the given size, is
+	// to be written to yield the leaf of the record.
+
+	leaf = sha256.New()
+
+	leaf.Write([]byte{0})
+	leaf.Write(
+		binary.BigEndian.AppendUint32(nil, uint32(len(key))),
+	)
+	leaf.Write(key)
+	leaf.Write([]byte{byte(xmv)})
+	leaf.Write(
+		binary.BigEndian.AppendUint64(nil, uint64(size)),
+	)
+
+	return
+}
+
+func snapshotLeaf(key, val []byte, xmv XMetaValue) []byte {
+	// Returns the leaf of a record.
+
+	var (
+		leaf = newSnapshotLeaf(key, xmv,
+			int64(len(val)),
+		)
+	)
+
+	leaf.Write(val)
+
+	return leaf.Sum(nil)
+}
+
+// SnapshotID returns the snapshot ID of the records transmitted, once Close
+// has transmitted it, if the Encoder is configured with WithSnapshotID, and
+// nil otherwise.
+func (n *Encoder) SnapshotID() []byte {
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	return n.snapshotID
+}
+
+func (n *Encoder) writeSnapshotID() (e error) {
+	// Transmits the snapshot ID of every record transmitted so far. The
+	// caller must hold n.mutex.
+
+	var (
+		root = n.snapshot.root()
+	)
+
+	e = n.writeControl(controlSnapshotID, root)
+	if e != nil {
+		return
+	}
+
+	n.snapshotID = root
+
+	return
+}
+
+// SnapshotID returns the snapshot ID that the stream carries, once it has
+// been received and verified against the records received, if the Decoder is
+// configured with WithSnapshotID, and nil otherwise. IDs of streams of which
+// records were skipped, such as under WithMetaFilter, cannot be verified, and
+// are not returned.
+func (d *Decoder) SnapshotID() []byte {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.snapshotID
+}
+
+func (d *Decoder) receiveSnapshotID(id []byte) (e error) {
+	// Verifies a snapshot ID against the records received so far.
+
+	if len(id) != sha256.Size {
+		return fmt.Errorf("malformed snapshot ID control record")
+	}
+
+	if d.snapshot == nil || d.snapshotPartial {
+		return
+	}
+
+	if !bytes.Equal(id, d.snapshot.root()) {
+		return fmt.Errorf("snapshot ID does not match records")
+	}
+
+	d.snapshotID = bytes.Clone(id)
+
+	return
+}
@@ -0,0 +1,157 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotID(t *testing.T) {
+	var (
+		decode func([]byte, ...Option) ([]byte, error)
+		e      error
+		encode func(bool, ...Option) []byte
+		id     []byte
+		ids    [][]byte
+		opts   []Option
+		stream ManifestStream
+		val    = bytes.Repeat([]byte("val"), 100)
+	)
+
+	encode = func(reversed bool, opts ...Option) []byte {
+		// Encodes the same records, in reverse order if so asked, returning
+		// the snapshot ID reported by the Encoder.
+
+		var (
+			buffer  bytes.Buffer
+			encoder = NewEncoder(&buffer, fnv.New32a(),
+				append(opts, WithSnapshotID())...,
+			)
+			i    int
+			keys = []string{"a", "b", "c"}
+		)
+
+		for i = range keys {
+			if reversed {
+				i = len(keys) - 1 - i
+			}
+
+			assert.NoError(t,
+				encoder.EncodeX([]byte(keys[i]), val, XMetaValue1),
+			)
+		}
+
+		assert.NoError(t,
+			encoder.EncodeFrom([]byte("d"), bytes.NewReader(val),
+				int64(len(val)),
+			),
+		)
+
+		assert.NoError(t, encoder.Close())
+
+		id, e = decode(buffer.Bytes(), opts...)
+
+		assert.NoError(t, e)
+		assert.Equal(t, encoder.SnapshotID(), id)
+
+		stream, e = DescribeStream("stream", "", bytes.NewReader(buffer.Bytes()),
+			fnv.New32a(),
+		)
+
+		assert.NoError(t, e)
+		assert.Equal(t, hex.EncodeToString(id), stream.SnapshotID)
+
+		return id
+	}
+
+	decode = func(encoded []byte, opts ...Option) (id []byte, e error) {
+		// Decodes every record of the encoded stream, returning the verified
+		// snapshot ID and the first error other than io.EOF.
+
+		var (
+			decoder = NewDecoder(bytes.NewReader(encoded), fnv.New32a(),
+				append(opts, WithSnapshotID())...,
+			)
+		)
+
+		for e == nil {
+			_, _, e = decoder.Decode()
+		}
+
+		if errors.Is(e, io.EOF) {
+			e = nil
+		}
+
+		return decoder.SnapshotID(), e
+	}
+
+	for _, opts = range [][]Option{
+		nil,
+		{WithBatchChecksum(2)},
+		{WithDeduplication(1 << 10)},
+		{WithCompression(
+			func(_, _ []byte) Compression { return CompressionDeflate },
+		)},
+	} {
+		ids = append(ids,
+			encode(false, opts...),
+		)
+	}
+
+	for _, id = range ids {
+		assert.Len(t, id, sha256.Size)
+		assert.Equal(t, ids[0], id)
+	}
+
+	assert.NotEqual(t, ids[0],
+		encode(true),
+	)
+
+	return
+}
+
+func TestSnapshotIDMismatch(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil, WithSnapshotID())
+	)
+
+	encoder.Encode([]byte("key"), []byte("val"))
+	encoder.Close()
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithSnapshotID(),
+		WithTransform(nil,
+			func(_, val []byte) ([]byte, error) { return val[1:], nil },
+		),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "snapshot ID does not match")
+	assert.Nil(t, decoder.SnapshotID())
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil,
+		WithSnapshotID(),
+		WithMetaFilter(XMetaValue1),
+	)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.Nil(t, decoder.SnapshotID())
+
+	return
+}
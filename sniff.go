@@ -0,0 +1,85 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"math"
+)
+
+var (
+	// compressedMagic holds the leading bytes of common formats that are
+	// compressed already, and so not worth compressing again.
+	compressedMagic = [][]byte{
+		{0xff, 0xd8, 0xff},            // JPEG
+		{0x89, 'P', 'N', 'G'},         // PNG
+		{'G', 'I', 'F', '8'},          // GIF
+		{0x1f, 0x8b},                  // gzip
+		{0x28, 0xb5, 0x2f, 0xfd},      // Zstandard
+		{'P', 'K', 0x03, 0x04},        // ZIP
+		{'B', 'Z', 'h'},               // bzip2
+		{0xfd, '7', 'z', 'X', 'Z', 0}, // xz
+	}
+)
+
+// SniffCompression returns a function, for use with WithCompression, that
+// chooses the given codec for every value unless it is too short to be worth
+// compressing, or bears the signature of a compressed format such as JPEG or
+// gzip, or its bytes, sampled, look random, as do those of encrypted data.
+// Otherwise it chooses CompressionNone.
+func SniffCompression(codec Compression) func(key, val []byte) Compression {
+	return func(key, val []byte) Compression {
+		if len(val) < compressionMinValLen || isIncompressible(val) {
+			return CompressionNone
+		}
+
+		return codec
+	}
+}
+
+func isIncompressible(val []byte) bool {
+	// Returns true if val bears the signature of a compressed format, or if
+	// the Shannon entropy of a sample of its bytes approaches 8 bits per byte.
+
+	var (
+		counts  [256]int
+		entropy float64
+		i, j    int
+		magic   []byte
+		p       float64
+		sample  int
+		stride  int
+	)
+
+	for _, magic = range compressedMagic {
+		if bytes.HasPrefix(val, magic) {
+			return true
+		}
+	}
+
+	// Sample evenly spaced runs of bytes throughout the value, rather than
+	// only its head, which may be a header unrepresentative of the rest.
+
+	stride = max(len(val)/(entropySampleLen/entropyRunLen), entropyRunLen)
+
+	for i = 0; i < len(val); i += stride {
+		for j = i; j < min(i+entropyRunLen, len(val)); j++ {
+			counts[val[j]]++
+
+			sample++
+		}
+	}
+
+	for i = range counts {
+		if counts[i] == 0 {
+			continue
+		}
+
+		p = float64(counts[i]) / float64(sample)
+
+		entropy -= p * math.Log2(p)
+	}
+
+	// A sample of n bytes can exhibit at most log2(n) bits of entropy per
+	// byte, so the threshold is relative to that bound for short values.
+
+	return entropy > entropyThreshold*min(8, math.Log2(float64(sample)))/8
+}
@@ -0,0 +1,56 @@
+package bottledlightning
+
+// SetSource tags the records that follow with the identifier of their source,
+// such as the name of the LMDB database that holds them, for the Decoder to
+// report by Source, as does Collect for the records of each Source.
+func (n *Encoder) SetSource(id string) (e error) {
+	defer errorf("could not set source", &e)
+
+	e = n.tagSource(id)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) tagSource(id string) (e error) {
+	// Transmits a control record tagging the records that follow with the
+	// identifier of their source, unless they are so tagged already.
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if id == n.source {
+		return
+	}
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	e = n.writeControl(controlSource, []byte(id))
+	if e != nil {
+		return
+	}
+
+	n.source = id
+
+	return
+}
+
+// Source returns the identifier of the source of the records most recently
+// received, as tagged by Collect or Encoder.SetSource, or the empty string if
+// none is tagged. If the source is mapped by WithSourceMap, Source returns the
+// name of its target instead.
+func (d *Decoder) Source() (id string) {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	id, _ = d.target()
+
+	return
+}
@@ -0,0 +1,52 @@
+package bottledlightning
+
+// A SourceTarget is the database into which a Decoder configured by
+// WithSourceMap restores the records of a source, such as an LMDB database of
+// the original environment.
+type SourceTarget struct {
+	// Name is the name of the target database, reported by Decoder.Source in
+	// place of that of the source.
+	Name string
+
+	// KeyPrefix, if not empty, is prepended to the key of every record of the
+	// source, so that several sources can be merged into one target without
+	// their keys colliding.
+	KeyPrefix []byte
+}
+
+func (d *Decoder) target() (name string, prefix []byte) {
+	// Returns the name of the database into which the records of the current
+	// source are restored, and the prefix of their keys. The caller must hold
+	// d.mutex.
+
+	var (
+		ok     bool
+		target SourceTarget
+	)
+
+	target, ok = d.options.sourceMap[d.source]
+	if !ok {
+		return d.source, nil
+	}
+
+	return target.Name, target.KeyPrefix
+}
+
+func (d *Decoder) mapKey(key []byte) []byte {
+	// Returns the key of a record of the current source as restored into its
+	// target, prefixed if so configured. The caller must hold d.mutex.
+
+	var (
+		prefix []byte
+	)
+
+	_, prefix = d.target()
+
+	if len(prefix) == 0 {
+		return key
+	}
+
+	return append(append(make([]byte, 0, len(prefix)+len(key)), prefix...),
+		key...,
+	)
+}
@@ -0,0 +1,71 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceMap(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		key     []byte
+		mapping map[string]SourceTarget
+		records []string
+		record  string
+	)
+
+	encoder = NewEncoder(&buffer, nil, WithSortedBySource())
+
+	for _, record = range []string{"u/b", "g/a", "s/a", "u/c", "g/b"} {
+		assert.NoError(t, encoder.SetSource(record[:1]))
+
+		assert.NoError(t,
+			encoder.Encode([]byte(record[2:]), []byte("val")),
+		)
+	}
+
+	assert.NoError(t, encoder.Close())
+
+	mapping = map[string]SourceTarget{
+		"u": {Name: "accounts", KeyPrefix: []byte("users:")},
+		"g": {Name: "accounts", KeyPrefix: []byte("groups:")},
+		"s": {Name: "settings"},
+	}
+
+	decoder = NewDecoder(&buffer, nil,
+		WithSourceMap(mapping),
+		WithSortedBySource(),
+	)
+
+	delete(mapping, "s") // copied by WithSourceMap
+
+	for {
+		key, _, e = decoder.Decode()
+		if e != nil {
+			break
+		}
+
+		records = append(records, decoder.Source()+"/"+string(key))
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	assert.Equal(t,
+		[]string{
+			"accounts/users:b",
+			"accounts/groups:a",
+			"settings/a",
+			"accounts/users:c",
+			"accounts/groups:b",
+		},
+		records,
+	)
+
+	return
+}
@@ -0,0 +1,128 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+)
+
+// A KeyExtraction restores only the records of the given keys from a full
+// dump, such as to repair a few corrupted records surgically, passing each to
+// Apply as it is found. Should a key appear in several records, only the
+// first is applied.
+type KeyExtraction struct {
+	Keys  [][]byte
+	Apply func(key, val []byte, xmv XMetaValue) error
+
+	// Seek, if true, causes each key to be located by Decoder.SeekToKey,
+	// reading only a few records around it if the stream carries seek
+	// markers, rather than by a scan of the whole stream. The stream must
+	// then satisfy the requirements of SeekToKey.
+	Seek bool
+}
+
+// Run receives the records of the keys from the Decoder and applies them, and
+// returns the keys that the stream lacks. A scan stops as soon as every key
+// has been found.
+func (x KeyExtraction) Run(decoder *Decoder) (missing [][]byte, e error) {
+	defer errorf("could not extract keys", &e)
+
+	if x.Seek {
+		return x.seek(decoder)
+	}
+
+	return x.scan(decoder)
+}
+
+func (x KeyExtraction) scan(decoder *Decoder) (missing [][]byte, e error) {
+	// Receives records until every key has been found or the stream ends.
+
+	var (
+		key    []byte
+		ok     bool
+		val    []byte
+		wanted = make(map[string]bool, len(x.Keys))
+		xmv    byte
+	)
+
+	for _, key = range x.Keys {
+		wanted[string(key)] = true
+	}
+
+	for len(wanted) > 0 {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			e = nil
+
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		_, ok = wanted[string(key)]
+		if !ok {
+			continue
+		}
+
+		delete(wanted, string(key))
+
+		e = x.Apply(key, val, XMetaValue(xmv))
+		if e != nil {
+			return
+		}
+	}
+
+	for _, key = range x.Keys {
+		if wanted[string(key)] {
+			delete(wanted, string(key))
+
+			missing = append(missing, key)
+		}
+	}
+
+	return
+}
+
+func (x KeyExtraction) seek(decoder *Decoder) (missing [][]byte, e error) {
+	// Seeks to each distinct key in order, and receives the record there.
+
+	var (
+		found []byte
+		key   []byte
+		keys  = slices.Clone(x.Keys)
+		val   []byte
+		xmv   byte
+	)
+
+	slices.SortFunc(keys, bytes.Compare)
+
+	keys = slices.CompactFunc(keys, bytes.Equal)
+
+	for _, key = range keys {
+		e = decoder.SeekToKey(key)
+		if e != nil {
+			return
+		}
+
+		found, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) || e == nil && !bytes.Equal(found, key) {
+			missing = append(missing, key)
+
+			continue
+		}
+
+		if e != nil {
+			return
+		}
+
+		e = x.Apply(key, val, XMetaValue(xmv))
+		if e != nil {
+			return
+		}
+	}
+
+	return missing, nil
+}
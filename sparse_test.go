@@ -0,0 +1,64 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyExtraction(t *testing.T) {
+	var (
+		applied []string
+		buffer  bytes.Buffer
+		e       error
+		i       int
+		missing [][]byte
+		seek    bool
+
+		encoder = NewEncoder(&buffer, fnv.New32a(),
+			WithSortedKeys(), WithSeekMarkers(16),
+		)
+	)
+
+	for i = 0; i < 1000; i += 2 {
+		assert.NoError(t,
+			encoder.Encode([]byte(fmt.Sprintf("key%04d", i)),
+				[]byte(fmt.Sprint(i)),
+			),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	for _, seek = range []bool{false, true} {
+		applied = nil
+
+		missing, e = KeyExtraction{
+			Keys: [][]byte{
+				[]byte("key0500"), []byte("key0001"), []byte("key0998"),
+				[]byte("key0002"),
+			},
+			Apply: func(key, val []byte, xmv XMetaValue) error {
+				applied = append(applied, fmt.Sprintf("%s=%s", key, val))
+
+				return nil
+			},
+			Seek: seek,
+		}.Run(
+			NewDecoder(bytes.NewReader(buffer.Bytes()), fnv.New32a()),
+		)
+		assert.NoError(t, e)
+		assert.Equal(t, [][]byte{[]byte("key0001")}, missing)
+		assert.ElementsMatch(t,
+			[]string{"key0002=2", "key0500=500", "key0998=998"},
+			applied,
+		)
+	}
+
+	return
+}
@@ -0,0 +1,148 @@
+// Package sqlite exports streams of records to tables of SQLite databases, and
+// imports them back, for ad hoc queries over dumps.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	bl "github.com/encodingx/bottled-lightning"
+	"github.com/encodingx/bottled-lightning/internal/wrap"
+)
+
+// Export receives the records of the Decoder and inserts them into the
+// named table of an SQLite database, creating it if necessary as
+//
+//	CREATE TABLE table (key BLOB PRIMARY KEY, value BLOB, meta INT)
+//
+// where meta holds the XMetaValue of the record, giving an ad hoc, queryable
+// representation of a dump for forensics. A record replaces any row of the
+// same key. The records are inserted in one transaction, committed once the
+// stream ends, and their number is returned. The database may be opened with
+// any SQLite driver for [database/sql].
+func Export(ctx context.Context, db *sql.DB, table string,
+	decoder *bl.Decoder,
+) (n int64, e error) {
+	defer wrap.Errorf("could not export to sqlite", &e)
+
+	var (
+		insert *sql.Stmt
+		key    []byte
+		name   = quoteSQLIdentifier(table)
+		tx     *sql.Tx
+		val    []byte
+		xmv    byte
+	)
+
+	tx, e = db.BeginTx(ctx, nil)
+	if e != nil {
+		return
+	}
+
+	defer func() {
+		if e != nil {
+			tx.Rollback()
+		}
+	}()
+
+	_, e = tx.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS "+name+
+			" (key BLOB PRIMARY KEY, value BLOB, meta INT)",
+	)
+	if e != nil {
+		return
+	}
+
+	insert, e = tx.PrepareContext(ctx,
+		"INSERT OR REPLACE INTO "+name+" (key, value, meta) VALUES (?, ?, ?)",
+	)
+	if e != nil {
+		return
+	}
+
+	defer insert.Close()
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		if e != nil {
+			return
+		}
+
+		_, e = insert.ExecContext(ctx, key, val, int64(xmv))
+		if e != nil {
+			return
+		}
+
+		n++
+	}
+
+	e = tx.Commit()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// Import encodes the rows of the named table of an SQLite database, as
+// created by Export, on the Encoder, in the order of their keys, and
+// returns their number. It does not close the Encoder.
+func Import(ctx context.Context, db *sql.DB, table string,
+	encoder *bl.Encoder,
+) (n int64, e error) {
+	defer wrap.Errorf("could not import from sqlite", &e)
+
+	var (
+		key  []byte
+		meta int64
+		rows *sql.Rows
+		val  []byte
+	)
+
+	rows, e = db.QueryContext(ctx,
+		"SELECT key, value, meta FROM "+quoteSQLIdentifier(table)+
+			" ORDER BY key",
+	)
+	if e != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		e = rows.Scan(&key, &val, &meta)
+		if e != nil {
+			return
+		}
+
+		if meta < 0 || meta > int64(bl.XMetaValueF) {
+			return n, fmt.Errorf("meta %d of key %x out of range", meta, key)
+		}
+
+		e = encoder.EncodeX(key, val, bl.XMetaValue(meta))
+		if e != nil {
+			return
+		}
+
+		n++
+	}
+
+	e = rows.Err()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func quoteSQLIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
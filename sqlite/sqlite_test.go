@@ -0,0 +1,209 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	bl "github.com/encodingx/bottled-lightning"
+)
+
+// A fakeSQLite is a database/sql driver that understands just the statements
+// of Export and Import, keeping the rows of one table in memory.
+type fakeSQLite struct {
+	mutex   sync.Mutex
+	queries []string
+	rows    map[string][]driver.Value
+}
+
+func (f *fakeSQLite) Connect(context.Context) (driver.Conn, error) {
+	return fakeSQLiteConn{f}, nil
+}
+
+func (f *fakeSQLite) Driver() driver.Driver {
+	return nil
+}
+
+type fakeSQLiteConn struct {
+	db *fakeSQLite
+}
+
+func (c fakeSQLiteConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.mutex.Lock()
+
+	defer c.db.mutex.Unlock()
+
+	c.db.queries = append(c.db.queries, query)
+
+	return fakeSQLiteStmt{c.db, query}, nil
+}
+
+func (c fakeSQLiteConn) Close() error {
+	return nil
+}
+
+func (c fakeSQLiteConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c fakeSQLiteConn) Commit() error {
+	return nil
+}
+
+func (c fakeSQLiteConn) Rollback() error {
+	return nil
+}
+
+type fakeSQLiteStmt struct {
+	db    *fakeSQLite
+	query string
+}
+
+func (s fakeSQLiteStmt) Close() error {
+	return nil
+}
+
+func (s fakeSQLiteStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeSQLiteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mutex.Lock()
+
+	defer s.db.mutex.Unlock()
+
+	if strings.HasPrefix(s.query, "INSERT") {
+		s.db.rows[string(args[0].([]byte))] = slices.Clone(args)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeSQLiteStmt) Query([]driver.Value) (driver.Rows, error) {
+	var (
+		key  string
+		keys []string
+		rows = new(fakeSQLiteRows)
+	)
+
+	s.db.mutex.Lock()
+
+	defer s.db.mutex.Unlock()
+
+	for key = range s.db.rows {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key = range keys {
+		rows.rows = append(rows.rows, s.db.rows[key])
+	}
+
+	return rows, nil
+}
+
+type fakeSQLiteRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeSQLiteRows) Columns() []string {
+	return []string{"key", "value", "meta"}
+}
+
+func (r *fakeSQLiteRows) Close() error {
+	return nil
+}
+
+func (r *fakeSQLiteRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func TestSQLite(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		ctx     = context.Background()
+		db      *sql.DB
+		decoder *bl.Decoder
+		e       error
+		encoder = bl.NewEncoder(&buffer, nil)
+		fake    = &fakeSQLite{rows: make(map[string][]driver.Value)}
+		i       int
+		key     []byte
+		n       int64
+		val     []byte
+		xmv     byte
+	)
+
+	db = sql.OpenDB(fake)
+
+	defer db.Close()
+
+	for _, i = range []int{2, 0, 1} {
+		assert.NoError(t,
+			encoder.EncodeX([]byte(fmt.Sprint("key", i)),
+				[]byte(fmt.Sprint(i)), bl.XMetaValue(i),
+			),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	n, e = Export(ctx, db, `dump "1"`, bl.NewDecoder(&buffer, nil))
+	assert.NoError(t, e)
+	assert.EqualValues(t, 3, n)
+	assert.Equal(t,
+		[]string{
+			`CREATE TABLE IF NOT EXISTS "dump ""1""" ` +
+				`(key BLOB PRIMARY KEY, value BLOB, meta INT)`,
+			`INSERT OR REPLACE INTO "dump ""1""" (key, value, meta) ` +
+				`VALUES (?, ?, ?)`,
+		},
+		fake.queries,
+	)
+
+	buffer.Reset()
+
+	encoder = bl.NewEncoder(&buffer, nil)
+
+	n, e = Import(ctx, db, `dump "1"`, encoder)
+	assert.NoError(t, e)
+	assert.EqualValues(t, 3, n)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	decoder = bl.NewDecoder(&buffer, nil)
+
+	for i = 0; i < 3; i++ {
+		key, val, xmv, e = decoder.DecodeX()
+		assert.NoError(t, e)
+		assert.Equal(t, fmt.Sprint("key", i), string(key))
+		assert.Equal(t, fmt.Sprint(i), string(val))
+		assert.EqualValues(t, i, xmv)
+	}
+
+	_, _, _, e = decoder.DecodeX()
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
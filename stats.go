@@ -0,0 +1,80 @@
+package bottledlightning
+
+import (
+	"errors"
+	"io"
+)
+
+// RecordStats counts the records transmitted by an Encoder or returned by a
+// Decoder, the bytes of their keys and values, as given to the Encoder or
+// returned by the Decoder, and the records that could not be encoded or
+// decoded. The end of a stream is not counted as an error.
+type RecordStats struct {
+	Records int64
+	Bytes   int64
+	Errors  int64
+}
+
+// RecordStats returns counts of the records transmitted so far, and of errors.
+func (n *Encoder) RecordStats() RecordStats {
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	return n.recordStats
+}
+
+// RecordStats returns counts of the records returned so far, and of errors.
+func (d *Decoder) RecordStats() RecordStats {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return d.recordStats
+}
+
+func (n *Encoder) countRecord(k int, v int64, e *error) {
+	// Counts a record of key size k and value size v as transmitted, or as an
+	// error if *e is not nil. The caller must not hold n.mutex.
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if *e != nil {
+		n.recordStats.Errors++
+
+		return
+	}
+
+	n.recordStats.Records++
+	n.recordStats.Bytes += int64(k) + v
+
+	n.sizes.add(k, v)
+
+	return
+}
+
+func (d *Decoder) countRecord(key *[]byte, val *ValueHandle, e *error) {
+	// Counts a record as returned, or as an error if *e is neither nil nor
+	// the end of the stream. The caller must hold d.mutex.
+
+	switch {
+	case errors.Is(*e, io.EOF):
+		return
+
+	case *e != nil:
+		d.recordStats.Errors++
+
+		return
+	}
+
+	d.recordStats.Records++
+	d.recordStats.Bytes += int64(len(*key)) + val.Len()
+
+	d.sizes.add(len(*key),
+		val.Len(),
+	)
+
+	return
+}
@@ -0,0 +1,63 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordStats(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+	)
+
+	encoder = NewEncoder(&buffer, nil)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("value")),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("key"), bytes.NewReader([]byte("val")), 3),
+	)
+
+	assert.Error(t,
+		encoder.Encode(make([]byte, 512), nil),
+	)
+
+	assert.Equal(t,
+		RecordStats{Records: 2, Bytes: 14, Errors: 1},
+		encoder.RecordStats(),
+	)
+
+	buffer.WriteByte(0)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil)
+
+	for e == nil {
+		_, _, e = decoder.Decode()
+	}
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	assert.Equal(t,
+		RecordStats{Records: 2, Bytes: 14, Errors: 1},
+		decoder.RecordStats(),
+	)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()[:buffer.Len()-1]), nil)
+
+	for e = nil; e == nil; {
+		_, _, e = decoder.Decode()
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.Zero(t, decoder.RecordStats().Errors)
+
+	return
+}
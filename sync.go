@@ -0,0 +1,76 @@
+package bottledlightning
+
+import (
+	"time"
+)
+
+type syncer interface {
+	Sync() error
+}
+
+// Sync commits all records transmitted so far to stable storage, provided that
+// the underlying [io.Writer] implements Sync, as does an [os.File]. It has no
+// effect otherwise.
+func (n *Encoder) Sync() (e error) {
+	defer errorf("could not sync", &e)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	e = n.sync()
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (n *Encoder) sync() (e error) {
+	// Syncs the underlying io.Writer if supported. The caller must hold
+	// n.mutex.
+
+	var (
+		ok     bool
+		writer syncer
+	)
+
+	writer, ok = n.dest.(syncer)
+	if !ok {
+		return
+	}
+
+	e = writer.Sync()
+	if e != nil {
+		return
+	}
+
+	n.lastSync = time.Now()
+	n.unsynced = 0
+
+	return
+}
+
+func (n *Encoder) syncByPolicy() (e error) {
+	// Syncs the underlying io.Writer after a record if the configured policy
+	// so demands. The caller must hold n.mutex.
+
+	n.unsynced++
+
+	switch {
+	case n.options.syncEvery > 0 && n.unsynced >= n.options.syncEvery:
+
+	case n.options.syncInterval > 0 &&
+		time.Since(n.lastSync) >= n.options.syncInterval:
+
+	default:
+		return
+	}
+
+	e = n.sync()
+	if e != nil {
+		return
+	}
+
+	return
+}
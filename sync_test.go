@@ -0,0 +1,78 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSyncer struct {
+	bytes.Buffer
+
+	syncs int
+}
+
+func (s *testSyncer) Sync() error {
+	s.syncs++
+
+	return nil
+}
+
+func TestSyncEvery(t *testing.T) {
+	var (
+		i      int
+		writer testSyncer
+
+		encoder = NewEncoder(&writer, nil,
+			WithSyncEvery(2),
+		)
+	)
+
+	for i = 0; i < 5; i++ {
+		assert.NoError(t,
+			encoder.Encode([]byte("key"), []byte("val")),
+		)
+	}
+
+	assert.Equal(t, 2, writer.syncs)
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	assert.Equal(t, 3, writer.syncs)
+
+	return
+}
+
+func TestSyncInterval(t *testing.T) {
+	var (
+		writer testSyncer
+
+		encoder = NewEncoder(&writer, nil,
+			WithSyncInterval(time.Millisecond),
+		)
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	time.Sleep(2 * time.Millisecond)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.Equal(t, 1, writer.syncs)
+
+	assert.NoError(t,
+		encoder.Sync(),
+	)
+
+	assert.Equal(t, 2, writer.syncs)
+
+	return
+}
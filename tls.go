@@ -0,0 +1,115 @@
+package bottledlightning
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+// ALPNProtocol is the identifier under which streams of records are negotiated
+// by Application-Layer Protocol Negotiation on connections established by
+// DialTLS and ListenTLS.
+const ALPNProtocol = "bottled-lightning"
+
+// DialTLS connects to the given network address and performs a TLS handshake
+// suitable for the transmission of records by an Encoder or their receipt by a
+// Decoder. The configuration, which may be nil, is copied and completed with
+// the defaults described at TLSConfig.
+func DialTLS(network, address string, config *tls.Config) (
+	conn *tls.Conn, e error,
+) {
+	defer errorf("could not dial TLS", &e)
+
+	conn, e = tls.Dial(network, address,
+		TLSConfig(config),
+	)
+	if e != nil {
+		return
+	}
+
+	if conn.ConnectionState().NegotiatedProtocol != ALPNProtocol {
+		conn.Close()
+
+		return nil, fmt.Errorf("peer does not support protocol %q",
+			ALPNProtocol,
+		)
+	}
+
+	return
+}
+
+// ListenTLS announces on the given network address and returns a
+// [net.Listener] that accepts TLS connections suitable for the transmission
+// of records by an Encoder or their receipt by a Decoder. The configuration
+// must hold at least one certificate; it is copied and completed with the
+// defaults described at TLSConfig. Handshakes in which the client does not
+// negotiate ALPNProtocol fail, as do those by DialTLS with a server that does
+// not, so that records are exchanged only between peers that expect them.
+func ListenTLS(network, address string, config *tls.Config) (
+	listener net.Listener, e error,
+) {
+	defer errorf("could not listen TLS", &e)
+
+	config = TLSConfig(config)
+
+	config.VerifyConnection = verifyProtocol(config.VerifyConnection)
+
+	listener, e = tls.Listen(network, address, config)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func verifyProtocol(verify func(tls.ConnectionState) error) (
+	verifyConnection func(tls.ConnectionState) error,
+) {
+	// Returns a function for tls.Config.VerifyConnection that rejects a
+	// connection on which ALPNProtocol was not negotiated, and then calls
+	// verify, if not nil. It is called at the end of each handshake, with
+	// the state that ConnectionState would then return.
+
+	return func(state tls.ConnectionState) error {
+		if state.NegotiatedProtocol != ALPNProtocol {
+			return fmt.Errorf("peer does not support protocol %q",
+				ALPNProtocol,
+			)
+		}
+
+		if verify != nil {
+			return verify(state)
+		}
+
+		return nil
+	}
+}
+
+// TLSConfig returns a copy of the configuration, which may be nil, completed
+// with the following defaults:
+//
+//   - a minimum protocol version of TLS 1.3,
+//   - ALPNProtocol as the only application protocol, and
+//   - mandatory verification of client certificates if ClientCAs is set and
+//     ClientAuth is not, so that mutual authentication requires no more than
+//     a certificate pool on the listening side and a certificate on the
+//     dialling side.
+func TLSConfig(config *tls.Config) (c *tls.Config) {
+	if config == nil {
+		c = new(tls.Config)
+	} else {
+		c = config.Clone()
+	}
+
+	if c.MinVersion < tls.VersionTLS13 {
+		c.MinVersion = tls.VersionTLS13
+	}
+
+	c.NextProtos = []string{ALPNProtocol}
+
+	if c.ClientCAs != nil && c.ClientAuth == tls.NoClientCert {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return
+}
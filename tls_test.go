@@ -0,0 +1,196 @@
+package bottledlightning
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLS(t *testing.T) {
+	var (
+		certificate tls.Certificate
+		conn        *tls.Conn
+		e           error
+		key         []byte
+		listener    net.Listener
+		pool        *x509.CertPool
+		val         []byte
+	)
+
+	certificate, pool = newTestCertificate(t)
+
+	listener, e = ListenTLS("tcp", "127.0.0.1:0",
+		&tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientCAs:    pool,
+		},
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		var (
+			e      error
+			server net.Conn
+		)
+
+		for {
+			server, e = listener.Accept()
+			if e != nil {
+				return
+			}
+
+			NewEncoder(server, nil).Encode([]byte("key"), []byte("val"))
+
+			server.Close()
+		}
+	}()
+
+	conn, e = DialTLS("tcp",
+		listener.Addr().String(),
+		&tls.Config{RootCAs: pool, ServerName: "localhost"},
+	)
+	if e == nil {
+		_, _, e = NewDecoder(conn, nil).Decode()
+
+		conn.Close()
+	}
+
+	assert.Error(t, e) // client certificate required
+
+	conn, e = tls.Dial("tcp",
+		listener.Addr().String(),
+		&tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			RootCAs:      pool,
+			ServerName:   "localhost",
+			MinVersion:   tls.VersionTLS13,
+		},
+	)
+	if e == nil {
+		_, _, e = NewDecoder(conn, nil).Decode()
+
+		conn.Close()
+	}
+
+	assert.Error(t, e) // no application protocol offered
+
+	conn, e = DialTLS("tcp",
+		listener.Addr().String(),
+		&tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			RootCAs:      pool,
+			ServerName:   "localhost",
+		},
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer conn.Close()
+
+	assert.Equal(t, uint16(tls.VersionTLS13),
+		conn.ConnectionState().Version,
+	)
+
+	key, val, e = NewDecoder(conn, nil).Decode()
+	if e != nil {
+		t.Error(e)
+	}
+
+	assert.Equal(t, []byte("key"), key)
+	assert.Equal(t, []byte("val"), val)
+
+	return
+}
+
+func TestTLSConfig(t *testing.T) {
+	var (
+		config *tls.Config
+		given  = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientCAs:  x509.NewCertPool(),
+		}
+	)
+
+	config = TLSConfig(given)
+
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	assert.Equal(t, []string{ALPNProtocol}, config.NextProtos)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	assert.Equal(t, uint16(tls.VersionTLS12), given.MinVersion)
+
+	config = TLSConfig(nil)
+
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+	return
+}
+
+func newTestCertificate(t *testing.T) (
+	certificate tls.Certificate, pool *x509.CertPool,
+) {
+	// Returns a self-signed certificate for localhost, valid for both server
+	// and client authentication, and a pool containing it.
+
+	var (
+		der      []byte
+		e        error
+		private  *ecdsa.PrivateKey
+		template = &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     []string{"localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage: x509.KeyUsageDigitalSignature |
+				x509.KeyUsageCertSign,
+			ExtKeyUsage: []x509.ExtKeyUsage{
+				x509.ExtKeyUsageServerAuth,
+				x509.ExtKeyUsageClientAuth,
+			},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		parsed *x509.Certificate
+	)
+
+	private, e = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	der, e = x509.CreateCertificate(rand.Reader, template, template,
+		&private.PublicKey, private,
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	parsed, e = x509.ParseCertificate(der)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	pool = x509.NewCertPool()
+
+	pool.AddCert(parsed)
+
+	certificate = tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  private,
+	}
+
+	return
+}
@@ -0,0 +1,120 @@
+package bottledlightning
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// SetTraceContext transmits the trace context of the records that follow, as
+// fields such as "traceparent" and "tracestate" of the W3C Trace Context
+// specification, for the Decoder to report by TraceContext, so that tracing
+// spans the services through which a stream is replicated. Relays forward the
+// trace context as they do other control records.
+func (n *Encoder) SetTraceContext(carrier map[string]string) (e error) {
+	defer errorf("could not set trace context", &e)
+
+	var (
+		payload []byte
+	)
+
+	payload, e = marshalTraceContext(carrier)
+	if e != nil {
+		return
+	}
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	e = n.writeControl(controlTraceContext, payload)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+// TraceContext returns a copy of the trace context most recently received, as
+// transmitted by Encoder.SetTraceContext, or nil if none has been received.
+func (d *Decoder) TraceContext() map[string]string {
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	return maps.Clone(d.traceContext)
+}
+
+func marshalTraceContext(carrier map[string]string) (payload []byte, e error) {
+	// Returns the fields of carrier in order of name, each name and value
+	// preceded by its length in 2 bytes.
+
+	var (
+		name  string
+		names = make([]string, 0, len(carrier))
+	)
+
+	for name = range carrier {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name = range names {
+		if len(name) > 1<<16-1 || len(carrier[name]) > 1<<16-1 {
+			return nil, fmt.Errorf("trace context field %q too long", name)
+		}
+
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(name)))
+		payload = append(payload, name...)
+
+		payload = binary.BigEndian.AppendUint16(payload,
+			uint16(len(carrier[name])),
+		)
+		payload = append(payload, carrier[name]...)
+	}
+
+	return
+}
+
+func unmarshalTraceContext(payload []byte) (
+	carrier map[string]string, e error,
+) {
+	// Returns the fields marshalled by marshalTraceContext.
+
+	var (
+		field [2]string
+		i     int
+		l     int
+	)
+
+	carrier = make(map[string]string)
+
+	for len(payload) > 0 {
+		for i = range field {
+			if len(payload) < 2 {
+				return nil, fmt.Errorf("malformed trace context control record")
+			}
+
+			l = int(binary.BigEndian.Uint16(payload))
+			payload = payload[2:]
+
+			if len(payload) < l {
+				return nil, fmt.Errorf("malformed trace context control record")
+			}
+
+			field[i] = string(payload[:l])
+			payload = payload[l:]
+		}
+
+		carrier[field[0]] = field[1]
+	}
+
+	return
+}
@@ -0,0 +1,53 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceContext(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		carrier = map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-" +
+				"00f067aa0ba902b7-01",
+			"tracestate": "congo=t61rcWkgMzE",
+		}
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		relayed bytes.Buffer
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a(), WithBatchChecksum(2))
+
+	assert.NoError(t, encoder.SetTraceContext(carrier))
+
+	assert.NoError(t,
+		encoder.Encode([]byte("key"), []byte("val")),
+	)
+
+	assert.NoError(t, encoder.Close())
+
+	_, e = Relay(&relayed, &buffer, fnv.New32a())
+
+	assert.NoError(t, e)
+
+	decoder = NewDecoder(&relayed, fnv.New32a())
+
+	assert.Nil(t, decoder.TraceContext())
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, carrier, decoder.TraceContext())
+
+	_, e = unmarshalTraceContext([]byte{0, 2, 'a'})
+
+	assert.ErrorContains(t, e, "malformed trace context")
+
+	return
+}
@@ -0,0 +1,128 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// BeginTransaction transmits a control record marking the start of a
+// transaction of the source identified by id, which may be nil, so that the
+// records up to the matching CommitTransaction can be applied downstream in
+// one transaction, without exposing the intermediate states of the source.
+// See WithTransactions. Transactions do not nest.
+func (n *Encoder) BeginTransaction(id []byte) (e error) {
+	defer errorf("could not begin transaction", &e)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if n.transaction {
+		return fmt.Errorf("transaction already begun")
+	}
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	e = n.writeControl(controlTransactionBegin, id)
+	if e != nil {
+		return
+	}
+
+	n.transaction = true
+
+	return
+}
+
+// CommitTransaction transmits a control record marking the end of the
+// transaction begun by BeginTransaction, after ending the current batch if
+// the stream is checksummed in batches, so that every record of the
+// transaction is verified before it is applied. It then flushes the
+// underlying [io.Writer], if it implements Flush, so that the transaction is
+// not held back in a buffer.
+func (n *Encoder) CommitTransaction() (e error) {
+	defer errorf("could not commit transaction", &e)
+
+	var (
+		ok     bool
+		writer flusher
+	)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	if !n.transaction {
+		return fmt.Errorf("no transaction begun")
+	}
+
+	e = n.begin()
+	if e != nil {
+		return
+	}
+
+	if n.features&featureBatchChecksum != 0 {
+		e = n.endBatch()
+		if e != nil {
+			return
+		}
+	}
+
+	e = n.writeControl(controlTransactionCommit, nil)
+	if e != nil {
+		return
+	}
+
+	n.transaction = false
+
+	writer, ok = n.dest.(flusher)
+	if ok {
+		e = writer.Flush()
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (d *Decoder) receiveTransactionBegin(id []byte) (e error) {
+	// Enters the transaction, and reports it if so configured.
+
+	if d.inTransaction {
+		return fmt.Errorf("transaction begun within transaction")
+	}
+
+	d.transaction = bytes.Clone(id)
+	d.inTransaction = true
+	d.transactionsBegun++
+
+	if d.options.beginTransaction == nil {
+		return
+	}
+
+	return d.options.beginTransaction(d.transaction)
+}
+
+func (d *Decoder) receiveTransactionCommit() (e error) {
+	// Leaves the transaction, and reports it if so configured.
+
+	var (
+		id = d.transaction
+	)
+
+	if !d.inTransaction {
+		return fmt.Errorf("transaction committed outside transaction")
+	}
+
+	d.transaction = nil
+	d.inTransaction = false
+
+	if d.options.commitTransaction == nil {
+		return
+	}
+
+	return d.options.commitTransaction(id)
+}
@@ -0,0 +1,194 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactions(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, NewCRC32C(), WithBatchChecksum(8))
+		events  []string
+		key     []byte
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("1")),
+	)
+	assert.NoError(t,
+		encoder.BeginTransaction([]byte("t1")),
+	)
+	assert.Error(t,
+		encoder.BeginTransaction([]byte("t2")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), []byte("2")),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("c"), []byte("3")),
+	)
+	assert.NoError(t,
+		encoder.CommitTransaction(),
+	)
+	assert.Error(t,
+		encoder.CommitTransaction(),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	// The hooks bracket the records of the transaction, and the commit is
+	// reported as soon as its marker is read, before the end of the stream.
+
+	decoder = NewDecoder(&buffer, NewCRC32C(),
+		WithTransactions(
+			func(id []byte) error {
+				events = append(events, "begin "+string(id))
+
+				return nil
+			},
+			func(id []byte) error {
+				events = append(events, "commit "+string(id))
+
+				return nil
+			},
+		),
+	)
+
+	for {
+		key, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+
+		events = append(events, string(key))
+	}
+
+	assert.Equal(t,
+		[]string{"a", "begin t1", "b", "c", "commit t1"},
+		events,
+	)
+
+	return
+}
+
+func TestTransactionTorn(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		failure = errors.New("target full")
+	)
+
+	assert.NoError(t,
+		encoder.BeginTransaction(nil),
+	)
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("1")),
+	)
+	assert.ErrorContains(t,
+		encoder.Close(), "transaction not committed",
+	)
+
+	// A stream that ends within a transaction is incomplete.
+
+	decoder = NewDecoder(
+		bytes.NewReader(buffer.Bytes()), nil,
+	)
+
+	_, _, e = decoder.Decode()
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+
+	// Errors of the hooks are returned by Decode.
+
+	decoder = NewDecoder(
+		bytes.NewReader(buffer.Bytes()), nil,
+		WithTransactions(
+			func(id []byte) error {
+				return failure
+			},
+			nil,
+		),
+	)
+
+	_, _, e = decoder.Decode()
+	assert.ErrorIs(t, e, failure)
+
+	// The stream can still be finished after Close has refused to tear it.
+
+	assert.NoError(t,
+		encoder.CommitTransaction(),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	return
+}
+
+func TestMirrorSinkTransactions(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		commits int
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil)
+		sink    *MirrorSink
+	)
+
+	sink = NewMirrorSink(newMapTxn(map[string]string{}), encoder)
+
+	assert.NoError(t,
+		sink.Put([]byte("a"), []byte("1")),
+	)
+	assert.NoError(t,
+		sink.Commit(),
+	)
+
+	// A transaction without changes leaves no markers.
+
+	sink = NewMirrorSink(newMapTxn(map[string]string{}), encoder)
+
+	assert.NoError(t,
+		sink.Commit(),
+	)
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	decoder = NewDecoder(&buffer, nil,
+		WithTransactions(nil,
+			func(id []byte) error {
+				commits++
+
+				return nil
+			},
+		),
+	)
+
+	for {
+		_, _, e = decoder.Decode()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+
+		assert.NoError(t, e)
+	}
+
+	assert.Equal(t, 1, commits)
+
+	return
+}
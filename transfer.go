@@ -0,0 +1,144 @@
+package bottledlightning
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteTo implements [io.WriterTo]. It copies the remaining records of the
+// stream verbatim to the [io.Writer], as would Relay, checking their framing
+// and checksums without decoding them, so that a stream can be forwarded
+// without transformation at little cost. What the Writer receives is a
+// continuation of the stream, which a Decoder can receive on its own only if
+// it begins at the start of the stream or the features of the stream are
+// announced anew. Because records are not decoded, WriteTo refuses a Decoder
+// that verifies signatures or snapshot IDs.
+func (d *Decoder) WriteTo(writer io.Writer) (n int64, e error) {
+	defer errorf("could not copy records", &e)
+
+	var (
+		r = &relay{
+			writer:   writer,
+			reader:   d.reader,
+			hasher:   d.hasher,
+			options:  d.options,
+			buffer:   make([]byte, relayBufferLen),
+			features: d.features,
+			batched:  d.batched,
+		}
+	)
+
+	d.mutex.Lock()
+
+	defer d.mutex.Unlock()
+
+	switch {
+	case d.closed:
+		return 0, fmt.Errorf("decoder closed")
+
+	case d.digest != nil:
+		return 0, fmt.Errorf("signatures cannot be verified without decoding")
+
+	case d.snapshot != nil:
+		return 0, fmt.Errorf("snapshot ID cannot be verified without decoding")
+	}
+
+	defer func() {
+		d.features = r.features
+		d.batched = r.batched
+
+		n = r.written
+	}()
+
+	for {
+		e = r.next()
+		if e == io.EOF {
+			return n, nil
+		}
+
+		if e != nil {
+			return
+		}
+	}
+}
+
+// ReadFrom implements [io.ReaderFrom]. It ingests an already encoded stream
+// from the [io.Reader], checking its framing, and its checksums if the Encoder
+// appends them, and writes its records verbatim, without decoding and
+// re-encoding them. Any batch in progress is ended, and any features revoked,
+// first. Records encoded afterwards are preceded by whatever control records
+// are needed to restore the features, schema, data key and deduplication state
+// of the Encoder. The ingested records are not subject to the options of the
+// Encoder, and ReadFrom refuses an Encoder that signs the stream or computes
+// its snapshot ID.
+func (n *Encoder) ReadFrom(reader io.Reader) (c int64, e error) {
+	defer errorf("could not ingest stream", &e)
+
+	var (
+		r = &relay{
+			writer:  n.writer,
+			reader:  reader,
+			hasher:  n.hasher,
+			options: n.options,
+			buffer:  make([]byte, relayBufferLen),
+		}
+	)
+
+	n.mutex.Lock()
+
+	defer n.mutex.Unlock()
+
+	switch {
+	case n.closed:
+		return 0, fmt.Errorf("encoder closed")
+
+	case n.digest != nil:
+		return 0, fmt.Errorf("ingested records cannot be signed")
+
+	case n.snapshot != nil:
+		return 0, fmt.Errorf("ingested records cannot be identified")
+	}
+
+	if n.batched > 0 {
+		e = n.endBatch()
+		if e != nil {
+			return
+		}
+	}
+
+	if n.features != 0 {
+		// The ingested stream assumes that no features are in effect until
+		// it announces its own.
+
+		e = n.writeControl(controlFeatures, make([]byte, 4))
+		if e != nil {
+			return
+		}
+
+		n.features = 0
+	}
+
+	defer func() {
+		// The decoder at the other end has received whatever control
+		// records the ingested stream held.
+
+		n.features = r.features
+		n.schemaSent = false
+		n.dedupCache = nil
+		n.dataKey = nil
+		n.source = ""
+
+		c = r.written
+	}()
+
+	for {
+		e = r.next()
+		if e == io.EOF {
+			return c, nil
+		}
+
+		if e != nil {
+			return
+		}
+	}
+}
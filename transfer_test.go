@@ -0,0 +1,99 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoderWriteTo(t *testing.T) {
+	var (
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		key     []byte
+		n       int64
+		output  bytes.Buffer
+		source  bytes.Buffer
+	)
+
+	encoder = NewEncoder(&source, fnv.New32a(), WithBatchChecksum(2))
+
+	assert.NoError(t, encoder.Encode([]byte("a"), []byte("one")))
+	assert.NoError(t, encoder.Encode([]byte("b"), []byte("two")))
+	assert.NoError(t, encoder.Encode([]byte("c"), []byte("three")))
+	assert.NoError(t, encoder.Close())
+
+	decoder = NewDecoder(&source, fnv.New32a())
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("a"), key)
+
+	n, e = decoder.WriteTo(&output)
+
+	assert.NoError(t, e)
+	assert.Equal(t, int64(output.Len()), n)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
+
+func TestEncoderReadFrom(t *testing.T) {
+	var (
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		ingest  bytes.Buffer
+		key     []byte
+		keys    []string
+		output  bytes.Buffer
+	)
+
+	encoder = NewEncoder(&ingest, fnv.New32a())
+
+	assert.NoError(t, encoder.Encode([]byte("b"), []byte("two")))
+	assert.NoError(t, encoder.Encode([]byte("c"), []byte("three")))
+
+	encoder = NewEncoder(&output, fnv.New32a(),
+		WithHeaderChecksum(), WithBatchChecksum(4),
+	)
+
+	assert.NoError(t, encoder.Encode([]byte("a"), []byte("one")))
+
+	_, e = encoder.ReadFrom(bytes.NewReader(ingest.Bytes()))
+
+	assert.NoError(t, e)
+
+	assert.NoError(t, encoder.Encode([]byte("d"), []byte("four")))
+	assert.NoError(t, encoder.Close())
+
+	decoder = NewDecoder(&output, fnv.New32a())
+
+	for {
+		key, _, e = decoder.Decode()
+		if e != nil {
+			break
+		}
+
+		keys = append(keys, string(key))
+	}
+
+	assert.ErrorIs(t, e, io.EOF)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, keys)
+
+	ingest.Bytes()[ingest.Len()-1] ^= 1
+
+	_, e = NewEncoder(io.Discard, fnv.New32a()).ReadFrom(&ingest)
+
+	assert.Error(t, e)
+
+	return
+}
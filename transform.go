@@ -0,0 +1,72 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+func (o *options) transformEncode(key, val []byte) ([]byte, error) {
+	// Returns the value of a record as transformed for transmission, if so
+	// configured.
+
+	if o.encodeTransform == nil {
+		return val, nil
+	}
+
+	return o.transform(o.encodeTransform, key, val)
+}
+
+func (o *options) transformEncodeFrom(key []byte, val io.Reader, size int64) (
+	transformed io.Reader, transformedSize int64, e error,
+) {
+	// Returns the value of a record, of the given size, to be read from val,
+	// as transformed for transmission, if so configured. The value is read
+	// into memory in order to be transformed.
+
+	var (
+		b []byte
+	)
+
+	if o.encodeTransform == nil {
+		return val, size, nil
+	}
+
+	b = make([]byte, size)
+
+	_, e = io.ReadFull(val, b)
+	if e != nil {
+		return
+	}
+
+	b, e = o.transform(o.encodeTransform, key, b)
+	if e != nil {
+		return
+	}
+
+	return bytes.NewReader(b), int64(len(b)), nil
+}
+
+func (o *options) transformDecode(key, val []byte) ([]byte, error) {
+	// Returns the value of a record as transformed upon receipt, if so
+	// configured.
+
+	if o.decodeTransform == nil {
+		return val, nil
+	}
+
+	return o.transform(o.decodeTransform, key, val)
+}
+
+func (o *options) transform(f func([]byte, []byte) ([]byte, error),
+	key, val []byte,
+) (
+	transformed []byte, e error,
+) {
+	transformed, e = f(key, val)
+	if e != nil {
+		return nil, fmt.Errorf("could not transform value: %w", e)
+	}
+
+	return
+}
@@ -0,0 +1,70 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransform(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		val     []byte
+
+		upper = func(key, val []byte) ([]byte, error) {
+			return bytes.ToUpper(val), nil
+		}
+		suffix = func(key, val []byte) ([]byte, error) {
+			if !bytes.HasPrefix(key, []byte("ok")) {
+				return nil, fmt.Errorf("unexpected key %q", key)
+			}
+
+			return append(val, "!"...), nil
+		}
+	)
+
+	encoder = NewEncoder(&buffer, fnv.New32a(), WithTransform(upper, nil))
+
+	assert.NoError(t,
+		encoder.Encode([]byte("ok1"), []byte("one")),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeFrom([]byte("ok2"), strings.NewReader("two"), 3),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("no"), []byte("three")),
+	)
+
+	decoder = NewDecoder(&buffer, fnv.New32a(), WithTransform(nil, suffix))
+
+	_, val, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("ONE!"), val)
+
+	_, val, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("TWO!"), val)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorContains(t, e, "could not transform value")
+
+	assert.Error(t,
+		NewEncoder(&buffer, nil, WithTransform(suffix, nil)).Encode(
+			[]byte("no"), []byte("val"),
+		),
+	)
+
+	return
+}
@@ -0,0 +1,312 @@
+package bottledlightning
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// A Transport establishes the connections over which streams are replicated,
+// by an Encoder on one end and a Decoder on the other, after a Handshake if
+// the peers negotiate capabilities. Implementations are provided for TCP,
+// Unix domain sockets and memory; others can carry streams through tunnels
+// such as SSH or a service mesh. See also WithFraming.
+type Transport interface {
+	// Dial connects to the peer listening at the address.
+	Dial(ctx context.Context, address string) (net.Conn, error)
+
+	// Listen announces on the address and returns a [net.Listener] that
+	// accepts connections from peers.
+	Listen(ctx context.Context, address string) (net.Listener, error)
+}
+
+// A Framer wraps each connection established by a Transport configured with
+// WithFraming, for example to secure it or to frame its bytes for a tunnel.
+// It is told whether the connection was dialled, or accepted. A Framer of an
+// accepted connection is called by Accept, and so should defer any exchange
+// with the peer, such as a handshake, to the first Read or Write.
+type Framer func(ctx context.Context, conn net.Conn, dialled bool) (
+	net.Conn, error,
+)
+
+// NetTransport is a Transport over a network of the [net] package, such as
+// "tcp" or "unix", by way of its Dialer and ListenConfig.
+type NetTransport struct {
+	Network      string
+	Dialer       net.Dialer
+	ListenConfig net.ListenConfig
+}
+
+// TCPTransport returns a Transport over TCP.
+func TCPTransport() *NetTransport {
+	return &NetTransport{Network: "tcp"}
+}
+
+// UnixTransport returns a Transport over Unix domain sockets, at addresses
+// that are paths in the file system.
+func UnixTransport() *NetTransport {
+	return &NetTransport{Network: "unix"}
+}
+
+// Dial implements Transport.
+func (t *NetTransport) Dial(ctx context.Context, address string) (
+	conn net.Conn, e error,
+) {
+	defer errorf("could not dial", &e)
+
+	return t.Dialer.DialContext(ctx, t.Network, address)
+}
+
+// Listen implements Transport.
+func (t *NetTransport) Listen(ctx context.Context, address string) (
+	listener net.Listener, e error,
+) {
+	defer errorf("could not listen", &e)
+
+	return t.ListenConfig.Listen(ctx, t.Network, address)
+}
+
+// A MemoryTransport is a Transport that connects peers within the process by
+// [net.Pipe], for tests and for pipelines whose stages share a process. Any
+// string is an address. MemoryTransports are safe for concurrent use by
+// multiple goroutines.
+type MemoryTransport struct {
+	mutex     sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+// NewMemoryTransport returns a new MemoryTransport with no listeners.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		listeners: make(map[string]*memoryListener),
+	}
+}
+
+// Dial implements Transport. It waits for the listener at the address to
+// accept the connection.
+func (t *MemoryTransport) Dial(ctx context.Context, address string) (
+	conn net.Conn, e error,
+) {
+	defer errorf("could not dial", &e)
+
+	var (
+		listener *memoryListener
+		server   net.Conn
+	)
+
+	t.mutex.Lock()
+
+	listener = t.listeners[address]
+
+	t.mutex.Unlock()
+
+	if listener == nil {
+		return nil, fmt.Errorf("no listener at %q", address)
+	}
+
+	conn, server = net.Pipe()
+
+	select {
+	case listener.conns <- server:
+		return
+
+	case <-listener.closed:
+		e = net.ErrClosed
+
+	case <-ctx.Done():
+		e = ctx.Err()
+	}
+
+	conn.Close()
+	server.Close()
+
+	return nil, e
+}
+
+// Listen implements Transport.
+func (t *MemoryTransport) Listen(_ context.Context, address string) (
+	listener net.Listener, e error,
+) {
+	defer errorf("could not listen", &e)
+
+	t.mutex.Lock()
+
+	defer t.mutex.Unlock()
+
+	if t.listeners[address] != nil {
+		return nil, fmt.Errorf("address %q in use", address)
+	}
+
+	t.listeners[address] = &memoryListener{
+		transport: t,
+		address:   address,
+		conns:     make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+
+	return t.listeners[address], nil
+}
+
+type memoryListener struct {
+	transport *MemoryTransport
+	address   string
+	conns     chan net.Conn
+	closed    chan struct{}
+	closing   sync.Once
+}
+
+func (l *memoryListener) Accept() (conn net.Conn, e error) {
+	select {
+	case conn = <-l.conns:
+		return
+
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closing.Do(func() {
+		close(l.closed)
+
+		l.transport.mutex.Lock()
+
+		defer l.transport.mutex.Unlock()
+
+		delete(l.transport.listeners, l.address)
+	})
+
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr(l.address)
+}
+
+type memoryAddr string
+
+func (memoryAddr) Network() string {
+	return "memory"
+}
+
+func (a memoryAddr) String() string {
+	return string(a)
+}
+
+// WithFraming returns a Transport that establishes connections by the given
+// Transport and wraps each with the framers, in order.
+func WithFraming(transport Transport, framers ...Framer) Transport {
+	return &framedTransport{
+		transport: transport,
+		framers:   framers,
+	}
+}
+
+type framedTransport struct {
+	transport Transport
+	framers   []Framer
+}
+
+func (t *framedTransport) Dial(ctx context.Context, address string) (
+	conn net.Conn, e error,
+) {
+	conn, e = t.transport.Dial(ctx, address)
+	if e != nil {
+		return
+	}
+
+	return frame(ctx, conn, true, t.framers)
+}
+
+func (t *framedTransport) Listen(ctx context.Context, address string) (
+	listener net.Listener, e error,
+) {
+	listener, e = t.transport.Listen(ctx, address)
+	if e != nil {
+		return
+	}
+
+	return &framedListener{
+		Listener: listener,
+		framers:  t.framers,
+	}, nil
+}
+
+type framedListener struct {
+	net.Listener
+
+	framers []Framer
+}
+
+func (l *framedListener) Accept() (conn net.Conn, e error) {
+	conn, e = l.Listener.Accept()
+	if e != nil {
+		return
+	}
+
+	return frame(context.Background(), conn, false, l.framers)
+}
+
+func frame(ctx context.Context, conn net.Conn, dialled bool,
+	framers []Framer,
+) (
+	framed net.Conn, e error,
+) {
+	// Wraps conn with the framers in order, closing it if any fails.
+
+	defer errorf("could not frame connection", &e)
+
+	var (
+		framer Framer
+	)
+
+	framed = conn
+
+	for _, framer = range framers {
+		framed, e = framer(ctx, framed, dialled)
+		if e != nil {
+			conn.Close()
+
+			return nil, e
+		}
+	}
+
+	return
+}
+
+// TLSFraming returns a Framer that secures connections by TLS, as do DialTLS
+// and ListenTLS, with the configuration, which may be nil, completed as
+// described at TLSConfig. Dialled connections are handshaken at once, and
+// accepted ones on their first Read or Write.
+func TLSFraming(config *tls.Config) Framer {
+	config = TLSConfig(config)
+
+	return func(ctx context.Context, conn net.Conn, dialled bool) (
+		framed net.Conn, e error,
+	) {
+		var (
+			client *tls.Conn
+		)
+
+		if !dialled {
+			return tls.Server(conn, config), nil
+		}
+
+		client = tls.Client(conn, config)
+
+		e = client.HandshakeContext(ctx)
+		if e != nil {
+			return
+		}
+
+		if client.ConnectionState().NegotiatedProtocol != ALPNProtocol {
+			return nil, fmt.Errorf("peer does not support protocol %q",
+				ALPNProtocol,
+			)
+		}
+
+		return client, nil
+	}
+}
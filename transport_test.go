@@ -0,0 +1,215 @@
+package bottledlightning
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	var (
+		certificate tls.Certificate
+		pool        *x509.CertPool
+		transport   Transport
+	)
+
+	certificate, pool = newTestCertificate(t)
+
+	for _, transport = range []Transport{
+		TCPTransport(),
+		UnixTransport(),
+		NewMemoryTransport(),
+		WithFraming(NewMemoryTransport(),
+			TLSFraming(
+				&tls.Config{
+					Certificates: []tls.Certificate{certificate},
+					ClientCAs:    pool,
+					RootCAs:      pool,
+					ServerName:   "localhost",
+				},
+			),
+		),
+	} {
+		testTransport(t, transport)
+	}
+
+	return
+}
+
+func testTransport(t *testing.T, transport Transport) {
+	var (
+		address  = "127.0.0.1:0"
+		conn     net.Conn
+		decoder  *Decoder
+		e        error
+		key      []byte
+		listener net.Listener
+		network  *NetTransport
+		ok       bool
+		val      []byte
+	)
+
+	network, ok = transport.(*NetTransport)
+	if ok && network.Network == "unix" {
+		address = filepath.Join(t.TempDir(), "socket")
+	}
+
+	listener, e = transport.Listen(context.Background(), address)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		var (
+			e       error
+			encoder *Encoder
+			server  net.Conn
+		)
+
+		server, e = listener.Accept()
+		if e != nil {
+			return
+		}
+
+		encoder = NewEncoder(server, nil)
+
+		encoder.Encode([]byte("key"), []byte("val"))
+		encoder.Close()
+
+		server.Close()
+	}()
+
+	conn, e = transport.Dial(context.Background(),
+		listener.Addr().String(),
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer conn.Close()
+
+	decoder = NewDecoder(conn, nil)
+
+	key, val, e = decoder.Decode()
+	if e != nil {
+		t.Error(e)
+	}
+
+	assert.Equal(t, []byte("key"), key)
+	assert.Equal(t, []byte("val"), val)
+
+	_, _, e = decoder.Decode()
+	assert.ErrorIs(t, e, io.EOF)
+
+	return
+}
+
+func TestMemoryTransport(t *testing.T) {
+	var (
+		cancel    context.CancelFunc
+		ctx       context.Context
+		e         error
+		listener  net.Listener
+		transport = NewMemoryTransport()
+	)
+
+	_, e = transport.Dial(context.Background(), "a")
+	assert.Error(t, e) // no listener
+
+	listener, e = transport.Listen(context.Background(), "a")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	assert.Equal(t, "memory", listener.Addr().Network())
+	assert.Equal(t, "a", listener.Addr().String())
+
+	_, e = transport.Listen(context.Background(), "a")
+	assert.Error(t, e) // in use
+
+	ctx, cancel = context.WithTimeout(context.Background(),
+		10*time.Millisecond,
+	)
+
+	defer cancel()
+
+	_, e = transport.Dial(ctx, "a")
+	assert.ErrorIs(t, e, context.DeadlineExceeded) // not accepted
+
+	listener.Close()
+
+	_, e = listener.Accept()
+	assert.True(t,
+		errors.Is(e, net.ErrClosed),
+	)
+
+	listener, e = transport.Listen(context.Background(), "a")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	listener.Close()
+
+	return
+}
+
+func TestTLSFramingProtocol(t *testing.T) {
+	var (
+		certificate tls.Certificate
+		e           error
+		listener    net.Listener
+		pool        *x509.CertPool
+		server      = NewMemoryTransport()
+	)
+
+	certificate, pool = newTestCertificate(t)
+
+	listener, e = server.Listen(context.Background(), "a")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		var (
+			conn *tls.Conn
+			e    error
+			raw  net.Conn
+		)
+
+		raw, e = listener.Accept()
+		if e != nil {
+			return
+		}
+
+		conn = tls.Server(raw,
+			&tls.Config{
+				Certificates: []tls.Certificate{certificate},
+			},
+		)
+
+		conn.Handshake()
+
+		conn.Close()
+	}()
+
+	_, e = WithFraming(server,
+		TLSFraming(
+			&tls.Config{RootCAs: pool, ServerName: "localhost"},
+		),
+	).Dial(context.Background(), "a")
+	assert.Error(t, e) // no ALPN protocol negotiated
+
+	return
+}
@@ -0,0 +1,145 @@
+package bottledlightning
+
+import (
+	"fmt"
+)
+
+// A ValidationProfile is a named set of rules, reflecting the constraints of a
+// target store, that an Encoder and a Decoder enforce on every record, so that
+// a record that the store would refuse is refused up front. See
+// WithValidationProfile. Profiles other than those predefined are built by
+// NewValidationProfile.
+type ValidationProfile struct {
+	name            string
+	maxKeyLen       int
+	maxValLen       int64
+	refuseEmptyKeys bool
+	requireSorted   bool
+}
+
+// A ValidationRule is a rule of a ValidationProfile.
+type ValidationRule func(*ValidationProfile)
+
+var (
+	// ProfileLMDBStrict enforces the constraints of LMDB, which refuses
+	// zero-length keys in addition to those exceeding the lengths that the
+	// format already limits.
+	ProfileLMDBStrict = NewValidationProfile("lmdb-strict",
+		LimitKeyLen(lmdbMaxKeyLen),
+		LimitValLen(lmdbMaxValLen),
+		RefuseEmptyKeys(),
+	)
+
+	// ProfileRelaxed enforces only the limits of the format, which is the
+	// default.
+	ProfileRelaxed = NewValidationProfile("relaxed")
+)
+
+// NewValidationProfile returns a custom ValidationProfile of the given name
+// that enforces the rules in addition to the limits of the format.
+func NewValidationProfile(name string, rules ...ValidationRule) (
+	p ValidationProfile,
+) {
+	var (
+		rule ValidationRule
+	)
+
+	p.name = name
+
+	for _, rule = range rules {
+		rule(&p)
+	}
+
+	return
+}
+
+// LookupValidationProfile returns the predefined ValidationProfile of the
+// given name, such as one named in configuration, and whether it exists.
+func LookupValidationProfile(name string) (p ValidationProfile, ok bool) {
+	for _, p = range []ValidationProfile{ProfileLMDBStrict, ProfileRelaxed} {
+		if p.name == name {
+			return p, true
+		}
+	}
+
+	return ValidationProfile{}, false
+}
+
+// Name returns the name of the profile.
+func (p ValidationProfile) Name() string {
+	return p.name
+}
+
+// LimitKeyLen is a ValidationRule refusing keys longer than n bytes.
+func LimitKeyLen(n int) ValidationRule {
+	return func(p *ValidationProfile) {
+		p.maxKeyLen = n
+	}
+}
+
+// LimitValLen is a ValidationRule refusing values longer than n bytes.
+func LimitValLen(n int64) ValidationRule {
+	return func(p *ValidationProfile) {
+		p.maxValLen = n
+	}
+}
+
+// RefuseEmptyKeys is a ValidationRule refusing zero-length keys, with an
+// EmptyKeyError.
+func RefuseEmptyKeys() ValidationRule {
+	return func(p *ValidationProfile) {
+		p.refuseEmptyKeys = true
+	}
+}
+
+// RequireSortedKeys is a ValidationRule refusing a key that does not sort
+// strictly after that of the preceding record, as does WithAssertSorted.
+func RequireSortedKeys() ValidationRule {
+	return func(p *ValidationProfile) {
+		p.requireSorted = true
+	}
+}
+
+func (p ValidationProfile) validate(key []byte, v int64) (e error) {
+	// Returns an error if a record with the given key and value length breaks
+	// a rule of the profile. Sortedness is enforced by orderKey.
+
+	switch {
+	case len(key) == 0 && p.refuseEmptyKeys:
+		return EmptyKeyError{}
+
+	case p.maxKeyLen > 0 && len(key) > p.maxKeyLen:
+		return ValidationError{p.name,
+			fmt.Sprintf("key longer than %d B", p.maxKeyLen),
+		}
+
+	case p.maxValLen > 0 && v > p.maxValLen:
+		return ValidationError{p.name,
+			fmt.Sprintf("value longer than %d B", p.maxValLen),
+		}
+	}
+
+	return
+}
+
+// A ValidationError reports a record that breaks a rule of a
+// ValidationProfile.
+type ValidationError struct {
+	Profile string
+	Reason  string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("record refused by validation profile %q: %s",
+		e.Profile, e.Reason,
+	)
+}
+
+// An EmptyKeyError reports a record with a zero-length key, which LMDB refuses
+// to store, encountered in strict mode or under a ValidationProfile that
+// refuses empty keys. See WithStrict.
+type EmptyKeyError struct{}
+
+func (EmptyKeyError) Error() string {
+	return "zero-length key refused"
+}
@@ -0,0 +1,110 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrict(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder = NewEncoder(&buffer, nil, WithStrict())
+		key     []byte
+	)
+
+	e = encoder.Encode([]byte{}, []byte("val"))
+
+	assert.True(t, errors.As(e, &EmptyKeyError{}))
+
+	e = encoder.EncodeFrom(nil, strings.NewReader("val"), 3)
+
+	assert.ErrorIs(t, e, EmptyKeyError{})
+	assert.Zero(t, buffer.Len())
+
+	assert.NoError(t,
+		NewEncoder(&buffer, nil).Encode([]byte{}, []byte("val")),
+	)
+
+	assert.NoError(t,
+		NewEncoder(&buffer, nil).Encode([]byte("key"), []byte("val")),
+	)
+
+	decoder = NewDecoder(bytes.NewReader(buffer.Bytes()), nil, WithStrict())
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorIs(t, e, EmptyKeyError{})
+
+	key, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+	assert.Equal(t, []byte("key"), key)
+
+	return
+}
+
+func TestValidationProfile(t *testing.T) {
+	var (
+		buffer  bytes.Buffer
+		decoder *Decoder
+		e       error
+		encoder *Encoder
+		ok      bool
+		profile ValidationProfile
+		target  ValidationError
+	)
+
+	profile, ok = LookupValidationProfile("lmdb-strict")
+
+	assert.True(t, ok)
+	assert.Equal(t, ProfileLMDBStrict, profile)
+
+	_, ok = LookupValidationProfile("custom")
+
+	assert.False(t, ok)
+
+	profile = NewValidationProfile("custom",
+		LimitKeyLen(4), LimitValLen(8), RequireSortedKeys(),
+	)
+
+	assert.Equal(t, "custom", profile.Name())
+
+	encoder = NewEncoder(&buffer, nil, WithValidationProfile(profile))
+
+	assert.NoError(t, encoder.Encode([]byte{}, []byte("val")))
+	assert.NoError(t, encoder.Encode([]byte("b"), []byte("val")))
+
+	e = encoder.Encode([]byte("long-key"), []byte("val"))
+
+	assert.True(t, errors.As(e, &target))
+	assert.Equal(t, "custom", target.Profile)
+
+	assert.Error(t, encoder.Encode([]byte("c"), []byte("long value")))
+	assert.Error(t, encoder.Encode([]byte("a"), []byte("val")))
+
+	assert.NoError(t,
+		NewEncoder(&buffer, nil).Encode([]byte("a"), []byte("long value")),
+	)
+
+	decoder = NewDecoder(&buffer, nil, WithValidationProfile(profile))
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+
+	assert.NoError(t, e)
+
+	_, _, e = decoder.Decode()
+
+	assert.ErrorAs(t, e, &target)
+
+	return
+}
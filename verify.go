@@ -0,0 +1,476 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// A Report summarises a stream of records, as returned by Verify.
+type Report struct {
+	// Records is the number of data records in the stream. Control records are
+	// not counted.
+	Records int
+
+	// KeyBytes and ValBytes are the total lengths of all keys and values.
+	KeyBytes int64
+	ValBytes int64
+
+	// EmptyKeys is the number of records with a zero-length key, which LMDB
+	// would refuse to store.
+	EmptyKeys int
+
+	// OutOfOrder is the number of records whose key does not sort strictly
+	// after that of the preceding record under the default LMDB comparator. A
+	// stream with no such records can be loaded with MDB_APPEND.
+	OutOfOrder int
+
+	// XMetaValues counts the records carrying each extended metadata value.
+	XMetaValues [XMetaValueF + 1]int
+}
+
+// Verify receives every record from the [io.Reader], verifying the checksum of
+// each if the [hash.Hash32] is not nil, and summarises the stream without
+// retaining it. On failure, Verify returns the Report as far as the last
+// well-formed record, so that the location of the damage can be reported.
+func Verify(reader io.Reader, hasher hash.Hash32) (report Report, e error) {
+	defer errorf("could not verify stream", &e)
+
+	var (
+		decoder = NewDecoder(reader, hasher)
+		key     []byte
+		prev    []byte
+		val     []byte
+		xmv     byte
+	)
+
+	for {
+		key, val, xmv, e = decoder.DecodeX()
+		if errors.Is(e, io.EOF) {
+			return report, nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		report.Records++
+
+		report.KeyBytes += int64(len(key))
+		report.ValBytes += int64(len(val))
+
+		if len(key) == 0 {
+			report.EmptyKeys++
+		}
+
+		if report.Records > 1 && bytes.Compare(key, prev) <= 0 {
+			report.OutOfOrder++
+		}
+
+		report.XMetaValues[xmv]++
+
+		prev = key
+	}
+}
+
+// VerifyParallel is a variant of Verify that computes checksums on the given
+// number of goroutines, or on one per CPU if workers is not positive, while
+// another parses the stream, so that the verification of a large archive on
+// fast storage is not bound by a single core. Each goroutine hashes with a
+// [hash.Hash32] of its own from newHasher, which may be nil if checksums are
+// not to be verified. Unlike Verify, VerifyParallel does not decode values:
+// ValBytes counts them as stored, before any decompression, decryption or
+// resolution of deduplicated references.
+func VerifyParallel(reader io.Reader, newHasher func() hash.Hash32,
+	workers int,
+) (
+	report Report, e error,
+) {
+	defer errorf("could not verify stream", &e)
+
+	var (
+		failure verifyFailure
+		i       int
+		jobs    chan []verifyCheck
+		v       = &verifier{reader: reader, failure: &failure}
+		wait    sync.WaitGroup
+	)
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	jobs = make(chan []verifyCheck, 2*workers)
+
+	if newHasher != nil {
+		v.hasher = newHasher()
+		v.jobs = jobs
+
+		for i = 0; i < workers; i++ {
+			wait.Add(1)
+
+			go func(hasher hash.Hash32) {
+				defer wait.Done()
+
+				verifyJobs(jobs, hasher, &failure)
+			}(newHasher())
+		}
+	}
+
+	e = v.run()
+
+	close(jobs)
+
+	wait.Wait()
+
+	if e != nil {
+		failure.add(v.report, e)
+	}
+
+	if failure.e != nil {
+		return failure.report, failure.e
+	}
+
+	return v.report, nil
+}
+
+// A verifier parses a stream for VerifyParallel, verifying control records
+// itself and submitting the checksums of data records to workers in jobs of
+// about verifyJobLen bytes.
+type verifier struct {
+	reader   io.Reader
+	hasher   hash.Hash32 // nil if checksums are not verified
+	jobs     chan<- []verifyCheck
+	failure  *verifyFailure
+	report   Report
+	prev     []byte
+	features uint32
+	batch    verifyCheck // pending, if in batches
+	batched  int
+	job      []verifyCheck
+	jobLen   int
+}
+
+// A verifyCheck is the verification of the checksum of a record, or of a
+// batch, as of whose first record report is the Report.
+type verifyCheck struct {
+	chunks   [][]byte
+	observed uint32
+	report   Report
+	batch    bool
+}
+
+// A verifyFailure is the earliest failure found by VerifyParallel.
+type verifyFailure struct {
+	mutex  sync.Mutex
+	failed atomic.Bool
+	report Report
+	e      error
+}
+
+func (v *verifier) run() (e error) {
+	// Parses records until the end of the stream or a failure, submitting
+	// the last job.
+
+	defer v.submit()
+
+	for !v.failure.failed.Load() {
+		e = v.next()
+		if errors.Is(e, io.EOF) {
+			return nil
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (v *verifier) next() (e error) {
+	// Parses one record.
+
+	var (
+		batch   = v.features&featureBatchChecksum != 0
+		body    []byte
+		check   verifyCheck
+		control bool
+		head    []byte
+		header  Header
+		trailer = make([]byte, crcLen)
+	)
+
+	header, head, e = v.readHeader()
+	switch {
+	case e != io.EOF:
+
+	case v.batched > 0:
+		e = fmt.Errorf("stream ended before checksum of last batch: %w",
+			io.ErrUnexpectedEOF,
+		)
+	}
+
+	if e != nil {
+		return
+	}
+
+	control = header.IsControl()
+
+	body = make([]byte, header.KeyLen+header.ValLen)
+
+	_, e = io.ReadFull(v.reader, body)
+	if e != nil {
+		return unexpectedEOF(e)
+	}
+
+	if header.Checksummed && (control || !batch) {
+		_, e = io.ReadFull(v.reader, trailer)
+		if e != nil {
+			return unexpectedEOF(e)
+		}
+	}
+
+	check = verifyCheck{
+		chunks:   [][]byte{body},
+		observed: binary.BigEndian.Uint32(trailer),
+		report:   v.report,
+	}
+
+	if batch || v.features&featureHeaderChecksum != 0 {
+		check.chunks = [][]byte{head, body}
+	}
+
+	switch {
+	case control:
+		if v.hasher != nil && header.Checksummed && !batch {
+			e = check.verify(v.hasher)
+			if e != nil {
+				return
+			}
+		}
+
+		return v.handleControl(body)
+
+	case batch:
+		if v.batched == 0 {
+			v.batch = verifyCheck{report: v.report, batch: true}
+		}
+
+		v.batch.chunks = append(v.batch.chunks, check.chunks...)
+		v.batched++
+
+	case header.Checksummed:
+		v.add(check)
+	}
+
+	v.count(header, body[:header.KeyLen])
+
+	return
+}
+
+func (v *verifier) readHeader() (header Header, head []byte, e error) {
+	// Reads the header of the next record. A stream that ends cleanly before
+	// the header yields io.EOF.
+
+	head = make([]byte, 2, 2+maxUintLen32)
+
+	_, e = io.ReadFull(v.reader, head)
+	if e != nil {
+		return
+	}
+
+	head = head[:2+int(head[0]>>(offsetX-8))]
+
+	if len(head) == 2 {
+		head = head[:2+maxUintLen32]
+	}
+
+	_, e = io.ReadFull(v.reader, head[2:])
+	if e != nil {
+		return header, head, unexpectedEOF(e)
+	}
+
+	header, e = ParseHeader(head)
+	if e != nil {
+		return
+	}
+
+	return
+}
+
+func (v *verifier) handleControl(val []byte) (e error) {
+	// Acts upon the control records that affect verification.
+
+	if len(val) == 0 {
+		return fmt.Errorf("control record kind missing")
+	}
+
+	switch controlKind(val[0]) {
+	case controlFeatures:
+		if len(val) != 5 {
+			return fmt.Errorf("malformed features control record")
+		}
+
+		v.features = binary.BigEndian.Uint32(val[1:])
+
+	case controlBatchChecksum:
+		if len(val) != 1+maxUintLen32 {
+			return fmt.Errorf("malformed batch checksum control record")
+		}
+
+		if v.batched == 0 {
+			v.batch = verifyCheck{report: v.report, batch: true}
+		}
+
+		v.batch.observed = binary.BigEndian.Uint32(val[1:])
+
+		v.add(v.batch)
+
+		v.batch = verifyCheck{}
+		v.batched = 0
+
+	case controlAbort:
+		return AbortedError{
+			Reason: string(val[1:]),
+		}
+	}
+
+	return
+}
+
+func (v *verifier) count(header Header, key []byte) {
+	// Adds a data record to the Report.
+
+	v.report.Records++
+
+	v.report.KeyBytes += int64(header.KeyLen)
+	v.report.ValBytes += int64(header.ValLen)
+
+	if len(key) == 0 {
+		v.report.EmptyKeys++
+	}
+
+	if v.report.Records > 1 && bytes.Compare(key, v.prev) <= 0 {
+		v.report.OutOfOrder++
+	}
+
+	v.report.XMetaValues[header.Meta]++
+
+	v.prev = key
+
+	return
+}
+
+func (v *verifier) add(check verifyCheck) {
+	// Adds a check to the pending job, submitting the job once it is large
+	// enough.
+
+	var (
+		chunk []byte
+	)
+
+	if v.hasher == nil {
+		return
+	}
+
+	v.job = append(v.job, check)
+
+	for _, chunk = range check.chunks {
+		v.jobLen += len(chunk)
+	}
+
+	if v.jobLen >= verifyJobLen {
+		v.submit()
+	}
+
+	return
+}
+
+func (v *verifier) submit() {
+	// Submits the pending job, if any, to the workers.
+
+	if len(v.job) == 0 {
+		return
+	}
+
+	v.jobs <- v.job
+
+	v.job = nil
+	v.jobLen = 0
+
+	return
+}
+
+func verifyJobs(jobs <-chan []verifyCheck, hasher hash.Hash32,
+	failure *verifyFailure,
+) {
+	// Verifies the checks of each job until the jobs are exhausted, noting
+	// the earliest failure.
+
+	var (
+		check verifyCheck
+		e     error
+		job   []verifyCheck
+	)
+
+	for job = range jobs {
+		for _, check = range job {
+			e = check.verify(hasher)
+			if e != nil {
+				failure.add(check.report, e)
+
+				break
+			}
+		}
+	}
+
+	return
+}
+
+func (c verifyCheck) verify(hasher hash.Hash32) (e error) {
+	var (
+		chunk []byte
+	)
+
+	hasher.Reset()
+
+	for _, chunk = range c.chunks {
+		_, e = hasher.Write(chunk)
+		if e != nil {
+			return
+		}
+	}
+
+	switch {
+	case hasher.Sum32() == c.observed:
+		return
+
+	case c.batch:
+		return fmt.Errorf("computed batch checksum does not match observed")
+	}
+
+	return fmt.Errorf("computed checksum does not match observed")
+}
+
+func (f *verifyFailure) add(report Report, e error) {
+	// Notes a failure as of the Report, unless an earlier one is noted.
+
+	f.mutex.Lock()
+
+	defer f.mutex.Unlock()
+
+	if f.e == nil || report.Records < f.report.Records {
+		f.report, f.e = report, e
+	}
+
+	f.failed.Store(true)
+
+	return
+}
@@ -0,0 +1,167 @@
+package bottledlightning
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		e      error
+		report Report
+
+		encoder = NewEncoder(&buffer, fnv.New32a())
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("b"), []byte("two")),
+	)
+
+	assert.NoError(t,
+		encoder.EncodeX([]byte("c"), []byte("three"), XMetaValue2),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte("a"), []byte("one")),
+	)
+
+	assert.NoError(t,
+		encoder.Encode([]byte{}, []byte{}),
+	)
+
+	report, e = Verify(
+		bytes.NewReader(buffer.Bytes()),
+		fnv.New32a(),
+	)
+
+	assert.NoError(t, e)
+
+	assert.Equal(t, 4, report.Records)
+	assert.Equal(t, int64(3), report.KeyBytes)
+	assert.Equal(t, int64(11), report.ValBytes)
+	assert.Equal(t, 1, report.EmptyKeys)
+	assert.Equal(t, 2, report.OutOfOrder)
+	assert.Equal(t, 3, report.XMetaValues[XMetaValue0])
+	assert.Equal(t, 1, report.XMetaValues[XMetaValue2])
+
+	buffer.Bytes()[5] ^= 1
+
+	report, e = Verify(
+		bytes.NewReader(buffer.Bytes()),
+		fnv.New32a(),
+	)
+
+	assert.Error(t, e)
+	assert.Equal(t, 0, report.Records)
+
+	report, e = Verify(
+		bytes.NewReader(buffer.Bytes()[:buffer.Len()-2]),
+		nil,
+	)
+
+	assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+	assert.Equal(t, 3, report.Records)
+
+	return
+}
+
+func TestVerifyParallel(t *testing.T) {
+	var (
+		e        error
+		expected Report
+		opts     []Option
+		report   Report
+		stream   []byte
+	)
+
+	for _, opts = range [][]Option{
+		nil,
+		{WithHeaderChecksum()},
+		{WithBatchChecksum(7)},
+	} {
+		stream = encodeVerifyTestStream(t, opts...)
+
+		expected, e = Verify(bytes.NewReader(stream), fnv.New32a())
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		report, e = VerifyParallel(bytes.NewReader(stream),
+			func() hash.Hash32 { return fnv.New32a() }, 4,
+		)
+		assert.NoError(t, e)
+		assert.Equal(t, expected, report)
+
+		stream[len(stream)/2] ^= 1
+
+		report, e = VerifyParallel(bytes.NewReader(stream),
+			func() hash.Hash32 { return fnv.New32a() }, 4,
+		)
+		assert.Error(t, e)
+		assert.Less(t, report.Records, expected.Records)
+		assert.Greater(t, report.Records, 0)
+
+		report, e = VerifyParallel(
+			bytes.NewReader(stream[:len(stream)-2]), nil, 0,
+		)
+		assert.ErrorIs(t, e, io.ErrUnexpectedEOF)
+		assert.LessOrEqual(t, report.Records, expected.Records)
+	}
+
+	return
+}
+
+func TestVerifyParallelEarliestFailure(t *testing.T) {
+	var (
+		e        error
+		expected Report
+		report   Report
+		stream   = encodeVerifyTestStream(t)
+	)
+
+	stream[len(stream)/3] ^= 1
+	stream[2*len(stream)/3] ^= 1
+
+	expected, e = Verify(bytes.NewReader(stream), fnv.New32a())
+	assert.Error(t, e)
+
+	report, e = VerifyParallel(bytes.NewReader(stream),
+		func() hash.Hash32 { return fnv.New32a() }, 8,
+	)
+	assert.Error(t, e)
+	assert.Equal(t, expected, report)
+
+	return
+}
+
+func encodeVerifyTestStream(t *testing.T, opts ...Option) []byte {
+	// Returns a stream of enough records to fill several jobs.
+
+	var (
+		buffer  bytes.Buffer
+		encoder = NewEncoder(&buffer, fnv.New32a(), opts...)
+		i       int
+		val     = bytes.Repeat([]byte("v"), 1000)
+	)
+
+	for i = 0; i < 5000; i++ {
+		assert.NoError(t,
+			encoder.EncodeX(fmt.Appendf(nil, "%08d", i), val,
+				XMetaValue(i%3),
+			),
+		)
+	}
+
+	assert.NoError(t,
+		encoder.Close(),
+	)
+
+	return buffer.Bytes()
+}